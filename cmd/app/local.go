@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"main/domain/entity"
+	"main/internal/config"
+	authUs "main/internal/usecase/auth"
+	"main/pkg/emailnorm"
+	"main/pkg/idgen"
+	"main/pkg/jwt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// localEnv is the env of local development: secrets missing from the config are generated for the lifetime of
+// the process, cookies are set without the Secure flag, services that cannot be reached are done without and a
+// dev user with an access token is printed. Only Postgres is still required.
+const localEnv = "local"
+
+// Credentials of the user created for local development.
+const (
+	devUsername = "dev"
+	devEmail    = "dev@localhost"
+	devPassword = "dev-password"
+)
+
+// applyLocalDefaults generates the secrets a local config leaves empty.
+func applyLocalDefaults(cfg *config.Config, logger *slog.Logger) error {
+	if cfg.JWTConfig.Secret == "" {
+		secret, err := randomSecret()
+		if err != nil {
+			return err
+		}
+		cfg.JWTConfig.Secret = secret
+		logger.Warn("Generated an ephemeral JWT secret, issued tokens do not survive a restart")
+	}
+	if cfg.PrivacyConfig.Mode && cfg.PrivacyConfig.FingerprintSalt == "" {
+		salt, err := randomSecret()
+		if err != nil {
+			return err
+		}
+		cfg.PrivacyConfig.FingerprintSalt = salt
+		logger.Warn("Generated an ephemeral fingerprint salt")
+	}
+	return nil
+}
+
+// randomSecret returns 32 random bytes encoded in base64.
+func randomSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// devUserRepo creates and finds the dev user.
+type devUserRepo interface {
+	GetUserByLogin(ctx context.Context, login, canonicalEmail string) (entity.User, error)
+	CreateUser(ctx context.Context, user entity.NewUser) (uuid.UUID, error)
+}
+
+// printDevToken creates the dev user on first start and prints its credentials and an access token.
+func printDevToken(ctx context.Context, repo devUserRepo, hasher authUs.PasswordHasher, emails emailnorm.Normalizer,
+	ids idgen.Generator, versions *authUs.TokenVersions, jwtManager *jwt.JWTManager, expirationMinutes int) error {
+	canonical := emails.Normalize(devEmail)
+	user, err := repo.GetUserByLogin(ctx, devUsername, canonical)
+	if errors.Is(err, pgx.ErrNoRows) {
+		hash, err := hasher.Hash(devPassword)
+		if err != nil {
+			return err
+		}
+		id, err := ids.NewID()
+		if err != nil {
+			return err
+		}
+		if _, err := repo.CreateUser(ctx, entity.NewUser{
+			ID:             id,
+			Email:          devEmail,
+			CanonicalEmail: canonical,
+			Username:       devUsername,
+			PasswordHash:   hash,
+		}); err != nil {
+			return err
+		}
+		user.ID = id
+	} else if err != nil {
+		return err
+	}
+
+	state, err := versions.Current(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	token, err := jwtManager.NewAccessToken(entity.AccessTokenClaims{
+		UserID:            user.ID,
+		ClientType:        entity.ClientTypeCLI,
+		TokenVersion:      state.Version,
+		PasswordTimestamp: state.PasswordTimestamp,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf(`
+Local development mode, never run it in production.
+  dev user:      %s / %s (id %s)
+  access token:  %s
+  (valid for %d minutes, log in as the dev user for a new one)
+
+`, devUsername, devPassword, user.ID, token, expirationMinutes)
+	return nil
+}
@@ -1,49 +1,197 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"errors"
+	"fmt"
 	"log/slog"
+	"main/domain/entity"
 	"main/internal/config"
+	grpcAdminHandler "main/internal/delivery/grpc/admin"
 	grpcAuthHandler "main/internal/delivery/grpc/auth"
+	"main/internal/delivery/grpc/extauthz"
 	"main/internal/delivery/grpc/interceptor"
+	"main/internal/delivery/grpc/mtls"
 	routes "main/internal/delivery/http"
+	httpAccountHandler "main/internal/delivery/http/account_handler"
+	httpAdminHandler "main/internal/delivery/http/admin_handler"
+	httpAdminUIHandler "main/internal/delivery/http/admin_ui_handler"
+	httpAuditHandler "main/internal/delivery/http/audit_handler"
 	httpAuthHandler "main/internal/delivery/http/auth_handler"
+	httpAuthzHandler "main/internal/delivery/http/authz_handler"
+	"main/internal/delivery/http/cookie"
+	httpEmailHandler "main/internal/delivery/http/email_handler"
+	httpFeatureHandler "main/internal/delivery/http/feature_handler"
+	httpHandleHandler "main/internal/delivery/http/handle_handler"
+	httpHealthHandler "main/internal/delivery/http/health_handler"
+	httpInviteHandler "main/internal/delivery/http/invite_handler"
+	httpOAuthHandler "main/internal/delivery/http/oauth_handler"
+	httpOrgHandler "main/internal/delivery/http/org_handler"
+	httpPasswordHandler "main/internal/delivery/http/password_handler"
+	httpPublicHandler "main/internal/delivery/http/public_handler"
+	httpStatsHandler "main/internal/delivery/http/stats_handler"
+	httpStatusHandler "main/internal/delivery/http/status_handler"
+	httpTermsHandler "main/internal/delivery/http/terms_handler"
+	httpVerificationHandler "main/internal/delivery/http/verification_handler"
 	"main/internal/metrics"
+	"main/internal/readonly"
+	"main/internal/secretage"
 	psql "main/internal/storage/postgres"
+	accountRepo "main/internal/storage/postgres/account"
+	auditRepo "main/internal/storage/postgres/audit"
 	authRepo "main/internal/storage/postgres/auth"
+	cleanupRepo "main/internal/storage/postgres/cleanup"
+	clientRepo "main/internal/storage/postgres/client"
+	dataKeyRepo "main/internal/storage/postgres/datakey"
+	featureRepo "main/internal/storage/postgres/feature"
+	handleRepo "main/internal/storage/postgres/handle"
+	inviteRepo "main/internal/storage/postgres/invite"
+	mfaRepo "main/internal/storage/postgres/mfa"
+	orgRepo "main/internal/storage/postgres/organization"
+	passkeyRepo "main/internal/storage/postgres/passkey"
+	passwordRepo "main/internal/storage/postgres/password"
+	phoneRepo "main/internal/storage/postgres/phone"
+	rbacRepo "main/internal/storage/postgres/rbac"
+	statsRepo "main/internal/storage/postgres/stats"
+	statusRepo "main/internal/storage/postgres/status"
+	termsRepo "main/internal/storage/postgres/terms"
+	verificationRepo "main/internal/storage/postgres/verification"
+	sessionRepo "main/internal/storage/redis/session"
+	"main/internal/tenant"
+	adminUs "main/internal/usecase/admin"
+	auditUs "main/internal/usecase/audit"
 	authUs "main/internal/usecase/auth"
+	cleanupUs "main/internal/usecase/cleanup"
+	featureUs "main/internal/usecase/feature"
+	handlesUs "main/internal/usecase/handles"
+	inviteUs "main/internal/usecase/invite"
+	oauthUs "main/internal/usecase/oauth"
+	orgUs "main/internal/usecase/organization"
+	rbacUs "main/internal/usecase/rbac"
+	statsUs "main/internal/usecase/stats"
+	statusUs "main/internal/usecase/status"
+	termsUs "main/internal/usecase/terms"
+	verificationUs "main/internal/usecase/verification"
+	"main/migrations"
+	"main/pkg/alert"
+	"main/pkg/attestation"
+	"main/pkg/captcha"
+	"main/pkg/devicetrust"
+	"main/pkg/disposable"
+	"main/pkg/dpop"
+	"main/pkg/emailnorm"
 	errHandler "main/pkg/error_handler"
+	"main/pkg/fingerprint"
+	"main/pkg/geoip"
+	"main/pkg/hibp"
+	"main/pkg/idgen"
 	"main/pkg/jwt"
+	"main/pkg/mailer"
+	"main/pkg/passhash"
 	pb "main/pkg/proto/gen/auth/v1"
+	"main/pkg/ratelimit"
+	"main/pkg/receipt"
+	"main/pkg/retry"
+	"main/pkg/revocation"
+	"main/pkg/s3"
+	"main/pkg/sms"
+	"main/pkg/tokenversion"
+	"main/pkg/webauthn"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"runtime"
+	"runtime/debug"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/bradfitz/gomemcache/memcache"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
 	"github.com/labstack/echo/v4"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/redis/go-redis/v9"
+	"github.com/soheilhy/cmux"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/reflection"
 )
 
+// Set at build time: go build -ldflags "-X main.version=1.4.0 -X main.commit=$(git rev-parse HEAD)"
+var (
+	version   = "dev"
+	commit    = ""
+	buildTime = ""
+)
+
 func main() {
 	cfg := config.LoadConfig()
 	logger := setupLogger(cfg.Env)
 	logger.Info("Application started", "env", cfg.Env)
+	local := cfg.Env == localEnv
+	if local {
+		if err := applyLocalDefaults(&cfg, logger); err != nil {
+			logger.Error("Failed to prepare local development mode", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// secrets referring to Vault or KMS, the references are kept to fetch rotated secrets
+	secretResolver, err := cfg.Secrets.SecretResolver()
+	if err != nil {
+		logger.Error("Invalid secret store configuration", "error", err)
+		os.Exit(1)
+	}
+	jwtSecretRef, dbPasswordRef := cfg.JWTConfig.Secret, cfg.PostgresConfig.Password
+	if err := cfg.ResolveSecrets(context.Background(), secretResolver); err != nil {
+		logger.Error("Failed to fetch secrets", "error", err)
+		os.Exit(1)
+	}
 
 	//prometheus metrics setup
 	reg := prometheus.NewRegistry()
-	metrics := metrics.NewMetrics(reg)
+	reg.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	instance := cfg.MetricsConfig.Instance
+	if instance == "" {
+		instance, _ = os.Hostname()
+	}
+	tenantLabel, endpointLabel := metricLabel(cfg.MetricsConfig.Labels.Tenant, metrics.LabelDrop),
+		metricLabel(cfg.MetricsConfig.Labels.Endpoint, metrics.LabelKeep)
+	if !tenantLabel.Valid() || !endpointLabel.Valid() {
+		logger.Error("Invalid metric label limits, modes are keep, hash or drop")
+		os.Exit(1)
+	}
+	metrics := metrics.NewMetrics(reg, metrics.Options{
+		Namespace:      cfg.MetricsConfig.Namespace,
+		Subsystem:      cfg.MetricsConfig.Subsystem,
+		RequestBuckets: cfg.MetricsConfig.RequestBuckets,
+		DBBuckets:      cfg.MetricsConfig.DBBuckets,
+		ConstLabels:    prometheus.Labels{"service": cfg.MetricsConfig.Service, "instance": instance},
+		SLO:            sloTargets(cfg.MetricsConfig.SLO),
+		EndpointLabel:  endpointLabel,
+		TenantLabel:    tenantLabel,
+	})
+
+	// read-only degradation, switched on by the schema check and by the write detector on the pool
+	readOnly := readonly.NewSwitch()
+	readOnlyDetector := readonly.NewDetector(readOnly, logger,
+		cfg.ReadOnlyConfig.WriteFailureThreshold, cfg.ReadOnlyConfig.CircuitCooldown)
 
 	//database connection setup
+	var dbPassword atomic.Value
+	dbPassword.Store(cfg.PostgresConfig.Password)
 	dsn := cfg.PostgresConfig.DSN()
-	pool, err := psql.NewPostgresConnection(dsn)
+	pool, err := psql.NewPostgresConnection(dsn, readOnlyDetector, func() string { return dbPassword.Load().(string) })
 	if err != nil {
 		logger.Error("Failed to connect to the database", "error", err)
 		os.Exit(1)
@@ -51,34 +199,572 @@ func main() {
 	defer pool.Close()
 	logger.Info("Connected to the database successfully")
 
-	//Redis client setup
-	redisClient := redis.NewClient(&redis.Options{
-		Addr:     cfg.RedisConfig.Addr,
-		Password: cfg.RedisConfig.Password,
-		DB:       cfg.RedisConfig.DB,
+	// a binary deployed before its migrations (or after a rollback) would fail on every query using the new schema
+	if cfg.SchemaCheck.OnMismatch != "ignore" {
+		status, err := psql.CheckSchema(context.Background(), pool, migrations.MigrationsFS, cfg.SchemaCheck.VersionTable)
+		if err == nil && !status.Compatible() {
+			err = fmt.Errorf("expected version %d, database is at %d (missing %v, unknown %v)",
+				status.Expected, status.Current, status.Missing, status.Unknown)
+		}
+		if err != nil {
+			if cfg.SchemaCheck.OnMismatch != "readonly" {
+				logger.Error("Database schema is not compatible", "error", err)
+				os.Exit(1)
+			}
+			logger.Warn("Database schema is not compatible, starting in read-only mode", "error", err)
+			readOnly.Enable("schema_mismatch")
+		}
+	}
+
+	//Redis client setup, small deployments can run without it
+	var redisClient *redis.Client
+	if cfg.RedisConfig.Enabled {
+		redisClient = redis.NewClient(&redis.Options{
+			Addr:     cfg.RedisConfig.Addr,
+			Password: cfg.RedisConfig.Password,
+			DB:       cfg.RedisConfig.DB,
+		})
+		defer redisClient.Close()
+
+		_, err = redisClient.Ping(context.Background()).Result()
+		switch {
+		case err != nil && local:
+			logger.Warn("Redis is not reachable, running without it", "error", err)
+			redisClient = nil
+		case err != nil:
+			logger.Error("Failed to connect to Redis", "error", err)
+			os.Exit(1)
+		default:
+			logger.Info("Connected to Redis successfully")
+		}
+	}
+	if local && redisClient == nil && cfg.RateLimiterConfig.Store == "redis" {
+		cfg.RateLimiterConfig.Store = "memory"
+	}
+
+	// rate limiter store, the window is the expiry of the counters and the interval the memory store is swept at
+	if cfg.RateLimiterConfig.Window <= 0 {
+		logger.Error("rate_limiter.window must be positive", "window", cfg.RateLimiterConfig.Window)
+		os.Exit(1)
+	}
+	var rateLimitStore ratelimit.Store
+	var memoryRateLimitStore *ratelimit.MemoryStore
+	switch cfg.RateLimiterConfig.Store {
+	case "redis":
+		if redisClient == nil {
+			logger.Error("The redis rate limiter store requires redis.enabled")
+			os.Exit(1)
+		}
+		rateLimitStore = ratelimit.NewRedisStore(redisClient)
+	case "memcached":
+		if len(cfg.RateLimiterConfig.MemcachedAddrs) == 0 {
+			logger.Error("The memcached rate limiter store requires rate_limiter.memcached_addrs")
+			os.Exit(1)
+		}
+		rateLimitStore = ratelimit.NewMemcachedStore(memcache.New(cfg.RateLimiterConfig.MemcachedAddrs...))
+	case "memory":
+		memoryRateLimitStore = ratelimit.NewMemoryStore(cfg.RateLimiterConfig.MemoryShards)
+		rateLimitStore = memoryRateLimitStore
+	default:
+		logger.Error("Unknown rate limiter store", "store", cfg.RateLimiterConfig.Store)
+		os.Exit(1)
+	}
+
+	//  Init Core Logic
+	var jwtOpts []jwt.Option
+	if cfg.JWTConfig.EncryptionKey != "" {
+		key, err := base64.StdEncoding.DecodeString(cfg.JWTConfig.EncryptionKey)
+		if err != nil || len(key) != 32 {
+			logger.Error("JWT encryption key must be 32 bytes encoded in base64")
+			os.Exit(1)
+		}
+		jwtOpts = append(jwtOpts, jwt.WithEncryption(key))
+	}
+	jwtOpts = append(jwtOpts, jwt.WithIssuer(strings.TrimSuffix(cfg.PublicConfig.Issuer, "/")))
+	if len(cfg.JWTConfig.Audience) > 0 {
+		jwtOpts = append(jwtOpts, jwt.WithAudience(cfg.JWTConfig.Audience...))
+	}
+	jwtManager := jwt.NewJWTManager(cfg.JWTConfig.Secret, cfg.JWTConfig.ExpirationMinutes, jwtOpts...)
+	var accessTokens accessTokenManager = jwtManager
+	if shadow := cfg.JWTConfig.Shadow; shadow.Enabled {
+		candidate, err := newShadowCandidate(cfg.JWTConfig)
+		if err != nil {
+			logger.Error("Invalid JWT shadow configuration", "error", err)
+			os.Exit(1)
+		}
+		accessTokens = jwt.NewShadowManager(jwtManager, candidate, shadow.SampleRate, logger.With("log", "token_shadow"),
+			func(outcome string) { metrics.TokenShadowVerifications.WithLabelValues(outcome).Inc() })
+		logger.Info("Shadow verification of access tokens enabled", "sample_rate", shadow.SampleRate)
+	}
+	tenants, err := tenant.NewResolver(cfg.PublicConfig.Issuer, tenantDomains(cfg.Tenants)...)
+	if err != nil {
+		logger.Error("Invalid tenant domains", "error", err)
+		os.Exit(1)
+	}
+	// emails, IP addresses and user agents are encrypted by the repositories, a nil cipher keeps them in plaintext
+	dataKeys := dataKeyRepo.NewDataKeyRepo(pool, metrics)
+	fieldCipher, err := cfg.PIIEncryption.FieldCipher(context.Background(), dataKeys)
+	if err != nil {
+		logger.Error("Failed to load the data keys of the PII encryption", "error", err)
+		os.Exit(1)
+	}
+	if fieldCipher != nil {
+		logger.Info("PII encryption enabled")
+	}
+	authRepository := authRepo.NewAuthRepo(pool, metrics, fieldCipher)
+	var sessionStore sessionRepository = authRepository
+	switch cfg.SessionConfig.Store {
+	case "redis":
+		if redisClient == nil {
+			logger.Error("The redis session store requires redis.enabled")
+			os.Exit(1)
+		}
+		sessionStore = sessionRepo.NewSessionRepo(redisClient, authRepository, metrics, fieldCipher)
+	case "postgres":
+	default:
+		logger.Error("Unknown session store", "store", cfg.SessionConfig.Store)
+		os.Exit(1)
+	}
+
+	var mail verificationUs.Mailer = mailer.NewLogMailer(logger)
+	var mailQueue *mailer.Queue
+	if cfg.MailerConfig.Host != "" {
+		mailQueue = newMailQueue(cfg.MailerConfig, logger, metrics)
+		mail = mailQueue
+	}
+
+	emails := emailnorm.Normalizer{CollapseGmail: cfg.EmailNormalization.CollapseGmail}
+	verificationRepository := verificationRepo.NewVerificationRepo(pool, metrics, fieldCipher)
+	verificationUsecase := verificationUs.NewVerificationUsecase(verificationRepository, authRepository, mail, logger,
+		cfg.EmailVerification.TokenTTL, cfg.EmailVerification.URL, emails)
+	sessionPolicies := authUs.SessionPolicies{
+		Default: authUs.SessionPolicy{
+			TTL:              cfg.SessionConfig.TTL,
+			MaxLifetime:      cfg.SessionConfig.MaxLifetime,
+			RotationInterval: cfg.SessionConfig.RotationInterval,
+			IPChange:         ipChangePolicy(cfg.SessionConfig.IPChange),
+			Attestation:      authUs.AttestationLevel(cfg.SessionConfig.Attestation),
+			Binding:          authUs.BindingMode(cfg.SessionConfig.Binding),
+		},
+		ByClientType: make(map[entity.ClientType]authUs.SessionPolicy),
+	}
+	for clientType, policy := range cfg.SessionConfig.Policies {
+		ipChange := sessionPolicies.Default.IPChange
+		if policy.IPChange.Action != "" {
+			ipChange = ipChangePolicy(policy.IPChange)
+		}
+		attestationLevel := sessionPolicies.Default.Attestation
+		if policy.Attestation != "" {
+			attestationLevel = authUs.AttestationLevel(policy.Attestation)
+		}
+		binding := sessionPolicies.Default.Binding
+		if policy.Binding != "" {
+			binding = authUs.BindingMode(policy.Binding)
+		}
+		sessionPolicies.ByClientType[entity.ClientType(clientType)] = authUs.SessionPolicy{
+			TTL:              policy.TTL,
+			MaxLifetime:      policy.MaxLifetime,
+			RotationInterval: policy.RotationInterval,
+			IPChange:         ipChange,
+			Attestation:      attestationLevel,
+			Binding:          binding,
+		}
+	}
+	for clientType, policy := range sessionPolicies.ByClientType {
+		if !policy.IPChange.Action.Valid() {
+			logger.Error("Invalid session IP change action", "client_type", clientType, "action", policy.IPChange.Action)
+			os.Exit(1)
+		}
+		if !policy.Attestation.Valid() {
+			logger.Error("Invalid session attestation level", "client_type", clientType, "attestation", policy.Attestation)
+			os.Exit(1)
+		}
+		if !policy.Binding.Valid() {
+			logger.Error("Invalid session binding mode", "client_type", clientType, "binding", policy.Binding)
+			os.Exit(1)
+		}
+	}
+	if !sessionPolicies.Default.IPChange.Action.Valid() {
+		logger.Error("Invalid session IP change action", "action", sessionPolicies.Default.IPChange.Action)
+		os.Exit(1)
+	}
+	if !sessionPolicies.Default.Attestation.Valid() {
+		logger.Error("Invalid session attestation level", "attestation", sessionPolicies.Default.Attestation)
+		os.Exit(1)
+	}
+	if !sessionPolicies.Default.Binding.Valid() {
+		logger.Error("Invalid session binding mode", "binding", sessionPolicies.Default.Binding)
+		os.Exit(1)
+	}
+	if cfg.PrivacyConfig.Mode && cfg.PrivacyConfig.FingerprintSalt == "" {
+		logger.Error("privacy.fingerprint_salt is required in privacy mode")
+		os.Exit(1)
+	}
+	passwordHasher, err := newPasswordHasher(cfg.PasswordHashing, cfg.PasswordHashing.Algorithm)
+	if err != nil {
+		logger.Error("Invalid password hashing config", "error", err)
+		os.Exit(1)
+	}
+	var fallbackHasher authUs.PasswordHasher
+	if cfg.PasswordHashing.Fallback != "" {
+		if fallbackHasher, err = newPasswordHasher(cfg.PasswordHashing, cfg.PasswordHashing.Fallback); err != nil {
+			logger.Error("Invalid password hashing config", "error", err)
+			os.Exit(1)
+		}
+	}
+	breachCheck, err := newBreachCheck(cfg.BreachCheck, logger)
+	if err != nil {
+		logger.Error("Invalid breach check config", "error", err)
+		os.Exit(1)
+	}
+	fingerprinter := fingerprint.NewHasher([]byte(cfg.PrivacyConfig.FingerprintSalt), cfg.PrivacyConfig.Mode)
+	var replayCache dpop.ReplayCache = dpop.NewMemoryReplayCache()
+	if redisClient != nil {
+		replayCache = dpop.NewRedisReplayCache(redisClient)
+	}
+	proofVerifier := dpop.NewVerifier(replayCache, cfg.DPoPConfig.ProofMaxAge)
+	// denied sessions and raised token states are broadcast to the other instances through Redis
+	var revocationBus *revocation.RedisBus
+	var denylistBroadcaster authUs.DenylistBroadcaster
+	var tokenStateBroadcaster authUs.TokenStateBroadcaster
+	if redisClient != nil && cfg.IncidentResponse.RevocationChannel != "" {
+		revocationBus = revocation.NewRedisBus(redisClient, cfg.IncidentResponse.RevocationChannel)
+		denylistBroadcaster = revocationBus
+		tokenStateBroadcaster = revocationBus
+	}
+	var tokenVersionCache authUs.TokenVersionCache = tokenversion.NewMemoryCache()
+	if redisClient != nil {
+		redisCache := tokenversion.NewRedisCache(redisClient)
+		tokenVersionCache = redisCache
+		// the broadcast evicts the states held in memory when they are raised
+		if revocationBus != nil {
+			tokenVersionCache = tokenversion.NewTieredCache(redisCache, cfg.TokenVersions.LocalTTL)
+		}
+	}
+	tokenVersions := authUs.NewTokenVersions(authRepository, tokenVersionCache, cfg.TokenVersions.CacheTTL, tokenStateBroadcaster)
+	sessionDenylist := authUs.NewSessionDenylist(authRepository, logger,
+		time.Duration(cfg.JWTConfig.ExpirationMinutes)*time.Minute, denylistBroadcaster)
+	if err := sessionDenylist.Load(context.Background()); err != nil {
+		logger.Error("Failed to load session denylist", "error", err)
+		os.Exit(1)
+	}
+	registrationPolicy := authUs.RegistrationPolicy{
+		Closed:         !cfg.Registration.Enabled,
+		InviteOnly:     cfg.Registration.InviteOnly,
+		AllowedDomains: cfg.Registration.AllowedDomains,
+	}
+	var disposableBlocklist *disposable.Blocklist
+	if cfg.Registration.BlockDisposable {
+		disposableBlocklist = disposable.NewBlocklist()
+		registrationPolicy.Blocklist = disposableBlocklist
+	}
+	var receiptSigner authUs.ReceiptSigner
+	if cfg.IssuanceReceipts.Enabled {
+		receiptSigner, err = receipt.NewSigner(cfg.IssuanceReceipts.SigningKey)
+		if err != nil {
+			logger.Error("Invalid issuance receipt config", "error", err)
+			os.Exit(1)
+		}
+	}
+	userIDs, err := idgen.New(cfg.IDConfig.UserIDStrategy)
+	if err != nil {
+		logger.Error("Invalid ID config", "error", err)
+		os.Exit(1)
+	}
+	termsRepository := termsRepo.NewTermsRepo(pool, metrics)
+	termsPolicy := authUs.TermsPolicy{
+		Store:             termsRepository,
+		RequireAcceptance: cfg.Terms.RequireAcceptance,
+	}
+	enumeration := authUs.NewEnumerationProtection(cfg.EnumerationProtection.Enabled, passwordHasher)
+	tenantReserved := make(map[string][]string)
+	for _, d := range cfg.Tenants.Domains {
+		tenantReserved[d.Host] = d.ReservedHandles
+	}
+	handleUsecase := handlesUs.NewHandleUsecase(handleRepo.NewHandleRepo(pool, metrics), logger,
+		append(slices.Clone(handlesUs.Reserved), cfg.Handles.Reserved...), tenantReserved)
+	// both stay nil interfaces while two-factor authentication is disabled
+	var (
+		secondFactor authUs.SecondFactor
+		mfaUsecase   httpAuthHandler.MFAUsecase
+	)
+	if cfg.MFA.Enabled {
+		var deviceSigner authUs.DeviceSigner
+		if cfg.MFA.TrustedDeviceTTL > 0 {
+			deviceSigner, err = devicetrust.NewSigner(cfg.MFA.TrustedDeviceKey)
+			if err != nil {
+				logger.Error("Invalid trusted device config", "error", err)
+				os.Exit(1)
+			}
+		}
+		mfa := authUs.NewMFAUsecase(mfaRepo.NewMFARepo(pool, metrics, fieldCipher), authRepository, mail, logger, authUs.MFAPolicy{
+			TTL:              cfg.MFA.TTL,
+			CodeLength:       cfg.MFA.CodeLength,
+			MaxAttempts:      cfg.MFA.MaxAttempts,
+			ResendCooldown:   cfg.MFA.ResendCooldown,
+			MaxSends:         cfg.MFA.MaxSends,
+			TrustedDeviceTTL: cfg.MFA.TrustedDeviceTTL,
+		}, deviceSigner)
+		secondFactor, mfaUsecase = mfa, mfa
+	}
+	riskPolicy := authUs.RiskPolicy{
+		Enabled:     cfg.LoginRisk.Enabled,
+		History:     cfg.LoginRisk.History,
+		StepUp:      authUs.RiskStepUp(cfg.LoginRisk.StepUp),
+		StepUpLevel: entity.RiskLevel(cfg.LoginRisk.StepUpLevel),
+	}
+	if !riskPolicy.StepUp.Valid() || (riskPolicy.StepUpLevel != entity.RiskMedium && riskPolicy.StepUpLevel != entity.RiskHigh) {
+		logger.Error("Invalid login risk step up", "step_up", riskPolicy.StepUp, "step_up_level", riskPolicy.StepUpLevel)
+		os.Exit(1)
+	}
+	if riskPolicy.StepUp != authUs.RiskStepUpNone && !cfg.MFA.Enabled {
+		logger.Error("login_risk.step_up requires mfa.enabled")
+		os.Exit(1)
+	}
+	geoPolicy, err := newGeoPolicy(cfg.GeoIP, cfg.LoginRisk.CountryDatabase)
+	if err != nil {
+		logger.Error("Invalid GeoIP configuration", "error", err)
+		os.Exit(1)
+	}
+	if geoPolicy.Locator != nil {
+		logger.Info("GeoIP database loaded", "blocked_countries", geoPolicy.BlockedCountries)
+	}
+	var attestationVerifier authUs.AttestationVerifier
+	if cfg.AppAttestation.Enabled {
+		verifier, err := newAttestationVerifier(cfg.AppAttestation)
+		if err != nil {
+			logger.Error("Invalid app attestation configuration", "error", err)
+			os.Exit(1)
+		}
+		attestationVerifier = verifier
+		logger.Info("App attestation enabled", "android", verifier.Android != nil, "ios", verifier.IOS != nil)
+	}
+	var captchaPolicy authUs.CaptchaPolicy
+	if cfg.Captcha.Provider != "" {
+		client, err := captcha.NewClient(captcha.Provider(cfg.Captcha.Provider), cfg.Captcha.Secret, cfg.Captcha.MinScore, cfg.Captcha.Timeout)
+		if err != nil {
+			logger.Error("Invalid captcha configuration", "error", err)
+			os.Exit(1)
+		}
+		captchaPolicy = authUs.CaptchaPolicy{
+			Verifier:   client,
+			Register:   cfg.Captcha.Register,
+			LoginAfter: cfg.Captcha.LoginAfterFailures,
+			Window:     cfg.Captcha.FailureWindow,
+			Failures:   rateLimitStore,
+		}
+		logger.Info("CAPTCHA enabled", "provider", cfg.Captcha.Provider, "register", cfg.Captcha.Register,
+			"login_after_failures", cfg.Captcha.LoginAfterFailures)
+	}
+	alertPolicy := authUs.AlertPolicy{
+		ReuseGrace:    cfg.Alerts.RefreshReuseGrace,
+		LockoutsAfter: cfg.Alerts.LockoutsAfter,
+		LockoutWindow: cfg.Alerts.LockoutWindow,
+		Lockouts:      rateLimitStore,
+		TravelWindow:  cfg.Alerts.TravelWindow,
+	}
+	var alertPublisher *alert.Publisher
+	if cfg.Alerts.Sink != "" {
+		sink, err := alert.NewSink(alert.Kind(cfg.Alerts.Sink), cfg.Alerts.URL, cfg.Alerts.Topic, cfg.Alerts.Secret, cfg.Alerts.Timeout)
+		if err != nil {
+			logger.Error("Invalid alerts configuration", "error", err)
+			os.Exit(1)
+		}
+		alertPublisher = alert.NewPublisher(sink, logger, cfg.Alerts.QueueSize)
+		alertPolicy.Publisher = alertPublisher
+		logger.Info("Security alerts enabled", "sink", cfg.Alerts.Sink)
+	}
+	var elevationPolicy authUs.ElevationPolicy
+	if cfg.AdminElevation.Enabled {
+		if cfg.AdminElevation.Duration <= 0 {
+			logger.Error("admin_elevation.duration must be positive")
+			os.Exit(1)
+		}
+		elevationPolicy = authUs.ElevationPolicy{
+			Roles:    cfg.AdminElevation.Roles,
+			TokenTTL: cfg.AdminElevation.TokenTTL,
+			Duration: cfg.AdminElevation.Duration,
+		}
+		logger.Info("Admin elevation enabled", "roles", cfg.AdminElevation.Roles, "duration", cfg.AdminElevation.Duration)
+	}
+	rbacRepository := rbacRepo.NewRBACRepo(pool, metrics)
+	var decisionLog rbacUs.DecisionLog
+	if dl := cfg.AuthzConfig.DecisionLog; dl.Enabled {
+		if dl.AllowSampleRate < 0 || dl.AllowSampleRate > 1 || dl.DenySampleRate < 0 || dl.DenySampleRate > 1 {
+			logger.Error("Authorization decision log sample rates must be between 0 and 1")
+			os.Exit(1)
+		}
+		decisionLog = rbacUs.DecisionLog{
+			Logger:          logger.With("log", "authz_decision"),
+			AllowSampleRate: dl.AllowSampleRate,
+			DenySampleRate:  dl.DenySampleRate,
+		}
+	}
+	rbacUsecase := rbacUs.NewRBACUsecase(rbacRepository, decisionLog)
+	auditLogger := auditUs.NewAuditLogger(auditRepo.NewAuditRepo(pool, metrics, fieldCipher), logger, fingerprinter)
+	featureUsecase := featureUs.NewFeatureUsecase(featureRepo.NewFeatureRepo(pool, metrics), auditLogger, logger, cfg.Features.CacheTTL)
+	rollout := authUs.FeatureRollout{Gate: featureUsecase, FallbackHasher: fallbackHasher}
+	authUsecase := authUs.NewAuthUsecase(authRepository, sessionStore, accessTokens, metrics, logger, verificationUsecase,
+		cfg.EmailVerification.Required, sessionPolicies, proofVerifier, fingerprinter, passwordHasher, breachCheck,
+		registrationPolicy, termsPolicy, emails, receiptSigner, userIDs, tokenVersions, secondFactor,
+		authUs.RefreshCanary{Enabled: cfg.SessionConfig.Canary.Enabled, Action: authUs.CanaryAction(cfg.SessionConfig.Canary.Action)},
+		enumeration, riskPolicy, handleUsecase, auditLogger, attestationVerifier, captchaPolicy, geoPolicy,
+		rbacUsecase, sessionDenylist, alertPolicy, elevationPolicy, rollout)
+	var phoneUsecase httpAuthHandler.PhoneUsecase
+	if cfg.PhoneOTP.Enabled {
+		smsSender, err := newSMSSender(cfg.SMSConfig, logger)
+		if err != nil {
+			logger.Error("Invalid SMS config", "error", err)
+			os.Exit(1)
+		}
+		phoneUsecase = authUs.NewPhoneUsecase(phoneRepo.NewPhoneRepo(pool, metrics, fieldCipher), authUsecase, smsSender, logger,
+			authUs.PhoneOTPPolicy{
+				TTL:            cfg.PhoneOTP.TTL,
+				CodeLength:     cfg.PhoneOTP.CodeLength,
+				MaxAttempts:    cfg.PhoneOTP.MaxAttempts,
+				ResendCooldown: cfg.PhoneOTP.ResendCooldown,
+			})
+	}
+	var passkeyUsecase httpAuthHandler.PasskeyUsecase
+	if cfg.Passkeys.Enabled {
+		if uv := cfg.Passkeys.UserVerification; uv != webauthn.VerificationRequired && uv != webauthn.VerificationPreferred {
+			logger.Error("Invalid passkeys config", "error", fmt.Sprintf("unknown user_verification %q", uv))
+			os.Exit(1)
+		}
+		passkeyUsecase = authUs.NewPasskeyUsecase(passkeyRepo.NewPasskeyRepo(pool, metrics), authRepository, authUsecase,
+			&webauthn.RelyingParty{
+				ID:               cfg.Passkeys.RPID,
+				Name:             cfg.Passkeys.RPName,
+				Origins:          cfg.Passkeys.Origins,
+				UserVerification: cfg.Passkeys.UserVerification,
+				Timeout:          cfg.Passkeys.Timeout,
+			}, logger, cfg.Passkeys.MaxPerUser, rollout)
+	}
+	passwordRepository := passwordRepo.NewPasswordRepo(pool, metrics)
+	// stays a nil interface unless resets may log the user in
+	var resetSessions authUs.SessionStarter
+	if cfg.PasswordReset.StartSession {
+		resetSessions = authUsecase
+	}
+	passwordUsecase := authUs.NewPasswordUsecase(passwordRepository, authRepository, mail, logger,
+		cfg.PasswordReset.TokenTTL, cfg.PasswordReset.URL, passwordHasher, breachCheck, emails, enumeration, resetSessions, auditLogger,
+		tokenVersions)
+	emailUsecase := authUs.NewEmailUsecase(verificationRepository, authRepository, mail, logger,
+		cfg.EmailChange.TokenTTL, cfg.EmailChange.URL, emails, handleUsecase)
+	accountRepository := accountRepo.NewAccountRepo(pool, metrics, fieldCipher)
+	accountUsecase := authUs.NewAccountUsecase(accountRepository, authRepository, sessionStore, logger, cfg.AccountDeletion.GracePeriod,
+		cfg.Passkeys.Enabled, cfg.MFA.Enabled, handleUsecase)
+	metadataUsecase := authUs.NewMetadataUsecase(accountRepository, authUs.MetadataLimits{
+		MaxBytes: cfg.UserMetadata.MaxBytes,
+		MaxKeys:  cfg.UserMetadata.MaxKeys,
+	})
+	// stays a nil interface unless token lists can be read from an object store
+	var tokenLists adminUs.ObjectStore
+	if s3cfg := cfg.IncidentResponse.S3; s3cfg.Region != "" {
+		tokenLists = s3.NewClient(s3cfg.Endpoint, s3cfg.Region, s3.Credentials{
+			AccessKeyID:     s3cfg.AccessKeyID,
+			SecretAccessKey: s3cfg.SecretAccessKey,
+			SessionToken:    s3cfg.SessionToken,
+		})
+	}
+	adminUsecase := adminUs.NewAdminUsecase(accountRepository, passwordUsecase, tokenVersions, logger, auditLogger,
+		sessionStore, sessionDenylist, authUsecase, tokenLists, mail)
+	importUsecase := authUs.NewImportUsecase(accountRepository, logger, emails, userIDs)
+	inviteRepository := inviteRepo.NewInviteRepo(pool, metrics)
+	inviteUsecase := inviteUs.NewInviteUsecase(inviteRepository, logger, cfg.Registration.InviteDefaultTTL, cfg.Registration.InviteMaxTTL)
+	orgRepository := orgRepo.NewOrgRepo(pool, metrics)
+	orgUsecase := orgUs.NewOrgUsecase(orgRepository, tokenVersions, sessionStore, logger, cfg.Organizations.PurgeDelay)
+	termsUsecase := termsUs.NewTermsUsecase(termsRepository, logger)
+	clientRepository := clientRepo.NewClientRepo(pool, metrics)
+	oauthUsecase := oauthUs.NewOAuthUsecase(clientRepository, jwtManager, oauthUs.KeyDistribution{
+		Enabled:   cfg.JWTConfig.KeyDistribution.Enabled,
+		Scope:     cfg.JWTConfig.KeyDistribution.Scope,
+		RefreshIn: cfg.JWTConfig.KeyDistribution.RefreshInterval,
 	})
-	defer redisClient.Close()
 
-	_, err = redisClient.Ping(context.Background()).Result()
+	secrets, err := trackedSecrets(cfg)
 	if err != nil {
-		logger.Error("Failed to connect to Redis", "error", err)
+		logger.Error("Invalid secret rotation config", "error", err)
 		os.Exit(1)
 	}
-	logger.Info("Connected to Redis successfully")
+	secretMonitor := secretage.NewMonitor(metrics, logger, cfg.SecretRotation.MaxAge, cfg.SecretRotation.WarnBefore, secrets...)
 
-	//  Init Core Logic
-	jwtManager := jwt.NewJWTManager(cfg.JWTConfig.Secret, cfg.JWTConfig.ExpirationMinutes)
-	authRepository := authRepo.NewAuthRepo(pool, metrics)
-	authUsecase := authUs.NewAuthUsecase(authRepository, jwtManager, metrics)
+	refreshCookie, err := cookie.NewRefreshToken(cookie.Options{
+		Name:     cfg.CookieConfig.Name,
+		Domain:   cfg.CookieConfig.Domain,
+		Path:     cfg.CookieConfig.Path,
+		SameSite: cfg.CookieConfig.SameSite,
+		Secure:   cfg.CookieConfig.Secure,
+	})
+	if err != nil {
+		logger.Error("Invalid cookie configuration", "error", err)
+		os.Exit(1)
+	}
 
 	// Init Handlers
-	httpHandler := httpAuthHandler.NewAuthHandler(authUsecase, metrics)
+	httpHandler := httpAuthHandler.NewAuthHandler(authUsecase, phoneUsecase, passkeyUsecase, mfaUsecase, metrics, refreshCookie)
+	oauthHandler := httpOAuthHandler.NewOAuthHandler(oauthUsecase)
+	authzHandler := httpAuthzHandler.NewAuthzHandler(authUsecase, oauthUsecase, rbacUsecase, cfg.AuthzConfig.CacheMaxAge)
+	verificationHandler := httpVerificationHandler.NewVerificationHandler(verificationUsecase)
+	passwordHandler := httpPasswordHandler.NewPasswordHandler(passwordUsecase, refreshCookie)
+	emailHandler := httpEmailHandler.NewEmailHandler(emailUsecase)
+	accountHandler := httpAccountHandler.NewAccountHandler(accountUsecase, metadataUsecase, refreshCookie)
+	adminHandler := httpAdminHandler.NewAdminHandler(adminUsecase, importUsecase)
+	inviteHandler := httpInviteHandler.NewInviteHandler(inviteUsecase)
+	orgHandler := httpOrgHandler.NewOrgHandler(orgUsecase)
+	termsHandler := httpTermsHandler.NewTermsHandler(termsUsecase)
+	handleHandler := httpHandleHandler.NewHandleHandler(handleUsecase)
+	auditHandler := httpAuditHandler.NewAuditHandler(auditLogger)
+	var adminUIHandler *httpAdminUIHandler.AdminUIHandler
+	if cfg.AdminUI.Enabled {
+		adminUIHandler = httpAdminUIHandler.NewAdminUIHandler(httpAdminUIHandler.Limits{
+			RateLimit:             cfg.RateLimiterConfig.Limit,
+			RateLimitWindow:       cfg.RateLimiterConfig.Window.String(),
+			RateLimitStore:        cfg.RateLimiterConfig.Store,
+			AccessTokenTTL:        (time.Duration(cfg.JWTConfig.ExpirationMinutes) * time.Minute).String(),
+			SessionTTL:            cfg.SessionConfig.TTL.String(),
+			RegistrationOpen:      cfg.Registration.Enabled,
+			InviteOnly:            cfg.Registration.InviteOnly,
+			PhoneOTPEnabled:       cfg.PhoneOTP.Enabled,
+			PhoneOTPMaxAttempts:   cfg.PhoneOTP.MaxAttempts,
+			AccountDeletionPeriod: cfg.AccountDeletion.GracePeriod.String(),
+		})
+	}
+	publicHandler, err := httpPublicHandler.NewPublicHandler(tenants.Issuers(), dpop.SigningAlgorithms(), cfg.PublicConfig.CacheMaxAge)
+	if err != nil {
+		logger.Error("Failed to render public documents", "error", err)
+		os.Exit(1)
+	}
+	healthChecks := map[string]httpHealthHandler.Check{
+		"postgres": pool.Ping,
+	}
+	if redisClient != nil {
+		healthChecks["redis"] = func(ctx context.Context) error {
+			return redisClient.Ping(ctx).Err()
+		}
+	}
+	healthHandler := httpHealthHandler.NewHealthHandler(readOnly, healthChecks, buildInfo())
+	statusChecks := make(map[string]statusUs.Check, len(healthChecks))
+	for name, check := range healthChecks {
+		statusChecks[name] = statusUs.Check(check)
+	}
+	statusUsecase := statusUs.NewStatusUsecase(statusRepo.NewStatusRepo(pool, metrics), readOnly, statusChecks, auditLogger, logger,
+		statusUs.StatusPolicy{CacheTTL: cfg.StatusPage.CacheTTL, RecentWindow: cfg.StatusPage.RecentWindow})
+	statusHandler := httpStatusHandler.NewStatusHandler(statusUsecase, cfg.StatusPage.CacheTTL)
+	statsUsecase := statsUs.NewStatsUsecase(statsRepo.NewStatsRepo(pool, metrics), sessionStore, sessionDenylist)
+	statsHandler := httpStatsHandler.NewStatsHandler(statsUsecase)
+	featureHandler := httpFeatureHandler.NewFeatureHandler(featureUsecase)
 	grpcHandler := grpcAuthHandler.NewAuthHandler(logger, authUsecase)
+	adminRPCHandler := grpcAdminHandler.NewAdminHandler(logger, adminUsecase, orgUsecase, statsUsecase)
+	extAuthzServer := extauthz.NewServer(logger, authUsecase)
 
 	//  HTTP Server Setup (Echo)
 	e := echo.New()
 	e.HTTPErrorHandler = errHandler.HandleError
-	routes.MapRoutes(e, httpHandler, authUsecase, logger, cfg.RateLimiterConfig, metrics, redisClient)
+	if local {
+		e.Use(routes.InsecureCookiesMiddleware())
+	}
+	routes.MapRoutes(e, httpHandler, oauthHandler, authzHandler, verificationHandler, passwordHandler, emailHandler, accountHandler, healthHandler, adminHandler, publicHandler, inviteHandler, orgHandler, termsHandler, handleHandler, auditHandler, statusHandler, statsHandler, featureHandler, adminUIHandler, authUsecase, rbacUsecase, readOnly, logger, cfg.RateLimiterConfig, cfg.CORSConfig, metrics, reg, rateLimitStore, fingerprinter, tenants, cfg.AdminElevation.Enabled)
 
 	// http.Server configuration with timeouts for better resource management and security
 	httpAddr := net.JoinHostPort(cfg.Server.Host, strconv.Itoa(cfg.Server.Port))
@@ -89,57 +775,242 @@ func main() {
 		WriteTimeout: cfg.Server.Timeout,
 		IdleTimeout:  cfg.Server.IdleTimeout,
 	}
+	httpServer.TLSConfig, err = httpTLSConfig(cfg)
+	if err != nil {
+		logger.Error("Failed to load HTTP TLS certificates", "error", err)
+		os.Exit(1)
+	}
+	if httpServer.TLSConfig != nil && cfg.Server.Multiplex {
+		logger.Error("HTTPS is not supported with multiplexing, terminate TLS in front of the service")
+		os.Exit(1)
+	}
 
 	// gRPC Server Setup
 	grpcAddr := net.JoinHostPort(cfg.GrpcServer.Host, strconv.Itoa(cfg.GrpcServer.Port))
 	//
 	//
 	//setup gRPC server with interceptors
-	grpcServer := grpc.NewServer(
+	grpcOpts := []grpc.ServerOption{
 		grpc.ChainUnaryInterceptor(
 			interceptor.RecoveryInterceptor(logger),
+			interceptor.RetryHintInterceptor(),
+			interceptor.RequestInfoInterceptor(),
 			interceptor.LoggingInterceptor(logger),
-			interceptor.AuthInterceptor(jwtManager),
-		))
+			interceptor.ReadOnlyInterceptor(readOnly),
+			interceptor.ClientIdentityInterceptor(cfg.GrpcServer.TLS.AllowedClients),
+			interceptor.AuthInterceptor(authUsecase, accessTokens, cfg.AdminElevation.Enabled),
+			interceptor.PermissionInterceptor(rbacUsecase),
+		),
+	}
+	if cfg.GrpcServer.TLS.Enabled {
+		// cmux has to read the plaintext HTTP/2 headers to route gRPC, which TLS hides
+		if cfg.Server.Multiplex {
+			logger.Error("gRPC TLS cannot be combined with server.multiplex")
+			os.Exit(1)
+		}
+		creds, err := mtls.ServerCredentials(cfg.GrpcServer.TLS)
+		if err != nil {
+			logger.Error("Failed to setup gRPC TLS", "error", err)
+			os.Exit(1)
+		}
+		grpcOpts = append(grpcOpts, grpc.Creds(creds))
+		logger.Info("gRPC TLS enabled", slog.Bool("mtls", cfg.GrpcServer.TLS.ClientCAFile != ""))
+	}
+	grpcServer := grpc.NewServer(grpcOpts...)
 
 	pb.RegisterAuthServiceServer(grpcServer, grpcHandler)
+	pb.RegisterAdminServiceServer(grpcServer, adminRPCHandler)
+	// Envoy ext_authz, lets the gateway delegate request authentication to this service
+	authv3.RegisterAuthorizationServer(grpcServer, extAuthzServer)
 	// reflection for gRPC debugging tools (Postman/BloomRPC) - only in non-production environments
 	if cfg.Env != "production" {
 		reflection.Register(grpcServer)
 	}
 
+	if local {
+		if err := printDevToken(context.Background(), authRepository, passwordHasher, emails, userIDs, tokenVersions,
+			jwtManager, cfg.JWTConfig.ExpirationMinutes); err != nil {
+			logger.Warn("Failed to prepare the dev user, are the migrations applied?", "error", err)
+		}
+	}
+
 	//  Graceful Shutdown Setup
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
 
 	g, gCtx := errgroup.WithContext(ctx)
 
-	//setup gRPC server in separate goroutine
-	g.Go(func() error {
-		lis, err := net.Listen("tcp", grpcAddr)
+	// root listener shared by HTTP and gRPC when multiplexing is enabled, nil otherwise
+	var (
+		muxListener net.Listener
+		mux         cmux.CMux
+	)
+
+	if cfg.Server.Multiplex {
+		muxListener, err = net.Listen("tcp", httpAddr)
 		if err != nil {
-			return errors.New("failed to listen gRPC: " + err.Error())
+			logger.Error("Failed to listen multiplexed port", "error", err)
+			os.Exit(1)
 		}
-		logger.Info("gRPC server started", slog.String("addr", grpcAddr))
+		mux = cmux.New(muxListener)
 
-		if err := grpcServer.Serve(lis); err != nil {
-			return errors.New("gRPC server failed: " + err.Error())
-		}
+		// gRPC clients (grpc-go, grpc-java) wait for SETTINGS before sending headers,
+		// so the matcher has to write them back while sniffing the content-type.
+		grpcListener := mux.MatchWithWriters(cmux.HTTP2MatchHeaderFieldSendSettings("content-type", "application/grpc"))
+		httpListener := mux.Match(cmux.Any())
+
+		g.Go(func() error {
+			logger.Info("gRPC server started", slog.String("addr", httpAddr), slog.Bool("multiplexed", true))
+			if err := grpcServer.Serve(grpcListener); err != nil && !errors.Is(err, cmux.ErrServerClosed) {
+				return errors.New("gRPC server failed: " + err.Error())
+			}
+			return nil
+		})
+
+		g.Go(func() error {
+			logger.Info("HTTP server started", slog.String("addr", httpAddr), slog.Bool("multiplexed", true))
+			if err := httpServer.Serve(httpListener); err != nil {
+				if errors.Is(err, http.ErrServerClosed) || errors.Is(err, cmux.ErrServerClosed) {
+					return nil
+				}
+				return errors.New("HTTP server failed: " + err.Error())
+			}
+			return nil
+		})
+
+		g.Go(func() error {
+			if err := mux.Serve(); err != nil && !errors.Is(err, net.ErrClosed) {
+				return errors.New("multiplexer failed: " + err.Error())
+			}
+			return nil
+		})
+	} else {
+		//setup gRPC server in separate goroutine
+		g.Go(func() error {
+			lis, err := net.Listen("tcp", grpcAddr)
+			if err != nil {
+				return errors.New("failed to listen gRPC: " + err.Error())
+			}
+			logger.Info("gRPC server started", slog.String("addr", grpcAddr))
+
+			if err := grpcServer.Serve(lis); err != nil {
+				return errors.New("gRPC server failed: " + err.Error())
+			}
+			return nil
+		})
+
+		//setup HTTP server in separate goroutine
+		g.Go(func() error {
+			logger.Info("HTTP server started", slog.String("addr", httpAddr))
+			if err := e.StartServer(httpServer); err != nil {
+				if errors.Is(err, http.ErrServerClosed) {
+					return nil
+				}
+				return errors.New("HTTP server failed: " + err.Error())
+			}
+			return nil
+		})
+	}
+
+	// leaves read-only mode once the database accepts writes again
+	g.Go(func() error {
+		readOnlyDetector.Run(gCtx, pool, cfg.ReadOnlyConfig.ProbeInterval)
+		return nil
+	})
+	g.Go(func() error {
+		secretMonitor.Run(gCtx, cfg.SecretRotation.CheckInterval)
+		return nil
+	})
+	g.Go(func() error {
+		secretResolver.Watch(gCtx, logger, "jwt_secret", jwtSecretRef, cfg.JWTConfig.Secret, cfg.Secrets.RefreshInterval,
+			func(secret string) { jwtManager.RotateSecret(secret, cfg.Secrets.RotationGrace) })
+		return nil
+	})
+	g.Go(func() error {
+		secretResolver.Watch(gCtx, logger, "database_password", dbPasswordRef, cfg.PostgresConfig.Password,
+			cfg.Secrets.RefreshInterval, func(password string) { dbPassword.Store(password) })
+		return nil
+	})
+	// picks up the data keys added by a rotation
+	g.Go(func() error {
+		fieldCipher.Watch(gCtx, logger, dataKeys, cfg.PIIEncryption.KeyReload)
 		return nil
 	})
 
-	//setup HTTP server in separate goroutine
+	// picks up the sessions denied on other instances
 	g.Go(func() error {
-		logger.Info("HTTP server started", slog.String("addr", httpAddr))
-		if err := e.StartServer(httpServer); err != nil {
-			if errors.Is(err, http.ErrServerClosed) {
-				return nil
-			}
-			return errors.New("HTTP server failed: " + err.Error())
-		}
+		sessionDenylist.Run(gCtx, cfg.IncidentResponse.DenylistReload)
+		return nil
+	})
+
+	// applies the sessions denied and the token states raised on other instances as soon as they are broadcast
+	if revocationBus != nil {
+		g.Go(func() error {
+			revocationBus.Run(gCtx, logger, sessionDenylist.Apply, tokenVersions.Evict)
+			return nil
+		})
+	}
+
+	// sends the queued emails, and the ones still queued at shutdown
+	if mailQueue != nil {
+		g.Go(func() error {
+			mailQueue.Run(gCtx)
+			return nil
+		})
+	}
+
+	// sends the security alerts to the sink
+	if alertPublisher != nil {
+		g.Go(func() error {
+			alertPublisher.Run(gCtx)
+			return nil
+		})
+	}
+
+	// purges accounts whose deletion grace period is over, stops with the servers
+	g.Go(func() error {
+		accountUsecase.RunPurgeJob(gCtx, cfg.AccountDeletion.PurgeInterval, cfg.AccountDeletion.DryRun)
+		return nil
+	})
+
+	// purges organizations whose purge delay is over, stops with the servers
+	g.Go(func() error {
+		orgUsecase.RunPurgeJob(gCtx, cfg.Organizations.PurgeInterval, cfg.Organizations.DryRun)
 		return nil
 	})
 
+	// deletes expired sessions and old login attempts, stops with the servers
+	if cfg.Cleanup.Enabled {
+		cleanupUsecase := cleanupUs.NewCleanupUsecase(cleanupRepo.NewCleanupRepo(pool, metrics), metrics, logger,
+			cleanupUs.CleanupPolicy{
+				BatchSize:             cfg.Cleanup.BatchSize,
+				SessionRetention:      cfg.Cleanup.SessionRetention,
+				LoginHistoryRetention: cfg.Cleanup.LoginHistoryRetention,
+				SessionLimits:         sessionLimits(sessionPolicies),
+				DryRun:                cfg.Cleanup.DryRun,
+			})
+		g.Go(func() error {
+			cleanupUsecase.Run(gCtx, cfg.Cleanup.Interval)
+			return nil
+		})
+	}
+
+	// drops expired in-process rate limit counters
+	if memoryRateLimitStore != nil {
+		g.Go(func() error {
+			memoryRateLimitStore.Run(gCtx, cfg.RateLimiterConfig.Window)
+			return nil
+		})
+	}
+
+	// reloads the additional disposable email domains
+	if disposableBlocklist != nil && cfg.Registration.DisposableEmail.Source != "" {
+		g.Go(func() error {
+			disposableBlocklist.Run(gCtx, cfg.Registration.DisposableEmail.Source, cfg.Registration.DisposableEmail.RefreshInterval, logger)
+			return nil
+		})
+	}
+
 	// --- Graceful Shutdown ---
 	g.Go(func() error {
 		<-gCtx.Done()
@@ -177,6 +1048,12 @@ func main() {
 			grpcServer.Stop()
 		}
 
+		// stop accepting on the shared port and unblock mux.Serve
+		if mux != nil {
+			mux.Close()
+			muxListener.Close()
+		}
+
 		return nil
 	})
 
@@ -187,6 +1064,342 @@ func main() {
 	}
 }
 
+// buildInfo describes the running binary, the commit comes from the VCS stamp of go build when version is not set.
+func buildInfo() httpHealthHandler.BuildInfo {
+	info := httpHealthHandler.BuildInfo{Version: version, Commit: commit, BuildTime: buildTime, GoVersion: runtime.Version()}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	for _, s := range bi.Settings {
+		switch {
+		case s.Key == "vcs.revision" && info.Commit == "":
+			info.Commit = s.Value
+		case s.Key == "vcs.time" && info.BuildTime == "":
+			info.BuildTime = s.Value
+		}
+	}
+	return info
+}
+
+// newPasswordHasher returns the hasher of the algorithm with the configured parameters.
+func newPasswordHasher(cfg config.PasswordHashing, algorithm string) (authUs.PasswordHasher, error) {
+	switch passhash.Algorithm(algorithm) {
+	case passhash.Bcrypt:
+		return passhash.NewBcryptHasher(cfg.BcryptCost)
+	case passhash.Argon2id:
+		return passhash.NewArgon2idHasher(cfg.Argon2Memory, cfg.Argon2Iterations, cfg.Argon2Parallelism)
+	}
+	return nil, fmt.Errorf("unknown password hash algorithm %q", algorithm)
+}
+
+// sloTargets converts the configured SLO targets, route overrides without a latency keep the default one.
+func sloTargets(cfg config.SLOConfig) metrics.SLOTargets {
+	targets := metrics.SLOTargets{
+		Default: metrics.SLOTarget{Objective: cfg.Objective, Latency: cfg.Latency},
+		ByRoute: make(map[string]metrics.SLOTarget, len(cfg.Routes)),
+	}
+	for route, target := range cfg.Routes {
+		if target.Latency == 0 {
+			target.Latency = cfg.Latency
+		}
+		targets.ByRoute[route] = metrics.SLOTarget{Objective: target.Objective, Latency: target.Latency}
+	}
+	return targets
+}
+
+// newBreachCheck returns the breached password policy of the configured mode.
+func newBreachCheck(cfg config.BreachCheck, logger *slog.Logger) (authUs.BreachCheck, error) {
+	var check authUs.BreachCheck
+	switch cfg.Mode {
+	case "off":
+		return check, nil
+	case "warn":
+	case "strict":
+		check.Strict = true
+	default:
+		return check, fmt.Errorf("unknown breach check mode %q", cfg.Mode)
+	}
+	if cfg.RangeDir != "" {
+		check.Checker = hibp.NewOfflineClient(cfg.RangeDir)
+	} else {
+		check.Checker = hibp.NewClient(cfg.APIURL, cfg.Timeout, cfg.CacheTTL, cfg.CacheSize, cfg.Attempts, logger)
+	}
+	return check, nil
+}
+
+// newMailQueue returns the queue sending emails through the SMTP relay, and through the fallback relay when
+// one is configured.
+func newMailQueue(cfg config.MailerConfig, logger *slog.Logger, m *metrics.Metrics) *mailer.Queue {
+	providers := []mailer.Provider{{
+		Name:   "primary",
+		Sender: mailer.NewSMTPMailer(cfg.Host, cfg.Port, cfg.Username, cfg.Password, cfg.From, cfg.Timeout),
+	}}
+	if fb := cfg.Fallback; fb.Host != "" {
+		from := fb.From
+		if from == "" {
+			from = cfg.From
+		}
+		providers = append(providers, mailer.Provider{
+			Name:   "fallback",
+			Sender: mailer.NewSMTPMailer(fb.Host, fb.Port, fb.Username, fb.Password, from, cfg.Timeout),
+		})
+	}
+	policy := mailer.QueuePolicy{
+		Size:             cfg.Queue.Size,
+		Rate:             cfg.Queue.Rate,
+		Burst:            cfg.Queue.Burst,
+		Retry:            retry.Policy{Attempts: cfg.Queue.Attempts, Backoff: cfg.Queue.Backoff},
+		FailoverCooldown: cfg.Queue.FailoverCooldown,
+	}
+	return mailer.NewQueue(providers, policy, logger.With("log", "mail_queue"), func(provider, outcome string) {
+		m.EmailDeliveries.WithLabelValues(provider, outcome).Inc()
+	})
+}
+
+// ipChangePolicy converts the configured IP change policy of sessions.
+func ipChangePolicy(cfg config.IPChangePolicy) authUs.IPChangePolicy {
+	return authUs.IPChangePolicy{
+		Action:              authUs.IPChangeAction(cfg.Action),
+		TolerateSameNetwork: cfg.TolerateSameNetwork,
+	}
+}
+
+// sessionLimits converts the session policies to the limits enforced by the cleanup job, the default policy
+// covers every client type without its own.
+func sessionLimits(policies authUs.SessionPolicies) []cleanupUs.SessionLimit {
+	limits := make([]cleanupUs.SessionLimit, 0, len(policies.ByClientType)+1)
+	others := make([]string, 0, len(policies.ByClientType))
+	for clientType, policy := range policies.ByClientType {
+		others = append(others, string(clientType))
+		limits = append(limits, cleanupUs.SessionLimit{
+			ClientTypes: []string{string(clientType)},
+			IdleTimeout: policy.TTL,
+			MaxLifetime: policy.MaxLifetime,
+		})
+	}
+	return append(limits, cleanupUs.SessionLimit{
+		ClientTypes: others,
+		Others:      true,
+		IdleTimeout: policies.Default.TTL,
+		MaxLifetime: policies.Default.MaxLifetime,
+	})
+}
+
+// sessionRepository is the session store, the Postgres auth repository or the Redis session store. Every usecase
+// reading or ending sessions goes through it, so both stores see the same sessions.
+type sessionRepository interface {
+	authUs.SessionRepository
+	adminUs.SessionRevoker
+	orgUs.SessionRemover
+	statsUs.SessionCounter
+}
+
+// accessTokenManager is the JWT manager of the paths verifying user access tokens, a jwt.ShadowManager while
+// a migration is verified.
+type accessTokenManager interface {
+	authUs.JWTManager
+	interceptor.JWTManager
+}
+
+// newShadowCandidate returns the manager of the candidate configuration, the settings it does not name are
+// the current ones.
+func newShadowCandidate(cfg config.JWTConfig) (*jwt.JWTManager, error) {
+	shadow := cfg.Shadow
+	if shadow.SampleRate <= 0 || shadow.SampleRate > 1 {
+		return nil, errors.New("sample_rate must be in (0, 1]")
+	}
+	var opts []jwt.Option
+	if encryptionKey := cmp.Or(shadow.EncryptionKey, cfg.EncryptionKey); encryptionKey != "" {
+		key, err := base64.StdEncoding.DecodeString(encryptionKey)
+		if err != nil || len(key) != 32 {
+			return nil, errors.New("encryption key must be 32 bytes encoded in base64")
+		}
+		opts = append(opts, jwt.WithEncryption(key))
+	}
+	if len(shadow.RequiredAudience) > 0 {
+		opts = append(opts, jwt.WithRequiredAudience(shadow.RequiredAudience...))
+	}
+	if len(shadow.RequiredClaims) > 0 {
+		opts = append(opts, jwt.WithRequiredClaims(shadow.RequiredClaims...))
+	}
+	return jwt.NewJWTManager(cmp.Or(shadow.Secret, cfg.Secret), cfg.ExpirationMinutes, opts...), nil
+}
+
+// metricLabel converts the configured limit of a label, an empty mode is the default of the label.
+func metricLabel(cfg config.MetricLabel, defaultMode metrics.LabelMode) metrics.LabelLimit {
+	mode := metrics.LabelMode(cfg.Mode)
+	if mode == "" {
+		mode = defaultMode
+	}
+	return metrics.LabelLimit{Mode: mode, MaxValues: cfg.MaxValues, Buckets: cfg.Buckets}
+}
+
+// newGeoPolicy loads the GeoIP database, the country database of the login risk assessment when none is configured.
+// Blocked countries are upper-cased ISO codes and require a database.
+func newGeoPolicy(cfg config.GeoIP, countryDatabase string) (authUs.GeoPolicy, error) {
+	path := cfg.Database
+	if path == "" {
+		path = countryDatabase
+	}
+	var policy authUs.GeoPolicy
+	for _, country := range cfg.BlockedCountries {
+		country = strings.ToUpper(strings.TrimSpace(country))
+		if len(country) != 2 {
+			return authUs.GeoPolicy{}, fmt.Errorf("blocked country %q is not an ISO 3166-1 alpha-2 code", country)
+		}
+		policy.BlockedCountries = append(policy.BlockedCountries, country)
+	}
+	if path == "" {
+		if len(policy.BlockedCountries) > 0 {
+			return authUs.GeoPolicy{}, errors.New("blocked_countries requires a database")
+		}
+		return policy, nil
+	}
+	db, err := geoip.Load(path)
+	if err != nil {
+		return authUs.GeoPolicy{}, fmt.Errorf("database: %w", err)
+	}
+	policy.Locator = db
+	return policy, nil
+}
+
+// newAttestationVerifier returns the verifier of the configured platforms, a platform without an app ID or
+// package name is not configured.
+func newAttestationVerifier(cfg config.AppAttestation) (*attestation.Verifier, error) {
+	secret, err := base64.StdEncoding.DecodeString(cfg.ChallengeSecret)
+	if err != nil || len(secret) < 32 {
+		return nil, errors.New("challenge_secret must be at least 32 bytes encoded in base64")
+	}
+	verifier := &attestation.Verifier{Challenges: attestation.NewChallenges(secret, cfg.ChallengeTTL)}
+	if cfg.Android.PackageName != "" {
+		decryptionKey, err := base64.StdEncoding.DecodeString(cfg.Android.DecryptionKey)
+		if err != nil || len(decryptionKey) != 32 {
+			return nil, errors.New("android decryption_key must be 32 bytes encoded in base64")
+		}
+		der, err := base64.StdEncoding.DecodeString(cfg.Android.VerificationKey)
+		if err != nil {
+			return nil, fmt.Errorf("android verification_key: %w", err)
+		}
+		key, err := x509.ParsePKIXPublicKey(der)
+		if err != nil {
+			return nil, fmt.Errorf("android verification_key: %w", err)
+		}
+		verificationKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return nil, errors.New("android verification_key must be an EC public key")
+		}
+		verifier.Android = &attestation.PlayIntegrity{
+			PackageName:     cfg.Android.PackageName,
+			DecryptionKey:   decryptionKey,
+			VerificationKey: verificationKey,
+			MaxAge:          cfg.Android.MaxAge,
+		}
+	}
+	if cfg.IOS.AppID != "" {
+		pem, err := os.ReadFile(cfg.IOS.RootCA)
+		if err != nil {
+			return nil, fmt.Errorf("ios root_ca: %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, errors.New("ios root_ca holds no certificate")
+		}
+		verifier.IOS = &attestation.AppAttest{AppID: cfg.IOS.AppID, Roots: roots, Development: cfg.IOS.Development}
+	}
+	return verifier, nil
+}
+
+// trackedSecrets lists the configured keys with a rotation date and the TLS certificates in use.
+func trackedSecrets(cfg config.Config) ([]secretage.Secret, error) {
+	var secrets []secretage.Secret
+	keys := []struct {
+		name, rotatedAt string
+		inUse           bool
+	}{
+		{"jwt_secret", cfg.SecretRotation.JWTSecretRotatedAt, true},
+		{"jwt_encryption_key", cfg.SecretRotation.JWTEncryptionKeyRotatedAt, cfg.JWTConfig.EncryptionKey != ""},
+		{"receipt_signing_key", cfg.SecretRotation.ReceiptSigningKeyRotatedAt, cfg.IssuanceReceipts.Enabled},
+	}
+	for _, key := range keys {
+		if !key.inUse || key.rotatedAt == "" {
+			continue
+		}
+		rotatedAt, err := time.Parse(time.RFC3339, key.rotatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("%s rotation date: %w", key.name, err)
+		}
+		secrets = append(secrets, secretage.Static(key.name, rotatedAt))
+	}
+	if cfg.Server.TLS.CertFile != "" {
+		secrets = append(secrets, secretage.CertificateFile("http_tls_cert", cfg.Server.TLS.CertFile))
+		for _, d := range cfg.Tenants.Domains {
+			if d.CertFile != "" {
+				secrets = append(secrets, secretage.CertificateFile("tenant_tls_cert:"+d.Host, d.CertFile))
+			}
+		}
+	}
+	if cfg.GrpcServer.TLS.Enabled {
+		secrets = append(secrets, secretage.CertificateFile("grpc_tls_cert", cfg.GrpcServer.TLS.CertFile))
+		if cfg.GrpcServer.TLS.ClientCAFile != "" {
+			secrets = append(secrets, secretage.CertificateFile("grpc_client_ca", cfg.GrpcServer.TLS.ClientCAFile))
+		}
+	}
+	return secrets, nil
+}
+
+// tenantDomains converts the configured tenant domains.
+func tenantDomains(cfg config.Tenants) []entity.TenantDomain {
+	domains := make([]entity.TenantDomain, 0, len(cfg.Domains))
+	for _, d := range cfg.Domains {
+		domains = append(domains, entity.TenantDomain{Host: d.Host, Issuer: d.Issuer, CookieDomain: d.CookieDomain})
+	}
+	return domains
+}
+
+// httpTLSConfig loads the server certificate followed by the certificates of the tenant domains,
+// the TLS stack picks the one matching the SNI of the client. Returns nil when HTTPS is not configured.
+func httpTLSConfig(cfg config.Config) (*tls.Config, error) {
+	if cfg.Server.TLS.CertFile == "" {
+		for _, d := range cfg.Tenants.Domains {
+			if d.CertFile != "" {
+				return nil, fmt.Errorf("tenant domain %s has a certificate but server.tls is not set", d.Host)
+			}
+		}
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.Server.TLS.CertFile, cfg.Server.TLS.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	certs := []tls.Certificate{cert}
+	for _, d := range cfg.Tenants.Domains {
+		if d.CertFile == "" {
+			continue
+		}
+		cert, err := tls.LoadX509KeyPair(d.CertFile, d.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("tenant domain %s: %w", d.Host, err)
+		}
+		certs = append(certs, cert)
+	}
+	return &tls.Config{Certificates: certs, MinVersion: tls.VersionTLS12}, nil
+}
+
+// newSMSSender returns the sender of the configured SMS provider.
+func newSMSSender(cfg config.SMSConfig, logger *slog.Logger) (authUs.SMSSender, error) {
+	switch cfg.Provider {
+	case "log":
+		return sms.NewLogSender(logger), nil
+	case "twilio":
+		if cfg.TwilioAccountSID == "" || cfg.TwilioAuthToken == "" || cfg.From == "" {
+			return nil, errors.New("twilio requires account SID, auth token and sender")
+		}
+		return sms.NewTwilioSender(cfg.TwilioAccountSID, cfg.TwilioAuthToken, cfg.From, cfg.Timeout), nil
+	}
+	return nil, fmt.Errorf("unknown SMS provider %q", cfg.Provider)
+}
+
 func setupLogger(env string) *slog.Logger {
 	var log *slog.Logger
 	switch env {
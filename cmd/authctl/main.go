@@ -0,0 +1,485 @@
+// Command authctl runs maintenance tasks against the auth database.
+//
+//	authctl import -config configs/config.yaml -file users.csv [-format csv|json] [-dry-run]
+//	authctl export -config configs/config.yaml [-out users.json] [-redact email,username] [-include-password-hashes]
+//	authctl verify-receipt -config configs/config.yaml -receipt <receipt> [-access-token <token>]
+//	authctl seed -config configs/config.yaml -users 100000 [-sessions 3] [-password <password>]
+//	authctl encrypt-pii -config configs/config.yaml [-batch 1000]
+//	authctl decrypt-pii -config configs/config.yaml [-batch 1000]
+//	authctl rotate-data-key -config configs/config.yaml
+//	authctl rewrap-data-keys -config configs/config.yaml -new-master-key <base64 or secret reference>
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"main/domain/entity"
+	"main/internal/config"
+	"main/internal/metrics"
+	psql "main/internal/storage/postgres"
+	accountRepo "main/internal/storage/postgres/account"
+	authRepo "main/internal/storage/postgres/auth"
+	dataKeyRepo "main/internal/storage/postgres/datakey"
+	authUs "main/internal/usecase/auth"
+	"main/pkg/emailnorm"
+	"main/pkg/fieldcrypt"
+	"main/pkg/fingerprint"
+	"main/pkg/idgen"
+	"main/pkg/passhash"
+	"main/pkg/receipt"
+	"main/pkg/seed"
+	"main/pkg/userexport"
+	"main/pkg/userimport"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "import":
+		err = runImport(os.Args[2:])
+	case "export":
+		err = runExport(os.Args[2:])
+	case "verify-receipt":
+		err = runVerifyReceipt(os.Args[2:])
+	case "seed":
+		err = runSeed(os.Args[2:])
+	case "encrypt-pii":
+		err = runEncryptPII(os.Args[2:], false)
+	case "decrypt-pii":
+		err = runEncryptPII(os.Args[2:], true)
+	case "rotate-data-key":
+		err = runRotateDataKey(os.Args[2:])
+	case "rewrap-data-keys":
+		err = runRewrapDataKeys(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "authctl:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage: authctl <command> [flags]
+
+commands:
+  import            import users with pre-hashed passwords from a CSV or JSON file
+  export            export users and credential metadata as SCIM JSON
+  verify-receipt    check the signature of a token issuance receipt and that it was recorded
+  seed              generate users and sessions for load tests
+  encrypt-pii       encrypt the emails, IP addresses and user agents stored in plaintext
+  decrypt-pii       store the encrypted personal data in plaintext again, to disable the encryption
+  rotate-data-key   add a data key that new values are encrypted with
+  rewrap-data-keys  wrap the data keys with a new master key`)
+	os.Exit(2)
+}
+
+// runImport imports the users of a file through the same usecase as the admin API.
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	configPath := fs.String("config", os.Getenv("CONFIG_PATH"), "Path to the config file")
+	file := fs.String("file", "", "CSV or JSON file with the users")
+	format := fs.String("format", "", "csv or json, detected from the file extension when empty")
+	dryRun := fs.Bool("dry-run", false, "validate and report without writing")
+	fs.Parse(args)
+
+	if *file == "" {
+		return fmt.Errorf("-file is required")
+	}
+	if *format == "" {
+		*format = strings.TrimPrefix(filepath.Ext(*file), ".")
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	users, err := userimport.Decode(f, userimport.Format(strings.ToLower(*format)))
+	if err != nil {
+		return err
+	}
+
+	pool, cfg, err := connect(*configPath)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	crypt, err := fieldCipher(pool, cfg.PIIEncryption)
+	if err != nil {
+		return err
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	repo := accountRepo.NewAccountRepo(pool, metrics.NewMetrics(prometheus.NewRegistry(), metrics.Options{}), crypt)
+	emails := emailnorm.Normalizer{CollapseGmail: cfg.EmailNormalization.CollapseGmail}
+	userIDs, err := idgen.New(cfg.IDConfig.UserIDStrategy)
+	if err != nil {
+		return err
+	}
+	report, err := authUs.NewImportUsecase(repo, logger, emails, userIDs).ImportUsers(context.Background(), users, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+// runExport writes all live users as a SCIM ListResponse to a file or stdout.
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	configPath := fs.String("config", os.Getenv("CONFIG_PATH"), "Path to the config file")
+	out := fs.String("out", "", "output file, stdout when empty")
+	redact := fs.String("redact", "", "comma-separated PII fields to mask: email, username")
+	includeHashes := fs.Bool("include-password-hashes", false, "export password hashes for targets that can verify them")
+	fs.Parse(args)
+
+	opts := userexport.Options{IncludePasswordHashes: *includeHashes}
+	for _, field := range strings.Split(*redact, ",") {
+		switch f := userexport.Field(strings.TrimSpace(field)); f {
+		case "":
+		case userexport.FieldEmail, userexport.FieldUsername:
+			opts.Redact = append(opts.Redact, f)
+		default:
+			return fmt.Errorf("unknown redact field %q", field)
+		}
+	}
+
+	pool, cfg, err := connect(*configPath)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	crypt, err := fieldCipher(pool, cfg.PIIEncryption)
+	if err != nil {
+		return err
+	}
+
+	w := os.Stdout
+	if *out != "" {
+		// the export holds personal data and possibly password hashes
+		w, err = os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			return err
+		}
+		defer w.Close()
+	}
+
+	repo := accountRepo.NewAccountRepo(pool, metrics.NewMetrics(prometheus.NewRegistry(), metrics.Options{}), crypt)
+	ew := userexport.NewWriter(w, opts)
+	if err := authUs.NewExportUsecase(repo).ExportUsers(context.Background(), ew.Write); err != nil {
+		return err
+	}
+	return ew.Close()
+}
+
+// runVerifyReceipt checks a receipt presented in a dispute: its signature, that the service recorded it
+// and, when given, that the access token is the one it was issued for.
+func runVerifyReceipt(args []string) error {
+	fs := flag.NewFlagSet("verify-receipt", flag.ExitOnError)
+	configPath := fs.String("config", os.Getenv("CONFIG_PATH"), "Path to the config file")
+	signed := fs.String("receipt", "", "the receipt as returned on login or refresh")
+	accessToken := fs.String("access-token", "", "optional access token to match against the receipt")
+	fs.Parse(args)
+
+	if *signed == "" {
+		return fmt.Errorf("-receipt is required")
+	}
+	pool, cfg, err := connect(*configPath)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	signer, err := receipt.NewSigner(cfg.IssuanceReceipts.SigningKey)
+	if err != nil {
+		return err
+	}
+	r, err := receipt.Verify(signer.PublicKey(), *signed)
+	if err != nil {
+		return err
+	}
+	if *accessToken != "" && receipt.HashToken(*accessToken) != r.AccessTokenHash {
+		return fmt.Errorf("the access token was not issued with this receipt")
+	}
+
+	// receipts hold no personal data, the cipher is not needed
+	repo := authRepo.NewAuthRepo(pool, metrics.NewMetrics(prometheus.NewRegistry(), metrics.Options{}), nil)
+	stored, err := repo.GetReceipt(context.Background(), r.ID)
+	if err != nil {
+		return fmt.Errorf("receipt %s is not recorded: %w", r.ID, err)
+	}
+	if stored.Signature != *signed {
+		return fmt.Errorf("receipt %s differs from the recorded one", r.ID)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// seedBatchSize is the number of users loaded per transaction.
+const seedBatchSize = 1000
+
+// SeedReport summarizes a seed run.
+type SeedReport struct {
+	Users           int    `json:"users"`
+	Sessions        int    `json:"sessions"`
+	ExpiredSessions int    `json:"expired_sessions"`
+	LoginEvents     int    `json:"login_events"`
+	Password        string `json:"password"`
+	Duration        string `json:"duration"`
+}
+
+// runSeed loads generated users with sessions spread over their account lifetime, for performance tests
+// of the session cleanup, user search and token verification paths. Never run it against production.
+func runSeed(args []string) error {
+	fs := flag.NewFlagSet("seed", flag.ExitOnError)
+	configPath := fs.String("config", os.Getenv("CONFIG_PATH"), "Path to the config file")
+	users := fs.Int("users", 0, "number of users to generate")
+	sessions := fs.Int("sessions", 3, "number of sessions per user, older ones are expired")
+	password := fs.String("password", "seed-Password-1", "password of every generated user")
+	history := fs.Duration("history", 730*24*time.Hour, "how far back users registered")
+	rngSeed := fs.Uint64("seed", uint64(time.Now().UnixNano()), "random seed, a seed already loaded generates colliding usernames")
+	fs.Parse(args)
+
+	if *users <= 0 || *sessions < 0 {
+		return fmt.Errorf("-users must be positive and -sessions must not be negative")
+	}
+	pool, cfg, err := connect(*configPath)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	hasher, err := passwordHasher(cfg.PasswordHashing)
+	if err != nil {
+		return err
+	}
+	// one hash for all users, hashing every password would dominate the run
+	passwordHash, err := hasher.Hash(*password)
+	if err != nil {
+		return err
+	}
+	gen := seed.NewGenerator(seed.Options{
+		PasswordHash: passwordHash,
+		SessionTTL:   cfg.SessionConfig.TTL,
+		History:      *history,
+	}, *rngSeed)
+	emails := emailnorm.Normalizer{CollapseGmail: cfg.EmailNormalization.CollapseGmail}
+	fingerprinter := fingerprint.NewHasher([]byte(cfg.PrivacyConfig.FingerprintSalt), cfg.PrivacyConfig.Mode)
+	crypt, err := fieldCipher(pool, cfg.PIIEncryption)
+	if err != nil {
+		return err
+	}
+	repo := accountRepo.NewAccountRepo(pool, metrics.NewMetrics(prometheus.NewRegistry(), metrics.Options{}), crypt)
+
+	start := time.Now()
+	report := SeedReport{Password: *password}
+	for report.Users < *users {
+		n := min(seedBatchSize, *users-report.Users)
+		batch := make([]entity.SeedUser, 0, n)
+		var batchSessions []entity.Session
+		var batchEvents []entity.LoginEvent
+		for range n {
+			user := gen.User()
+			user.CanonicalEmail = emails.Canonical(user.Email)
+			batch = append(batch, user)
+			userSessions := gen.Sessions(user, *sessions)
+			for i, s := range userSessions {
+				fp := fingerprinter.Fingerprint(s.ClientIP, s.UserAgent)
+				s.ClientIP, s.UserAgent, s.IPHash, s.DeviceHash = fp.IP, fp.UserAgent, fp.IPHash, fp.DeviceHash
+				if s.ExpiresAt.Before(start) {
+					report.ExpiredSessions++
+				}
+				userSessions[i] = s
+			}
+			batchSessions = append(batchSessions, userSessions...)
+			// the attempts of sessions are already fingerprinted, fingerprinting them again changes nothing
+			for _, e := range gen.LoginEvents(user, userSessions) {
+				fp := fingerprinter.Fingerprint(e.IP, e.UserAgent)
+				e.IP, e.UserAgent = fp.IP, fp.UserAgent
+				batchEvents = append(batchEvents, e)
+			}
+		}
+		if err := repo.SeedUsers(context.Background(), batch, batchSessions, batchEvents); err != nil {
+			return err
+		}
+		report.Users += n
+		report.Sessions += len(batchSessions)
+		report.LoginEvents += len(batchEvents)
+		fmt.Fprintf(os.Stderr, "seeded %d/%d users\n", report.Users, *users)
+	}
+	report.Duration = time.Since(start).Round(time.Millisecond).String()
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+// runEncryptPII encrypts the personal data written before the encryption was enabled, or with decrypt stores it
+// in plaintext again. The encryption is used as configured even when it is disabled, decrypting is done after
+// disabling it, the instances cannot read the encrypted rows meanwhile.
+func runEncryptPII(args []string, decrypt bool) error {
+	fs := flag.NewFlagSet("encrypt-pii", flag.ExitOnError)
+	configPath := fs.String("config", os.Getenv("CONFIG_PATH"), "Path to the config file")
+	batch := fs.Int("batch", 1000, "rows updated per transaction")
+	fs.Parse(args)
+
+	if *batch <= 0 {
+		return fmt.Errorf("-batch must be positive")
+	}
+	pool, cfg, err := connect(*configPath)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	pii := cfg.PIIEncryption
+	pii.Enabled = true
+	crypt, err := fieldCipher(pool, pii)
+	if err != nil {
+		return err
+	}
+	emails := emailnorm.Normalizer{CollapseGmail: cfg.EmailNormalization.CollapseGmail}
+	repo := dataKeyRepo.NewDataKeyRepo(pool, metrics.NewMetrics(prometheus.NewRegistry(), metrics.Options{}))
+	updated, err := repo.EncryptPII(context.Background(), crypt, emails.Canonical, decrypt, *batch)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(updated)
+}
+
+// runRotateDataKey adds a data key. The instances encrypt with it after pii_encryption.key_activation, the
+// values encrypted with the previous keys stay readable.
+func runRotateDataKey(args []string) error {
+	fs := flag.NewFlagSet("rotate-data-key", flag.ExitOnError)
+	configPath := fs.String("config", os.Getenv("CONFIG_PATH"), "Path to the config file")
+	fs.Parse(args)
+
+	pool, cfg, err := connect(*configPath)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	master, err := fieldcrypt.ParseMasterKey(cfg.PIIEncryption.MasterKey)
+	if err != nil {
+		return err
+	}
+	repo := dataKeyRepo.NewDataKeyRepo(pool, metrics.NewMetrics(prometheus.NewRegistry(), metrics.Options{}))
+	// the keys must be readable with the configured master key before one is added
+	if _, err := fieldcrypt.Open(context.Background(), repo, master, cfg.PIIEncryption.KeyActivation); err != nil {
+		return err
+	}
+	return fieldcrypt.Rotate(context.Background(), repo, master)
+}
+
+// runRewrapDataKeys wraps the data keys with a new master key, the encrypted values are unchanged. The instances
+// keep the keys they loaded until they are restarted with the new master key.
+func runRewrapDataKeys(args []string) error {
+	fs := flag.NewFlagSet("rewrap-data-keys", flag.ExitOnError)
+	configPath := fs.String("config", os.Getenv("CONFIG_PATH"), "Path to the config file")
+	newMasterKey := fs.String("new-master-key", "", "the new master key, base64 of 32 bytes or a secret reference")
+	fs.Parse(args)
+
+	if *newMasterKey == "" {
+		return fmt.Errorf("-new-master-key is required")
+	}
+	pool, cfg, err := connect(*configPath)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	resolver, err := cfg.Secrets.SecretResolver()
+	if err != nil {
+		return err
+	}
+	encoded, err := resolver.Resolve(context.Background(), *newMasterKey)
+	if err != nil {
+		return err
+	}
+	newMaster, err := fieldcrypt.ParseMasterKey(encoded)
+	if err != nil {
+		return err
+	}
+	master, err := fieldcrypt.ParseMasterKey(cfg.PIIEncryption.MasterKey)
+	if err != nil {
+		return err
+	}
+
+	repo := dataKeyRepo.NewDataKeyRepo(pool, metrics.NewMetrics(prometheus.NewRegistry(), metrics.Options{}))
+	keys, err := repo.DataKeys(context.Background())
+	if err != nil {
+		return err
+	}
+	for i, k := range keys {
+		key, err := fieldcrypt.UnwrapKey(master, k.Wrapped)
+		if err != nil {
+			return fmt.Errorf("data key %d: %w", k.ID, err)
+		}
+		if keys[i].Wrapped, err = fieldcrypt.WrapKey(newMaster, key); err != nil {
+			return err
+		}
+	}
+	if err := repo.ReplaceWrappedKeys(context.Background(), keys); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "rewrapped %d data keys, set pii_encryption.master_key to the new key\n", len(keys))
+	return nil
+}
+
+// fieldCipher returns the cipher of the PII encryption, nil when it is disabled.
+func fieldCipher(pool *pgxpool.Pool, cfg config.PIIEncryption) (*fieldcrypt.Cipher, error) {
+	repo := dataKeyRepo.NewDataKeyRepo(pool, metrics.NewMetrics(prometheus.NewRegistry(), metrics.Options{}))
+	return cfg.FieldCipher(context.Background(), repo)
+}
+
+// passwordHasher returns the hasher of the configured algorithm, the one the service verifies logins with.
+func passwordHasher(cfg config.PasswordHashing) (authUs.PasswordHasher, error) {
+	switch passhash.Algorithm(cfg.Algorithm) {
+	case passhash.Bcrypt:
+		return passhash.NewBcryptHasher(cfg.BcryptCost)
+	case passhash.Argon2id:
+		return passhash.NewArgon2idHasher(cfg.Argon2Memory, cfg.Argon2Iterations, cfg.Argon2Parallelism)
+	}
+	return nil, fmt.Errorf("unknown password hashing algorithm %q", cfg.Algorithm)
+}
+
+// connect opens the database of the config file.
+func connect(configPath string) (*pgxpool.Pool, config.Config, error) {
+	if configPath == "" {
+		return nil, config.Config{}, fmt.Errorf("-config or CONFIG_PATH is required")
+	}
+	cfg := config.LoadConfigFromPath(configPath)
+	resolver, err := cfg.Secrets.SecretResolver()
+	if err != nil {
+		return nil, cfg, err
+	}
+	if err := cfg.ResolveSecrets(context.Background(), resolver); err != nil {
+		return nil, cfg, err
+	}
+	pool, err := psql.NewPostgresConnection(cfg.PostgresConfig.DSN(), nil, nil)
+	return pool, cfg, err
+}
@@ -2,6 +2,7 @@ package entity
 
 import (
 	"net/netip"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -9,12 +10,16 @@ import (
 
 // User represents a user in the system with essential attributes.
 type User struct {
-	ID           uuid.UUID `json:"id"`
-	Email        string    `json:"email"`
-	Username     string    `json:"username"`
-	PasswordHash string    `json:"password"`
-	CreatedAt    time.Time `json:"created_at"`
-	IsBlocked    bool      `json:"is_blocked"`
+	ID            uuid.UUID `json:"id"`
+	Email         string    `json:"email"`
+	Username      string    `json:"username"`
+	PasswordHash  string    `json:"password"`
+	CreatedAt     time.Time `json:"created_at"`
+	IsBlocked     bool      `json:"is_blocked"`
+	EmailVerified bool      `json:"email_verified"`
+	// Locale and Timezone come from the last login, empty when the client sent none
+	Locale   string `json:"locale,omitempty"`
+	Timezone string `json:"timezone,omitempty"`
 }
 
 // Session represents a user session with relevant details for authentication and tracking.
@@ -27,4 +32,1094 @@ type Session struct {
 	CreatedAt    time.Time  `json:"created_at"`
 	ExpiresAt    time.Time  `json:"expires_at"`
 	UserAgent    string     `json:"user_agent"`
+	ClientType   ClientType `json:"client_type"`
+	// StartedAt is the login time, unlike CreatedAt it does not move with the rotations of the refresh token
+	StartedAt time.Time `json:"started_at"`
+	// DeviceName is the name the user gave the device of the session ("Dan's iPhone"), empty if none
+	DeviceName string `json:"device_name,omitempty"`
+	// CertThumbprint binds the session to the mTLS client certificate used at login (RFC 8705)
+	CertThumbprint string `json:"-"`
+	// DPoPThumbprint binds the session to the key pair that signed the DPoP proof at login (RFC 9449)
+	DPoPThumbprint string `json:"-"`
+	// IPHash and DeviceHash are salted hashes of the client IP and user agent
+	IPHash     string `json:"-"`
+	DeviceHash string `json:"-"`
+	// CanaryToken is the decoy refresh token of the session, uuid.Nil without refresh canaries
+	CanaryToken uuid.UUID `json:"-"`
+	// Locale is the supported language negotiated from Accept-Language, Timezone the IANA zone of the client
+	Locale   string `json:"locale,omitempty"`
+	Timezone string `json:"timezone,omitempty"`
+	// Country is the ISO code of the country of the login IP, empty without a country database,
+	// City its city, empty without a city database
+	Country string         `json:"country,omitempty"`
+	City    string         `json:"city,omitempty"`
+	Risk    RiskAssessment `json:"risk"`
+	// AttestedKey is the App Attest key the ios app attested at login, its refreshes are asserted with it
+	AttestedKey *AttestedKey `json:"-"`
+	// BoundNetwork is the network (/24, /48) of the login IP, unlike ClientIP it does not follow refreshes
+	BoundNetwork netip.Addr `json:"-"`
+	// Privileges is a fingerprint of the roles of the user when the session was started or last rotated
+	Privileges string `json:"-"`
+	// ElevatedUntil is when the step-up elevation of the session ends, zero if it was never elevated
+	ElevatedUntil time.Time `json:"-"`
+	// TokenState is the token state of the user when the session was started, nil when the store does not keep it
+	TokenState *TokenState `json:"-"`
+}
+
+// RiskLevel grades how unusual a login is compared with the previous sessions of the user.
+type RiskLevel string
+
+const (
+	RiskLow    RiskLevel = "low"
+	RiskMedium RiskLevel = "medium"
+	RiskHigh   RiskLevel = "high"
+)
+
+// Rank orders the levels, unknown levels rank below low.
+func (l RiskLevel) Rank() int {
+	switch l {
+	case RiskLow:
+		return 1
+	case RiskMedium:
+		return 2
+	case RiskHigh:
+		return 3
+	}
+	return 0
+}
+
+// Risk factors of a login
+const (
+	// RiskNewDevice is a user agent the user had no session with
+	RiskNewDevice = "new_device"
+	// RiskNewNetwork is an IP network (/24, /48) the user had no session from
+	RiskNewNetwork = "new_network"
+	// RiskNewCountry is a country the user had no session from
+	RiskNewCountry = "new_country"
+	// RiskUnattested is a login of a client type that is checked for app attestation without a valid one
+	RiskUnattested = "unattested_app"
+)
+
+// RiskAssessment is the result of comparing a login with the previous sessions of the user.
+type RiskAssessment struct {
+	Level   RiskLevel `json:"level,omitempty"`
+	Factors []string  `json:"factors,omitempty"`
+}
+
+// GeoLocation is where an IP address is located, empty fields are unknown.
+type GeoLocation struct {
+	// Country is the ISO 3166-1 alpha-2 code of the country
+	Country string
+	// City is the English name of the city
+	City string
+}
+
+// SessionOrigin is where a previous session of the user was started from.
+type SessionOrigin struct {
+	IP         netip.Addr
+	DeviceHash string
+	Country    string
+	CreatedAt  time.Time
+}
+
+// ActiveSession is a session as listed to its user, for a "manage devices" screen, or to administrators.
+type ActiveSession struct {
+	ID         uuid.UUID  `json:"id"`
+	ClientType ClientType `json:"client_type"`
+	DeviceName string     `json:"device_name,omitempty"`
+	UserAgent  string     `json:"user_agent"`
+	IP         netip.Addr `json:"ip"`
+	Country    string     `json:"country,omitempty"`
+	City       string     `json:"city,omitempty"`
+	// CreatedAt is the login, LastUsedAt the last refresh of the session
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	// Blocked sessions are kept but cannot be refreshed
+	Blocked bool `json:"blocked,omitempty"`
+	// Current marks the session of the access token the list was requested with
+	Current bool `json:"current"`
+}
+
+// SessionStatus selects the sessions of a listing by state, empty selects all of them.
+type SessionStatus string
+
+const (
+	// SessionStatusActive sessions are unexpired and not blocked
+	SessionStatusActive  SessionStatus = "active"
+	SessionStatusExpired SessionStatus = "expired"
+	SessionStatusBlocked SessionStatus = "blocked"
+)
+
+// Valid reports whether the status is known or empty.
+func (s SessionStatus) Valid() bool {
+	switch s {
+	case "", SessionStatusActive, SessionStatusExpired, SessionStatusBlocked:
+		return true
+	}
+	return false
+}
+
+// SessionSort is the time session listings are ordered by, newest first.
+type SessionSort string
+
+const (
+	// SessionSortCreated orders by login, SessionSortLastUsed by last refresh
+	SessionSortCreated  SessionSort = "created_at"
+	SessionSortLastUsed SessionSort = "last_used_at"
+)
+
+// Valid reports whether the sort is known.
+func (s SessionSort) Valid() bool {
+	return s == SessionSortCreated || s == SessionSortLastUsed
+}
+
+// SessionCursor is the position of the last session of a page in the order of the listing, the next page starts
+// after it (keyset pagination). At is the time the listing is sorted by.
+type SessionCursor struct {
+	At time.Time
+	ID uuid.UUID
+}
+
+// SessionFilter selects the sessions of a user for a listing. With After set, Offset is ignored.
+type SessionFilter struct {
+	UserID uuid.UUID
+	Status SessionStatus
+	Sort   SessionSort
+	Limit  int
+	Offset int
+	After  *SessionCursor
+}
+
+// SessionPage is one page of a session listing, Total counts all sessions matching the filter.
+type SessionPage struct {
+	Sessions []ActiveSession `json:"sessions"`
+	Total    int             `json:"total"`
+	// NextCursor continues the listing after this page, empty on the last one
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// LoginOutcome is the result of a login attempt in the login history.
+type LoginOutcome string
+
+const (
+	LoginSuccess          LoginOutcome = "success"
+	LoginInvalidPassword  LoginOutcome = "invalid_password"
+	LoginEmailNotVerified LoginOutcome = "email_not_verified"
+	LoginBlocked          LoginOutcome = "blocked"
+	LoginMFARequired      LoginOutcome = "mfa_required"
+	LoginTermsNotAccepted LoginOutcome = "terms_not_accepted"
+	// LoginAttestationFailed is a login of a client type requiring app attestation without a valid one
+	LoginAttestationFailed LoginOutcome = "attestation_failed"
+	// LoginGeoBlocked is a login from a country logins are blocked from
+	LoginGeoBlocked LoginOutcome = "geo_blocked"
+)
+
+// LoginEvent is a login attempt on an account, IP and UserAgent are stored like the ones of sessions
+// (see ClientFingerprint).
+type LoginEvent struct {
+	ID         uuid.UUID    `json:"id"`
+	UserID     uuid.UUID    `json:"-"`
+	CreatedAt  time.Time    `json:"created_at"`
+	IP         netip.Addr   `json:"ip"`
+	UserAgent  string       `json:"user_agent"`
+	ClientType ClientType   `json:"client_type,omitempty"`
+	Country    string       `json:"country,omitempty"`
+	City       string       `json:"city,omitempty"`
+	Outcome    LoginOutcome `json:"outcome"`
+}
+
+// LoginEventPage is one page of the login history of a user, Total counts all its events.
+type LoginEventPage struct {
+	Events []LoginEvent `json:"events"`
+	Total  int          `json:"total"`
+}
+
+// ClientFingerprint is what is stored about the client of a session. In privacy mode IP holds only
+// the network of the client and UserAgent is empty, the hashes still identify the exact client.
+type ClientFingerprint struct {
+	IP         netip.Addr
+	UserAgent  string
+	IPHash     string
+	DeviceHash string
+}
+
+// RegisterInput holds the details of a new account.
+type RegisterInput struct {
+	Username string
+	Email    string
+	Password string
+	// Phone is optional, it enables login with a code sent by SMS
+	Phone string
+	// InviteCode is required when registration is invite-only
+	InviteCode string
+	// AcceptedTerms are the IDs of the terms documents the user accepted with the registration
+	AcceptedTerms []uuid.UUID
+	// IP is the client address, CaptchaToken the response of the CAPTCHA widget when one is required
+	IP           string
+	CaptchaToken string
+}
+
+// NewUser is the record of a validated registration as it is stored.
+type NewUser struct {
+	ID             uuid.UUID
+	Email          string
+	CanonicalEmail string
+	Username       string
+	PasswordHash   string
+	// Phone is in E.164 format, empty when not given
+	Phone string
+}
+
+// PhoneLoginInput holds a login code sent by SMS and the request context of the login.
+type PhoneLoginInput struct {
+	Phone string
+	Code  string
+	// Client carries the client type and the request context, its login and password are not used
+	Client LoginInput
+}
+
+// MFAMethod is a second factor of password logins.
+type MFAMethod string
+
+const (
+	// MFAEmail sends a one-time code to the verified email address of the account
+	MFAEmail MFAMethod = "email"
+)
+
+// MFAMethods are the available second factors.
+var MFAMethods = []MFAMethod{MFAEmail}
+
+// Valid reports whether the method is one of the available second factors.
+func (m MFAMethod) Valid() bool {
+	return m == MFAEmail
+}
+
+// MFAChallenge is a pending second factor of a login. Destination is where the code was sent, masked.
+type MFAChallenge struct {
+	ID          uuid.UUID `json:"challenge_id"`
+	UserID      uuid.UUID `json:"-"`
+	Method      MFAMethod `json:"method"`
+	Destination string    `json:"destination"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// MFALoginInput completes a password login with the code of its second factor.
+type MFALoginInput struct {
+	ChallengeID uuid.UUID
+	Code        string
+	// Client carries the client type and the request context, its login and password are not used
+	Client LoginInput
+	// TrustDevice skips the second factor on this device for the next logins
+	TrustDevice bool
+}
+
+// ElevationInput re-authenticates the user of a session to elevate it. The password is sent first, users with a
+// second factor then get a challenge and send its ID and code.
+type ElevationInput struct {
+	UserID      uuid.UUID
+	SessionID   uuid.UUID
+	Password    string
+	ChallengeID uuid.UUID
+	Code        string
+	IP          string
+}
+
+// TrustedDevice is a device on which the user skips the second factor until ExpiresAt.
+type TrustedDevice struct {
+	ID         uuid.UUID  `json:"id"`
+	UserID     uuid.UUID  `json:"-"`
+	UserAgent  string     `json:"user_agent"`
+	IP         netip.Addr `json:"ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+}
+
+// DeviceTrust is the signed cookie of a device trusted with a login.
+type DeviceTrust struct {
+	Cookie    string
+	ExpiresAt time.Time
+}
+
+// Invitation allows registering while registration is invite-only. The code itself is only
+// shown once on creation, the invitation is used up after MaxUses registrations.
+type Invitation struct {
+	ID        uuid.UUID  `json:"id"`
+	MaxUses   int        `json:"max_uses"`
+	Uses      int        `json:"uses"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedBy uuid.UUID  `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// LoginInput holds the credentials and request context of a login attempt.
+type LoginInput struct {
+	Login      string
+	Password   string
+	UserAgent  string
+	IP         string
+	ClientType string
+	// CertThumbprint is the SHA-256 thumbprint of the mTLS client certificate, empty without mTLS
+	CertThumbprint string
+	// DPoPThumbprint is the JWK thumbprint of a verified DPoP proof, empty without DPoP
+	DPoPThumbprint string
+	// AcceptedTerms are the IDs of the terms documents the user accepted with the login
+	AcceptedTerms []uuid.UUID
+	// AcceptLanguage and Timezone are the raw Accept-Language and X-Timezone values of the client
+	AcceptLanguage string
+	Timezone       string
+	// TrustedDevice is the cookie of a trusted device, it skips the second factor
+	TrustedDevice string
+	// Attestation is the app attestation presented by a mobile app, empty when none was sent
+	Attestation Attestation
+	// CaptchaToken is the response of the CAPTCHA widget, required after repeated failed logins from the IP
+	CaptchaToken string
+	// DeviceName is the name the user gives the device of the new session, optional
+	DeviceName string
+}
+
+// RefreshInput holds a refresh token and the request context of the refresh.
+type RefreshInput struct {
+	RefreshToken string
+	UserAgent    string
+	IP           string
+	// CertThumbprint and DPoPThumbprint must match the bindings of the session, if it has any
+	CertThumbprint string
+	DPoPThumbprint string
+	// Native is set when the refresh token was sent in the request body, web sessions are refused then
+	Native bool
+	// Attestation is the app attestation presented by a native client, empty when none was sent
+	Attestation Attestation
+}
+
+// Attestation is the proof of a mobile platform that a request comes from a genuine installation of the app:
+// a Play Integrity token on android; on ios an App Attest attestation object at login and an assertion
+// of the attested key at refresh. The token was requested for the challenge, see AttestationChallenge.
+type Attestation struct {
+	Platform  string
+	Token     string
+	Challenge string
+}
+
+// Platforms of app attestations.
+const (
+	AttestationAndroid = "android"
+	AttestationIOS     = "ios"
+)
+
+// AttestationChallenge is the value a mobile app requests its attestation for, it is accepted until ExpiresAt.
+type AttestationChallenge struct {
+	Challenge string    `json:"challenge"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// AttestedKey is an App Attest key: ID is the key identifier, PublicKey the PKIX encoding of its P-256 key
+// and Counter the sign count of its last assertion.
+type AttestedKey struct {
+	ID        []byte
+	PublicKey []byte
+	Counter   uint32
+}
+
+// IssuedTokens is the result of a login or refresh. Receipt is only set when issuance receipts are enabled.
+type IssuedTokens struct {
+	UserID       uuid.UUID
+	SessionID    uuid.UUID
+	AccessToken  string
+	RefreshToken string
+	Receipt      string
+	// DPoPBound is set when the tokens are bound to the DPoP key of the session, they are bearer tokens otherwise
+	DPoPBound bool
+	// MFA is set instead of the tokens when the login still needs its second factor
+	MFA *MFAChallenge
+	// DeviceTrust is set when the login trusted its device
+	DeviceTrust *DeviceTrust
+	// ClientType is the client type of the session, which may differ from the one the login claimed
+	ClientType ClientType
+	// ElevatedUntil is set by the elevation of a session
+	ElevatedUntil time.Time
+}
+
+// PasswordResetResult is the result of a completed password reset. Tokens is set when the reset also
+// logged the user in.
+type PasswordResetResult struct {
+	UserID   uuid.UUID
+	Warnings []string
+	Tokens   *IssuedTokens
+}
+
+// IssuanceReceipt records which tokens were issued to whom. Only SHA-256 hashes of the tokens are kept,
+// Signature is the signed receipt handed to the client and can be verified with the receipt public key.
+type IssuanceReceipt struct {
+	ID               uuid.UUID `json:"rid"`
+	UserID           uuid.UUID `json:"sub"`
+	SessionID        uuid.UUID `json:"sid"`
+	AccessTokenHash  string    `json:"ath"`
+	RefreshTokenHash string    `json:"rth"`
+	IssuedAt         time.Time `json:"iat"`
+	Signature        string    `json:"-"`
+}
+
+// AccessTokenClaims are the user claims carried by an access token.
+type AccessTokenClaims struct {
+	UserID     uuid.UUID  `json:"sub"`
+	SessionID  uuid.UUID  `json:"sid"`
+	ClientType ClientType `json:"client_type"`
+	// CertThumbprint is the cnf x5t#S256 confirmation, the token is only valid with that client certificate
+	CertThumbprint string `json:"cnf,omitempty"`
+	// DPoPThumbprint is the cnf jkt confirmation, the token is only valid with a proof signed by that key
+	DPoPThumbprint string `json:"-"`
+	// IssuedAt is the iat claim, set on verification
+	IssuedAt time.Time `json:"-"`
+	// Issuer is the iss claim, the issuer of the tenant domain the token was issued on
+	Issuer string `json:"iss,omitempty"`
+	// TokenVersion is the tv claim, the token version of the user at issuance. Tokens with an older version are revoked.
+	TokenVersion int64 `json:"tv"`
+	// PasswordTimestamp is the pwd_ts claim, the unix time of the last password change of the user at issuance.
+	// Tokens minted before a later password change are revoked.
+	PasswordTimestamp int64 `json:"pwd_ts"`
+	// ElevatedUntil is the elevated_until claim, the end of the step-up elevation of the session, zero when
+	// the session is not elevated
+	ElevatedUntil time.Time `json:"-"`
+	// TTL is the lifetime of the token when it is shorter than the configured one, like for admins
+	TTL time.Duration `json:"-"`
+}
+
+// TokenState is what the access tokens of a user are checked against.
+type TokenState struct {
+	// Version is the token version, incremented whenever all access tokens of the user are revoked
+	Version int64
+	// PasswordTimestamp is the unix time of the last password change
+	PasswordTimestamp int64
+}
+
+// TenantDomain is a custom domain under which an organization serves the hosted auth endpoints
+// (white-label). Tokens issued on it carry its issuer and cookies are scoped to its cookie domain.
+type TenantDomain struct {
+	Host   string
+	Issuer string
+	// CookieDomain is the Domain attribute of the cookies set on the domain, host-only when empty
+	CookieDomain string
+}
+
+// ClientType tags a session with the kind of application that created it,
+// session policies (TTL, refresh token rotation) can differ per client type.
+type ClientType string
+
+const (
+	ClientTypeWeb     ClientType = "web"
+	ClientTypeMobile  ClientType = "mobile"
+	ClientTypeCLI     ClientType = "cli"
+	ClientTypeService ClientType = "service"
+)
+
+// Valid reports whether the client type is one of the known types.
+func (t ClientType) Valid() bool {
+	switch t {
+	case ClientTypeWeb, ClientTypeMobile, ClientTypeCLI, ClientTypeService:
+		return true
+	}
+	return false
+}
+
+// Native reports whether clients of the type keep the refresh token themselves instead of in a cookie,
+// every type but web (the default of an empty type).
+func (t ClientType) Native() bool {
+	return t != "" && t != ClientTypeWeb
+}
+
+// Client represents an internal service allowed to obtain machine tokens via the client_credentials grant.
+type Client struct {
+	ID         string        `json:"id"`
+	Name       string        `json:"name"`
+	SecretHash string        `json:"-"`
+	Scopes     []string      `json:"scopes"`
+	TokenTTL   time.Duration `json:"token_ttl"`
+	CreatedAt  time.Time     `json:"created_at"`
+	IsDisabled bool          `json:"is_disabled"`
+}
+
+// WarningPasswordBreached is returned with a successful password change or registration when the
+// password appears in known data breaches and the breach check only warns.
+const WarningPasswordBreached = "password_breached"
+
+// AffectedReport lists the records a destructive operation removed or, in dry-run mode, would remove.
+type AffectedReport struct {
+	DryRun bool        `json:"dry_run"`
+	Count  int         `json:"count"`
+	IDs    []uuid.UUID `json:"ids"`
+}
+
+// CleanupReport counts the rows a run of the cleanup job deleted or, in dry-run mode, would delete.
+type CleanupReport struct {
+	DryRun bool `json:"dry_run"`
+	// EndedSessions counts the live sessions expired for exceeding their idle timeout or lifetime
+	EndedSessions int64 `json:"ended_sessions"`
+	Sessions      int64 `json:"sessions"`
+	LoginEvents   int64 `json:"login_events"`
+}
+
+// CompromisedTokenReport is the result of the revocation of a list of compromised refresh tokens and session IDs.
+// In dry-run mode nothing is revoked and Sessions lists the sessions that would be.
+type CompromisedTokenReport struct {
+	DryRun bool `json:"dry_run"`
+	// Tokens counts the distinct valid entries of the list
+	Tokens int `json:"tokens"`
+	// Sessions are the sessions the tokens lead to, revoked and denied
+	Sessions []uuid.UUID `json:"sessions"`
+	// Users are the owners of the sessions
+	Users []uuid.UUID `json:"users"`
+	// Unknown counts the tokens of no session: already revoked, expired or never issued
+	Unknown int `json:"unknown"`
+	// Invalid are the entries that are neither refresh tokens nor session IDs
+	Invalid []string `json:"invalid"`
+}
+
+// ImportUser is an account imported from another system. The password hash is kept as is
+// and replaced with a hash of the current algorithm on the first login.
+type ImportUser struct {
+	// ID keeps the identifier of the source system, a new one is generated when empty
+	ID            uuid.UUID `json:"id"`
+	Email         string    `json:"email"`
+	Username      string    `json:"username"`
+	PasswordHash  string    `json:"password_hash"`
+	EmailVerified bool      `json:"email_verified"`
+	// HashAlgorithm optionally states the algorithm of the hash (bcrypt, argon2id, scrypt), it must match the hash
+	HashAlgorithm string `json:"hash_algorithm,omitempty"`
+	// CanonicalEmail is derived from Email during the import
+	CanonicalEmail string `json:"-"`
+}
+
+// SeedUser is a generated user for load tests, stored with its history as is.
+type SeedUser struct {
+	ImportUser
+	IsBlocked bool
+	CreatedAt time.Time
+}
+
+// ImportRejection is a user that was not imported, Row is its position in the input starting at 1.
+type ImportRejection struct {
+	Row    int    `json:"row"`
+	Login  string `json:"login"`
+	Reason string `json:"reason"`
+}
+
+// ImportReport is the result of a user import. In dry-run mode nothing is written and Imported
+// counts the users that passed validation.
+type ImportReport struct {
+	DryRun   bool              `json:"dry_run"`
+	Imported int               `json:"imported"`
+	Rejected []ImportRejection `json:"rejected"`
+}
+
+// UserFilter selects the users of an admin listing. Query matches usernames and emails by substring.
+type UserFilter struct {
+	Query  string
+	Limit  int
+	Offset int
+}
+
+// UserPage is one page of an admin listing, Total counts all users matching the filter.
+type UserPage struct {
+	Users []User
+	Total int
+}
+
+// UserDetail is a user with its active sessions, as shown to administrators.
+type UserDetail struct {
+	User     User
+	Sessions []Session
+}
+
+// ExportUser is a user with the credential metadata exported for migrating to another identity provider.
+type ExportUser struct {
+	User
+	PasswordChangedAt time.Time
+}
+
+// Passkey is a WebAuthn credential of a user, it signs in without a password.
+type Passkey struct {
+	CredentialID []byte
+	UserID       uuid.UUID
+	Name         string
+	// PublicKey is the COSE encoded credential key
+	PublicKey []byte
+	SignCount uint32
+	// AAGUID identifies the authenticator model, zero for most synced passkeys
+	AAGUID     uuid.UUID
+	Transports []string
+	// BackupEligible passkeys are synced between devices, BackupState tells whether they are backed up now
+	BackupEligible bool
+	BackupState    bool
+	CreatedAt      time.Time
+	LastUsedAt     *time.Time
+}
+
+// Passkey ceremonies.
+const (
+	CeremonyRegistration   = "registration"
+	CeremonyAuthentication = "authentication"
+)
+
+// PasskeyChallenge is a pending passkey ceremony, UserID is uuid.Nil for authentications.
+type PasskeyChallenge struct {
+	ID        uuid.UUID
+	Ceremony  string
+	UserID    uuid.UUID
+	Challenge []byte
+	ExpiresAt time.Time
+}
+
+// SecurityFacts are the account properties the security score is computed from.
+type SecurityFacts struct {
+	EmailVerified     bool
+	PasswordChangedAt time.Time
+	Passkeys          int
+	MFAEnabled        bool
+	// ActiveSessions counts unexpired sessions, StaleSessions those of them not refreshed for a while
+	ActiveSessions int
+	StaleSessions  int
+}
+
+// SecurityCheck is one factor of the security score with the action that improves it.
+type SecurityCheck struct {
+	ID             string `json:"id"`
+	Passed         bool   `json:"passed"`
+	Weight         int    `json:"weight"`
+	Recommendation string `json:"recommendation,omitempty"`
+}
+
+// SecurityScore rates how well an account is protected, Score is the share of passed check weights (0-100).
+type SecurityScore struct {
+	Score  int             `json:"score"`
+	Checks []SecurityCheck `json:"checks"`
+}
+
+// AdminReasonCode is the structured reason an administrator gives for a destructive action.
+type AdminReasonCode string
+
+const (
+	ReasonSecurityIncident AdminReasonCode = "security_incident"
+	ReasonAbuse            AdminReasonCode = "abuse"
+	ReasonFraud            AdminReasonCode = "fraud"
+	ReasonUserRequest      AdminReasonCode = "user_request"
+	ReasonLegal            AdminReasonCode = "legal"
+	ReasonOther            AdminReasonCode = "other"
+)
+
+// Valid reports whether the code is one of the known reason codes.
+func (c AdminReasonCode) Valid() bool {
+	switch c {
+	case ReasonSecurityIncident, ReasonAbuse, ReasonFraud, ReasonUserRequest, ReasonLegal, ReasonOther:
+		return true
+	}
+	return false
+}
+
+// AdminReason is required on destructive admin actions (block, force logout, delete)
+// and is stored with the audit record and included in the user notification.
+type AdminReason struct {
+	Code AdminReasonCode `json:"code"`
+	Text string          `json:"text"`
+}
+
+// Valid reports whether the reason has a known code and a non-empty text.
+func (r AdminReason) Valid() bool {
+	return r.Code.Valid() && strings.TrimSpace(r.Text) != ""
+}
+
+// AdminAction records who performed an administrative action on which user or organization and why.
+type AdminAction struct {
+	ID      uuid.UUID `json:"id"`
+	ActorID uuid.UUID `json:"actor_id"`
+	Action  string    `json:"action"`
+	// TargetType is AdminTargetUser or AdminTargetOrganization
+	TargetType string      `json:"target_type"`
+	TargetID   uuid.UUID   `json:"target_id"`
+	Reason     AdminReason `json:"reason"`
+	CreatedAt  time.Time   `json:"created_at"`
+}
+
+// Targets of admin actions.
+const (
+	AdminTargetUser         = "user"
+	AdminTargetOrganization = "organization"
+	AdminTargetIncident     = "status_incident"
+	AdminTargetSession      = "session"
+)
+
+// Admin actions recorded in the audit_events table.
+const (
+	AdminActionForceLogout   = "force_logout"
+	AdminActionOrgCreate     = "org_create"
+	AdminActionOrgSuspend    = "org_suspend"
+	AdminActionOrgResume     = "org_resume"
+	AdminActionOrgDelete     = "org_delete"
+	AdminActionBlock         = "user_block"
+	AdminActionUnblock       = "user_unblock"
+	AdminActionPasswordReset = "admin_password_reset"
+	AdminActionDelete        = "user_delete"
+	AdminActionRestore       = "user_restore"
+	// AdminActionRevokeCompromised is a bulk revocation of compromised refresh tokens and session IDs
+	AdminActionRevokeCompromised = "compromised_tokens_revoke"
+	// AdminActionIncident* annotate the public status page
+	AdminActionIncidentCreate  = "status_incident_create"
+	AdminActionIncidentUpdate  = "status_incident_update"
+	AdminActionIncidentResolve = "status_incident_resolve"
+	// AdminActionFeature* change the rollout of a feature, the allowlist ones target the user
+	AdminActionFeatureRollout  = "feature_rollout"
+	AdminActionFeatureAllow    = "feature_allow"
+	AdminActionFeatureDisallow = "feature_disallow"
+	// AdminActionSession* freeze a session of the user without ending it
+	AdminActionSessionBlock   = "session_block"
+	AdminActionSessionUnblock = "session_unblock"
+)
+
+// Actions of users on their own account recorded in the audit_events table.
+const (
+	AuditRegister       = "register"
+	AuditLogin          = "login"
+	AuditLoginFailed    = "login_failed"
+	AuditLogout         = "logout"
+	AuditLogoutAll      = "logout_all"
+	AuditRefresh        = "refresh"
+	AuditPasswordChange = "password_change"
+	AuditPasswordReset  = "password_reset"
+	// AuditSessionBindingMismatch is a refresh from another user agent or network than the login of the session
+	AuditSessionBindingMismatch = "session_binding_mismatch"
+	// AuditSessionRotated is a session moved to a new ID and refresh token after a privilege change
+	AuditSessionRotated = "session_rotated"
+	// AuditSessionElevated is a session elevated for sensitive admin operations after a step-up authentication
+	AuditSessionElevated = "session_elevated"
+	// AuditSessionRevoked is a session the user ended from their list of sessions
+	AuditSessionRevoked = "session_revoked"
+	// AuditSecurityAlert is a SecurityAlert raised on the account, its type is in the details
+	AuditSecurityAlert = "security_alert"
+)
+
+// AlertType is the kind of anomaly a SecurityAlert reports.
+type AlertType string
+
+const (
+	// AlertRefreshTokenReuse is a refresh token presented again after the session was rotated to a new one
+	AlertRefreshTokenReuse AlertType = "refresh_token_reuse"
+	// AlertRepeatedLockouts is a user locked out of the second factor repeatedly
+	AlertRepeatedLockouts AlertType = "repeated_lockouts"
+	// AlertImpossibleTravel is a login from another country shortly after the previous one
+	AlertImpossibleTravel AlertType = "impossible_travel"
+)
+
+// AlertSeverity tells the receivers of an alert how urgent it is.
+type AlertSeverity string
+
+const (
+	AlertSeverityMedium AlertSeverity = "medium"
+	AlertSeverityHigh   AlertSeverity = "high"
+)
+
+// SecurityAlert is an anomalous authentication event, published to the alert sink and recorded in the audit log.
+type SecurityAlert struct {
+	ID        uuid.UUID     `json:"id"`
+	CreatedAt time.Time     `json:"created_at"`
+	Type      AlertType     `json:"type"`
+	Severity  AlertSeverity `json:"severity"`
+	UserID    uuid.UUID     `json:"user_id"`
+	SessionID *uuid.UUID    `json:"session_id,omitempty"`
+	// IP follows the privacy mode like the ones of sessions
+	IP      netip.Addr        `json:"ip"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// AuditEvent is an entry of the append-only security audit log: an action of a user on its own account
+// or of an administrator on a user or organization.
+type AuditEvent struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Action    string    `json:"action"`
+	// ActorID is the user who acted, nil when it is unknown
+	ActorID *uuid.UUID `json:"actor_id,omitempty"`
+	// TargetType is AdminTargetUser or AdminTargetOrganization, empty when the actor acted on itself
+	TargetType string          `json:"target_type,omitempty"`
+	TargetID   *uuid.UUID      `json:"target_id,omitempty"`
+	ReasonCode AdminReasonCode `json:"reason_code,omitempty"`
+	Reason     string          `json:"reason,omitempty"`
+	// IP and UserAgent follow the privacy mode like the ones of sessions
+	IP        netip.Addr `json:"ip"`
+	UserAgent string     `json:"user_agent,omitempty"`
+	// Details holds what else is known about the action, like the outcome of a failed login
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// AuditFilter selects audit events, zero values match everything. UserID matches the actor and the target.
+type AuditFilter struct {
+	UserID uuid.UUID
+	Action string
+	From   time.Time
+	To     time.Time
+	Limit  int
+	Offset int
+}
+
+// AuditPage is one page of the audit log, Total counts all events matching the filter.
+type AuditPage struct {
+	Events []AuditEvent `json:"events"`
+	Total  int          `json:"total"`
+}
+
+// OrgStatus is the lifecycle state of an organization.
+type OrgStatus string
+
+const (
+	OrgActive    OrgStatus = "active"
+	OrgSuspended OrgStatus = "suspended"
+	// OrgDeleted organizations are kept until PurgeAt and then purged with their memberships
+	OrgDeleted OrgStatus = "deleted"
+)
+
+// Organization is a tenant grouping users. Suspending or deleting it ends the sessions of all its members.
+type Organization struct {
+	ID          uuid.UUID  `json:"id"`
+	Name        string     `json:"name"`
+	Status      OrgStatus  `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	SuspendedAt *time.Time `json:"suspended_at,omitempty"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+	PurgeAt     *time.Time `json:"purge_at,omitempty"`
+}
+
+// OrgMember is the membership of a user in an organization.
+type OrgMember struct {
+	UserID    uuid.UUID `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OrganizationDetail is an organization with its members.
+type OrganizationDetail struct {
+	Organization
+	Members []OrgMember `json:"members"`
+}
+
+// TermsKind is the kind of a legal document users accept.
+type TermsKind string
+
+const (
+	TermsOfService TermsKind = "terms"
+	PrivacyPolicy  TermsKind = "privacy"
+)
+
+// Valid reports whether the kind is one of the known kinds.
+func (k TermsKind) Valid() bool {
+	return k == TermsOfService || k == PrivacyPolicy
+}
+
+// TermsDocument is a published version of the terms of service or the privacy policy.
+type TermsDocument struct {
+	ID          uuid.UUID `json:"id"`
+	Kind        TermsKind `json:"kind"`
+	Version     string    `json:"version"`
+	URL         string    `json:"url"`
+	PublishedAt time.Time `json:"published_at"`
+	// RequiresAcceptance makes users who accepted an earlier version accept this one again,
+	// editorial changes are published without it
+	RequiresAcceptance bool `json:"requires_acceptance"`
+}
+
+// TermsAcceptance records that a user accepted a document version.
+type TermsAcceptance struct {
+	Document   TermsDocument `json:"document"`
+	AcceptedAt time.Time     `json:"accepted_at"`
+}
+
+// BannedHandle is a username or email address administrators banned from registration and username changes.
+// Handles of the empty tenant are banned on every tenant domain.
+type BannedHandle struct {
+	Handle    string     `json:"handle"`
+	Tenant    string     `json:"tenant"`
+	Reason    string     `json:"reason,omitempty"`
+	CreatedBy *uuid.UUID `json:"created_by,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Permission is a single capability on the admin surface, granted to users through roles.
+type Permission string
+
+const (
+	PermUserRead      Permission = "user.read"
+	PermUserBlock     Permission = "user.block"
+	PermAuditRead     Permission = "audit.read"
+	PermSessionRevoke Permission = "session.revoke"
+	PermUserDelete    Permission = "user.delete"
+	PermUserImport    Permission = "user.import"
+	PermInviteManage  Permission = "invite.manage"
+	PermPasswordReset Permission = "user.password_reset"
+	PermOrgManage     Permission = "org.manage"
+	PermTermsManage   Permission = "terms.manage"
+	PermHandleManage  Permission = "handle.manage"
+	PermStatusManage  Permission = "status.manage"
+	PermStatsRead     Permission = "stats.read"
+	PermFeatureManage Permission = "feature.manage"
+)
+
+// Role is a named set of permissions granted to users.
+type Role struct {
+	Name        string
+	Permissions []string
+}
+
+// IncidentKind tells whether an annotation of the status page reports an incident or a maintenance.
+type IncidentKind string
+
+const (
+	IncidentKindIncident    IncidentKind = "incident"
+	IncidentKindMaintenance IncidentKind = "maintenance"
+)
+
+// IncidentSeverity tells how much of the service an incident affects.
+type IncidentSeverity string
+
+const (
+	// IncidentSeverityMinor degrades the service, most users can still log in
+	IncidentSeverityMinor IncidentSeverity = "minor"
+	// IncidentSeverityMajor is an outage, users cannot log in
+	IncidentSeverityMajor IncidentSeverity = "major"
+)
+
+// Incident is an annotation of the public status page set by administrators.
+type Incident struct {
+	ID       uuid.UUID        `json:"id"`
+	Kind     IncidentKind     `json:"kind"`
+	Severity IncidentSeverity `json:"severity"`
+	Title    string           `json:"title"`
+	Message  string           `json:"message,omitempty"`
+	// StartsAt is when the incident began, or when a scheduled maintenance begins
+	StartsAt time.Time `json:"starts_at"`
+	// EndsAt is the expected end, nil when unknown. The incident is over then even if not resolved
+	EndsAt     *time.Time `json:"ends_at,omitempty"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+	CreatedBy  *uuid.UUID `json:"created_by,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// Active reports whether the incident is going on at the given time.
+func (i Incident) Active(now time.Time) bool {
+	return i.ResolvedAt == nil && !i.StartsAt.After(now) && (i.EndsAt == nil || i.EndsAt.After(now))
+}
+
+// IncidentUpdate changes an unresolved incident, nil fields are kept.
+type IncidentUpdate struct {
+	Severity *IncidentSeverity
+	Title    *string
+	Message  *string
+	EndsAt   *time.Time
+}
+
+// Overall and component states of the status page.
+const (
+	StatusOperational = "operational"
+	StatusDegraded    = "degraded"
+	StatusMaintenance = "maintenance"
+	StatusOutage      = "outage"
+)
+
+// SystemStatus is the public summary of the health of the service.
+type SystemStatus struct {
+	// Status is the worst of the components, the maintenance and the active incidents
+	Status string `json:"status"`
+	// Components maps each dependency to operational or outage
+	Components  map[string]string `json:"components"`
+	Maintenance MaintenanceStatus `json:"maintenance"`
+	// Incidents are the unresolved and scheduled incidents and the ones resolved recently, newest first
+	Incidents []Incident `json:"incidents"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// MaintenanceStatus tells whether the service is under maintenance: in read-only mode, where logins and other
+// changes fail, or during an announced maintenance.
+type MaintenanceStatus struct {
+	Active   bool `json:"active"`
+	ReadOnly bool `json:"read_only"`
+	// Until is the expected end of the announced maintenance, nil when unknown
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// SystemStats are counts for capacity planning, the rates are averages over the window they are named after.
+type SystemStats struct {
+	Users       UserStats     `json:"users"`
+	Sessions    SessionStats  `json:"sessions"`
+	Tokens      TokenStats    `json:"tokens"`
+	Denylist    DenylistStats `json:"denylist"`
+	GeneratedAt time.Time     `json:"generated_at"`
+}
+
+// UserStats counts the accounts that are not deleted, blocked ones included.
+type UserStats struct {
+	Total           int64   `json:"total"`
+	Blocked         int64   `json:"blocked"`
+	CreatedLastHour int64   `json:"created_last_hour"`
+	CreatedLastDay  int64   `json:"created_last_day"`
+	CreatedPerHour  float64 `json:"created_per_hour"`
+}
+
+// SessionStats counts the unexpired sessions and the logins that started them.
+type SessionStats struct {
+	Active          int64   `json:"active"`
+	StartedLastHour int64   `json:"started_last_hour"`
+	StartedLastDay  int64   `json:"started_last_day"`
+	StartedPerHour  float64 `json:"started_per_hour"`
+}
+
+// TokenStats counts the access tokens issued at logins and refreshes, as recorded in the audit log.
+type TokenStats struct {
+	IssuedLastMinute int64   `json:"issued_last_minute"`
+	IssuedLastHour   int64   `json:"issued_last_hour"`
+	IssuedPerMinute  float64 `json:"issued_per_minute"`
+}
+
+// DenylistStats counts the unexpired entries of the session denylist in the database and in the memory of
+// the answering instance.
+type DenylistStats struct {
+	Entries int64 `json:"entries"`
+	Cached  int64 `json:"cached"`
+}
+
+// Feature is a new auth flow that is rolled out to the users gradually, see FeatureFlag.
+type Feature string
+
+const (
+	// FeaturePasskeys is the registration of passkeys, logins with registered passkeys are not gated
+	FeaturePasskeys Feature = "passkeys"
+	// FeatureDPoP binds the sessions of logins with a DPoP proof to its key, other sessions get bearer tokens
+	FeatureDPoP Feature = "dpop"
+	// FeaturePasswordHashing hashes passwords with the configured algorithm instead of the fallback one
+	FeaturePasswordHashing Feature = "password_hashing"
+)
+
+// Valid reports whether the feature is a known one.
+func (f Feature) Valid() bool {
+	switch f {
+	case FeaturePasskeys, FeatureDPoP, FeaturePasswordHashing:
+		return true
+	}
+	return false
+}
+
+// FeatureFlag is the rollout of a Feature. It is enabled for Percentage percent of the users, picked by a stable
+// hash of their ID so that raising the percentage only adds users, and for the users on its allowlist.
+type FeatureFlag struct {
+	Name       Feature `json:"name"`
+	Percentage int     `json:"percentage"`
+	// Allowlisted counts the users on the allowlist
+	Allowlisted int        `json:"allowlisted"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	UpdatedBy   *uuid.UUID `json:"updated_by,omitempty"`
+}
+
+// FeatureUser is a user on the allowlist of a feature.
+type FeatureUser struct {
+	UserID  uuid.UUID  `json:"user_id"`
+	AddedAt time.Time  `json:"added_at"`
+	AddedBy *uuid.UUID `json:"added_by,omitempty"`
 }
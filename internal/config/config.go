@@ -2,6 +2,7 @@ package config
 
 import (
 	"flag"
+	"net/url"
 	"os"
 	"strconv"
 	"time"
@@ -10,43 +11,672 @@ import (
 )
 
 type Config struct {
-	Env               string `yaml:"env" default:"development"`
-	PostgresConfig    `yaml:"database"`
-	JWTConfig         `yaml:"jwt"`
-	Server            `yaml:"server"`
-	GrpcServer        `yaml:"grpc"`
-	RateLimiterConfig `yaml:"rate_limiter"`
-	RedisConfig       `yaml:"redis"`
+	Env                   string `yaml:"env" default:"development"`
+	PostgresConfig        `yaml:"database"`
+	JWTConfig             `yaml:"jwt"`
+	Server                `yaml:"server"`
+	GrpcServer            `yaml:"grpc"`
+	RateLimiterConfig     `yaml:"rate_limiter"`
+	CORSConfig            `yaml:"cors"`
+	RedisConfig           `yaml:"redis"`
+	AuthzConfig           `yaml:"authz"`
+	MailerConfig          `yaml:"mailer"`
+	EmailVerification     `yaml:"email_verification"`
+	PasswordReset         `yaml:"password_reset"`
+	PasswordHashing       `yaml:"password_hashing"`
+	BreachCheck           `yaml:"breach_check"`
+	SessionConfig         `yaml:"sessions"`
+	EmailChange           `yaml:"email_change"`
+	DPoPConfig            `yaml:"dpop"`
+	TokenVersions         `yaml:"token_versions"`
+	AccountDeletion       `yaml:"account_deletion"`
+	SchemaCheck           `yaml:"schema_check"`
+	ReadOnlyConfig        `yaml:"read_only"`
+	MetricsConfig         `yaml:"metrics"`
+	PrivacyConfig         `yaml:"privacy"`
+	PublicConfig          `yaml:"public"`
+	Registration          `yaml:"registration"`
+	EnumerationProtection `yaml:"enumeration_protection"`
+	EmailNormalization    `yaml:"email_normalization"`
+	IssuanceReceipts      `yaml:"issuance_receipts"`
+	SMSConfig             `yaml:"sms"`
+	PhoneOTP              `yaml:"phone_otp"`
+	Passkeys              `yaml:"passkeys"`
+	MFA                   `yaml:"mfa"`
+	LoginRisk             `yaml:"login_risk"`
+	AppAttestation        `yaml:"app_attestation"`
+	Captcha               `yaml:"captcha"`
+	GeoIP                 `yaml:"geoip"`
+	SecretRotation        `yaml:"secret_rotation"`
+	AdminUI               `yaml:"admin_ui"`
+	Organizations         `yaml:"organizations"`
+	Tenants               `yaml:"tenants"`
+	IDConfig              `yaml:"ids"`
+	Terms                 `yaml:"terms"`
+	UserMetadata          `yaml:"user_metadata"`
+	Handles               `yaml:"handles"`
+	IncidentResponse      `yaml:"incident_response"`
+	Alerts                `yaml:"alerts"`
+	Secrets               `yaml:"secrets"`
+	AdminElevation        `yaml:"admin_elevation"`
+	PIIEncryption         `yaml:"pii_encryption"`
+	StatusPage            `yaml:"status_page"`
+	Features              `yaml:"features"`
+	Cleanup               `yaml:"cleanup"`
+	CookieConfig          `yaml:"cookie"`
+}
+
+type PrivacyConfig struct {
+	// Mode stores salted hashes instead of raw client IPs and user agents
+	Mode bool `yaml:"mode" env:"PRIVACY_MODE" env-default:"false"`
+	// FingerprintSalt keys the hashes, required in privacy mode. Changing it breaks matching with stored hashes.
+	FingerprintSalt string `yaml:"fingerprint_salt" env:"PRIVACY_FINGERPRINT_SALT" env-default:""`
+}
+
+type MetricsConfig struct {
+	Namespace string `yaml:"namespace" env:"METRICS_NAMESPACE" env-default:""`
+	Subsystem string `yaml:"subsystem" env:"METRICS_SUBSYSTEM" env-default:""`
+	// RequestBuckets and DBBuckets are latency bucket boundaries in seconds, built-in defaults when empty
+	RequestBuckets []float64 `yaml:"request_buckets" env:"METRICS_REQUEST_BUCKETS" env-separator:","`
+	DBBuckets      []float64 `yaml:"db_buckets" env:"METRICS_DB_BUCKETS" env-separator:","`
+	// Service and Instance are added as constant labels to every metric, Instance defaults to the hostname
+	Service  string    `yaml:"service" env:"METRICS_SERVICE" env-default:"auth"`
+	Instance string    `yaml:"instance" env:"METRICS_INSTANCE" env-default:""`
+	SLO      SLOConfig `yaml:"slo"`
+	// Labels keep the values of high-cardinality labels from growing the series without bound
+	Labels MetricLabels `yaml:"labels"`
+}
+
+// MetricLabels limits the labels whose values grow with tenants and routes.
+type MetricLabels struct {
+	// Tenant is the tenant domain of HTTP requests, dropped by default
+	Tenant MetricLabel `yaml:"tenant" env-prefix:"METRICS_LABELS_TENANT_"`
+	// Endpoint is the route pattern of HTTP requests and the SLO metrics
+	Endpoint MetricLabel `yaml:"endpoint" env-prefix:"METRICS_LABELS_ENDPOINT_"`
+}
+
+// MetricLabel is how the values of a label are exported.
+type MetricLabel struct {
+	// Mode is keep, hash (one of Buckets buckets per value) or drop
+	Mode string `yaml:"mode" env:"MODE"`
+	// MaxValues caps the distinct values of keep mode, later ones are exported as "other", 0 is unlimited
+	MaxValues int `yaml:"max_values" env:"MAX_VALUES" env-default:"0"`
+	Buckets   int `yaml:"buckets" env:"BUCKETS" env-default:"64"`
+}
+
+// SLOConfig sets the availability and latency targets the SLO burn-rate metrics are computed against.
+type SLOConfig struct {
+	// Objective is the share of good requests (e.g. 0.999), zero disables the SLO metrics of routes without a target
+	Objective float64       `yaml:"objective" env:"METRICS_SLO_OBJECTIVE" env-default:"0.999"`
+	Latency   time.Duration `yaml:"latency" env:"METRICS_SLO_LATENCY" env-default:"500ms"`
+	// Routes overrides the targets per route, keyed by "METHOD /path" such as "POST /login"
+	Routes map[string]SLOTarget `yaml:"routes"`
+}
+
+type SLOTarget struct {
+	Objective float64       `yaml:"objective"`
+	Latency   time.Duration `yaml:"latency"`
+}
+
+// ReadOnlyConfig controls the detection of a database that stopped accepting writes.
+type ReadOnlyConfig struct {
+	// ProbeInterval is how often the database is asked whether it is read-only
+	ProbeInterval time.Duration `yaml:"probe_interval" env:"READ_ONLY_PROBE_INTERVAL" env-default:"5s"`
+	// WriteFailureThreshold consecutive failed writes open the write circuit
+	WriteFailureThreshold int `yaml:"write_failure_threshold" env:"READ_ONLY_WRITE_FAILURE_THRESHOLD" env-default:"5"`
+	// CircuitCooldown is how long the write circuit stays open before writes are tried again
+	CircuitCooldown time.Duration `yaml:"circuit_cooldown" env:"READ_ONLY_CIRCUIT_COOLDOWN" env-default:"30s"`
+}
+
+// SchemaCheck compares the migrations embedded in the binary with the ones applied to the database at boot.
+type SchemaCheck struct {
+	// OnMismatch is fail (refuse to start), readonly (start in read-only mode) or ignore
+	OnMismatch string `yaml:"on_mismatch" env:"SCHEMA_CHECK_ON_MISMATCH" env-default:"fail"`
+	// VersionTable is the goose version table
+	VersionTable string `yaml:"version_table" env:"SCHEMA_CHECK_VERSION_TABLE" env-default:"goose_db_version"`
+}
+
+type AccountDeletion struct {
+	// GracePeriod is how long a deleted account can still be restored before it is purged
+	GracePeriod time.Duration `yaml:"grace_period" env:"ACCOUNT_DELETION_GRACE_PERIOD" env-default:"720h"`
+	// PurgeInterval is how often the background job purges accounts whose grace period is over
+	PurgeInterval time.Duration `yaml:"purge_interval" env:"ACCOUNT_DELETION_PURGE_INTERVAL" env-default:"1h"`
+	// DryRun makes the purge job only log the accounts it would delete
+	DryRun bool `yaml:"dry_run" env:"ACCOUNT_DELETION_DRY_RUN" env-default:"false"`
+}
+
+type DPoPConfig struct {
+	// ProofMaxAge is the accepted clock difference between the iat of a proof and now, in both directions
+	ProofMaxAge time.Duration `yaml:"proof_max_age" env:"DPOP_PROOF_MAX_AGE" env-default:"60s"`
+}
+
+// TokenVersions configures the cache of the per-user counters access tokens are checked against.
+type TokenVersions struct {
+	// CacheTTL is how long a version is cached. Without Redis each instance has its own cache and sees
+	// revocations made on other instances only after this time.
+	CacheTTL time.Duration `yaml:"cache_ttl" env:"TOKEN_VERSIONS_CACHE_TTL" env-default:"5m"`
+	// LocalTTL is how long each instance keeps the versions read from Redis in memory when the revocation channel
+	// is enabled, the channel evicts them when they are raised
+	LocalTTL time.Duration `yaml:"local_ttl" env:"TOKEN_VERSIONS_LOCAL_TTL" env-default:"30s"`
+}
+
+type EmailChange struct {
+	TokenTTL time.Duration `yaml:"token_ttl" env:"EMAIL_CHANGE_TOKEN_TTL" env-default:"24h"`
+	// URL is the confirmation link sent to the new address, the token is appended as a query parameter
+	URL string `yaml:"url" env:"EMAIL_CHANGE_URL" env-default:"http://localhost:8082/email/change/confirm"`
+}
+
+type SessionConfig struct {
+	// Store is postgres or redis, which needs redis.enabled
+	Store string `yaml:"store" env:"SESSION_STORE" env-default:"postgres"`
+	// TTL is the idle timeout, MaxLifetime ends sessions that long after login however often they are refreshed (0s never)
+	TTL              time.Duration  `yaml:"ttl" env:"SESSION_TTL" env-default:"360h"`
+	MaxLifetime      time.Duration  `yaml:"max_lifetime" env:"SESSION_MAX_LIFETIME" env-default:"0s"`
+	RotationInterval time.Duration  `yaml:"rotation_interval" env:"SESSION_ROTATION_INTERVAL" env-default:"0s"`
+	IPChange         IPChangePolicy `yaml:"ip_change"`
+	// Attestation is off, observe or require, see app_attestation
+	Attestation string `yaml:"attestation" env:"SESSION_ATTESTATION" env-default:"off"`
+	// Binding compares refreshes with the user agent and IP network of the login: off, warn or enforce
+	Binding string `yaml:"binding" env:"SESSION_BINDING" env-default:"off"`
+	// Policies overrides ttl, max_lifetime, rotation_interval, ip_change, attestation and binding per client type (web, mobile, cli, service)
+	Policies map[string]SessionPolicy `yaml:"policies"`
+	Canary   RefreshCanary            `yaml:"refresh_canary"`
+}
+
+// CookieConfig holds the attributes of the refresh token cookie, the same for every endpoint setting or clearing it.
+type CookieConfig struct {
+	Name string `yaml:"name" env:"COOKIE_NAME" env-default:"refresh_token"`
+	// Domain is overridden by the cookie domain of a tenant, empty sets host-only cookies
+	Domain string `yaml:"domain" env:"COOKIE_DOMAIN"`
+	Path   string `yaml:"path" env:"COOKIE_PATH" env-default:"/"`
+	// SameSite is lax, strict or none (needs secure), empty leaves it to the browser
+	SameSite string `yaml:"same_site" env:"COOKIE_SAME_SITE" env-default:"lax"`
+	Secure   bool   `yaml:"secure" env:"COOKIE_SECURE" env-default:"true"`
+}
+
+// RefreshCanary issues a decoy refresh token with every session that is never given to the client.
+// Its use means the sessions table leaked, Action is log or revoke_user (every session of the user ends).
+type RefreshCanary struct {
+	Enabled bool   `yaml:"enabled" env:"SESSION_REFRESH_CANARY_ENABLED" env-default:"false"`
+	Action  string `yaml:"action" env:"SESSION_REFRESH_CANARY_ACTION" env-default:"revoke_user"`
+}
+
+type SessionPolicy struct {
+	TTL              time.Duration `yaml:"ttl"`
+	MaxLifetime      time.Duration `yaml:"max_lifetime"`
+	RotationInterval time.Duration `yaml:"rotation_interval"`
+	// IPChange falls back to the default policy when its action is empty
+	IPChange IPChangePolicy `yaml:"ip_change"`
+	// Attestation and Binding fall back to the default policy when empty
+	Attestation string `yaml:"attestation"`
+	Binding     string `yaml:"binding"`
+}
+
+// IPChangePolicy configures the reaction to a session refreshed from another IP address: ignore, log or reauth.
+type IPChangePolicy struct {
+	Action string `yaml:"action" env:"SESSION_IP_CHANGE_ACTION" env-default:"log"`
+	// TolerateSameNetwork ignores moves within the same /24 or /48 network
+	TolerateSameNetwork bool `yaml:"tolerate_same_network" env:"SESSION_IP_CHANGE_TOLERATE_SAME_NETWORK" env-default:"false"`
+}
+
+// PasswordHashing selects the algorithm and parameters of new password hashes. Hashes of another
+// algorithm or other parameters keep working and are rehashed on the next login.
+type PasswordHashing struct {
+	// Algorithm is bcrypt or argon2id
+	Algorithm  string `yaml:"algorithm" env:"PASSWORD_HASH_ALGORITHM" env-default:"bcrypt"`
+	BcryptCost int    `yaml:"bcrypt_cost" env:"PASSWORD_BCRYPT_COST" env-default:"10"`
+	// Argon2Memory is in KiB
+	Argon2Memory      uint32 `yaml:"argon2_memory" env:"PASSWORD_ARGON2_MEMORY" env-default:"65536"`
+	Argon2Iterations  uint32 `yaml:"argon2_iterations" env:"PASSWORD_ARGON2_ITERATIONS" env-default:"3"`
+	Argon2Parallelism uint8  `yaml:"argon2_parallelism" env:"PASSWORD_ARGON2_PARALLELISM" env-default:"2"`
+	// Fallback is the algorithm of users outside the rollout of the password_hashing feature, empty hashes every
+	// password with Algorithm. Hashes are converted at the next login of their user.
+	Fallback string `yaml:"fallback" env:"PASSWORD_HASH_FALLBACK"`
+}
+
+// BreachCheck looks new passwords up in Have I Been Pwned (k-anonymity range API).
+type BreachCheck struct {
+	// Mode is off, warn (accept with a warning) or strict (reject)
+	Mode   string `yaml:"mode" env:"BREACH_CHECK_MODE" env-default:"off"`
+	APIURL string `yaml:"api_url" env:"BREACH_CHECK_API_URL" env-default:"https://api.pwnedpasswords.com"`
+	// RangeDir switches to offline lookups in downloaded range files (<PREFIX>.txt), no requests are made
+	RangeDir  string        `yaml:"range_dir" env:"BREACH_CHECK_RANGE_DIR"`
+	Timeout   time.Duration `yaml:"timeout" env:"BREACH_CHECK_TIMEOUT" env-default:"2s"`
+	CacheTTL  time.Duration `yaml:"cache_ttl" env:"BREACH_CHECK_CACHE_TTL" env-default:"1h"`
+	CacheSize int           `yaml:"cache_size" env:"BREACH_CHECK_CACHE_SIZE" env-default:"10000"`
+	// Attempts of range requests failing with network or server errors, the timeout applies to each
+	Attempts int `yaml:"attempts" env:"BREACH_CHECK_ATTEMPTS" env-default:"2"`
+}
+
+// EnumerationProtection makes registration, login and forgot-password answer alike whether or not the account
+// exists: unknown logins fail like wrong passwords after a password check, a taken email registers "successfully"
+// while its owner is emailed, and emails are sent after the response. Disable it to report them explicitly.
+type EnumerationProtection struct {
+	Enabled bool `yaml:"enabled" env:"ENUMERATION_PROTECTION_ENABLED" env-default:"true"`
+}
+
+// Registration controls who may create an account.
+type Registration struct {
+	// Enabled false closes registration entirely, accounts can then only be imported
+	Enabled bool `yaml:"enabled" env:"REGISTRATION_ENABLED" env-default:"true"`
+	// AllowedDomains restricts registration to emails of these domains (e.g. company.com), any domain when empty
+	AllowedDomains []string `yaml:"allowed_domains" env:"REGISTRATION_ALLOWED_DOMAINS" env-separator:","`
+	// BlockDisposable rejects emails of throwaway providers, see DisposableEmail
+	BlockDisposable bool            `yaml:"block_disposable" env:"REGISTRATION_BLOCK_DISPOSABLE" env-default:"false"`
+	DisposableEmail DisposableEmail `yaml:"disposable"`
+	// InviteOnly requires an invite code created by an administrator on every registration
+	InviteOnly bool `yaml:"invite_only" env:"REGISTRATION_INVITE_ONLY" env-default:"false"`
+	// InviteDefaultTTL applies when the administrator does not set one, InviteMaxTTL caps it
+	InviteDefaultTTL time.Duration `yaml:"invite_default_ttl" env:"REGISTRATION_INVITE_DEFAULT_TTL" env-default:"168h"`
+	InviteMaxTTL     time.Duration `yaml:"invite_max_ttl" env:"REGISTRATION_INVITE_MAX_TTL" env-default:"720h"`
+}
+
+// EmailNormalization controls how emails are compared. Emails are always trimmed and lowercased.
+type EmailNormalization struct {
+	// CollapseGmail treats Gmail addresses differing only in dots or a +tag as the same account.
+	// Accounts registered before it was enabled keep their canonical email.
+	CollapseGmail bool `yaml:"collapse_gmail" env:"EMAIL_COLLAPSE_GMAIL" env-default:"false"`
+}
+
+// DisposableEmail configures the blocklist of throwaway email domains. The bundled list is always used,
+// Source (a file path or http(s) URL) adds to it and is reloaded every RefreshInterval.
+type DisposableEmail struct {
+	Source          string        `yaml:"source" env:"REGISTRATION_DISPOSABLE_SOURCE"`
+	RefreshInterval time.Duration `yaml:"refresh_interval" env:"REGISTRATION_DISPOSABLE_REFRESH_INTERVAL" env-default:"24h"`
+}
+
+type PasswordReset struct {
+	TokenTTL time.Duration `yaml:"token_ttl" env:"PASSWORD_RESET_TOKEN_TTL" env-default:"1h"`
+	// URL is the page of the frontend that asks for the new password, the token is appended as a query parameter
+	URL string `yaml:"url" env:"PASSWORD_RESET_URL" env-default:"http://localhost:3000/reset-password"`
+	// StartSession lets clients ask the reset completion to log the user in, instead of sending them back to the login
+	StartSession bool `yaml:"start_session" env:"PASSWORD_RESET_START_SESSION" env-default:"false"`
+}
+
+// MailerConfig configures the SMTP relay. Emails are only logged when Host is empty, otherwise they are queued
+// and sent in the background through the relay, or through the fallback relay while the relay fails.
+type MailerConfig struct {
+	Host     string        `yaml:"host" env:"SMTP_HOST"`
+	Port     int           `yaml:"port" env:"SMTP_PORT" env-default:"587"`
+	Username string        `yaml:"username" env:"SMTP_USERNAME"`
+	Password string        `yaml:"password" env:"SMTP_PASSWORD"`
+	From     string        `yaml:"from" env:"SMTP_FROM" env-default:"no-reply@localhost"`
+	Timeout  time.Duration `yaml:"timeout" env:"SMTP_TIMEOUT" env-default:"30s"`
+	Fallback SMTPFallback  `yaml:"fallback"`
+	Queue    MailQueue     `yaml:"queue"`
+}
+
+// SMTPFallback is the secondary SMTP relay, disabled without a host. From defaults to the one of the relay.
+type SMTPFallback struct {
+	Host     string `yaml:"host" env:"SMTP_FALLBACK_HOST"`
+	Port     int    `yaml:"port" env:"SMTP_FALLBACK_PORT" env-default:"587"`
+	Username string `yaml:"username" env:"SMTP_FALLBACK_USERNAME"`
+	Password string `yaml:"password" env:"SMTP_FALLBACK_PASSWORD"`
+	From     string `yaml:"from" env:"SMTP_FALLBACK_FROM"`
+}
+
+// MailQueue throttles and retries the emails sent through the SMTP relays.
+type MailQueue struct {
+	// Size is the number of emails waiting to be sent, further ones fail to be sent
+	Size int `yaml:"size" env:"MAIL_QUEUE_SIZE" env-default:"10000"`
+	// Rate is the number of emails sent per second, 0 is unlimited. Burst is how many are sent at once after a pause.
+	Rate  float64 `yaml:"rate" env:"MAIL_QUEUE_RATE" env-default:"10"`
+	Burst int     `yaml:"burst" env:"MAIL_QUEUE_BURST" env-default:"10"`
+	// Attempts and Backoff are how an email is retried with a relay before the fallback relay is tried
+	Attempts int           `yaml:"attempts" env:"MAIL_QUEUE_ATTEMPTS" env-default:"3"`
+	Backoff  time.Duration `yaml:"backoff" env:"MAIL_QUEUE_BACKOFF" env-default:"1s"`
+	// FailoverCooldown is how long the relay is skipped for the fallback relay after it failed
+	FailoverCooldown time.Duration `yaml:"failover_cooldown" env:"MAIL_QUEUE_FAILOVER_COOLDOWN" env-default:"1m"`
+}
+
+type EmailVerification struct {
+	// Required blocks login until the email is verified
+	Required bool          `yaml:"required" env:"EMAIL_VERIFICATION_REQUIRED" env-default:"false"`
+	TokenTTL time.Duration `yaml:"token_ttl" env:"EMAIL_VERIFICATION_TOKEN_TTL" env-default:"24h"`
+	// URL is the link sent in the email, the token is appended as a query parameter
+	URL string `yaml:"url" env:"EMAIL_VERIFICATION_URL" env-default:"http://localhost:8082/verify-email"`
+}
+
+// IssuanceReceipts configures signed receipts of issued tokens, returned on login and refresh and stored for disputes.
+type IssuanceReceipts struct {
+	Enabled bool `yaml:"enabled" env:"ISSUANCE_RECEIPTS_ENABLED" env-default:"false"`
+	// SigningKey is the base64 encoded 32 byte Ed25519 seed, required when enabled. Receipts signed
+	// with a previous key can no longer be verified, keep old keys for as long as disputes are possible.
+	SigningKey string `yaml:"signing_key" env:"ISSUANCE_RECEIPTS_SIGNING_KEY"`
+}
+
+// SMSConfig selects the provider of text messages, messages are only logged with the log provider.
+type SMSConfig struct {
+	Provider         string        `yaml:"provider" env:"SMS_PROVIDER" env-default:"log"`
+	TwilioAccountSID string        `yaml:"twilio_account_sid" env:"SMS_TWILIO_ACCOUNT_SID"`
+	TwilioAuthToken  string        `yaml:"twilio_auth_token" env:"SMS_TWILIO_AUTH_TOKEN"`
+	From             string        `yaml:"from" env:"SMS_FROM"`
+	Timeout          time.Duration `yaml:"timeout" env:"SMS_TIMEOUT" env-default:"5s"`
+}
+
+// PhoneOTP configures login with a one-time code sent by SMS.
+type PhoneOTP struct {
+	Enabled    bool          `yaml:"enabled" env:"PHONE_OTP_ENABLED" env-default:"false"`
+	TTL        time.Duration `yaml:"ttl" env:"PHONE_OTP_TTL" env-default:"5m"`
+	CodeLength int           `yaml:"code_length" env:"PHONE_OTP_CODE_LENGTH" env-default:"6"`
+	// MaxAttempts wrong codes invalidate the code, a new one has to be requested
+	MaxAttempts    int           `yaml:"max_attempts" env:"PHONE_OTP_MAX_ATTEMPTS" env-default:"5"`
+	ResendCooldown time.Duration `yaml:"resend_cooldown" env:"PHONE_OTP_RESEND_COOLDOWN" env-default:"30s"`
+}
+
+// MFA configures two-factor authentication of password logins with one-time codes. Users choose
+// their method, disabling it lets users who turned it on log in with the password alone.
+type MFA struct {
+	Enabled    bool          `yaml:"enabled" env:"MFA_ENABLED" env-default:"false"`
+	TTL        time.Duration `yaml:"ttl" env:"MFA_TTL" env-default:"10m"`
+	CodeLength int           `yaml:"code_length" env:"MFA_CODE_LENGTH" env-default:"6"`
+	// MaxAttempts wrong codes end the challenge, the user has to log in again
+	MaxAttempts    int           `yaml:"max_attempts" env:"MFA_MAX_ATTEMPTS" env-default:"5"`
+	ResendCooldown time.Duration `yaml:"resend_cooldown" env:"MFA_RESEND_COOLDOWN" env-default:"30s"`
+	// MaxSends is the number of codes one login can send, including the first
+	MaxSends int `yaml:"max_sends" env:"MFA_MAX_SENDS" env-default:"5"`
+	// TrustedDeviceTTL is how long "trust this device" skips the second factor, 0s disables trusted devices
+	TrustedDeviceTTL time.Duration `yaml:"trusted_device_ttl" env:"MFA_TRUSTED_DEVICE_TTL" env-default:"720h"`
+	// TrustedDeviceKey is the base64 encoded HMAC key of the device cookies, at least 32 bytes, required
+	// with trusted devices. Changing it revokes every trusted device.
+	TrustedDeviceKey string `yaml:"trusted_device_key" env:"MFA_TRUSTED_DEVICE_KEY"`
+}
+
+// LoginRisk compares logins with the recent sessions of the user and flags new devices, networks and countries.
+// The assessment is stored with the session, risky password logins can additionally be stepped up.
+type LoginRisk struct {
+	Enabled bool `yaml:"enabled" env:"LOGIN_RISK_ENABLED" env-default:"true"`
+	// History is the number of recent sessions of the user a login is compared with
+	History int `yaml:"history" env:"LOGIN_RISK_HISTORY" env-default:"50"`
+	// CountryDatabase is a CSV file of start_ip,end_ip,country_code ranges, new countries are not detected without it.
+	// geoip.database replaces it.
+	CountryDatabase string `yaml:"country_database" env:"LOGIN_RISK_COUNTRY_DATABASE"`
+	// StepUp is none, mfa (the second factor even on trusted devices) or email (mfa, and an email code for
+	// users without a second factor). It requires mfa.enabled.
+	StepUp string `yaml:"step_up" env:"LOGIN_RISK_STEP_UP" env-default:"none"`
+	// StepUpLevel is the lowest risk level that steps up: medium or high
+	StepUpLevel string `yaml:"step_up_level" env:"LOGIN_RISK_STEP_UP_LEVEL" env-default:"high"`
+}
+
+// AppAttestation verifies Google Play Integrity and Apple App Attest tokens of the mobile apps at login and refresh.
+// How strictly is set per client type by sessions.attestation, a platform without configuration rejects its tokens.
+type AppAttestation struct {
+	Enabled bool `yaml:"enabled" env:"APP_ATTESTATION_ENABLED" env-default:"false"`
+	// ChallengeSecret is the base64 encoded HMAC key of the challenges, at least 32 bytes, required when enabled
+	ChallengeSecret string        `yaml:"challenge_secret" env:"APP_ATTESTATION_CHALLENGE_SECRET"`
+	ChallengeTTL    time.Duration `yaml:"challenge_ttl" env:"APP_ATTESTATION_CHALLENGE_TTL" env-default:"5m"`
+	Android         PlayIntegrity `yaml:"android"`
+	IOS             AppAttest     `yaml:"ios"`
+}
+
+// PlayIntegrity holds the response encryption keys of the android app from the Play Console.
+type PlayIntegrity struct {
+	PackageName string `yaml:"package_name" env:"APP_ATTESTATION_ANDROID_PACKAGE_NAME"`
+	// DecryptionKey is the base64 encoded AES key, VerificationKey the base64 encoded DER public key
+	DecryptionKey   string        `yaml:"decryption_key" env:"APP_ATTESTATION_ANDROID_DECRYPTION_KEY"`
+	VerificationKey string        `yaml:"verification_key" env:"APP_ATTESTATION_ANDROID_VERIFICATION_KEY"`
+	MaxAge          time.Duration `yaml:"max_age" env:"APP_ATTESTATION_ANDROID_MAX_AGE" env-default:"5m"`
+}
+
+// AppAttest identifies the ios app and the root certificate of its attestations.
+type AppAttest struct {
+	// AppID is the team ID and bundle ID, like ABCDE12345.com.example.app
+	AppID string `yaml:"app_id" env:"APP_ATTESTATION_IOS_APP_ID"`
+	// RootCA is the PEM file of the Apple App Attestation Root CA
+	RootCA string `yaml:"root_ca" env:"APP_ATTESTATION_IOS_ROOT_CA"`
+	// Development accepts keys of the development environment instead of production ones
+	Development bool `yaml:"development" env:"APP_ATTESTATION_IOS_DEVELOPMENT" env-default:"false"`
+}
+
+// Captcha requires a solved CAPTCHA on registration and on password logins from IPs with repeated failed logins.
+// Failed logins are counted in the rate limiter store.
+type Captcha struct {
+	// Provider is recaptcha (v3), hcaptcha or turnstile, empty disables CAPTCHAs
+	Provider string `yaml:"provider" env:"CAPTCHA_PROVIDER"`
+	// Secret is the server-side secret key of the site, required with a provider
+	Secret string `yaml:"secret" env:"CAPTCHA_SECRET"`
+	// MinScore is the lowest accepted reCAPTCHA v3 score
+	MinScore float64       `yaml:"min_score" env:"CAPTCHA_MIN_SCORE" env-default:"0.5"`
+	Timeout  time.Duration `yaml:"timeout" env:"CAPTCHA_TIMEOUT" env-default:"5s"`
+	// Register requires a CAPTCHA on every registration
+	Register bool `yaml:"register" env:"CAPTCHA_REGISTER" env-default:"false"`
+	// LoginAfterFailures requires a CAPTCHA on logins from an IP with that many failed logins in FailureWindow,
+	// 0 never requires one
+	LoginAfterFailures int           `yaml:"login_after_failures" env:"CAPTCHA_LOGIN_AFTER_FAILURES" env-default:"0"`
+	FailureWindow      time.Duration `yaml:"failure_window" env:"CAPTCHA_FAILURE_WINDOW" env-default:"15m"`
+}
+
+// GeoIP locates the IP of logins. Sessions and the login history store the country and city, the risk
+// assessment flags new countries.
+type GeoIP struct {
+	// Database is a MaxMind DB file (.mmdb, e.g. GeoLite2 City or DB-IP Lite) or a CSV of DB-IP Lite country
+	// or city ranges, empty falls back to login_risk.country_database
+	Database string `yaml:"database" env:"GEOIP_DATABASE"`
+	// BlockedCountries are ISO 3166-1 alpha-2 codes of countries logins are refused from, it requires a database
+	BlockedCountries []string `yaml:"blocked_countries" env:"GEOIP_BLOCKED_COUNTRIES" env-separator:","`
+}
+
+// Passkeys configures sign-in with WebAuthn credentials.
+type Passkeys struct {
+	Enabled bool `yaml:"enabled" env:"PASSKEYS_ENABLED" env-default:"false"`
+	// RPID is the domain passkeys are bound to, the origins must be on it or a subdomain
+	RPID    string   `yaml:"rp_id" env:"PASSKEYS_RP_ID" env-default:"localhost"`
+	RPName  string   `yaml:"rp_name" env:"PASSKEYS_RP_NAME" env-default:"Auth"`
+	Origins []string `yaml:"origins" env:"PASSKEYS_ORIGINS" env-separator:","`
+	// UserVerification is required or preferred
+	UserVerification string        `yaml:"user_verification" env:"PASSKEYS_USER_VERIFICATION" env-default:"preferred"`
+	Timeout          time.Duration `yaml:"timeout" env:"PASSKEYS_TIMEOUT" env-default:"5m"`
+	MaxPerUser       int           `yaml:"max_per_user" env:"PASSKEYS_MAX_PER_USER" env-default:"10"`
+}
+
+// SecretRotation configures the age warnings of keys and certificates. Keys carry no issue date,
+// the RotatedAt dates (RFC 3339) are updated together with the key, keys without one are not tracked.
+// Certificates are dated by their validity period.
+type SecretRotation struct {
+	MaxAge        time.Duration `yaml:"max_age" env:"SECRET_ROTATION_MAX_AGE" env-default:"2160h"`
+	WarnBefore    time.Duration `yaml:"warn_before" env:"SECRET_ROTATION_WARN_BEFORE" env-default:"168h"`
+	CheckInterval time.Duration `yaml:"check_interval" env:"SECRET_ROTATION_CHECK_INTERVAL" env-default:"1h"`
+
+	JWTSecretRotatedAt         string `yaml:"jwt_secret_rotated_at" env:"JWT_SECRET_ROTATED_AT"`
+	JWTEncryptionKeyRotatedAt  string `yaml:"jwt_encryption_key_rotated_at" env:"JWT_ENCRYPTION_KEY_ROTATED_AT"`
+	ReceiptSigningKeyRotatedAt string `yaml:"receipt_signing_key_rotated_at" env:"ISSUANCE_RECEIPTS_SIGNING_KEY_ROTATED_AT"`
+}
+
+// AdminUI serves the embedded admin console under /admin/ui, it requires the user.read permission.
+type AdminUI struct {
+	Enabled bool `yaml:"enabled" env:"ADMIN_UI_ENABLED" env-default:"true"`
+}
+
+// Organizations configures the tenant lifecycle.
+type Organizations struct {
+	// PurgeDelay is how long a deleted organization is kept before it is purged
+	PurgeDelay time.Duration `yaml:"purge_delay" env:"ORGANIZATIONS_PURGE_DELAY" env-default:"720h"`
+	// PurgeInterval is how often the background job purges organizations whose purge delay is over
+	PurgeInterval time.Duration `yaml:"purge_interval" env:"ORGANIZATIONS_PURGE_INTERVAL" env-default:"1h"`
+	// DryRun makes the purge job only log the organizations it would delete
+	DryRun bool `yaml:"dry_run" env:"ORGANIZATIONS_DRY_RUN" env-default:"false"`
+}
+
+// Terms controls the acceptance of the terms of service and the privacy policy.
+type Terms struct {
+	// RequireAcceptance rejects registrations and logins until the current versions that require acceptance are accepted
+	RequireAcceptance bool `yaml:"require_acceptance" env:"TERMS_REQUIRE_ACCEPTANCE" env-default:"false"`
+}
+
+// Handles reserves usernames on top of the built-in list (admin, support, security...), administrators ban
+// further usernames and emails at runtime through /admin/banned-handles.
+type Handles struct {
+	Reserved []string `yaml:"reserved" env:"HANDLES_RESERVED" env-separator:","`
+}
+
+// UserMetadata limits the app-specific attributes stored per user.
+type UserMetadata struct {
+	// MaxBytes is the maximum size of the metadata object serialized as JSON
+	MaxBytes int `yaml:"max_bytes" env:"USER_METADATA_MAX_BYTES" env-default:"16384"`
+	// MaxKeys is the maximum number of top-level keys
+	MaxKeys int `yaml:"max_keys" env:"USER_METADATA_MAX_KEYS" env-default:"64"`
+}
+
+// IDConfig selects how primary keys of new records are generated.
+type IDConfig struct {
+	// UserIDStrategy is uuidv4 (random), uuidv7 or ulid (time-ordered, better index locality).
+	// Existing IDs and IDs kept by user imports are not affected.
+	UserIDStrategy string `yaml:"user_id_strategy" env:"IDS_USER_ID_STRATEGY" env-default:"uuidv4"`
+}
+
+// Tenants lets organizations serve the hosted auth endpoints under their own domain (white-label).
+type Tenants struct {
+	Domains []TenantDomain `yaml:"domains"`
+}
+
+type TenantDomain struct {
+	Host string `yaml:"host"`
+	// Issuer is the iss claim of tokens issued on the domain and the issuer in its metadata
+	Issuer string `yaml:"issuer"`
+	// CookieDomain scopes the cookies set on the domain, host-only when empty
+	CookieDomain string `yaml:"cookie_domain"`
+	// CertFile and KeyFile are the TLS certificate of the domain, served by SNI when the server serves HTTPS
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ReservedHandles are usernames reserved on this domain only, on top of handles.reserved
+	ReservedHandles []string `yaml:"reserved_handles"`
+}
+
+// PublicConfig configures the unauthenticated documents (/version, OAuth server metadata).
+type PublicConfig struct {
+	// Issuer is the external base URL of the service, advertised in the metadata
+	Issuer string `yaml:"issuer" env:"PUBLIC_ISSUER" env-default:"http://localhost:8082"`
+	// CacheMaxAge is how long browsers, CDNs and verifying services may cache the documents
+	CacheMaxAge time.Duration `yaml:"cache_max_age" env:"PUBLIC_CACHE_MAX_AGE" env-default:"1h"`
+}
+
+type AuthzConfig struct {
+	// CacheMaxAge caps how long gateways may cache an allow decision. Tokens stay valid until expiry,
+	// but blocking a user only takes effect once the cached decision expires.
+	CacheMaxAge time.Duration    `yaml:"cache_max_age" env:"AUTHZ_CACHE_MAX_AGE" env-default:"30s"`
+	DecisionLog AuthzDecisionLog `yaml:"decision_log"`
+}
+
+// AuthzDecisionLog logs sampled permission checks of the admin API with the subject, resource, action,
+// matched role and latency, for debugging authorization in production.
+type AuthzDecisionLog struct {
+	Enabled bool `yaml:"enabled" env:"AUTHZ_DECISION_LOG_ENABLED" env-default:"false"`
+	// AllowSampleRate and DenySampleRate are the fractions (0 to 1) of allowed and denied decisions logged
+	AllowSampleRate float64 `yaml:"allow_sample_rate" env:"AUTHZ_DECISION_LOG_ALLOW_SAMPLE_RATE" env-default:"0.01"`
+	DenySampleRate  float64 `yaml:"deny_sample_rate" env:"AUTHZ_DECISION_LOG_DENY_SAMPLE_RATE" env-default:"1"`
 }
 
 type RedisConfig struct {
+	// Enabled can be switched off when neither the rate limiter nor anything else uses Redis,
+	// DPoP replay detection then only covers the local instance
+	Enabled  bool   `yaml:"enabled" env:"REDIS_ENABLED" env-default:"true"`
 	Addr     string `yaml:"addr" env:"REDIS_ADDR" env-default:"localhost:6379"`
 	Password string `yaml:"password" env:"REDIS_PASSWORD" env-default:""`
 	DB       int    `yaml:"db" env:"REDIS_DB" env-default:"0"`
 	// Optional: Add fields for connection pool settings, timeouts, etc.
 }
 
+// CORSConfig holds the CORS policy of every route group.
+type CORSConfig struct {
+	// Public covers the auth endpoints used by browser apps (login, register, refresh, /me...)
+	Public CORSPolicy `yaml:"public" env-prefix:"CORS_PUBLIC_"`
+	// Admin covers the admin API under /admin
+	Admin CORSPolicy `yaml:"admin" env-prefix:"CORS_ADMIN_"`
+	// OAuth covers /oauth and the authorization server metadata
+	OAuth CORSPolicy `yaml:"oauth" env-prefix:"CORS_OAUTH_"`
+}
+
+// CORSPolicy configures the CORS headers of a route group. Without allowed origins no CORS headers are sent,
+// browsers then only allow same-origin requests. Empty methods and headers use the defaults of echo.
+type CORSPolicy struct {
+	AllowOrigins     []string      `yaml:"allow_origins" env:"ALLOW_ORIGINS" env-separator:"," env-default:"*"`
+	AllowMethods     []string      `yaml:"allow_methods" env:"ALLOW_METHODS" env-separator:","`
+	AllowHeaders     []string      `yaml:"allow_headers" env:"ALLOW_HEADERS" env-separator:","`
+	ExposeHeaders    []string      `yaml:"expose_headers" env:"EXPOSE_HEADERS" env-separator:","`
+	AllowCredentials bool          `yaml:"allow_credentials" env:"ALLOW_CREDENTIALS" env-default:"false"`
+	MaxAge           time.Duration `yaml:"max_age" env:"MAX_AGE" env-default:"0s"`
+}
+
 type RateLimiterConfig struct {
 	Limit  int           `yaml:"limit" env:"RATE_LIMITER_LIMIT" env-default:"100"`
 	Window time.Duration `yaml:"window" env:"RATE_LIMITER_WINDOW" env-default:"1m"`
+	// Store is redis, memcached or memory. The memory store is per instance, so every instance allows Limit requests
+	Store          string   `yaml:"store" env:"RATE_LIMITER_STORE" env-default:"redis"`
+	MemcachedAddrs []string `yaml:"memcached_addrs" env:"RATE_LIMITER_MEMCACHED_ADDRS" env-separator:","`
+	MemoryShards   int      `yaml:"memory_shards" env:"RATE_LIMITER_MEMORY_SHARDS" env-default:"32"`
 }
 
 type Server struct {
 	Port        int           `yaml:"port" env:"SERVER_PORT" env-default:"8082"`
 	Mode        string        `yaml:"mode" env:"SERVER_MODE" env-default:"debug"`
 	Host        string        `yaml:"host" env:"SERVER_HOST" env-default:"localhost"`
-	Timeout     time.Duration `yaml:"timeout" env:"SERVER_TIMEOUT" env-default:"15"`
-	IdleTimeout time.Duration `yaml:"idle_timeout" env:"SERVER_IDLE_TIMEOUT" env-default:"60"`
+	Timeout     time.Duration `yaml:"timeout" env:"SERVER_TIMEOUT" env-default:"15s"`
+	IdleTimeout time.Duration `yaml:"idle_timeout" env:"SERVER_IDLE_TIMEOUT" env-default:"60s"`
+	// Multiplex serves HTTP and gRPC on the HTTP port, gRPC is routed by the application/grpc content-type.
+	// The grpc section port is ignored when enabled.
+	Multiplex bool `yaml:"multiplex" env:"SERVER_MULTIPLEX" env-default:"false"`
+	// TLS serves HTTPS, the certificates of tenant domains are selected by SNI. Not supported with Multiplex.
+	TLS ServerTLS `yaml:"tls"`
+}
+
+type ServerTLS struct {
+	CertFile string `yaml:"cert_file" env:"SERVER_TLS_CERT_FILE"`
+	KeyFile  string `yaml:"key_file" env:"SERVER_TLS_KEY_FILE"`
 }
 
 type GrpcServer struct {
-	Host string `yaml:"host" env:"GRPC_HOST" env-default:"0.0.0.0"`
-	Port int    `yaml:"port" env:"GRPC_PORT" env-default:"50052"`
+	Host string  `yaml:"host" env:"GRPC_HOST" env-default:"0.0.0.0"`
+	Port int     `yaml:"port" env:"GRPC_PORT" env-default:"50052"`
+	TLS  GrpcTLS `yaml:"tls"`
+}
+
+// GrpcTLS configures TLS on the gRPC listener. Setting ClientCAFile turns on mutual TLS,
+// only clients presenting a certificate signed by that CA bundle are accepted.
+type GrpcTLS struct {
+	Enabled      bool   `yaml:"enabled" env:"GRPC_TLS_ENABLED" env-default:"false"`
+	CertFile     string `yaml:"cert_file" env:"GRPC_TLS_CERT_FILE"`
+	KeyFile      string `yaml:"key_file" env:"GRPC_TLS_KEY_FILE"`
+	ClientCAFile string `yaml:"client_ca_file" env:"GRPC_TLS_CLIENT_CA_FILE"`
+	// AllowedClients optionally restricts callers to these certificate CN/SAN values
+	AllowedClients []string `yaml:"allowed_clients" env:"GRPC_TLS_ALLOWED_CLIENTS"`
 }
 
 type JWTConfig struct {
 	Secret            string `yaml:"secret"`
 	ExpirationMinutes int    `yaml:"expiration_minutes" default:"15"`
+	// EncryptionKey is a base64 encoded 32 byte key, access tokens are encrypted as JWE when set
+	EncryptionKey string `yaml:"encryption_key" env:"JWT_ENCRYPTION_KEY"`
+	// Audience is the aud claim of all access and service tokens, the services expecting them
+	Audience []string  `yaml:"audience" env:"JWT_AUDIENCE" env-separator:","`
+	Shadow   JWTShadow `yaml:"shadow"`
+	// KeyDistribution serves the verification keys to services, POST /oauth/verification-keys
+	KeyDistribution JWTKeyDistribution `yaml:"key_distribution"`
+}
+
+// JWTKeyDistribution lets services using the SDK fetch the keys verifying tokens instead of configuring the secret.
+// The keys are only returned encrypted to a key of the requesting service.
+type JWTKeyDistribution struct {
+	Enabled bool `yaml:"enabled" env:"JWT_KEY_DISTRIBUTION_ENABLED" env-default:"false"`
+	// Scope is required in the service token of the request
+	Scope string `yaml:"scope" env:"JWT_KEY_DISTRIBUTION_SCOPE" env-default:"tokens.verify"`
+	// RefreshInterval is how long services cache the keys, shorter than secrets.rotation_grace so that they
+	// pick up a rotated secret before the replaced one stops verifying
+	RefreshInterval time.Duration `yaml:"refresh_interval" env:"JWT_KEY_DISTRIBUTION_REFRESH_INTERVAL" env-default:"5m"`
+}
+
+// JWTShadow verifies access tokens with a candidate configuration next to the current one before a migration
+// to new keys or claim rules is cut over. Only the current result counts, divergences are logged and counted.
+type JWTShadow struct {
+	Enabled bool `yaml:"enabled" env:"JWT_SHADOW_ENABLED" env-default:"false"`
+	// Secret and EncryptionKey are the ones of the candidate, the current ones when empty
+	Secret        string `yaml:"secret" env:"JWT_SHADOW_SECRET"`
+	EncryptionKey string `yaml:"encryption_key" env:"JWT_SHADOW_ENCRYPTION_KEY"`
+	// RequiredAudience makes the candidate reject tokens for none of these audiences
+	RequiredAudience []string `yaml:"required_audience" env:"JWT_SHADOW_REQUIRED_AUDIENCE" env-separator:","`
+	// RequiredClaims makes the candidate reject tokens without any of these claims, e.g. pwd_ts
+	RequiredClaims []string `yaml:"required_claims" env:"JWT_SHADOW_REQUIRED_CLAIMS" env-separator:","`
+	// SampleRate is the fraction of verifications repeated by the candidate
+	SampleRate float64 `yaml:"sample_rate" env:"JWT_SHADOW_SAMPLE_RATE" env-default:"1"`
 }
 
 // postgres config
@@ -60,8 +690,7 @@ type PostgresConfig struct {
 
 func (cfg *PostgresConfig) DSN() string {
 	return "postgres://" +
-		cfg.Username + ":" +
-		cfg.Password + "@" +
+		url.UserPassword(cfg.Username, cfg.Password).String() + "@" +
 		cfg.Host + ":" +
 		strconv.Itoa(cfg.Port) + "/" +
 		cfg.Name + "?sslmode=disable"
@@ -70,10 +699,151 @@ func (cfg *PostgresConfig) DSN() string {
 // -------------Get Config Path from Flag or Env --------------
 var configPath string
 
+// localConfigPath is the config used without -config and CONFIG_PATH.
+const localConfigPath = "configs/local.yaml"
+
 func init() {
 	flag.StringVar(&configPath, "config", "", "Path to the config file")
 }
 
+// IncidentResponse configures the bulk revocation of compromised refresh tokens and session IDs.
+type IncidentResponse struct {
+	// DenylistReload is how often the session denylist is reloaded, to reject the sessions denied on other instances
+	DenylistReload time.Duration `yaml:"denylist_reload" env:"INCIDENT_DENYLIST_RELOAD" env-default:"30s"`
+	// RevocationChannel is the Redis pub/sub channel denied sessions and raised token versions are broadcast on,
+	// empty disables the broadcast
+	RevocationChannel string   `yaml:"revocation_channel" env:"INCIDENT_REVOCATION_CHANNEL" env-default:"session_revocations"`
+	S3                S3Config `yaml:"s3"`
+}
+
+// S3Config is the object store token lists can be read from (s3://bucket/key), disabled without a region.
+// Endpoint is empty for AWS, the URL of the store for S3-compatible ones.
+type S3Config struct {
+	Endpoint        string `yaml:"endpoint" env:"S3_ENDPOINT"`
+	Region          string `yaml:"region" env:"S3_REGION"`
+	AccessKeyID     string `yaml:"access_key_id" env:"AWS_ACCESS_KEY_ID"`
+	SecretAccessKey string `yaml:"secret_access_key" env:"AWS_SECRET_ACCESS_KEY"`
+	SessionToken    string `yaml:"session_token" env:"AWS_SESSION_TOKEN"`
+}
+
+// Alerts configures the security alerts raised on anomalous auth events. Alerts are always recorded in the audit
+// log, the sink receives them in addition. Lockouts are counted in the rate limiter store.
+type Alerts struct {
+	// Sink is webhook, slack or kafka, empty only records alerts in the audit log
+	Sink string `yaml:"sink" env:"ALERTS_SINK"`
+	// URL is the webhook URL, or the base URL of the Kafka REST proxy
+	URL string `yaml:"url" env:"ALERTS_URL"`
+	// Topic is the Kafka topic alerts are produced to
+	Topic string `yaml:"topic" env:"ALERTS_TOPIC"`
+	// Secret signs the bodies of webhook requests (X-Signature-256), optional
+	Secret    string        `yaml:"secret" env:"ALERTS_SECRET"`
+	Timeout   time.Duration `yaml:"timeout" env:"ALERTS_TIMEOUT" env-default:"5s"`
+	QueueSize int           `yaml:"queue_size" env:"ALERTS_QUEUE_SIZE" env-default:"1000"`
+	// RefreshReuseGrace is how long a rotated refresh token may be presented again before it counts as reused
+	RefreshReuseGrace time.Duration `yaml:"refresh_reuse_grace" env:"ALERTS_REFRESH_REUSE_GRACE" env-default:"30s"`
+	// LockoutsAfter alerts once a user was locked out of the second factor that many times in LockoutWindow, 0 never
+	LockoutsAfter int           `yaml:"lockouts_after" env:"ALERTS_LOCKOUTS_AFTER" env-default:"3"`
+	LockoutWindow time.Duration `yaml:"lockout_window" env:"ALERTS_LOCKOUT_WINDOW" env-default:"1h"`
+	// TravelWindow alerts on logins from another country within that time of the last session, 0 never
+	TravelWindow time.Duration `yaml:"travel_window" env:"ALERTS_TRAVEL_WINDOW" env-default:"2h"`
+}
+
+// AdminElevation keeps the access tokens of admins short-lived and requires an elevated session, obtained by
+// authenticating again at POST /me/elevate, for the most sensitive admin operations.
+type AdminElevation struct {
+	Enabled bool `yaml:"enabled" env:"ADMIN_ELEVATION_ENABLED" env-default:"false"`
+	// Roles are the admin roles whose holders get access tokens of TokenTTL
+	Roles    []string      `yaml:"roles" env:"ADMIN_ELEVATION_ROLES" env-separator:"," env-default:"admin"`
+	TokenTTL time.Duration `yaml:"token_ttl" env:"ADMIN_ELEVATION_TOKEN_TTL" env-default:"5m"`
+	// Duration is how long an elevation lasts
+	Duration time.Duration `yaml:"duration" env:"ADMIN_ELEVATION_DURATION" env-default:"15m"`
+}
+
+// PIIEncryption encrypts the emails, IP addresses and user agents stored in the database with data keys, which are
+// stored wrapped with the master key. The keys are created on the first start, rows written before are encrypted
+// by authctl encrypt-pii.
+type PIIEncryption struct {
+	Enabled bool `yaml:"enabled" env:"PII_ENCRYPTION_ENABLED" env-default:"false"`
+	// MasterKey is the base64 of 32 random bytes, losing it loses the data, best kept in a secret store
+	MasterKey string `yaml:"master_key" env:"PII_MASTER_KEY" env-default:""`
+	// KeyReload is how often the data keys are read again to pick up a key added by authctl rotate-data-key
+	KeyReload time.Duration `yaml:"key_reload" env:"PII_KEY_RELOAD" env-default:"1m"`
+	// KeyActivation is how long a new data key only decrypts, so that every instance reloaded it before one
+	// encrypts with it. Must be longer than KeyReload
+	KeyActivation time.Duration `yaml:"key_activation" env:"PII_KEY_ACTIVATION" env-default:"5m"`
+}
+
+// StatusPage configures the public GET /status and the incidents admins annotate it with.
+type StatusPage struct {
+	// CacheTTL is how long the status is reused and cached by clients, the dependencies are probed at most
+	// once per TTL and instance
+	CacheTTL time.Duration `yaml:"cache_ttl" env:"STATUS_PAGE_CACHE_TTL" env-default:"30s"`
+	// RecentWindow is how long resolved incidents stay on the page
+	RecentWindow time.Duration `yaml:"recent_window" env:"STATUS_PAGE_RECENT_WINDOW" env-default:"72h"`
+}
+
+// Features configures the gradual rollouts of new auth flows, managed with /admin/features.
+type Features struct {
+	// CacheTTL is how long each instance caches the rollouts, changes reach the other instances after at most this time
+	CacheTTL time.Duration `yaml:"cache_ttl" env:"FEATURES_CACHE_TTL" env-default:"30s"`
+}
+
+// Cleanup configures the background job deleting expired sessions and old login attempts.
+type Cleanup struct {
+	Enabled bool `yaml:"enabled" env:"CLEANUP_ENABLED" env-default:"true"`
+	// Interval is how often the job runs
+	Interval time.Duration `yaml:"interval" env:"CLEANUP_INTERVAL" env-default:"1h"`
+	// BatchSize is the number of rows deleted per statement
+	BatchSize int `yaml:"batch_size" env:"CLEANUP_BATCH_SIZE" env-default:"1000"`
+	// SessionRetention keeps expired sessions this long, the login risk assessment compares new logins with them
+	SessionRetention time.Duration `yaml:"session_retention" env:"CLEANUP_SESSION_RETENTION" env-default:"720h"`
+	// LoginHistoryRetention is how long login attempts are kept, 0 keeps them forever
+	LoginHistoryRetention time.Duration `yaml:"login_history_retention" env:"CLEANUP_LOGIN_HISTORY_RETENTION" env-default:"0s"`
+	// DryRun makes the job only log the rows it would delete
+	DryRun bool `yaml:"dry_run" env:"CLEANUP_DRY_RUN" env-default:"false"`
+}
+
+// Secrets configures the stores secrets are read from instead of the YAML. jwt.secret, jwt.encryption_key,
+// jwt.shadow.secret, database.password, issuance_receipts.signing_key, privacy.fingerprint_salt and
+// pii_encryption.master_key may be set to a
+// reference (vault:<mount>/<path>#<field>, awskms:<ciphertext> or gcpkms:<key name>#<ciphertext>).
+type Secrets struct {
+	// RefreshInterval is how often jwt.secret and database.password are fetched again to pick up rotations, 0 never
+	RefreshInterval time.Duration `yaml:"refresh_interval" env:"SECRETS_REFRESH_INTERVAL" env-default:"5m"`
+	// RotationGrace is how long tokens signed with a rotated JWT secret are still accepted, at least the
+	// lifetime of access and service tokens
+	RotationGrace time.Duration `yaml:"rotation_grace" env:"SECRETS_ROTATION_GRACE" env-default:"1h"`
+	Timeout       time.Duration `yaml:"timeout" env:"SECRETS_TIMEOUT" env-default:"10s"`
+	Vault         VaultConfig   `yaml:"vault"`
+	AWSKMS        AWSKMSConfig  `yaml:"aws_kms"`
+	GCPKMS        GCPKMSConfig  `yaml:"gcp_kms"`
+}
+
+// VaultConfig is the HashiCorp Vault server of vault: references, disabled without an address.
+type VaultConfig struct {
+	Addr string `yaml:"addr" env:"VAULT_ADDR"`
+	// Token authenticates to Vault, or the token in TokenFile (read on every request, as renewed by a Vault Agent)
+	Token     string `yaml:"token" env:"VAULT_TOKEN"`
+	TokenFile string `yaml:"token_file" env:"VAULT_TOKEN_FILE"`
+	// Namespace is the Vault Enterprise namespace, empty for none
+	Namespace string `yaml:"namespace" env:"VAULT_NAMESPACE"`
+}
+
+// AWSKMSConfig is the AWS KMS region of awskms: references, disabled without a region.
+type AWSKMSConfig struct {
+	Endpoint        string `yaml:"endpoint" env:"AWS_KMS_ENDPOINT"`
+	Region          string `yaml:"region" env:"AWS_KMS_REGION"`
+	AccessKeyID     string `yaml:"access_key_id" env:"AWS_ACCESS_KEY_ID"`
+	SecretAccessKey string `yaml:"secret_access_key" env:"AWS_SECRET_ACCESS_KEY"`
+	SessionToken    string `yaml:"session_token" env:"AWS_SESSION_TOKEN"`
+}
+
+// GCPKMSConfig authenticates gcpkms: references. They are always enabled, without an access token as the
+// service account of the instance.
+type GCPKMSConfig struct {
+	AccessToken string `yaml:"access_token" env:"GCP_ACCESS_TOKEN"`
+}
+
 func fetchConfigPath() string {
 	var res string
 
@@ -87,6 +857,13 @@ func fetchConfigPath() string {
 		res = os.Getenv("CONFIG_PATH")
 	}
 
+	// go run ./cmd/app from the repository root starts in local development mode
+	if res == "" {
+		if _, err := os.Stat(localConfigPath); err == nil {
+			res = localConfigPath
+		}
+	}
+
 	if res == "" {
 		panic("config path is not provided")
 	}
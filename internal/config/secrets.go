@@ -0,0 +1,76 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"main/pkg/fieldcrypt"
+	"main/pkg/secrets"
+	"main/pkg/sigv4"
+)
+
+// SecretResolver returns the resolver of the configured secret stores.
+func (cfg Secrets) SecretResolver() (*secrets.Resolver, error) {
+	resolver := &secrets.Resolver{GCPKMS: secrets.NewGCPKMS(cfg.GCPKMS.AccessToken, cfg.Timeout)}
+	if cfg.Vault.Addr != "" {
+		vault, err := secrets.NewVault(cfg.Vault.Addr, cfg.Vault.Token, cfg.Vault.TokenFile, cfg.Vault.Namespace, cfg.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		resolver.Vault = vault
+	}
+	if cfg.AWSKMS.Region != "" {
+		kms, err := secrets.NewAWSKMS(cfg.AWSKMS.Endpoint, cfg.AWSKMS.Region, sigv4.Credentials{
+			AccessKeyID:     cfg.AWSKMS.AccessKeyID,
+			SecretAccessKey: cfg.AWSKMS.SecretAccessKey,
+			SessionToken:    cfg.AWSKMS.SessionToken,
+		}, cfg.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		resolver.AWSKMS = kms
+	}
+	return resolver, nil
+}
+
+// ResolveSecrets replaces the secret references of the config by the secrets they refer to.
+func (cfg *Config) ResolveSecrets(ctx context.Context, resolver *secrets.Resolver) error {
+	fields := []struct {
+		name  string
+		value *string
+	}{
+		{"jwt.secret", &cfg.JWTConfig.Secret},
+		{"jwt.encryption_key", &cfg.JWTConfig.EncryptionKey},
+		{"jwt.shadow.secret", &cfg.JWTConfig.Shadow.Secret},
+		{"database.password", &cfg.PostgresConfig.Password},
+		{"issuance_receipts.signing_key", &cfg.IssuanceReceipts.SigningKey},
+		{"privacy.fingerprint_salt", &cfg.PrivacyConfig.FingerprintSalt},
+		{"pii_encryption.master_key", &cfg.PIIEncryption.MasterKey},
+	}
+	for _, field := range fields {
+		if !secrets.IsReference(*field.value) {
+			continue
+		}
+		secret, err := resolver.Resolve(ctx, *field.value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", field.name, err)
+		}
+		*field.value = secret
+	}
+	return nil
+}
+
+// FieldCipher returns the cipher of the data keys in the store, creating them on the first start, and nil when
+// the encryption is disabled.
+func (cfg PIIEncryption) FieldCipher(ctx context.Context, store fieldcrypt.KeyStore) (*fieldcrypt.Cipher, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if cfg.KeyActivation <= cfg.KeyReload {
+		return nil, fmt.Errorf("pii_encryption.key_activation must be longer than key_reload")
+	}
+	master, err := fieldcrypt.ParseMasterKey(cfg.MasterKey)
+	if err != nil {
+		return nil, fmt.Errorf("pii_encryption.master_key: %w", err)
+	}
+	return fieldcrypt.Open(ctx, store, master, cfg.KeyActivation)
+}
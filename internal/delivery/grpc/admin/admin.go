@@ -0,0 +1,196 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"main/domain/entity"
+	"main/internal/delivery/grpc/grpcerr"
+	"main/pkg/customerrors"
+	authv1 "main/pkg/proto/gen/auth/v1"
+	ctxUtil "main/pkg/utils/context"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type RPCAdminHandler struct {
+	authv1.UnimplementedAdminServiceServer
+	logger       *slog.Logger
+	AdminUsecase AdminUsecase
+	OrgUsecase   OrgUsecase
+	StatsUsecase StatsUsecase
+}
+
+type AdminUsecase interface {
+	//ListUsers returns a page of users matching the filter.
+	ListUsers(ctx context.Context, filter entity.UserFilter) (entity.UserPage, error)
+
+	//GetUser returns the user with its active sessions.
+	GetUser(ctx context.Context, userID uuid.UUID) (entity.UserDetail, error)
+
+	//BlockUser blocks the user and revokes its sessions, a reason is required.
+	BlockUser(ctx context.Context, adminID, userID uuid.UUID, reason entity.AdminReason) error
+
+	//UnblockUser lets a blocked user log in again.
+	UnblockUser(ctx context.Context, adminID, userID uuid.UUID) error
+
+	//ForcePasswordReset invalidates the password of the user and emails a reset link, a reason is required.
+	ForcePasswordReset(ctx context.Context, adminID, userID uuid.UUID, reason entity.AdminReason) error
+
+	//ForceLogout revokes all sessions and access tokens of the user, a reason is required.
+	ForceLogout(ctx context.Context, adminID, userID uuid.UUID, reason entity.AdminReason, dryRun bool) (entity.AffectedReport, error)
+}
+
+func NewAdminHandler(logger *slog.Logger, adminUsecase AdminUsecase, orgUsecase OrgUsecase, statsUsecase StatsUsecase) *RPCAdminHandler {
+	return &RPCAdminHandler{
+		logger:       logger,
+		AdminUsecase: adminUsecase,
+		OrgUsecase:   orgUsecase,
+		StatsUsecase: statsUsecase,
+	}
+}
+
+// ListUsers returns a page of users, newest first.
+func (h *RPCAdminHandler) ListUsers(ctx context.Context, req *authv1.ListUsersRequest) (*authv1.ListUsersResponse, error) {
+	page, err := h.AdminUsecase.ListUsers(ctx, entity.UserFilter{
+		Query:  req.GetQuery(),
+		Limit:  int(req.GetLimit()),
+		Offset: int(req.GetOffset()),
+	})
+	if err != nil {
+		return nil, h.adminError(err, "failed to list users")
+	}
+	resp := &authv1.ListUsersResponse{Total: int32(page.Total)}
+	for _, user := range page.Users {
+		resp.Users = append(resp.Users, adminUser(user))
+	}
+	return resp, nil
+}
+
+// GetUser returns the user with its active sessions.
+func (h *RPCAdminHandler) GetUser(ctx context.Context, req *authv1.GetUserRequest) (*authv1.GetUserResponse, error) {
+	userID, err := uuid.Parse(req.GetUserId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid user ID")
+	}
+	detail, err := h.AdminUsecase.GetUser(ctx, userID)
+	if err != nil {
+		return nil, h.adminError(err, "failed to get user")
+	}
+	resp := &authv1.GetUserResponse{User: adminUser(detail.User)}
+	for _, s := range detail.Sessions {
+		resp.Sessions = append(resp.Sessions, &authv1.AdminSession{
+			SessionId:  s.ID.String(),
+			ClientType: string(s.ClientType),
+			IpAddress:  s.ClientIP.String(),
+			UserAgent:  s.UserAgent,
+			CreatedAt:  s.CreatedAt.UTC().Format(time.RFC3339),
+			ExpiresAt:  s.ExpiresAt.UTC().Format(time.RFC3339),
+		})
+	}
+	return resp, nil
+}
+
+// BlockUser blocks the user and revokes its sessions.
+func (h *RPCAdminHandler) BlockUser(ctx context.Context, req *authv1.BlockUserRequest) (*authv1.BlockUserResponse, error) {
+	adminID, userID, err := actorAndTarget(ctx, req.GetUserId())
+	if err != nil {
+		return nil, err
+	}
+	err = h.AdminUsecase.BlockUser(ctx, adminID, userID, entity.AdminReason{
+		Code: entity.AdminReasonCode(req.GetReasonCode()),
+		Text: req.GetReason(),
+	})
+	if err != nil {
+		return nil, h.adminError(err, "failed to block user")
+	}
+	return &authv1.BlockUserResponse{}, nil
+}
+
+// UnblockUser lets a blocked user log in again.
+func (h *RPCAdminHandler) UnblockUser(ctx context.Context, req *authv1.UnblockUserRequest) (*authv1.UnblockUserResponse, error) {
+	adminID, userID, err := actorAndTarget(ctx, req.GetUserId())
+	if err != nil {
+		return nil, err
+	}
+	if err := h.AdminUsecase.UnblockUser(ctx, adminID, userID); err != nil {
+		return nil, h.adminError(err, "failed to unblock user")
+	}
+	return &authv1.UnblockUserResponse{}, nil
+}
+
+// ForcePasswordReset invalidates the password of the user and emails it a reset link.
+func (h *RPCAdminHandler) ForcePasswordReset(ctx context.Context, req *authv1.ForcePasswordResetRequest) (*authv1.ForcePasswordResetResponse, error) {
+	adminID, userID, err := actorAndTarget(ctx, req.GetUserId())
+	if err != nil {
+		return nil, err
+	}
+	err = h.AdminUsecase.ForcePasswordReset(ctx, adminID, userID, entity.AdminReason{
+		Code: entity.AdminReasonCode(req.GetReasonCode()),
+		Text: req.GetReason(),
+	})
+	if err != nil {
+		return nil, h.adminError(err, "failed to reset password")
+	}
+	return &authv1.ForcePasswordResetResponse{}, nil
+}
+
+// ForceLogout revokes all sessions and outstanding access tokens of the user.
+func (h *RPCAdminHandler) ForceLogout(ctx context.Context, req *authv1.ForceLogoutRequest) (*authv1.ForceLogoutResponse, error) {
+	adminID, userID, err := actorAndTarget(ctx, req.GetUserId())
+	if err != nil {
+		return nil, err
+	}
+	report, err := h.AdminUsecase.ForceLogout(ctx, adminID, userID, entity.AdminReason{
+		Code: entity.AdminReasonCode(req.GetReasonCode()),
+		Text: req.GetReason(),
+	}, req.GetDryRun())
+	if err != nil {
+		return nil, h.adminError(err, "failed to log out user")
+	}
+	return &authv1.ForceLogoutResponse{
+		DryRun:     report.DryRun,
+		SessionIds: idStrings(report.IDs),
+	}, nil
+}
+
+// actorAndTarget returns the administrator from the access token and the user the request is about.
+func actorAndTarget(ctx context.Context, userIDStr string) (adminID, userID uuid.UUID, err error) {
+	adminIDStr, ok := ctxUtil.FromContext(ctx)
+	if !ok {
+		return uuid.Nil, uuid.Nil, status.Error(codes.Unauthenticated, "user token required")
+	}
+	if adminID, err = uuid.Parse(adminIDStr); err != nil {
+		return uuid.Nil, uuid.Nil, status.Error(codes.Unauthenticated, "invalid user ID")
+	}
+	if userID, err = uuid.Parse(userIDStr); err != nil {
+		return uuid.Nil, uuid.Nil, status.Error(codes.InvalidArgument, "invalid user ID")
+	}
+	return adminID, userID, nil
+}
+
+// adminError maps the errors of admin operations to gRPC status errors.
+func (h *RPCAdminHandler) adminError(err error, msg string) error {
+	switch {
+	case errors.Is(err, customerrors.ErrReasonRequired):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, customerrors.ErrUserNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	}
+	h.logger.Error("Admin operation failed", "operation", msg, "error", err)
+	return grpcerr.Or(err, codes.Internal, msg)
+}
+
+func adminUser(user entity.User) *authv1.AdminUser {
+	return &authv1.AdminUser{
+		UserId:        user.ID.String(),
+		Username:      user.Username,
+		Email:         user.Email,
+		CreatedAt:     user.CreatedAt.UTC().Format(time.RFC3339),
+		IsBlocked:     user.IsBlocked,
+		EmailVerified: user.EmailVerified,
+	}
+}
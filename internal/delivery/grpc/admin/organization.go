@@ -0,0 +1,227 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"main/domain/entity"
+	"main/pkg/customerrors"
+	authv1 "main/pkg/proto/gen/auth/v1"
+	ctxUtil "main/pkg/utils/context"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type OrgUsecase interface {
+	//CreateOrganization creates an active organization.
+	CreateOrganization(ctx context.Context, adminID uuid.UUID, name string) (entity.Organization, error)
+
+	//GetOrganization returns the organization with its members.
+	GetOrganization(ctx context.Context, id uuid.UUID) (entity.OrganizationDetail, error)
+
+	//ListOrganizations returns a page of organizations.
+	ListOrganizations(ctx context.Context, limit, offset int) ([]entity.Organization, error)
+
+	//SuspendOrganization suspends the organization and revokes the sessions of its members, a reason is required.
+	SuspendOrganization(ctx context.Context, adminID, id uuid.UUID, reason entity.AdminReason) (entity.AffectedReport, error)
+
+	//ResumeOrganization reactivates a suspended organization.
+	ResumeOrganization(ctx context.Context, adminID, id uuid.UUID) error
+
+	//DeleteOrganization deletes the organization, revokes the sessions of its members and schedules the purge of its data.
+	DeleteOrganization(ctx context.Context, adminID, id uuid.UUID, reason entity.AdminReason) (entity.AffectedReport, error)
+
+	//AddMember adds the user to the organization.
+	AddMember(ctx context.Context, adminID, id, userID uuid.UUID) error
+
+	//RemoveMember removes the user from the organization.
+	RemoveMember(ctx context.Context, adminID, id, userID uuid.UUID) error
+}
+
+// CreateOrganization creates an organization without members.
+func (h *RPCAdminHandler) CreateOrganization(ctx context.Context, req *authv1.CreateOrganizationRequest) (*authv1.CreateOrganizationResponse, error) {
+	adminID, err := actor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	org, err := h.OrgUsecase.CreateOrganization(ctx, adminID, req.GetName())
+	if err != nil {
+		return nil, h.orgError(err, "failed to create organization")
+	}
+	return &authv1.CreateOrganizationResponse{Organization: organization(org)}, nil
+}
+
+// GetOrganization returns the organization with the IDs of its members.
+func (h *RPCAdminHandler) GetOrganization(ctx context.Context, req *authv1.GetOrganizationRequest) (*authv1.GetOrganizationResponse, error) {
+	id, err := uuid.Parse(req.GetOrganizationId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid organization ID")
+	}
+	detail, err := h.OrgUsecase.GetOrganization(ctx, id)
+	if err != nil {
+		return nil, h.orgError(err, "failed to get organization")
+	}
+	memberIDs := make([]string, 0, len(detail.Members))
+	for _, m := range detail.Members {
+		memberIDs = append(memberIDs, m.UserID.String())
+	}
+	return &authv1.GetOrganizationResponse{
+		Organization: organization(detail.Organization),
+		MemberIds:    memberIDs,
+	}, nil
+}
+
+// ListOrganizations returns a page of organizations, newest first.
+func (h *RPCAdminHandler) ListOrganizations(ctx context.Context, req *authv1.ListOrganizationsRequest) (*authv1.ListOrganizationsResponse, error) {
+	orgs, err := h.OrgUsecase.ListOrganizations(ctx, int(req.GetLimit()), int(req.GetOffset()))
+	if err != nil {
+		return nil, h.orgError(err, "failed to list organizations")
+	}
+	resp := &authv1.ListOrganizationsResponse{Organizations: make([]*authv1.Organization, 0, len(orgs))}
+	for _, org := range orgs {
+		resp.Organizations = append(resp.Organizations, organization(org))
+	}
+	return resp, nil
+}
+
+// SuspendOrganization suspends the organization and ends the sessions of its members.
+func (h *RPCAdminHandler) SuspendOrganization(ctx context.Context, req *authv1.SuspendOrganizationRequest) (*authv1.SuspendOrganizationResponse, error) {
+	adminID, id, err := actorAndOrganization(ctx, req.GetOrganizationId())
+	if err != nil {
+		return nil, err
+	}
+	report, err := h.OrgUsecase.SuspendOrganization(ctx, adminID, id, entity.AdminReason{
+		Code: entity.AdminReasonCode(req.GetReasonCode()),
+		Text: req.GetReason(),
+	})
+	if err != nil {
+		return nil, h.orgError(err, "failed to suspend organization")
+	}
+	return &authv1.SuspendOrganizationResponse{SessionIds: idStrings(report.IDs)}, nil
+}
+
+// ResumeOrganization reactivates a suspended organization.
+func (h *RPCAdminHandler) ResumeOrganization(ctx context.Context, req *authv1.ResumeOrganizationRequest) (*authv1.ResumeOrganizationResponse, error) {
+	adminID, id, err := actorAndOrganization(ctx, req.GetOrganizationId())
+	if err != nil {
+		return nil, err
+	}
+	if err := h.OrgUsecase.ResumeOrganization(ctx, adminID, id); err != nil {
+		return nil, h.orgError(err, "failed to resume organization")
+	}
+	return &authv1.ResumeOrganizationResponse{}, nil
+}
+
+// DeleteOrganization deletes the organization, ends the sessions of its members and schedules its purge.
+func (h *RPCAdminHandler) DeleteOrganization(ctx context.Context, req *authv1.DeleteOrganizationRequest) (*authv1.DeleteOrganizationResponse, error) {
+	adminID, id, err := actorAndOrganization(ctx, req.GetOrganizationId())
+	if err != nil {
+		return nil, err
+	}
+	report, err := h.OrgUsecase.DeleteOrganization(ctx, adminID, id, entity.AdminReason{
+		Code: entity.AdminReasonCode(req.GetReasonCode()),
+		Text: req.GetReason(),
+	})
+	if err != nil {
+		return nil, h.orgError(err, "failed to delete organization")
+	}
+	return &authv1.DeleteOrganizationResponse{SessionIds: idStrings(report.IDs)}, nil
+}
+
+// AddOrganizationMember adds the user to the organization.
+func (h *RPCAdminHandler) AddOrganizationMember(ctx context.Context, req *authv1.AddOrganizationMemberRequest) (*authv1.AddOrganizationMemberResponse, error) {
+	adminID, id, err := actorAndOrganization(ctx, req.GetOrganizationId())
+	if err != nil {
+		return nil, err
+	}
+	userID, err := uuid.Parse(req.GetUserId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid user ID")
+	}
+	if err := h.OrgUsecase.AddMember(ctx, adminID, id, userID); err != nil {
+		return nil, h.orgError(err, "failed to add member")
+	}
+	return &authv1.AddOrganizationMemberResponse{}, nil
+}
+
+// RemoveOrganizationMember removes the user from the organization.
+func (h *RPCAdminHandler) RemoveOrganizationMember(ctx context.Context, req *authv1.RemoveOrganizationMemberRequest) (*authv1.RemoveOrganizationMemberResponse, error) {
+	adminID, id, err := actorAndOrganization(ctx, req.GetOrganizationId())
+	if err != nil {
+		return nil, err
+	}
+	userID, err := uuid.Parse(req.GetUserId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid user ID")
+	}
+	if err := h.OrgUsecase.RemoveMember(ctx, adminID, id, userID); err != nil {
+		return nil, h.orgError(err, "failed to remove member")
+	}
+	return &authv1.RemoveOrganizationMemberResponse{}, nil
+}
+
+// actor returns the administrator from the access token.
+func actor(ctx context.Context) (uuid.UUID, error) {
+	adminIDStr, ok := ctxUtil.FromContext(ctx)
+	if !ok {
+		return uuid.Nil, status.Error(codes.Unauthenticated, "user token required")
+	}
+	adminID, err := uuid.Parse(adminIDStr)
+	if err != nil {
+		return uuid.Nil, status.Error(codes.Unauthenticated, "invalid user ID")
+	}
+	return adminID, nil
+}
+
+// actorAndOrganization returns the administrator from the access token and the organization the request is about.
+func actorAndOrganization(ctx context.Context, orgIDStr string) (adminID, orgID uuid.UUID, err error) {
+	if adminID, err = actor(ctx); err != nil {
+		return uuid.Nil, uuid.Nil, err
+	}
+	if orgID, err = uuid.Parse(orgIDStr); err != nil {
+		return uuid.Nil, uuid.Nil, status.Error(codes.InvalidArgument, "invalid organization ID")
+	}
+	return adminID, orgID, nil
+}
+
+// orgError maps the errors of organization operations to gRPC status errors.
+func (h *RPCAdminHandler) orgError(err error, msg string) error {
+	switch {
+	case errors.Is(err, customerrors.ErrInvalidOrganizationName):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, customerrors.ErrOrganizationNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, customerrors.ErrOrganizationState):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return h.adminError(err, msg)
+}
+
+func organization(org entity.Organization) *authv1.Organization {
+	return &authv1.Organization{
+		OrganizationId: org.ID.String(),
+		Name:           org.Name,
+		Status:         string(org.Status),
+		CreatedAt:      org.CreatedAt.UTC().Format(time.RFC3339),
+		SuspendedAt:    optionalTime(org.SuspendedAt),
+		DeletedAt:      optionalTime(org.DeletedAt),
+		PurgeAt:        optionalTime(org.PurgeAt),
+	}
+}
+
+func optionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func idStrings(ids []uuid.UUID) []string {
+	s := make([]string, 0, len(ids))
+	for _, id := range ids {
+		s = append(s, id.String())
+	}
+	return s
+}
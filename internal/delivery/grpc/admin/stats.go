@@ -0,0 +1,46 @@
+package admin
+
+import (
+	"context"
+	"main/domain/entity"
+	authv1 "main/pkg/proto/gen/auth/v1"
+	"time"
+)
+
+type StatsUsecase interface {
+	//Stats returns the counts and growth rates of users, sessions, issued tokens and the denylist.
+	Stats(ctx context.Context) (entity.SystemStats, error)
+}
+
+// GetStats returns the statistics for capacity planning, to admins and to services with the stats.read scope.
+func (h *RPCAdminHandler) GetStats(ctx context.Context, req *authv1.GetStatsRequest) (*authv1.GetStatsResponse, error) {
+	stats, err := h.StatsUsecase.Stats(ctx)
+	if err != nil {
+		return nil, h.adminError(err, "failed to get stats")
+	}
+	return &authv1.GetStatsResponse{
+		Users: &authv1.UserStats{
+			Total:           stats.Users.Total,
+			Blocked:         stats.Users.Blocked,
+			CreatedLastHour: stats.Users.CreatedLastHour,
+			CreatedLastDay:  stats.Users.CreatedLastDay,
+			CreatedPerHour:  stats.Users.CreatedPerHour,
+		},
+		Sessions: &authv1.SessionStats{
+			Active:          stats.Sessions.Active,
+			StartedLastHour: stats.Sessions.StartedLastHour,
+			StartedLastDay:  stats.Sessions.StartedLastDay,
+			StartedPerHour:  stats.Sessions.StartedPerHour,
+		},
+		Tokens: &authv1.TokenStats{
+			IssuedLastMinute: stats.Tokens.IssuedLastMinute,
+			IssuedLastHour:   stats.Tokens.IssuedLastHour,
+			IssuedPerMinute:  stats.Tokens.IssuedPerMinute,
+		},
+		Denylist: &authv1.DenylistStats{
+			Entries: stats.Denylist.Entries,
+			Cached:  stats.Denylist.Cached,
+		},
+		GeneratedAt: stats.GeneratedAt.Format(time.RFC3339),
+	}, nil
+}
@@ -2,12 +2,19 @@ package grp
 
 import (
 	"context"
+	"errors"
 	"log/slog"
+	"main/domain/entity"
+	"main/internal/delivery/grpc/grpcerr"
+	"main/pkg/customerrors"
 	authv1 "main/pkg/proto/gen/auth/v1"
+	ctxUtil "main/pkg/utils/context"
 	"net"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/peer"
@@ -23,19 +30,25 @@ type RPCAuthHandler struct {
 type AuthUsecase interface {
 
 	//RegisterUser registers a new user and returns the user ID as a string.
-	RegisterUser(ctx context.Context, username, email, password string) (userID uuid.UUID, err error)
+	RegisterUser(ctx context.Context, in entity.RegisterInput) (userID uuid.UUID, warnings []string, err error)
 
 	//LoginUser authenticates a user and returns an access token.
-	LoginUser(ctx context.Context, login, password, userAgent string, ip string) (userID uuid.UUID, accessToken string, refreshToken string, err error)
+	LoginUser(ctx context.Context, in entity.LoginInput) (entity.IssuedTokens, error)
+
+	//CompleteMFALogin finishes a login with the code of its second factor.
+	CompleteMFALogin(ctx context.Context, in entity.MFALoginInput) (entity.IssuedTokens, error)
 
 	//LogoutSession logs out a user from a specific session.
 	LogoutSession(ctx context.Context, userID string, sessionID string) error
 
-	//LogoutAllSessions logs out a user from all sessions.
-	LogoutAllSessions(ctx context.Context, userID string) error
+	//LogoutAllSessions logs out a user from all sessions, in dry-run mode it only reports the sessions.
+	LogoutAllSessions(ctx context.Context, userID string, dryRun bool) (entity.AffectedReport, error)
 
 	//RefreshSessionToken refreshes the session token for a user and returns the new access token and refresh token.
-	RefreshSessionToken(ctx context.Context, refreshToken string) (string, string, error)
+	RefreshSessionToken(ctx context.Context, in entity.RefreshInput) (entity.IssuedTokens, error)
+
+	//GetProfile returns the record of the user.
+	GetProfile(ctx context.Context, userID uuid.UUID) (entity.User, error)
 }
 
 func NewAuthHandler(logger *slog.Logger, authUsecase AuthUsecase) *RPCAuthHandler {
@@ -48,44 +61,175 @@ func NewAuthHandler(logger *slog.Logger, authUsecase AuthUsecase) *RPCAuthHandle
 
 // RegisterUser registers a new user and returns the user ID.
 func (h *RPCAuthHandler) Register(ctx context.Context, req *authv1.RegisterRequest) (*authv1.RegisterResponse, error) {
-	userID, err := h.AuthUsecase.RegisterUser(ctx, req.Username, req.Email, req.Password)
+	acceptedTerms, err := documentIDs(req.GetAcceptTerms())
 	if err != nil {
+		return nil, err
+	}
+	userID, warnings, err := h.AuthUsecase.RegisterUser(ctx, entity.RegisterInput{
+		Username:      req.GetUsername(),
+		Email:         req.GetEmail(),
+		Password:      req.GetPassword(),
+		Phone:         req.GetPhone(),
+		InviteCode:    req.GetInviteCode(),
+		AcceptedTerms: acceptedTerms,
+		IP:            getClientIP(ctx),
+		CaptchaToken:  firstMetadata(ctx, captchaTokenKey),
+	})
+	if err != nil {
+		if errors.Is(err, customerrors.ErrPasswordBreached) || errors.Is(err, customerrors.ErrInvalidPhone) ||
+			errors.Is(err, customerrors.ErrInvalidTermsDocument) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if errors.Is(err, customerrors.ErrTermsNotAccepted) {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+		if errors.Is(err, customerrors.ErrEmailTaken) || errors.Is(err, customerrors.ErrPhoneTaken) ||
+			errors.Is(err, customerrors.ErrUsernameTaken) {
+			return nil, status.Error(codes.AlreadyExists, err.Error())
+		}
+		if errors.Is(err, customerrors.ErrInvalidInvite) || errors.Is(err, customerrors.ErrRegistrationClosed) ||
+			errors.Is(err, customerrors.ErrEmailDomainNotAllowed) || errors.Is(err, customerrors.ErrDisposableEmail) ||
+			errors.Is(err, customerrors.ErrHandleUnavailable) || errors.Is(err, customerrors.ErrCaptchaRequired) {
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
 		h.logger.Error("Failed to register user", "error", err)
-		return nil, status.Error(codes.Internal, "failed to register user")
+		return nil, grpcerr.Or(err, codes.Internal, "failed to register user")
 	}
 	return &authv1.RegisterResponse{
-		UserId: userID.String()}, nil
+		UserId:   userID.String(),
+		Warnings: warnings}, nil
 
 }
 
 // LoginUser authenticates the user and returns an access token if successful.
 func (h *RPCAuthHandler) Login(ctx context.Context, req *authv1.LoginRequest) (*authv1.LoginResponse, error) {
+	if req.GetMfaChallengeId() != "" {
+		return h.completeMFALogin(ctx, req)
+	}
 	if req.GetLogin() == "" || req.GetPassword() == "" {
 		h.logger.Error("Login or password is empty")
 		return nil, status.Error(codes.InvalidArgument, "login or password is empty")
 	}
+	acceptedTerms, err := documentIDs(req.GetAcceptTerms())
+	if err != nil {
+		return nil, err
+	}
 	userAgent := getUserAgent(ctx)
 	clientIP := getClientIP(ctx)
-	userID, accessToken, refreshToken, err := h.AuthUsecase.LoginUser(ctx, req.GetLogin(), req.GetPassword(), userAgent, clientIP)
+	tokens, err := h.AuthUsecase.LoginUser(ctx, entity.LoginInput{
+		Login:          req.GetLogin(),
+		Password:       req.GetPassword(),
+		UserAgent:      userAgent,
+		IP:             clientIP,
+		ClientType:     req.GetClientType(),
+		CertThumbprint: certThumbprint(ctx),
+		AcceptedTerms:  acceptedTerms,
+		AcceptLanguage: firstMetadata(ctx, "accept-language"),
+		Timezone:       firstMetadata(ctx, "x-timezone"),
+		TrustedDevice:  req.GetTrustedDevice(),
+		Attestation:    attestation(ctx),
+		CaptchaToken:   firstMetadata(ctx, captchaTokenKey),
+		DeviceName:     req.GetDeviceName(),
+	})
+	if errors.Is(err, customerrors.ErrMFARequired) && tokens.MFA != nil {
+		return &authv1.LoginResponse{
+			MfaChallengeId: tokens.MFA.ID.String(),
+			MfaMethod:      string(tokens.MFA.Method),
+			MfaDestination: tokens.MFA.Destination,
+		}, nil
+	}
 	if err != nil {
 		h.logger.Error("Failed to login user", "error", err)
-		return nil, status.Error(codes.Unauthenticated, "invalid credentials")
+		if errors.Is(err, customerrors.ErrUserBlocked) || errors.Is(err, customerrors.ErrAttestationFailed) ||
+			errors.Is(err, customerrors.ErrCaptchaRequired) || errors.Is(err, customerrors.ErrLoginLocationBlocked) {
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+		if errors.Is(err, customerrors.ErrTermsNotAccepted) {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+		if errors.Is(err, customerrors.ErrInvalidTermsDocument) || errors.Is(err, customerrors.ErrInvalidDeviceName) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		// only reported without enumeration protection
+		if errors.Is(err, customerrors.ErrUserNotFound) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		return nil, grpcerr.Or(err, codes.Unauthenticated, "invalid credentials")
 	}
-	metadata.AppendToOutgoingContext(ctx, "user_id", userID.String())
+	metadata.AppendToOutgoingContext(ctx, "user_id", tokens.UserID.String())
 
 	return &authv1.LoginResponse{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		Receipt:      tokens.Receipt,
 	}, nil
 
 }
 
+// completeMFALogin finishes a login with the code of its second factor.
+func (h *RPCAuthHandler) completeMFALogin(ctx context.Context, req *authv1.LoginRequest) (*authv1.LoginResponse, error) {
+	challengeID, err := uuid.Parse(req.GetMfaChallengeId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid MFA challenge ID")
+	}
+	acceptedTerms, err := documentIDs(req.GetAcceptTerms())
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := h.AuthUsecase.CompleteMFALogin(ctx, entity.MFALoginInput{
+		ChallengeID: challengeID,
+		Code:        req.GetMfaCode(),
+		Client: entity.LoginInput{
+			UserAgent:      getUserAgent(ctx),
+			IP:             getClientIP(ctx),
+			ClientType:     req.GetClientType(),
+			CertThumbprint: certThumbprint(ctx),
+			AcceptedTerms:  acceptedTerms,
+			AcceptLanguage: firstMetadata(ctx, "accept-language"),
+			Timezone:       firstMetadata(ctx, "x-timezone"),
+			Attestation:    attestation(ctx),
+			DeviceName:     req.GetDeviceName(),
+		},
+		TrustDevice: req.GetTrustDevice(),
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, customerrors.ErrUserBlocked), errors.Is(err, customerrors.ErrAttestationFailed),
+			errors.Is(err, customerrors.ErrLoginLocationBlocked):
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		case errors.Is(err, customerrors.ErrTermsNotAccepted):
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		case errors.Is(err, customerrors.ErrInvalidTermsDocument), errors.Is(err, customerrors.ErrInvalidDeviceName):
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		case errors.Is(err, customerrors.ErrTooManyAttempts):
+			return nil, status.Error(codes.ResourceExhausted, err.Error())
+		case errors.Is(err, customerrors.ErrInvalidOTP), errors.Is(err, pgx.ErrNoRows):
+			return nil, status.Error(codes.Unauthenticated, customerrors.ErrInvalidOTP.Error())
+		}
+		h.logger.Error("Failed to complete MFA login", "error", err)
+		return nil, grpcerr.Or(err, codes.Internal, "failed to login")
+	}
+	resp := &authv1.LoginResponse{
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		Receipt:      tokens.Receipt,
+	}
+	if tokens.DeviceTrust != nil {
+		resp.TrustedDevice = tokens.DeviceTrust.Cookie
+	}
+	return resp, nil
+}
+
 // LogoutSession logs out the user from a specific session by deleting that session from the database.
 func (h *RPCAuthHandler) Logout(ctx context.Context, req *authv1.LogoutRequest) (*authv1.LogoutResponse, error) {
-	err := h.AuthUsecase.LogoutSession(ctx, req.GetUserId(), req.GetSessionId())
+	userID, err := logoutTarget(ctx, req.GetUserId())
+	if err != nil {
+		return nil, err
+	}
+	err = h.AuthUsecase.LogoutSession(ctx, userID, req.GetSessionId())
 	if err != nil {
 		h.logger.Error("Failed to logout session", "error", err)
-		return nil, status.Error(codes.Internal, "failed to logout session")
+		return nil, grpcerr.Or(err, codes.Internal, "failed to logout session")
 
 	}
 	return &authv1.LogoutResponse{
@@ -95,29 +239,112 @@ func (h *RPCAuthHandler) Logout(ctx context.Context, req *authv1.LogoutRequest)
 
 // LogoutAllSessions logs out the user from all sessions by deleting all sessions associated with the user from the database.
 func (h *RPCAuthHandler) LogoutAll(ctx context.Context, req *authv1.LogoutAllRequest) (*authv1.LogoutAllResponse, error) {
-	err := h.AuthUsecase.LogoutAllSessions(ctx, req.GetUserId())
+	userID, err := logoutTarget(ctx, req.GetUserId())
+	if err != nil {
+		return nil, err
+	}
+	report, err := h.AuthUsecase.LogoutAllSessions(ctx, userID, req.GetDryRun())
 	if err != nil {
 		h.logger.Error("Failed to logout all sessions", "error", err)
-		return nil, status.Error(codes.Internal, "failed to logout all sessions")
+		return nil, grpcerr.Or(err, codes.Internal, "failed to logout all sessions")
+	}
+	sessionIDs := make([]string, 0, len(report.IDs))
+	for _, id := range report.IDs {
+		sessionIDs = append(sessionIDs, id.String())
 	}
 	return &authv1.LogoutAllResponse{
-		Success: true,
+		Success:    true,
+		DryRun:     report.DryRun,
+		SessionIds: sessionIDs,
 	}, nil
 }
 
-// RefreshSession refreshes the session token for a user and returns the new access token and refresh token.
-func (h *RPCAuthHandler) RefreshSession(ctx context.Context, req *authv1.RefreshTokenRequest) (*authv1.RefreshTokenResponse, error) {
-	newAccessToken, newRefreshToken, err := h.AuthUsecase.RefreshSessionToken(ctx, req.GetRefreshToken())
+// logoutTarget returns the user whose sessions a logout ends: the requested one for service clients with the
+// sessions.revoke scope, always the user of the access token for users.
+func logoutTarget(ctx context.Context, requested string) (string, error) {
+	if _, ok := ctxUtil.ClientFromContext(ctx); ok {
+		return requested, nil
+	}
+	userID, ok := ctxUtil.FromContext(ctx)
+	if !ok {
+		return "", status.Error(codes.Unauthenticated, "user token required")
+	}
+	return userID, nil
+}
+
+// RefreshToken refreshes the session token for a user and returns the new access token and refresh token.
+// gRPC clients keep the refresh token themselves like native apps, the sessions of web clients are refused.
+func (h *RPCAuthHandler) RefreshToken(ctx context.Context, req *authv1.RefreshTokenRequest) (*authv1.RefreshTokenResponse, error) {
+	tokens, err := h.AuthUsecase.RefreshSessionToken(ctx, entity.RefreshInput{
+		RefreshToken:   req.GetRefreshToken(),
+		UserAgent:      getUserAgent(ctx),
+		IP:             getClientIP(ctx),
+		CertThumbprint: certThumbprint(ctx),
+		Native:         true,
+		Attestation:    attestation(ctx),
+	})
 	if err != nil {
+		if errors.Is(err, customerrors.ErrRefreshCookieRequired) {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+		if errors.Is(err, customerrors.ErrReauthenticationRequired) || errors.Is(err, customerrors.ErrSessionBindingMismatch) ||
+			errors.Is(err, pgx.ErrNoRows) || errors.Is(err, customerrors.ErrCertificateMismatch) {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		if errors.Is(err, customerrors.ErrAttestationFailed) || errors.Is(err, customerrors.ErrSessionBlocked) {
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
 		h.logger.Error("Failed to refresh session token", "error", err)
-		return nil, status.Error(codes.Internal, "failed to refresh session token")
+		return nil, grpcerr.Or(err, codes.Internal, "failed to refresh session token")
 	}
 	return &authv1.RefreshTokenResponse{
-		AccessToken:  newAccessToken,
-		RefreshToken: newRefreshToken,
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		Receipt:      tokens.Receipt,
+	}, nil
+}
+
+// GetMe returns the profile of the user the access token belongs to.
+func (h *RPCAuthHandler) GetMe(ctx context.Context, req *authv1.GetMeRequest) (*authv1.GetMeResponse, error) {
+	userIDStr, ok := ctxUtil.FromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "user token required")
+	}
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid user ID")
+	}
+
+	user, err := h.AuthUsecase.GetProfile(ctx, userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+		h.logger.Error("Failed to get profile", "error", err)
+		return nil, grpcerr.Or(err, codes.Internal, "failed to get profile")
+	}
+	return &authv1.GetMeResponse{
+		UserId:        user.ID.String(),
+		Username:      user.Username,
+		Email:         user.Email,
+		CreatedAt:     user.CreatedAt.UTC().Format(time.RFC3339),
+		EmailVerified: user.EmailVerified,
 	}, nil
 }
 
+// documentIDs parses the IDs of accepted terms documents.
+func documentIDs(ids []string) ([]uuid.UUID, error) {
+	parsed := make([]uuid.UUID, 0, len(ids))
+	for _, id := range ids {
+		documentID, err := uuid.Parse(id)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid terms document ID")
+		}
+		parsed = append(parsed, documentID)
+	}
+	return parsed, nil
+}
+
 // getClientIP extracts the client IP address from gRPC metadata or peer info.
 func getClientIP(ctx context.Context) string {
 	// 1. First, try to get the IP from gRPC metadata headers
@@ -151,6 +378,15 @@ func getClientIP(ctx context.Context) string {
 	return "unknown"
 }
 
+// certThumbprint returns the thumbprint of the caller's mTLS certificate, empty without mTLS.
+func certThumbprint(ctx context.Context) string {
+	identity, ok := ctxUtil.PeerIdentityFromContext(ctx)
+	if !ok {
+		return ""
+	}
+	return identity.Thumbprint
+}
+
 // getUserAgent extracts the User-Agent from gRPC metadata.
 func getUserAgent(ctx context.Context) string {
 	md, ok := metadata.FromIncomingContext(ctx)
@@ -170,3 +406,29 @@ func getUserAgent(ctx context.Context) string {
 
 	return "unknown"
 }
+
+// attestation returns the app attestation of a mobile app, sent like the X-App-* headers of HTTP as
+// x-app-platform, x-app-attestation and x-app-attestation-challenge.
+func attestation(ctx context.Context) entity.Attestation {
+	return entity.Attestation{
+		Platform:  firstMetadata(ctx, "x-app-platform"),
+		Token:     firstMetadata(ctx, "x-app-attestation"),
+		Challenge: firstMetadata(ctx, "x-app-attestation-challenge"),
+	}
+}
+
+// captchaTokenKey is the metadata key of the CAPTCHA token of registrations and logins.
+const captchaTokenKey = "x-captcha-token"
+
+// firstMetadata returns the first value of the metadata key, clients send the Accept-Language and
+// X-Timezone headers of HTTP as accept-language and x-timezone.
+func firstMetadata(ctx context.Context, key string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if v := md.Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
@@ -0,0 +1,145 @@
+package extauthz
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/pem"
+	"log/slog"
+	"main/domain/entity"
+	"main/internal/delivery/grpc/grpcerr"
+	"main/pkg/dpop"
+	"main/pkg/utils"
+	"net/url"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/google/uuid"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+)
+
+// UserIDHeader is the header forwarded to the upstream service with the authenticated user ID.
+const UserIDHeader = "x-user-id"
+
+type AuthUsecase interface {
+	// VerifyAccessClaims verifies the access token and returns its claims.
+	VerifyAccessClaims(token string) (entity.AccessTokenClaims, error)
+
+	// VerifyProof verifies a DPoP proof and returns the thumbprint of its key.
+	VerifyProof(ctx context.Context, proof, method, uri, accessToken string) (jkt string, err error)
+}
+
+// Server implements the Envoy external authorization API (envoy.service.auth.v3.Authorization),
+// so gateways can delegate per-request authentication to this service.
+type Server struct {
+	authv3.UnimplementedAuthorizationServer
+	logger      *slog.Logger
+	AuthUsecase AuthUsecase
+}
+
+func NewServer(logger *slog.Logger, authUsecase AuthUsecase) *Server {
+	return &Server{
+		logger:      logger,
+		AuthUsecase: authUsecase,
+	}
+}
+
+// Check verifies the bearer token of the original HTTP request and either allows it,
+// injecting the user ID header for the upstream, or denies it with 401.
+// Envoy lowercases header names, so the authorization header is looked up as is.
+func (s *Server) Check(ctx context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	httpReq := req.GetAttributes().GetRequest().GetHttp()
+	token, isDPoP, ok := dpop.ParseAuthorization(httpReq.GetHeaders()["authorization"])
+	if !ok {
+		return denied("missing authorization token"), nil
+	}
+
+	claims, err := s.AuthUsecase.VerifyAccessClaims(token)
+	// an outage of the token state stores says nothing about the token, Envoy applies its failure mode instead
+	if st := grpcerr.Dependency(err); st != nil {
+		s.logger.Error("ext_authz token state check failed", "error", err)
+		return nil, st
+	}
+	if err != nil || claims.UserID == uuid.Nil {
+		s.logger.Debug("ext_authz check denied", "error", err)
+		return denied("invalid token"), nil
+	}
+	userID := claims.UserID
+
+	// certificate-bound token (RFC 8705), Envoy forwards the downstream client certificate
+	// as URL-encoded PEM when include_peer_certificate is enabled
+	if claims.CertThumbprint != "" && sourceCertThumbprint(req) != claims.CertThumbprint {
+		return denied("token is bound to a different client certificate"), nil
+	}
+	// DPoP-bound token (RFC 9449), the proof must match the original request and the token key
+	if isDPoP != (claims.DPoPThumbprint != "") {
+		return denied("token binding does not match the authorization scheme"), nil
+	}
+	if isDPoP {
+		uri := httpReq.GetScheme() + "://" + httpReq.GetHost() + httpReq.GetPath()
+		jkt, err := s.AuthUsecase.VerifyProof(ctx, httpReq.GetHeaders()["dpop"], httpReq.GetMethod(), uri, token)
+		// the replay cache being down says nothing about the proof, Envoy applies its failure mode instead
+		if st := grpcerr.Dependency(err); st != nil {
+			s.logger.Error("ext_authz DPoP replay check failed", "error", err)
+			return nil, st
+		}
+		if err != nil || subtle.ConstantTimeCompare([]byte(jkt), []byte(claims.DPoPThumbprint)) != 1 {
+			s.logger.Debug("ext_authz DPoP proof rejected", "error", err)
+			return denied("invalid DPoP proof"), nil
+		}
+	}
+
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{Code: int32(codes.OK)},
+		HttpResponse: &authv3.CheckResponse_OkResponse{
+			OkResponse: &authv3.OkHttpResponse{
+				Headers: []*corev3.HeaderValueOption{
+					{
+						Header: &corev3.HeaderValue{Key: UserIDHeader, Value: userID.String()},
+						// never trust an identity header sent by the client itself
+						AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+// denied builds a CheckResponse that makes Envoy answer the client with 401.
+func denied(reason string) *authv3.CheckResponse {
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{Code: int32(codes.Unauthenticated), Message: reason},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{
+			DeniedResponse: &authv3.DeniedHttpResponse{
+				Status: &typev3.HttpStatus{Code: typev3.StatusCode_Unauthorized},
+				Headers: []*corev3.HeaderValueOption{
+					{Header: &corev3.HeaderValue{Key: "www-authenticate", Value: "Bearer"}},
+				},
+				Body: `{"error":"Unauthorized"}`,
+			},
+		},
+	}
+}
+
+// sourceCertThumbprint returns the thumbprint of the downstream client certificate forwarded by Envoy.
+func sourceCertThumbprint(req *authv3.CheckRequest) string {
+	encoded := req.GetAttributes().GetSource().GetCertificate()
+	if encoded == "" {
+		return ""
+	}
+	decoded, err := url.QueryUnescape(encoded)
+	if err != nil {
+		return ""
+	}
+	block, _ := pem.Decode([]byte(decoded))
+	if block == nil {
+		return ""
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return ""
+	}
+	return utils.CertThumbprint(cert)
+}
@@ -0,0 +1,58 @@
+// Package grpcerr maps errors of unreachable or overloaded dependencies to retryable gRPC statuses:
+// UNAVAILABLE or RESOURCE_EXHAUSTED with a RetryInfo detail, so clients back off and retry instead of
+// treating an outage as a rejected credential.
+package grpcerr
+
+import (
+	"main/pkg/outage"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+const (
+	// unavailableRetryDelay is the hint while a dependency is down, exhaustedRetryDelay while it is saturated
+	unavailableRetryDelay = time.Second
+	exhaustedRetryDelay   = 2 * time.Second
+)
+
+// Dependency returns the retryable status of an error caused by an outage of Postgres or Redis, nil for any other error.
+func Dependency(err error) error {
+	switch outage.Classify(err) {
+	case outage.Unavailable:
+		return withRetry(codes.Unavailable, "service temporarily unavailable, retry later", unavailableRetryDelay)
+	case outage.Exhausted:
+		return withRetry(codes.ResourceExhausted, "service overloaded, retry later", exhaustedRetryDelay)
+	}
+	return nil
+}
+
+// Or returns the retryable status of an outage, or a status with code and msg for any other error.
+func Or(err error, code codes.Code, msg string) error {
+	if st := Dependency(err); st != nil {
+		return st
+	}
+	return status.Error(code, msg)
+}
+
+// RetryDelay returns the RetryInfo delay of the status, false if it has none.
+func RetryDelay(st *status.Status) (time.Duration, bool) {
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok && info.GetRetryDelay() != nil {
+			return info.GetRetryDelay().AsDuration(), true
+		}
+	}
+	return 0, false
+}
+
+func withRetry(code codes.Code, msg string, delay time.Duration) error {
+	st := status.New(code, msg)
+	detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(delay)})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
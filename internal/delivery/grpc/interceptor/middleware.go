@@ -2,29 +2,117 @@ package interceptor
 
 import (
 	"context"
+	"crypto/subtle"
+	"errors"
 	"log/slog"
+	"main/domain/entity"
+	"main/internal/delivery/grpc/grpcerr"
+	"main/pkg/customerrors"
+	"main/pkg/retry"
+	"main/pkg/utils"
 	ctxUtil "main/pkg/utils/context"
+	"net"
 	"runtime/debug"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 )
 
 var publicMethods = map[string]struct{}{
 	"/auth.v1.AuthService/Register": {},
 	"/auth.v1.AuthService/Login":    {},
+	// the refresh token authenticates the call, the access token has usually expired by then
+	"/auth.v1.AuthService/RefreshToken": {},
+	// ext_authz carries the token of the proxied request inside the message itself
+	"/envoy.service.auth.v3.Authorization/Check": {},
+}
+
+// serviceMethodScopes lists the methods callable with a service (client_credentials) token
+// and the scope the token must carry. Methods not listed here are user-only.
+var serviceMethodScopes = map[string]string{
+	"/auth.v1.AuthService/Logout":    "sessions.revoke",
+	"/auth.v1.AuthService/LogoutAll": "sessions.revoke",
+	"/auth.v1.AdminService/GetStats": "stats.read",
+}
+
+// methodPermissions lists the methods that require a permission on top of a user token.
+var methodPermissions = map[string]entity.Permission{
+	"/auth.v1.AdminService/ListUsers":                entity.PermUserRead,
+	"/auth.v1.AdminService/GetUser":                  entity.PermUserRead,
+	"/auth.v1.AdminService/BlockUser":                entity.PermUserBlock,
+	"/auth.v1.AdminService/UnblockUser":              entity.PermUserBlock,
+	"/auth.v1.AdminService/ForcePasswordReset":       entity.PermPasswordReset,
+	"/auth.v1.AdminService/ForceLogout":              entity.PermSessionRevoke,
+	"/auth.v1.AdminService/CreateOrganization":       entity.PermOrgManage,
+	"/auth.v1.AdminService/GetOrganization":          entity.PermOrgManage,
+	"/auth.v1.AdminService/ListOrganizations":        entity.PermOrgManage,
+	"/auth.v1.AdminService/SuspendOrganization":      entity.PermOrgManage,
+	"/auth.v1.AdminService/ResumeOrganization":       entity.PermOrgManage,
+	"/auth.v1.AdminService/DeleteOrganization":       entity.PermOrgManage,
+	"/auth.v1.AdminService/AddOrganizationMember":    entity.PermOrgManage,
+	"/auth.v1.AdminService/RemoveOrganizationMember": entity.PermOrgManage,
+	"/auth.v1.AdminService/GetStats":                 entity.PermStatsRead,
+}
+
+// elevatedMethods are the most sensitive admin methods, they require an elevated session (POST /me/elevate).
+var elevatedMethods = map[string]struct{}{
+	"/auth.v1.AdminService/ForcePasswordReset": {},
+	"/auth.v1.AdminService/DeleteOrganization": {},
+}
+
+// readOnlySafeMethods keep working in read-only mode, every other method writes to the database.
+var readOnlySafeMethods = map[string]struct{}{
+	"/auth.v1.AuthService/GetMe":                 {},
+	"/auth.v1.AdminService/ListUsers":            {},
+	"/auth.v1.AdminService/GetUser":              {},
+	"/auth.v1.AdminService/GetOrganization":      {},
+	"/auth.v1.AdminService/ListOrganizations":    {},
+	"/auth.v1.AdminService/GetStats":             {},
+	"/envoy.service.auth.v3.Authorization/Check": {},
+}
+
+type ReadOnlyMode interface {
+	Enabled() bool
+}
+
+// ReadOnlyInterceptor rejects methods that write to the database with UNAVAILABLE while the service is in read-only mode.
+func ReadOnlyInterceptor(mode ReadOnlyMode) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		if _, ok := readOnlySafeMethods[info.FullMethod]; !ok && mode.Enabled() {
+			return nil, status.Error(codes.Unavailable, "service is in read-only mode")
+		}
+		return handler(ctx, req)
+	}
+}
+
+// AccessTokenVerifier verifies user access tokens like the HTTP middleware does, including their revocation
+// (token versions, password changes, denied sessions), implemented by auth.AuthUsecase.
+type AccessTokenVerifier interface {
+	VerifyAccessClaims(token string) (entity.AccessTokenClaims, error)
 }
 
 type JWTManager interface {
-	VerifyAccessToken(tokenString string) (userID uuid.UUID, err error)
+	VerifyServiceToken(tokenString string) (clientID string, scopes []string, err error)
 }
 
 // AuthInterceptor is a gRPC middleware that intercepts incoming requests to perform authentication.
-func AuthInterceptor(jwtManager JWTManager) grpc.UnaryServerInterceptor {
+// User tokens are verified by users, service tokens by jwtManager.
+// With elevation the methods of elevatedMethods are refused to tokens of sessions that are not elevated.
+func AuthInterceptor(users AccessTokenVerifier, jwtManager JWTManager, elevation bool) grpc.UnaryServerInterceptor {
 	return func(
 		ctx context.Context,
 		req any,
@@ -47,18 +135,174 @@ func AuthInterceptor(jwtManager JWTManager) grpc.UnaryServerInterceptor {
 
 		accessToken := strings.TrimPrefix(values[0], "Bearer ")
 
-		userID, err := jwtManager.VerifyAccessToken(accessToken)
-		if err != nil {
-			return nil, status.Errorf(codes.Unauthenticated, "invalid token: %v", err)
+		claims, err := users.VerifyAccessClaims(accessToken)
+		switch {
+		case err == nil, errors.Is(err, customerrors.ErrInvalidToken):
+		case errors.Is(err, customerrors.ErrTokenRevoked):
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		case errors.Is(err, customerrors.ErrUserBlocked):
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		default:
+			// the token state could not be read, an outage must not send the client back to the login
+			return nil, grpcerr.Or(err, codes.Internal, "failed to verify token")
+		}
+		if err == nil {
+			// certificate-bound token (RFC 8705), only usable over mTLS with the same certificate
+			if claims.CertThumbprint != "" {
+				identity, ok := ctxUtil.PeerIdentityFromContext(ctx)
+				if !ok || subtle.ConstantTimeCompare([]byte(identity.Thumbprint), []byte(claims.CertThumbprint)) != 1 {
+					return nil, status.Error(codes.Unauthenticated, "token is bound to a different client certificate")
+				}
+			}
+			// DPoP proofs are defined for HTTP requests only, DPoP-bound tokens cannot be used over gRPC
+			if claims.DPoPThumbprint != "" {
+				return nil, status.Error(codes.Unauthenticated, "DPoP-bound tokens are not accepted over gRPC")
+			}
+			if _, ok := elevatedMethods[info.FullMethod]; ok && elevation && !time.Now().Before(claims.ElevatedUntil) {
+				return nil, status.Error(codes.PermissionDenied, customerrors.ErrElevationRequired.Error())
+			}
+			return handler(ctxUtil.NewContext(ctx, claims.UserID.String()), req)
+		}
+
+		// not a valid user token, try it as a service token
+		clientID, scopes, svcErr := jwtManager.VerifyServiceToken(accessToken)
+		if svcErr != nil {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		required, ok := serviceMethodScopes[info.FullMethod]
+		if !ok || !slices.Contains(scopes, required) {
+			return nil, status.Errorf(codes.PermissionDenied, "client %s is not allowed to call %s", clientID, info.FullMethod)
 		}
 
-		newCtx := ctxUtil.NewContext(ctx, userID.String())
+		newCtx := ctxUtil.NewClientContext(ctx, ctxUtil.Client{ID: clientID, Scopes: scopes})
 
 		return handler(newCtx, req)
 	}
 }
 
+type Authorizer interface {
+	Authorize(ctx context.Context, userID uuid.UUID, permission entity.Permission, resource string) error
+}
+
+// PermissionInterceptor allows the methods listed in methodPermissions only if the authenticated user holds
+// the permission through one of their roles. It must be chained after AuthInterceptor, which puts the user ID into the context.
+// Service tokens were already checked against serviceMethodScopes by AuthInterceptor.
+func PermissionInterceptor(authorizer Authorizer) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		permission, ok := methodPermissions[info.FullMethod]
+		if !ok {
+			return handler(ctx, req)
+		}
+		if _, ok := ctxUtil.ClientFromContext(ctx); ok {
+			return handler(ctx, req)
+		}
+		userIDStr, ok := ctxUtil.FromContext(ctx)
+		if !ok {
+			return nil, status.Error(codes.Unauthenticated, "user token required")
+		}
+		userID, err := uuid.Parse(userIDStr)
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, "invalid user ID")
+		}
+
+		err = authorizer.Authorize(ctx, userID, permission, info.FullMethod)
+		if errors.Is(err, customerrors.ErrForbidden) {
+			return nil, status.Errorf(codes.PermissionDenied, "%s requires the %s permission", info.FullMethod, permission)
+		}
+		if err != nil {
+			return nil, grpcerr.Or(err, codes.Internal, "failed to check permissions")
+		}
+		return handler(ctx, req)
+	}
+}
+
+// ClientIdentityInterceptor extracts the identity of the caller from its verified mTLS certificate
+// and stores it in the context for per-service authorization in later interceptors and handlers.
+// If allowed is not empty, callers whose CN/SAN values are not listed are rejected.
+func ClientIdentityInterceptor(allowed []string) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		identity, ok := peerIdentity(ctx)
+		if !ok {
+			if len(allowed) > 0 {
+				return nil, status.Error(codes.Unauthenticated, "client certificate required")
+			}
+			return handler(ctx, req)
+		}
+
+		if len(allowed) > 0 && !slices.ContainsFunc(identity.Names(), func(name string) bool {
+			return slices.Contains(allowed, name)
+		}) {
+			return nil, status.Errorf(codes.PermissionDenied, "client %q is not allowed", identity.CommonName)
+		}
+
+		return handler(ctxUtil.NewPeerIdentityContext(ctx, identity), req)
+	}
+}
+
+// peerIdentity returns the subject of the verified client certificate, if the connection uses mTLS.
+func peerIdentity(ctx context.Context) (ctxUtil.PeerIdentity, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ctxUtil.PeerIdentity{}, false
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return ctxUtil.PeerIdentity{}, false
+	}
+
+	cert := tlsInfo.State.VerifiedChains[0][0]
+	identity := ctxUtil.PeerIdentity{
+		CommonName: cert.Subject.CommonName,
+		DNSNames:   cert.DNSNames,
+		Thumbprint: utils.CertThumbprint(cert),
+	}
+	for _, uri := range cert.URIs {
+		identity.URIs = append(identity.URIs, uri.String())
+	}
+	return identity, true
+}
+
+// RequestInfoInterceptor stores the IP and user agent of the caller in the context for the audit log. The IP is
+// taken from the x-forwarded-for or x-real-ip metadata set by proxies, the peer address without them.
+func RequestInfoInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		var client ctxUtil.RequestInfo
+		md, _ := metadata.FromIncomingContext(ctx)
+		if xff := md.Get("x-forwarded-for"); len(xff) > 0 {
+			client.IP, _, _ = strings.Cut(xff[0], ",")
+			client.IP = strings.TrimSpace(client.IP)
+		} else if xrip := md.Get("x-real-ip"); len(xrip) > 0 {
+			client.IP = xrip[0]
+		} else if p, ok := peer.FromContext(ctx); ok {
+			client.IP = p.Addr.String()
+			if host, _, err := net.SplitHostPort(client.IP); err == nil {
+				client.IP = host
+			}
+		}
+		if ua := md.Get("user-agent"); len(ua) > 0 {
+			client.UserAgent = ua[0]
+		}
+		return handler(ctxUtil.NewRequestInfoContext(ctx, client), req)
+	}
+}
+
 // LoggingInterceptor is a gRPC middleware that intercepts errors returned by handlers and logs them appropriately.
+// It starts the retry trace of the call, the retries made for it are added to its log line.
 func LoggingInterceptor(logger *slog.Logger) grpc.UnaryServerInterceptor {
 	return func(
 		ctx context.Context,
@@ -66,14 +310,16 @@ func LoggingInterceptor(logger *slog.Logger) grpc.UnaryServerInterceptor {
 		info *grpc.UnaryServerInfo,
 		handler grpc.UnaryHandler,
 	) (any, error) {
+		ctx, _ = retry.NewContext(ctx)
 		resp, err := handler(ctx, req)
+		retries := retry.LogAttrs(ctx)
 
 		if err == nil {
-			logger.Info("gRPC Request",
+			logger.Info("gRPC Request", append([]any{
 				"method", info.FullMethod,
 				"request", req,
 				"response", resp,
-			)
+			}, retries...)...)
 			return resp, nil
 		}
 
@@ -81,23 +327,56 @@ func LoggingInterceptor(logger *slog.Logger) grpc.UnaryServerInterceptor {
 
 		if ok {
 
-			logger.Warn("gRPC Client Error",
+			logger.Warn("gRPC Client Error", append([]any{
 				"method", info.FullMethod,
 				"code", st.Code(),
 				"msg", st.Message(),
-			)
+			}, retries...)...)
 			return resp, err
 		}
 
-		logger.Error("gRPC SYSTEM ERROR",
+		if st := grpcerr.Dependency(err); st != nil {
+			logger.Error("gRPC dependency unavailable", append([]any{
+				"method", info.FullMethod,
+				"err", err,
+			}, retries...)...)
+			return nil, st
+		}
+
+		logger.Error("gRPC SYSTEM ERROR", append([]any{
 			"method", info.FullMethod,
 			"err", err,
-		)
+		}, retries...)...)
 
 		return nil, status.Error(codes.Internal, "internal server error")
 	}
 }
 
+// retryPushbackTrailer tells gRPC clients with a retry policy how long to wait before the next attempt (gRFC A6).
+const retryPushbackTrailer = "grpc-retry-pushback-ms"
+
+// RetryHintInterceptor copies the RetryInfo delay of UNAVAILABLE and RESOURCE_EXHAUSTED errors into the retry
+// pushback trailer, which the built-in retries of gRPC clients honor without decoding the status details.
+// It must be chained before LoggingInterceptor, which turns outage errors into these statuses.
+func RetryHintInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		resp, err := handler(ctx, req)
+		st, ok := status.FromError(err)
+		if err == nil || !ok || (st.Code() != codes.Unavailable && st.Code() != codes.ResourceExhausted) {
+			return resp, err
+		}
+		if delay, ok := grpcerr.RetryDelay(st); ok {
+			_ = grpc.SetTrailer(ctx, metadata.Pairs(retryPushbackTrailer, strconv.FormatInt(delay.Milliseconds(), 10)))
+		}
+		return resp, err
+	}
+}
+
 // RecoveryInterceptor is a gRPC middleware that recovers from panics in handlers and logs the panic details.
 func RecoveryInterceptor(logger *slog.Logger) grpc.UnaryServerInterceptor {
 	return func(
@@ -0,0 +1,114 @@
+package interceptor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"main/domain/entity"
+	"main/pkg/customerrors"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type fakeVerifier struct {
+	claims entity.AccessTokenClaims
+	err    error
+}
+
+func (f fakeVerifier) VerifyAccessClaims(string) (entity.AccessTokenClaims, error) {
+	return f.claims, f.err
+}
+
+type fakeServiceTokens struct {
+	scopes []string
+	err    error
+}
+
+func (f fakeServiceTokens) VerifyServiceToken(string) (string, []string, error) {
+	return "billing", f.scopes, f.err
+}
+
+func TestAuthInterceptor(t *testing.T) {
+	invalid := fmt.Errorf("%w: token is expired", customerrors.ErrInvalidToken)
+	tests := []struct {
+		name      string
+		method    string
+		noToken   bool
+		elevation bool
+		user      fakeVerifier
+		service   fakeServiceTokens
+		want      codes.Code
+	}{
+		{name: "public method", method: "/auth.v1.AuthService/Login", noToken: true, want: codes.OK},
+		{name: "missing token", method: "/auth.v1.AuthService/GetMe", noToken: true, want: codes.Unauthenticated},
+		{name: "valid user token", method: "/auth.v1.AuthService/GetMe", want: codes.OK},
+		{name: "revoked token", method: "/auth.v1.AuthService/GetMe", user: fakeVerifier{err: customerrors.ErrTokenRevoked}, want: codes.Unauthenticated},
+		{name: "blocked user", method: "/auth.v1.AuthService/GetMe", user: fakeVerifier{err: customerrors.ErrUserBlocked}, want: codes.PermissionDenied},
+		{name: "postgres down", method: "/auth.v1.AuthService/GetMe", user: fakeVerifier{err: &pgconn.PgError{Code: "57P01"}}, want: codes.Unavailable},
+		{name: "redis pool exhausted", method: "/auth.v1.AuthService/GetMe", user: fakeVerifier{err: redis.ErrPoolTimeout}, want: codes.ResourceExhausted},
+		{name: "other verification failure", method: "/auth.v1.AuthService/GetMe", user: fakeVerifier{err: errors.New("boom")}, want: codes.Internal},
+		{name: "DPoP-bound token", method: "/auth.v1.AuthService/GetMe", user: fakeVerifier{claims: entity.AccessTokenClaims{DPoPThumbprint: "jkt"}}, want: codes.Unauthenticated},
+		{name: "certificate-bound token without mTLS", method: "/auth.v1.AuthService/GetMe", user: fakeVerifier{claims: entity.AccessTokenClaims{CertThumbprint: "x5t"}}, want: codes.Unauthenticated},
+		{name: "elevated method, session not elevated", method: "/auth.v1.AdminService/ForcePasswordReset", elevation: true, want: codes.PermissionDenied},
+		{name: "elevated method, session elevated", method: "/auth.v1.AdminService/ForcePasswordReset", elevation: true, user: fakeVerifier{claims: entity.AccessTokenClaims{ElevatedUntil: time.Now().Add(time.Minute)}}, want: codes.OK},
+		{name: "invalid token, invalid service token", method: "/auth.v1.AuthService/Logout", user: fakeVerifier{err: invalid}, service: fakeServiceTokens{err: errors.New("bad signature")}, want: codes.Unauthenticated},
+		{name: "service token with scope", method: "/auth.v1.AuthService/Logout", user: fakeVerifier{err: invalid}, service: fakeServiceTokens{scopes: []string{"sessions.revoke"}}, want: codes.OK},
+		{name: "service token without scope", method: "/auth.v1.AuthService/Logout", user: fakeVerifier{err: invalid}, service: fakeServiceTokens{scopes: []string{"stats.read"}}, want: codes.PermissionDenied},
+		{name: "service token on a user-only method", method: "/auth.v1.AuthService/GetMe", user: fakeVerifier{err: invalid}, service: fakeServiceTokens{scopes: []string{"sessions.revoke"}}, want: codes.PermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.user.err == nil {
+				tt.user.claims.UserID = uuid.New()
+			}
+			ctx := context.Background()
+			if !tt.noToken {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Bearer token"))
+			}
+			handler := func(ctx context.Context, req any) (any, error) { return "ok", nil }
+
+			interceptor := AuthInterceptor(tt.user, tt.service, tt.elevation)
+			_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+			if got := status.Code(err); got != tt.want {
+				t.Errorf("code = %s, want %s (err: %v)", got, tt.want, err)
+			}
+		})
+	}
+}
+
+type readOnly bool
+
+func (m readOnly) Enabled() bool { return bool(m) }
+
+func TestReadOnlyInterceptor(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+		method  string
+		want    codes.Code
+	}{
+		{name: "write while writable", method: "/auth.v1.AuthService/Register", want: codes.OK},
+		{name: "read while read-only", enabled: true, method: "/auth.v1.AuthService/GetMe", want: codes.OK},
+		{name: "ext_authz while read-only", enabled: true, method: "/envoy.service.auth.v3.Authorization/Check", want: codes.OK},
+		{name: "write while read-only", enabled: true, method: "/auth.v1.AuthService/Register", want: codes.Unavailable},
+		{name: "admin write while read-only", enabled: true, method: "/auth.v1.AdminService/BlockUser", want: codes.Unavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := func(ctx context.Context, req any) (any, error) { return "ok", nil }
+			_, err := ReadOnlyInterceptor(readOnly(tt.enabled))(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+			if got := status.Code(err); got != tt.want {
+				t.Errorf("code = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package accountHandler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"main/domain/entity"
+	"main/internal/delivery/http/cookie"
+	"main/pkg/customerrors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+)
+
+type AccountHandler struct {
+	AccountUsecase  AccountUsecase
+	MetadataUsecase MetadataUsecase
+	RefreshCookie   *cookie.RefreshToken
+}
+
+type AccountUsecase interface {
+	//DeleteAccount schedules the deletion of the account and revokes all its sessions.
+	DeleteAccount(ctx context.Context, userID uuid.UUID, password string) (deleteAt time.Time, err error)
+
+	//CancelDeletion cancels a scheduled deletion of the account.
+	CancelDeletion(ctx context.Context, userID uuid.UUID) error
+
+	//SecurityScore rates the protection of the account with recommendations for improving it.
+	SecurityScore(ctx context.Context, userID uuid.UUID) (entity.SecurityScore, error)
+
+	//ChangeUsername changes the username after checking the password.
+	ChangeUsername(ctx context.Context, userID uuid.UUID, password, username string) error
+}
+
+func NewAccountHandler(accountUsecase AccountUsecase, metadataUsecase MetadataUsecase, refreshCookie *cookie.RefreshToken) *AccountHandler {
+	return &AccountHandler{
+		AccountUsecase:  accountUsecase,
+		MetadataUsecase: metadataUsecase,
+		RefreshCookie:   refreshCookie,
+	}
+}
+
+// DTOs
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+type ChangeUsernameRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// DeleteMe schedules the deletion of the authenticated user's account. All sessions are revoked
+// right away, the data is purged once the grace period is over unless the user logs in and cancels.
+func (h *AccountHandler) DeleteMe(c echo.Context) error {
+	userID, _ := c.Get("userID").(uuid.UUID)
+
+	var req DeleteAccountRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	deleteAt, err := h.AccountUsecase.DeleteAccount(c.Request().Context(), userID, req.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, customerrors.ErrInvalidCredentials):
+			return echo.NewHTTPError(http.StatusForbidden, "password is incorrect")
+		case errors.Is(err, customerrors.ErrDeletionScheduled):
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to delete account: %v", err))
+	}
+
+	h.RefreshCookie.Clear(c)
+	return c.JSON(http.StatusAccepted, map[string]string{"delete_at": deleteAt.UTC().Format(time.RFC3339)})
+}
+
+// CancelDeletion cancels the scheduled deletion of the authenticated user's account.
+func (h *AccountHandler) CancelDeletion(c echo.Context) error {
+	userID, _ := c.Get("userID").(uuid.UUID)
+
+	err := h.AccountUsecase.CancelDeletion(c.Request().Context(), userID)
+	if err != nil {
+		if errors.Is(err, customerrors.ErrNoDeletionScheduled) {
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to cancel deletion: %v", err))
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ChangeUsername changes the username of the authenticated user, the password is required.
+// Reserved and banned usernames answer 403, usernames of other accounts 409.
+func (h *AccountHandler) ChangeUsername(c echo.Context) error {
+	userID, _ := c.Get("userID").(uuid.UUID)
+
+	var req ChangeUsernameRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	err := h.AccountUsecase.ChangeUsername(c.Request().Context(), userID, req.Password, req.Username)
+	if err != nil {
+		switch {
+		case errors.Is(err, customerrors.ErrInvalidCredentials):
+			return echo.NewHTTPError(http.StatusForbidden, "password is incorrect")
+		case errors.Is(err, customerrors.ErrInvalidUsername):
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		case errors.Is(err, customerrors.ErrHandleUnavailable):
+			return echo.NewHTTPError(http.StatusForbidden, err.Error())
+		case errors.Is(err, customerrors.ErrUsernameTaken):
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		case errors.Is(err, pgx.ErrNoRows):
+			return echo.NewHTTPError(http.StatusNotFound, "user not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to change username: %v", err))
+	}
+	return c.JSON(http.StatusOK, map[string]string{"username": req.Username})
+}
+
+// SecurityScore returns the security score of the authenticated user's account with actionable recommendations.
+func (h *AccountHandler) SecurityScore(c echo.Context) error {
+	userID, _ := c.Get("userID").(uuid.UUID)
+
+	score, err := h.AccountUsecase.SecurityScore(c.Request().Context(), userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "user not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to compute security score: %v", err))
+	}
+	return c.JSON(http.StatusOK, score)
+}
@@ -0,0 +1,61 @@
+package accountHandler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"main/pkg/customerrors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+)
+
+type MetadataUsecase interface {
+	//Metadata returns the app-specific attributes of the user.
+	Metadata(ctx context.Context, userID uuid.UUID) (map[string]json.RawMessage, error)
+
+	//PatchMetadata sets or, with null values, removes top-level keys of the metadata and returns the result.
+	PatchMetadata(ctx context.Context, userID uuid.UUID, patch map[string]json.RawMessage) (map[string]json.RawMessage, error)
+}
+
+// GetMetadata returns the metadata object of the authenticated user.
+func (h *AccountHandler) GetMetadata(c echo.Context) error {
+	userID, _ := c.Get("userID").(uuid.UUID)
+
+	metadata, err := h.MetadataUsecase.Metadata(c.Request().Context(), userID)
+	if err != nil {
+		return metadataError(err, "failed to get metadata")
+	}
+	return c.JSON(http.StatusOK, metadata)
+}
+
+// PatchMetadata merges the JSON object in the body into the metadata of the authenticated user,
+// keys with a null value are removed. It answers with the whole metadata object.
+func (h *AccountHandler) PatchMetadata(c echo.Context) error {
+	userID, _ := c.Get("userID").(uuid.UUID)
+
+	var patch map[string]json.RawMessage
+	if err := c.Bind(&patch); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	metadata, err := h.MetadataUsecase.PatchMetadata(c.Request().Context(), userID, patch)
+	if err != nil {
+		return metadataError(err, "failed to update metadata")
+	}
+	return c.JSON(http.StatusOK, metadata)
+}
+
+func metadataError(err error, msg string) error {
+	switch {
+	case errors.Is(err, customerrors.ErrInvalidMetadata):
+		return echo.NewHTTPError(http.StatusBadRequest, "metadata keys must start with a letter and contain up to 64 letters, digits, '_', '.' or '-'")
+	case errors.Is(err, customerrors.ErrMetadataTooLarge):
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, err.Error())
+	case errors.Is(err, pgx.ErrNoRows):
+		return echo.NewHTTPError(http.StatusNotFound, "user not found")
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("%s: %v", msg, err))
+}
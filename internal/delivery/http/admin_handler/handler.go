@@ -0,0 +1,446 @@
+package adminHandler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"main/domain/entity"
+	"main/pkg/customerrors"
+	"main/pkg/s3"
+	"main/pkg/tokenlist"
+	"main/pkg/userimport"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+type AdminHandler struct {
+	AdminUsecase  AdminUsecase
+	ImportUsecase ImportUsecase
+}
+
+type AdminUsecase interface {
+	//DeleteUser soft-deletes the user, a reason is required.
+	DeleteUser(ctx context.Context, adminID, userID uuid.UUID, reason entity.AdminReason) error
+
+	//RestoreUser restores a soft-deleted user.
+	RestoreUser(ctx context.Context, adminID, userID uuid.UUID) error
+
+	//ListUsers returns a page of users matching the filter.
+	ListUsers(ctx context.Context, filter entity.UserFilter) (entity.UserPage, error)
+
+	//GetUser returns the user with its active sessions.
+	GetUser(ctx context.Context, userID uuid.UUID) (entity.UserDetail, error)
+
+	//BlockUser blocks the user and revokes its sessions, a reason is required.
+	BlockUser(ctx context.Context, adminID, userID uuid.UUID, reason entity.AdminReason) error
+
+	//UnblockUser lets a blocked user log in again.
+	UnblockUser(ctx context.Context, adminID, userID uuid.UUID) error
+
+	//ListUserSessions returns a page of the sessions of the user matching the filter, continued after the cursor.
+	ListUserSessions(ctx context.Context, filter entity.SessionFilter, cursor string) (entity.SessionPage, error)
+
+	//BlockSession freezes a session of the user without deleting it, a reason is required.
+	BlockSession(ctx context.Context, adminID, userID, sessionID uuid.UUID, reason entity.AdminReason) error
+
+	//UnblockSession lets a blocked session of the user be refreshed again.
+	UnblockSession(ctx context.Context, adminID, userID, sessionID uuid.UUID) error
+
+	//ForcePasswordReset invalidates the password of the user and emails a reset link, a reason is required.
+	ForcePasswordReset(ctx context.Context, adminID, userID uuid.UUID, reason entity.AdminReason) error
+
+	//ForceLogout revokes all sessions and access tokens of the user, a reason is required.
+	ForceLogout(ctx context.Context, adminID, userID uuid.UUID, reason entity.AdminReason, dryRun bool) (entity.AffectedReport, error)
+
+	//RevokeCompromisedTokens revokes and denies the sessions of leaked refresh tokens and session IDs, a reason is required.
+	RevokeCompromisedTokens(ctx context.Context, adminID uuid.UUID, entries []string, reason entity.AdminReason, dryRun bool) (entity.CompromisedTokenReport, error)
+
+	//RevokeCompromisedTokensFrom is RevokeCompromisedTokens with the list at an object store path.
+	RevokeCompromisedTokensFrom(ctx context.Context, adminID uuid.UUID, path string, reason entity.AdminReason, dryRun bool) (entity.CompromisedTokenReport, error)
+}
+
+type ImportUsecase interface {
+	//ImportUsers imports users with pre-hashed passwords, invalid and conflicting users are reported as rejected.
+	ImportUsers(ctx context.Context, users []entity.ImportUser, dryRun bool) (entity.ImportReport, error)
+}
+
+func NewAdminHandler(adminUsecase AdminUsecase, importUsecase ImportUsecase) *AdminHandler {
+	return &AdminHandler{
+		AdminUsecase:  adminUsecase,
+		ImportUsecase: importUsecase,
+	}
+}
+
+// maxImportBodySize limits the size of an uploaded import file.
+const maxImportBodySize = 10 << 20
+
+// DTOs
+type ReasonRequest struct {
+	ReasonCode string `json:"reason_code"`
+	Reason     string `json:"reason"`
+}
+
+type ListUsersRequest struct {
+	Query  string `query:"q"`
+	Limit  int    `query:"limit"`
+	Offset int    `query:"offset"`
+}
+
+type UserResponse struct {
+	ID            string    `json:"id"`
+	Username      string    `json:"username"`
+	Email         string    `json:"email"`
+	CreatedAt     time.Time `json:"created_at"`
+	IsBlocked     bool      `json:"is_blocked"`
+	EmailVerified bool      `json:"email_verified"`
+}
+
+type ListUsersResponse struct {
+	Users []UserResponse `json:"users"`
+	Total int            `json:"total"`
+}
+
+type SessionResponse struct {
+	ID         string    `json:"id"`
+	ClientType string    `json:"client_type"`
+	IPAddress  string    `json:"ip_address"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+type ListSessionsRequest struct {
+	Status string `query:"status"`
+	Sort   string `query:"sort"`
+	Cursor string `query:"cursor"`
+	Limit  int    `query:"limit"`
+	Offset int    `query:"offset"`
+}
+
+type UserDetailResponse struct {
+	UserResponse
+	Sessions []SessionResponse `json:"sessions"`
+}
+
+// ListUsers returns a page of users, newest first, optionally filtered by ?q= on username and email.
+func (h *AdminHandler) ListUsers(c echo.Context) error {
+	var req ListUsersRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	page, err := h.AdminUsecase.ListUsers(c.Request().Context(), entity.UserFilter{
+		Query:  req.Query,
+		Limit:  req.Limit,
+		Offset: req.Offset,
+	})
+	if err != nil {
+		return adminError(err, "failed to list users")
+	}
+	resp := ListUsersResponse{
+		Users: make([]UserResponse, 0, len(page.Users)),
+		Total: page.Total,
+	}
+	for _, user := range page.Users {
+		resp.Users = append(resp.Users, userResponse(user))
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// GetUser returns the user in the path with its active sessions.
+func (h *AdminHandler) GetUser(c echo.Context) error {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid user ID")
+	}
+	detail, err := h.AdminUsecase.GetUser(c.Request().Context(), userID)
+	if err != nil {
+		return adminError(err, "failed to get user")
+	}
+	resp := UserDetailResponse{
+		UserResponse: userResponse(detail.User),
+		Sessions:     make([]SessionResponse, 0, len(detail.Sessions)),
+	}
+	for _, s := range detail.Sessions {
+		resp.Sessions = append(resp.Sessions, SessionResponse{
+			ID:         s.ID.String(),
+			ClientType: string(s.ClientType),
+			IPAddress:  s.ClientIP.String(),
+			UserAgent:  s.UserAgent,
+			CreatedAt:  s.CreatedAt,
+			ExpiresAt:  s.ExpiresAt,
+		})
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// BlockUser blocks the user in the path and revokes its sessions.
+func (h *AdminHandler) BlockUser(c echo.Context) error {
+	adminID, _ := c.Get("userID").(uuid.UUID)
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid user ID")
+	}
+	var req ReasonRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	if err := h.AdminUsecase.BlockUser(c.Request().Context(), adminID, userID, req.reason()); err != nil {
+		return adminError(err, "failed to block user")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// UnblockUser unblocks the user in the path.
+func (h *AdminHandler) UnblockUser(c echo.Context) error {
+	adminID, _ := c.Get("userID").(uuid.UUID)
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid user ID")
+	}
+	if err := h.AdminUsecase.UnblockUser(c.Request().Context(), adminID, userID); err != nil {
+		return adminError(err, "failed to unblock user")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ListSessions returns a page of the sessions of the user in the path, filtered by ?status= (active, expired or
+// blocked) and sorted by ?sort= (last_used_at or created_at), newest first. The next page is requested with the
+// next_cursor of the response as ?cursor=, or with ?offset=.
+func (h *AdminHandler) ListSessions(c echo.Context) error {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid user ID")
+	}
+	var req ListSessionsRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	page, err := h.AdminUsecase.ListUserSessions(c.Request().Context(), entity.SessionFilter{
+		UserID: userID,
+		Status: entity.SessionStatus(req.Status),
+		Sort:   entity.SessionSort(req.Sort),
+		Limit:  req.Limit,
+		Offset: req.Offset,
+	}, req.Cursor)
+	if err != nil {
+		return adminError(err, "failed to list sessions")
+	}
+	return c.JSON(http.StatusOK, page)
+}
+
+// BlockSession blocks a session of the user in the path, it stays listed but cannot be used until unblocked.
+func (h *AdminHandler) BlockSession(c echo.Context) error {
+	adminID, _ := c.Get("userID").(uuid.UUID)
+	userID, sessionID, err := sessionParams(c)
+	if err != nil {
+		return err
+	}
+	var req ReasonRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	if err := h.AdminUsecase.BlockSession(c.Request().Context(), adminID, userID, sessionID, req.reason()); err != nil {
+		return adminError(err, "failed to block session")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// UnblockSession unblocks a session of the user in the path.
+func (h *AdminHandler) UnblockSession(c echo.Context) error {
+	adminID, _ := c.Get("userID").(uuid.UUID)
+	userID, sessionID, err := sessionParams(c)
+	if err != nil {
+		return err
+	}
+	if err := h.AdminUsecase.UnblockSession(c.Request().Context(), adminID, userID, sessionID); err != nil {
+		return adminError(err, "failed to unblock session")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// sessionParams parses the user and session IDs of the session routes.
+func sessionParams(c echo.Context) (userID, sessionID uuid.UUID, err error) {
+	if userID, err = uuid.Parse(c.Param("id")); err != nil {
+		return uuid.Nil, uuid.Nil, echo.NewHTTPError(http.StatusBadRequest, "invalid user ID")
+	}
+	if sessionID, err = uuid.Parse(c.Param("session_id")); err != nil {
+		return uuid.Nil, uuid.Nil, echo.NewHTTPError(http.StatusBadRequest, "invalid session ID")
+	}
+	return userID, sessionID, nil
+}
+
+// ForcePasswordReset invalidates the password of the user in the path and emails it a reset link.
+func (h *AdminHandler) ForcePasswordReset(c echo.Context) error {
+	adminID, _ := c.Get("userID").(uuid.UUID)
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid user ID")
+	}
+	var req ReasonRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	if err := h.AdminUsecase.ForcePasswordReset(c.Request().Context(), adminID, userID, req.reason()); err != nil {
+		return adminError(err, "failed to reset password")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ForceLogout revokes all sessions and outstanding access tokens of the user in the path.
+// With ?dry_run=true it answers with the sessions that would be revoked and changes nothing.
+func (h *AdminHandler) ForceLogout(c echo.Context) error {
+	adminID, _ := c.Get("userID").(uuid.UUID)
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid user ID")
+	}
+	dryRun, err := dryRunParam(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	var req ReasonRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	report, err := h.AdminUsecase.ForceLogout(c.Request().Context(), adminID, userID, req.reason(), dryRun)
+	if err != nil {
+		return adminError(err, "failed to log out user")
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// dryRunParam parses the optional dry_run query parameter of destructive operations.
+func dryRunParam(c echo.Context) (bool, error) {
+	value := c.QueryParam("dry_run")
+	if value == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+func (r ReasonRequest) reason() entity.AdminReason {
+	return entity.AdminReason{Code: entity.AdminReasonCode(r.ReasonCode), Text: r.Reason}
+}
+
+func userResponse(user entity.User) UserResponse {
+	return UserResponse{
+		ID:            user.ID.String(),
+		Username:      user.Username,
+		Email:         user.Email,
+		CreatedAt:     user.CreatedAt,
+		IsBlocked:     user.IsBlocked,
+		EmailVerified: user.EmailVerified,
+	}
+}
+
+// DeleteUser soft-deletes the user in the path.
+func (h *AdminHandler) DeleteUser(c echo.Context) error {
+	adminID, _ := c.Get("userID").(uuid.UUID)
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid user ID")
+	}
+
+	var req ReasonRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	err = h.AdminUsecase.DeleteUser(c.Request().Context(), adminID, userID, req.reason())
+	if err != nil {
+		return adminError(err, "failed to delete user")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// RestoreUser restores the soft-deleted user in the path.
+func (h *AdminHandler) RestoreUser(c echo.Context) error {
+	adminID, _ := c.Get("userID").(uuid.UUID)
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid user ID")
+	}
+
+	if err := h.AdminUsecase.RestoreUser(c.Request().Context(), adminID, userID); err != nil {
+		return adminError(err, "failed to restore user")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ImportUsers imports the users of a JSON array or, with Content-Type text/csv, a CSV file.
+// With ?dry_run=true nothing is written and the report shows what would be imported.
+func (h *AdminHandler) ImportUsers(c echo.Context) error {
+	dryRun, err := dryRunParam(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	format := userimport.FormatJSON
+	if strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), "text/csv") {
+		format = userimport.FormatCSV
+	}
+
+	body := http.MaxBytesReader(c.Response(), c.Request().Body, maxImportBodySize)
+	users, err := userimport.Decode(body, format)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid import file: %v", err))
+	}
+	report, err := h.ImportUsecase.ImportUsers(c.Request().Context(), users, dryRun)
+	if err != nil {
+		if errors.Is(err, customerrors.ErrImportTooLarge) {
+			return echo.NewHTTPError(http.StatusRequestEntityTooLarge, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to import users: %v", err))
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// RevokeCompromisedTokens revokes the sessions of a list of compromised refresh tokens and session IDs, uploaded
+// as the body (one token per line or a JSON array) or read from the object store path in ?source=s3://bucket/key.
+// The reason is given with ?reason_code= and ?reason=, with ?dry_run=true the report shows what would be revoked.
+func (h *AdminHandler) RevokeCompromisedTokens(c echo.Context) error {
+	adminID, _ := c.Get("userID").(uuid.UUID)
+	dryRun, err := dryRunParam(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	reason := ReasonRequest{ReasonCode: c.QueryParam("reason_code"), Reason: c.QueryParam("reason")}.reason()
+
+	var report entity.CompromisedTokenReport
+	if source := c.QueryParam("source"); source != "" {
+		report, err = h.AdminUsecase.RevokeCompromisedTokensFrom(c.Request().Context(), adminID, source, reason, dryRun)
+	} else {
+		body := http.MaxBytesReader(c.Response(), c.Request().Body, maxImportBodySize)
+		entries, decodeErr := tokenlist.Decode(body)
+		if decodeErr != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid token list: %v", decodeErr))
+		}
+		report, err = h.AdminUsecase.RevokeCompromisedTokens(c.Request().Context(), adminID, entries, reason, dryRun)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, customerrors.ErrTokenListTooLarge):
+			return echo.NewHTTPError(http.StatusRequestEntityTooLarge, err.Error())
+		case errors.Is(err, customerrors.ErrObjectStoreDisabled), errors.Is(err, s3.ErrInvalidPath):
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return adminError(err, "failed to revoke tokens")
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// adminError maps the errors of admin operations to HTTP errors.
+func adminError(err error, msg string) error {
+	switch {
+	case errors.Is(err, customerrors.ErrReasonRequired), errors.Is(err, customerrors.ErrInvalidSessionFilter):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, customerrors.ErrUserNotFound), errors.Is(err, customerrors.ErrSessionNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, customerrors.ErrEmailTaken):
+		return echo.NewHTTPError(http.StatusConflict, "username or email is used by another account")
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("%s: %v", msg, err))
+}
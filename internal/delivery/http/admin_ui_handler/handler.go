@@ -0,0 +1,104 @@
+package adminUIHandler
+
+import (
+	"embed"
+	"errors"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+
+	"github.com/labstack/echo/v4"
+)
+
+// TokenCookie carries the access token of the console, so the console page can be authorized on navigation.
+// It is set by the login page and scoped to /admin/ui, the admin API itself is called with the Authorization header.
+const TokenCookie = "admin_ui_token"
+
+// LoginPath is where unauthorized visitors of the console are sent.
+const LoginPath = "/admin/ui/login"
+
+// contentSecurityPolicy only allows the embedded assets and calls to this service.
+const contentSecurityPolicy = "default-src 'none'; script-src 'self'; style-src 'self'; connect-src 'self'; form-action 'self'; frame-ancestors 'none'; base-uri 'none'"
+
+//go:embed static
+var static embed.FS
+
+// AdminUIHandler serves the admin console, a static single page application embedded in the binary
+// that manages users through the admin API. Only the login page and the assets are public.
+type AdminUIHandler struct {
+	assets fs.FS
+	limits Limits
+}
+
+func NewAdminUIHandler(limits Limits) *AdminUIHandler {
+	assets, _ := fs.Sub(static, "static")
+	return &AdminUIHandler{
+		assets: assets,
+		limits: limits,
+	}
+}
+
+// DTOs
+
+// Limits are the configured limits shown on the limits page of the console.
+type Limits struct {
+	RateLimit             int    `json:"rate_limit"`
+	RateLimitWindow       string `json:"rate_limit_window"`
+	RateLimitStore        string `json:"rate_limit_store"`
+	AccessTokenTTL        string `json:"access_token_ttl"`
+	SessionTTL            string `json:"session_ttl"`
+	RegistrationOpen      bool   `json:"registration_open"`
+	InviteOnly            bool   `json:"invite_only"`
+	PhoneOTPEnabled       bool   `json:"phone_otp_enabled"`
+	PhoneOTPMaxAttempts   int    `json:"phone_otp_max_attempts"`
+	AccountDeletionPeriod string `json:"account_deletion_grace_period"`
+}
+
+// Index serves the console page.
+func (h *AdminUIHandler) Index(c echo.Context) error {
+	return h.file(c, "index.html")
+}
+
+// Login serves the login page of the console.
+func (h *AdminUIHandler) Login(c echo.Context) error {
+	return h.file(c, "login.html")
+}
+
+// Asset serves the scripts and styles of the console.
+func (h *AdminUIHandler) Asset(c echo.Context) error {
+	name := path.Clean(c.Param("*"))
+	if path.Ext(name) != ".js" && path.Ext(name) != ".css" {
+		return echo.ErrNotFound
+	}
+	return h.file(c, name)
+}
+
+// Limits returns the configured limits.
+func (h *AdminUIHandler) Limits(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.limits)
+}
+
+// RedirectToLogin sends visitors of console pages without a valid admin token to the login page
+// instead of answering with an error.
+func (h *AdminUIHandler) RedirectToLogin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		err := next(c)
+		var he *echo.HTTPError
+		if errors.As(err, &he) && (he.Code == http.StatusUnauthorized || he.Code == http.StatusForbidden) {
+			return c.Redirect(http.StatusFound, LoginPath)
+		}
+		return err
+	}
+}
+
+func (h *AdminUIHandler) file(c echo.Context, name string) error {
+	c.Response().Header().Set("Content-Security-Policy", contentSecurityPolicy)
+	c.Response().Header().Set("X-Content-Type-Options", "nosniff")
+	c.Response().Header().Set("Cache-Control", "no-cache")
+	data, err := fs.ReadFile(h.assets, name)
+	if err != nil {
+		return echo.ErrNotFound
+	}
+	return c.Blob(http.StatusOK, mime.TypeByExtension(path.Ext(name)), data)
+}
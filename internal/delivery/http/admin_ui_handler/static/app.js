@@ -0,0 +1,245 @@
+'use strict';
+
+const pageSize = 50;
+const state = { query: '', offset: 0, total: 0, user: null };
+
+function token() {
+  const stored = sessionStorage.getItem('admin_token');
+  if (stored) return stored;
+  const cookie = document.cookie.split('; ').find((c) => c.startsWith('admin_ui_token='));
+  return cookie ? decodeURIComponent(cookie.split('=')[1]) : '';
+}
+
+function storeToken(accessToken) {
+  sessionStorage.setItem('admin_token', accessToken);
+  const secure = location.protocol === 'https:' ? '; Secure' : '';
+  document.cookie = 'admin_ui_token=' + encodeURIComponent(accessToken) + '; Path=/admin/ui; SameSite=Strict' + secure;
+}
+
+function signOut() {
+  sessionStorage.removeItem('admin_token');
+  document.cookie = 'admin_ui_token=; Path=/admin/ui; Max-Age=0';
+  location.href = '/admin/ui/login';
+}
+
+// elevate elevates the session after asking for the password again and, for accounts with a second factor,
+// its code. It returns false when the admin cancelled.
+async function elevate() {
+  const password = prompt('This action needs your password again');
+  if (password === null) return false;
+  let body = { password };
+  for (;;) {
+    const resp = await fetch('/me/elevate', {
+      method: 'POST',
+      headers: { 'Authorization': 'Bearer ' + token(), 'Content-Type': 'application/json' },
+      body: JSON.stringify(body),
+    });
+    const data = await resp.json().catch(() => ({}));
+    if (resp.ok) {
+      storeToken(data.access_token);
+      return true;
+    }
+    if (data.code !== 'mfa_required') {
+      throw new Error(data.error || data.message || 'elevation failed');
+    }
+    const code = prompt(data.destination ? `Code sent to ${data.destination}` : 'Code of your authenticator app');
+    if (code === null) return false;
+    body = { challenge_id: data.challenge_id, code: code.trim() };
+  }
+}
+
+// api calls the admin API with the access token, an expired token sends the user back to the login page.
+// Operations refused outside of an elevated session are repeated once the session is elevated.
+async function api(method, path, body, elevated) {
+  const resp = await fetch(path, {
+    method,
+    headers: { 'Authorization': 'Bearer ' + token(), 'Content-Type': 'application/json' },
+    body: body === undefined ? undefined : JSON.stringify(body),
+  });
+  if (resp.status === 401) {
+    signOut();
+    throw new Error('session expired');
+  }
+  if (!resp.ok) {
+    const err = await resp.json().catch(() => ({}));
+    if (err.code === 'elevation_required' && !elevated) {
+      if (!(await elevate())) throw new Error('cancelled');
+      return api(method, path, body, true);
+    }
+    const error = new Error(err.error || resp.statusText);
+    error.status = resp.status;
+    throw error;
+  }
+  return resp.status === 204 ? null : resp.json();
+}
+
+function showError(err) {
+  const el = document.getElementById('error');
+  el.textContent = err ? err.message : '';
+  el.hidden = !err;
+}
+
+function cell(row, text) {
+  const td = document.createElement('td');
+  td.textContent = text;
+  row.appendChild(td);
+  return td;
+}
+
+function date(value) {
+  return value ? new Date(value).toLocaleString() : '';
+}
+
+function fields(dl, entries) {
+  dl.replaceChildren();
+  for (const [name, value] of entries) {
+    const dt = document.createElement('dt');
+    dt.textContent = name;
+    const dd = document.createElement('dd');
+    dd.textContent = value;
+    dl.append(dt, dd);
+  }
+}
+
+async function loadUsers() {
+  const params = new URLSearchParams({ q: state.query, limit: pageSize, offset: state.offset });
+  const page = await api('GET', '/admin/users?' + params);
+  state.total = page.total;
+  const tbody = document.getElementById('users');
+  tbody.replaceChildren();
+  for (const user of page.users) {
+    const row = document.createElement('tr');
+    row.dataset.id = user.id;
+    cell(row, user.username);
+    cell(row, user.email);
+    cell(row, date(user.created_at));
+    const badge = document.createElement('span');
+    badge.className = user.is_blocked ? 'badge blocked' : 'badge';
+    badge.textContent = user.is_blocked ? 'blocked' : 'active';
+    cell(row, '').appendChild(badge);
+    row.addEventListener('click', () => loadUser(user.id).catch(showError));
+    tbody.appendChild(row);
+  }
+  const last = Math.min(state.offset + pageSize, state.total);
+  document.getElementById('page-info').textContent = state.total ? `${state.offset + 1}–${last} of ${state.total}` : 'No users';
+  document.getElementById('prev').disabled = state.offset === 0;
+  document.getElementById('next').disabled = last >= state.total;
+}
+
+async function loadUser(id) {
+  const user = await api('GET', '/admin/users/' + encodeURIComponent(id));
+  state.user = user;
+  document.getElementById('detail').hidden = false;
+  document.getElementById('detail-title').textContent = user.username;
+  fields(document.getElementById('detail-fields'), [
+    ['ID', user.id],
+    ['Email', user.email + (user.email_verified ? ' (verified)' : '')],
+    ['Created', date(user.created_at)],
+    ['Status', user.is_blocked ? 'blocked' : 'active'],
+  ]);
+  document.getElementById('block').hidden = user.is_blocked;
+  document.getElementById('unblock').hidden = !user.is_blocked;
+  const tbody = document.getElementById('sessions');
+  tbody.replaceChildren();
+  for (const s of user.sessions) {
+    const row = document.createElement('tr');
+    cell(row, s.client_type);
+    cell(row, s.ip_address);
+    cell(row, s.user_agent);
+    cell(row, date(s.created_at));
+    cell(row, date(s.expires_at));
+    tbody.appendChild(row);
+  }
+}
+
+// askReason prompts for the structured reason destructive actions require.
+function askReason(action) {
+  const codes = ['security_incident', 'abuse', 'fraud', 'user_request', 'legal', 'other'];
+  const code = prompt(`Reason code for "${action}" (${codes.join(', ')})`, 'other');
+  if (code === null) return null;
+  const text = prompt('Reason');
+  if (text === null) return null;
+  return { reason_code: code.trim(), reason: text };
+}
+
+async function userAction(action, method, suffix, needsReason) {
+  if (!state.user) return;
+  let body;
+  if (needsReason) {
+    body = askReason(action);
+    if (!body) return;
+  }
+  await api(method, '/admin/users/' + encodeURIComponent(state.user.id) + suffix, body);
+  if (method === 'DELETE') {
+    document.getElementById('detail').hidden = true;
+    state.user = null;
+  } else {
+    await loadUser(state.user.id);
+  }
+  await loadUsers();
+}
+
+async function loadAudit() {
+  const tbody = document.getElementById('audit');
+  const empty = document.getElementById('audit-empty');
+  tbody.replaceChildren();
+  empty.hidden = true;
+  let events;
+  try {
+    events = await api('GET', '/admin/audit');
+  } catch (err) {
+    if (err.status !== 404) throw err;
+    empty.textContent = 'This server does not keep an audit log.';
+    empty.hidden = false;
+    return;
+  }
+  for (const e of events.events || []) {
+    const row = document.createElement('tr');
+    cell(row, date(e.created_at));
+    cell(row, e.actor_id || '');
+    cell(row, e.action);
+    cell(row, e.target_id || '');
+    cell(row, [e.reason_code, e.reason].filter(Boolean).join(': '));
+    tbody.appendChild(row);
+  }
+}
+
+async function loadLimits() {
+  const limits = await api('GET', '/admin/ui/limits');
+  fields(document.getElementById('limits'), Object.entries(limits).map(([k, v]) => [k.replaceAll('_', ' '), String(v)]));
+}
+
+const views = { users: loadUsers, audit: loadAudit, limits: loadLimits };
+
+function route() {
+  const name = views[location.hash.slice(1)] ? location.hash.slice(1) : 'users';
+  for (const view of Object.keys(views)) {
+    document.getElementById('view-' + view).hidden = view !== name;
+    document.querySelector(`[data-view="${view}"]`).classList.toggle('active', view === name);
+  }
+  showError(null);
+  views[name]().catch(showError);
+}
+
+document.getElementById('search').addEventListener('submit', (event) => {
+  event.preventDefault();
+  state.query = new FormData(event.target).get('q');
+  state.offset = 0;
+  loadUsers().catch(showError);
+});
+document.getElementById('prev').addEventListener('click', () => {
+  state.offset = Math.max(state.offset - pageSize, 0);
+  loadUsers().catch(showError);
+});
+document.getElementById('next').addEventListener('click', () => {
+  state.offset += pageSize;
+  loadUsers().catch(showError);
+});
+document.getElementById('block').addEventListener('click', () => userAction('block', 'POST', '/block', true).catch(showError));
+document.getElementById('unblock').addEventListener('click', () => userAction('unblock', 'POST', '/unblock', false).catch(showError));
+document.getElementById('force-logout').addEventListener('click', () => userAction('force logout', 'POST', '/logout', true).catch(showError));
+document.getElementById('reset').addEventListener('click', () => userAction('force password reset', 'POST', '/password-reset', true).catch(showError));
+document.getElementById('delete').addEventListener('click', () => userAction('delete', 'DELETE', '', true).catch(showError));
+document.getElementById('logout').addEventListener('click', signOut);
+window.addEventListener('hashchange', route);
+route();
@@ -0,0 +1,111 @@
+'use strict';
+
+// Signs in with the regular login endpoint. The access token is kept for the session of the tab and as a
+// cookie scoped to the console, so the console page itself can be authorized on navigation.
+// Logins can take more steps than the password: the code of the second factor (mfa_required) and the
+// acceptance of updated terms (terms_not_accepted), after which the login is repeated with the accepted documents.
+const state = { login: '', password: '', challengeId: '', acceptTerms: [] };
+
+const forms = ['login-form', 'mfa-form', 'terms-form'].map((id) => document.getElementById(id));
+
+function show(form, message) {
+  for (const f of forms) f.hidden = f !== form;
+  const error = form.querySelector('.error');
+  error.textContent = message || '';
+  error.hidden = !message;
+}
+
+async function post(path, body) {
+  const resp = await fetch(path, {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify(body),
+  });
+  const data = await resp.json().catch(() => ({}));
+  return { resp, data };
+}
+
+function signIn() {
+  return post('/login', { login: state.login, password: state.password, accept_terms: state.acceptTerms });
+}
+
+// step handles the answer of the login or of the code verification, form is the form it was sent from.
+async function step(form, { resp, data }) {
+  if (resp.ok) {
+    finish(data.access_token);
+    return;
+  }
+  switch (data.code) {
+    case 'mfa_required':
+      state.challengeId = data.challenge_id;
+      document.getElementById('mfa-hint').textContent = data.destination
+        ? `Enter the code sent to ${data.destination}.`
+        : 'Enter the code of your authenticator app.';
+      document.getElementById('mfa-form').reset();
+      show(document.getElementById('mfa-form'));
+      return;
+    case 'terms_not_accepted':
+      await showTerms();
+      return;
+  }
+  if (resp.status === 429) {
+    show(form, 'Too many attempts, try again later.');
+  } else if (form.id === 'mfa-form') {
+    show(form, resp.status === 401 ? 'Invalid code.' : data.message || 'Sign in failed.');
+  } else {
+    show(document.getElementById('login-form'), resp.status === 401 ? 'Invalid credentials.' : data.message || 'Sign in failed.');
+  }
+}
+
+async function showTerms() {
+  const resp = await fetch('/terms');
+  const docs = resp.ok ? await resp.json() : [];
+  const list = document.getElementById('terms-list');
+  list.replaceChildren();
+  for (const doc of docs) {
+    const link = document.createElement('a');
+    link.href = doc.url;
+    link.target = '_blank';
+    link.rel = 'noopener';
+    link.textContent = `${doc.kind} (${doc.version})`;
+    const item = document.createElement('li');
+    item.appendChild(link);
+    list.appendChild(item);
+  }
+  state.acceptTerms = docs.map((doc) => doc.id);
+  document.getElementById('terms-form').reset();
+  show(document.getElementById('terms-form'));
+}
+
+function finish(accessToken) {
+  sessionStorage.setItem('admin_token', accessToken);
+  const secure = location.protocol === 'https:' ? '; Secure' : '';
+  document.cookie = 'admin_ui_token=' + encodeURIComponent(accessToken) + '; Path=/admin/ui; SameSite=Strict' + secure;
+  location.href = '/admin/ui';
+}
+
+document.getElementById('login-form').addEventListener('submit', async (event) => {
+  event.preventDefault();
+  const form = new FormData(event.target);
+  state.login = form.get('login');
+  state.password = form.get('password');
+  state.acceptTerms = [];
+  await step(event.target, await signIn());
+});
+
+document.getElementById('mfa-form').addEventListener('submit', async (event) => {
+  event.preventDefault();
+  const code = new FormData(event.target).get('code').trim();
+  await step(event.target, await post('/login/mfa/verify', {
+    challenge_id: state.challengeId,
+    code,
+    accept_terms: state.acceptTerms,
+  }));
+});
+
+// the verification of the code used up its challenge, the login starts over with the accepted terms and asks
+// for a new code if the account has a second factor
+document.getElementById('terms-form').addEventListener('submit', async (event) => {
+  event.preventDefault();
+  await step(event.target, await signIn());
+});
@@ -0,0 +1,73 @@
+package auditHandler
+
+import (
+	"context"
+	"fmt"
+	"main/domain/entity"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+type AuditHandler struct {
+	AuditUsecase AuditUsecase
+}
+
+type AuditUsecase interface {
+	//List returns a page of the audit events matching the filter, newest first.
+	List(ctx context.Context, filter entity.AuditFilter) (entity.AuditPage, error)
+}
+
+func NewAuditHandler(auditUsecase AuditUsecase) *AuditHandler {
+	return &AuditHandler{
+		AuditUsecase: auditUsecase,
+	}
+}
+
+// DTOs
+type ListAuditRequest struct {
+	// UserID matches events the user performed or was the target of
+	UserID string `query:"user_id"`
+	Action string `query:"action"`
+	// From and To bound the time of the events as RFC 3339 timestamps, To is exclusive
+	From   string `query:"from"`
+	To     string `query:"to"`
+	Limit  int    `query:"limit"`
+	Offset int    `query:"offset"`
+}
+
+// List returns a page of the audit log filtered by user, action and time range.
+func (h *AuditHandler) List(c echo.Context) error {
+	var req ListAuditRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	filter := entity.AuditFilter{Action: req.Action, Limit: req.Limit, Offset: req.Offset}
+	var err error
+	if req.UserID != "" {
+		if filter.UserID, err = uuid.Parse(req.UserID); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid user ID")
+		}
+	}
+	if req.From != "" {
+		if filter.From, err = time.Parse(time.RFC3339, req.From); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid from, expected an RFC 3339 timestamp")
+		}
+	}
+	if req.To != "" {
+		if filter.To, err = time.Parse(time.RFC3339, req.To); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid to, expected an RFC 3339 timestamp")
+		}
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return echo.NewHTTPError(http.StatusBadRequest, "from must be before to")
+	}
+
+	page, err := h.AuditUsecase.List(c.Request().Context(), filter)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to list audit events: %v", err))
+	}
+	return c.JSON(http.StatusOK, page)
+}
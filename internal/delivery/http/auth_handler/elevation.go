@@ -0,0 +1,81 @@
+package authHandler
+
+import (
+	"errors"
+	"fmt"
+	"main/domain/entity"
+	"main/pkg/customerrors"
+	"main/pkg/dpop"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+)
+
+// ElevateRequest is sent twice by users with two-factor authentication: first with the password, then with
+// the challenge ID and the code of the mfa_required answer.
+type ElevateRequest struct {
+	Password    string    `json:"password"`
+	ChallengeID uuid.UUID `json:"challenge_id"`
+	Code        string    `json:"code"`
+}
+
+// Elevate elevates the session of the access token after the user authenticated again, the most sensitive admin
+// operations are refused outside of an elevation. The session is rotated: the answer carries a new access token
+// with the elevated_until claim and the new refresh token, in the refresh_token cookie for web sessions and in
+// the body for native ones.
+func (h *AuthHandler) Elevate(c echo.Context) error {
+	userID, _ := c.Get("userID").(uuid.UUID)
+	sessionID, _ := c.Get("sessionID").(uuid.UUID)
+	if sessionID == uuid.Nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "the access token belongs to no session, log in again")
+	}
+	var req ElevateRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+
+	tokens, err := h.AuthUsecase.ElevateSession(c.Request().Context(), entity.ElevationInput{
+		UserID:      userID,
+		SessionID:   sessionID,
+		Password:    req.Password,
+		ChallengeID: req.ChallengeID,
+		Code:        req.Code,
+		IP:          c.RealIP(),
+	})
+	if err != nil {
+		if errors.Is(err, customerrors.ErrMFARequired) && tokens.MFA != nil {
+			return c.JSON(http.StatusUnauthorized, MFARequiredResponse{Error: err.Error(), Code: "mfa_required", MFAChallenge: *tokens.MFA})
+		}
+		if errors.Is(err, customerrors.ErrElevationDisabled) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		if errors.Is(err, customerrors.ErrUserBlocked) || errors.Is(err, customerrors.ErrSessionBlocked) {
+			return echo.NewHTTPError(http.StatusForbidden, err.Error())
+		}
+		if errors.Is(err, customerrors.ErrTooManyAttempts) {
+			return echo.NewHTTPError(http.StatusTooManyRequests, "too many attempts, start the elevation again")
+		}
+		if errors.Is(err, customerrors.ErrInvalidCredentials) || errors.Is(err, customerrors.ErrInvalidOTP) {
+			return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+		}
+		if errors.Is(err, pgx.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusUnauthorized, "session has ended, log in again")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to elevate session: %v", err))
+	}
+
+	native := tokens.ClientType.Native()
+	if !native {
+		h.RefreshCookie.Set(c, tokens.RefreshToken)
+	}
+	body := tokenResponse(tokens, native)
+	// the tokens keep the DPoP binding of the session
+	if _, isDPoP, _ := dpop.ParseAuthorization(c.Request().Header.Get("authorization")); isDPoP {
+		body["token_type"] = "DPoP"
+	}
+	body["elevated_until"] = tokens.ElevatedUntil.UTC().Format(time.RFC3339)
+	return c.JSON(http.StatusOK, body)
+}
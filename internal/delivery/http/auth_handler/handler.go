@@ -2,42 +2,100 @@ package authHandler
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"main/domain/entity"
+	"main/internal/delivery/http/cookie"
 	"main/internal/metrics"
+	"main/pkg/customerrors"
+	"main/pkg/dpop"
+	"main/pkg/locale"
+	"main/pkg/utils"
+	ctxUtil "main/pkg/utils/context"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/labstack/echo/v4"
 )
 
 type AuthHandler struct {
-	AuthUsecase AuthUsecase
-	Metrics     *metrics.Metrics
+	AuthUsecase    AuthUsecase
+	PhoneUsecase   PhoneUsecase
+	PasskeyUsecase PasskeyUsecase
+	MFAUsecase     MFAUsecase
+	Metrics        *metrics.Metrics
+	RefreshCookie  *cookie.RefreshToken
 }
 
+// The app attestation headers of native clients: the platform (android or ios), its attestation token and the
+// challenge from /attestation/challenge it was requested for.
+const (
+	attestationPlatformHeader  = "X-App-Platform"
+	attestationHeader          = "X-App-Attestation"
+	attestationChallengeHeader = "X-App-Attestation-Challenge"
+)
+
 type AuthUsecase interface {
 
 	//RegisterUser registers a new user and returns the user ID as a string.
-	RegisterUser(ctx context.Context, username, email, password string) (userID uuid.UUID, err error)
+	RegisterUser(ctx context.Context, in entity.RegisterInput) (userID uuid.UUID, warnings []string, err error)
 
 	//LoginUser authenticates a user and returns the user ID, access token, and refresh token.
-	LoginUser(ctx context.Context, login, password, userAgent string, ip string) (userID uuid.UUID, accessToken string, refreshToken string, err error)
+	//Users with two-factor authentication get customerrors.ErrMFARequired and the started challenge instead.
+	LoginUser(ctx context.Context, in entity.LoginInput) (entity.IssuedTokens, error)
+
+	//CompleteMFALogin finishes a login with the code of its second factor.
+	CompleteMFALogin(ctx context.Context, in entity.MFALoginInput) (entity.IssuedTokens, error)
 
 	//LogoutSession logs out a user from a specific session.
 	LogoutSession(ctx context.Context, userID string, sessionID string) error
 
-	//LogoutAllSessions logs out a user from all sessions.
-	LogoutAllSessions(ctx context.Context, userID string) error
+	//LogoutAllSessions logs out a user from all sessions, in dry-run mode it only reports the sessions.
+	LogoutAllSessions(ctx context.Context, userID string, dryRun bool) (entity.AffectedReport, error)
 
 	//RefreshSessionToken refreshes the access token using a valid refresh token and returns the new access token and refresh token.
-	RefreshSessionToken(ctx context.Context, refreshToken string) (newAccessToken string, newRefreshToken string, err error)
+	RefreshSessionToken(ctx context.Context, in entity.RefreshInput) (entity.IssuedTokens, error)
+
+	//CheckAvailability reports whether the username and email can be used for a new account.
+	CheckAvailability(ctx context.Context, username, email string) (available bool, err error)
+
+	//GetProfile returns the record of the user.
+	GetProfile(ctx context.Context, userID uuid.UUID) (entity.User, error)
+
+	//VerifyProof verifies a DPoP proof and returns the thumbprint of its key.
+	VerifyProof(ctx context.Context, proof, method, uri, accessToken string) (jkt string, err error)
+
+	//LoginHistory returns a page of the login attempts on the account of the user, newest first.
+	LoginHistory(ctx context.Context, userID uuid.UUID, limit, offset int) (entity.LoginEventPage, error)
+
+	//ListSessions returns the active sessions of the user, the one of currentID marked as current.
+	ListSessions(ctx context.Context, userID, currentID uuid.UUID) ([]entity.ActiveSession, error)
+
+	//RevokeSession ends one session of the user, customerrors.ErrSessionNotFound if there is none with the ID.
+	RevokeSession(ctx context.Context, userID, sessionID uuid.UUID) error
+
+	//RenameSession sets the device name of a session of the user, customerrors.ErrSessionNotFound if there is none.
+	RenameSession(ctx context.Context, userID, sessionID uuid.UUID, name string) error
+
+	//AttestationChallenge returns a challenge for a mobile app to request its attestation token for.
+	AttestationChallenge(ctx context.Context) (entity.AttestationChallenge, error)
+
+	//ElevateSession elevates the session for sensitive admin operations after the user authenticated again.
+	//Users with two-factor authentication get customerrors.ErrMFARequired and the started challenge first.
+	ElevateSession(ctx context.Context, in entity.ElevationInput) (entity.IssuedTokens, error)
 }
 
-func NewAuthHandler(authUsecase AuthUsecase, metrics *metrics.Metrics) *AuthHandler {
+func NewAuthHandler(authUsecase AuthUsecase, phoneUsecase PhoneUsecase, passkeyUsecase PasskeyUsecase, mfaUsecase MFAUsecase, metrics *metrics.Metrics, refreshCookie *cookie.RefreshToken) *AuthHandler {
 	return &AuthHandler{
-		AuthUsecase: authUsecase,
-		Metrics:     metrics,
+		AuthUsecase:    authUsecase,
+		PhoneUsecase:   phoneUsecase,
+		PasskeyUsecase: passkeyUsecase,
+		MFAUsecase:     mfaUsecase,
+		Metrics:        metrics,
+		RefreshCookie:  refreshCookie,
 	}
 }
 
@@ -46,15 +104,80 @@ type RegisterRequest struct {
 	Username string `json:"username"`
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	// Phone is optional, any common format is accepted and stored in E.164
+	Phone string `json:"phone"`
+	// InviteCode is required when registration is invite-only
+	InviteCode string `json:"invite_code"`
+	// AcceptTerms are the IDs of the terms documents (GET /terms) the user accepted
+	AcceptTerms []uuid.UUID `json:"accept_terms"`
+	// CaptchaToken is the response of the CAPTCHA widget, required when registrations require a CAPTCHA
+	CaptchaToken string `json:"captcha_token"`
 }
 
 type LoginRequest struct {
 	Login    string `json:"login"`
 	Password string `json:"password"`
+	// ClientType is one of web, mobile, cli, service. Defaults to web.
+	ClientType string `json:"client_type"`
+	// AcceptTerms are the IDs of the terms documents the user accepted, sent again after a terms_not_accepted answer
+	AcceptTerms []uuid.UUID `json:"accept_terms"`
+	// CaptchaToken is the response of the CAPTCHA widget, sent again after a captcha_required answer
+	CaptchaToken string `json:"captcha_token"`
+	// DeviceName names the device of the new session in the sessions list, e.g. "Dan's iPhone"
+	DeviceName string `json:"device_name"`
+}
+
+type ProfileResponse struct {
+	ID            string    `json:"id"`
+	Username      string    `json:"username"`
+	Email         string    `json:"email"`
+	CreatedAt     time.Time `json:"created_at"`
+	EmailVerified bool      `json:"email_verified"`
+}
+
+type RegisterResponse struct {
+	UserID   string   `json:"user_id"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// RegistrationRejectedResponse tells clients why the registration policy refused the account,
+// Code is one of registration_closed, email_domain_not_allowed, disposable_email, invite_required, terms_not_accepted,
+// handle_unavailable or captcha_required.
+type RegistrationRejectedResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+// TermsNotAcceptedResponse answers logins of users who have to accept the current terms documents first,
+// Code is always terms_not_accepted. The client shows GET /terms and repeats the login with accept_terms.
+type TermsNotAcceptedResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+// CaptchaRequiredResponse answers logins from an IP with too many failed logins that sent no CAPTCHA token
+// or a rejected one, Code is always captcha_required. The client shows the widget and repeats the login with its token.
+type CaptchaRequiredResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+type NativeRefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type LoginHistoryRequest struct {
+	Limit  int `query:"limit"`
+	Offset int `query:"offset"`
+}
+
+type AvailabilityRequest struct {
+	Username string `query:"username"`
+	Email    string `query:"email"`
 }
 
+// LogoutRequest names the session to end, the current one when empty. The user is always the one of the access token.
 type LogoutRequest struct {
-	UserID    string `json:"user_id"`
 	SessionID string `json:"session_id"`
 }
 
@@ -63,11 +186,57 @@ func (h *AuthHandler) Register(c echo.Context) error {
 	if err := c.Bind(&req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
 	}
-	userID, err := h.AuthUsecase.RegisterUser(c.Request().Context(), req.Username, req.Email, req.Password)
+	userID, warnings, err := h.AuthUsecase.RegisterUser(c.Request().Context(), entity.RegisterInput{
+		Username:      req.Username,
+		Email:         req.Email,
+		Password:      req.Password,
+		Phone:         req.Phone,
+		InviteCode:    req.InviteCode,
+		AcceptedTerms: req.AcceptTerms,
+		IP:            c.RealIP(),
+		CaptchaToken:  req.CaptchaToken,
+	})
 	if err != nil {
+		if errors.Is(err, customerrors.ErrPasswordBreached) || errors.Is(err, customerrors.ErrInvalidPhone) ||
+			errors.Is(err, customerrors.ErrInvalidTermsDocument) {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		if errors.Is(err, customerrors.ErrEmailTaken) || errors.Is(err, customerrors.ErrPhoneTaken) ||
+			errors.Is(err, customerrors.ErrUsernameTaken) {
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		}
+		if code, ok := registrationRejection(err); ok {
+			return c.JSON(http.StatusForbidden, RegistrationRejectedResponse{Error: err.Error(), Code: code})
+		}
 		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to register user: %v", err))
 	}
-	return c.JSON(201, map[string]string{"user_id": userID.String()})
+	return c.JSON(201, RegisterResponse{UserID: userID.String(), Warnings: warnings})
+}
+
+// registrationRejection returns the machine-readable code of an error of the registration policy.
+func registrationRejection(err error) (string, bool) {
+	switch {
+	case errors.Is(err, customerrors.ErrRegistrationClosed):
+		return "registration_closed", true
+	case errors.Is(err, customerrors.ErrEmailDomainNotAllowed):
+		return "email_domain_not_allowed", true
+	case errors.Is(err, customerrors.ErrDisposableEmail):
+		return "disposable_email", true
+	case errors.Is(err, customerrors.ErrInvalidInvite):
+		return "invite_required", true
+	case errors.Is(err, customerrors.ErrTermsNotAccepted):
+		return "terms_not_accepted", true
+	case errors.Is(err, customerrors.ErrHandleUnavailable):
+		return "handle_unavailable", true
+	case errors.Is(err, customerrors.ErrCaptchaRequired):
+		return "captcha_required", true
+	}
+	return "", false
+}
+
+// termsNotAccepted answers a login rejected until the user accepts the current terms documents.
+func termsNotAccepted(c echo.Context, err error) error {
+	return c.JSON(http.StatusForbidden, TermsNotAcceptedResponse{Error: err.Error(), Code: "terms_not_accepted"})
 }
 
 func (h *AuthHandler) Login(c echo.Context) error {
@@ -75,44 +244,71 @@ func (h *AuthHandler) Login(c echo.Context) error {
 	if err := c.Bind(&req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
 	}
-	userID, accessToken, refreshToken, err := h.AuthUsecase.LoginUser(
-		c.Request().Context(),
-		req.Login,
-		req.Password,
-		c.Request().UserAgent(),
-		c.RealIP())
+	jkt, err := h.proofThumbprint(c)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusUnauthorized, fmt.Sprintf("invalid credentials: %v", err))
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid DPoP proof: %v", err))
 	}
-
-	cookie := &http.Cookie{
-		Name:     "refresh_token",
-		Value:    refreshToken,
-		HttpOnly: true,
-		Secure:   true,
-		Expires:  time.Now().Add(15 * 24 * time.Hour),
-		Path:     "/",
-		// could add SameSite attribute if needed
-		// could add another sites for different environments (e.g., development vs production)
+	tokens, err := h.AuthUsecase.LoginUser(c.Request().Context(), entity.LoginInput{
+		Login:          req.Login,
+		Password:       req.Password,
+		UserAgent:      c.Request().UserAgent(),
+		AcceptLanguage: c.Request().Header.Get("Accept-Language"),
+		Timezone:       c.Request().Header.Get(locale.TimezoneHeader),
+		IP:             c.RealIP(),
+		ClientType:     req.ClientType,
+		CertThumbprint: utils.RequestCertThumbprint(c.Request()),
+		DPoPThumbprint: jkt,
+		AcceptedTerms:  req.AcceptTerms,
+		TrustedDevice:  trustedDevice(c),
+		Attestation:    attestation(c),
+		CaptchaToken:   req.CaptchaToken,
+		DeviceName:     req.DeviceName,
+	})
+	if err != nil {
+		if errors.Is(err, customerrors.ErrMFARequired) && tokens.MFA != nil {
+			return c.JSON(http.StatusUnauthorized, MFARequiredResponse{Error: err.Error(), Code: "mfa_required", MFAChallenge: *tokens.MFA})
+		}
+		if errors.Is(err, customerrors.ErrCaptchaRequired) {
+			return c.JSON(http.StatusForbidden, CaptchaRequiredResponse{Error: err.Error(), Code: "captcha_required"})
+		}
+		if errors.Is(err, customerrors.ErrEmailNotVerified) || errors.Is(err, customerrors.ErrUserBlocked) ||
+			errors.Is(err, customerrors.ErrAttestationFailed) || errors.Is(err, customerrors.ErrLoginLocationBlocked) {
+			return echo.NewHTTPError(http.StatusForbidden, err.Error())
+		}
+		if errors.Is(err, customerrors.ErrTermsNotAccepted) {
+			return termsNotAccepted(c, err)
+		}
+		if errors.Is(err, customerrors.ErrInvalidTermsDocument) || errors.Is(err, customerrors.ErrInvalidDeviceName) {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		if errors.Is(err, customerrors.ErrInvalidCredentials) || errors.Is(err, customerrors.ErrUserNotFound) {
+			return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusUnauthorized, fmt.Sprintf("invalid credentials: %v", err))
 	}
 
-	c.SetCookie(cookie)
-	c.Set("user_id", userID) // Store user ID in context for later use (e.g., in refresh handler)
+	h.RefreshCookie.Set(c, tokens.RefreshToken)
+	c.Set("user_id", tokens.UserID) // Store user ID in context for later use (e.g., in refresh handler)
 
-	return c.JSON(200, map[string]string{"access_token": accessToken})
+	return c.JSON(200, tokenResponse(tokens, tokens.ClientType.Native()))
 
 }
 
-// Logout handles the logout request by invalidating the specified session for the user.
-// It expects a JSON payload containing the user ID and session ID. If the request is valid and the session is successfully invalidated,
+// Logout handles the logout request by invalidating a session of the authenticated user.
+// It expects a JSON payload with the session ID, the session of the access token when omitted. If the request is valid and the session is successfully invalidated,
 // it returns a 204 No Content response. If there are any errors during the process, it returns an appropriate HTTP error response.
 func (h *AuthHandler) Logout(c echo.Context) error {
+	userID, _ := c.Get("userID").(uuid.UUID)
 	var req LogoutRequest
 
 	if err := c.Bind(&req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
 	}
-	err := h.AuthUsecase.LogoutSession(c.Request().Context(), req.UserID, req.SessionID)
+	if req.SessionID == "" {
+		sessionID, _ := c.Get("sessionID").(uuid.UUID)
+		req.SessionID = sessionID.String()
+	}
+	err := h.AuthUsecase.LogoutSession(c.Request().Context(), userID.String(), req.SessionID)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to logout session: %v", err))
 	}
@@ -120,56 +316,224 @@ func (h *AuthHandler) Logout(c echo.Context) error {
 	return c.NoContent(204)
 }
 
-// LogoutAll handles the logout request by invalidating all sessions for the user.
+// LogoutAll handles the logout request by invalidating all sessions of the authenticated user.
+// With ?dry_run=true it answers with the sessions that would be revoked and changes nothing.
 func (h *AuthHandler) LogoutAll(c echo.Context) error {
-	var req LogoutRequest
-	if err := c.Bind(&req); err != nil {
+	userID, _ := c.Get("userID").(uuid.UUID)
+	dryRun, err := dryRunParam(c)
+	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
 	}
-	err := h.AuthUsecase.LogoutAllSessions(c.Request().Context(), req.UserID)
+	report, err := h.AuthUsecase.LogoutAllSessions(c.Request().Context(), userID.String(), dryRun)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to logout all sessions: %v", err))
 	}
+	if dryRun {
+		return c.JSON(200, report)
+	}
 
-	c.SetCookie(
-		&http.Cookie{
-			Name:     "refresh_token",
-			Value:    "",
-			HttpOnly: true,
-			Secure:   true,
-			Expires:  time.Unix(0, 0), // Expire the cookie immediately
-		},
-	)
+	h.RefreshCookie.Clear(c)
 
 	return c.NoContent(204)
 }
 
 // RefreshSession handles the session refresh request by validating the provided refresh token and issuing a new access token and refresh token if the refresh token is valid.
 func (h *AuthHandler) RefreshSession(c echo.Context) error {
-	refreshTokenCookie, err := c.Cookie("refresh_token")
+	refreshToken, err := h.RefreshCookie.Value(c)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("missing refresh token cookie: %v", err))
 	}
-	refreshToken := refreshTokenCookie.Value
 
-	newAccessToken, newRefreshToken, err := h.AuthUsecase.RefreshSessionToken(c.Request().Context(), refreshToken)
+	jkt, err := h.proofThumbprint(c)
 	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid DPoP proof: %v", err))
+	}
+
+	tokens, err := h.AuthUsecase.RefreshSessionToken(c.Request().Context(), entity.RefreshInput{
+		RefreshToken:   refreshToken,
+		UserAgent:      c.Request().UserAgent(),
+		IP:             c.RealIP(),
+		CertThumbprint: utils.RequestCertThumbprint(c.Request()),
+		DPoPThumbprint: jkt,
+		Attestation:    attestation(c),
+	})
+	if err != nil {
+		if errors.Is(err, customerrors.ErrReauthenticationRequired) || errors.Is(err, customerrors.ErrSessionBindingMismatch) {
+			return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+		}
+		if errors.Is(err, customerrors.ErrAttestationFailed) || errors.Is(err, customerrors.ErrSessionBlocked) {
+			return echo.NewHTTPError(http.StatusForbidden, err.Error())
+		}
 		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to refresh session: %v", err))
 	}
 
-	newCookie := &http.Cookie{
-		Name:     "refresh_token",
-		Value:    newRefreshToken,
-		HttpOnly: true,
-		Secure:   true,
-		Expires:  time.Now().Add(15 * 24 * time.Hour),
-		Path:     "/refresh",
-		// could add SameSite attribute if needed
-		// could add another sites for different environments (e.g., development vs production)
+	h.RefreshCookie.Set(c, tokens.RefreshToken)
+
+	return c.JSON(200, tokenResponse(tokens, false))
+}
+
+// RefreshNative refreshes the session of a native app that keeps its refresh token itself instead of in a cookie jar.
+// The refresh token is sent in the body and the rotated one returned in the body, no cookie is read or set.
+// Mobile apps add their app attestation in the X-App-Platform, X-App-Attestation and X-App-Attestation-Challenge headers.
+// Sessions started by web clients are refused, their refresh token must only travel in the HttpOnly cookie.
+func (h *AuthHandler) RefreshNative(c echo.Context) error {
+	var req NativeRefreshRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	if req.RefreshToken == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "refresh_token is required")
+	}
+	jkt, err := h.proofThumbprint(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid DPoP proof: %v", err))
 	}
-	c.SetCookie(newCookie)
 
-	return c.JSON(200, map[string]string{"access_token": newAccessToken})
+	tokens, err := h.AuthUsecase.RefreshSessionToken(c.Request().Context(), entity.RefreshInput{
+		RefreshToken:   req.RefreshToken,
+		UserAgent:      c.Request().UserAgent(),
+		IP:             c.RealIP(),
+		CertThumbprint: utils.RequestCertThumbprint(c.Request()),
+		DPoPThumbprint: jkt,
+		Native:         true,
+		Attestation:    attestation(c),
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, customerrors.ErrRefreshCookieRequired):
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		case errors.Is(err, customerrors.ErrReauthenticationRequired), errors.Is(err, pgx.ErrNoRows),
+			errors.Is(err, customerrors.ErrCertificateMismatch), errors.Is(err, customerrors.ErrProofKeyMismatch),
+			errors.Is(err, customerrors.ErrSessionBindingMismatch):
+			return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+		case errors.Is(err, customerrors.ErrAttestationFailed), errors.Is(err, customerrors.ErrSessionBlocked):
+			return echo.NewHTTPError(http.StatusForbidden, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to refresh session: %v", err))
+	}
+	return c.JSON(200, tokenResponse(tokens, true))
+}
+
+// AttestationChallenge issues a challenge for a mobile app to request its Play Integrity or App Attest token for,
+// the token and the challenge are then sent with the login or refresh. It answers 404 while app attestation is disabled.
+func (h *AuthHandler) AttestationChallenge(c echo.Context) error {
+	challenge, err := h.AuthUsecase.AttestationChallenge(c.Request().Context())
+	if err != nil {
+		if errors.Is(err, customerrors.ErrAttestationDisabled) {
+			return echo.ErrNotFound
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to issue challenge: %v", err))
+	}
+	return c.JSON(http.StatusOK, challenge)
+}
+
+// attestation returns the app attestation headers of the request.
+func attestation(c echo.Context) entity.Attestation {
+	header := c.Request().Header
+	return entity.Attestation{
+		Platform:  header.Get(attestationPlatformHeader),
+		Token:     header.Get(attestationHeader),
+		Challenge: header.Get(attestationChallengeHeader),
+	}
+}
+
+// tokenResponse is the body of login and refresh responses. The refresh token travels in a cookie,
+// native clients (see entity.ClientType.Native) get it in the body as well.
+func tokenResponse(tokens entity.IssuedTokens, native bool) map[string]string {
+	body := map[string]string{"access_token": tokens.AccessToken, "token_type": tokenType(tokens.DPoPBound)}
+	if native {
+		body["refresh_token"] = tokens.RefreshToken
+	}
+	if tokens.Receipt != "" {
+		body["receipt"] = tokens.Receipt
+	}
+	return body
+}
+
+// dryRunParam parses the optional dry_run query parameter of destructive operations.
+func dryRunParam(c echo.Context) (bool, error) {
+	value := c.QueryParam("dry_run")
+	if value == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+// proofThumbprint verifies the DPoP proof of a token request, if the client sent one,
+// and returns the thumbprint of its key. Without a proof the issued tokens are plain bearer tokens.
+func (h *AuthHandler) proofThumbprint(c echo.Context) (string, error) {
+	req := c.Request()
+	proof := req.Header.Get(dpop.HeaderName)
+	if proof == "" {
+		return "", nil
+	}
+	return h.AuthUsecase.VerifyProof(req.Context(), proof, req.Method, dpop.RequestURL(req), "")
+}
+
+// tokenType is the token_type of the issued access token (RFC 9449 section 5). A login with a DPoP proof gets
+// bearer tokens while DPoP is not rolled out to the user.
+func tokenType(dpopBound bool) string {
+	if dpopBound {
+		return "DPoP"
+	}
+	return "Bearer"
+}
+
+// Availability tells a registration form whether the username and email are free.
+// A single flag is returned for both, so the endpoint does not reveal which one is taken.
+func (h *AuthHandler) Availability(c echo.Context) error {
+	var req AvailabilityRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	if req.Username == "" && req.Email == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "username or email is required")
+	}
+	available, err := h.AuthUsecase.CheckAvailability(c.Request().Context(), req.Username, req.Email)
+	if err != nil {
+		if errors.Is(err, customerrors.ErrInvalidUsername) || errors.Is(err, customerrors.ErrInvalidEmail) {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to check availability: %v", err))
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(200, map[string]bool{"available": available})
+}
+
+// Me returns the profile of the authenticated user.
+func (h *AuthHandler) Me(c echo.Context) error {
+	userID, _ := c.Get("userID").(uuid.UUID)
+
+	user, err := h.AuthUsecase.GetProfile(c.Request().Context(), userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "user not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to get profile: %v", err))
+	}
+	return c.JSON(200, ProfileResponse{
+		ID:            user.ID.String(),
+		Username:      user.Username,
+		Email:         user.Email,
+		CreatedAt:     user.CreatedAt,
+		EmailVerified: user.EmailVerified,
+	})
+}
+
+// LoginHistory returns the login attempts on the account of the authenticated user, newest first, so the user
+// can spot access they do not recognise. Pages are selected with limit (default 20, at most 100) and offset.
+func (h *AuthHandler) LoginHistory(c echo.Context) error {
+	userID, _ := c.Get("userID").(uuid.UUID)
+
+	var req LoginHistoryRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	page, err := h.AuthUsecase.LoginHistory(c.Request().Context(), userID, req.Limit, req.Offset)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to get login history: %v", err))
+	}
+	return c.JSON(http.StatusOK, page)
 }
 
 // Silly example of how to use the metrics in handler
@@ -180,7 +544,7 @@ func (h *AuthHandler) GetTokenForAdmin(c echo.Context) error {
 		Name:     "admin_token",
 		Value:    refreshToken,
 		HttpOnly: true,
-		Secure:   true,
+		Secure:   ctxUtil.CookieSecure(c.Request().Context()),
 		Expires:  time.Now().Add(15 * 24 * time.Hour),
 		Path:     "/admin",
 	})
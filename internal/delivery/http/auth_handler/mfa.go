@@ -0,0 +1,275 @@
+package authHandler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"main/domain/entity"
+	"main/pkg/customerrors"
+	"main/pkg/locale"
+	"main/pkg/utils"
+	ctxUtil "main/pkg/utils/context"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+)
+
+type MFAUsecase interface {
+
+	//Method returns the default second factor of the user, empty when two-factor authentication is off.
+	Method(ctx context.Context, userID uuid.UUID) (entity.MFAMethod, error)
+
+	//SetMethod changes the default second factor after checking the password, an empty method turns it off.
+	SetMethod(ctx context.Context, userID uuid.UUID, method entity.MFAMethod, password string) error
+
+	//Resend sends a new code for the challenge of a login.
+	Resend(ctx context.Context, challengeID uuid.UUID) (entity.MFAChallenge, error)
+
+	//TrustedDevices returns the devices on which the user skips the second factor.
+	TrustedDevices(ctx context.Context, userID uuid.UUID) ([]entity.TrustedDevice, error)
+
+	//RevokeTrustedDevice makes the device ask for the second factor again.
+	RevokeTrustedDevice(ctx context.Context, userID, deviceID uuid.UUID) error
+
+	//RevokeTrustedDevices revokes every trusted device of the user.
+	RevokeTrustedDevices(ctx context.Context, userID uuid.UUID) (int64, error)
+}
+
+// trustedDeviceCookie holds the signed cookie of a trusted device, sent with password logins.
+const trustedDeviceCookie = "trusted_device"
+
+// DTOs
+
+// MFARequiredResponse answers password logins of users with two-factor authentication, Code is always
+// mfa_required. The client asks for the code sent to the destination and posts it to /login/mfa/verify.
+type MFARequiredResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+	entity.MFAChallenge
+}
+
+type MFAVerifyRequest struct {
+	ChallengeID uuid.UUID `json:"challenge_id"`
+	Code        string    `json:"code"`
+	// ClientType is one of web, mobile, cli, service. Defaults to web.
+	ClientType string `json:"client_type"`
+	// AcceptTerms are the IDs of the terms documents the user accepted
+	AcceptTerms []uuid.UUID `json:"accept_terms"`
+	// TrustDevice skips the second factor on this device for the next logins, see the trusted_device cookie
+	TrustDevice bool `json:"trust_device"`
+	// DeviceName names the device of the new session in the sessions list
+	DeviceName string `json:"device_name"`
+}
+
+type MFAResendRequest struct {
+	ChallengeID uuid.UUID `json:"challenge_id"`
+}
+
+type MFASettingsRequest struct {
+	// Method is the default second factor, empty turns two-factor authentication off
+	Method   entity.MFAMethod `json:"method"`
+	Password string           `json:"password"`
+}
+
+type MFASettingsResponse struct {
+	Method    entity.MFAMethod   `json:"method"`
+	Available []entity.MFAMethod `json:"available"`
+}
+
+type RevokeTrustedDevicesResponse struct {
+	Revoked int64 `json:"revoked"`
+}
+
+// VerifyMFA completes a password login with the code of its second factor, the response matches the one of Login.
+// The MFA endpoints answer 404 while two-factor authentication is disabled.
+func (h *AuthHandler) VerifyMFA(c echo.Context) error {
+	if h.MFAUsecase == nil {
+		return echo.ErrNotFound
+	}
+	var req MFAVerifyRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	jkt, err := h.proofThumbprint(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid DPoP proof: %v", err))
+	}
+	tokens, err := h.AuthUsecase.CompleteMFALogin(c.Request().Context(), entity.MFALoginInput{
+		ChallengeID: req.ChallengeID,
+		Code:        req.Code,
+		Client: entity.LoginInput{
+			UserAgent:      c.Request().UserAgent(),
+			AcceptLanguage: c.Request().Header.Get("Accept-Language"),
+			Timezone:       c.Request().Header.Get(locale.TimezoneHeader),
+			IP:             c.RealIP(),
+			ClientType:     req.ClientType,
+			CertThumbprint: utils.RequestCertThumbprint(c.Request()),
+			DPoPThumbprint: jkt,
+			AcceptedTerms:  req.AcceptTerms,
+			Attestation:    attestation(c),
+			DeviceName:     req.DeviceName,
+		},
+		TrustDevice: req.TrustDevice,
+	})
+	if err != nil {
+		if errors.Is(err, customerrors.ErrUserBlocked) || errors.Is(err, customerrors.ErrAttestationFailed) ||
+			errors.Is(err, customerrors.ErrLoginLocationBlocked) {
+			return echo.NewHTTPError(http.StatusForbidden, err.Error())
+		}
+		if errors.Is(err, customerrors.ErrTermsNotAccepted) {
+			return termsNotAccepted(c, err)
+		}
+		if errors.Is(err, customerrors.ErrInvalidTermsDocument) || errors.Is(err, customerrors.ErrInvalidDeviceName) {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		if errors.Is(err, customerrors.ErrTooManyAttempts) {
+			return echo.NewHTTPError(http.StatusTooManyRequests, "too many attempts, log in again")
+		}
+		if errors.Is(err, customerrors.ErrInvalidOTP) || errors.Is(err, pgx.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusUnauthorized, customerrors.ErrInvalidOTP.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to login: %v", err))
+	}
+
+	h.RefreshCookie.Set(c, tokens.RefreshToken)
+	if tokens.DeviceTrust != nil {
+		c.SetCookie(&http.Cookie{
+			Name:     trustedDeviceCookie,
+			Value:    tokens.DeviceTrust.Cookie,
+			HttpOnly: true,
+			Secure:   ctxUtil.CookieSecure(c.Request().Context()),
+			Expires:  tokens.DeviceTrust.ExpiresAt,
+			Path:     "/",
+			Domain:   ctxUtil.CookieDomain(c.Request().Context()),
+		})
+	}
+	c.Set("user_id", tokens.UserID)
+
+	return c.JSON(200, tokenResponse(tokens, tokens.ClientType.Native()))
+}
+
+// ResendMFA sends a new code for the challenge of a login, at most every resend cooldown.
+func (h *AuthHandler) ResendMFA(c echo.Context) error {
+	if h.MFAUsecase == nil {
+		return echo.ErrNotFound
+	}
+	var req MFAResendRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	challenge, err := h.MFAUsecase.Resend(c.Request().Context(), req.ChallengeID)
+	if err != nil {
+		return mfaError(err, "failed to resend code")
+	}
+	return c.JSON(http.StatusAccepted, challenge)
+}
+
+// GetMFA returns the two-factor settings of the authenticated user.
+func (h *AuthHandler) GetMFA(c echo.Context) error {
+	if h.MFAUsecase == nil {
+		return echo.ErrNotFound
+	}
+	userID, _ := c.Get("userID").(uuid.UUID)
+
+	method, err := h.MFAUsecase.Method(c.Request().Context(), userID)
+	if err != nil {
+		return mfaError(err, "failed to get two-factor settings")
+	}
+	return c.JSON(http.StatusOK, MFASettingsResponse{Method: method, Available: entity.MFAMethods})
+}
+
+// SetMFA changes the default second factor of the authenticated user, the password is required.
+func (h *AuthHandler) SetMFA(c echo.Context) error {
+	if h.MFAUsecase == nil {
+		return echo.ErrNotFound
+	}
+	userID, _ := c.Get("userID").(uuid.UUID)
+
+	var req MFASettingsRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	if err := h.MFAUsecase.SetMethod(c.Request().Context(), userID, req.Method, req.Password); err != nil {
+		return mfaError(err, "failed to change two-factor settings")
+	}
+	return c.JSON(http.StatusOK, MFASettingsResponse{Method: req.Method, Available: entity.MFAMethods})
+}
+
+// ListTrustedDevices returns the devices on which the authenticated user skips the second factor.
+func (h *AuthHandler) ListTrustedDevices(c echo.Context) error {
+	if h.MFAUsecase == nil {
+		return echo.ErrNotFound
+	}
+	userID, _ := c.Get("userID").(uuid.UUID)
+
+	devices, err := h.MFAUsecase.TrustedDevices(c.Request().Context(), userID)
+	if err != nil {
+		return mfaError(err, "failed to list trusted devices")
+	}
+	if devices == nil {
+		devices = []entity.TrustedDevice{}
+	}
+	return c.JSON(http.StatusOK, devices)
+}
+
+// RevokeTrustedDevice makes a trusted device of the authenticated user ask for the second factor again.
+func (h *AuthHandler) RevokeTrustedDevice(c echo.Context) error {
+	if h.MFAUsecase == nil {
+		return echo.ErrNotFound
+	}
+	userID, _ := c.Get("userID").(uuid.UUID)
+
+	deviceID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid device ID")
+	}
+	if err := h.MFAUsecase.RevokeTrustedDevice(c.Request().Context(), userID, deviceID); err != nil {
+		return mfaError(err, "failed to revoke trusted device")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// RevokeTrustedDevices revokes every trusted device of the authenticated user.
+func (h *AuthHandler) RevokeTrustedDevices(c echo.Context) error {
+	if h.MFAUsecase == nil {
+		return echo.ErrNotFound
+	}
+	userID, _ := c.Get("userID").(uuid.UUID)
+
+	n, err := h.MFAUsecase.RevokeTrustedDevices(c.Request().Context(), userID)
+	if err != nil {
+		return mfaError(err, "failed to revoke trusted devices")
+	}
+	return c.JSON(http.StatusOK, RevokeTrustedDevicesResponse{Revoked: n})
+}
+
+// trustedDevice returns the trusted device cookie of the request, empty without one.
+func trustedDevice(c echo.Context) string {
+	cookie, err := c.Cookie(trustedDeviceCookie)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+func mfaError(err error, msg string) error {
+	switch {
+	case errors.Is(err, customerrors.ErrInvalidMFAMethod):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, customerrors.ErrInvalidCredentials):
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	case errors.Is(err, customerrors.ErrEmailNotVerified):
+		return echo.NewHTTPError(http.StatusForbidden, "verify your email address first")
+	case errors.Is(err, customerrors.ErrResendThrottled):
+		return echo.NewHTTPError(http.StatusTooManyRequests, err.Error())
+	case errors.Is(err, customerrors.ErrInvalidOTP):
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid or expired challenge, log in again")
+	case errors.Is(err, customerrors.ErrNoTagsAffected):
+		return echo.NewHTTPError(http.StatusNotFound, "trusted device not found")
+	case errors.Is(err, pgx.ErrNoRows):
+		return echo.NewHTTPError(http.StatusNotFound, "user not found")
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("%s: %v", msg, err))
+}
@@ -0,0 +1,239 @@
+package authHandler
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"main/domain/entity"
+	"main/pkg/customerrors"
+	"main/pkg/locale"
+	"main/pkg/utils"
+	"main/pkg/webauthn"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+)
+
+type PasskeyUsecase interface {
+
+	//BeginRegistration starts adding a passkey and returns the ceremony ID with the credential creation options.
+	BeginRegistration(ctx context.Context, userID uuid.UUID) (uuid.UUID, webauthn.CreationOptions, error)
+
+	//FinishRegistration verifies the new credential and stores it as a passkey of the user.
+	FinishRegistration(ctx context.Context, userID, ceremonyID uuid.UUID, name string, resp webauthn.AttestationResponse) (entity.Passkey, error)
+
+	//BeginLogin starts a passkey sign-in and returns the ceremony ID with the credential request options.
+	BeginLogin(ctx context.Context) (uuid.UUID, webauthn.RequestOptions, error)
+
+	//FinishLogin verifies the assertion of the passkey and starts a session.
+	FinishLogin(ctx context.Context, ceremonyID uuid.UUID, credentialID []byte, resp webauthn.AssertionResponse, in entity.LoginInput) (entity.IssuedTokens, error)
+
+	//Passkeys returns the passkeys of the user.
+	Passkeys(ctx context.Context, userID uuid.UUID) ([]entity.Passkey, error)
+
+	//DeletePasskey removes a passkey of the user.
+	DeletePasskey(ctx context.Context, userID uuid.UUID, credentialID []byte) error
+}
+
+// DTOs
+
+// PasskeyOptionsResponse is returned by both option endpoints, PublicKey is passed as is to
+// navigator.credentials.create() or navigator.credentials.get() and CeremonyID sent back with the result.
+type PasskeyOptionsResponse struct {
+	CeremonyID uuid.UUID `json:"ceremony_id"`
+	PublicKey  any       `json:"public_key"`
+}
+
+// PublicKeyCredential is the JSON form of the credential returned by the browser (PublicKeyCredential.toJSON()).
+type PublicKeyCredential[R any] struct {
+	RawID    webauthn.Base64URL `json:"rawId"`
+	Type     string             `json:"type"`
+	Response R                  `json:"response"`
+}
+
+type PasskeyRegisterRequest struct {
+	CeremonyID uuid.UUID                                         `json:"ceremony_id"`
+	Name       string                                            `json:"name"`
+	Credential PublicKeyCredential[webauthn.AttestationResponse] `json:"credential"`
+}
+
+type PasskeyLoginRequest struct {
+	CeremonyID uuid.UUID                                       `json:"ceremony_id"`
+	Credential PublicKeyCredential[webauthn.AssertionResponse] `json:"credential"`
+	// ClientType is one of web, mobile, cli, service. Defaults to web.
+	ClientType string `json:"client_type"`
+	// AcceptTerms are the IDs of the terms documents the user accepted
+	AcceptTerms []uuid.UUID `json:"accept_terms"`
+}
+
+type PasskeyResponse struct {
+	ID             webauthn.Base64URL `json:"id"`
+	Name           string             `json:"name"`
+	AAGUID         uuid.UUID          `json:"aaguid"`
+	Transports     []string           `json:"transports"`
+	BackupEligible bool               `json:"backup_eligible"`
+	BackupState    bool               `json:"backup_state"`
+	CreatedAt      time.Time          `json:"created_at"`
+	LastUsedAt     *time.Time         `json:"last_used_at"`
+}
+
+// PasskeyRegistrationOptions starts adding a passkey to the account of the authenticated user.
+// All passkey endpoints answer 404 while passkeys are disabled.
+func (h *AuthHandler) PasskeyRegistrationOptions(c echo.Context) error {
+	if h.PasskeyUsecase == nil {
+		return echo.ErrNotFound
+	}
+	userID, _ := c.Get("userID").(uuid.UUID)
+
+	ceremonyID, options, err := h.PasskeyUsecase.BeginRegistration(c.Request().Context(), userID)
+	if err != nil {
+		return passkeyError(err, "failed to start passkey registration")
+	}
+	return c.JSON(http.StatusOK, PasskeyOptionsResponse{CeremonyID: ceremonyID, PublicKey: options})
+}
+
+// RegisterPasskey stores the credential created with the registration options.
+func (h *AuthHandler) RegisterPasskey(c echo.Context) error {
+	if h.PasskeyUsecase == nil {
+		return echo.ErrNotFound
+	}
+	userID, _ := c.Get("userID").(uuid.UUID)
+
+	var req PasskeyRegisterRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	passkey, err := h.PasskeyUsecase.FinishRegistration(c.Request().Context(), userID, req.CeremonyID, req.Name, req.Credential.Response)
+	if err != nil {
+		return passkeyError(err, "failed to register passkey")
+	}
+	return c.JSON(http.StatusCreated, passkeyResponse(passkey))
+}
+
+// ListPasskeys returns the passkeys of the authenticated user.
+func (h *AuthHandler) ListPasskeys(c echo.Context) error {
+	if h.PasskeyUsecase == nil {
+		return echo.ErrNotFound
+	}
+	userID, _ := c.Get("userID").(uuid.UUID)
+
+	passkeys, err := h.PasskeyUsecase.Passkeys(c.Request().Context(), userID)
+	if err != nil {
+		return passkeyError(err, "failed to list passkeys")
+	}
+	body := make([]PasskeyResponse, 0, len(passkeys))
+	for _, p := range passkeys {
+		body = append(body, passkeyResponse(p))
+	}
+	return c.JSON(http.StatusOK, body)
+}
+
+// DeletePasskey removes a passkey of the authenticated user, the ID is the base64url credential ID.
+func (h *AuthHandler) DeletePasskey(c echo.Context) error {
+	if h.PasskeyUsecase == nil {
+		return echo.ErrNotFound
+	}
+	userID, _ := c.Get("userID").(uuid.UUID)
+
+	credentialID, err := base64.RawURLEncoding.DecodeString(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid passkey ID")
+	}
+	if err := h.PasskeyUsecase.DeletePasskey(c.Request().Context(), userID, credentialID); err != nil {
+		return passkeyError(err, "failed to delete passkey")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// PasskeyLoginOptions starts a passkey sign-in, no username is needed.
+func (h *AuthHandler) PasskeyLoginOptions(c echo.Context) error {
+	if h.PasskeyUsecase == nil {
+		return echo.ErrNotFound
+	}
+	ceremonyID, options, err := h.PasskeyUsecase.BeginLogin(c.Request().Context())
+	if err != nil {
+		return passkeyError(err, "failed to start passkey login")
+	}
+	return c.JSON(http.StatusOK, PasskeyOptionsResponse{CeremonyID: ceremonyID, PublicKey: options})
+}
+
+// PasskeyLogin exchanges the assertion of a passkey for tokens, the response matches the one of Login.
+func (h *AuthHandler) PasskeyLogin(c echo.Context) error {
+	if h.PasskeyUsecase == nil {
+		return echo.ErrNotFound
+	}
+	var req PasskeyLoginRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	jkt, err := h.proofThumbprint(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid DPoP proof: %v", err))
+	}
+	tokens, err := h.PasskeyUsecase.FinishLogin(c.Request().Context(), req.CeremonyID, req.Credential.RawID, req.Credential.Response,
+		entity.LoginInput{
+			UserAgent:      c.Request().UserAgent(),
+			AcceptLanguage: c.Request().Header.Get("Accept-Language"),
+			Timezone:       c.Request().Header.Get(locale.TimezoneHeader),
+			IP:             c.RealIP(),
+			ClientType:     req.ClientType,
+			CertThumbprint: utils.RequestCertThumbprint(c.Request()),
+			DPoPThumbprint: jkt,
+			AcceptedTerms:  req.AcceptTerms,
+			Attestation:    attestation(c),
+		})
+	if err != nil {
+		if errors.Is(err, customerrors.ErrUserBlocked) || errors.Is(err, customerrors.ErrAttestationFailed) ||
+			errors.Is(err, customerrors.ErrLoginLocationBlocked) {
+			return echo.NewHTTPError(http.StatusForbidden, err.Error())
+		}
+		if errors.Is(err, customerrors.ErrTermsNotAccepted) {
+			return termsNotAccepted(c, err)
+		}
+		if errors.Is(err, customerrors.ErrInvalidTermsDocument) {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		if errors.Is(err, customerrors.ErrInvalidPasskey) {
+			return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to login: %v", err))
+	}
+
+	h.RefreshCookie.Set(c, tokens.RefreshToken)
+	c.Set("user_id", tokens.UserID)
+
+	return c.JSON(200, tokenResponse(tokens, tokens.ClientType.Native()))
+}
+
+func passkeyResponse(p entity.Passkey) PasskeyResponse {
+	return PasskeyResponse{
+		ID:             p.CredentialID,
+		Name:           p.Name,
+		AAGUID:         p.AAGUID,
+		Transports:     p.Transports,
+		BackupEligible: p.BackupEligible,
+		BackupState:    p.BackupState,
+		CreatedAt:      p.CreatedAt,
+		LastUsedAt:     p.LastUsedAt,
+	}
+}
+
+func passkeyError(err error, msg string) error {
+	switch {
+	case errors.Is(err, customerrors.ErrInvalidPasskey), errors.Is(err, customerrors.ErrInvalidPasskeyName):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, customerrors.ErrPasskeyExists), errors.Is(err, customerrors.ErrTooManyPasskeys):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	case errors.Is(err, customerrors.ErrFeatureNotEnabled):
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	case errors.Is(err, customerrors.ErrNoTagsAffected):
+		return echo.NewHTTPError(http.StatusNotFound, "passkey not found")
+	case errors.Is(err, pgx.ErrNoRows):
+		return echo.NewHTTPError(http.StatusNotFound, "user not found")
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("%s: %v", msg, err))
+}
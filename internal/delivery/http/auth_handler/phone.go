@@ -0,0 +1,112 @@
+package authHandler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"main/domain/entity"
+	"main/pkg/customerrors"
+	"main/pkg/locale"
+	"main/pkg/utils"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+type PhoneUsecase interface {
+
+	//RequestLoginCode sends a login code by SMS, it does not reveal whether the phone belongs to an account.
+	RequestLoginCode(ctx context.Context, phone string) error
+
+	//LoginWithCode authenticates the owner of the phone with the code and starts a session.
+	LoginWithCode(ctx context.Context, in entity.PhoneLoginInput) (entity.IssuedTokens, error)
+}
+
+// DTOs
+type PhoneCodeRequest struct {
+	Phone string `json:"phone"`
+}
+
+type PhoneLoginRequest struct {
+	Phone string `json:"phone"`
+	Code  string `json:"code"`
+	// ClientType is one of web, mobile, cli, service. Defaults to web.
+	ClientType string `json:"client_type"`
+	// AcceptTerms are the IDs of the terms documents the user accepted
+	AcceptTerms []uuid.UUID `json:"accept_terms"`
+}
+
+// RequestPhoneCode sends a one-time login code to the phone. It answers 202 whether or not
+// an account uses the number, only malformed numbers are rejected.
+func (h *AuthHandler) RequestPhoneCode(c echo.Context) error {
+	if h.PhoneUsecase == nil {
+		return echo.ErrNotFound
+	}
+	var req PhoneCodeRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	if err := h.PhoneUsecase.RequestLoginCode(c.Request().Context(), req.Phone); err != nil {
+		if errors.Is(err, customerrors.ErrInvalidPhone) {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to send login code: %v", err))
+	}
+	return c.NoContent(http.StatusAccepted)
+}
+
+// PhoneLogin exchanges a login code sent by SMS for tokens, the response matches the one of Login.
+// Both phone endpoints answer 404 while phone login is disabled.
+func (h *AuthHandler) PhoneLogin(c echo.Context) error {
+	if h.PhoneUsecase == nil {
+		return echo.ErrNotFound
+	}
+	var req PhoneLoginRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	jkt, err := h.proofThumbprint(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid DPoP proof: %v", err))
+	}
+	tokens, err := h.PhoneUsecase.LoginWithCode(c.Request().Context(), entity.PhoneLoginInput{
+		Phone: req.Phone,
+		Code:  req.Code,
+		Client: entity.LoginInput{
+			UserAgent:      c.Request().UserAgent(),
+			AcceptLanguage: c.Request().Header.Get("Accept-Language"),
+			Timezone:       c.Request().Header.Get(locale.TimezoneHeader),
+			IP:             c.RealIP(),
+			ClientType:     req.ClientType,
+			CertThumbprint: utils.RequestCertThumbprint(c.Request()),
+			DPoPThumbprint: jkt,
+			AcceptedTerms:  req.AcceptTerms,
+			Attestation:    attestation(c),
+		},
+	})
+	if err != nil {
+		if errors.Is(err, customerrors.ErrUserBlocked) || errors.Is(err, customerrors.ErrAttestationFailed) ||
+			errors.Is(err, customerrors.ErrLoginLocationBlocked) {
+			return echo.NewHTTPError(http.StatusForbidden, err.Error())
+		}
+		if errors.Is(err, customerrors.ErrTermsNotAccepted) {
+			return termsNotAccepted(c, err)
+		}
+		if errors.Is(err, customerrors.ErrInvalidTermsDocument) {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		if errors.Is(err, customerrors.ErrTooManyAttempts) {
+			return echo.NewHTTPError(http.StatusTooManyRequests, err.Error())
+		}
+		if errors.Is(err, customerrors.ErrInvalidOTP) {
+			return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to login: %v", err))
+	}
+
+	h.RefreshCookie.Set(c, tokens.RefreshToken)
+	c.Set("user_id", tokens.UserID)
+
+	return c.JSON(200, tokenResponse(tokens, tokens.ClientType.Native()))
+}
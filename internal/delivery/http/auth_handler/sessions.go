@@ -0,0 +1,80 @@
+package authHandler
+
+import (
+	"errors"
+	"fmt"
+	"main/pkg/customerrors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// DTOs
+
+type RenameSessionRequest struct {
+	// DeviceName is the new name of the device of the session, empty to remove it
+	DeviceName string `json:"device_name"`
+}
+
+// ListSessions returns the active sessions of the authenticated user, the last used first, with the device,
+// IP address and location of each. The session of the access token is marked as current.
+func (h *AuthHandler) ListSessions(c echo.Context) error {
+	userID, _ := c.Get("userID").(uuid.UUID)
+	sessionID, _ := c.Get("sessionID").(uuid.UUID)
+
+	sessions, err := h.AuthUsecase.ListSessions(c.Request().Context(), userID, sessionID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to list sessions: %v", err))
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusOK, sessions)
+}
+
+// RenameSession names the device of the session in the path ("Dan's iPhone"), one of the authenticated user.
+func (h *AuthHandler) RenameSession(c echo.Context) error {
+	userID, _ := c.Get("userID").(uuid.UUID)
+
+	sessionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid session ID")
+	}
+	var req RenameSessionRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	err = h.AuthUsecase.RenameSession(c.Request().Context(), userID, sessionID, req.DeviceName)
+	switch {
+	case errors.Is(err, customerrors.ErrSessionNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, customerrors.ErrInvalidDeviceName):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case err != nil:
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to rename session: %v", err))
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// RevokeSession ends the session in the path, one of the authenticated user. Revoking the current session
+// also clears the refresh token cookie, like a logout.
+func (h *AuthHandler) RevokeSession(c echo.Context) error {
+	userID, _ := c.Get("userID").(uuid.UUID)
+	currentID, _ := c.Get("sessionID").(uuid.UUID)
+
+	sessionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid session ID")
+	}
+	err = h.AuthUsecase.RevokeSession(c.Request().Context(), userID, sessionID)
+	if errors.Is(err, customerrors.ErrSessionNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to revoke session: %v", err))
+	}
+
+	if sessionID == currentID {
+		h.RefreshCookie.Clear(c)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
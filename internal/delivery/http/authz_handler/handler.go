@@ -0,0 +1,175 @@
+package authzHandler
+
+import (
+	"context"
+	"crypto/subtle"
+	"main/domain/entity"
+	"main/pkg/dpop"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// Identity headers returned to the gateway, which copies them onto the upstream request.
+// Scopes, roles and audiences are space-separated.
+const (
+	HeaderUserID   = "X-User-ID"
+	HeaderClientID = "X-Client-ID"
+	HeaderScopes   = "X-Scopes"
+	HeaderRoles    = "X-Roles"
+	HeaderAudience = "X-Audience"
+)
+
+type AuthzHandler struct {
+	AuthUsecase  AuthUsecase
+	OAuthUsecase OAuthUsecase
+	RBACUsecase  RBACUsecase
+	// cacheMaxAge caps the max-age of allow decisions
+	cacheMaxAge time.Duration
+}
+
+type AuthUsecase interface {
+	//VerifyAccessClaims verifies the user access token and returns its claims.
+	VerifyAccessClaims(token string) (entity.AccessTokenClaims, error)
+
+	//VerifyProof verifies a DPoP proof and returns the thumbprint of its key.
+	VerifyProof(ctx context.Context, proof, method, uri, accessToken string) (jkt string, err error)
+
+	//TokenExpiry returns the expiration time of a valid access token.
+	TokenExpiry(token string) (time.Time, error)
+
+	//TokenAudience returns the audience of a valid access or service token.
+	TokenAudience(token string) ([]string, error)
+}
+
+type OAuthUsecase interface {
+	//VerifyClient verifies the machine token and returns the client ID and its scopes.
+	VerifyClient(token string) (clientID string, scopes []string, err error)
+}
+
+type RBACUsecase interface {
+	//UserRoles returns the names of the roles granted to the user.
+	UserRoles(ctx context.Context, userID uuid.UUID) ([]string, error)
+}
+
+func NewAuthzHandler(authUsecase AuthUsecase, oauthUsecase OAuthUsecase, rbacUsecase RBACUsecase, cacheMaxAge time.Duration) *AuthzHandler {
+	return &AuthzHandler{
+		AuthUsecase:  authUsecase,
+		OAuthUsecase: oauthUsecase,
+		RBACUsecase:  rbacUsecase,
+		cacheMaxAge:  cacheMaxAge,
+	}
+}
+
+// Authz is a forward-auth endpoint compatible with nginx auth_request and Traefik ForwardAuth.
+// It answers 200 with identity headers for a valid user or service token and 401 otherwise. The identity
+// carries the audience of the token and the scopes of service tokens or the roles of users, so that gateways
+// and the SDK middleware (pkg/authsdk) can apply the authorization policy of the route.
+// The response body is always empty on success, gateways only look at the status and headers.
+// DPoP-bound tokens are checked against the proof of the original request, which the gateway
+// describes with X-Forwarded-Method/Proto/Host/Uri (Traefik) or X-Original-Method/URL (nginx).
+func (h *AuthzHandler) Authz(c echo.Context) error {
+	token, isDPoP, ok := dpop.ParseAuthorization(c.Request().Header.Get("authorization"))
+	if !ok {
+		return unauthorized(c)
+	}
+
+	if claims, err := h.AuthUsecase.VerifyAccessClaims(token); err == nil && claims.UserID != uuid.Nil {
+		// the gateway cannot show us the client certificate, certificate-bound tokens are never allowed here
+		if claims.CertThumbprint != "" || isDPoP != (claims.DPoPThumbprint != "") {
+			return unauthorized(c)
+		}
+		if isDPoP {
+			method, uri := originalRequest(c.Request())
+			jkt, err := h.AuthUsecase.VerifyProof(c.Request().Context(), c.Request().Header.Get(dpop.HeaderName), method, uri, token)
+			if err != nil || subtle.ConstantTimeCompare([]byte(jkt), []byte(claims.DPoPThumbprint)) != 1 {
+				return unauthorized(c)
+			}
+		}
+		roles, err := h.RBACUsecase.UserRoles(c.Request().Context(), claims.UserID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to read roles")
+		}
+		c.Response().Header().Set(HeaderUserID, claims.UserID.String())
+		c.Response().Header().Set(HeaderScopes, "")
+		c.Response().Header().Set(HeaderRoles, strings.Join(roles, " "))
+		h.setAudience(c, token)
+		if isDPoP {
+			// every request carries a new single-use proof, the decision cannot be reused
+			c.Response().Header().Set("Cache-Control", "no-store")
+		} else {
+			h.setCacheHeaders(c, token)
+		}
+		return c.NoContent(http.StatusOK)
+	}
+	if isDPoP {
+		return unauthorized(c)
+	}
+
+	clientID, scopes, err := h.OAuthUsecase.VerifyClient(token)
+	if err != nil {
+		return unauthorized(c)
+	}
+	c.Response().Header().Set(HeaderClientID, clientID)
+	c.Response().Header().Set(HeaderScopes, strings.Join(scopes, " "))
+	c.Response().Header().Set(HeaderRoles, "")
+	h.setAudience(c, token)
+	h.setCacheHeaders(c, token)
+	return c.NoContent(http.StatusOK)
+}
+
+// setAudience sets the audience header of a verified token.
+func (h *AuthzHandler) setAudience(c echo.Context, token string) {
+	audience, _ := h.AuthUsecase.TokenAudience(token)
+	c.Response().Header().Set(HeaderAudience, strings.Join(audience, " "))
+}
+
+// setCacheHeaders lets gateways cache an allow decision until the token expires, but never longer
+// than the configured cap, since revocation (blocking, logout) is only observed on the next check.
+// Requests carry an Authorization header, so s-maxage is required for shared caches to store the response.
+func (h *AuthzHandler) setCacheHeaders(c echo.Context, token string) {
+	header := c.Response().Header()
+	header.Set("Vary", "Authorization")
+
+	expiresAt, err := h.AuthUsecase.TokenExpiry(token)
+	if err != nil {
+		header.Set("Cache-Control", "no-store")
+		return
+	}
+	maxAge := min(time.Until(expiresAt), h.cacheMaxAge)
+	if maxAge < time.Second {
+		header.Set("Cache-Control", "no-store")
+		return
+	}
+	seconds := strconv.Itoa(int(maxAge.Seconds()))
+	header.Set("Cache-Control", "max-age="+seconds+", s-maxage="+seconds)
+}
+
+// originalRequest returns the method and URL of the request the gateway is asking about.
+func originalRequest(r *http.Request) (method, uri string) {
+	method = r.Header.Get("X-Forwarded-Method")
+	if method == "" {
+		method = r.Header.Get("X-Original-Method")
+	}
+	if original := r.Header.Get("X-Original-URL"); original != "" {
+		return method, original
+	}
+	forwarded := *r
+	forwarded.URL = &url.URL{Path: r.Header.Get("X-Forwarded-Uri")}
+	if u, err := url.ParseRequestURI(r.Header.Get("X-Forwarded-Uri")); err == nil {
+		forwarded.URL = u
+	}
+	return method, dpop.RequestURL(&forwarded)
+}
+
+// unauthorized denies the request. Denials are never cached, so a freshly issued token works immediately.
+func unauthorized(c echo.Context) error {
+	c.Response().Header().Set("Cache-Control", "no-store")
+	c.Response().Header().Set("WWW-Authenticate", `Bearer, DPoP algs="ES256 RS256 EdDSA"`)
+	return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+}
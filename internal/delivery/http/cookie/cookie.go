@@ -0,0 +1,104 @@
+// Package cookie sets, reads and clears the refresh token cookie with the same attributes in every handler, so
+// the cookie set at login is the one sent to /refresh and the one removed at logout.
+package cookie
+
+import (
+	"fmt"
+	ctxUtil "main/pkg/utils/context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// lifetime is the expiry of the refresh token cookie, the session itself may end earlier.
+const lifetime = 15 * 24 * time.Hour
+
+// Options are the attributes of the refresh token cookie.
+type Options struct {
+	Name string
+	// Domain is used unless the tenant of the request has its own cookie domain, empty for host-only cookies
+	Domain string
+	Path   string
+	// SameSite is lax, strict or none, which needs Secure. Empty leaves it to the browser default
+	SameSite string
+	// Secure is dropped for the requests of local development unless SameSite is none, which browsers reject
+	// without it (they accept Secure cookies from http://localhost)
+	Secure bool
+}
+
+func parseSameSite(value string) (http.SameSite, error) {
+	switch strings.ToLower(value) {
+	case "":
+		return http.SameSiteDefaultMode, nil
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	default:
+		return 0, fmt.Errorf("unknown same_site %q, expected lax, strict or none", value)
+	}
+}
+
+// RefreshToken is the refresh token cookie of the browser clients.
+type RefreshToken struct {
+	opts     Options
+	sameSite http.SameSite
+}
+
+func NewRefreshToken(opts Options) (*RefreshToken, error) {
+	sameSite, err := parseSameSite(opts.SameSite)
+	if err != nil {
+		return nil, err
+	}
+	if sameSite == http.SameSiteNoneMode && !opts.Secure {
+		return nil, fmt.Errorf("same_site none requires secure")
+	}
+	if opts.Name == "" {
+		opts.Name = "refresh_token"
+	}
+	if opts.Path == "" {
+		opts.Path = "/"
+	}
+	return &RefreshToken{opts: opts, sameSite: sameSite}, nil
+}
+
+// Set stores the refresh token in the cookie.
+func (r *RefreshToken) Set(c echo.Context, token string) {
+	c.SetCookie(r.cookie(c, token, time.Now().Add(lifetime)))
+}
+
+// Clear expires the cookie immediately.
+func (r *RefreshToken) Clear(c echo.Context) {
+	c.SetCookie(r.cookie(c, "", time.Unix(0, 0)))
+}
+
+// Value returns the refresh token sent with the request, http.ErrNoCookie without one.
+func (r *RefreshToken) Value(c echo.Context) (string, error) {
+	cookie, err := c.Cookie(r.opts.Name)
+	if err != nil {
+		return "", err
+	}
+	return cookie.Value, nil
+}
+
+func (r *RefreshToken) cookie(c echo.Context, value string, expires time.Time) *http.Cookie {
+	ctx := c.Request().Context()
+	domain := ctxUtil.CookieDomain(ctx)
+	if domain == "" {
+		domain = r.opts.Domain
+	}
+	return &http.Cookie{
+		Name:     r.opts.Name,
+		Value:    value,
+		HttpOnly: true,
+		Secure:   r.opts.Secure && (ctxUtil.CookieSecure(ctx) || r.sameSite == http.SameSiteNoneMode),
+		SameSite: r.sameSite,
+		Expires:  expires,
+		Path:     r.opts.Path,
+		Domain:   domain,
+	}
+}
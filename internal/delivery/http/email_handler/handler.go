@@ -0,0 +1,101 @@
+package emailHandler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"main/internal/delivery/http/linkpage"
+	"main/pkg/customerrors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+type EmailHandler struct {
+	EmailUsecase EmailUsecase
+}
+
+type EmailUsecase interface {
+	//RequestEmailChange verifies the password and sends a confirmation link to the new address.
+	RequestEmailChange(ctx context.Context, userID uuid.UUID, password, newEmail string) error
+
+	//ConfirmEmailChange commits the pending email change.
+	ConfirmEmailChange(ctx context.Context, token string) (userID uuid.UUID, err error)
+}
+
+func NewEmailHandler(emailUsecase EmailUsecase) *EmailHandler {
+	return &EmailHandler{
+		EmailUsecase: emailUsecase,
+	}
+}
+
+// DTOs
+type ChangeEmailRequest struct {
+	NewEmail string `json:"new_email"`
+	Password string `json:"password"`
+}
+
+type ConfirmEmailChangeRequest struct {
+	Token string `json:"token" form:"token"`
+}
+
+// ChangeEmail starts an email change for the authenticated user. It answers 202, the change
+// is only applied once the link sent to the new address is opened.
+func (h *EmailHandler) ChangeEmail(c echo.Context) error {
+	userID, _ := c.Get("userID").(uuid.UUID)
+
+	var req ChangeEmailRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	err := h.EmailUsecase.RequestEmailChange(c.Request().Context(), userID, req.Password, req.NewEmail)
+	if err != nil {
+		switch {
+		case errors.Is(err, customerrors.ErrInvalidCredentials):
+			return echo.NewHTTPError(http.StatusForbidden, "password is incorrect")
+		case errors.Is(err, customerrors.ErrInvalidEmail):
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		case errors.Is(err, customerrors.ErrEmailTaken):
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		case errors.Is(err, customerrors.ErrHandleUnavailable):
+			return echo.NewHTTPError(http.StatusForbidden, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to change email: %v", err))
+	}
+	return c.NoContent(http.StatusAccepted)
+}
+
+// ConfirmEmailChangePage serves the link sent to the new address (GET ?token=), a page whose button posts the token
+// to ConfirmEmailChange. Opening the link does not use up the token.
+func (h *EmailHandler) ConfirmEmailChangePage(c echo.Context) error {
+	return linkpage.Confirm(c, "Confirm your new email address", "Confirm")
+}
+
+// ConfirmEmailChange applies the change with the token posted by API clients or by the page of the link.
+func (h *EmailHandler) ConfirmEmailChange(c echo.Context) error {
+	var req ConfirmEmailChangeRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	userID, err := h.EmailUsecase.ConfirmEmailChange(c.Request().Context(), req.Token)
+	if err != nil {
+		switch {
+		case errors.Is(err, customerrors.ErrInvalidToken):
+			if linkpage.Posted(c) {
+				return linkpage.Result(c, http.StatusBadRequest, "This link is invalid or has expired.")
+			}
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		case errors.Is(err, customerrors.ErrEmailTaken):
+			if linkpage.Posted(c) {
+				return linkpage.Result(c, http.StatusConflict, "This email address is already used by another account.")
+			}
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to confirm email change: %v", err))
+	}
+	if linkpage.Posted(c) {
+		return linkpage.Result(c, http.StatusOK, "Your email address is changed.")
+	}
+	return c.JSON(200, map[string]string{"user_id": userID.String(), "status": "changed"})
+}
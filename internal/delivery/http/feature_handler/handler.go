@@ -0,0 +1,129 @@
+package featureHandler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"main/domain/entity"
+	"main/pkg/customerrors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// FeatureHandler serves the admin API of the gradual rollouts of new auth flows.
+type FeatureHandler struct {
+	FeatureUsecase FeatureUsecase
+}
+
+type FeatureUsecase interface {
+	//ListFlags returns the rollouts of all features.
+	ListFlags(ctx context.Context) ([]entity.FeatureFlag, error)
+
+	//SetPercentage rolls the feature out to the percentage of the users.
+	SetPercentage(ctx context.Context, feature entity.Feature, percentage int, adminID uuid.UUID) (entity.FeatureFlag, error)
+
+	//AllowUser enables the feature for the user whatever the percentage.
+	AllowUser(ctx context.Context, feature entity.Feature, userID, adminID uuid.UUID) error
+
+	//DisallowUser removes the user from the allowlist of the feature.
+	DisallowUser(ctx context.Context, feature entity.Feature, userID, adminID uuid.UUID) error
+
+	//ListAllowedUsers returns a page of the allowlist of the feature.
+	ListAllowedUsers(ctx context.Context, feature entity.Feature, limit, offset int) ([]entity.FeatureUser, error)
+}
+
+func NewFeatureHandler(featureUsecase FeatureUsecase) *FeatureHandler {
+	return &FeatureHandler{
+		FeatureUsecase: featureUsecase,
+	}
+}
+
+// DTOs
+type SetRolloutRequest struct {
+	// Percentage of the users the feature is enabled for, 0 to 100
+	Percentage *int `json:"percentage"`
+}
+
+type ListAllowedUsersRequest struct {
+	Limit  int `query:"limit"`
+	Offset int `query:"offset"`
+}
+
+// ListFlags returns the rollouts of passkeys, DPoP and the password hashing algorithm.
+func (h *FeatureHandler) ListFlags(c echo.Context) error {
+	flags, err := h.FeatureUsecase.ListFlags(c.Request().Context())
+	if err != nil {
+		return featureError(err, "failed to list features")
+	}
+	return c.JSON(http.StatusOK, flags)
+}
+
+// SetRollout changes the percentage of the users the feature in the path is enabled for. Lowering it rolls the
+// feature back for the users above it, allowlisted users keep it.
+func (h *FeatureHandler) SetRollout(c echo.Context) error {
+	adminID, _ := c.Get("userID").(uuid.UUID)
+	var req SetRolloutRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	if req.Percentage == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, customerrors.ErrInvalidRolloutPercentage.Error())
+	}
+	flag, err := h.FeatureUsecase.SetPercentage(c.Request().Context(), entity.Feature(c.Param("name")), *req.Percentage, adminID)
+	if err != nil {
+		return featureError(err, "failed to change rollout")
+	}
+	return c.JSON(http.StatusOK, flag)
+}
+
+// ListAllowedUsers returns a page of the allowlist of the feature in the path.
+func (h *FeatureHandler) ListAllowedUsers(c echo.Context) error {
+	var req ListAllowedUsersRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	users, err := h.FeatureUsecase.ListAllowedUsers(c.Request().Context(), entity.Feature(c.Param("name")), req.Limit, req.Offset)
+	if err != nil {
+		return featureError(err, "failed to list allowlist")
+	}
+	return c.JSON(http.StatusOK, users)
+}
+
+// AllowUser adds the user in the path to the allowlist of the feature.
+func (h *FeatureHandler) AllowUser(c echo.Context) error {
+	adminID, _ := c.Get("userID").(uuid.UUID)
+	userID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid user ID")
+	}
+	if err := h.FeatureUsecase.AllowUser(c.Request().Context(), entity.Feature(c.Param("name")), userID, adminID); err != nil {
+		return featureError(err, "failed to allow user")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// DisallowUser removes the user in the path from the allowlist of the feature.
+func (h *FeatureHandler) DisallowUser(c echo.Context) error {
+	adminID, _ := c.Get("userID").(uuid.UUID)
+	userID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid user ID")
+	}
+	if err := h.FeatureUsecase.DisallowUser(c.Request().Context(), entity.Feature(c.Param("name")), userID, adminID); err != nil {
+		return featureError(err, "failed to disallow user")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func featureError(err error, msg string) error {
+	switch {
+	case errors.Is(err, customerrors.ErrUnknownFeature), errors.Is(err, customerrors.ErrUserNotFound),
+		errors.Is(err, customerrors.ErrUserNotAllowlisted):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, customerrors.ErrInvalidRolloutPercentage):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("%s: %v", msg, err))
+}
@@ -0,0 +1,90 @@
+package handleHandler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"main/domain/entity"
+	"main/pkg/customerrors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+type HandleHandler struct {
+	HandleUsecase HandleUsecase
+}
+
+type HandleUsecase interface {
+	//Ban bans a username or an email address on the tenant, on every domain for the empty tenant.
+	Ban(ctx context.Context, tenant, handle, reason string, adminID uuid.UUID) (entity.BannedHandle, error)
+
+	//Unban lifts the ban of the handle on the tenant.
+	Unban(ctx context.Context, tenant, handle string) error
+
+	//List returns the bans of the tenant.
+	List(ctx context.Context, tenant string) ([]entity.BannedHandle, error)
+}
+
+func NewHandleHandler(handleUsecase HandleUsecase) *HandleHandler {
+	return &HandleHandler{
+		HandleUsecase: handleUsecase,
+	}
+}
+
+// DTOs
+type BanRequest struct {
+	// Handle is a username or an email address
+	Handle string `json:"handle"`
+	// Tenant is the host of a tenant domain, empty bans the handle on every domain
+	Tenant string `json:"tenant"`
+	Reason string `json:"reason"`
+}
+
+// Ban bans a username or an email address from registration and username or email changes.
+func (h *HandleHandler) Ban(c echo.Context) error {
+	adminID, _ := c.Get("userID").(uuid.UUID)
+
+	var req BanRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	ban, err := h.HandleUsecase.Ban(c.Request().Context(), req.Tenant, req.Handle, req.Reason, adminID)
+	if err != nil {
+		return handleError(err, "failed to ban handle")
+	}
+	return c.JSON(http.StatusCreated, ban)
+}
+
+// Unban lifts a ban, the tenant is given with the tenant query parameter.
+func (h *HandleHandler) Unban(c echo.Context) error {
+	err := h.HandleUsecase.Unban(c.Request().Context(), c.QueryParam("tenant"), c.Param("handle"))
+	if err != nil {
+		return handleError(err, "failed to unban handle")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// List returns the bans of the tenant query parameter, the bans of every domain without it.
+// The built-in reserved usernames are not listed.
+func (h *HandleHandler) List(c echo.Context) error {
+	bans, err := h.HandleUsecase.List(c.Request().Context(), c.QueryParam("tenant"))
+	if err != nil {
+		return handleError(err, "failed to list banned handles")
+	}
+	if bans == nil {
+		bans = []entity.BannedHandle{}
+	}
+	return c.JSON(http.StatusOK, bans)
+}
+
+func handleError(err error, msg string) error {
+	switch {
+	case errors.Is(err, customerrors.ErrInvalidHandle):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, customerrors.ErrNoTagsAffected):
+		return echo.NewHTTPError(http.StatusNotFound, "handle is not banned")
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("%s: %v", msg, err))
+}
@@ -0,0 +1,151 @@
+package healthHandler
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// checkTimeout bounds each dependency probe, so a hanging dependency cannot hang the probe itself.
+const checkTimeout = 2 * time.Second
+
+type HealthHandler struct {
+	ReadOnly  ReadOnlyMode
+	checks    map[string]Check
+	build     BuildInfo
+	startedAt time.Time
+
+	mu sync.Mutex
+	// lastSuccess is the time of the last passed probe per dependency
+	lastSuccess map[string]time.Time
+}
+
+type ReadOnlyMode interface {
+	//Enabled reports whether the service is degraded to read-only.
+	Enabled() bool
+
+	//Reasons returns why the service is read-only.
+	Reasons() []string
+}
+
+// Check probes one dependency, returning nil if it is usable.
+type Check func(ctx context.Context) error
+
+// BuildInfo identifies the running build.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildTime string `json:"build_time,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+func NewHealthHandler(readOnly ReadOnlyMode, checks map[string]Check, build BuildInfo) *HealthHandler {
+	return &HealthHandler{
+		ReadOnly:    readOnly,
+		checks:      checks,
+		build:       build,
+		startedAt:   time.Now().UTC(),
+		lastSuccess: make(map[string]time.Time, len(checks)),
+	}
+}
+
+// DTOs
+type ReadyResponse struct {
+	Status string `json:"status"`
+	// ReadOnly is true while mutations are rejected, token verification is still served
+	ReadOnly        bool     `json:"read_only"`
+	ReadOnlyReasons []string `json:"read_only_reasons,omitempty"`
+	// Checks maps each dependency to "ok" or its error, Dependencies has the details
+	Checks       map[string]string           `json:"checks"`
+	Dependencies map[string]DependencyStatus `json:"dependencies"`
+	Version      BuildInfo                   `json:"version"`
+	StartedAt    time.Time                   `json:"started_at"`
+	CheckedAt    time.Time                   `json:"checked_at"`
+}
+
+// DependencyStatus is the result of probing one dependency. LastSuccess is the last passed probe
+// of this instance, null if none passed since it started.
+type DependencyStatus struct {
+	Status      string     `json:"status"`
+	LatencyMS   float64    `json:"latency_ms"`
+	LastSuccess *time.Time `json:"last_success"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// Readyz answers 200 while the service can verify tokens, also in read-only mode which is reported
+// as a flag, and 503 when a dependency is down. Dependencies are probed concurrently.
+func (h *HealthHandler) Readyz(c echo.Context) error {
+	resp := ReadyResponse{
+		Status:       "ready",
+		ReadOnly:     h.ReadOnly.Enabled(),
+		Checks:       make(map[string]string, len(h.checks)),
+		Dependencies: make(map[string]DependencyStatus, len(h.checks)),
+		Version:      h.build,
+		StartedAt:    h.startedAt,
+		CheckedAt:    time.Now().UTC(),
+	}
+	if resp.ReadOnly {
+		resp.Status = "degraded"
+		resp.ReadOnlyReasons = h.ReadOnly.Reasons()
+	}
+
+	code := http.StatusOK
+	for name, dep := range h.probe(c.Request().Context()) {
+		resp.Dependencies[name] = dep
+		if dep.Error != "" {
+			resp.Checks[name] = dep.Error
+			resp.Status = "unavailable"
+			code = http.StatusServiceUnavailable
+			continue
+		}
+		resp.Checks[name] = "ok"
+	}
+
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(code, resp)
+}
+
+// probe runs all checks concurrently and records the time of the successful ones.
+func (h *HealthHandler) probe(ctx context.Context) map[string]DependencyStatus {
+	names := make([]string, 0, len(h.checks))
+	for name := range h.checks {
+		names = append(names, name)
+	}
+	results := make([]DependencyStatus, len(names))
+
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			probeCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+			start := time.Now()
+			err := h.checks[name](probeCtx)
+			results[i] = DependencyStatus{Status: "up", LatencyMS: float64(time.Since(start).Microseconds()) / 1000}
+			if err != nil {
+				results[i].Status, results[i].Error = "down", err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+
+	now := time.Now().UTC()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	statuses := make(map[string]DependencyStatus, len(names))
+	for i, name := range names {
+		dep := results[i]
+		if dep.Error == "" {
+			h.lastSuccess[name] = now
+		}
+		if last, ok := h.lastSuccess[name]; ok {
+			dep.LastSuccess = &last
+		}
+		statuses[name] = dep
+	}
+	return statuses
+}
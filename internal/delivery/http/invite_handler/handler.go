@@ -0,0 +1,105 @@
+package inviteHandler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"main/domain/entity"
+	"main/pkg/customerrors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+type InviteHandler struct {
+	InviteUsecase InviteUsecase
+}
+
+type InviteUsecase interface {
+	//CreateInvite creates an invitation and returns its code, which is shown only once.
+	CreateInvite(ctx context.Context, adminID uuid.UUID, maxUses int, ttl time.Duration) (code string, inv entity.Invitation, err error)
+
+	//ListInvites returns the invitations that can still be used.
+	ListInvites(ctx context.Context) ([]entity.Invitation, error)
+
+	//RevokeInvite makes the invitation unusable.
+	RevokeInvite(ctx context.Context, adminID, id uuid.UUID) error
+}
+
+func NewInviteHandler(inviteUsecase InviteUsecase) *InviteHandler {
+	return &InviteHandler{
+		InviteUsecase: inviteUsecase,
+	}
+}
+
+// DTOs
+type CreateInviteRequest struct {
+	// MaxUses defaults to 1 (single-use)
+	MaxUses int `json:"max_uses"`
+	// TTL is a Go duration such as 72h, the configured default when empty
+	TTL string `json:"ttl"`
+}
+
+type CreateInviteResponse struct {
+	Code       string            `json:"code"`
+	Invitation entity.Invitation `json:"invitation"`
+}
+
+// CreateInvite creates an invitation, the code is only part of this response.
+func (h *InviteHandler) CreateInvite(c echo.Context) error {
+	adminID, _ := c.Get("userID").(uuid.UUID)
+
+	req := CreateInviteRequest{MaxUses: 1}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	var ttl time.Duration
+	if req.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(req.TTL); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid ttl: %v", err))
+		}
+	}
+
+	code, inv, err := h.InviteUsecase.CreateInvite(c.Request().Context(), adminID, req.MaxUses, ttl)
+	if err != nil {
+		if errors.Is(err, customerrors.ErrInvalidInvitation) {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to create invitation: %v", err))
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusCreated, CreateInviteResponse{Code: code, Invitation: inv})
+}
+
+// ListInvites lists the invitations that can still be used.
+func (h *InviteHandler) ListInvites(c echo.Context) error {
+	invitations, err := h.InviteUsecase.ListInvites(c.Request().Context())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to list invitations: %v", err))
+	}
+	if invitations == nil {
+		invitations = []entity.Invitation{}
+	}
+	return c.JSON(http.StatusOK, invitations)
+}
+
+// RevokeInvite revokes the invitation in the path.
+func (h *InviteHandler) RevokeInvite(c echo.Context) error {
+	adminID, _ := c.Get("userID").(uuid.UUID)
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid invitation ID")
+	}
+
+	err = h.InviteUsecase.RevokeInvite(c.Request().Context(), adminID, id)
+	if err != nil {
+		if errors.Is(err, customerrors.ErrInvalidInvite) {
+			return echo.NewHTTPError(http.StatusNotFound, "invitation not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to revoke invitation: %v", err))
+	}
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,83 @@
+// Package linkpage serves the pages of the single-use links sent by email. Opening a link must not use up its
+// token, mail scanners and link previews open it as well: the link shows a page whose button posts the token
+// back, only the POST applies it.
+package linkpage
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// contentSecurityPolicy allows no scripts or external resources, the form may only post to this service.
+const contentSecurityPolicy = "default-src 'none'; form-action 'self'; frame-ancestors 'none'; base-uri 'none'"
+
+var confirmPage = template.Must(template.New("confirm").Parse(`<!doctype html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <meta name="referrer" content="no-referrer">
+  <title>{{.Title}}</title>
+</head>
+<body>
+  <form method="post" action="{{.Action}}">
+    <h1>{{.Title}}</h1>
+    <input type="hidden" name="token" value="{{.Token}}">
+    <button type="submit">{{.Button}}</button>
+  </form>
+</body>
+</html>
+`))
+
+var resultPage = template.Must(template.New("result").Parse(`<!doctype html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>{{.}}</title>
+</head>
+<body>
+  <p>{{.}}</p>
+</body>
+</html>
+`))
+
+// Confirm answers the GET of a link with a page posting its token to the same path when the button is pressed.
+func Confirm(c echo.Context, title, button string) error {
+	var page strings.Builder
+	err := confirmPage.Execute(&page, map[string]string{
+		"Title":  title,
+		"Button": button,
+		"Action": c.Request().URL.Path,
+		"Token":  c.QueryParam("token"),
+	})
+	if err != nil {
+		return err
+	}
+	return render(c, http.StatusOK, page.String())
+}
+
+// Posted reports whether the request was posted by the page of Confirm, which is answered with Result.
+func Posted(c echo.Context) bool {
+	return strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEApplicationForm)
+}
+
+// Result answers the post of the page of Confirm with the message.
+func Result(c echo.Context, status int, message string) error {
+	var page strings.Builder
+	if err := resultPage.Execute(&page, message); err != nil {
+		return err
+	}
+	return render(c, status, page.String())
+}
+
+func render(c echo.Context, status int, page string) error {
+	header := c.Response().Header()
+	header.Set("Content-Security-Policy", contentSecurityPolicy)
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set("Cache-Control", "no-store")
+	return c.HTML(status, page)
+}
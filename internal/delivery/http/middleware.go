@@ -2,35 +2,210 @@ package http
 
 import (
 	"context"
+	"crypto/subtle"
+	"errors"
+	"main/domain/entity"
 	"main/internal/config"
 	metrics "main/internal/metrics"
+	"main/pkg/customerrors"
+	"main/pkg/dpop"
+	"main/pkg/ratelimit"
+	"main/pkg/retry"
+	"main/pkg/utils"
+	ctxUtil "main/pkg/utils/context"
+	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
-	"github.com/redis/go-redis/v9"
+	"github.com/labstack/echo/v4/middleware"
 )
 
 type AuthUsecase interface {
-	// VerifyUser verifies the access token and returns the user ID.
-	VerifyUser(token string) (userID uuid.UUID, err error)
+	// VerifyAccessClaims verifies the access token and returns its claims.
+	VerifyAccessClaims(token string) (entity.AccessTokenClaims, error)
+
+	// VerifyProof verifies a DPoP proof and returns the thumbprint of its key.
+	VerifyProof(ctx context.Context, proof, method, uri, accessToken string) (jkt string, err error)
+}
+
+type TenantResolver interface {
+	// Resolve returns the tenant domain serving the host, the default domain for unknown hosts.
+	Resolve(host string) entity.TenantDomain
+}
+
+// TenantMiddleware stores the tenant domain of the request host in the request context,
+// which selects the issuer of issued tokens and the domain of cookies.
+func TenantMiddleware(tenants TenantResolver) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			c.SetRequest(req.WithContext(ctxUtil.NewTenantContext(req.Context(), tenants.Resolve(req.Host))))
+			return next(c)
+		}
+	}
+}
+
+// InsecureCookiesMiddleware drops the Secure flag of the cookies set by the handlers, for local development over
+// plain HTTP only.
+func InsecureCookiesMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			c.SetRequest(req.WithContext(ctxUtil.NewInsecureCookiesContext(req.Context())))
+			return next(c)
+		}
+	}
+}
+
+// RequestInfoMiddleware stores the IP and user agent of the client in the request context for the audit log.
+func RequestInfoMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			info := ctxUtil.RequestInfo{IP: c.RealIP(), UserAgent: req.UserAgent()}
+			c.SetRequest(req.WithContext(ctxUtil.NewRequestInfoContext(req.Context(), info)))
+			return next(c)
+		}
+	}
+}
+
+// RetryTraceMiddleware starts the retry trace of the request, retries of dependencies made for the request are
+// reported in its log line. It must come before the request logger.
+func RetryTraceMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			ctx, _ := retry.NewContext(c.Request().Context())
+			c.SetRequest(c.Request().WithContext(ctx))
+			return next(c)
+		}
+	}
+}
+
+// CORSMiddleware applies the CORS policy of the route group of the request: the admin API (/admin),
+// the OAuth endpoints (/oauth and the authorization server metadata at both discovery paths) or the public auth endpoints (all others).
+// It runs for unrouted requests as well, so preflight requests get the headers of their group.
+func CORSMiddleware(cfg config.CORSConfig) echo.MiddlewareFunc {
+	admin, oauth, public := corsPolicy(cfg.Admin), corsPolicy(cfg.OAuth), corsPolicy(cfg.Public)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		adminNext, oauthNext, publicNext := admin(next), oauth(next), public(next)
+		return func(c echo.Context) error {
+			path := c.Request().URL.Path
+			switch {
+			case path == "/admin" || strings.HasPrefix(path, "/admin/"):
+				return adminNext(c)
+			case strings.HasPrefix(path, "/oauth/") || path == "/.well-known/oauth-authorization-server" ||
+				path == "/.well-known/openid-configuration":
+				return oauthNext(c)
+			}
+			return publicNext(c)
+		}
+	}
+}
+
+// corsPolicy returns the CORS middleware of a policy, a policy without allowed origins sends no CORS headers.
+func corsPolicy(p config.CORSPolicy) echo.MiddlewareFunc {
+	if len(p.AllowOrigins) == 0 {
+		return func(next echo.HandlerFunc) echo.HandlerFunc {
+			return next
+		}
+	}
+	return middleware.CORSWithConfig(middleware.CORSConfig{
+		AllowOrigins:     p.AllowOrigins,
+		AllowMethods:     p.AllowMethods,
+		AllowHeaders:     p.AllowHeaders,
+		ExposeHeaders:    p.ExposeHeaders,
+		AllowCredentials: p.AllowCredentials,
+		MaxAge:           int(p.MaxAge.Seconds()),
+	})
+}
+
+type ReadOnlyMode interface {
+	// Enabled reports whether the service is degraded to read-only.
+	Enabled() bool
+}
+
+// readOnlySafePaths are mutating routes that do not write to the database and keep working in read-only mode.
+var readOnlySafePaths = map[string]struct{}{
+	"/oauth/token":             {},
+	"/oauth/verification-keys": {},
+}
+
+// ReadOnlyMiddleware answers 503 to mutating requests while the service is in read-only mode,
+// token verification (GET routes such as /authz) keeps working.
+func ReadOnlyMiddleware(mode ReadOnlyMode) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			switch c.Request().Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				return next(c)
+			}
+			if _, ok := readOnlySafePaths[c.Path()]; ok || !mode.Enabled() {
+				return next(c)
+			}
+			c.Response().Header().Set("Retry-After", "30")
+			return echo.NewHTTPError(http.StatusServiceUnavailable, "service is in read-only mode")
+		}
+	}
+}
+
+type RBACUsecase interface {
+	// Authorize returns nil if the user has the permission, resource is the accessed route for the decision log.
+	Authorize(ctx context.Context, userID uuid.UUID, permission entity.Permission, resource string) error
 }
 
-// Just a silly example
-func IsAdminMiddleware() echo.MiddlewareFunc {
+// RequirePermission allows the request only if the authenticated user holds the permission through one of their roles.
+// It must be chained after AuthMiddleware, which puts the user ID into the context.
+func RequirePermission(rbacUsecase RBACUsecase, permission entity.Permission) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
-			header := c.Request().Header.Get("authorization")
-			if header == "" || !strings.HasPrefix(header, "Bearer ") {
+			userID, ok := c.Get("userID").(uuid.UUID)
+			if !ok || userID == uuid.Nil {
 				return echo.NewHTTPError(401, "Unauthorized")
 			}
-			if !strings.Contains(header, "admin_token") {
+
+			err := rbacUsecase.Authorize(c.Request().Context(), userID, permission, c.Request().Method+" "+c.Path())
+			if errors.Is(err, customerrors.ErrForbidden) {
 				return echo.NewHTTPError(403, "Forbidden")
 			}
+			if err != nil {
+				return echo.NewHTTPError(500, "Internal Server Error")
+			}
 			return next(c)
+		}
+	}
+}
 
+// RequireElevation allows the request only while the session of the access token is elevated, which the user
+// does by authenticating again at POST /me/elevate. It must be chained after AuthMiddleware.
+func RequireElevation() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			elevatedUntil, _ := c.Get("elevatedUntil").(time.Time)
+			if !time.Now().Before(elevatedUntil) {
+				return c.JSON(http.StatusForbidden, map[string]string{
+					"error": customerrors.ErrElevationRequired.Error(),
+					"code":  "elevation_required",
+				})
+			}
+			return next(c)
+		}
+	}
+}
+
+// TokenCookieMiddleware lets browsers authenticate page loads: when the request has no Authorization header,
+// the bearer token is taken from the cookie. It must be chained before AuthMiddleware.
+func TokenCookieMiddleware(name string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if c.Request().Header.Get("authorization") == "" {
+				if cookie, err := c.Cookie(name); err == nil && cookie.Value != "" {
+					c.Request().Header.Set("authorization", "Bearer "+cookie.Value)
+				}
+			}
+			return next(c)
 		}
 	}
 }
@@ -39,50 +214,66 @@ func AuthMiddleware(authUsecase AuthUsecase) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 
-			header := c.Request().Header.Get("authorization")
-			if header == "" || !strings.HasPrefix(header, "Bearer ") {
+			accessToken, isDPoP, ok := dpop.ParseAuthorization(c.Request().Header.Get("authorization"))
+			if !ok {
 				return echo.NewHTTPError(401, "Unauthorized")
 			}
 
-			accessToken := strings.TrimPrefix(header, "Bearer ")
-
-			userID, err := authUsecase.VerifyUser(accessToken)
+			claims, err := authUsecase.VerifyAccessClaims(accessToken)
 			if err != nil {
 				return echo.NewHTTPError(401, "Unauthorized")
 			}
-			if userID == uuid.Nil {
+			if claims.UserID == uuid.Nil {
+				return echo.NewHTTPError(401, "Unauthorized")
+			}
+			// tokens are only accepted on the tenant domain they were issued on
+			if tenant, ok := ctxUtil.TenantFromContext(c.Request().Context()); ok && claims.Issuer != "" && claims.Issuer != tenant.Issuer {
+				return echo.NewHTTPError(401, "Unauthorized")
+			}
+			// certificate-bound token (RFC 8705), only usable over mTLS with the same certificate
+			if claims.CertThumbprint != "" && utils.RequestCertThumbprint(c.Request()) != claims.CertThumbprint {
+				return echo.NewHTTPError(401, "Unauthorized")
+			}
+			// DPoP-bound token (RFC 9449), only usable with the DPoP scheme and a fresh proof of the same key
+			if isDPoP != (claims.DPoPThumbprint != "") {
 				return echo.NewHTTPError(401, "Unauthorized")
 			}
+			if isDPoP {
+				req := c.Request()
+				jkt, err := authUsecase.VerifyProof(req.Context(), req.Header.Get(dpop.HeaderName), req.Method, dpop.RequestURL(req), accessToken)
+				if err != nil || subtle.ConstantTimeCompare([]byte(jkt), []byte(claims.DPoPThumbprint)) != 1 {
+					return echo.NewHTTPError(401, "Unauthorized")
+				}
+			}
 
-			c.Set("userID", userID)
+			c.Set("userID", claims.UserID)
+			c.Set("sessionID", claims.SessionID)
+			c.Set("elevatedUntil", claims.ElevatedUntil)
 			return next(c)
 		}
 	}
 }
 
-func RateLimitMiddleware(client *redis.Client, cfg *config.RateLimiterConfig) echo.MiddlewareFunc {
+type ClientKeyer interface {
+	// RateLimitKey returns the key the requests of a client IP are counted under.
+	RateLimitKey(ip string) string
+}
+
+func RateLimitMiddleware(store ratelimit.Store, cfg *config.RateLimiterConfig, keyer ClientKeyer) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 
 			// Get the client's IP address
 			ip := c.RealIP()
-			key := "rate_limit:" + ip
+			key := "rate_limit:" + keyer.RateLimitKey(ip)
 			ctx := context.Background()
 
 			// Increment the request count for the IP address
-			count, err := client.Incr(ctx, key).Result()
+			count, err := store.Incr(ctx, key, cfg.Window)
 			if err != nil {
 				return echo.NewHTTPError(500, "Internal Server Error")
 			}
 
-			// Set the expiration for the key if it's the first request
-			if count == 1 {
-				err := client.Expire(ctx, key, cfg.Window).Err()
-				if err != nil {
-					return echo.NewHTTPError(500, "Internal Server Error")
-				}
-			}
-
 			// Check if the request count exceeds the limit
 			if count > int64(cfg.Limit) {
 				return echo.NewHTTPError(429, "Too Many Requests")
@@ -97,19 +288,33 @@ func RateLimitMiddleware(client *redis.Client, cfg *config.RateLimiterConfig) ec
 	}
 }
 
+// responseStatus returns the status the request is answered with. A returned error is only written
+// by the error handler after the middlewares, so its status is derived from the error.
+func responseStatus(c echo.Context, err error) int {
+	if err == nil || c.Response().Committed {
+		return c.Response().Status
+	}
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		return he.Code
+	}
+	return http.StatusInternalServerError
+}
+
 func MetricsMiddleware(m *metrics.Metrics) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 
 			startTime := time.Now()
 			err := next(c)
-			duration := time.Since(startTime).Seconds()
+			elapsed := time.Since(startTime)
 
 			path := c.Path()
 			method := c.Request().Method
-			status := strconv.Itoa(c.Response().Status)
+			tenant, _ := ctxUtil.TenantFromContext(c.Request().Context())
 
-			m.RequestDuration.WithLabelValues(method, path, status).Observe(duration)
+			m.ObserveRequest(method, path, c.Response().Status, tenant.Host, elapsed)
+			m.ObserveSLO(method, path, responseStatus(c, err), elapsed)
 
 			return err
 		}
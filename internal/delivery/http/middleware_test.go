@@ -0,0 +1,46 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+type readOnly bool
+
+func (m readOnly) Enabled() bool { return bool(m) }
+
+func TestReadOnlyMiddleware(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+		method  string
+		path    string
+		want    int
+	}{
+		{name: "write while writable", method: http.MethodPost, path: "/register", want: http.StatusOK},
+		{name: "read while read-only", enabled: true, method: http.MethodGet, path: "/authz", want: http.StatusOK},
+		{name: "preflight while read-only", enabled: true, method: http.MethodOptions, path: "/register", want: http.StatusOK},
+		{name: "token exchange while read-only", enabled: true, method: http.MethodPost, path: "/oauth/token", want: http.StatusOK},
+		{name: "write while read-only", enabled: true, method: http.MethodPost, path: "/register", want: http.StatusServiceUnavailable},
+		{name: "delete while read-only", enabled: true, method: http.MethodDelete, path: "/me/sessions", want: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			e.Use(ReadOnlyMiddleware(readOnly(tt.enabled)))
+			e.Add(tt.method, tt.path, func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want == http.StatusServiceUnavailable && rec.Header().Get("Retry-After") == "" {
+				t.Error("503 without Retry-After")
+			}
+		})
+	}
+}
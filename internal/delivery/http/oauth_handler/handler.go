@@ -0,0 +1,122 @@
+package oauthHandler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"main/pkg/customerrors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type OAuthHandler struct {
+	OAuthUsecase OAuthUsecase
+}
+
+type OAuthUsecase interface {
+	//IssueClientToken authenticates a service client and returns a machine token, its lifetime and granted scopes.
+	IssueClientToken(ctx context.Context, grantType, clientID, clientSecret, scope string) (accessToken string, ttl time.Duration, scopes []string, err error)
+
+	//VerificationKeys returns the token verification keys wrapped for the public key of the calling service.
+	VerificationKeys(token string, publicKey json.RawMessage) (string, error)
+}
+
+func NewOAuthHandler(oauthUsecase OAuthUsecase) *OAuthHandler {
+	return &OAuthHandler{
+		OAuthUsecase: oauthUsecase,
+	}
+}
+
+// DTOs
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	Scope       string `json:"scope,omitempty"`
+}
+
+type VerificationKeysRequest struct {
+	// PublicKey is a P-256 JWK generated by the service for this request, the keys are encrypted to it
+	PublicKey json.RawMessage `json:"public_key"`
+}
+
+type VerificationKeysResponse struct {
+	// Keys is a compact JWE (ECDH-ES+A256KW, A256GCM) of the key set
+	Keys string `json:"keys"`
+}
+
+// Token implements the OAuth 2.0 token endpoint for the client_credentials grant (RFC 6749 section 4.4).
+// Client credentials are accepted either via HTTP Basic auth or as form parameters.
+func (h *OAuthHandler) Token(c echo.Context) error {
+	clientID, clientSecret, ok := c.Request().BasicAuth()
+	if !ok {
+		clientID = c.FormValue("client_id")
+		clientSecret = c.FormValue("client_secret")
+	}
+
+	accessToken, ttl, scopes, err := h.OAuthUsecase.IssueClientToken(
+		c.Request().Context(),
+		c.FormValue("grant_type"),
+		clientID,
+		clientSecret,
+		c.FormValue("scope"))
+	if err != nil {
+		switch {
+		case errors.Is(err, customerrors.ErrInvalidClient):
+			c.Response().Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+			return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+		case errors.Is(err, customerrors.ErrInvalidScope), errors.Is(err, customerrors.ErrUnsupportedGrantType):
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "server_error")
+	}
+
+	// token responses must never be cached (RFC 6749 section 5.1)
+	c.Response().Header().Set("Cache-Control", "no-store")
+	c.Response().Header().Set("Pragma", "no-cache")
+
+	return c.JSON(200, TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(ttl.Seconds()),
+		Scope:       strings.Join(scopes, " "),
+	})
+}
+
+// VerificationKeys returns the keys verifying tokens to a service authenticated with a service token carrying
+// the key distribution scope, so that it can verify tokens without calling /authz for each request. The keys
+// are encrypted to the public key in the body and must be fetched again after the refresh_in of the set.
+func (h *OAuthHandler) VerificationKeys(c echo.Context) error {
+	token, ok := strings.CutPrefix(c.Request().Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		c.Response().Header().Set("WWW-Authenticate", "Bearer")
+		return echo.NewHTTPError(http.StatusUnauthorized, customerrors.ErrInvalidClient.Error())
+	}
+	var req VerificationKeysRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+
+	keys, err := h.OAuthUsecase.VerificationKeys(token, req.PublicKey)
+	if err != nil {
+		switch {
+		case errors.Is(err, customerrors.ErrKeyDistributionDisabled):
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		case errors.Is(err, customerrors.ErrInvalidClient):
+			c.Response().Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+		case errors.Is(err, customerrors.ErrInsufficientScope):
+			c.Response().Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope"`)
+			return echo.NewHTTPError(http.StatusForbidden, err.Error())
+		case errors.Is(err, customerrors.ErrInvalidRecipientKey):
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "server_error")
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusOK, VerificationKeysResponse{Keys: keys})
+}
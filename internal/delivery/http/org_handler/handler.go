@@ -0,0 +1,218 @@
+package orgHandler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"main/domain/entity"
+	"main/pkg/customerrors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+type OrgHandler struct {
+	OrgUsecase OrgUsecase
+}
+
+type OrgUsecase interface {
+	//CreateOrganization creates an active organization.
+	CreateOrganization(ctx context.Context, adminID uuid.UUID, name string) (entity.Organization, error)
+
+	//GetOrganization returns the organization with its members.
+	GetOrganization(ctx context.Context, id uuid.UUID) (entity.OrganizationDetail, error)
+
+	//ListOrganizations returns a page of organizations.
+	ListOrganizations(ctx context.Context, limit, offset int) ([]entity.Organization, error)
+
+	//SuspendOrganization suspends the organization and revokes the sessions of its members, a reason is required.
+	SuspendOrganization(ctx context.Context, adminID, id uuid.UUID, reason entity.AdminReason) (entity.AffectedReport, error)
+
+	//ResumeOrganization reactivates a suspended organization.
+	ResumeOrganization(ctx context.Context, adminID, id uuid.UUID) error
+
+	//DeleteOrganization deletes the organization, revokes the sessions of its members and schedules the purge of its data.
+	DeleteOrganization(ctx context.Context, adminID, id uuid.UUID, reason entity.AdminReason) (entity.AffectedReport, error)
+
+	//AddMember adds the user to the organization.
+	AddMember(ctx context.Context, adminID, id, userID uuid.UUID) error
+
+	//RemoveMember removes the user from the organization.
+	RemoveMember(ctx context.Context, adminID, id, userID uuid.UUID) error
+}
+
+func NewOrgHandler(orgUsecase OrgUsecase) *OrgHandler {
+	return &OrgHandler{
+		OrgUsecase: orgUsecase,
+	}
+}
+
+// DTOs
+type CreateOrganizationRequest struct {
+	Name string `json:"name"`
+}
+
+type ListOrganizationsRequest struct {
+	Limit  int `query:"limit"`
+	Offset int `query:"offset"`
+}
+
+type ReasonRequest struct {
+	ReasonCode string `json:"reason_code"`
+	Reason     string `json:"reason"`
+}
+
+type AddMemberRequest struct {
+	UserID string `json:"user_id"`
+}
+
+// CreateOrganization creates an organization without members.
+func (h *OrgHandler) CreateOrganization(c echo.Context) error {
+	adminID, _ := c.Get("userID").(uuid.UUID)
+	var req CreateOrganizationRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	org, err := h.OrgUsecase.CreateOrganization(c.Request().Context(), adminID, req.Name)
+	if err != nil {
+		return orgError(err, "failed to create organization")
+	}
+	return c.JSON(http.StatusCreated, org)
+}
+
+// ListOrganizations returns a page of organizations, newest first.
+func (h *OrgHandler) ListOrganizations(c echo.Context) error {
+	var req ListOrganizationsRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	orgs, err := h.OrgUsecase.ListOrganizations(c.Request().Context(), req.Limit, req.Offset)
+	if err != nil {
+		return orgError(err, "failed to list organizations")
+	}
+	if orgs == nil {
+		orgs = []entity.Organization{}
+	}
+	return c.JSON(http.StatusOK, orgs)
+}
+
+// GetOrganization returns the organization in the path with its members.
+func (h *OrgHandler) GetOrganization(c echo.Context) error {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid organization ID")
+	}
+	detail, err := h.OrgUsecase.GetOrganization(c.Request().Context(), id)
+	if err != nil {
+		return orgError(err, "failed to get organization")
+	}
+	if detail.Members == nil {
+		detail.Members = []entity.OrgMember{}
+	}
+	return c.JSON(http.StatusOK, detail)
+}
+
+// SuspendOrganization suspends the organization in the path and answers with the revoked member sessions.
+func (h *OrgHandler) SuspendOrganization(c echo.Context) error {
+	adminID, _ := c.Get("userID").(uuid.UUID)
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid organization ID")
+	}
+	var req ReasonRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	report, err := h.OrgUsecase.SuspendOrganization(c.Request().Context(), adminID, id, req.reason())
+	if err != nil {
+		return orgError(err, "failed to suspend organization")
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// ResumeOrganization reactivates the suspended organization in the path.
+func (h *OrgHandler) ResumeOrganization(c echo.Context) error {
+	adminID, _ := c.Get("userID").(uuid.UUID)
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid organization ID")
+	}
+	if err := h.OrgUsecase.ResumeOrganization(c.Request().Context(), adminID, id); err != nil {
+		return orgError(err, "failed to resume organization")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// DeleteOrganization deletes the organization in the path and answers with the revoked member sessions.
+func (h *OrgHandler) DeleteOrganization(c echo.Context) error {
+	adminID, _ := c.Get("userID").(uuid.UUID)
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid organization ID")
+	}
+	var req ReasonRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	report, err := h.OrgUsecase.DeleteOrganization(c.Request().Context(), adminID, id, req.reason())
+	if err != nil {
+		return orgError(err, "failed to delete organization")
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// AddMember adds the user in the body to the organization in the path.
+func (h *OrgHandler) AddMember(c echo.Context) error {
+	adminID, _ := c.Get("userID").(uuid.UUID)
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid organization ID")
+	}
+	var req AddMemberRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	userID, err := uuid.Parse(req.UserID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid user ID")
+	}
+	if err := h.OrgUsecase.AddMember(c.Request().Context(), adminID, id, userID); err != nil {
+		return orgError(err, "failed to add member")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// RemoveMember removes the user in the path from the organization.
+func (h *OrgHandler) RemoveMember(c echo.Context) error {
+	adminID, _ := c.Get("userID").(uuid.UUID)
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid organization ID")
+	}
+	userID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid user ID")
+	}
+	if err := h.OrgUsecase.RemoveMember(c.Request().Context(), adminID, id, userID); err != nil {
+		return orgError(err, "failed to remove member")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (r ReasonRequest) reason() entity.AdminReason {
+	return entity.AdminReason{Code: entity.AdminReasonCode(r.ReasonCode), Text: r.Reason}
+}
+
+// orgError maps the errors of organization operations to HTTP errors.
+func orgError(err error, msg string) error {
+	switch {
+	case errors.Is(err, customerrors.ErrReasonRequired), errors.Is(err, customerrors.ErrInvalidOrganizationName):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, customerrors.ErrOrganizationNotFound), errors.Is(err, customerrors.ErrUserNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, customerrors.ErrOrganizationState):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("%s: %v", msg, err))
+}
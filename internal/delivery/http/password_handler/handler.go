@@ -0,0 +1,152 @@
+package passwordHandler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"main/domain/entity"
+	"main/internal/delivery/http/cookie"
+	"main/pkg/customerrors"
+	"main/pkg/locale"
+	"main/pkg/utils"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+type PasswordHandler struct {
+	PasswordUsecase PasswordUsecase
+	RefreshCookie   *cookie.RefreshToken
+}
+
+type PasswordUsecase interface {
+	//ForgotPassword emails a reset link if the account exists.
+	ForgotPassword(ctx context.Context, email string)
+
+	//ResetPassword sets a new password using a reset token and revokes all sessions, a non-nil client asks for a new session.
+	ResetPassword(ctx context.Context, token, newPassword string, client *entity.LoginInput) (entity.PasswordResetResult, error)
+
+	//ChangePassword verifies the current password, sets the new one and revokes all other sessions.
+	ChangePassword(ctx context.Context, userID, sessionID uuid.UUID, currentPassword, newPassword string) (warnings []string, err error)
+}
+
+func NewPasswordHandler(passwordUsecase PasswordUsecase, refreshCookie *cookie.RefreshToken) *PasswordHandler {
+	return &PasswordHandler{
+		PasswordUsecase: passwordUsecase,
+		RefreshCookie:   refreshCookie,
+	}
+}
+
+// DTOs
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+	// StartSession logs the user in with the reset when the server allows it, see ResetPasswordResponse
+	StartSession bool `json:"start_session"`
+	// ClientType is the client type of that session: web, mobile, cli, service. Defaults to web.
+	ClientType string `json:"client_type"`
+}
+
+// ResetPasswordResponse answers resets that started a session, the refresh token is set as a cookie like on login.
+type ResetPasswordResponse struct {
+	AccessToken string   `json:"access_token"`
+	TokenType   string   `json:"token_type"`
+	Receipt     string   `json:"receipt,omitempty"`
+	Warnings    []string `json:"warnings,omitempty"`
+}
+
+type PasswordWarningsResponse struct {
+	Warnings []string `json:"warnings"`
+}
+
+// ForgotPassword always answers 202 so it cannot be used to probe which emails are registered.
+func (h *PasswordHandler) ForgotPassword(c echo.Context) error {
+	var req ForgotPasswordRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	h.PasswordUsecase.ForgotPassword(c.Request().Context(), req.Email)
+	return c.NoContent(http.StatusAccepted)
+}
+
+// ResetPassword sets the new password. All sessions of the user are revoked, so the client has to log in again
+// unless it asked for a session and the server issues them after resets: then it gets the tokens like on login.
+// Without tokens in the response (disabled, blocked user, terms to accept) the client falls back to the login.
+func (h *PasswordHandler) ResetPassword(c echo.Context) error {
+	var req ResetPasswordRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	var client *entity.LoginInput
+	if req.StartSession {
+		client = &entity.LoginInput{
+			UserAgent:      c.Request().UserAgent(),
+			AcceptLanguage: c.Request().Header.Get("Accept-Language"),
+			Timezone:       c.Request().Header.Get(locale.TimezoneHeader),
+			IP:             c.RealIP(),
+			ClientType:     req.ClientType,
+			CertThumbprint: utils.RequestCertThumbprint(c.Request()),
+		}
+	}
+	result, err := h.PasswordUsecase.ResetPassword(c.Request().Context(), req.Token, req.NewPassword, client)
+	if err != nil {
+		if errors.Is(err, customerrors.ErrInvalidToken) || errors.Is(err, customerrors.ErrPasswordPolicy) ||
+			errors.Is(err, customerrors.ErrPasswordBreached) {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to reset password: %v", err))
+	}
+	if result.Tokens == nil {
+		return passwordSet(c, result.Warnings)
+	}
+
+	h.RefreshCookie.Set(c, result.Tokens.RefreshToken)
+	c.Set("user_id", result.Tokens.UserID)
+	return c.JSON(http.StatusOK, ResetPasswordResponse{
+		AccessToken: result.Tokens.AccessToken,
+		TokenType:   "Bearer",
+		Receipt:     result.Tokens.Receipt,
+		Warnings:    result.Warnings,
+	})
+}
+
+// ChangePassword changes the password of the authenticated user. The current session stays logged in,
+// every other session is revoked.
+func (h *PasswordHandler) ChangePassword(c echo.Context) error {
+	userID, _ := c.Get("userID").(uuid.UUID)
+	sessionID, _ := c.Get("sessionID").(uuid.UUID)
+
+	var req ChangePasswordRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	warnings, err := h.PasswordUsecase.ChangePassword(c.Request().Context(), userID, sessionID, req.CurrentPassword, req.NewPassword)
+	if err != nil {
+		switch {
+		case errors.Is(err, customerrors.ErrInvalidCredentials):
+			return echo.NewHTTPError(http.StatusForbidden, "current password is incorrect")
+		case errors.Is(err, customerrors.ErrPasswordPolicy), errors.Is(err, customerrors.ErrPasswordBreached):
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to change password: %v", err))
+	}
+	return passwordSet(c, warnings)
+}
+
+// passwordSet answers a successful password update, with a body only if there are warnings about the password.
+func passwordSet(c echo.Context, warnings []string) error {
+	if len(warnings) == 0 {
+		return c.NoContent(http.StatusNoContent)
+	}
+	return c.JSON(http.StatusOK, PasswordWarningsResponse{Warnings: warnings})
+}
@@ -0,0 +1,150 @@
+package publicHandler
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	ctxUtil "main/pkg/utils/context"
+
+	"github.com/labstack/echo/v4"
+)
+
+// PublicHandler serves the documents any client may fetch without authentication. They only change
+// with a deployment, so they are rendered once and served with a strong ETag and a public max-age,
+// which lets CDNs and the HTTP caches of verifying services absorb the traffic.
+type PublicHandler struct {
+	cacheMaxAge time.Duration
+	version     document
+	// metadata is rendered per issuer, the tenant domain of the request selects it
+	metadata      map[string]document
+	defaultIssuer string
+}
+
+// document is a rendered JSON response with its ETag.
+type document struct {
+	body []byte
+	etag string
+}
+
+// NewPublicHandler renders the documents. issuers holds the issuer of the default domain
+// followed by the issuers of the tenant domains.
+func NewPublicHandler(issuers []string, dpopAlgorithms []string, cacheMaxAge time.Duration) (*PublicHandler, error) {
+	version, err := newDocument(newVersionResponse())
+	if err != nil {
+		return nil, err
+	}
+	h := &PublicHandler{
+		cacheMaxAge: cacheMaxAge,
+		version:     version,
+		metadata:    make(map[string]document, len(issuers)),
+	}
+	for i, issuer := range issuers {
+		issuer = strings.TrimSuffix(issuer, "/")
+		if i == 0 {
+			h.defaultIssuer = issuer
+		}
+		h.metadata[issuer], err = newDocument(MetadataResponse{
+			Issuer:                            issuer,
+			TokenEndpoint:                     issuer + "/oauth/token",
+			GrantTypesSupported:               []string{"client_credentials"},
+			TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post"},
+			DPoPSigningAlgValuesSupported:     dpopAlgorithms,
+			TLSClientCertificateBoundTokens:   true,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// DTOs
+type VersionResponse struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// MetadataResponse is the OAuth 2.0 authorization server metadata (RFC 8414).
+type MetadataResponse struct {
+	Issuer                            string   `json:"issuer"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	DPoPSigningAlgValuesSupported     []string `json:"dpop_signing_alg_values_supported"`
+	TLSClientCertificateBoundTokens   bool     `json:"tls_client_certificate_bound_access_tokens"`
+}
+
+// Version returns the build of the running binary.
+func (h *PublicHandler) Version(c echo.Context) error {
+	return h.serve(c, h.version)
+}
+
+// Metadata returns the authorization server metadata of the tenant domain of the request, served at
+// /.well-known/oauth-authorization-server and /.well-known/openid-configuration.
+func (h *PublicHandler) Metadata(c echo.Context) error {
+	tenant, _ := ctxUtil.TenantFromContext(c.Request().Context())
+	doc, ok := h.metadata[tenant.Issuer]
+	if !ok {
+		doc = h.metadata[h.defaultIssuer]
+	}
+	return h.serve(c, doc)
+}
+
+// serve writes the document, or 304 if the client already holds the current version.
+func (h *PublicHandler) serve(c echo.Context, doc document) error {
+	header := c.Response().Header()
+	header.Set("ETag", doc.etag)
+	seconds := strconv.Itoa(int(h.cacheMaxAge.Seconds()))
+	header.Set("Cache-Control", "public, max-age="+seconds+", s-maxage="+seconds)
+
+	if etagMatches(c.Request().Header.Get("If-None-Match"), doc.etag) {
+		return c.NoContent(http.StatusNotModified)
+	}
+	return c.JSONBlob(http.StatusOK, doc.body)
+}
+
+func newDocument(v any) (document, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return document{}, err
+	}
+	sum := sha256.Sum256(body)
+	return document{body: body, etag: `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`}, nil
+}
+
+// etagMatches implements the weak comparison of If-None-Match (RFC 9110 section 13.1.2).
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// newVersionResponse reads the module version and VCS revision stamped into the binary by the Go toolchain.
+func newVersionResponse() VersionResponse {
+	resp := VersionResponse{Version: "unknown"}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return resp
+	}
+	resp.GoVersion = info.GoVersion
+	if info.Main.Version != "" {
+		resp.Version = info.Main.Version
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			resp.Revision = setting.Value
+		}
+	}
+	return resp
+}
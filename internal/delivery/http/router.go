@@ -2,30 +2,80 @@ package http
 
 import (
 	"log/slog"
+	"main/domain/entity"
 	"main/internal/config"
+	accountHandler "main/internal/delivery/http/account_handler"
+	adminHandler "main/internal/delivery/http/admin_handler"
+	adminUIHandler "main/internal/delivery/http/admin_ui_handler"
+	auditHandler "main/internal/delivery/http/audit_handler"
 	handler "main/internal/delivery/http/auth_handler"
+	authzHandler "main/internal/delivery/http/authz_handler"
+	emailHandler "main/internal/delivery/http/email_handler"
+	featureHandler "main/internal/delivery/http/feature_handler"
+	handleHandler "main/internal/delivery/http/handle_handler"
+	healthHandler "main/internal/delivery/http/health_handler"
+	inviteHandler "main/internal/delivery/http/invite_handler"
+	oauthHandler "main/internal/delivery/http/oauth_handler"
+	orgHandler "main/internal/delivery/http/org_handler"
+	passwordHandler "main/internal/delivery/http/password_handler"
+	publicHandler "main/internal/delivery/http/public_handler"
+	statsHandler "main/internal/delivery/http/stats_handler"
+	statusHandler "main/internal/delivery/http/status_handler"
+	termsHandler "main/internal/delivery/http/terms_handler"
+	verificationHandler "main/internal/delivery/http/verification_handler"
 	metrics "main/internal/metrics"
+	"main/pkg/ratelimit"
+	"main/pkg/retry"
+	"net/http"
 
 	"github.com/labstack/echo/v4"
 	middleware "github.com/labstack/echo/v4/middleware"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/redis/go-redis/v9"
 )
 
 func MapRoutes(
 	e *echo.Echo,
 	authHandler *handler.AuthHandler,
+	oauthHandler *oauthHandler.OAuthHandler,
+	authzHandler *authzHandler.AuthzHandler,
+	verificationHandler *verificationHandler.VerificationHandler,
+	passwordHandler *passwordHandler.PasswordHandler,
+	emailHandler *emailHandler.EmailHandler,
+	accountHandler *accountHandler.AccountHandler,
+	healthHandler *healthHandler.HealthHandler,
+	adminHandler *adminHandler.AdminHandler,
+	publicHandler *publicHandler.PublicHandler,
+	inviteHandler *inviteHandler.InviteHandler,
+	orgHandler *orgHandler.OrgHandler,
+	termsHandler *termsHandler.TermsHandler,
+	handleHandler *handleHandler.HandleHandler,
+	auditHandler *auditHandler.AuditHandler,
+	statusHandler *statusHandler.StatusHandler,
+	statsHandler *statsHandler.StatsHandler,
+	featureHandler *featureHandler.FeatureHandler,
+	adminUI *adminUIHandler.AdminUIHandler,
 	authUsecase AuthUsecase,
+	rbacUsecase RBACUsecase,
+	readOnly ReadOnlyMode,
 	logger *slog.Logger,
 	rateLimiterConfig config.RateLimiterConfig,
+	corsConfig config.CORSConfig,
 	m *metrics.Metrics,
-	client *redis.Client,
+	gatherer prometheus.Gatherer,
+	rateLimitStore ratelimit.Store,
+	keyer ClientKeyer,
+	tenants TenantResolver,
+	elevation bool,
 ) {
 	// Middlewares
 	e.Use(middleware.Recover())
-	e.Use(middleware.CORS())
+	e.Use(TenantMiddleware(tenants))
+	e.Use(RequestInfoMiddleware())
+	e.Use(RetryTraceMiddleware())
+	e.Use(CORSMiddleware(corsConfig))
 	e.Use(middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
-		Skipper:   func(c echo.Context) bool { return c.Path() == "/metrics" }, // Skip logging for /metrics endpoint
+		Skipper:   func(c echo.Context) bool { return c.Path() == "/metrics" || c.Path() == "/readyz" }, // Skip logging for /metrics and probe endpoints
 		LogURI:    true,
 		LogMethod: true,
 		LogStatus: true,
@@ -36,35 +86,167 @@ func MapRoutes(
 				return nil // ingore gRPC client errors in HTTP logs, as they are handled separately in gRPC interceptors
 			}
 
+			retries := retry.LogAttrs(c.Request().Context())
 			if v.Error != nil {
-				logger.Error("HTTP request error",
+				logger.Error("HTTP request error", append([]any{
 					"method", v.Method,
 					"uri", v.URI,
 					"status", v.Status,
 					"error", v.Error,
-				)
+				}, retries...)...)
 				return nil
 			}
 
-			logger.Info("HTTP request",
+			logger.Info("HTTP request", append([]any{
 				"method", v.Method,
 				"uri", v.URI,
 				"status", v.Status,
 				"error", v.Error,
-			)
+			}, retries...)...)
 
 			return nil
 		},
 	},
 	))
 
-	//routes
-	e.POST("/logout", authHandler.Logout, MetricsMiddleware(m))
-	e.POST("/logout_all", authHandler.LogoutAll, AuthMiddleware(authUsecase), MetricsMiddleware(m))
-	e.POST("/register", authHandler.Register, MetricsMiddleware(m))
-	e.POST("/login", authHandler.Login, RateLimitMiddleware(client, &rateLimiterConfig), MetricsMiddleware(m))
-	e.POST("/refresh", authHandler.RefreshSession, MetricsMiddleware(m))
-	e.GET("/metrics", echo.WrapHandler(promhttp.Handler()))
+	e.Use(ReadOnlyMiddleware(readOnly))
+
+	// routes, the middlewares of every route follow from its entry, see Route
+	routes := []Route{
+		{Method: http.MethodPost, Path: "/logout", Handler: authHandler.Logout, Auth: true},
+		{Method: http.MethodPost, Path: "/logout_all", Handler: authHandler.LogoutAll, Auth: true},
+		{Method: http.MethodPost, Path: "/register", Handler: authHandler.Register},
+		{Method: http.MethodGet, Path: "/availability", Handler: authHandler.Availability, RateLimit: true},
+		{Method: http.MethodPost, Path: "/login", Handler: authHandler.Login, RateLimit: true},
+		{Method: http.MethodPost, Path: "/login/phone/request", Handler: authHandler.RequestPhoneCode, RateLimit: true},
+		{Method: http.MethodPost, Path: "/login/phone/verify", Handler: authHandler.PhoneLogin, RateLimit: true},
+		{Method: http.MethodPost, Path: "/login/mfa/verify", Handler: authHandler.VerifyMFA, RateLimit: true},
+		{Method: http.MethodPost, Path: "/login/mfa/resend", Handler: authHandler.ResendMFA, RateLimit: true},
+		{Method: http.MethodPost, Path: "/login/passkey/options", Handler: authHandler.PasskeyLoginOptions, RateLimit: true},
+		{Method: http.MethodPost, Path: "/login/passkey", Handler: authHandler.PasskeyLogin, RateLimit: true},
+		{Method: http.MethodPost, Path: "/attestation/challenge", Handler: authHandler.AttestationChallenge, RateLimit: true},
+		{Method: http.MethodPost, Path: "/refresh", Handler: authHandler.RefreshSession},
+		{Method: http.MethodPost, Path: "/token/refresh", Handler: authHandler.RefreshNative},
+		{Method: http.MethodPost, Path: "/oauth/token", Handler: oauthHandler.Token, RateLimit: true},
+		{Method: http.MethodPost, Path: "/oauth/verification-keys", Handler: oauthHandler.VerificationKeys, RateLimit: true},
+		{Method: http.MethodGet, Path: "/verify-email", Handler: verificationHandler.VerifyEmailPage},
+		{Method: http.MethodPost, Path: "/verify-email", Handler: verificationHandler.VerifyEmail},
+		{Method: http.MethodPost, Path: "/verify-email/resend", Handler: verificationHandler.ResendVerification, RateLimit: true},
+		{Method: http.MethodPost, Path: "/password/forgot", Handler: passwordHandler.ForgotPassword, RateLimit: true},
+		{Method: http.MethodPost, Path: "/password/reset", Handler: passwordHandler.ResetPassword, RateLimit: true},
+		{Method: http.MethodPost, Path: "/password/change", Handler: passwordHandler.ChangePassword, Auth: true, RateLimit: true},
+		{Method: http.MethodPost, Path: "/email/change", Handler: emailHandler.ChangeEmail, Auth: true, RateLimit: true},
+		{Method: http.MethodGet, Path: "/email/change/confirm", Handler: emailHandler.ConfirmEmailChangePage},
+		{Method: http.MethodPost, Path: "/email/change/confirm", Handler: emailHandler.ConfirmEmailChange},
+		{Method: http.MethodGet, Path: "/me", Handler: authHandler.Me, Auth: true},
+		{Method: http.MethodDelete, Path: "/me", Handler: accountHandler.DeleteMe, Auth: true},
+		{Method: http.MethodPut, Path: "/me/username", Handler: accountHandler.ChangeUsername, Auth: true, RateLimit: true},
+		{Method: http.MethodGet, Path: "/me/login-history", Handler: authHandler.LoginHistory, Auth: true},
+		{Method: http.MethodGet, Path: "/sessions", Handler: authHandler.ListSessions, Auth: true},
+		{Method: http.MethodPatch, Path: "/sessions/:id", Handler: authHandler.RenameSession, Auth: true},
+		{Method: http.MethodDelete, Path: "/sessions/:id", Handler: authHandler.RevokeSession, Auth: true},
+		{Method: http.MethodPost, Path: "/me/elevate", Handler: authHandler.Elevate, Auth: true, RateLimit: true},
+		{Method: http.MethodGet, Path: "/me/security-score", Handler: accountHandler.SecurityScore, Auth: true},
+		{Method: http.MethodPost, Path: "/me/deletion/cancel", Handler: accountHandler.CancelDeletion, Auth: true},
+		{Method: http.MethodGet, Path: "/me/metadata", Handler: accountHandler.GetMetadata, Auth: true},
+		{Method: http.MethodPatch, Path: "/me/metadata", Handler: accountHandler.PatchMetadata, Auth: true},
+		{Method: http.MethodGet, Path: "/me/mfa", Handler: authHandler.GetMFA, Auth: true},
+		{Method: http.MethodPut, Path: "/me/mfa", Handler: authHandler.SetMFA, Auth: true, RateLimit: true},
+		{Method: http.MethodGet, Path: "/me/trusted-devices", Handler: authHandler.ListTrustedDevices, Auth: true},
+		{Method: http.MethodDelete, Path: "/me/trusted-devices", Handler: authHandler.RevokeTrustedDevices, Auth: true},
+		{Method: http.MethodDelete, Path: "/me/trusted-devices/:id", Handler: authHandler.RevokeTrustedDevice, Auth: true},
+		{Method: http.MethodGet, Path: "/me/passkeys", Handler: authHandler.ListPasskeys, Auth: true},
+		{Method: http.MethodPost, Path: "/me/passkeys/options", Handler: authHandler.PasskeyRegistrationOptions, Auth: true},
+		{Method: http.MethodPost, Path: "/me/passkeys", Handler: authHandler.RegisterPasskey, Auth: true},
+		{Method: http.MethodDelete, Path: "/me/passkeys/:id", Handler: authHandler.DeletePasskey, Auth: true},
+		{Method: http.MethodGet, Path: "/me/terms", Handler: termsHandler.MyTerms, Auth: true},
+		{Method: http.MethodPost, Path: "/me/terms/accept", Handler: termsHandler.Accept, Auth: true},
+		{Method: http.MethodGet, Path: "/terms", Handler: termsHandler.Current},
+		{Method: http.MethodGet, Path: "/authz", Handler: authzHandler.Authz},
+
+		// admin API, every route requires its own permission on top of authentication, the most sensitive ones
+		// an elevated session as well
+		{Method: http.MethodGet, Path: "/admin/users", Handler: adminHandler.ListUsers, Permission: entity.PermUserRead},
+		{Method: http.MethodGet, Path: "/admin/users/:id", Handler: adminHandler.GetUser, Permission: entity.PermUserRead},
+		{Method: http.MethodPost, Path: "/admin/users/:id/block", Handler: adminHandler.BlockUser, Permission: entity.PermUserBlock},
+		{Method: http.MethodPost, Path: "/admin/users/:id/unblock", Handler: adminHandler.UnblockUser, Permission: entity.PermUserBlock},
+		{Method: http.MethodPost, Path: "/admin/users/:id/logout", Handler: adminHandler.ForceLogout, Permission: entity.PermSessionRevoke},
+		{Method: http.MethodGet, Path: "/admin/users/:id/sessions", Handler: adminHandler.ListSessions, Permission: entity.PermUserRead},
+		{Method: http.MethodPost, Path: "/admin/users/:id/sessions/:session_id/block", Handler: adminHandler.BlockSession, Permission: entity.PermSessionRevoke},
+		{Method: http.MethodPost, Path: "/admin/users/:id/sessions/:session_id/unblock", Handler: adminHandler.UnblockSession, Permission: entity.PermSessionRevoke},
+		{Method: http.MethodPost, Path: "/admin/users/:id/password-reset", Handler: adminHandler.ForcePasswordReset, Permission: entity.PermPasswordReset, Elevated: true},
+		{Method: http.MethodDelete, Path: "/admin/users/:id", Handler: adminHandler.DeleteUser, Permission: entity.PermUserDelete, Elevated: true},
+		{Method: http.MethodPost, Path: "/admin/users/:id/restore", Handler: adminHandler.RestoreUser, Permission: entity.PermUserDelete},
+		{Method: http.MethodPost, Path: "/admin/users/import", Handler: adminHandler.ImportUsers, Permission: entity.PermUserImport, Elevated: true},
+		{Method: http.MethodPost, Path: "/admin/sessions/compromised", Handler: adminHandler.RevokeCompromisedTokens, Permission: entity.PermSessionRevoke, Elevated: true},
+		{Method: http.MethodPost, Path: "/admin/invites", Handler: inviteHandler.CreateInvite, Permission: entity.PermInviteManage},
+		{Method: http.MethodGet, Path: "/admin/invites", Handler: inviteHandler.ListInvites, Permission: entity.PermInviteManage},
+		{Method: http.MethodDelete, Path: "/admin/invites/:id", Handler: inviteHandler.RevokeInvite, Permission: entity.PermInviteManage},
+		{Method: http.MethodPost, Path: "/admin/orgs", Handler: orgHandler.CreateOrganization, Permission: entity.PermOrgManage},
+		{Method: http.MethodGet, Path: "/admin/orgs", Handler: orgHandler.ListOrganizations, Permission: entity.PermOrgManage},
+		{Method: http.MethodGet, Path: "/admin/orgs/:id", Handler: orgHandler.GetOrganization, Permission: entity.PermOrgManage},
+		{Method: http.MethodPost, Path: "/admin/orgs/:id/suspend", Handler: orgHandler.SuspendOrganization, Permission: entity.PermOrgManage},
+		{Method: http.MethodPost, Path: "/admin/orgs/:id/resume", Handler: orgHandler.ResumeOrganization, Permission: entity.PermOrgManage},
+		{Method: http.MethodDelete, Path: "/admin/orgs/:id", Handler: orgHandler.DeleteOrganization, Permission: entity.PermOrgManage, Elevated: true},
+		{Method: http.MethodPost, Path: "/admin/orgs/:id/members", Handler: orgHandler.AddMember, Permission: entity.PermOrgManage},
+		{Method: http.MethodDelete, Path: "/admin/orgs/:id/members/:user_id", Handler: orgHandler.RemoveMember, Permission: entity.PermOrgManage},
+		{Method: http.MethodPost, Path: "/admin/terms", Handler: termsHandler.Publish, Permission: entity.PermTermsManage},
+		{Method: http.MethodGet, Path: "/admin/terms", Handler: termsHandler.ListDocuments, Permission: entity.PermTermsManage},
+		{Method: http.MethodGet, Path: "/admin/audit", Handler: auditHandler.List, Permission: entity.PermAuditRead},
+		{Method: http.MethodGet, Path: "/admin/stats", Handler: statsHandler.Stats, Permission: entity.PermStatsRead},
+		{Method: http.MethodGet, Path: "/admin/banned-handles", Handler: handleHandler.List, Permission: entity.PermHandleManage},
+		{Method: http.MethodPost, Path: "/admin/banned-handles", Handler: handleHandler.Ban, Permission: entity.PermHandleManage},
+		{Method: http.MethodDelete, Path: "/admin/banned-handles/:handle", Handler: handleHandler.Unban, Permission: entity.PermHandleManage},
+		{Method: http.MethodPost, Path: "/admin/status/incidents", Handler: statusHandler.CreateIncident, Permission: entity.PermStatusManage},
+		{Method: http.MethodGet, Path: "/admin/status/incidents", Handler: statusHandler.ListIncidents, Permission: entity.PermStatusManage},
+		{Method: http.MethodPatch, Path: "/admin/status/incidents/:id", Handler: statusHandler.UpdateIncident, Permission: entity.PermStatusManage},
+		{Method: http.MethodPost, Path: "/admin/status/incidents/:id/resolve", Handler: statusHandler.ResolveIncident, Permission: entity.PermStatusManage},
+		{Method: http.MethodGet, Path: "/admin/features", Handler: featureHandler.ListFlags, Permission: entity.PermFeatureManage},
+		{Method: http.MethodPut, Path: "/admin/features/:name", Handler: featureHandler.SetRollout, Permission: entity.PermFeatureManage},
+		{Method: http.MethodGet, Path: "/admin/features/:name/users", Handler: featureHandler.ListAllowedUsers, Permission: entity.PermFeatureManage},
+		{Method: http.MethodPut, Path: "/admin/features/:name/users/:user_id", Handler: featureHandler.AllowUser, Permission: entity.PermFeatureManage},
+		{Method: http.MethodDelete, Path: "/admin/features/:name/users/:user_id", Handler: featureHandler.DisallowUser, Permission: entity.PermFeatureManage},
+
+		// public documents, cacheable by CDNs and clients, HEAD and conditional requests are supported
+		{Method: http.MethodGet, Path: "/version", Handler: publicHandler.Version},
+		{Method: http.MethodHead, Path: "/version", Handler: publicHandler.Version},
+		{Method: http.MethodGet, Path: "/.well-known/oauth-authorization-server", Handler: publicHandler.Metadata},
+		{Method: http.MethodHead, Path: "/.well-known/oauth-authorization-server", Handler: publicHandler.Metadata},
+		// the same document for clients that only look up OpenID discovery. There is no /.well-known/jwks.json:
+		// tokens are signed with HMAC keys that must stay secret, services fetch them wrapped from
+		// /oauth/verification-keys
+		{Method: http.MethodGet, Path: "/.well-known/openid-configuration", Handler: publicHandler.Metadata},
+		{Method: http.MethodHead, Path: "/.well-known/openid-configuration", Handler: publicHandler.Metadata},
+		// status page of the "can't log in?" screens of client apps
+		{Method: http.MethodGet, Path: "/status", Handler: statusHandler.Status},
+
+		{Method: http.MethodGet, Path: "/readyz", Handler: healthHandler.Readyz, NoMetrics: true},
+		// the registry the metrics are registered with, the default one only holds the Go runtime collectors
+		{Method: http.MethodGet, Path: "/metrics", Handler: echo.WrapHandler(promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{})), NoMetrics: true},
+	}
+
+	// admin console, nil when disabled. The page is authorized with the token cookie set by its login page.
+	if adminUI != nil {
+		routes = append(routes,
+			Route{Method: http.MethodGet, Path: adminUIHandler.LoginPath, Handler: adminUI.Login, NoMetrics: true},
+			Route{Method: http.MethodGet, Path: "/admin/ui/assets/*", Handler: adminUI.Asset, NoMetrics: true},
+			Route{Method: http.MethodGet, Path: "/admin/ui", Handler: adminUI.Index, Permission: entity.PermUserRead, NoMetrics: true,
+				Before: []echo.MiddlewareFunc{adminUI.RedirectToLogin, TokenCookieMiddleware(adminUIHandler.TokenCookie)}},
+			Route{Method: http.MethodGet, Path: "/admin/ui/limits", Handler: adminUI.Limits, Permission: entity.PermUserRead, NoMetrics: true},
+		)
+	}
+
+	var requireElevation echo.MiddlewareFunc
+	if elevation {
+		requireElevation = RequireElevation()
+	}
+	RegisterRoutes(e, routes, RouteMiddlewares{
+		Auth:      AuthMiddleware(authUsecase),
+		RateLimit: RateLimitMiddleware(rateLimitStore, &rateLimiterConfig, keyer),
+		Metrics:   MetricsMiddleware(m),
+		RBAC:      rbacUsecase,
+		Elevation: requireElevation,
+	})
 
 	logger.Info("HTTP routes mapped successfully")
 }
@@ -0,0 +1,67 @@
+package http
+
+import (
+	"main/domain/entity"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Route is an entry of the route table of MapRoutes. Its middlewares are derived from its fields and applied
+// in the same order on every route: Before, authentication, rate limiting, metrics, permission, elevation.
+type Route struct {
+	Method  string
+	Path    string
+	Handler echo.HandlerFunc
+	// Auth requires the access token of a user, see AuthMiddleware
+	Auth bool
+	// RateLimit counts the request against the rate limit of the client IP
+	RateLimit bool
+	// Permission is required through one of the roles of the user, it implies Auth
+	Permission entity.Permission
+	// Elevated requires an elevated session for the most sensitive admin operations, see RequireElevation.
+	// It implies Auth.
+	Elevated bool
+	// Before are middlewares applied ahead of all others, like the token cookie of the admin console
+	Before []echo.MiddlewareFunc
+	// NoMetrics leaves the route out of the request metrics, for probes and the metrics endpoint itself
+	NoMetrics bool
+}
+
+// RouteMiddlewares are the middlewares the fields of a Route stand for.
+type RouteMiddlewares struct {
+	Auth      echo.MiddlewareFunc
+	RateLimit echo.MiddlewareFunc
+	Metrics   echo.MiddlewareFunc
+	// RBAC checks the Permission of routes
+	RBAC RBACUsecase
+	// Elevation checks the Elevated routes, nil while elevation is disabled
+	Elevation echo.MiddlewareFunc
+}
+
+// chain returns the middlewares of the route.
+func (m RouteMiddlewares) chain(route Route) []echo.MiddlewareFunc {
+	chain := append([]echo.MiddlewareFunc(nil), route.Before...)
+	if route.Auth || route.Permission != "" || route.Elevated {
+		chain = append(chain, m.Auth)
+	}
+	if route.RateLimit {
+		chain = append(chain, m.RateLimit)
+	}
+	if !route.NoMetrics {
+		chain = append(chain, m.Metrics)
+	}
+	if route.Permission != "" {
+		chain = append(chain, RequirePermission(m.RBAC, route.Permission))
+	}
+	if route.Elevated && m.Elevation != nil {
+		chain = append(chain, m.Elevation)
+	}
+	return chain
+}
+
+// RegisterRoutes adds the routes of the table to the server.
+func RegisterRoutes(e *echo.Echo, routes []Route, m RouteMiddlewares) {
+	for _, route := range routes {
+		e.Add(route.Method, route.Path, route.Handler, m.chain(route)...)
+	}
+}
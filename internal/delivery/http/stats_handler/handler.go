@@ -0,0 +1,35 @@
+package statsHandler
+
+import (
+	"context"
+	"fmt"
+	"main/domain/entity"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+type StatsHandler struct {
+	StatsUsecase StatsUsecase
+}
+
+type StatsUsecase interface {
+	//Stats returns the counts and growth rates of users, sessions, issued tokens and the denylist.
+	Stats(ctx context.Context) (entity.SystemStats, error)
+}
+
+func NewStatsHandler(statsUsecase StatsUsecase) *StatsHandler {
+	return &StatsHandler{
+		StatsUsecase: statsUsecase,
+	}
+}
+
+// Stats returns the statistics for capacity dashboards, the GetStats RPC answers the same for services.
+func (h *StatsHandler) Stats(c echo.Context) error {
+	stats, err := h.StatsUsecase.Stats(c.Request().Context())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to get stats: %v", err))
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusOK, stats)
+}
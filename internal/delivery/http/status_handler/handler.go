@@ -0,0 +1,214 @@
+package statusHandler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"main/domain/entity"
+	"main/pkg/customerrors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// StatusHandler serves the public status page, meant for the "can't log in?" screens of client apps, and the
+// admin API annotating it with incidents.
+type StatusHandler struct {
+	StatusUsecase StatusUsecase
+	cacheMaxAge   time.Duration
+}
+
+type StatusUsecase interface {
+	//Status returns the summary of the health of the service.
+	Status(ctx context.Context) entity.SystemStatus
+
+	//CreateIncident publishes an incident or an announced maintenance.
+	CreateIncident(ctx context.Context, incident entity.Incident, adminID uuid.UUID) (entity.Incident, error)
+
+	//UpdateIncident changes an unresolved incident.
+	UpdateIncident(ctx context.Context, id uuid.UUID, update entity.IncidentUpdate, adminID uuid.UUID) (entity.Incident, error)
+
+	//ResolveIncident ends an incident.
+	ResolveIncident(ctx context.Context, id, adminID uuid.UUID) (entity.Incident, error)
+
+	//ListIncidents returns a page of all incidents.
+	ListIncidents(ctx context.Context, limit, offset int) ([]entity.Incident, error)
+}
+
+// NewStatusHandler creates the handler, cacheMaxAge is the max-age of the public status, the cache TTL of the
+// status usecase.
+func NewStatusHandler(statusUsecase StatusUsecase, cacheMaxAge time.Duration) *StatusHandler {
+	return &StatusHandler{
+		StatusUsecase: statusUsecase,
+		cacheMaxAge:   cacheMaxAge,
+	}
+}
+
+// DTOs
+type StatusResponse struct {
+	Status      string                   `json:"status"`
+	Components  map[string]string        `json:"components"`
+	Maintenance entity.MaintenanceStatus `json:"maintenance"`
+	Incidents   []PublicIncident         `json:"incidents"`
+	UpdatedAt   time.Time                `json:"updated_at"`
+}
+
+// PublicIncident is an incident as shown on the status page, without the administrator who created it.
+type PublicIncident struct {
+	ID         uuid.UUID  `json:"id"`
+	Kind       string     `json:"kind"`
+	Severity   string     `json:"severity"`
+	Title      string     `json:"title"`
+	Message    string     `json:"message,omitempty"`
+	StartsAt   time.Time  `json:"starts_at"`
+	EndsAt     *time.Time `json:"ends_at,omitempty"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+	Active     bool       `json:"active"`
+}
+
+type CreateIncidentRequest struct {
+	// Kind is incident or maintenance
+	Kind string `json:"kind"`
+	// Severity is minor or major, maintenances default to minor
+	Severity string `json:"severity"`
+	Title    string `json:"title"`
+	Message  string `json:"message"`
+	// StartsAt defaults to now, a future time announces a scheduled maintenance
+	StartsAt *time.Time `json:"starts_at"`
+	EndsAt   *time.Time `json:"ends_at"`
+}
+
+// UpdateIncidentRequest changes the fields present in the body.
+type UpdateIncidentRequest struct {
+	Severity *string    `json:"severity"`
+	Title    *string    `json:"title"`
+	Message  *string    `json:"message"`
+	EndsAt   *time.Time `json:"ends_at"`
+}
+
+type ListIncidentsRequest struct {
+	Limit  int `query:"limit"`
+	Offset int `query:"offset"`
+}
+
+// Status returns the health of the service, its maintenance and the recent incidents. It is public and
+// cacheable for the cache TTL of the status.
+func (h *StatusHandler) Status(c echo.Context) error {
+	status := h.StatusUsecase.Status(c.Request().Context())
+	resp := StatusResponse{
+		Status:      status.Status,
+		Components:  status.Components,
+		Maintenance: status.Maintenance,
+		Incidents:   make([]PublicIncident, 0, len(status.Incidents)),
+		UpdatedAt:   status.UpdatedAt,
+	}
+	now := time.Now()
+	for _, incident := range status.Incidents {
+		resp.Incidents = append(resp.Incidents, PublicIncident{
+			ID:         incident.ID,
+			Kind:       string(incident.Kind),
+			Severity:   string(incident.Severity),
+			Title:      incident.Title,
+			Message:    incident.Message,
+			StartsAt:   incident.StartsAt,
+			EndsAt:     incident.EndsAt,
+			ResolvedAt: incident.ResolvedAt,
+			Active:     incident.Active(now),
+		})
+	}
+	seconds := strconv.Itoa(int(h.cacheMaxAge.Seconds()))
+	c.Response().Header().Set("Cache-Control", "public, max-age="+seconds)
+	return c.JSON(http.StatusOK, resp)
+}
+
+// CreateIncident publishes an incident on the status page.
+func (h *StatusHandler) CreateIncident(c echo.Context) error {
+	adminID, _ := c.Get("userID").(uuid.UUID)
+	var req CreateIncidentRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	incident := entity.Incident{
+		Kind:     entity.IncidentKind(req.Kind),
+		Severity: entity.IncidentSeverity(req.Severity),
+		Title:    req.Title,
+		Message:  req.Message,
+		EndsAt:   req.EndsAt,
+	}
+	if req.StartsAt != nil {
+		incident.StartsAt = req.StartsAt.UTC()
+	}
+	incident, err := h.StatusUsecase.CreateIncident(c.Request().Context(), incident, adminID)
+	if err != nil {
+		return statusError(err, "failed to create incident")
+	}
+	return c.JSON(http.StatusCreated, incident)
+}
+
+// ListIncidents returns a page of all incidents, the latest start first.
+func (h *StatusHandler) ListIncidents(c echo.Context) error {
+	var req ListIncidentsRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	incidents, err := h.StatusUsecase.ListIncidents(c.Request().Context(), req.Limit, req.Offset)
+	if err != nil {
+		return statusError(err, "failed to list incidents")
+	}
+	if incidents == nil {
+		incidents = []entity.Incident{}
+	}
+	return c.JSON(http.StatusOK, incidents)
+}
+
+// UpdateIncident changes the incident in the path, for example to post progress in its message.
+func (h *StatusHandler) UpdateIncident(c echo.Context) error {
+	adminID, _ := c.Get("userID").(uuid.UUID)
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid incident ID")
+	}
+	var req UpdateIncidentRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	update := entity.IncidentUpdate{Title: req.Title, Message: req.Message, EndsAt: req.EndsAt}
+	if req.Severity != nil {
+		severity := entity.IncidentSeverity(*req.Severity)
+		update.Severity = &severity
+	}
+	incident, err := h.StatusUsecase.UpdateIncident(c.Request().Context(), id, update, adminID)
+	if err != nil {
+		return statusError(err, "failed to update incident")
+	}
+	return c.JSON(http.StatusOK, incident)
+}
+
+// ResolveIncident resolves the incident in the path.
+func (h *StatusHandler) ResolveIncident(c echo.Context) error {
+	adminID, _ := c.Get("userID").(uuid.UUID)
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid incident ID")
+	}
+	incident, err := h.StatusUsecase.ResolveIncident(c.Request().Context(), id, adminID)
+	if err != nil {
+		return statusError(err, "failed to resolve incident")
+	}
+	return c.JSON(http.StatusOK, incident)
+}
+
+func statusError(err error, msg string) error {
+	switch {
+	case errors.Is(err, customerrors.ErrInvalidIncident):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, customerrors.ErrIncidentNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, customerrors.ErrIncidentResolved):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("%s: %v", msg, err))
+}
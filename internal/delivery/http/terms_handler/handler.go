@@ -0,0 +1,146 @@
+package termsHandler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"main/domain/entity"
+	"main/pkg/customerrors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+type TermsHandler struct {
+	TermsUsecase TermsUsecase
+}
+
+type TermsUsecase interface {
+	//Publish publishes a new version of the terms of service or the privacy policy.
+	Publish(ctx context.Context, kind entity.TermsKind, version, url string, requiresAcceptance bool) (entity.TermsDocument, error)
+
+	//ListDocuments returns every published version.
+	ListDocuments(ctx context.Context) ([]entity.TermsDocument, error)
+
+	//CurrentDocuments returns the versions in force.
+	CurrentDocuments(ctx context.Context) ([]entity.TermsDocument, error)
+
+	//Pending returns the current versions the user has not accepted yet.
+	Pending(ctx context.Context, userID uuid.UUID) ([]entity.TermsDocument, error)
+
+	//Accept records that the user accepted the documents.
+	Accept(ctx context.Context, userID uuid.UUID, documentIDs []uuid.UUID) error
+
+	//Acceptances returns the documents the user accepted and when.
+	Acceptances(ctx context.Context, userID uuid.UUID) ([]entity.TermsAcceptance, error)
+}
+
+func NewTermsHandler(termsUsecase TermsUsecase) *TermsHandler {
+	return &TermsHandler{
+		TermsUsecase: termsUsecase,
+	}
+}
+
+// DTOs
+type PublishRequest struct {
+	// Kind is terms or privacy
+	Kind    string `json:"kind"`
+	Version string `json:"version"`
+	URL     string `json:"url"`
+	// RequiresAcceptance defaults to true, false publishes an editorial change earlier acceptances still cover
+	RequiresAcceptance *bool `json:"requires_acceptance"`
+}
+
+type AcceptRequest struct {
+	DocumentIDs []uuid.UUID `json:"document_ids"`
+}
+
+type MyTermsResponse struct {
+	Accepted []entity.TermsAcceptance `json:"accepted"`
+	// Pending are the current versions the user still has to accept
+	Pending []entity.TermsDocument `json:"pending"`
+}
+
+// Current returns the current version of every document, the ones to accept at registration.
+func (h *TermsHandler) Current(c echo.Context) error {
+	docs, err := h.TermsUsecase.CurrentDocuments(c.Request().Context())
+	if err != nil {
+		return termsError(err, "failed to get terms")
+	}
+	if docs == nil {
+		docs = []entity.TermsDocument{}
+	}
+	return c.JSON(http.StatusOK, docs)
+}
+
+// MyTerms returns the documents the user accepted and the ones still pending.
+func (h *TermsHandler) MyTerms(c echo.Context) error {
+	userID, _ := c.Get("userID").(uuid.UUID)
+	ctx := c.Request().Context()
+	accepted, err := h.TermsUsecase.Acceptances(ctx, userID)
+	if err != nil {
+		return termsError(err, "failed to get accepted terms")
+	}
+	pending, err := h.TermsUsecase.Pending(ctx, userID)
+	if err != nil {
+		return termsError(err, "failed to get pending terms")
+	}
+	resp := MyTermsResponse{Accepted: accepted, Pending: pending}
+	if resp.Accepted == nil {
+		resp.Accepted = []entity.TermsAcceptance{}
+	}
+	if resp.Pending == nil {
+		resp.Pending = []entity.TermsDocument{}
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// Accept records that the user accepted the documents in the body.
+func (h *TermsHandler) Accept(c echo.Context) error {
+	userID, _ := c.Get("userID").(uuid.UUID)
+	var req AcceptRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	if err := h.TermsUsecase.Accept(c.Request().Context(), userID, req.DocumentIDs); err != nil {
+		return termsError(err, "failed to accept terms")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// Publish publishes a new document version, it is in force right away.
+func (h *TermsHandler) Publish(c echo.Context) error {
+	var req PublishRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	requiresAcceptance := req.RequiresAcceptance == nil || *req.RequiresAcceptance
+	doc, err := h.TermsUsecase.Publish(c.Request().Context(), entity.TermsKind(req.Kind), req.Version, req.URL, requiresAcceptance)
+	if err != nil {
+		return termsError(err, "failed to publish terms")
+	}
+	return c.JSON(http.StatusCreated, doc)
+}
+
+// ListDocuments returns every published version.
+func (h *TermsHandler) ListDocuments(c echo.Context) error {
+	docs, err := h.TermsUsecase.ListDocuments(c.Request().Context())
+	if err != nil {
+		return termsError(err, "failed to list terms")
+	}
+	if docs == nil {
+		docs = []entity.TermsDocument{}
+	}
+	return c.JSON(http.StatusOK, docs)
+}
+
+func termsError(err error, msg string) error {
+	switch {
+	case errors.Is(err, customerrors.ErrInvalidTermsDocument):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, customerrors.ErrTermsVersionExists):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("%s: %v", msg, err))
+}
@@ -0,0 +1,78 @@
+package verificationHandler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"main/internal/delivery/http/linkpage"
+	"main/pkg/customerrors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+type VerificationHandler struct {
+	VerificationUsecase VerificationUsecase
+}
+
+type VerificationUsecase interface {
+	//VerifyEmail consumes the verification token and returns the verified user ID.
+	VerifyEmail(ctx context.Context, token string) (userID uuid.UUID, err error)
+
+	//ResendVerification sends a new verification link if the account exists and is unverified.
+	ResendVerification(ctx context.Context, email string)
+}
+
+func NewVerificationHandler(verificationUsecase VerificationUsecase) *VerificationHandler {
+	return &VerificationHandler{
+		VerificationUsecase: verificationUsecase,
+	}
+}
+
+// DTOs
+type VerifyEmailRequest struct {
+	Token string `json:"token" form:"token"`
+}
+
+type ResendVerificationRequest struct {
+	Email string `json:"email"`
+}
+
+// VerifyEmailPage serves the link from the email (GET ?token=), a page whose button posts the token to VerifyEmail.
+// Opening the link does not use up the token.
+func (h *VerificationHandler) VerifyEmailPage(c echo.Context) error {
+	return linkpage.Confirm(c, "Verify your email address", "Verify")
+}
+
+// VerifyEmail confirms the email address with the token posted by API clients or by the page of the link.
+func (h *VerificationHandler) VerifyEmail(c echo.Context) error {
+	var req VerifyEmailRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	userID, err := h.VerificationUsecase.VerifyEmail(c.Request().Context(), req.Token)
+	if err != nil {
+		if errors.Is(err, customerrors.ErrInvalidToken) {
+			if linkpage.Posted(c) {
+				return linkpage.Result(c, http.StatusBadRequest, "This link is invalid or has expired.")
+			}
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to verify email: %v", err))
+	}
+	if linkpage.Posted(c) {
+		return linkpage.Result(c, http.StatusOK, "Your email address is verified.")
+	}
+	return c.JSON(200, map[string]string{"user_id": userID.String(), "status": "verified"})
+}
+
+// ResendVerification always answers 202 so it cannot be used to probe which emails are registered.
+func (h *VerificationHandler) ResendVerification(c echo.Context) error {
+	var req ResendVerificationRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+	}
+	h.VerificationUsecase.ResendVerification(c.Request().Context(), req.Email)
+	return c.NoContent(http.StatusAccepted)
+}
@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+)
+
+// LabelMode is how the values of a high-cardinality label reach Prometheus.
+type LabelMode string
+
+const (
+	// LabelKeep exports the values as they are, up to MaxValues distinct ones
+	LabelKeep LabelMode = "keep"
+	// LabelHash exports one of Buckets stable buckets per value instead of the value
+	LabelHash LabelMode = "hash"
+	// LabelDrop exports an empty value, which Prometheus treats like a missing label
+	LabelDrop LabelMode = "drop"
+)
+
+// otherLabelValue replaces the values beyond MaxValues.
+const otherLabelValue = "other"
+
+// LabelLimit bounds the values of a label, the zero limit keeps every value.
+type LabelLimit struct {
+	Mode LabelMode
+	// MaxValues caps the distinct values LabelKeep exports, later values are exported as "other". Zero is unlimited.
+	MaxValues int
+	// Buckets is the number of buckets of LabelHash
+	Buckets int
+}
+
+// Valid reports whether the limit can be applied.
+func (l LabelLimit) Valid() bool {
+	switch l.Mode {
+	case "", LabelKeep, LabelDrop:
+		return l.MaxValues >= 0
+	case LabelHash:
+		return l.Buckets > 0
+	}
+	return false
+}
+
+// labelGuard applies a limit to the values of one label. The values seen first keep their own series,
+// which are the busiest ones in practice.
+type labelGuard struct {
+	limit LabelLimit
+	mu    sync.Mutex
+	seen  map[string]struct{}
+}
+
+func newLabelGuard(limit LabelLimit) *labelGuard {
+	return &labelGuard{limit: limit, seen: make(map[string]struct{})}
+}
+
+// value returns the value to export for v.
+func (g *labelGuard) value(v string) string {
+	switch g.limit.Mode {
+	case LabelDrop:
+		return ""
+	case LabelHash:
+		h := fnv.New32a()
+		h.Write([]byte(v))
+		return fmt.Sprintf("bucket-%d", h.Sum32()%uint32(g.limit.Buckets))
+	}
+	if g.limit.MaxValues <= 0 {
+		return v
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.seen[v]; ok {
+		return v
+	}
+	if len(g.seen) >= g.limit.MaxValues {
+		return otherLabelValue
+	}
+	g.seen[v] = struct{}{}
+	return v
+}
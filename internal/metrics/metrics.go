@@ -1,6 +1,7 @@
 package metrics
 
 import (
+	"strconv"
 	"time"
 
 	"github.com/jackc/pgx/v5"
@@ -8,66 +9,291 @@ import (
 )
 
 type Metrics struct {
-	//Request duration histogram with method, endpoint, and status labels
+	//Request duration histogram with method, endpoint, status and tenant labels
 	RequestDuration *prometheus.HistogramVec
 	//Login attempts counter
 	LoginAttempts *prometheus.CounterVec
 	//Total errors counter with error type label
 	TotalErrors *prometheus.CounterVec
+	//Registrations rejected by the registration policy, with reason label
+	RegistrationRejections *prometheus.CounterVec
+	//Sessions refreshed from another IP address, with client type and action labels
+	SessionIPChanges *prometheus.CounterVec
+	//Sessions refreshed from another user agent or network than their login, with client type and mode labels
+	SessionBindingMismatches *prometheus.CounterVec
+	//Decoy refresh tokens presented, with action label
+	RefreshCanaryHits *prometheus.CounterVec
+	LoginRisk         *prometheus.CounterVec
+	//App attestations checked at login and refresh, with client type and outcome labels
+	AppAttestations *prometheus.CounterVec
+	//CAPTCHA tokens checked at registration and login, with action and outcome labels
+	CaptchaVerifications *prometheus.CounterVec
+	//Access tokens verified by the shadow candidate, with outcome label
+	TokenShadowVerifications *prometheus.CounterVec
+	//Security alerts raised on anomalous auth events, with type label
+	SecurityAlerts *prometheus.CounterVec
+	//Emails handed to the SMTP relays, with provider and outcome labels
+	EmailDeliveries *prometheus.CounterVec
 	//Database query duration histogram with query type and status labels
 	DbQueryDuration *prometheus.HistogramVec
 	//CPU temperature gauge with core label
 	CpuTemp *prometheus.GaugeVec
+	//Age of keys and certificates with secret label
+	SecretAge *prometheus.GaugeVec
+	//Rows deleted by the cleanup job, with table label
+	CleanupDeletedRows *prometheus.CounterVec
+	//Duration of the runs of the cleanup job, with outcome label
+	CleanupDuration *prometheus.HistogramVec
+
+	slo *sloMetrics
+	// endpoints and tenants bound the values of the endpoint and tenant labels
+	endpoints *labelGuard
+	tenants   *labelGuard
+}
+
+// defaultDBBuckets are the database query duration buckets used when none are configured.
+var defaultDBBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// Options customizes metric names and buckets, so several deployments can share dashboards.
+type Options struct {
+	// Namespace and Subsystem prefix every metric name (namespace_subsystem_name)
+	Namespace string
+	Subsystem string
+	// RequestBuckets are the HTTP request duration buckets, prometheus.DefBuckets when empty
+	RequestBuckets []float64
+	// DBBuckets are the database query duration buckets
+	DBBuckets []float64
+	// ConstLabels are added to every metric, e.g. service and instance
+	ConstLabels prometheus.Labels
+	// SLO are the per-endpoint targets, endpoints with a zero objective are not counted
+	SLO SLOTargets
+	// EndpointLabel and TenantLabel limit the values of the endpoint labels and of the tenant label of requests
+	EndpointLabel LabelLimit
+	TenantLabel   LabelLimit
 }
 
-func NewMetrics(reg prometheus.Registerer) *Metrics {
+func NewMetrics(reg prometheus.Registerer, opts Options) *Metrics {
+	if len(opts.RequestBuckets) == 0 {
+		opts.RequestBuckets = prometheus.DefBuckets
+	}
+	if len(opts.DBBuckets) == 0 {
+		opts.DBBuckets = defaultDBBuckets
+	}
+	if len(opts.ConstLabels) > 0 {
+		reg = prometheus.WrapRegistererWith(opts.ConstLabels, reg)
+	}
+
 	m := &Metrics{
-		//Request duration histogram with method, endpoint, and status labels
+		endpoints: newLabelGuard(opts.EndpointLabel),
+		tenants:   newLabelGuard(opts.TenantLabel),
+		//Request duration histogram with method, endpoint, status and tenant labels
 		RequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
-			Name: "request_duration_seconds",
-			Help: "Duration of HTTP requests in seconds."},
-			[]string{"method", "endpoint", "status"},
+			Namespace: opts.Namespace,
+			Subsystem: opts.Subsystem,
+			Name:      "request_duration_seconds",
+			Help:      "Duration of HTTP requests in seconds.",
+			Buckets:   opts.RequestBuckets,
+		},
+			[]string{"method", "endpoint", "status", "tenant"},
 		),
 		//Login attempts counter
 		LoginAttempts: prometheus.NewCounterVec(prometheus.CounterOpts{
-			Name: "login_attempts_total",
-			Help: "Total number of login attempts.",
+			Namespace: opts.Namespace,
+			Subsystem: opts.Subsystem,
+			Name:      "login_attempts_total",
+			Help:      "Total number of login attempts.",
 		},
 			[]string{"status"},
 		),
 		//Total errors counter with error type label
 		TotalErrors: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
-				Name: "total_errors_total",
-				Help: "Number of total errors.",
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "total_errors_total",
+				Help:      "Number of total errors.",
 			},
 			[]string{"error_type"},
 		),
+		//Registrations rejected by the registration policy, with reason label
+		RegistrationRejections: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "registration_rejections_total",
+				Help:      "Registrations rejected by the registration policy (closed, domain_not_allowed, disposable_email, invite_missing).",
+			},
+			[]string{"reason"},
+		),
+		//Sessions refreshed from another IP address, with client type and action labels
+		SessionIPChanges: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "session_ip_changes_total",
+				Help:      "Sessions refreshed from another IP address, by the action of the IP change policy (ignore, log, reauth).",
+			},
+			[]string{"client_type", "action"},
+		),
+		//Sessions refreshed from another user agent or network than their login, with client type and mode labels
+		SessionBindingMismatches: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "session_binding_mismatches_total",
+				Help:      "Sessions refreshed from another user agent or IP network than at login, by binding mode (warn, enforce).",
+			},
+			[]string{"client_type", "mode"},
+		),
+		//Decoy refresh tokens presented, with action label
+		RefreshCanaryHits: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "refresh_canary_hits_total",
+				Help:      "Decoy refresh tokens presented to the refresh endpoint, by incident response action (log, revoke_user).",
+			},
+			[]string{"action"},
+		),
+		//Risk assessments of logins, with level label
+		LoginRisk: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "login_risk_total",
+				Help:      "Logins compared with the previous sessions of the user, by risk level (low, medium, high).",
+			},
+			[]string{"level"},
+		),
+		//App attestations checked at login and refresh, with client type and outcome labels
+		AppAttestations: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "app_attestations_total",
+				Help:      "App attestations checked at login and refresh, by client type and outcome (verified, missing, invalid).",
+			},
+			[]string{"client_type", "outcome"},
+		),
+		//CAPTCHA tokens checked at registration and login, with action and outcome labels
+		CaptchaVerifications: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "captcha_verifications_total",
+				Help:      "CAPTCHA tokens checked at registration and login, by action and outcome (verified, missing, rejected, error).",
+			},
+			[]string{"action", "outcome"},
+		),
+		//Access tokens verified by the shadow candidate, with outcome label
+		TokenShadowVerifications: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "token_shadow_verifications_total",
+				Help:      "Access tokens verified by the current and the shadow candidate configuration, by outcome (match, claims_mismatch, candidate_rejected, candidate_accepted).",
+			},
+			[]string{"outcome"},
+		),
+		//Security alerts raised on anomalous auth events, with type label
+		SecurityAlerts: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "security_alerts_total",
+				Help:      "Security alerts raised on anomalous auth events, by type (refresh_token_reuse, repeated_lockouts, impossible_travel).",
+			},
+			[]string{"type"},
+		),
+		//Emails handed to the SMTP relays, with provider and outcome labels
+		EmailDeliveries: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "email_deliveries_total",
+				Help:      "Emails handed to the SMTP relays, by provider (primary, fallback) and outcome (sent, failed). Emails no relay accepted or the full queue rejected are counted as dropped without a provider.",
+			},
+			[]string{"provider", "outcome"},
+		),
 		//Database query duration histogram with query type and status labels
 		DbQueryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
-			Name:    "db_query_duration_seconds",
-			Help:    "Duration of database queries in seconds.",
-			Buckets: []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5},
+			Namespace: opts.Namespace,
+			Subsystem: opts.Subsystem,
+			Name:      "db_query_duration_seconds",
+			Help:      "Duration of database queries in seconds.",
+			Buckets:   opts.DBBuckets,
 		},
 			[]string{"query_type", "status"},
 		),
 		//CPU temperature gauge with core label
 		CpuTemp: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "cpu_temperature_celsius",
-			Help: "Current temperature of the CPU.",
+			Namespace: opts.Namespace,
+			Subsystem: opts.Subsystem,
+			Name:      "cpu_temperature_celsius",
+			Help:      "Current temperature of the CPU.",
 		},
 			[]string{"core"},
 		),
+		//Age of keys and certificates with secret label
+		SecretAge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: opts.Namespace,
+			Subsystem: opts.Subsystem,
+			Name:      "secret_age_seconds",
+			Help:      "Time since the secret was issued or last rotated.",
+		},
+			[]string{"secret"},
+		),
+		//Rows deleted by the cleanup job, with table label
+		CleanupDeletedRows: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "cleanup_deleted_rows_total",
+				Help:      "Rows deleted by the cleanup job, by table (sessions, login_events). Dry runs delete nothing.",
+			},
+			[]string{"table"},
+		),
+		//Duration of the runs of the cleanup job, with outcome label
+		CleanupDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: opts.Namespace,
+			Subsystem: opts.Subsystem,
+			Name:      "cleanup_duration_seconds",
+			Help:      "Duration of the runs of the cleanup job in seconds, by outcome (ok, error).",
+			Buckets:   []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 900},
+		},
+			[]string{"outcome"},
+		),
 	}
 	// Register metrics with the provided registry
 	reg.MustRegister(m.RequestDuration)
 	reg.MustRegister(m.LoginAttempts)
 	reg.MustRegister(m.TotalErrors)
+	reg.MustRegister(m.RegistrationRejections)
+	reg.MustRegister(m.SessionIPChanges)
+	reg.MustRegister(m.SessionBindingMismatches)
+	reg.MustRegister(m.RefreshCanaryHits)
+	reg.MustRegister(m.LoginRisk)
+	reg.MustRegister(m.AppAttestations)
+	reg.MustRegister(m.CaptchaVerifications)
+	reg.MustRegister(m.TokenShadowVerifications)
+	reg.MustRegister(m.SecurityAlerts)
+	reg.MustRegister(m.EmailDeliveries)
 	reg.MustRegister(m.DbQueryDuration)
 	reg.MustRegister(m.CpuTemp)
+	reg.MustRegister(m.SecretAge)
+	reg.MustRegister(m.CleanupDeletedRows)
+	reg.MustRegister(m.CleanupDuration)
+	m.slo = newSLOMetrics(reg, opts)
 	return m
 }
 
+// ObserveRequest records the duration of an HTTP request to the route (the registered pattern) on the tenant
+// domain, the endpoint and tenant labels are limited as configured.
+func (m *Metrics) ObserveRequest(method, route string, status int, tenant string, duration time.Duration) {
+	m.RequestDuration.WithLabelValues(method, m.endpoints.value(route), strconv.Itoa(status), m.tenants.value(tenant)).
+		Observe(duration.Seconds())
+}
+
 // ObserveDB is a helper method to record the duration and status of database queries in a consistent way.
 func (m *Metrics) ObserveDB(queryName string, start time.Time, err error) {
 	duration := time.Since(start).Seconds()
@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SLOTarget is the objective of one route: Objective of the requests (e.g. 0.999) must succeed
+// within Latency. A request is good when it did not fail with a 5xx status and was not slower than Latency.
+type SLOTarget struct {
+	Latency   time.Duration
+	Objective float64
+}
+
+// SLOTargets resolves the target of a route, routes are keyed by "METHOD /path" with the route pattern
+// as registered (e.g. "POST /login"). Routes without an entry use Default.
+type SLOTargets struct {
+	Default SLOTarget
+	ByRoute map[string]SLOTarget
+}
+
+// For returns the target of the route, falling back to the default one.
+func (t SLOTargets) For(method, route string) SLOTarget {
+	if target, ok := t.ByRoute[method+" "+route]; ok {
+		return target
+	}
+	return t.Default
+}
+
+// sloMetrics are the counters burn-rate alerts are built from:
+//
+//	error ratio = 1 - rate(slo_good_requests_total[w]) / rate(slo_requests_total[w])
+//	burn rate   = error ratio / (1 - slo_objective_ratio)
+//
+// The objective and latency target are exported as gauges, so alert rules need no per-route constants.
+type sloMetrics struct {
+	targets       SLOTargets
+	total         *prometheus.CounterVec
+	good          *prometheus.CounterVec
+	objective     *prometheus.GaugeVec
+	latencyTarget *prometheus.GaugeVec
+}
+
+func newSLOMetrics(reg prometheus.Registerer, opts Options) *sloMetrics {
+	labels := []string{"method", "endpoint"}
+	s := &sloMetrics{
+		targets: opts.SLO,
+		total: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: opts.Namespace,
+			Subsystem: opts.Subsystem,
+			Name:      "slo_requests_total",
+			Help:      "Requests counted against the SLO of the endpoint.",
+		}, labels),
+		good: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: opts.Namespace,
+			Subsystem: opts.Subsystem,
+			Name:      "slo_good_requests_total",
+			Help:      "Requests that met the SLO of the endpoint (no server error, within the latency target).",
+		}, labels),
+		objective: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: opts.Namespace,
+			Subsystem: opts.Subsystem,
+			Name:      "slo_objective_ratio",
+			Help:      "Share of requests of the endpoint that must be good.",
+		}, labels),
+		latencyTarget: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: opts.Namespace,
+			Subsystem: opts.Subsystem,
+			Name:      "slo_latency_target_seconds",
+			Help:      "Latency a good request of the endpoint must not exceed.",
+		}, labels),
+	}
+	reg.MustRegister(s.total, s.good, s.objective, s.latencyTarget)
+	return s
+}
+
+// ObserveSLO counts a finished request against the SLO of its route. The target is looked up with the route
+// itself, the endpoint label is limited like the one of the request duration.
+func (m *Metrics) ObserveSLO(method, route string, status int, duration time.Duration) {
+	target := m.slo.targets.For(method, route)
+	if target.Objective <= 0 {
+		return
+	}
+	endpoint := m.endpoints.value(route)
+	m.slo.objective.WithLabelValues(method, endpoint).Set(target.Objective)
+	m.slo.latencyTarget.WithLabelValues(method, endpoint).Set(target.Latency.Seconds())
+
+	m.slo.total.WithLabelValues(method, endpoint).Inc()
+	if status < 500 && (target.Latency <= 0 || duration <= target.Latency) {
+		m.slo.good.WithLabelValues(method, endpoint).Inc()
+	}
+}
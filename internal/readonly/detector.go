@@ -0,0 +1,144 @@
+package readonly
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Reasons set by the Detector.
+const (
+	ReasonDatabaseReadOnly = "database_read_only"
+	ReasonWriteCircuitOpen = "write_circuit_open"
+)
+
+// readOnlySQLState is returned by Postgres for writes on a read-only server or transaction (read_only_sql_transaction).
+const readOnlySQLState = "25006"
+
+type sqlKey struct{}
+
+// Detector turns read-only mode on when the database stops accepting writes. It is a pgx query tracer,
+// so it sees the result of every statement:
+//   - a write rejected as read-only (failover to a replica) enables ReasonDatabaseReadOnly until Run sees
+//     the database writable again;
+//   - threshold consecutive failed writes open the write circuit (ReasonWriteCircuitOpen) for cooldown,
+//     after which writes are let through again and the next failure reopens it.
+type Detector struct {
+	sw        *Switch
+	logger    *slog.Logger
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+func NewDetector(sw *Switch, logger *slog.Logger, threshold int, cooldown time.Duration) *Detector {
+	return &Detector{
+		sw:        sw,
+		logger:    logger,
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// TraceQueryStart implements pgx.QueryTracer.
+func (d *Detector) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, sqlKey{}, data.SQL)
+}
+
+// TraceQueryEnd implements pgx.QueryTracer.
+func (d *Detector) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	sql, _ := ctx.Value(sqlKey{}).(string)
+	if !isWrite(sql) {
+		return
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(data.Err, &pgErr) && pgErr.Code == readOnlySQLState {
+		if !d.sw.Enabled() {
+			d.logger.Warn("Database rejected a write as read-only, entering read-only mode")
+		}
+		d.sw.Enable(ReasonDatabaseReadOnly)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// constraint violations and other statement errors are answers of a healthy database
+	if data.Err == nil || (errors.As(data.Err, &pgErr) && pgErr.Code != readOnlySQLState) || errors.Is(data.Err, context.Canceled) {
+		d.failures = 0
+		return
+	}
+	d.failures++
+	if d.failures >= d.threshold && d.openUntil.IsZero() {
+		d.openUntil = time.Now().Add(d.cooldown)
+		d.sw.Enable(ReasonWriteCircuitOpen)
+		d.logger.Warn("Write circuit opened", "failures", d.failures, "cooldown", d.cooldown, "error", data.Err)
+	}
+}
+
+// Run probes the database every interval, clearing ReasonDatabaseReadOnly once it accepts writes again,
+// and half-opens the write circuit when its cooldown is over. It returns when the context is cancelled.
+func (d *Detector) Run(ctx context.Context, pool *pgxpool.Pool, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.probe(ctx, pool)
+			d.halfOpen()
+		}
+	}
+}
+
+// probe asks the server whether it is a standby or defaults to read-only transactions.
+func (d *Detector) probe(ctx context.Context, pool *pgxpool.Pool) {
+	var readOnly bool
+	err := pool.QueryRow(ctx,
+		`SELECT pg_is_in_recovery() OR current_setting('default_transaction_read_only') = 'on'`).Scan(&readOnly)
+	if err != nil {
+		// unreachable database, the write circuit covers it
+		return
+	}
+	if readOnly {
+		d.sw.Enable(ReasonDatabaseReadOnly)
+		return
+	}
+	d.sw.Disable(ReasonDatabaseReadOnly)
+}
+
+// halfOpen lets writes through again once the cooldown of an open circuit is over.
+func (d *Detector) halfOpen() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.openUntil.IsZero() || time.Now().Before(d.openUntil) {
+		return
+	}
+	d.openUntil = time.Time{}
+	// one more failure reopens the circuit immediately
+	d.failures = d.threshold - 1
+	d.sw.Disable(ReasonWriteCircuitOpen)
+	d.logger.Info("Write circuit half-open, letting writes through")
+}
+
+// isWrite reports whether the statement modifies data.
+func isWrite(sql string) bool {
+	sql = strings.TrimSpace(sql)
+	for _, prefix := range []string{"INSERT", "UPDATE", "DELETE"} {
+		if len(sql) >= len(prefix) && strings.EqualFold(sql[:len(prefix)], prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+// Package readonly holds the read-only degradation switch. While it is on, requests that only
+// verify tokens keep working and mutations are answered with 503.
+package readonly
+
+import (
+	"slices"
+	"sync"
+)
+
+// Switch is turned on by independent sources (schema check, database failover...), each with its own reason.
+// It stays on until every source has cleared its reason.
+type Switch struct {
+	mu      sync.RWMutex
+	reasons map[string]struct{}
+}
+
+func NewSwitch() *Switch {
+	return &Switch{reasons: make(map[string]struct{})}
+}
+
+// Enable turns read-only mode on for the given reason.
+func (s *Switch) Enable(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reasons[reason] = struct{}{}
+}
+
+// Disable clears the reason, read-only mode ends when no reason is left.
+func (s *Switch) Disable(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.reasons, reason)
+}
+
+// Enabled reports whether read-only mode is on.
+func (s *Switch) Enabled() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.reasons) > 0
+}
+
+// Reasons returns the sorted reasons read-only mode is on for.
+func (s *Switch) Reasons() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	reasons := make([]string, 0, len(s.reasons))
+	for reason := range s.reasons {
+		reasons = append(reasons, reason)
+	}
+	slices.Sort(reasons)
+	return reasons
+}
@@ -0,0 +1,116 @@
+package secretage
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"main/internal/metrics"
+)
+
+// Secret is a key or certificate whose age is tracked. Dates is called on every check,
+// so certificates replaced on disk are picked up without a restart.
+type Secret struct {
+	Name string
+	// Dates returns when the secret was issued and when it expires, a zero expiry means it does not expire
+	Dates func() (issuedAt, expiresAt time.Time, err error)
+}
+
+// Static is a secret with a known issue date and no expiry, e.g. a key declared as rotated at issuedAt.
+func Static(name string, issuedAt time.Time) Secret {
+	return Secret{
+		Name: name,
+		Dates: func() (time.Time, time.Time, error) {
+			return issuedAt, time.Time{}, nil
+		},
+	}
+}
+
+// CertificateFile is the leaf certificate of a PEM file, dated by its validity period.
+func CertificateFile(name, path string) Secret {
+	return Secret{
+		Name: name,
+		Dates: func() (time.Time, time.Time, error) {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return time.Time{}, time.Time{}, err
+			}
+			block, _ := pem.Decode(data)
+			if block == nil || block.Type != "CERTIFICATE" {
+				return time.Time{}, time.Time{}, errors.New("no PEM certificate in " + path)
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return time.Time{}, time.Time{}, fmt.Errorf("parse %s: %w", path, err)
+			}
+			return cert.NotBefore, cert.NotAfter, nil
+		},
+	}
+}
+
+// Monitor exports the age of secrets as the secret_age_seconds gauge and warns operators before a secret
+// exceeds the rotation policy: from warnBefore ahead of maxAge or of its expiry it logs a warning on every
+// check, past them an error. A zero maxAge only checks expiries.
+type Monitor struct {
+	secrets    []Secret
+	maxAge     time.Duration
+	warnBefore time.Duration
+	metrics    *metrics.Metrics
+	logger     *slog.Logger
+}
+
+func NewMonitor(m *metrics.Metrics, logger *slog.Logger, maxAge, warnBefore time.Duration, secrets ...Secret) *Monitor {
+	return &Monitor{
+		secrets:    secrets,
+		maxAge:     maxAge,
+		warnBefore: warnBefore,
+		metrics:    m,
+		logger:     logger,
+	}
+}
+
+// Run checks the secrets right away and then every interval until the context is cancelled.
+func (m *Monitor) Run(ctx context.Context, interval time.Duration) {
+	m.Check(time.Now())
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			m.Check(now)
+		}
+	}
+}
+
+// Check updates the gauge and logs the secrets that are due or overdue for rotation at now.
+func (m *Monitor) Check(now time.Time) {
+	for _, s := range m.secrets {
+		issuedAt, expiresAt, err := s.Dates()
+		if err != nil {
+			m.logger.Error("Failed to read secret dates", "secret", s.Name, "error", err)
+			continue
+		}
+		age := now.Sub(issuedAt)
+		m.metrics.SecretAge.WithLabelValues(s.Name).Set(age.Seconds())
+
+		switch {
+		case !expiresAt.IsZero() && !now.Before(expiresAt):
+			m.logger.Error("Secret has expired", "secret", s.Name, "expired_at", expiresAt)
+		case m.maxAge > 0 && age >= m.maxAge:
+			m.logger.Error("Secret exceeds the rotation policy", "secret", s.Name, "age", age.Round(time.Hour), "max_age", m.maxAge)
+		case !expiresAt.IsZero() && expiresAt.Sub(now) <= m.warnBefore:
+			m.logger.Warn("Secret expires soon, rotate it", "secret", s.Name, "expires_at", expiresAt)
+		case m.maxAge > 0 && m.maxAge-age <= m.warnBefore:
+			m.logger.Warn("Secret is due for rotation", "secret", s.Name, "age", age.Round(time.Hour), "max_age", m.maxAge)
+		}
+	}
+}
@@ -0,0 +1,483 @@
+package account
+
+import (
+	"context"
+	"main/domain/entity"
+	metrics "main/internal/metrics"
+	"main/pkg/customerrors"
+	"main/pkg/fieldcrypt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type AccountRepo struct {
+	pool    *pgxpool.Pool
+	Metrics *metrics.Metrics
+	// crypt encrypts emails, IP addresses and user agents, nil stores them in plaintext
+	crypt *fieldcrypt.Cipher
+}
+
+func NewAccountRepo(pool *pgxpool.Pool, metrics *metrics.Metrics, crypt *fieldcrypt.Cipher) *AccountRepo {
+	return &AccountRepo{
+		pool:    pool,
+		Metrics: metrics,
+		crypt:   crypt,
+	}
+}
+
+// ScheduleDeletion marks the account for deletion at the given time and deletes all its sessions in one transaction.
+// Returns customerrors.ErrNoTagsAffected if the deletion is already scheduled.
+func (r *AccountRepo) ScheduleDeletion(ctx context.Context, userID uuid.UUID, deleteAt time.Time) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("schedule_account_deletion", start, err)
+	}(time.Now())
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `UPDATE users SET deletion_scheduled_at = $1 WHERE id = $2 AND deletion_scheduled_at IS NULL AND deleted_at IS NULL`,
+		deleteAt, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() != 1 {
+		err = customerrors.ErrNoTagsAffected
+		return err
+	}
+	if _, err = tx.Exec(ctx, `DELETE FROM sessions WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	return err
+}
+
+// CancelDeletion clears a scheduled deletion. Returns customerrors.ErrNoTagsAffected if none is scheduled.
+func (r *AccountRepo) CancelDeletion(ctx context.Context, userID uuid.UUID) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("cancel_account_deletion", start, err)
+	}(time.Now())
+
+	tag, err := r.pool.Exec(ctx, `UPDATE users SET deletion_scheduled_at = NULL WHERE id = $1 AND deletion_scheduled_at IS NOT NULL`,
+		userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() != 1 {
+		err = customerrors.ErrNoTagsAffected
+	}
+	return err
+}
+
+// PurgeDeletedUsers permanently deletes the accounts whose grace period ended before the given time
+// and returns their IDs. Sessions, roles and pending tokens are removed by the ON DELETE CASCADE foreign keys.
+func (r *AccountRepo) PurgeDeletedUsers(ctx context.Context, before time.Time) (ids []uuid.UUID, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("purge_deleted_users", start, err)
+	}(time.Now())
+
+	rows, err := r.pool.Query(ctx, `DELETE FROM users WHERE deletion_scheduled_at <= $1 RETURNING id`, before)
+	if err != nil {
+		return nil, err
+	}
+	ids, err = pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	return ids, err
+}
+
+// ListDeletedUsers returns the IDs of the accounts PurgeDeletedUsers would delete, without deleting them.
+func (r *AccountRepo) ListDeletedUsers(ctx context.Context, before time.Time) (ids []uuid.UUID, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_deleted_users", start, err)
+	}(time.Now())
+
+	rows, err := r.pool.Query(ctx, `SELECT id FROM users WHERE deletion_scheduled_at <= $1`, before)
+	if err != nil {
+		return nil, err
+	}
+	ids, err = pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	return ids, err
+}
+
+// UpdateUsername changes the username of a live account, returns pgx.ErrNoRows if there is none.
+// A username of another live account fails with a unique violation of users_username_live_key.
+func (r *AccountRepo) UpdateUsername(ctx context.Context, userID uuid.UUID, username string) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("update_username", start, err)
+	}(time.Now())
+
+	tag, err := r.pool.Exec(ctx, `UPDATE users SET username = $1 WHERE id = $2 AND deleted_at IS NULL`, username, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		err = pgx.ErrNoRows
+	}
+	return err
+}
+
+// SoftDeleteUser marks the user as deleted, increments its token version and deletes all its sessions in one transaction. The row is kept,
+// but every lookup treats the user as nonexistent. Returns customerrors.ErrNoTagsAffected if the user does not exist.
+func (r *AccountRepo) SoftDeleteUser(ctx context.Context, userID uuid.UUID) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("soft_delete_user", start, err)
+	}(time.Now())
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `UPDATE users SET deleted_at = NOW(), token_version = token_version + 1 WHERE id = $1 AND deleted_at IS NULL`, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() != 1 {
+		err = customerrors.ErrNoTagsAffected
+		return err
+	}
+	if _, err = tx.Exec(ctx, `DELETE FROM sessions WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	return err
+}
+
+// RestoreUser clears the soft deletion of the user. Returns customerrors.ErrNoTagsAffected if the user
+// is not soft-deleted and a unique violation if its username or email was taken in the meantime.
+func (r *AccountRepo) RestoreUser(ctx context.Context, userID uuid.UUID) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("restore_user", start, err)
+	}(time.Now())
+
+	tag, err := r.pool.Exec(ctx, `UPDATE users SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL`, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() != 1 {
+		err = customerrors.ErrNoTagsAffected
+	}
+	return err
+}
+
+// GetSecurityFacts returns the verification state, the password age, the second factor and the passkeys of the user,
+// the session counts are left to the caller. Returns pgx.ErrNoRows if the user does not exist or is deleted.
+func (r *AccountRepo) GetSecurityFacts(ctx context.Context, userID uuid.UUID) (facts entity.SecurityFacts, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_security_facts", start, err)
+	}(time.Now())
+
+	sql := `SELECT u.email_verified, u.password_changed_at, u.mfa_method IS NOT NULL,
+				(SELECT COUNT(*) FROM passkeys p WHERE p.user_id = u.id)
+			FROM users u
+			WHERE u.id = $1 AND u.deleted_at IS NULL`
+	err = r.pool.QueryRow(ctx, sql, userID).Scan(
+		&facts.EmailVerified,
+		&facts.PasswordChangedAt,
+		&facts.MFAEnabled,
+		&facts.Passkeys,
+	)
+	return facts, err
+}
+
+// ImportUsers inserts the users with their password hashes as is in one transaction. Users whose ID, username
+// or email is already used are skipped and their indexes returned, also by an account whose canonical email is
+// still stored in plaintext. In dry-run mode the transaction is rolled back.
+func (r *AccountRepo) ImportUsers(ctx context.Context, users []entity.ImportUser, dryRun bool) (conflicts []int, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("import_users", start, err)
+	}(time.Now())
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	batch := &pgx.Batch{}
+	for _, user := range users {
+		batch.Queue(`INSERT INTO users (id, email, canonical_email, username, password_hash, email_verified)
+					SELECT $1, $2, $3, $4, $5, $6 WHERE NOT EXISTS (SELECT 1 FROM users WHERE canonical_email = $7)
+					ON CONFLICT DO NOTHING`,
+			user.ID, r.crypt.Encrypt(user.Email), r.crypt.Index(user.CanonicalEmail), user.Username, user.PasswordHash,
+			user.EmailVerified, user.CanonicalEmail)
+	}
+	results := tx.SendBatch(ctx, batch)
+	for i := range users {
+		tag, execErr := results.Exec()
+		if execErr != nil {
+			results.Close()
+			err = execErr
+			return nil, err
+		}
+		if tag.RowsAffected() != 1 {
+			conflicts = append(conflicts, i)
+		}
+	}
+	if err = results.Close(); err != nil {
+		return nil, err
+	}
+
+	if dryRun {
+		return conflicts, nil
+	}
+	err = tx.Commit(ctx)
+	return conflicts, err
+}
+
+// ListUsersForExport returns up to limit live users ordered by ID, starting after afterID (uuid.Nil for the first page).
+func (r *AccountRepo) ListUsersForExport(ctx context.Context, afterID uuid.UUID, limit int) (users []entity.ExportUser, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_users_for_export", start, err)
+	}(time.Now())
+
+	sql := `SELECT id, email, username, password_hash, created_at, is_blocked, email_verified, password_changed_at
+			FROM users WHERE id > $1 AND deleted_at IS NULL
+			ORDER BY id LIMIT $2`
+	rows, err := r.pool.Query(ctx, sql, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	users, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (entity.ExportUser, error) {
+		var u entity.ExportUser
+		err := row.Scan(&u.ID, &u.Email, &u.Username, &u.PasswordHash, &u.CreatedAt, &u.IsBlocked, &u.EmailVerified, &u.PasswordChangedAt)
+		if err == nil {
+			u.Email, err = r.crypt.Decrypt(u.Email)
+		}
+		return u, err
+	})
+	return users, err
+}
+
+// ListUsers returns a page of live users, newest first, and the number of users matching the filter. Encrypted
+// emails are only matched by a query that is the whole email in its canonical form.
+func (r *AccountRepo) ListUsers(ctx context.Context, filter entity.UserFilter) (page entity.UserPage, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_users", start, err)
+	}(time.Now())
+
+	pattern := "%" + escapeLike(filter.Query) + "%"
+	index := r.crypt.Index(strings.ToLower(strings.TrimSpace(filter.Query)))
+	where := `deleted_at IS NULL AND ($1 = '%%' OR username ILIKE $1 OR email ILIKE $1 OR canonical_email = $2)`
+	if err = r.pool.QueryRow(ctx, `SELECT COUNT(*) FROM users WHERE `+where, pattern, index).Scan(&page.Total); err != nil {
+		return entity.UserPage{}, err
+	}
+
+	sql := `SELECT id, email, username, created_at, is_blocked, email_verified
+			FROM users WHERE ` + where + `
+			ORDER BY created_at DESC, id LIMIT $3 OFFSET $4`
+	rows, err := r.pool.Query(ctx, sql, pattern, index, filter.Limit, filter.Offset)
+	if err != nil {
+		return entity.UserPage{}, err
+	}
+	page.Users, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (entity.User, error) {
+		var u entity.User
+		err := row.Scan(&u.ID, &u.Email, &u.Username, &u.CreatedAt, &u.IsBlocked, &u.EmailVerified)
+		if err == nil {
+			u.Email, err = r.crypt.Decrypt(u.Email)
+		}
+		return u, err
+	})
+	return page, err
+}
+
+// GetUserDetail returns the live user, its sessions are read from the session store. Returns pgx.ErrNoRows if the user does not exist.
+func (r *AccountRepo) GetUserDetail(ctx context.Context, userID uuid.UUID) (detail entity.UserDetail, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_user_detail", start, err)
+	}(time.Now())
+
+	u := &detail.User
+	err = r.pool.QueryRow(ctx, `SELECT id, email, username, created_at, is_blocked, email_verified,
+				COALESCE(locale, ''), COALESCE(timezone, '')
+			FROM users WHERE id = $1 AND deleted_at IS NULL`, userID).
+		Scan(&u.ID, &u.Email, &u.Username, &u.CreatedAt, &u.IsBlocked, &u.EmailVerified, &u.Locale, &u.Timezone)
+	if err != nil {
+		return entity.UserDetail{}, err
+	}
+	if u.Email, err = r.crypt.Decrypt(u.Email); err != nil {
+		return entity.UserDetail{}, err
+	}
+
+	return detail, nil
+}
+
+// SetUserBlocked blocks or unblocks the user, blocking also increments its token version and deletes all its sessions
+// in the same transaction.
+// Returns customerrors.ErrNoTagsAffected if the user does not exist.
+func (r *AccountRepo) SetUserBlocked(ctx context.Context, userID uuid.UUID, blocked bool) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("update_user_blocked", start, err)
+	}(time.Now())
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `UPDATE users SET is_blocked = $1, token_version = token_version + CASE WHEN $1 THEN 1 ELSE 0 END
+			WHERE id = $2 AND deleted_at IS NULL`, blocked, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() != 1 {
+		err = customerrors.ErrNoTagsAffected
+		return err
+	}
+	if blocked {
+		if _, err = tx.Exec(ctx, `DELETE FROM sessions WHERE user_id = $1`, userID); err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit(ctx)
+	return err
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// ForceLogout increments the token version of the target user and records the action in one transaction, its
+// sessions are ended in the session store. In dry-run mode nothing changes.
+// Returns customerrors.ErrNoTagsAffected if the user does not exist.
+func (r *AccountRepo) ForceLogout(ctx context.Context, action entity.AdminAction, dryRun bool) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("force_logout", start, err)
+	}(time.Now())
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `UPDATE users SET token_version = token_version + 1 WHERE id = $1 AND deleted_at IS NULL`, action.TargetID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() != 1 {
+		err = customerrors.ErrNoTagsAffected
+		return err
+	}
+	if dryRun {
+		return nil
+	}
+
+	_, err = tx.Exec(ctx, `INSERT INTO audit_events (id, actor_id, action, target_type, target_id, reason_code, reason, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		action.ID, action.ActorID, action.Action, action.TargetType, action.TargetID, action.Reason.Code, action.Reason.Text, action.CreatedAt)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit(ctx)
+	return err
+}
+
+// UserMetadata returns the metadata object of the user, pgx.ErrNoRows if the user does not exist or is deleted.
+func (r *AccountRepo) UserMetadata(ctx context.Context, userID uuid.UUID) (metadata []byte, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_user_metadata", start, err)
+	}(time.Now())
+
+	err = r.pool.QueryRow(ctx, `SELECT metadata FROM users WHERE id = $1 AND deleted_at IS NULL`, userID).Scan(&metadata)
+	return metadata, err
+}
+
+// PatchUserMetadata merges set into the metadata of the user and removes the keys in remove, top-level keys
+// are replaced as a whole. Returns pgx.ErrNoRows if the user does not exist and customerrors.ErrMetadataTooLarge
+// if the result would be larger than maxBytes or have more than maxKeys keys, the metadata is unchanged then.
+func (r *AccountRepo) PatchUserMetadata(ctx context.Context, userID uuid.UUID, set []byte, remove []string, maxBytes, maxKeys int) (metadata []byte, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("update_user_metadata", start, err)
+	}(time.Now())
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var size, keys int
+	err = tx.QueryRow(ctx, `SELECT octet_length(m::text), (SELECT COUNT(*) FROM jsonb_object_keys(m))
+		FROM (SELECT (metadata || $2::jsonb) - $3::text[] AS m FROM users WHERE id = $1 AND deleted_at IS NULL FOR UPDATE) patched`,
+		userID, string(set), remove).Scan(&size, &keys)
+	if err != nil {
+		return nil, err
+	}
+	if size > maxBytes || keys > maxKeys {
+		err = customerrors.ErrMetadataTooLarge
+		return nil, err
+	}
+	err = tx.QueryRow(ctx, `UPDATE users SET metadata = (metadata || $2::jsonb) - $3::text[] WHERE id = $1 RETURNING metadata`,
+		userID, string(set), remove).Scan(&metadata)
+	if err != nil {
+		return nil, err
+	}
+	err = tx.Commit(ctx)
+	return metadata, err
+}
+
+// SeedUsers bulk loads generated users, their sessions and login history with COPY in one transaction, for load tests.
+// The users must not exist yet.
+func (r *AccountRepo) SeedUsers(ctx context.Context, users []entity.SeedUser, sessions []entity.Session, events []entity.LoginEvent) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("seed_users", start, err)
+	}(time.Now())
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"users"},
+		[]string{"id", "email", "canonical_email", "username", "password_hash", "email_verified", "is_blocked", "created_at", "password_changed_at"},
+		pgx.CopyFromSlice(len(users), func(i int) ([]any, error) {
+			u := users[i]
+			return []any{u.ID, r.crypt.Encrypt(u.Email), r.crypt.Index(u.CanonicalEmail), u.Username, u.PasswordHash, u.EmailVerified, u.IsBlocked, u.CreatedAt, u.CreatedAt}, nil
+		}))
+	if err != nil {
+		return err
+	}
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"sessions"},
+		[]string{"id", "user_id", "refresh_token", "created_at", "expires_at", "user_agent", "ip_address", "client_type", "ip_hash", "device_hash"},
+		pgx.CopyFromSlice(len(sessions), func(i int) ([]any, error) {
+			s := sessions[i]
+			return []any{s.ID, s.UserID, s.RefreshToken, s.CreatedAt, s.ExpiresAt, r.crypt.Encrypt(s.UserAgent),
+				r.crypt.EncryptAddr(s.ClientIP), string(s.ClientType),
+				nullIfEmpty(s.IPHash), nullIfEmpty(s.DeviceHash)}, nil
+		}))
+	if err != nil {
+		return err
+	}
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"login_events"},
+		[]string{"id", "user_id", "created_at", "ip_address", "user_agent", "client_type", "outcome"},
+		pgx.CopyFromSlice(len(events), func(i int) ([]any, error) {
+			e := events[i]
+			return []any{e.ID, e.UserID, e.CreatedAt, r.crypt.EncryptAddr(e.IP), nullIfEmpty(r.crypt.Encrypt(e.UserAgent)),
+				string(e.ClientType), string(e.Outcome)}, nil
+		}))
+	if err != nil {
+		return err
+	}
+	err = tx.Commit(ctx)
+	return err
+}
+
+func nullIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,94 @@
+package audit
+
+import (
+	"context"
+	"main/domain/entity"
+	metrics "main/internal/metrics"
+	"main/pkg/fieldcrypt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type AuditRepo struct {
+	pool    *pgxpool.Pool
+	Metrics *metrics.Metrics
+	// crypt encrypts the IP addresses and user agents of the events, nil stores them in plaintext
+	crypt *fieldcrypt.Cipher
+}
+
+func NewAuditRepo(pool *pgxpool.Pool, metrics *metrics.Metrics, crypt *fieldcrypt.Cipher) *AuditRepo {
+	return &AuditRepo{
+		pool:    pool,
+		Metrics: metrics,
+		crypt:   crypt,
+	}
+}
+
+// StoreAuditEvent appends the event to the audit log.
+func (r *AuditRepo) StoreAuditEvent(ctx context.Context, event entity.AuditEvent) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("insert_audit_event", start, err)
+	}(time.Now())
+
+	details := event.Details
+	if details == nil {
+		details = map[string]string{}
+	}
+	_, err = r.pool.Exec(ctx, `INSERT INTO audit_events (id, created_at, action, actor_id, target_type, target_id, reason_code, reason,
+				ip_address, user_agent, details)
+			VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, NULLIF($7, ''), NULLIF($8, ''), $9, NULLIF($10, ''), $11)`,
+		event.ID, event.CreatedAt, event.Action, event.ActorID, event.TargetType, event.TargetID, string(event.ReasonCode),
+		event.Reason, r.crypt.EncryptAddr(event.IP), r.crypt.Encrypt(event.UserAgent), details)
+	return err
+}
+
+// ListAuditEvents returns a page of the events matching the filter, newest first, and the number of matching events.
+func (r *AuditRepo) ListAuditEvents(ctx context.Context, filter entity.AuditFilter) (page entity.AuditPage, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_audit_events", start, err)
+	}(time.Now())
+
+	var userID *uuid.UUID
+	if filter.UserID != uuid.Nil {
+		userID = &filter.UserID
+	}
+	var from, to *time.Time
+	if !filter.From.IsZero() {
+		from = &filter.From
+	}
+	if !filter.To.IsZero() {
+		to = &filter.To
+	}
+	where := `($1::uuid IS NULL OR actor_id = $1 OR target_id = $1) AND ($2 = '' OR action = $2)
+			AND ($3::timestamptz IS NULL OR created_at >= $3) AND ($4::timestamptz IS NULL OR created_at < $4)`
+	if err = r.pool.QueryRow(ctx, `SELECT COUNT(*) FROM audit_events WHERE `+where,
+		userID, filter.Action, from, to).Scan(&page.Total); err != nil {
+		return entity.AuditPage{}, err
+	}
+
+	sql := `SELECT id, created_at, action, actor_id, COALESCE(target_type, ''), target_id, COALESCE(reason_code, ''),
+				COALESCE(reason, ''), ip_address, COALESCE(user_agent, ''), details
+			FROM audit_events WHERE ` + where + `
+			ORDER BY created_at DESC, id LIMIT $5 OFFSET $6`
+	rows, err := r.pool.Query(ctx, sql, userID, filter.Action, from, to, filter.Limit, filter.Offset)
+	if err != nil {
+		return entity.AuditPage{}, err
+	}
+	page.Events, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (entity.AuditEvent, error) {
+		var e entity.AuditEvent
+		var ip *string
+		err := row.Scan(&e.ID, &e.CreatedAt, &e.Action, &e.ActorID, &e.TargetType, &e.TargetID, &e.ReasonCode,
+			&e.Reason, &ip, &e.UserAgent, &e.Details)
+		if err == nil {
+			e.UserAgent, err = r.crypt.Decrypt(e.UserAgent)
+		}
+		if err == nil {
+			e.IP, err = r.crypt.DecryptAddr(ip)
+		}
+		return e, err
+	})
+	return page, err
+}
@@ -2,35 +2,43 @@ package auth
 
 import (
 	"context"
+	"errors"
 	"main/domain/entity"
 	metrics "main/internal/metrics"
 	"main/pkg/customerrors"
+	"main/pkg/fieldcrypt"
+	"net/netip"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type AuthRepo struct {
 	pool    *pgxpool.Pool
 	Metrics *metrics.Metrics
+	// crypt encrypts emails, IP addresses and user agents, nil stores them in plaintext
+	crypt *fieldcrypt.Cipher
 }
 
-func NewAuthRepo(pool *pgxpool.Pool, metrics *metrics.Metrics) *AuthRepo {
+func NewAuthRepo(pool *pgxpool.Pool, metrics *metrics.Metrics, crypt *fieldcrypt.Cipher) *AuthRepo {
 	return &AuthRepo{
 		pool:    pool,
 		Metrics: metrics,
+		crypt:   crypt,
 	}
 }
 
 // CreateUser creates a new user in the database with the provided details and returns the user ID.
-func (r *AuthRepo) CreateUser(ctx context.Context, userID uuid.UUID, email, username, passwordHash string) (uuid.UUID, error) {
+// A unique violation is returned if another account has the same canonical email.
+func (r *AuthRepo) CreateUser(ctx context.Context, user entity.NewUser) (uuid.UUID, error) {
 	var err error
 	defer func(start time.Time) {
 		r.Metrics.ObserveDB("insert_user", start, err)
 	}(time.Now())
-	tag, err := r.pool.Exec(ctx, "INSERT INTO users (id, email, username, password_hash) VALUES ($1, $2, $3, $4)",
-		userID, email, username, passwordHash)
+	tag, err := r.pool.Exec(ctx, "INSERT INTO users (id, email, canonical_email, username, password_hash, phone) VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''))",
+		user.ID, r.crypt.Encrypt(user.Email), r.crypt.Index(user.CanonicalEmail), user.Username, user.PasswordHash, user.Phone)
 
 	if err != nil {
 		return uuid.Nil, err
@@ -39,41 +47,202 @@ func (r *AuthRepo) CreateUser(ctx context.Context, userID uuid.UUID, email, user
 		err = customerrors.ErrNoTagsAffected
 		return uuid.Nil, err
 	}
-	return userID, nil
+	return user.ID, nil
 }
 
-// Returns userID and password hash
-func (r *AuthRepo) GetUserByLogin(ctx context.Context, login string) (userID uuid.UUID, passwordHash string, err error) {
+// CreateInvitedUser consumes one use of the invitation with the given code hash and creates the user in the same
+// transaction. Returns customerrors.ErrInvalidInvite if the invitation is unknown, expired, revoked or used up.
+func (r *AuthRepo) CreateInvitedUser(ctx context.Context, user entity.NewUser, codeHash []byte) (_ uuid.UUID, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("insert_invited_user", start, err)
+	}(time.Now())
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var invitationID uuid.UUID
+	err = tx.QueryRow(ctx, `UPDATE invitations SET uses = uses + 1
+		WHERE code_hash = $1 AND uses < max_uses AND expires_at > NOW() AND revoked_at IS NULL
+		RETURNING id`, codeHash).Scan(&invitationID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = customerrors.ErrInvalidInvite
+		return uuid.Nil, err
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	_, err = tx.Exec(ctx, "INSERT INTO users (id, email, canonical_email, username, password_hash, phone, invitation_id) VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7)",
+		user.ID, r.crypt.Encrypt(user.Email), r.crypt.Index(user.CanonicalEmail), user.Username, user.PasswordHash, user.Phone,
+		invitationID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return uuid.Nil, err
+	}
+	return user.ID, nil
+}
+
+// LoginTaken reports whether the username or the canonical email is used by an account, empty values are not checked.
+// Both are looked up in one query, so the query time does not depend on which of them matches. Canonical emails
+// are looked up by their blind index and in plaintext, for the accounts created before encryption was enabled.
+func (r *AuthRepo) LoginTaken(ctx context.Context, username, canonicalEmail string) (taken bool, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_login_taken", start, err)
+	}(time.Now())
+
+	sql := `SELECT EXISTS (
+				SELECT 1 FROM users
+				WHERE deleted_at IS NULL AND (($1 <> '' AND username = $1) OR ($2 <> '' AND canonical_email IN ($2, $3)))
+			)`
+	err = r.pool.QueryRow(ctx, sql, username, canonicalEmail, r.crypt.Index(canonicalEmail)).Scan(&taken)
+	return taken, err
+}
+
+// GetUserByLogin retrieves the user by username or canonical email. Soft-deleted users are not found.
+func (r *AuthRepo) GetUserByLogin(ctx context.Context, login, canonicalEmail string) (user entity.User, err error) {
 
 	defer func(start time.Time) {
 		r.Metrics.ObserveDB("select_user_by_login", start, err)
 	}(time.Now())
 
-	err = r.pool.QueryRow(ctx, "select id, password_hash from users where username = $1 OR email = $1", login).Scan(
-		&userID,
-		&passwordHash,
+	sql := `SELECT id, email, username, password_hash, created_at, is_blocked, email_verified,
+				COALESCE(locale, ''), COALESCE(timezone, '')
+			FROM users WHERE (username = $1 OR canonical_email IN ($2, $3)) AND deleted_at IS NULL`
+	err = r.pool.QueryRow(ctx, sql, login, canonicalEmail, r.crypt.Index(canonicalEmail)).Scan(
+		&user.ID,
+		&user.Email,
+		&user.Username,
+		&user.PasswordHash,
+		&user.CreatedAt,
+		&user.IsBlocked,
+		&user.EmailVerified,
+		&user.Locale,
+		&user.Timezone,
+	)
+	if err != nil {
+		return entity.User{}, err
+	}
+	if user.Email, err = r.crypt.Decrypt(user.Email); err != nil {
+		return entity.User{}, err
+	}
+	return user, nil
+
+}
+
+// GetUserByEmail retrieves the user by canonical email. Soft-deleted users are not found.
+func (r *AuthRepo) GetUserByEmail(ctx context.Context, canonicalEmail string) (user entity.User, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_user_by_email", start, err)
+	}(time.Now())
+
+	sql := `SELECT id, email, username, password_hash, created_at, is_blocked, email_verified,
+				COALESCE(locale, ''), COALESCE(timezone, '')
+			FROM users WHERE canonical_email IN ($1, $2) AND deleted_at IS NULL`
+	err = r.pool.QueryRow(ctx, sql, canonicalEmail, r.crypt.Index(canonicalEmail)).Scan(
+		&user.ID,
+		&user.Email,
+		&user.Username,
+		&user.PasswordHash,
+		&user.CreatedAt,
+		&user.IsBlocked,
+		&user.EmailVerified,
+		&user.Locale,
+		&user.Timezone,
 	)
 	if err != nil {
-		return uuid.Nil, "", err
+		return entity.User{}, err
+	}
+	if user.Email, err = r.crypt.Decrypt(user.Email); err != nil {
+		return entity.User{}, err
 	}
-	return userID, passwordHash, nil
+	return user, nil
+}
 
+// GetUserByID retrieves the user by ID. Soft-deleted users are not found.
+func (r *AuthRepo) GetUserByID(ctx context.Context, userID uuid.UUID) (user entity.User, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_user_by_id", start, err)
+	}(time.Now())
+
+	sql := `SELECT id, email, username, password_hash, created_at, is_blocked, email_verified,
+				COALESCE(locale, ''), COALESCE(timezone, '')
+			FROM users WHERE id = $1 AND deleted_at IS NULL`
+	err = r.pool.QueryRow(ctx, sql, userID).Scan(
+		&user.ID,
+		&user.Email,
+		&user.Username,
+		&user.PasswordHash,
+		&user.CreatedAt,
+		&user.IsBlocked,
+		&user.EmailVerified,
+		&user.Locale,
+		&user.Timezone,
+	)
+	if err != nil {
+		return entity.User{}, err
+	}
+	if user.Email, err = r.crypt.Decrypt(user.Email); err != nil {
+		return entity.User{}, err
+	}
+	return user, nil
 }
 
 // Saves the session associated with a user in the database, allowing for session management and token revocation.
+// The locale and time zone of the session become those of the user, empty ones keep the previous values.
 func (r *AuthRepo) StoreSession(ctx context.Context, userID uuid.UUID, session entity.Session) (err error) {
 	defer func(start time.Time) {
 		r.Metrics.ObserveDB("insert_session", start, err)
 	}(time.Now())
-	sql := `INSERT INTO sessions 
-			(id, user_id, refresh_token, created_at, expires_at, user_agent, ip_address) 
-			VALUES ($1, $2, $3, $4, $5, $6, $7)`
 
-	_, err = r.pool.Exec(ctx,
-		sql, session.ID, userID, session.RefreshToken, session.CreatedAt, session.ExpiresAt, session.UserAgent, session.ClientIP)
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	sql := `INSERT INTO sessions 
+			(id, user_id, refresh_token, created_at, expires_at, user_agent, ip_address, client_type, cert_thumbprint, dpop_jkt, ip_hash, device_hash,
+			locale, timezone, backup_refresh_token, country, risk_level, risk_factors, attest_key_id, attest_public_key, attest_counter,
+			bound_network, city, privileges, started_at, last_used_at, device_name) 
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, ''), NULLIF($10, ''), $11, $12, NULLIF($13, ''), NULLIF($14, ''), $15,
+			NULLIF($16, ''), NULLIF($17, ''), $18, $19, $20, $21, $22, NULLIF($23, ''), $24, $4, $4, NULLIF($25, ''))`
 
-	return err
+	var canary *uuid.UUID
+	if session.CanaryToken != uuid.Nil {
+		canary = &session.CanaryToken
+	}
+	factors := session.Risk.Factors
+	if factors == nil {
+		factors = []string{}
+	}
+	keyID, publicKey, counter := attestedKeyColumns(session.AttestedKey)
+	var network *netip.Addr
+	if session.BoundNetwork.IsValid() {
+		network = &session.BoundNetwork
+	}
+	_, err = tx.Exec(ctx,
+		sql, session.ID, userID, session.RefreshToken, session.CreatedAt, session.ExpiresAt, r.crypt.Encrypt(session.UserAgent),
+		r.crypt.EncryptAddr(session.ClientIP),
+		session.ClientType, session.CertThumbprint, session.DPoPThumbprint, session.IPHash, session.DeviceHash,
+		session.Locale, session.Timezone, canary, session.Country, session.Risk.Level, factors, keyID, publicKey, counter, network,
+		session.City, session.Privileges, r.crypt.Encrypt(session.DeviceName))
+	if err != nil {
+		return err
+	}
 
+	if session.Locale != "" || session.Timezone != "" {
+		_, err = tx.Exec(ctx, `UPDATE users SET locale = COALESCE(NULLIF($2, ''), locale), timezone = COALESCE(NULLIF($3, ''), timezone)
+			WHERE id = $1`, userID, session.Locale, session.Timezone)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
 }
 
 // DeleteSession removes a specific session for a user, effectively logging them out from that ONE SPECIFIC SESSION.
@@ -83,51 +252,598 @@ func (r *AuthRepo) DeleteSession(ctx context.Context, userID uuid.UUID, sessionI
 	return err
 }
 
-// DeleteAllSessions removes all sessions for a user, effectively logging them out from !ALL! sessions.
-func (r *AuthRepo) DeleteAllSessions(ctx context.Context, userID uuid.UUID) error {
-	sql := `DELETE FROM sessions WHERE user_id = $1`
-	_, err := r.pool.Exec(ctx, sql, userID)
+// DeleteAllSessions removes all sessions for a user, effectively logging them out from !ALL! sessions,
+// and increments its token version in the same transaction. It returns the IDs of the deleted sessions.
+func (r *AuthRepo) DeleteAllSessions(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err = tx.Exec(ctx, `UPDATE users SET token_version = token_version + 1 WHERE id = $1`, userID); err != nil {
+		return nil, err
+	}
+	rows, err := tx.Query(ctx, `DELETE FROM sessions WHERE user_id = $1 RETURNING id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return nil, err
+	}
+	return ids, tx.Commit(ctx)
+}
+
+// IncrementTokenVersion increments the token version of the user, which revokes its access tokens. Used by the
+// Redis session store, whose sessions are not deleted here.
+func (r *AuthRepo) IncrementTokenVersion(ctx context.Context, userID uuid.UUID) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("increment_token_version", start, err)
+	}(time.Now())
+
+	_, err = r.pool.Exec(ctx, `UPDATE users SET token_version = token_version + 1 WHERE id = $1`, userID)
+	return err
+}
+
+// UpdateLocale sets the locale and time zone of the user, empty ones keep the previous values. StoreSession
+// does it in its transaction, this is for the Redis session store.
+func (r *AuthRepo) UpdateLocale(ctx context.Context, userID uuid.UUID, locale, timezone string) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("update_user_locale", start, err)
+	}(time.Now())
+
+	_, err = r.pool.Exec(ctx, `UPDATE users SET locale = COALESCE(NULLIF($2, ''), locale), timezone = COALESCE(NULLIF($3, ''), timezone)
+			WHERE id = $1`, userID, locale, timezone)
 	return err
 }
 
+// ListSessionIDs returns the IDs of all sessions of a user.
+func (r *AuthRepo) ListSessionIDs(ctx context.Context, userID uuid.UUID) (ids []uuid.UUID, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_session_ids", start, err)
+	}(time.Now())
+
+	rows, err := r.pool.Query(ctx, `SELECT id FROM sessions WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	ids, err = pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	return ids, err
+}
+
+// RemoveUserSessions deletes all sessions of the users without touching their token versions, which the
+// revocations calling it incremented already, and returns their IDs.
+func (r *AuthRepo) RemoveUserSessions(ctx context.Context, userIDs []uuid.UUID) (ids []uuid.UUID, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("delete_user_sessions", start, err)
+	}(time.Now())
+
+	rows, err := r.pool.Query(ctx, `DELETE FROM sessions WHERE user_id = ANY($1) RETURNING id`, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	ids, err = pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	return ids, err
+}
+
+// UserSessions returns the unexpired sessions of the user, newest first.
+func (r *AuthRepo) UserSessions(ctx context.Context, userID uuid.UUID) (sessions []entity.Session, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_user_sessions", start, err)
+	}(time.Now())
+
+	rows, err := r.pool.Query(ctx, `SELECT id, user_id, created_at, expires_at, user_agent, ip_address, client_type,
+			COALESCE(country, ''), COALESCE(city, ''), COALESCE(risk_level, ''), risk_factors
+			FROM sessions WHERE user_id = $1 AND expires_at > NOW() ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	sessions, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (entity.Session, error) {
+		var s entity.Session
+		var ip *string
+		err := row.Scan(&s.ID, &s.UserID, &s.CreatedAt, &s.ExpiresAt, &s.UserAgent, &ip, &s.ClientType,
+			&s.Country, &s.City, &s.Risk.Level, &s.Risk.Factors)
+		if err == nil {
+			s.UserAgent, err = r.crypt.Decrypt(s.UserAgent)
+		}
+		if err == nil {
+			s.ClientIP, err = r.crypt.DecryptAddr(ip)
+		}
+		return s, err
+	})
+	return sessions, err
+}
+
+// SessionsByTokens returns the sessions whose ID or refresh token is one of the tokens, with their ID,
+// user and refresh token.
+func (r *AuthRepo) SessionsByTokens(ctx context.Context, tokens []uuid.UUID) (sessions []entity.Session, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_sessions_by_tokens", start, err)
+	}(time.Now())
+
+	rows, err := r.pool.Query(ctx, `SELECT id, user_id, refresh_token FROM sessions
+			WHERE id = ANY($1) OR refresh_token = ANY($1)`, tokens)
+	if err != nil {
+		return nil, err
+	}
+	sessions, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (entity.Session, error) {
+		var s entity.Session
+		err := row.Scan(&s.ID, &s.UserID, &s.RefreshToken)
+		return s, err
+	})
+	return sessions, err
+}
+
+// DeleteSessions deletes the sessions and returns how many it deleted.
+func (r *AuthRepo) DeleteSessions(ctx context.Context, sessionIDs []uuid.UUID) (_ int64, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("delete_sessions", start, err)
+	}(time.Now())
+
+	tag, err := r.pool.Exec(ctx, `DELETE FROM sessions WHERE id = ANY($1)`, sessionIDs)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// SessionStats counts the unexpired sessions and the sessions started in the last hour and day as of now.
+func (r *AuthRepo) SessionStats(ctx context.Context, now time.Time) (stats entity.SessionStats, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_session_stats", start, err)
+	}(time.Now())
+
+	err = r.pool.QueryRow(ctx, `SELECT
+			COUNT(*) FILTER (WHERE expires_at > $1),
+			COUNT(*) FILTER (WHERE started_at > $1 - INTERVAL '1 hour'),
+			COUNT(*) FILTER (WHERE started_at > $1 - INTERVAL '1 day')
+			FROM sessions`, now).Scan(&stats.Active, &stats.StartedLastHour, &stats.StartedLastDay)
+	return stats, err
+}
+
+// ListActiveSessions returns the unexpired sessions of the user, the last used first.
+func (r *AuthRepo) ListActiveSessions(ctx context.Context, userID uuid.UUID) (sessions []entity.ActiveSession, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_active_sessions", start, err)
+	}(time.Now())
+
+	rows, err := r.pool.Query(ctx, `SELECT `+activeSessionColumns+`
+			FROM sessions WHERE user_id = $1 AND expires_at > NOW() ORDER BY last_used_at DESC, id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, r.scanActiveSession)
+}
+
+// sessionStatusConditions are the conditions of the session statuses of listings.
+var sessionStatusConditions = map[entity.SessionStatus]string{
+	"":                          "TRUE",
+	entity.SessionStatusActive:  "expires_at > NOW() AND NOT COALESCE(is_blocked, FALSE)",
+	entity.SessionStatusExpired: "expires_at <= NOW()",
+	entity.SessionStatusBlocked: "COALESCE(is_blocked, FALSE)",
+}
+
+// sessionSortColumns are the columns session listings are sorted by.
+var sessionSortColumns = map[entity.SessionSort]string{
+	entity.SessionSortCreated:  "started_at",
+	entity.SessionSortLastUsed: "last_used_at",
+}
+
+// ListSessions returns a page of the sessions of the user matching the filter, newest first by the sort of the
+// filter, and the number of matching sessions. Pages continue after filter.After when it is set, at the offset
+// otherwise.
+func (r *AuthRepo) ListSessions(ctx context.Context, filter entity.SessionFilter) (page entity.SessionPage, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_sessions", start, err)
+	}(time.Now())
+
+	condition, ok := sessionStatusConditions[filter.Status]
+	column, sorted := sessionSortColumns[filter.Sort]
+	if !ok || !sorted {
+		return entity.SessionPage{}, errors.New("invalid session filter")
+	}
+	where := `user_id = $1 AND ` + condition
+	if err = r.pool.QueryRow(ctx, `SELECT COUNT(*) FROM sessions WHERE `+where, filter.UserID).Scan(&page.Total); err != nil {
+		return entity.SessionPage{}, err
+	}
+
+	args := []any{filter.UserID, filter.Limit, filter.Offset}
+	if filter.After != nil {
+		where += ` AND (` + column + `, id) < ($4, $5)`
+		args = append(args, filter.After.At, filter.After.ID)
+		args[2] = 0
+	}
+	rows, err := r.pool.Query(ctx, `SELECT `+activeSessionColumns+` FROM sessions WHERE `+where+`
+			ORDER BY `+column+` DESC, id DESC LIMIT $2 OFFSET $3`, args...)
+	if err != nil {
+		return entity.SessionPage{}, err
+	}
+	page.Sessions, err = pgx.CollectRows(rows, r.scanActiveSession)
+	return page, err
+}
+
+// activeSessionColumns are the columns scanned by scanActiveSession.
+const activeSessionColumns = `id, client_type, COALESCE(device_name, ''), COALESCE(user_agent, ''), ip_address,
+			COALESCE(country, ''), COALESCE(city, ''), started_at, last_used_at, expires_at, COALESCE(is_blocked, FALSE)`
+
+func (r *AuthRepo) scanActiveSession(row pgx.CollectableRow) (entity.ActiveSession, error) {
+	var s entity.ActiveSession
+	var ip *string
+	err := row.Scan(&s.ID, &s.ClientType, &s.DeviceName, &s.UserAgent, &ip, &s.Country, &s.City, &s.CreatedAt, &s.LastUsedAt,
+		&s.ExpiresAt, &s.Blocked)
+	if err != nil {
+		return s, err
+	}
+	if s.DeviceName, err = r.crypt.Decrypt(s.DeviceName); err != nil {
+		return s, err
+	}
+	if s.UserAgent, err = r.crypt.Decrypt(s.UserAgent); err != nil {
+		return s, err
+	}
+	s.IP, err = r.crypt.DecryptAddr(ip)
+	return s, err
+}
+
+// RenameSession sets the device name of an unexpired session of the user, an empty name removes it.
+// It returns pgx.ErrNoRows if the user has no such session.
+func (r *AuthRepo) RenameSession(ctx context.Context, userID, sessionID uuid.UUID, name string) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("rename_session", start, err)
+	}(time.Now())
+
+	tag, err := r.pool.Exec(ctx, `UPDATE sessions SET device_name = NULLIF($3, '') WHERE id = $1 AND user_id = $2 AND expires_at > NOW()`,
+		sessionID, userID, r.crypt.Encrypt(name))
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// SetSessionBlocked blocks or unblocks an unexpired session of the user, a blocked session is kept but cannot be
+// refreshed. It returns pgx.ErrNoRows if the user has no such session.
+func (r *AuthRepo) SetSessionBlocked(ctx context.Context, userID, sessionID uuid.UUID, blocked bool) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("set_session_blocked", start, err)
+	}(time.Now())
+
+	tag, err := r.pool.Exec(ctx, `UPDATE sessions SET is_blocked = $3 WHERE id = $1 AND user_id = $2 AND expires_at > NOW()`,
+		sessionID, userID, blocked)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
 func (r *AuthRepo) RefreshSession(ctx context.Context, session entity.Session) (err error) {
 
 	defer func(start time.Time) {
 		r.Metrics.ObserveDB("update_session", start, err)
 	}(time.Now())
 
-	sql := `UPDATE sessions SET created_at = $1, expires_at = $2, refresh_token = $3 WHERE id = $4 AND user_id = $5`
-	_, err = r.pool.Exec(ctx, sql, session.CreatedAt, session.ExpiresAt, session.RefreshToken, session.ID, session.UserID)
+	return r.updateSession(ctx, session.ID, session)
+}
+
+// RotateSession updates the session like RefreshSession and moves it from previousID to session.ID.
+func (r *AuthRepo) RotateSession(ctx context.Context, previousID uuid.UUID, session entity.Session) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("rotate_session", start, err)
+	}(time.Now())
+
+	return r.updateSession(ctx, previousID, session)
+}
+
+func (r *AuthRepo) updateSession(ctx context.Context, previousID uuid.UUID, session entity.Session) error {
+	// the refresh token is kept as the previous one when it is rotated, to tell its reuse from an unknown token
+	sql := `UPDATE sessions SET id = $1, created_at = $2, expires_at = $3, refresh_token = $4, ip_address = $5, ip_hash = NULLIF($6, ''),
+			attest_key_id = $7, attest_public_key = $8, attest_counter = $9, privileges = $10, elevated_until = $13, last_used_at = NOW(),
+			previous_refresh_token = CASE WHEN refresh_token <> $4 THEN refresh_token ELSE previous_refresh_token END
+			WHERE id = $11 AND user_id = $12`
+	keyID, publicKey, counter := attestedKeyColumns(session.AttestedKey)
+	var elevatedUntil *time.Time
+	if !session.ElevatedUntil.IsZero() {
+		elevatedUntil = &session.ElevatedUntil
+	}
+	tag, err := r.pool.Exec(ctx, sql, session.ID, session.CreatedAt, session.ExpiresAt, session.RefreshToken, r.crypt.EncryptAddr(session.ClientIP),
+		session.IPHash, keyID, publicKey, counter, session.Privileges, previousID, session.UserID, elevatedUntil)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		// the session was deleted since it was read
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// DenySessions adds the sessions to the denylist until the given time, sessions already on it are kept until
+// the later of both times.
+func (r *AuthRepo) DenySessions(ctx context.Context, sessionIDs []uuid.UUID, until time.Time) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("insert_denied_sessions", start, err)
+	}(time.Now())
+
+	_, err = r.pool.Exec(ctx, `INSERT INTO denied_sessions (session_id, expires_at) SELECT unnest($1::uuid[]), $2
+			ON CONFLICT (session_id) DO UPDATE SET expires_at = GREATEST(denied_sessions.expires_at, EXCLUDED.expires_at)`,
+		sessionIDs, until)
 	return err
 }
 
+// DeniedSessions returns the sessions denied until a time in the future.
+func (r *AuthRepo) DeniedSessions(ctx context.Context) (denied map[uuid.UUID]time.Time, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_denied_sessions", start, err)
+	}(time.Now())
+
+	rows, err := r.pool.Query(ctx, `SELECT session_id, expires_at FROM denied_sessions WHERE expires_at > NOW()`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	denied = make(map[uuid.UUID]time.Time)
+	for rows.Next() {
+		var id uuid.UUID
+		var until time.Time
+		if err = rows.Scan(&id, &until); err != nil {
+			return nil, err
+		}
+		denied[id] = until
+	}
+	err = rows.Err()
+	return denied, err
+}
+
+// PruneDeniedSessions removes the expired entries of the denylist and returns how many it removed.
+func (r *AuthRepo) PruneDeniedSessions(ctx context.Context) (_ int64, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("delete_denied_sessions", start, err)
+	}(time.Now())
+
+	tag, err := r.pool.Exec(ctx, `DELETE FROM denied_sessions WHERE expires_at <= NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// attestedKeyColumns returns the attest_* columns of the App Attest key of a session, all NULL without one.
+func attestedKeyColumns(key *entity.AttestedKey) (id, publicKey []byte, counter *int64) {
+	if key == nil {
+		return nil, nil, nil
+	}
+	c := int64(key.Counter)
+	return key.ID, key.PublicKey, &c
+}
+
 // GetSessionByRefreshToken retrieves a session from the database based on the provided refresh token, allowing for session validation and management.
 func (r *AuthRepo) GetSessionByRefreshToken(ctx context.Context, refreshToken uuid.UUID) (session entity.Session, err error) {
 	defer func(start time.Time) {
 		r.Metrics.ObserveDB("select_session_by_refresh_token", start, err)
 	}(time.Now())
 
-	sql := `SELECT id, user_id, created_at, expires_at, user_agent, ip_address
-			FROM sessions WHERE refresh_token = $1`
-	err = r.pool.QueryRow(ctx, sql, refreshToken).Scan(
+	return r.getSession(ctx, `refresh_token = $1`, refreshToken)
+}
+
+// GetSession returns the session of the user, pgx.ErrNoRows if the user has no session with the ID.
+func (r *AuthRepo) GetSession(ctx context.Context, userID, sessionID uuid.UUID) (session entity.Session, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_session", start, err)
+	}(time.Now())
+
+	return r.getSession(ctx, `id = $1 AND user_id = $2`, sessionID, userID)
+}
+
+func (r *AuthRepo) getSession(ctx context.Context, where string, args ...any) (session entity.Session, err error) {
+	sql := `SELECT id, user_id, refresh_token, created_at, expires_at, user_agent, ip_address, client_type,
+			COALESCE(cert_thumbprint, ''), COALESCE(dpop_jkt, ''), COALESCE(ip_hash, ''), COALESCE(device_hash, ''),
+			attest_key_id, attest_public_key, attest_counter, bound_network, privileges, elevated_until, started_at,
+			COALESCE(is_blocked, FALSE)
+			FROM sessions WHERE ` + where
+	var keyID, publicKey []byte
+	var counter *int64
+	var network *netip.Addr
+	var elevatedUntil *time.Time
+	var ip *string
+	err = r.pool.QueryRow(ctx, sql, args...).Scan(
 		&session.ID,
 		&session.UserID,
+		&session.RefreshToken,
 		&session.CreatedAt,
 		&session.ExpiresAt,
 		&session.UserAgent,
-		&session.ClientIP,
+		&ip,
+		&session.ClientType,
+		&session.CertThumbprint,
+		&session.DPoPThumbprint,
+		&session.IPHash,
+		&session.DeviceHash,
+		&keyID,
+		&publicKey,
+		&counter,
+		&network,
+		&session.Privileges,
+		&elevatedUntil,
+		&session.StartedAt,
+		&session.IsBlocked,
+	)
+	if err != nil {
+		return session, err
+	}
+	if session.UserAgent, err = r.crypt.Decrypt(session.UserAgent); err != nil {
+		return session, err
+	}
+	if session.ClientIP, err = r.crypt.DecryptAddr(ip); err != nil {
+		return session, err
+	}
+	if keyID != nil && counter != nil {
+		session.AttestedKey = &entity.AttestedKey{ID: keyID, PublicKey: publicKey, Counter: uint32(*counter)}
+	}
+	if network != nil {
+		session.BoundNetwork = *network
+	}
+	if elevatedUntil != nil {
+		session.ElevatedUntil = *elevatedUntil
+	}
+	return session, nil
+}
+
+// GetSessionByCanaryToken returns the session the decoy refresh token was issued with, pgx.ErrNoRows if none was.
+func (r *AuthRepo) GetSessionByCanaryToken(ctx context.Context, token uuid.UUID) (session entity.Session, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_session_by_canary_token", start, err)
+	}(time.Now())
+
+	err = r.pool.QueryRow(ctx, `SELECT id, user_id FROM sessions WHERE backup_refresh_token = $1`, token).Scan(
+		&session.ID,
+		&session.UserID,
 	)
 	return session, err
+}
+
+// GetSessionByPreviousRefreshToken returns the session whose refresh token was rotated away from the token,
+// pgx.ErrNoRows if there is none. Only the last rotated token of a session is kept.
+func (r *AuthRepo) GetSessionByPreviousRefreshToken(ctx context.Context, token uuid.UUID) (session entity.Session, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_session_by_previous_refresh_token", start, err)
+	}(time.Now())
 
+	err = r.pool.QueryRow(ctx, `SELECT id, user_id, client_type, created_at FROM sessions WHERE previous_refresh_token = $1`,
+		token).Scan(
+		&session.ID,
+		&session.UserID,
+		&session.ClientType,
+		&session.CreatedAt,
+	)
+	return session, err
 }
 
-func (r *AuthRepo) UserIsBlocked(userID uuid.UUID) (bool, error) {
-	var isBlocked bool
-	err := r.pool.QueryRow(context.Background(),
-		"SELECT is_blocked FROM users WHERE id = $1", userID).
-		Scan(&isBlocked)
+// SessionOrigins returns the IP, device hash, country and creation time of the last limit sessions of the user,
+// newest first. The creation time of a session is moved on by each rotation of its refresh token.
+func (r *AuthRepo) SessionOrigins(ctx context.Context, userID uuid.UUID, limit int) (origins []entity.SessionOrigin, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_session_origins", start, err)
+	}(time.Now())
+
+	rows, err := r.pool.Query(ctx, `SELECT ip_address, COALESCE(device_hash, ''), COALESCE(country, ''), created_at
+			FROM sessions WHERE user_id = $1 ORDER BY created_at DESC LIMIT $2`, userID, limit)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	return !isBlocked, nil
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (entity.SessionOrigin, error) {
+		var o entity.SessionOrigin
+		var ip *string
+		err := row.Scan(&ip, &o.DeviceHash, &o.Country, &o.CreatedAt)
+		if err == nil {
+			o.IP, err = r.crypt.DecryptAddr(ip)
+		}
+		return o, err
+	})
+}
+
+// StoreLoginEvent adds a login attempt to the login history of the user.
+func (r *AuthRepo) StoreLoginEvent(ctx context.Context, event entity.LoginEvent) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("insert_login_event", start, err)
+	}(time.Now())
+
+	_, err = r.pool.Exec(ctx, `INSERT INTO login_events (id, user_id, created_at, ip_address, user_agent, client_type, country, city, outcome)
+			VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, ''), $9)`,
+		event.ID, event.UserID, event.CreatedAt, r.crypt.EncryptAddr(event.IP), r.crypt.Encrypt(event.UserAgent),
+		string(event.ClientType), event.Country, event.City,
+		string(event.Outcome))
+	return err
+}
+
+// LoginHistory returns a page of the login attempts of the user, newest first, and the number of its attempts.
+func (r *AuthRepo) LoginHistory(ctx context.Context, userID uuid.UUID, limit, offset int) (page entity.LoginEventPage, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_login_history", start, err)
+	}(time.Now())
+
+	if err = r.pool.QueryRow(ctx, `SELECT COUNT(*) FROM login_events WHERE user_id = $1`, userID).Scan(&page.Total); err != nil {
+		return entity.LoginEventPage{}, err
+	}
+	rows, err := r.pool.Query(ctx, `SELECT id, user_id, created_at, ip_address, COALESCE(user_agent, ''), COALESCE(client_type, ''),
+				COALESCE(country, ''), COALESCE(city, ''), outcome
+			FROM login_events WHERE user_id = $1 ORDER BY created_at DESC, id LIMIT $2 OFFSET $3`, userID, limit, offset)
+	if err != nil {
+		return entity.LoginEventPage{}, err
+	}
+	page.Events, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (entity.LoginEvent, error) {
+		var e entity.LoginEvent
+		var ip *string
+		err := row.Scan(&e.ID, &e.UserID, &e.CreatedAt, &ip, &e.UserAgent, &e.ClientType, &e.Country, &e.City, &e.Outcome)
+		if err == nil {
+			e.UserAgent, err = r.crypt.Decrypt(e.UserAgent)
+		}
+		if err == nil {
+			e.IP, err = r.crypt.DecryptAddr(ip)
+		}
+		return e, err
+	})
+	return page, err
+}
+
+// UpdatePasswordHash replaces the password hash if it still equals oldHash, so a concurrent password change
+// is never overwritten. The password itself does not change, so sessions and password_changed_at are kept.
+func (r *AuthRepo) UpdatePasswordHash(ctx context.Context, userID uuid.UUID, oldHash, newHash string) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("update_password_hash", start, err)
+	}(time.Now())
+
+	tag, err := r.pool.Exec(ctx, `UPDATE users SET password_hash = $1 WHERE id = $2 AND password_hash = $3 AND deleted_at IS NULL`,
+		newHash, userID, oldHash)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() != 1 {
+		err = customerrors.ErrNoTagsAffected
+	}
+	return err
+}
+
+// UserTokenVersion returns the token version and the password timestamp of the user and whether it is blocked,
+// pgx.ErrNoRows if the user does not exist or is deleted.
+func (r *AuthRepo) UserTokenVersion(ctx context.Context, userID uuid.UUID) (state entity.TokenState, isBlocked bool, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_token_version", start, err)
+	}(time.Now())
+
+	var passwordChangedAt time.Time
+	err = r.pool.QueryRow(ctx, "SELECT token_version, password_changed_at, is_blocked FROM users WHERE id = $1 AND deleted_at IS NULL", userID).
+		Scan(&state.Version, &passwordChangedAt, &isBlocked)
+	state.PasswordTimestamp = passwordChangedAt.Unix()
+	return state, isBlocked, err
+}
+
+// StoreReceipt saves the issuance receipt of a login or refresh.
+func (r *AuthRepo) StoreReceipt(ctx context.Context, receipt entity.IssuanceReceipt) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("insert_token_receipt", start, err)
+	}(time.Now())
+
+	sql := `INSERT INTO token_receipts (id, user_id, session_id, access_token_hash, refresh_token_hash, issued_at, signature)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err = r.pool.Exec(ctx, sql, receipt.ID, receipt.UserID, receipt.SessionID,
+		receipt.AccessTokenHash, receipt.RefreshTokenHash, receipt.IssuedAt, receipt.Signature)
+	return err
+}
+
+// GetReceipt retrieves a stored issuance receipt by ID, returns pgx.ErrNoRows if it was never stored.
+func (r *AuthRepo) GetReceipt(ctx context.Context, id uuid.UUID) (receipt entity.IssuanceReceipt, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_token_receipt", start, err)
+	}(time.Now())
+
+	sql := `SELECT id, user_id, session_id, access_token_hash, refresh_token_hash, issued_at, signature
+			FROM token_receipts WHERE id = $1`
+	err = r.pool.QueryRow(ctx, sql, id).Scan(&receipt.ID, &receipt.UserID, &receipt.SessionID,
+		&receipt.AccessTokenHash, &receipt.RefreshTokenHash, &receipt.IssuedAt, &receipt.Signature)
+	return receipt, err
 }
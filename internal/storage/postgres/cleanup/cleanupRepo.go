@@ -0,0 +1,102 @@
+package cleanup
+
+import (
+	"context"
+	metrics "main/internal/metrics"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type CleanupRepo struct {
+	pool    *pgxpool.Pool
+	Metrics *metrics.Metrics
+}
+
+func NewCleanupRepo(pool *pgxpool.Pool, metrics *metrics.Metrics) *CleanupRepo {
+	return &CleanupRepo{
+		pool:    pool,
+		Metrics: metrics,
+	}
+}
+
+// DeleteExpiredSessions deletes up to limit sessions that expired before the given time and returns how many.
+// Rows locked by another instance running the job are skipped.
+func (r *CleanupRepo) DeleteExpiredSessions(ctx context.Context, before time.Time, limit int) (deleted int64, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("delete_expired_sessions", start, err)
+	}(time.Now())
+
+	tag, err := r.pool.Exec(ctx, `DELETE FROM sessions WHERE id IN (
+			SELECT id FROM sessions WHERE expires_at < $1 LIMIT $2 FOR UPDATE SKIP LOCKED)`, before, limit)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// CountExpiredSessions counts the sessions that expired before the given time.
+func (r *CleanupRepo) CountExpiredSessions(ctx context.Context, before time.Time) (count int64, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("count_expired_sessions", start, err)
+	}(time.Now())
+
+	err = r.pool.QueryRow(ctx, `SELECT COUNT(*) FROM sessions WHERE expires_at < $1`, before).Scan(&count)
+	return count, err
+}
+
+// overdueSessions selects the live sessions of the client types, or of all the other client types with exclude,
+// that were last used before idleSince or started before startedBefore. A zero time matches no session.
+const overdueSessions = `expires_at > NOW() AND (client_type = ANY($1)) <> $2 AND (last_used_at < $3 OR started_at < $4)`
+
+// EndOverdueSessions expires up to limit overdue sessions now and returns how many. They stay until the retention
+// of expired sessions is over like the others.
+func (r *CleanupRepo) EndOverdueSessions(ctx context.Context, clientTypes []string, exclude bool, idleSince, startedBefore time.Time,
+	limit int) (ended int64, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("end_overdue_sessions", start, err)
+	}(time.Now())
+
+	tag, err := r.pool.Exec(ctx, `UPDATE sessions SET expires_at = NOW() WHERE id IN (
+			SELECT id FROM sessions WHERE `+overdueSessions+` LIMIT $5 FOR UPDATE SKIP LOCKED)`,
+		clientTypes, exclude, idleSince, startedBefore, limit)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// CountOverdueSessions counts the overdue sessions.
+func (r *CleanupRepo) CountOverdueSessions(ctx context.Context, clientTypes []string, exclude bool, idleSince, startedBefore time.Time) (count int64, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("count_overdue_sessions", start, err)
+	}(time.Now())
+
+	err = r.pool.QueryRow(ctx, `SELECT COUNT(*) FROM sessions WHERE `+overdueSessions,
+		clientTypes, exclude, idleSince, startedBefore).Scan(&count)
+	return count, err
+}
+
+// DeleteLoginEvents deletes up to limit login attempts made before the given time and returns how many.
+func (r *CleanupRepo) DeleteLoginEvents(ctx context.Context, before time.Time, limit int) (deleted int64, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("delete_login_events", start, err)
+	}(time.Now())
+
+	tag, err := r.pool.Exec(ctx, `DELETE FROM login_events WHERE id IN (
+			SELECT id FROM login_events WHERE created_at < $1 LIMIT $2 FOR UPDATE SKIP LOCKED)`, before, limit)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// CountLoginEvents counts the login attempts made before the given time.
+func (r *CleanupRepo) CountLoginEvents(ctx context.Context, before time.Time) (count int64, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("count_login_events", start, err)
+	}(time.Now())
+
+	err = r.pool.QueryRow(ctx, `SELECT COUNT(*) FROM login_events WHERE created_at < $1`, before).Scan(&count)
+	return count, err
+}
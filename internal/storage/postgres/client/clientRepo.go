@@ -0,0 +1,44 @@
+package client
+
+import (
+	"context"
+	"main/domain/entity"
+	metrics "main/internal/metrics"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type ClientRepo struct {
+	pool    *pgxpool.Pool
+	Metrics *metrics.Metrics
+}
+
+func NewClientRepo(pool *pgxpool.Pool, metrics *metrics.Metrics) *ClientRepo {
+	return &ClientRepo{
+		pool:    pool,
+		Metrics: metrics,
+	}
+}
+
+// GetClientByID retrieves a service client by its client_id.
+func (r *ClientRepo) GetClientByID(ctx context.Context, clientID string) (client entity.Client, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_client_by_id", start, err)
+	}(time.Now())
+
+	var ttlSeconds int
+	sql := `SELECT id, name, secret_hash, scopes, token_ttl_seconds, created_at, is_disabled
+			FROM clients WHERE id = $1`
+	err = r.pool.QueryRow(ctx, sql, clientID).Scan(
+		&client.ID,
+		&client.Name,
+		&client.SecretHash,
+		&client.Scopes,
+		&ttlSeconds,
+		&client.CreatedAt,
+		&client.IsDisabled,
+	)
+	client.TokenTTL = time.Duration(ttlSeconds) * time.Second
+	return client, err
+}
@@ -4,16 +4,27 @@ import (
 	"context"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-func NewPostgresConnection(dbURL string) (*pgxpool.Pool, error) {
+// NewPostgresConnection connects the pool, tracer (nil for none) observes every query. password (nil for the
+// one of the URL) is asked for the password of every new connection, so that a rotated password is used
+// without restarting.
+func NewPostgresConnection(dbURL string, tracer pgx.QueryTracer, password func() string) (*pgxpool.Pool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	config, err := pgxpool.ParseConfig(dbURL)
 	if err != nil {
 		return nil, err
 	}
+	config.ConnConfig.Tracer = tracer
+	if password != nil {
+		config.BeforeConnect = func(_ context.Context, cc *pgx.ConnConfig) error {
+			cc.Password = password()
+			return nil
+		}
+	}
 
 	pool, err := pgxpool.NewWithConfig(ctx, config)
 	if err != nil {
@@ -0,0 +1,226 @@
+package datakey
+
+import (
+	"context"
+	"fmt"
+	metrics "main/internal/metrics"
+	"main/pkg/fieldcrypt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type DataKeyRepo struct {
+	pool    *pgxpool.Pool
+	Metrics *metrics.Metrics
+}
+
+func NewDataKeyRepo(pool *pgxpool.Pool, metrics *metrics.Metrics) *DataKeyRepo {
+	return &DataKeyRepo{
+		pool:    pool,
+		Metrics: metrics,
+	}
+}
+
+// DataKeys returns the wrapped data keys, oldest first.
+func (r *DataKeyRepo) DataKeys(ctx context.Context) (keys []fieldcrypt.DataKey, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_data_keys", start, err)
+	}(time.Now())
+
+	rows, err := r.pool.Query(ctx, `SELECT id, purpose, wrapped_key, created_at FROM data_keys ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	keys, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (fieldcrypt.DataKey, error) {
+		var k fieldcrypt.DataKey
+		err := row.Scan(&k.ID, &k.Purpose, &k.Wrapped, &k.CreatedAt)
+		return k, err
+	})
+	return keys, err
+}
+
+// StoreDataKey adds a wrapped data key. An index key is not added when there is one, the one of the instance
+// that stored it first is kept.
+func (r *DataKeyRepo) StoreDataKey(ctx context.Context, purpose string, wrapped []byte) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("insert_data_key", start, err)
+	}(time.Now())
+
+	_, err = r.pool.Exec(ctx, `INSERT INTO data_keys (purpose, wrapped_key) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+		purpose, wrapped)
+	return err
+}
+
+// ReplaceWrappedKeys stores the keys wrapped again with another master key, in one transaction.
+func (r *DataKeyRepo) ReplaceWrappedKeys(ctx context.Context, keys []fieldcrypt.DataKey) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("update_data_keys", start, err)
+	}(time.Now())
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	for _, k := range keys {
+		if _, err = tx.Exec(ctx, `UPDATE data_keys SET wrapped_key = $1 WHERE id = $2`, k.Wrapped, k.ID); err != nil {
+			return err
+		}
+	}
+	err = tx.Commit(ctx)
+	return err
+}
+
+// piiColumn is a column holding personal data, index columns hold a blind index instead of a ciphertext.
+type piiColumn struct {
+	name  string
+	index bool
+}
+
+// piiTables are the columns encrypted by the repositories. The append-only audit log cannot be updated, its
+// rows written before encryption was enabled stay in plaintext.
+var piiTables = []struct {
+	name, key string
+	columns   []piiColumn
+}{
+	{"users", "id", []piiColumn{{"email", false}, {"canonical_email", true}}},
+	{"email_verifications", "token_hash", []piiColumn{{"email", false}}},
+	{"email_changes", "token_hash", []piiColumn{{"new_email", false}, {"new_canonical_email", true}}},
+	{"sessions", "id", []piiColumn{{"user_agent", false}, {"ip_address", false}}},
+	{"login_events", "id", []piiColumn{{"user_agent", false}, {"ip_address", false}}},
+	{"trusted_devices", "id", []piiColumn{{"user_agent", false}, {"ip_address", false}}},
+}
+
+// EncryptPII encrypts the personal data stored in plaintext, batchSize rows per transaction, and returns the
+// number of rows it updated per table. Blind indexes cannot be decrypted, so decrypting restores the canonical
+// emails from the decrypted emails with canonical. decrypt stores everything in plaintext again, to disable
+// the encryption.
+func (r *DataKeyRepo) EncryptPII(ctx context.Context, crypt *fieldcrypt.Cipher, canonical func(string) string,
+	decrypt bool, batchSize int) (updated map[string]int64, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("encrypt_pii", start, err)
+	}(time.Now())
+
+	updated = make(map[string]int64)
+	for _, table := range piiTables {
+		var names, pending []string
+		for _, c := range table.columns {
+			names = append(names, c.name)
+			if decrypt {
+				pending = append(pending, fmt.Sprintf(`%s LIKE 'enc:%%' OR %s LIKE 'idx:%%'`, c.name, c.name))
+			} else {
+				pending = append(pending, fmt.Sprintf(`(%s <> '' AND %s NOT LIKE 'enc:%%' AND %s NOT LIKE 'idx:%%')`,
+					c.name, c.name, c.name))
+			}
+		}
+		query := fmt.Sprintf(`SELECT %s, %s FROM %s WHERE %s LIMIT %d FOR UPDATE SKIP LOCKED`,
+			table.key, strings.Join(names, ", "), table.name, strings.Join(pending, " OR "), batchSize)
+		for {
+			n, err := r.encryptBatch(ctx, crypt, canonical, decrypt, table.name, table.key, table.columns, query)
+			if err != nil {
+				return updated, fmt.Errorf("%s: %w", table.name, err)
+			}
+			updated[table.name] += n
+			if n == 0 {
+				break
+			}
+		}
+	}
+	return updated, nil
+}
+
+// encryptBatch converts the rows selected by query in one transaction and returns how many it updated.
+func (r *DataKeyRepo) encryptBatch(ctx context.Context, crypt *fieldcrypt.Cipher, canonical func(string) string,
+	decrypt bool, table, key string, columns []piiColumn, query string) (int64, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	var batch [][]any
+	for rows.Next() {
+		var id any
+		values := make([]*string, len(columns))
+		dest := []any{&id}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		args := []any{id}
+		plaintext := ""
+		for i, c := range columns {
+			value, err := convertPII(crypt, canonical, decrypt, c.index, values[i], plaintext)
+			if err != nil {
+				rows.Close()
+				return 0, err
+			}
+			// an index column follows the column it is the index of
+			if values[i] != nil && !c.index {
+				if plaintext, err = crypt.Decrypt(*values[i]); err != nil {
+					rows.Close()
+					return 0, err
+				}
+			}
+			args = append(args, value)
+		}
+		batch = append(batch, args)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var set []string
+	for i, c := range columns {
+		set = append(set, fmt.Sprintf("%s = $%d", c.name, i+2))
+	}
+	update := fmt.Sprintf(`UPDATE %s SET %s WHERE %s = $1`, table, strings.Join(set, ", "), key)
+	for _, args := range batch {
+		if _, err := tx.Exec(ctx, update, args...); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(batch)), tx.Commit(ctx)
+}
+
+// convertPII returns the value of a column encrypted, or decrypted when decrypt is set. An index column is
+// derived from the plaintext of the column before it.
+func convertPII(crypt *fieldcrypt.Cipher, canonical func(string) string, decrypt, index bool, value *string,
+	plaintext string) (*string, error) {
+	if value == nil || *value == "" {
+		return value, nil
+	}
+	var converted string
+	switch {
+	case index && decrypt:
+		converted = canonical(plaintext)
+	case index:
+		converted = *value
+		if !fieldcrypt.Encrypted(converted) {
+			converted = crypt.Index(converted)
+		}
+	case decrypt:
+		var err error
+		if converted, err = crypt.Decrypt(*value); err != nil {
+			return nil, err
+		}
+	default:
+		converted = *value
+		if !fieldcrypt.Encrypted(converted) {
+			converted = crypt.Encrypt(converted)
+		}
+	}
+	return &converted, nil
+}
@@ -0,0 +1,144 @@
+package feature
+
+import (
+	"context"
+	"main/domain/entity"
+	metrics "main/internal/metrics"
+	"main/pkg/customerrors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type FeatureRepo struct {
+	pool    *pgxpool.Pool
+	Metrics *metrics.Metrics
+}
+
+func NewFeatureRepo(pool *pgxpool.Pool, metrics *metrics.Metrics) *FeatureRepo {
+	return &FeatureRepo{
+		pool:    pool,
+		Metrics: metrics,
+	}
+}
+
+const flagColumns = `f.name, f.percentage, (SELECT COUNT(*) FROM feature_flag_users u WHERE u.feature = f.name), f.updated_at, f.updated_by`
+
+func scanFlag(row pgx.CollectableRow) (entity.FeatureFlag, error) {
+	var f entity.FeatureFlag
+	err := row.Scan(&f.Name, &f.Percentage, &f.Allowlisted, &f.UpdatedAt, &f.UpdatedBy)
+	return f, err
+}
+
+// ListFlags returns the rollouts of all features by name.
+func (r *FeatureRepo) ListFlags(ctx context.Context) (flags []entity.FeatureFlag, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_feature_flags", start, err)
+	}(time.Now())
+
+	rows, err := r.pool.Query(ctx, `SELECT `+flagColumns+` FROM feature_flags f ORDER BY f.name`)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, scanFlag)
+}
+
+// SetPercentage changes the percentage of the rollout of the feature, creating its flag if there is none.
+func (r *FeatureRepo) SetPercentage(ctx context.Context, feature entity.Feature, percentage int, adminID uuid.UUID) (flag entity.FeatureFlag, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("upsert_feature_flag", start, err)
+	}(time.Now())
+
+	rows, err := r.pool.Query(ctx, `INSERT INTO feature_flags AS f (name, percentage, updated_at, updated_by)
+			VALUES ($1, $2, NOW(), $3)
+			ON CONFLICT (name) DO UPDATE SET percentage = EXCLUDED.percentage, updated_at = NOW(), updated_by = EXCLUDED.updated_by
+			RETURNING `+flagColumns, feature, percentage, adminID)
+	if err != nil {
+		return entity.FeatureFlag{}, err
+	}
+	return pgx.CollectExactlyOneRow(rows, scanFlag)
+}
+
+// AllowUser adds the user to the allowlist of the feature, a user already on it is kept with its first addition.
+// It returns customerrors.ErrUserNotFound if the user does not exist.
+func (r *FeatureRepo) AllowUser(ctx context.Context, feature entity.Feature, userID, adminID uuid.UUID) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("insert_feature_flag_user", start, err)
+	}(time.Now())
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var exists bool
+	err = tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND deleted_at IS NULL)`, userID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return customerrors.ErrUserNotFound
+	}
+	// the flag of a feature that was never rolled out starts at 0 percent
+	_, err = tx.Exec(ctx, `INSERT INTO feature_flags (name, percentage, updated_by) VALUES ($1, 0, $2)
+			ON CONFLICT (name) DO NOTHING`, feature, adminID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `INSERT INTO feature_flag_users (feature, user_id, added_by) VALUES ($1, $2, $3)
+			ON CONFLICT (feature, user_id) DO NOTHING`, feature, userID, adminID)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit(ctx)
+	return err
+}
+
+// DisallowUser removes the user from the allowlist of the feature, returns customerrors.ErrUserNotAllowlisted
+// if it is not on it.
+func (r *FeatureRepo) DisallowUser(ctx context.Context, feature entity.Feature, userID uuid.UUID) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("delete_feature_flag_user", start, err)
+	}(time.Now())
+
+	tag, err := r.pool.Exec(ctx, `DELETE FROM feature_flag_users WHERE feature = $1 AND user_id = $2`, feature, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return customerrors.ErrUserNotAllowlisted
+	}
+	return nil
+}
+
+// ListAllowedUsers returns a page of the allowlist of the feature, the latest addition first.
+func (r *FeatureRepo) ListAllowedUsers(ctx context.Context, feature entity.Feature, limit, offset int) (users []entity.FeatureUser, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_feature_flag_users", start, err)
+	}(time.Now())
+
+	rows, err := r.pool.Query(ctx, `SELECT user_id, added_at, added_by FROM feature_flag_users WHERE feature = $1
+			ORDER BY added_at DESC, user_id LIMIT $2 OFFSET $3`, feature, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (entity.FeatureUser, error) {
+		var u entity.FeatureUser
+		err := row.Scan(&u.UserID, &u.AddedAt, &u.AddedBy)
+		return u, err
+	})
+}
+
+// IsAllowed reports whether the user is on the allowlist of the feature.
+func (r *FeatureRepo) IsAllowed(ctx context.Context, feature entity.Feature, userID uuid.UUID) (allowed bool, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_feature_flag_user", start, err)
+	}(time.Now())
+
+	err = r.pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM feature_flag_users WHERE feature = $1 AND user_id = $2)`,
+		feature, userID).Scan(&allowed)
+	return allowed, err
+}
@@ -0,0 +1,82 @@
+package handle
+
+import (
+	"context"
+	"main/domain/entity"
+	metrics "main/internal/metrics"
+	"main/pkg/customerrors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type HandleRepo struct {
+	pool    *pgxpool.Pool
+	Metrics *metrics.Metrics
+}
+
+func NewHandleRepo(pool *pgxpool.Pool, metrics *metrics.Metrics) *HandleRepo {
+	return &HandleRepo{
+		pool:    pool,
+		Metrics: metrics,
+	}
+}
+
+// BanHandle stores the ban, banning a handle again replaces the reason and the author of the ban.
+func (r *HandleRepo) BanHandle(ctx context.Context, ban entity.BannedHandle) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("insert_banned_handle", start, err)
+	}(time.Now())
+
+	_, err = r.pool.Exec(ctx, `INSERT INTO banned_handles (tenant, handle, reason, created_by, created_at)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5)
+		ON CONFLICT (tenant, handle) DO UPDATE SET reason = EXCLUDED.reason, created_by = EXCLUDED.created_by, created_at = EXCLUDED.created_at`,
+		ban.Tenant, ban.Handle, ban.Reason, ban.CreatedBy, ban.CreatedAt)
+	return err
+}
+
+// UnbanHandle removes the ban, returns customerrors.ErrNoTagsAffected if there is none.
+func (r *HandleRepo) UnbanHandle(ctx context.Context, tenant, handle string) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("delete_banned_handle", start, err)
+	}(time.Now())
+
+	tag, err := r.pool.Exec(ctx, `DELETE FROM banned_handles WHERE tenant = $1 AND handle = $2`, tenant, handle)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return customerrors.ErrNoTagsAffected
+	}
+	return nil
+}
+
+// ListBannedHandles returns the bans of the tenant in alphabetical order.
+func (r *HandleRepo) ListBannedHandles(ctx context.Context, tenant string) (bans []entity.BannedHandle, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_banned_handles", start, err)
+	}(time.Now())
+
+	rows, err := r.pool.Query(ctx, `SELECT handle, tenant, COALESCE(reason, ''), created_by, created_at
+		FROM banned_handles WHERE tenant = $1 ORDER BY handle`, tenant)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (entity.BannedHandle, error) {
+		var b entity.BannedHandle
+		err := row.Scan(&b.Handle, &b.Tenant, &b.Reason, &b.CreatedBy, &b.CreatedAt)
+		return b, err
+	})
+}
+
+// HandleBanned reports whether one of the handles is banned on every domain or on the tenant.
+func (r *HandleRepo) HandleBanned(ctx context.Context, tenant string, handles []string) (banned bool, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_handle_banned", start, err)
+	}(time.Now())
+
+	err = r.pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM banned_handles
+		WHERE tenant IN ('', $1) AND handle = ANY($2))`, tenant, handles).Scan(&banned)
+	return banned, err
+}
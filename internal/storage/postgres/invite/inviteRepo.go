@@ -0,0 +1,75 @@
+package invite
+
+import (
+	"context"
+	"main/domain/entity"
+	metrics "main/internal/metrics"
+	"main/pkg/customerrors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type InviteRepo struct {
+	pool    *pgxpool.Pool
+	Metrics *metrics.Metrics
+}
+
+func NewInviteRepo(pool *pgxpool.Pool, metrics *metrics.Metrics) *InviteRepo {
+	return &InviteRepo{
+		pool:    pool,
+		Metrics: metrics,
+	}
+}
+
+// StoreInvitation saves the invitation with the hash of its code.
+func (r *InviteRepo) StoreInvitation(ctx context.Context, inv entity.Invitation, codeHash []byte) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("insert_invitation", start, err)
+	}(time.Now())
+
+	sql := `INSERT INTO invitations (id, code_hash, max_uses, expires_at, created_by, created_at) VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err = r.pool.Exec(ctx, sql, inv.ID, codeHash, inv.MaxUses, inv.ExpiresAt, inv.CreatedBy, inv.CreatedAt)
+	return err
+}
+
+// ListInvitations returns the invitations that can still be used, newest first.
+func (r *InviteRepo) ListInvitations(ctx context.Context) (invitations []entity.Invitation, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_invitations", start, err)
+	}(time.Now())
+
+	sql := `SELECT id, max_uses, uses, expires_at, revoked_at, COALESCE(created_by, '00000000-0000-0000-0000-000000000000'), created_at
+			FROM invitations
+			WHERE revoked_at IS NULL AND expires_at > NOW() AND uses < max_uses
+			ORDER BY created_at DESC`
+	rows, err := r.pool.Query(ctx, sql)
+	if err != nil {
+		return nil, err
+	}
+	invitations, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (entity.Invitation, error) {
+		var inv entity.Invitation
+		err := row.Scan(&inv.ID, &inv.MaxUses, &inv.Uses, &inv.ExpiresAt, &inv.RevokedAt, &inv.CreatedBy, &inv.CreatedAt)
+		return inv, err
+	})
+	return invitations, err
+}
+
+// RevokeInvitation makes the invitation unusable. Returns customerrors.ErrNoTagsAffected if it does not exist
+// or is already revoked.
+func (r *InviteRepo) RevokeInvitation(ctx context.Context, id uuid.UUID) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("revoke_invitation", start, err)
+	}(time.Now())
+
+	tag, err := r.pool.Exec(ctx, `UPDATE invitations SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() != 1 {
+		err = customerrors.ErrNoTagsAffected
+	}
+	return err
+}
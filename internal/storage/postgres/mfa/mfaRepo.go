@@ -0,0 +1,272 @@
+package mfa
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"main/domain/entity"
+	metrics "main/internal/metrics"
+	"main/pkg/customerrors"
+	"main/pkg/fieldcrypt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type MFARepo struct {
+	pool    *pgxpool.Pool
+	Metrics *metrics.Metrics
+	// crypt encrypts the IP addresses and user agents of trusted devices, nil stores them in plaintext
+	crypt *fieldcrypt.Cipher
+}
+
+func NewMFARepo(pool *pgxpool.Pool, metrics *metrics.Metrics, crypt *fieldcrypt.Cipher) *MFARepo {
+	return &MFARepo{
+		pool:    pool,
+		Metrics: metrics,
+		crypt:   crypt,
+	}
+}
+
+// UserMFAMethod returns the default second factor of the user, empty when two-factor authentication is off.
+func (r *MFARepo) UserMFAMethod(ctx context.Context, userID uuid.UUID) (method entity.MFAMethod, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_mfa_method", start, err)
+	}(time.Now())
+
+	var m *string
+	err = r.pool.QueryRow(ctx, `SELECT mfa_method FROM users WHERE id = $1 AND deleted_at IS NULL`, userID).Scan(&m)
+	if err != nil || m == nil {
+		return "", err
+	}
+	return entity.MFAMethod(*m), nil
+}
+
+// SetUserMFAMethod sets the default second factor of the user, an empty method turns two-factor authentication off.
+func (r *MFARepo) SetUserMFAMethod(ctx context.Context, userID uuid.UUID, method entity.MFAMethod) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("update_mfa_method", start, err)
+	}(time.Now())
+
+	var m *string
+	if method != "" {
+		m = (*string)(&method)
+	}
+	tag, err := r.pool.Exec(ctx, `UPDATE users SET mfa_method = $2 WHERE id = $1 AND deleted_at IS NULL`, userID, m)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// CreateMFAChallenge stores a new challenge with the hash of its first code and deletes the expired ones.
+func (r *MFARepo) CreateMFAChallenge(ctx context.Context, c entity.MFAChallenge, codeHash []byte) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("insert_mfa_challenge", start, err)
+	}(time.Now())
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err = tx.Exec(ctx, `DELETE FROM mfa_challenges WHERE expires_at <= NOW()`); err != nil {
+		return err
+	}
+	sql := `INSERT INTO mfa_challenges (id, user_id, method, code_hash, expires_at) VALUES ($1, $2, $3, $4, $5)`
+	if _, err = tx.Exec(ctx, sql, c.ID, c.UserID, c.Method, codeHash, c.ExpiresAt); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// ResendMFACode replaces the code of the challenge, resets its attempts and extends it until expiresAt.
+// It returns customerrors.ErrResendThrottled when the last code is younger than cooldown or maxSends codes
+// were sent, and customerrors.ErrInvalidOTP for unknown and expired challenges.
+func (r *MFARepo) ResendMFACode(ctx context.Context, id uuid.UUID, codeHash []byte, expiresAt time.Time, cooldown time.Duration, maxSends int) (c entity.MFAChallenge, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("resend_mfa_code", start, err)
+	}(time.Now())
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return entity.MFAChallenge{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	var sends int
+	var tooSoon bool
+	err = tx.QueryRow(ctx, `SELECT id, user_id, method, sends, last_sent_at > NOW() - make_interval(secs => $2)
+			FROM mfa_challenges WHERE id = $1 AND expires_at > NOW() FOR UPDATE`,
+		id, cooldown.Seconds()).Scan(&c.ID, &c.UserID, &c.Method, &sends, &tooSoon)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return entity.MFAChallenge{}, customerrors.ErrInvalidOTP
+	}
+	if err != nil {
+		return entity.MFAChallenge{}, err
+	}
+	if sends >= maxSends || tooSoon {
+		return entity.MFAChallenge{}, customerrors.ErrResendThrottled
+	}
+
+	sql := `UPDATE mfa_challenges SET code_hash = $2, attempts = 0, sends = sends + 1, last_sent_at = NOW(), expires_at = $3
+			WHERE id = $1`
+	if _, err = tx.Exec(ctx, sql, id, codeHash, expiresAt); err != nil {
+		return entity.MFAChallenge{}, err
+	}
+	c.ExpiresAt = expiresAt
+	return c, tx.Commit(ctx)
+}
+
+// ConsumeMFAChallenge checks the code of the challenge and deletes the challenge when it matches, returning its user.
+// A wrong code counts as an attempt, once maxAttempts are used up the challenge is deleted and
+// customerrors.ErrTooManyAttempts returned with the user of the challenge. Unknown, expired and wrong codes
+// return customerrors.ErrInvalidOTP.
+func (r *MFARepo) ConsumeMFAChallenge(ctx context.Context, id uuid.UUID, codeHash []byte, maxAttempts int) (userID uuid.UUID, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("consume_mfa_challenge", start, err)
+	}(time.Now())
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var storedHash []byte
+	var attempts int
+	err = tx.QueryRow(ctx, `SELECT user_id, code_hash, attempts FROM mfa_challenges WHERE id = $1 AND expires_at > NOW() FOR UPDATE`,
+		id).Scan(&userID, &storedHash, &attempts)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return uuid.Nil, customerrors.ErrInvalidOTP
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	if subtle.ConstantTimeCompare(storedHash, codeHash) == 1 {
+		if _, err = tx.Exec(ctx, `DELETE FROM mfa_challenges WHERE id = $1`, id); err != nil {
+			return uuid.Nil, err
+		}
+		return userID, tx.Commit(ctx)
+	}
+
+	result := customerrors.ErrInvalidOTP
+	if attempts+1 >= maxAttempts {
+		_, err = tx.Exec(ctx, `DELETE FROM mfa_challenges WHERE id = $1`, id)
+		result = customerrors.ErrTooManyAttempts
+	} else {
+		_, err = tx.Exec(ctx, `UPDATE mfa_challenges SET attempts = attempts + 1 WHERE id = $1`, id)
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return uuid.Nil, err
+	}
+	if errors.Is(result, customerrors.ErrTooManyAttempts) {
+		return userID, result
+	}
+	return uuid.Nil, result
+}
+
+// CreateTrustedDevice stores a trusted device of the user and deletes the expired ones.
+func (r *MFARepo) CreateTrustedDevice(ctx context.Context, d entity.TrustedDevice) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("insert_trusted_device", start, err)
+	}(time.Now())
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err = tx.Exec(ctx, `DELETE FROM trusted_devices WHERE expires_at <= NOW()`); err != nil {
+		return err
+	}
+	sql := `INSERT INTO trusted_devices (id, user_id, user_agent, ip_address, created_at, expires_at) VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err = tx.Exec(ctx, sql, d.ID, d.UserID, r.crypt.Encrypt(d.UserAgent), r.crypt.EncryptAddr(d.IP), d.CreatedAt, d.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// UseTrustedDevice records a login on the device, pgx.ErrNoRows if the user has no such unexpired device.
+func (r *MFARepo) UseTrustedDevice(ctx context.Context, userID, deviceID uuid.UUID) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("update_trusted_device", start, err)
+	}(time.Now())
+
+	tag, err := r.pool.Exec(ctx, `UPDATE trusted_devices SET last_used_at = NOW() WHERE id = $1 AND user_id = $2 AND expires_at > NOW()`,
+		deviceID, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// ListTrustedDevices returns the unexpired trusted devices of the user, the most recently trusted first.
+func (r *MFARepo) ListTrustedDevices(ctx context.Context, userID uuid.UUID) (devices []entity.TrustedDevice, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_trusted_devices", start, err)
+	}(time.Now())
+
+	rows, err := r.pool.Query(ctx, `SELECT id, user_id, user_agent, ip_address, created_at, last_used_at, expires_at
+		FROM trusted_devices WHERE user_id = $1 AND expires_at > NOW() ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	devices, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (entity.TrustedDevice, error) {
+		var d entity.TrustedDevice
+		var ip *string
+		err := row.Scan(&d.ID, &d.UserID, &d.UserAgent, &ip, &d.CreatedAt, &d.LastUsedAt, &d.ExpiresAt)
+		if err == nil {
+			d.UserAgent, err = r.crypt.Decrypt(d.UserAgent)
+		}
+		if err == nil {
+			d.IP, err = r.crypt.DecryptAddr(ip)
+		}
+		return d, err
+	})
+	return devices, err
+}
+
+// DeleteTrustedDevice revokes a trusted device of the user, customerrors.ErrNoTagsAffected if there is none.
+func (r *MFARepo) DeleteTrustedDevice(ctx context.Context, userID, deviceID uuid.UUID) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("delete_trusted_device", start, err)
+	}(time.Now())
+
+	tag, err := r.pool.Exec(ctx, `DELETE FROM trusted_devices WHERE id = $1 AND user_id = $2`, deviceID, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return customerrors.ErrNoTagsAffected
+	}
+	return nil
+}
+
+// DeleteTrustedDevices revokes every trusted device of the user and returns how many there were.
+func (r *MFARepo) DeleteTrustedDevices(ctx context.Context, userID uuid.UUID) (n int64, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("delete_trusted_devices", start, err)
+	}(time.Now())
+
+	tag, err := r.pool.Exec(ctx, `DELETE FROM trusted_devices WHERE user_id = $1`, userID)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,293 @@
+package organization
+
+import (
+	"context"
+	"main/domain/entity"
+	metrics "main/internal/metrics"
+	"main/pkg/customerrors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type OrgRepo struct {
+	pool    *pgxpool.Pool
+	Metrics *metrics.Metrics
+}
+
+func NewOrgRepo(pool *pgxpool.Pool, metrics *metrics.Metrics) *OrgRepo {
+	return &OrgRepo{
+		pool:    pool,
+		Metrics: metrics,
+	}
+}
+
+const orgColumns = `id, name, status, created_at, suspended_at, deleted_at, purge_at`
+
+func scanOrganization(row pgx.Row) (entity.Organization, error) {
+	var org entity.Organization
+	err := row.Scan(&org.ID, &org.Name, &org.Status, &org.CreatedAt, &org.SuspendedAt, &org.DeletedAt, &org.PurgeAt)
+	return org, err
+}
+
+// CreateOrganization stores a new active organization and records the action.
+func (r *OrgRepo) CreateOrganization(ctx context.Context, org entity.Organization, action entity.AdminAction) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("insert_organization", start, err)
+	}(time.Now())
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `INSERT INTO organizations (id, name, status, created_at) VALUES ($1, $2, $3, $4)`,
+		org.ID, org.Name, org.Status, org.CreatedAt)
+	if err != nil {
+		return err
+	}
+	if err = insertAction(ctx, tx, action); err != nil {
+		return err
+	}
+	err = tx.Commit(ctx)
+	return err
+}
+
+// GetOrganization returns the organization with its members, pgx.ErrNoRows if it does not exist.
+func (r *OrgRepo) GetOrganization(ctx context.Context, id uuid.UUID) (detail entity.OrganizationDetail, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_organization", start, err)
+	}(time.Now())
+
+	detail.Organization, err = scanOrganization(r.pool.QueryRow(ctx, `SELECT `+orgColumns+` FROM organizations WHERE id = $1`, id))
+	if err != nil {
+		return entity.OrganizationDetail{}, err
+	}
+	rows, err := r.pool.Query(ctx, `SELECT user_id, created_at FROM organization_members WHERE organization_id = $1 ORDER BY created_at`, id)
+	if err != nil {
+		return entity.OrganizationDetail{}, err
+	}
+	detail.Members, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (entity.OrgMember, error) {
+		var m entity.OrgMember
+		err := row.Scan(&m.UserID, &m.CreatedAt)
+		return m, err
+	})
+	return detail, err
+}
+
+// ListOrganizations returns a page of organizations, newest first, including deleted ones not yet purged.
+func (r *OrgRepo) ListOrganizations(ctx context.Context, limit, offset int) (orgs []entity.Organization, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_organizations", start, err)
+	}(time.Now())
+
+	rows, err := r.pool.Query(ctx, `SELECT `+orgColumns+` FROM organizations ORDER BY created_at DESC LIMIT $1 OFFSET $2`, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	orgs, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (entity.Organization, error) {
+		return scanOrganization(row)
+	})
+	return orgs, err
+}
+
+// SuspendOrganization suspends an active organization, revokes the access tokens of all its members and records
+// the action in one transaction. Their sessions are ended in the session store.
+func (r *OrgRepo) SuspendOrganization(ctx context.Context, action entity.AdminAction) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("suspend_organization", start, err)
+	}(time.Now())
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err = checkStatus(ctx, tx, action.TargetID, entity.OrgActive); err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `UPDATE organizations SET status = $1, suspended_at = NOW() WHERE id = $2`, entity.OrgSuspended, action.TargetID)
+	if err != nil {
+		return err
+	}
+	if err = revokeMemberTokens(ctx, tx, action.TargetID); err != nil {
+		return err
+	}
+	if err = insertAction(ctx, tx, action); err != nil {
+		return err
+	}
+	err = tx.Commit(ctx)
+	return err
+}
+
+// ResumeOrganization reactivates a suspended organization and records the action.
+func (r *OrgRepo) ResumeOrganization(ctx context.Context, action entity.AdminAction) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("resume_organization", start, err)
+	}(time.Now())
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err = checkStatus(ctx, tx, action.TargetID, entity.OrgSuspended); err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `UPDATE organizations SET status = $1, suspended_at = NULL WHERE id = $2`, entity.OrgActive, action.TargetID)
+	if err != nil {
+		return err
+	}
+	if err = insertAction(ctx, tx, action); err != nil {
+		return err
+	}
+	err = tx.Commit(ctx)
+	return err
+}
+
+// DeleteOrganization marks an active or suspended organization as deleted and schedules its purge, revokes the
+// access tokens of all its members and records the action in one transaction. Their sessions are ended in the
+// session store.
+func (r *OrgRepo) DeleteOrganization(ctx context.Context, action entity.AdminAction, purgeAt time.Time) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("delete_organization", start, err)
+	}(time.Now())
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err = checkStatus(ctx, tx, action.TargetID, entity.OrgActive, entity.OrgSuspended); err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `UPDATE organizations SET status = $1, deleted_at = NOW(), purge_at = $2 WHERE id = $3`,
+		entity.OrgDeleted, purgeAt, action.TargetID)
+	if err != nil {
+		return err
+	}
+	if err = revokeMemberTokens(ctx, tx, action.TargetID); err != nil {
+		return err
+	}
+	if err = insertAction(ctx, tx, action); err != nil {
+		return err
+	}
+	err = tx.Commit(ctx)
+	return err
+}
+
+// AddMember adds the user to an active organization, adding an existing member does nothing.
+// Returns customerrors.ErrUserNotFound if the user does not exist.
+func (r *OrgRepo) AddMember(ctx context.Context, orgID, userID uuid.UUID) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("insert_organization_member", start, err)
+	}(time.Now())
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err = checkStatus(ctx, tx, orgID, entity.OrgActive); err != nil {
+		return err
+	}
+	var exists bool
+	err = tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND deleted_at IS NULL)`, userID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		err = customerrors.ErrUserNotFound
+		return err
+	}
+	_, err = tx.Exec(ctx, `INSERT INTO organization_members (organization_id, user_id, created_at) VALUES ($1, $2, NOW())
+			ON CONFLICT DO NOTHING`, orgID, userID)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit(ctx)
+	return err
+}
+
+// RemoveMember removes the user from the organization. Returns customerrors.ErrNoTagsAffected if the user
+// is not a member.
+func (r *OrgRepo) RemoveMember(ctx context.Context, orgID, userID uuid.UUID) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("delete_organization_member", start, err)
+	}(time.Now())
+
+	tag, err := r.pool.Exec(ctx, `DELETE FROM organization_members WHERE organization_id = $1 AND user_id = $2`, orgID, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() != 1 {
+		err = customerrors.ErrNoTagsAffected
+	}
+	return err
+}
+
+// PurgeDeletedOrganizations permanently deletes the organizations whose purge time is before the given time
+// and returns their IDs. Memberships are removed by the ON DELETE CASCADE foreign key, member accounts are kept.
+func (r *OrgRepo) PurgeDeletedOrganizations(ctx context.Context, before time.Time) (ids []uuid.UUID, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("purge_deleted_organizations", start, err)
+	}(time.Now())
+
+	rows, err := r.pool.Query(ctx, `DELETE FROM organizations WHERE status = $1 AND purge_at <= $2 RETURNING id`, entity.OrgDeleted, before)
+	if err != nil {
+		return nil, err
+	}
+	ids, err = pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	return ids, err
+}
+
+// ListDeletedOrganizations returns the IDs of the organizations PurgeDeletedOrganizations would delete.
+func (r *OrgRepo) ListDeletedOrganizations(ctx context.Context, before time.Time) (ids []uuid.UUID, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_deleted_organizations", start, err)
+	}(time.Now())
+
+	rows, err := r.pool.Query(ctx, `SELECT id FROM organizations WHERE status = $1 AND purge_at <= $2`, entity.OrgDeleted, before)
+	if err != nil {
+		return nil, err
+	}
+	ids, err = pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	return ids, err
+}
+
+// checkStatus locks the organization for the transaction and checks that it is in one of the given states.
+// Returns pgx.ErrNoRows if it does not exist and customerrors.ErrOrganizationState if it is in another state.
+func checkStatus(ctx context.Context, tx pgx.Tx, id uuid.UUID, allowed ...entity.OrgStatus) error {
+	var status entity.OrgStatus
+	if err := tx.QueryRow(ctx, `SELECT status FROM organizations WHERE id = $1 FOR UPDATE`, id).Scan(&status); err != nil {
+		return err
+	}
+	for _, s := range allowed {
+		if s == status {
+			return nil
+		}
+	}
+	return customerrors.ErrOrganizationState
+}
+
+// revokeMemberTokens increments the token versions of all members of the organization.
+func revokeMemberTokens(ctx context.Context, tx pgx.Tx, orgID uuid.UUID) error {
+	_, err := tx.Exec(ctx, `UPDATE users SET token_version = token_version + 1
+			WHERE id IN (SELECT user_id FROM organization_members WHERE organization_id = $1)`, orgID)
+	return err
+}
+
+// insertAction records the tenant-level audit entry of a lifecycle action.
+func insertAction(ctx context.Context, tx pgx.Tx, action entity.AdminAction) error {
+	_, err := tx.Exec(ctx, `INSERT INTO audit_events (id, actor_id, action, target_type, target_id, reason_code, reason, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		action.ID, action.ActorID, action.Action, action.TargetType, action.TargetID, action.Reason.Code, action.Reason.Text, action.CreatedAt)
+	return err
+}
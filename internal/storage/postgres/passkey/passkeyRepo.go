@@ -0,0 +1,166 @@
+package passkey
+
+import (
+	"context"
+	"main/domain/entity"
+	metrics "main/internal/metrics"
+	"main/pkg/customerrors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type PasskeyRepo struct {
+	pool    *pgxpool.Pool
+	Metrics *metrics.Metrics
+}
+
+func NewPasskeyRepo(pool *pgxpool.Pool, metrics *metrics.Metrics) *PasskeyRepo {
+	return &PasskeyRepo{
+		pool:    pool,
+		Metrics: metrics,
+	}
+}
+
+// StoreChallenge saves a pending ceremony and deletes the expired ones.
+func (r *PasskeyRepo) StoreChallenge(ctx context.Context, c entity.PasskeyChallenge) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("insert_passkey_challenge", start, err)
+	}(time.Now())
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err = tx.Exec(ctx, `DELETE FROM passkey_challenges WHERE expires_at <= NOW()`); err != nil {
+		return err
+	}
+	sql := `INSERT INTO passkey_challenges (id, ceremony, user_id, challenge, expires_at) VALUES ($1, $2, $3, $4, $5)`
+	if _, err = tx.Exec(ctx, sql, c.ID, c.Ceremony, nullableUserID(c.UserID), c.Challenge, c.ExpiresAt); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// ConsumeChallenge deletes the pending ceremony and returns it, unknown and expired ceremonies return pgx.ErrNoRows.
+func (r *PasskeyRepo) ConsumeChallenge(ctx context.Context, id uuid.UUID, ceremony string) (c entity.PasskeyChallenge, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("consume_passkey_challenge", start, err)
+	}(time.Now())
+
+	var userID *uuid.UUID
+	sql := `DELETE FROM passkey_challenges WHERE id = $1 AND ceremony = $2 AND expires_at > NOW()
+			RETURNING id, ceremony, user_id, challenge, expires_at`
+	err = r.pool.QueryRow(ctx, sql, id, ceremony).Scan(&c.ID, &c.Ceremony, &userID, &c.Challenge, &c.ExpiresAt)
+	if err != nil {
+		return entity.PasskeyChallenge{}, err
+	}
+	if userID != nil {
+		c.UserID = *userID
+	}
+	return c, nil
+}
+
+// CreatePasskey stores a verified credential, returns customerrors.ErrPasskeyExists when its ID is already registered.
+func (r *PasskeyRepo) CreatePasskey(ctx context.Context, p entity.Passkey) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("insert_passkey", start, err)
+	}(time.Now())
+
+	sql := `INSERT INTO passkeys (credential_id, user_id, name, public_key, sign_count, aaguid, transports,
+				backup_eligible, backup_state, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			ON CONFLICT (credential_id) DO NOTHING`
+	tag, err := r.pool.Exec(ctx, sql, p.CredentialID, p.UserID, p.Name, p.PublicKey, int64(p.SignCount), p.AAGUID,
+		p.Transports, p.BackupEligible, p.BackupState, p.CreatedAt)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return customerrors.ErrPasskeyExists
+	}
+	return nil
+}
+
+// GetPasskey returns the credential with the ID. Credentials of soft-deleted users are not found.
+func (r *PasskeyRepo) GetPasskey(ctx context.Context, credentialID []byte) (p entity.Passkey, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_passkey", start, err)
+	}(time.Now())
+
+	sql := `SELECT p.credential_id, p.user_id, p.name, p.public_key, p.sign_count, p.aaguid, p.transports,
+				p.backup_eligible, p.backup_state, p.created_at, p.last_used_at
+			FROM passkeys p JOIN users u ON u.id = p.user_id
+			WHERE p.credential_id = $1 AND u.deleted_at IS NULL`
+	rows, err := r.pool.Query(ctx, sql, credentialID)
+	if err != nil {
+		return entity.Passkey{}, err
+	}
+	return pgx.CollectExactlyOneRow(rows, scanPasskey)
+}
+
+// ListPasskeys returns the credentials of the user, oldest first.
+func (r *PasskeyRepo) ListPasskeys(ctx context.Context, userID uuid.UUID) (passkeys []entity.Passkey, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_passkeys", start, err)
+	}(time.Now())
+
+	sql := `SELECT credential_id, user_id, name, public_key, sign_count, aaguid, transports,
+				backup_eligible, backup_state, created_at, last_used_at
+			FROM passkeys WHERE user_id = $1 ORDER BY created_at`
+	rows, err := r.pool.Query(ctx, sql, userID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, scanPasskey)
+}
+
+// UsePasskey records a successful authentication with the new signature counter and backup state.
+// The counter only moves forward, so a concurrent authentication cannot lower it.
+func (r *PasskeyRepo) UsePasskey(ctx context.Context, credentialID []byte, signCount uint32, backupState bool) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("update_passkey_used", start, err)
+	}(time.Now())
+
+	sql := `UPDATE passkeys SET sign_count = GREATEST(sign_count, $2), backup_state = $3, last_used_at = NOW()
+			WHERE credential_id = $1`
+	_, err = r.pool.Exec(ctx, sql, credentialID, int64(signCount), backupState)
+	return err
+}
+
+// DeletePasskey deletes a credential of the user, returns customerrors.ErrNoTagsAffected if the user has no such credential.
+func (r *PasskeyRepo) DeletePasskey(ctx context.Context, userID uuid.UUID, credentialID []byte) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("delete_passkey", start, err)
+	}(time.Now())
+
+	tag, err := r.pool.Exec(ctx, `DELETE FROM passkeys WHERE credential_id = $1 AND user_id = $2`, credentialID, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return customerrors.ErrNoTagsAffected
+	}
+	return nil
+}
+
+func scanPasskey(row pgx.CollectableRow) (entity.Passkey, error) {
+	var p entity.Passkey
+	var signCount int64
+	err := row.Scan(&p.CredentialID, &p.UserID, &p.Name, &p.PublicKey, &signCount, &p.AAGUID, &p.Transports,
+		&p.BackupEligible, &p.BackupState, &p.CreatedAt, &p.LastUsedAt)
+	p.SignCount = uint32(signCount)
+	return p, err
+}
+
+// nullableUserID stores uuid.Nil as NULL.
+func nullableUserID(id uuid.UUID) *uuid.UUID {
+	if id == uuid.Nil {
+		return nil
+	}
+	return &id
+}
@@ -0,0 +1,149 @@
+package password
+
+import (
+	"context"
+	metrics "main/internal/metrics"
+	"main/pkg/customerrors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type PasswordRepo struct {
+	pool    *pgxpool.Pool
+	Metrics *metrics.Metrics
+}
+
+func NewPasswordRepo(pool *pgxpool.Pool, metrics *metrics.Metrics) *PasswordRepo {
+	return &PasswordRepo{
+		pool:    pool,
+		Metrics: metrics,
+	}
+}
+
+// StorePasswordReset saves the hash of a password reset token issued for the user.
+func (r *PasswordRepo) StorePasswordReset(ctx context.Context, tokenHash []byte, userID uuid.UUID, expiresAt time.Time) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("insert_password_reset", start, err)
+	}(time.Now())
+
+	sql := `INSERT INTO password_resets (token_hash, user_id, expires_at) VALUES ($1, $2, $3)`
+	_, err = r.pool.Exec(ctx, sql, tokenHash, userID, expiresAt)
+	return err
+}
+
+// ResetPassword consumes the reset token, sets the new password hash and deletes all sessions and trusted devices
+// of the user in one transaction. Returns pgx.ErrNoRows if the token does not exist or has expired.
+func (r *PasswordRepo) ResetPassword(ctx context.Context, tokenHash []byte, passwordHash string) (userID uuid.UUID, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("reset_password", start, err)
+	}(time.Now())
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx,
+		`DELETE FROM password_resets WHERE token_hash = $1 AND expires_at > NOW() RETURNING user_id`,
+		tokenHash).Scan(&userID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	tag, err := tx.Exec(ctx, `UPDATE users SET password_hash = $1, password_changed_at = NOW() WHERE id = $2 AND deleted_at IS NULL`, passwordHash, userID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if tag.RowsAffected() != 1 {
+		err = customerrors.ErrNoTagsAffected
+		return uuid.Nil, err
+	}
+
+	// other reset links and every session die with the old password
+	if _, err = tx.Exec(ctx, `DELETE FROM password_resets WHERE user_id = $1`, userID); err != nil {
+		return uuid.Nil, err
+	}
+	if _, err = tx.Exec(ctx, `DELETE FROM sessions WHERE user_id = $1`, userID); err != nil {
+		return uuid.Nil, err
+	}
+	if _, err = tx.Exec(ctx, `DELETE FROM trusted_devices WHERE user_id = $1`, userID); err != nil {
+		return uuid.Nil, err
+	}
+
+	err = tx.Commit(ctx)
+	return userID, err
+}
+
+// ChangePassword sets the new password hash and deletes all trusted devices and sessions of the user except
+// keepSessionID in one transaction, so other devices have to log in with the new password.
+func (r *PasswordRepo) ChangePassword(ctx context.Context, userID uuid.UUID, passwordHash string, keepSessionID uuid.UUID) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("change_password", start, err)
+	}(time.Now())
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `UPDATE users SET password_hash = $1, password_changed_at = NOW() WHERE id = $2 AND deleted_at IS NULL`, passwordHash, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() != 1 {
+		err = customerrors.ErrNoTagsAffected
+		return err
+	}
+
+	if _, err = tx.Exec(ctx, `DELETE FROM sessions WHERE user_id = $1 AND id <> $2`, userID, keepSessionID); err != nil {
+		return err
+	}
+	// a device trusted by whoever knew the old password must not skip the second factor
+	if _, err = tx.Exec(ctx, `DELETE FROM trusted_devices WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	// a pending reset link would allow bypassing the new password
+	if _, err = tx.Exec(ctx, `DELETE FROM password_resets WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	return err
+}
+
+// InvalidatePassword clears the password hash so no password matches until the user sets a new one through
+// a reset link, and deletes all sessions and trusted devices of the user in the same transaction.
+// Returns customerrors.ErrNoTagsAffected if the user does not exist.
+func (r *PasswordRepo) InvalidatePassword(ctx context.Context, userID uuid.UUID) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("invalidate_password", start, err)
+	}(time.Now())
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `UPDATE users SET password_hash = '' WHERE id = $1 AND deleted_at IS NULL`, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() != 1 {
+		err = customerrors.ErrNoTagsAffected
+		return err
+	}
+	if _, err = tx.Exec(ctx, `DELETE FROM sessions WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(ctx, `DELETE FROM trusted_devices WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	return err
+}
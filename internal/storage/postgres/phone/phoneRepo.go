@@ -0,0 +1,133 @@
+package phone
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"main/domain/entity"
+	metrics "main/internal/metrics"
+	"main/pkg/customerrors"
+	"main/pkg/fieldcrypt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type PhoneRepo struct {
+	pool    *pgxpool.Pool
+	Metrics *metrics.Metrics
+	// crypt decrypts the emails of users, nil reads them in plaintext
+	crypt *fieldcrypt.Cipher
+}
+
+func NewPhoneRepo(pool *pgxpool.Pool, metrics *metrics.Metrics, crypt *fieldcrypt.Cipher) *PhoneRepo {
+	return &PhoneRepo{
+		pool:    pool,
+		Metrics: metrics,
+		crypt:   crypt,
+	}
+}
+
+// StorePhoneOTP saves the login code of the phone, replacing a previous one and resetting the attempts.
+// It returns false without storing anything when the previous code is younger than cooldown.
+func (r *PhoneRepo) StorePhoneOTP(ctx context.Context, phone string, codeHash []byte, expiresAt time.Time, cooldown time.Duration) (stored bool, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("upsert_phone_otp", start, err)
+	}(time.Now())
+
+	sql := `INSERT INTO phone_otps (phone, code_hash, attempts, expires_at, created_at) VALUES ($1, $2, 0, $3, NOW())
+			ON CONFLICT (phone) DO UPDATE SET code_hash = EXCLUDED.code_hash, attempts = 0,
+				expires_at = EXCLUDED.expires_at, created_at = EXCLUDED.created_at
+			WHERE phone_otps.created_at <= NOW() - make_interval(secs => $4)`
+	tag, err := r.pool.Exec(ctx, sql, phone, codeHash, expiresAt, cooldown.Seconds())
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// ConsumePhoneOTP checks the code of the phone and deletes it when it matches. A wrong code counts as an attempt,
+// once maxAttempts are used up the code is deleted and customerrors.ErrTooManyAttempts returned.
+// Unknown, expired and wrong codes return customerrors.ErrInvalidOTP.
+func (r *PhoneRepo) ConsumePhoneOTP(ctx context.Context, phone string, codeHash []byte, maxAttempts int) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("consume_phone_otp", start, err)
+	}(time.Now())
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var storedHash []byte
+	var attempts int
+	err = tx.QueryRow(ctx, `SELECT code_hash, attempts FROM phone_otps WHERE phone = $1 AND expires_at > NOW() FOR UPDATE`,
+		phone).Scan(&storedHash, &attempts)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return customerrors.ErrInvalidOTP
+	}
+	if err != nil {
+		return err
+	}
+
+	if subtle.ConstantTimeCompare(storedHash, codeHash) == 1 {
+		if _, err = tx.Exec(ctx, `DELETE FROM phone_otps WHERE phone = $1`, phone); err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	}
+
+	result := customerrors.ErrInvalidOTP
+	if attempts+1 >= maxAttempts {
+		_, err = tx.Exec(ctx, `DELETE FROM phone_otps WHERE phone = $1`, phone)
+		result = customerrors.ErrTooManyAttempts
+	} else {
+		_, err = tx.Exec(ctx, `UPDATE phone_otps SET attempts = attempts + 1 WHERE phone = $1`, phone)
+	}
+	if err != nil {
+		return err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return err
+	}
+	return result
+}
+
+// GetUserByPhone retrieves the user by phone number. Soft-deleted users are not found.
+func (r *PhoneRepo) GetUserByPhone(ctx context.Context, phone string) (user entity.User, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_user_by_phone", start, err)
+	}(time.Now())
+
+	sql := `SELECT id, email, username, password_hash, created_at, is_blocked, email_verified
+			FROM users WHERE phone = $1 AND deleted_at IS NULL`
+	err = r.pool.QueryRow(ctx, sql, phone).Scan(
+		&user.ID,
+		&user.Email,
+		&user.Username,
+		&user.PasswordHash,
+		&user.CreatedAt,
+		&user.IsBlocked,
+		&user.EmailVerified,
+	)
+	if err != nil {
+		return entity.User{}, err
+	}
+	if user.Email, err = r.crypt.Decrypt(user.Email); err != nil {
+		return entity.User{}, err
+	}
+	return user, nil
+}
+
+// MarkPhoneVerified records that the user proved to own their phone number.
+func (r *PhoneRepo) MarkPhoneVerified(ctx context.Context, userID uuid.UUID) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("update_phone_verified", start, err)
+	}(time.Now())
+
+	_, err = r.pool.Exec(ctx, `UPDATE users SET phone_verified = TRUE WHERE id = $1 AND NOT phone_verified`, userID)
+	return err
+}
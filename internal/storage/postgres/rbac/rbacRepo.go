@@ -0,0 +1,50 @@
+package rbac
+
+import (
+	"context"
+	"main/domain/entity"
+	metrics "main/internal/metrics"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type RBACRepo struct {
+	pool    *pgxpool.Pool
+	Metrics *metrics.Metrics
+}
+
+func NewRBACRepo(pool *pgxpool.Pool, metrics *metrics.Metrics) *RBACRepo {
+	return &RBACRepo{
+		pool:    pool,
+		Metrics: metrics,
+	}
+}
+
+// GetUserRoles returns the roles granted to the user with their permissions, ordered by name.
+func (r *RBACRepo) GetUserRoles(ctx context.Context, userID uuid.UUID) (roles []entity.Role, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_user_roles", start, err)
+	}(time.Now())
+
+	sql := `SELECT r.name, r.permissions
+			FROM user_roles ur JOIN roles r ON r.name = ur.role
+			WHERE ur.user_id = $1
+			ORDER BY r.name`
+	rows, err := r.pool.Query(ctx, sql, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var role entity.Role
+		if err = rows.Scan(&role.Name, &role.Permissions); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	err = rows.Err()
+	return roles, err
+}
@@ -0,0 +1,89 @@
+package postgres
+
+import (
+	"context"
+	"io/fs"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// SchemaStatus compares the migrations shipped with the binary to the ones applied to the database.
+type SchemaStatus struct {
+	// Expected is the latest migration version known to the binary
+	Expected int64
+	// Current is the latest migration version applied to the database
+	Current int64
+	// Missing are migrations of the binary that are not applied, queries using them would fail
+	Missing []int64
+	// Unknown are applied migrations the binary does not know, the database is ahead of the binary
+	Unknown []int64
+}
+
+// Compatible reports whether every migration of the binary is applied and the database is not ahead.
+func (s SchemaStatus) Compatible() bool {
+	return len(s.Missing) == 0 && len(s.Unknown) == 0
+}
+
+// CheckSchema reads the goose version table and compares it to the migration files in migrations.
+// Goose keeps a row per up and down run, the latest row of a version tells whether it is applied.
+func CheckSchema(ctx context.Context, pool *pgxpool.Pool, migrations fs.FS, versionTable string) (SchemaStatus, error) {
+	expected, err := migrationVersions(migrations)
+	if err != nil {
+		return SchemaStatus{}, err
+	}
+
+	rows, err := pool.Query(ctx,
+		`SELECT version_id FROM (
+			SELECT DISTINCT ON (version_id) version_id, is_applied FROM `+pgx.Identifier{versionTable}.Sanitize()+`
+			ORDER BY version_id, id DESC
+		) v WHERE is_applied AND version_id > 0 ORDER BY version_id`)
+	if err != nil {
+		return SchemaStatus{}, err
+	}
+	applied, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil {
+		return SchemaStatus{}, err
+	}
+
+	var status SchemaStatus
+	if len(expected) > 0 {
+		status.Expected = expected[len(expected)-1]
+	}
+	if len(applied) > 0 {
+		status.Current = applied[len(applied)-1]
+	}
+	for _, v := range expected {
+		if _, found := slices.BinarySearch(applied, v); !found {
+			status.Missing = append(status.Missing, v)
+		}
+	}
+	for _, v := range applied {
+		if _, found := slices.BinarySearch(expected, v); !found {
+			status.Unknown = append(status.Unknown, v)
+		}
+	}
+	return status, nil
+}
+
+// migrationVersions returns the sorted versions of the goose migration files (<version>_<name>.sql).
+func migrationVersions(migrations fs.FS) ([]int64, error) {
+	names, err := fs.Glob(migrations, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]int64, 0, len(names))
+	for _, name := range names {
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, version)
+	}
+	slices.Sort(versions)
+	return versions, nil
+}
@@ -0,0 +1,49 @@
+package stats
+
+import (
+	"context"
+	"main/domain/entity"
+	metrics "main/internal/metrics"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type StatsRepo struct {
+	pool    *pgxpool.Pool
+	Metrics *metrics.Metrics
+}
+
+func NewStatsRepo(pool *pgxpool.Pool, metrics *metrics.Metrics) *StatsRepo {
+	return &StatsRepo{
+		pool:    pool,
+		Metrics: metrics,
+	}
+}
+
+// Stats counts the users, issued tokens and denylist entries as of the given time, the sessions and the rates
+// are left to the caller. Tokens are counted from the login and refresh events of the audit log.
+func (r *StatsRepo) Stats(ctx context.Context, now time.Time) (stats entity.SystemStats, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_stats", start, err)
+	}(time.Now())
+
+	sql := `SELECT
+			(SELECT COUNT(*) FROM users WHERE deleted_at IS NULL),
+			(SELECT COUNT(*) FROM users WHERE deleted_at IS NULL AND is_blocked),
+			(SELECT COUNT(*) FROM users WHERE created_at > $1 - INTERVAL '1 hour'),
+			(SELECT COUNT(*) FROM users WHERE created_at > $1 - INTERVAL '1 day'),
+			(SELECT COUNT(*) FROM audit_events WHERE action = ANY($2) AND created_at > $1 - INTERVAL '1 minute'),
+			(SELECT COUNT(*) FROM audit_events WHERE action = ANY($2) AND created_at > $1 - INTERVAL '1 hour'),
+			(SELECT COUNT(*) FROM denied_sessions WHERE expires_at > $1)`
+	err = r.pool.QueryRow(ctx, sql, now, []string{entity.AuditLogin, entity.AuditRefresh}).Scan(
+		&stats.Users.Total,
+		&stats.Users.Blocked,
+		&stats.Users.CreatedLastHour,
+		&stats.Users.CreatedLastDay,
+		&stats.Tokens.IssuedLastMinute,
+		&stats.Tokens.IssuedLastHour,
+		&stats.Denylist.Entries,
+	)
+	return stats, err
+}
@@ -0,0 +1,114 @@
+package status
+
+import (
+	"context"
+	"main/domain/entity"
+	metrics "main/internal/metrics"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type StatusRepo struct {
+	pool    *pgxpool.Pool
+	Metrics *metrics.Metrics
+}
+
+func NewStatusRepo(pool *pgxpool.Pool, metrics *metrics.Metrics) *StatusRepo {
+	return &StatusRepo{
+		pool:    pool,
+		Metrics: metrics,
+	}
+}
+
+const incidentColumns = `id, kind, severity, title, COALESCE(message, ''), starts_at, ends_at, resolved_at, created_by,
+	created_at, updated_at`
+
+func scanIncident(row pgx.CollectableRow) (entity.Incident, error) {
+	var i entity.Incident
+	err := row.Scan(&i.ID, &i.Kind, &i.Severity, &i.Title, &i.Message, &i.StartsAt, &i.EndsAt, &i.ResolvedAt, &i.CreatedBy,
+		&i.CreatedAt, &i.UpdatedAt)
+	return i, err
+}
+
+// CreateIncident stores a new incident.
+func (r *StatusRepo) CreateIncident(ctx context.Context, incident entity.Incident) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("insert_status_incident", start, err)
+	}(time.Now())
+
+	_, err = r.pool.Exec(ctx, `INSERT INTO status_incidents (id, kind, severity, title, message, starts_at, ends_at, created_by,
+				created_at, updated_at)
+			VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, $8, $9, $9)`,
+		incident.ID, incident.Kind, incident.Severity, incident.Title, incident.Message, incident.StartsAt, incident.EndsAt,
+		incident.CreatedBy, incident.CreatedAt)
+	return err
+}
+
+// UpdateIncident applies the update to the unresolved incident and resolves it at resolvedAt when that is set.
+// An empty message removes the message. Returns pgx.ErrNoRows if there is no unresolved incident with the ID.
+func (r *StatusRepo) UpdateIncident(ctx context.Context, id uuid.UUID, update entity.IncidentUpdate, resolvedAt *time.Time) (incident entity.Incident, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("update_status_incident", start, err)
+	}(time.Now())
+
+	rows, err := r.pool.Query(ctx, `UPDATE status_incidents SET severity = COALESCE($2, severity), title = COALESCE($3, title),
+				message = CASE WHEN $4::text IS NULL THEN message ELSE NULLIF($4, '') END,
+				ends_at = COALESCE($5, ends_at), resolved_at = $6, updated_at = NOW()
+			WHERE id = $1 AND resolved_at IS NULL
+			RETURNING `+incidentColumns,
+		id, update.Severity, update.Title, update.Message, update.EndsAt, resolvedAt)
+	if err != nil {
+		return entity.Incident{}, err
+	}
+	incident, err = pgx.CollectExactlyOneRow(rows, scanIncident)
+	return incident, err
+}
+
+// GetIncident returns the incident, pgx.ErrNoRows if it does not exist.
+func (r *StatusRepo) GetIncident(ctx context.Context, id uuid.UUID) (incident entity.Incident, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_status_incident", start, err)
+	}(time.Now())
+
+	rows, err := r.pool.Query(ctx, `SELECT `+incidentColumns+` FROM status_incidents WHERE id = $1`, id)
+	if err != nil {
+		return entity.Incident{}, err
+	}
+	incident, err = pgx.CollectExactlyOneRow(rows, scanIncident)
+	return incident, err
+}
+
+// ListIncidents returns a page of the incidents, the latest start first.
+func (r *StatusRepo) ListIncidents(ctx context.Context, limit, offset int) (incidents []entity.Incident, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_status_incidents", start, err)
+	}(time.Now())
+
+	rows, err := r.pool.Query(ctx, `SELECT `+incidentColumns+` FROM status_incidents
+			ORDER BY starts_at DESC, id LIMIT $1 OFFSET $2`, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	incidents, err = pgx.CollectRows(rows, scanIncident)
+	return incidents, err
+}
+
+// RecentIncidents returns the unresolved incidents, also the scheduled ones, and the ones that were resolved
+// or ended since the given time, the latest start first.
+func (r *StatusRepo) RecentIncidents(ctx context.Context, since time.Time, limit int) (incidents []entity.Incident, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_recent_status_incidents", start, err)
+	}(time.Now())
+
+	rows, err := r.pool.Query(ctx, `SELECT `+incidentColumns+` FROM status_incidents
+			WHERE (resolved_at IS NULL AND (ends_at IS NULL OR ends_at >= $1)) OR resolved_at >= $1
+			ORDER BY starts_at DESC, id LIMIT $2`, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	incidents, err = pgx.CollectRows(rows, scanIncident)
+	return incidents, err
+}
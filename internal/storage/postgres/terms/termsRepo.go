@@ -0,0 +1,153 @@
+package terms
+
+import (
+	"context"
+	"main/domain/entity"
+	metrics "main/internal/metrics"
+	"main/pkg/customerrors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type TermsRepo struct {
+	pool    *pgxpool.Pool
+	Metrics *metrics.Metrics
+}
+
+func NewTermsRepo(pool *pgxpool.Pool, metrics *metrics.Metrics) *TermsRepo {
+	return &TermsRepo{
+		pool:    pool,
+		Metrics: metrics,
+	}
+}
+
+const documentColumns = `id, kind, version, url, published_at, requires_acceptance`
+
+// currentDocuments selects the latest published version of every kind.
+const currentDocuments = `SELECT DISTINCT ON (kind) ` + documentColumns + ` FROM terms_documents ORDER BY kind, published_at DESC`
+
+func scanDocument(row pgx.CollectableRow) (entity.TermsDocument, error) {
+	var doc entity.TermsDocument
+	err := row.Scan(&doc.ID, &doc.Kind, &doc.Version, &doc.URL, &doc.PublishedAt, &doc.RequiresAcceptance)
+	return doc, err
+}
+
+// PublishTerms stores a new version of a document, it becomes the current version of its kind.
+func (r *TermsRepo) PublishTerms(ctx context.Context, doc entity.TermsDocument) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("insert_terms_document", start, err)
+	}(time.Now())
+
+	_, err = r.pool.Exec(ctx, `INSERT INTO terms_documents (`+documentColumns+`) VALUES ($1, $2, $3, $4, $5, $6)`,
+		doc.ID, doc.Kind, doc.Version, doc.URL, doc.PublishedAt, doc.RequiresAcceptance)
+	return err
+}
+
+// ListTermsDocuments returns every published version, the newest first within a kind.
+func (r *TermsRepo) ListTermsDocuments(ctx context.Context) (docs []entity.TermsDocument, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_terms_documents", start, err)
+	}(time.Now())
+
+	rows, err := r.pool.Query(ctx, `SELECT `+documentColumns+` FROM terms_documents ORDER BY kind, published_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, scanDocument)
+}
+
+// CurrentTermsDocuments returns the current version of every kind.
+func (r *TermsRepo) CurrentTermsDocuments(ctx context.Context) (docs []entity.TermsDocument, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_current_terms_documents", start, err)
+	}(time.Now())
+
+	rows, err := r.pool.Query(ctx, currentDocuments)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, scanDocument)
+}
+
+// PendingTerms returns the current versions the user still has to accept. A kind is pending while the user
+// has accepted no version published since the latest version that requires acceptance, kinds without
+// such a version are never pending. For uuid.Nil it returns what a new user has to accept.
+func (r *TermsRepo) PendingTerms(ctx context.Context, userID uuid.UUID) (docs []entity.TermsDocument, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_pending_terms", start, err)
+	}(time.Now())
+
+	rows, err := r.pool.Query(ctx, `
+		WITH current_docs AS (`+currentDocuments+`),
+		required_docs AS (
+			SELECT kind, MAX(published_at) AS published_at FROM terms_documents
+			WHERE requires_acceptance GROUP BY kind
+		)
+		SELECT c.id, c.kind, c.version, c.url, c.published_at, c.requires_acceptance
+		FROM current_docs c JOIN required_docs q ON q.kind = c.kind
+		WHERE NOT EXISTS (
+			SELECT 1 FROM terms_acceptances a JOIN terms_documents d ON d.id = a.document_id
+			WHERE a.user_id = $1 AND d.kind = c.kind AND d.published_at >= q.published_at
+		)
+		ORDER BY c.kind`, userID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, scanDocument)
+}
+
+// AcceptTerms records that the user accepted the documents, accepting a document twice keeps the first acceptance.
+// It returns customerrors.ErrInvalidTermsDocument if one of the documents does not exist.
+func (r *TermsRepo) AcceptTerms(ctx context.Context, userID uuid.UUID, documentIDs []uuid.UUID) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("insert_terms_acceptances", start, err)
+	}(time.Now())
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var unknown bool
+	err = tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM unnest($1::uuid[]) AS ids(id)
+		WHERE NOT EXISTS (SELECT 1 FROM terms_documents d WHERE d.id = ids.id))`, documentIDs).Scan(&unknown)
+	if err != nil {
+		return err
+	}
+	if unknown {
+		return customerrors.ErrInvalidTermsDocument
+	}
+	_, err = tx.Exec(ctx, `INSERT INTO terms_acceptances (user_id, document_id)
+		SELECT $1, id FROM terms_documents WHERE id = ANY($2)
+		ON CONFLICT (user_id, document_id) DO NOTHING`, userID, documentIDs)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit(ctx)
+	return err
+}
+
+// TermsAcceptances returns the documents the user accepted, the latest acceptance first.
+func (r *TermsRepo) TermsAcceptances(ctx context.Context, userID uuid.UUID) (acceptances []entity.TermsAcceptance, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("select_terms_acceptances", start, err)
+	}(time.Now())
+
+	rows, err := r.pool.Query(ctx, `
+		SELECT d.id, d.kind, d.version, d.url, d.published_at, d.requires_acceptance, a.accepted_at
+		FROM terms_acceptances a JOIN terms_documents d ON d.id = a.document_id
+		WHERE a.user_id = $1 ORDER BY a.accepted_at DESC, d.kind`, userID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (entity.TermsAcceptance, error) {
+		var a entity.TermsAcceptance
+		d := &a.Document
+		err := row.Scan(&d.ID, &d.Kind, &d.Version, &d.URL, &d.PublishedAt, &d.RequiresAcceptance, &a.AcceptedAt)
+		return a, err
+	})
+}
@@ -0,0 +1,166 @@
+package verification
+
+import (
+	"context"
+	"errors"
+	metrics "main/internal/metrics"
+	"main/pkg/customerrors"
+	"main/pkg/fieldcrypt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type VerificationRepo struct {
+	pool    *pgxpool.Pool
+	Metrics *metrics.Metrics
+	// crypt encrypts the emails, nil stores them in plaintext
+	crypt *fieldcrypt.Cipher
+}
+
+func NewVerificationRepo(pool *pgxpool.Pool, metrics *metrics.Metrics, crypt *fieldcrypt.Cipher) *VerificationRepo {
+	return &VerificationRepo{
+		pool:    pool,
+		Metrics: metrics,
+		crypt:   crypt,
+	}
+}
+
+// StoreEmailVerification saves the hash of a verification token issued for the user's email.
+func (r *VerificationRepo) StoreEmailVerification(ctx context.Context, tokenHash []byte, userID uuid.UUID, email string, expiresAt time.Time) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("insert_email_verification", start, err)
+	}(time.Now())
+
+	sql := `INSERT INTO email_verifications (token_hash, user_id, email, expires_at) VALUES ($1, $2, $3, $4)`
+	_, err = r.pool.Exec(ctx, sql, tokenHash, userID, r.crypt.Encrypt(email), expiresAt)
+	return err
+}
+
+// ConsumeEmailVerification deletes the verification token and marks the email verified in one transaction,
+// so a token can be used only once. The email must still match the one the token was issued for.
+// Returns pgx.ErrNoRows if the token does not exist or has expired.
+func (r *VerificationRepo) ConsumeEmailVerification(ctx context.Context, tokenHash []byte) (userID uuid.UUID, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("consume_email_verification", start, err)
+	}(time.Now())
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var email string
+	err = tx.QueryRow(ctx,
+		`DELETE FROM email_verifications WHERE token_hash = $1 AND expires_at > NOW() RETURNING user_id, email`,
+		tokenHash).Scan(&userID, &email)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	// encrypted emails differ even when equal, so they are compared decrypted
+	var current string
+	err = tx.QueryRow(ctx, `SELECT email FROM users WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, userID).Scan(&current)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = customerrors.ErrNoTagsAffected
+		return uuid.Nil, err
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if email, err = r.crypt.Decrypt(email); err != nil {
+		return uuid.Nil, err
+	}
+	if current, err = r.crypt.Decrypt(current); err != nil {
+		return uuid.Nil, err
+	}
+	if current != email {
+		err = customerrors.ErrNoTagsAffected
+		return uuid.Nil, err
+	}
+	if _, err = tx.Exec(ctx, `UPDATE users SET email_verified = TRUE WHERE id = $1`, userID); err != nil {
+		return uuid.Nil, err
+	}
+
+	// any other outstanding links for this user are now useless
+	if _, err = tx.Exec(ctx, `DELETE FROM email_verifications WHERE user_id = $1`, userID); err != nil {
+		return uuid.Nil, err
+	}
+
+	err = tx.Commit(ctx)
+	return userID, err
+}
+
+// StoreEmailChange saves a pending email change, a user can have only one at a time.
+func (r *VerificationRepo) StoreEmailChange(ctx context.Context, tokenHash []byte, userID uuid.UUID, newEmail, newCanonicalEmail string, expiresAt time.Time) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("insert_email_change", start, err)
+	}(time.Now())
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err = tx.Exec(ctx, `DELETE FROM email_changes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `INSERT INTO email_changes (token_hash, user_id, new_email, new_canonical_email, expires_at) VALUES ($1, $2, $3, $4, $5)`,
+		tokenHash, userID, r.crypt.Encrypt(newEmail), r.crypt.Index(newCanonicalEmail), expiresAt)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	return err
+}
+
+// ConsumeEmailChange deletes the pending change and sets the new, already verified, email on the user.
+// Returns pgx.ErrNoRows if the token does not exist or has expired and a unique violation if the email was taken meanwhile.
+func (r *VerificationRepo) ConsumeEmailChange(ctx context.Context, tokenHash []byte) (userID uuid.UUID, newEmail string, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("consume_email_change", start, err)
+	}(time.Now())
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+	defer tx.Rollback(ctx)
+
+	var newCanonicalEmail string
+	err = tx.QueryRow(ctx,
+		`DELETE FROM email_changes WHERE token_hash = $1 AND expires_at > NOW() RETURNING user_id, new_email, new_canonical_email`,
+		tokenHash).Scan(&userID, &newEmail, &newCanonicalEmail)
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+	if newEmail, err = r.crypt.Decrypt(newEmail); err != nil {
+		return uuid.Nil, "", err
+	}
+	// a change requested before encryption was enabled has its canonical email in plaintext
+	if !fieldcrypt.Encrypted(newCanonicalEmail) {
+		newCanonicalEmail = r.crypt.Index(newCanonicalEmail)
+	}
+
+	tag, err := tx.Exec(ctx, `UPDATE users SET email = $1, canonical_email = $2, email_verified = TRUE WHERE id = $3 AND deleted_at IS NULL`,
+		r.crypt.Encrypt(newEmail), newCanonicalEmail, userID)
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+	if tag.RowsAffected() != 1 {
+		err = customerrors.ErrNoTagsAffected
+		return uuid.Nil, "", err
+	}
+	// verification links sent to the old address must not flip the flag anymore
+	if _, err = tx.Exec(ctx, `DELETE FROM email_verifications WHERE user_id = $1`, userID); err != nil {
+		return uuid.Nil, "", err
+	}
+
+	err = tx.Commit(ctx)
+	return userID, newEmail, err
+}
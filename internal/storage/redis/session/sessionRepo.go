@@ -0,0 +1,878 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"main/domain/entity"
+	metrics "main/internal/metrics"
+	"main/pkg/fieldcrypt"
+	"maps"
+	"net/netip"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+// Keys of the sessions, of the lookups of their refresh, rotated and decoy tokens and of the set of sessions
+// of each user. All of them expire with the session.
+const (
+	sessionPrefix       = "session:"
+	tokenPrefix         = "session_token:"
+	previousTokenPrefix = "session_previous_token:"
+	canaryPrefix        = "session_canary:"
+	userPrefix          = "user_sessions:"
+)
+
+// Sorted sets of all sessions by expiry and by start, which only serve the statistics. Their expired members
+// are trimmed on every write.
+const (
+	expiryIndex = "sessions_by_expiry"
+	startIndex  = "sessions_by_start"
+)
+
+// statsWindow is the longest period the statistics count started sessions over.
+const statsWindow = 24 * time.Hour
+
+// lookupBatchSize is the number of keys read with one MGET.
+const lookupBatchSize = 1000
+
+// UserStore keeps the state of users sessions change, implemented by the Postgres auth repository.
+type UserStore interface {
+	// IncrementTokenVersion increments the token version of the user, which revokes its access tokens.
+	IncrementTokenVersion(ctx context.Context, userID uuid.UUID) error
+
+	// UpdateLocale sets the locale and time zone of the user, empty ones keep the previous values.
+	UpdateLocale(ctx context.Context, userID uuid.UUID, locale, timezone string) error
+}
+
+// record is a session as stored in Redis. The user agent, the IP address and the device name are encrypted
+// like in Postgres.
+type record struct {
+	ID                   uuid.UUID             `json:"id"`
+	UserID               uuid.UUID             `json:"user_id"`
+	RefreshToken         uuid.UUID             `json:"refresh_token"`
+	IsBlocked            bool                  `json:"is_blocked,omitempty"`
+	PreviousRefreshToken uuid.UUID             `json:"previous_refresh_token"`
+	CanaryToken          uuid.UUID             `json:"canary_token"`
+	CreatedAt            time.Time             `json:"created_at"`
+	StartedAt            time.Time             `json:"started_at"`
+	LastUsedAt           time.Time             `json:"last_used_at"`
+	ExpiresAt            time.Time             `json:"expires_at"`
+	UserAgent            string                `json:"user_agent,omitempty"`
+	ClientIP             *string               `json:"client_ip,omitempty"`
+	ClientType           entity.ClientType     `json:"client_type"`
+	DeviceName           string                `json:"device_name,omitempty"`
+	CertThumbprint       string                `json:"cert_thumbprint,omitempty"`
+	DPoPThumbprint       string                `json:"dpop_jkt,omitempty"`
+	IPHash               string                `json:"ip_hash,omitempty"`
+	DeviceHash           string                `json:"device_hash,omitempty"`
+	Locale               string                `json:"locale,omitempty"`
+	Timezone             string                `json:"timezone,omitempty"`
+	Country              string                `json:"country,omitempty"`
+	City                 string                `json:"city,omitempty"`
+	Risk                 entity.RiskAssessment `json:"risk"`
+	AttestedKey          *entity.AttestedKey   `json:"attested_key,omitempty"`
+	BoundNetwork         netip.Addr            `json:"bound_network"`
+	Privileges           string                `json:"privileges,omitempty"`
+	ElevatedUntil        time.Time             `json:"elevated_until"`
+	TokenState           *entity.TokenState    `json:"token_state,omitempty"`
+}
+
+// SessionRepo stores sessions in Redis, where they expire by themselves. Users and everything else stay in
+// Postgres: sessions deleted there in bulk (password changes, blocks, deletions) are not seen by this store,
+// the auth usecase ends them at their next refresh by comparing the token state they were started with.
+// Administrative revocations end them here right away through RemoveUserSessions and DeleteSessions.
+type SessionRepo struct {
+	client  *redis.Client
+	users   UserStore
+	Metrics *metrics.Metrics
+	// crypt encrypts IP addresses, user agents and device names, nil stores them in plaintext
+	crypt *fieldcrypt.Cipher
+}
+
+func NewSessionRepo(client *redis.Client, users UserStore, metrics *metrics.Metrics, crypt *fieldcrypt.Cipher) *SessionRepo {
+	return &SessionRepo{
+		client:  client,
+		users:   users,
+		Metrics: metrics,
+		crypt:   crypt,
+	}
+}
+
+// StoreSession saves the session of the user until it expires. The locale and time zone of the session become
+// those of the user, empty ones keep the previous values.
+func (r *SessionRepo) StoreSession(ctx context.Context, userID uuid.UUID, session entity.Session) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("redis_store_session", start, err)
+	}(time.Now())
+
+	rec := record{
+		ID:             session.ID,
+		UserID:         userID,
+		RefreshToken:   session.RefreshToken,
+		IsBlocked:      session.IsBlocked,
+		CanaryToken:    session.CanaryToken,
+		CreatedAt:      session.CreatedAt,
+		StartedAt:      session.CreatedAt,
+		LastUsedAt:     session.CreatedAt,
+		ExpiresAt:      session.ExpiresAt,
+		UserAgent:      r.crypt.Encrypt(session.UserAgent),
+		ClientIP:       r.crypt.EncryptAddr(session.ClientIP),
+		ClientType:     session.ClientType,
+		DeviceName:     r.crypt.Encrypt(session.DeviceName),
+		CertThumbprint: session.CertThumbprint,
+		DPoPThumbprint: session.DPoPThumbprint,
+		IPHash:         session.IPHash,
+		DeviceHash:     session.DeviceHash,
+		Locale:         session.Locale,
+		Timezone:       session.Timezone,
+		Country:        session.Country,
+		City:           session.City,
+		Risk:           session.Risk,
+		AttestedKey:    session.AttestedKey,
+		BoundNetwork:   session.BoundNetwork,
+		Privileges:     session.Privileges,
+		ElevatedUntil:  session.ElevatedUntil,
+		TokenState:     session.TokenState,
+	}
+	value, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		r.write(ctx, pipe, rec, value)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if session.Locale != "" || session.Timezone != "" {
+		err = r.users.UpdateLocale(ctx, userID, session.Locale, session.Timezone)
+	}
+	return err
+}
+
+// write queues the commands storing the record and its lookups until it expires.
+func (r *SessionRepo) write(ctx context.Context, pipe redis.Pipeliner, rec record, value []byte) {
+	id := rec.ID.String()
+	expire := redis.SetArgs{ExpireAt: rec.ExpiresAt}
+	pipe.SetArgs(ctx, sessionPrefix+id, value, expire)
+	pipe.SetArgs(ctx, tokenPrefix+rec.RefreshToken.String(), id, expire)
+	if rec.PreviousRefreshToken != uuid.Nil {
+		pipe.SetArgs(ctx, previousTokenPrefix+rec.PreviousRefreshToken.String(), id, expire)
+	}
+	if rec.CanaryToken != uuid.Nil {
+		pipe.SetArgs(ctx, canaryPrefix+rec.CanaryToken.String(), id, expire)
+	}
+	// the set lives as long as the last session of the user, NX gives a new set a TTL that GT can only extend
+	userKey := userPrefix + rec.UserID.String()
+	pipe.SAdd(ctx, userKey, id)
+	pipe.ExpireNX(ctx, userKey, time.Until(rec.ExpiresAt))
+	pipe.ExpireGT(ctx, userKey, time.Until(rec.ExpiresAt))
+
+	now := time.Now()
+	pipe.ZAdd(ctx, expiryIndex, redis.Z{Score: float64(rec.ExpiresAt.Unix()), Member: id})
+	pipe.ZAdd(ctx, startIndex, redis.Z{Score: float64(rec.StartedAt.Unix()), Member: id})
+	pipe.ZRemRangeByScore(ctx, expiryIndex, "-inf", unixScore(now))
+	pipe.ZRemRangeByScore(ctx, startIndex, "-inf", unixScore(now.Add(-statsWindow)))
+}
+
+func unixScore(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10)
+}
+
+// remove queues the commands deleting the record and its lookups.
+func (r *SessionRepo) remove(ctx context.Context, pipe redis.Pipeliner, rec record) {
+	pipe.Del(ctx, sessionPrefix+rec.ID.String(), tokenPrefix+rec.RefreshToken.String())
+	if rec.PreviousRefreshToken != uuid.Nil {
+		pipe.Del(ctx, previousTokenPrefix+rec.PreviousRefreshToken.String())
+	}
+	if rec.CanaryToken != uuid.Nil {
+		pipe.Del(ctx, canaryPrefix+rec.CanaryToken.String())
+	}
+	pipe.SRem(ctx, userPrefix+rec.UserID.String(), rec.ID.String())
+	pipe.ZRem(ctx, expiryIndex, rec.ID.String())
+	pipe.ZRem(ctx, startIndex, rec.ID.String())
+}
+
+// DeleteSession removes a specific session of the user, nothing happens if the user has no such session.
+func (r *SessionRepo) DeleteSession(ctx context.Context, userID uuid.UUID, sessionID uuid.UUID) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("redis_delete_session", start, err)
+	}(time.Now())
+
+	rec, err := r.get(ctx, r.client, sessionID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if rec.UserID != userID {
+		return nil
+	}
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		r.remove(ctx, pipe, rec)
+		return nil
+	})
+	return err
+}
+
+// DeleteAllSessions increments the token version of the user, then removes all its sessions and returns their IDs.
+// The version is incremented first, a failure in between leaves sessions that cannot be refreshed anymore.
+func (r *SessionRepo) DeleteAllSessions(ctx context.Context, userID uuid.UUID) (ids []uuid.UUID, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("redis_delete_all_sessions", start, err)
+	}(time.Now())
+
+	if err = r.users.IncrementTokenVersion(ctx, userID); err != nil {
+		return nil, err
+	}
+	ids, err = r.removeUserSessions(ctx, []uuid.UUID{userID})
+	return ids, err
+}
+
+// RemoveUserSessions removes all sessions of the users without touching their token versions, which the
+// revocations calling it incremented already, and returns their IDs.
+func (r *SessionRepo) RemoveUserSessions(ctx context.Context, userIDs []uuid.UUID) (ids []uuid.UUID, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("redis_remove_user_sessions", start, err)
+	}(time.Now())
+
+	ids, err = r.removeUserSessions(ctx, userIDs)
+	return ids, err
+}
+
+func (r *SessionRepo) removeUserSessions(ctx context.Context, userIDs []uuid.UUID) ([]uuid.UUID, error) {
+	var records []record
+	for _, userID := range userIDs {
+		userRecords, err := r.userRecords(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, userRecords...)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	ids := make([]uuid.UUID, 0, len(records))
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, rec := range records {
+			r.remove(ctx, pipe, rec)
+			ids = append(ids, rec.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// ListSessionIDs returns the IDs of all sessions of a user.
+func (r *SessionRepo) ListSessionIDs(ctx context.Context, userID uuid.UUID) (ids []uuid.UUID, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("redis_list_session_ids", start, err)
+	}(time.Now())
+
+	records, err := r.userRecords(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, rec := range records {
+		ids = append(ids, rec.ID)
+	}
+	return ids, nil
+}
+
+// ListActiveSessions returns the unexpired sessions of the user, the last used first.
+func (r *SessionRepo) ListActiveSessions(ctx context.Context, userID uuid.UUID) (sessions []entity.ActiveSession, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("redis_list_active_sessions", start, err)
+	}(time.Now())
+
+	records, err := r.userRecords(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(records, func(a, b record) int {
+		if c := b.LastUsedAt.Compare(a.LastUsedAt); c != 0 {
+			return c
+		}
+		return slices.Compare(a.ID[:], b.ID[:])
+	})
+	now := time.Now()
+	for _, rec := range records {
+		if !rec.ExpiresAt.After(now) {
+			continue
+		}
+		s, err := r.activeSession(rec)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, nil
+}
+
+// ListSessions returns a page of the sessions of the user matching the filter, newest first by the sort of the
+// filter, and the number of matching sessions. Expired sessions are gone, the expired status matches none.
+func (r *SessionRepo) ListSessions(ctx context.Context, filter entity.SessionFilter) (page entity.SessionPage, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("redis_list_sessions", start, err)
+	}(time.Now())
+
+	if !filter.Status.Valid() || !filter.Sort.Valid() {
+		return entity.SessionPage{}, errors.New("invalid session filter")
+	}
+	records, err := r.userRecords(ctx, filter.UserID)
+	if err != nil {
+		return entity.SessionPage{}, err
+	}
+	sortedAt := func(rec record) time.Time {
+		if filter.Sort == entity.SessionSortCreated {
+			return rec.StartedAt
+		}
+		return rec.LastUsedAt
+	}
+	now := time.Now()
+	records = slices.DeleteFunc(records, func(rec record) bool {
+		switch filter.Status {
+		case entity.SessionStatusActive:
+			return rec.IsBlocked || !rec.ExpiresAt.After(now)
+		case entity.SessionStatusExpired:
+			return rec.ExpiresAt.After(now)
+		case entity.SessionStatusBlocked:
+			return !rec.IsBlocked
+		}
+		return false
+	})
+	page.Total = len(records)
+	// the order of Postgres: time, then ID, both descending
+	slices.SortFunc(records, func(a, b record) int {
+		if c := sortedAt(b).Compare(sortedAt(a)); c != 0 {
+			return c
+		}
+		return slices.Compare(b.ID[:], a.ID[:])
+	})
+	if after := filter.After; after != nil {
+		records = slices.DeleteFunc(records, func(rec record) bool {
+			at := sortedAt(rec)
+			return at.After(after.At) || at.Equal(after.At) && slices.Compare(rec.ID[:], after.ID[:]) >= 0
+		})
+	} else {
+		records = records[min(max(filter.Offset, 0), len(records)):]
+	}
+	if filter.Limit > 0 {
+		records = records[:min(filter.Limit, len(records))]
+	}
+	for _, rec := range records {
+		s, err := r.activeSession(rec)
+		if err != nil {
+			return entity.SessionPage{}, err
+		}
+		page.Sessions = append(page.Sessions, s)
+	}
+	return page, nil
+}
+
+// UserSessions returns the unexpired sessions of the user, newest first.
+func (r *SessionRepo) UserSessions(ctx context.Context, userID uuid.UUID) (sessions []entity.Session, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("redis_user_sessions", start, err)
+	}(time.Now())
+
+	records, err := r.userRecords(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(records, func(a, b record) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+	now := time.Now()
+	for _, rec := range records {
+		if !rec.ExpiresAt.After(now) {
+			continue
+		}
+		s, err := r.session(rec)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, nil
+}
+
+// SessionsByTokens returns the sessions whose ID or refresh token is one of the tokens, with their ID,
+// user and refresh token.
+func (r *SessionRepo) SessionsByTokens(ctx context.Context, tokens []uuid.UUID) (sessions []entity.Session, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("redis_sessions_by_tokens", start, err)
+	}(time.Now())
+
+	found := make(map[uuid.UUID]bool)
+	add := func(rec record) {
+		if !found[rec.ID] {
+			found[rec.ID] = true
+			sessions = append(sessions, entity.Session{ID: rec.ID, UserID: rec.UserID, RefreshToken: rec.RefreshToken})
+		}
+	}
+	for batch := range slices.Chunk(tokens, lookupBatchSize) {
+		ids := make([]string, len(batch))
+		keys := make([]string, len(batch))
+		for i, token := range batch {
+			ids[i] = token.String()
+			keys[i] = tokenPrefix + ids[i]
+		}
+		records, err := r.records(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, rec := range records {
+			add(rec)
+		}
+
+		values, err := r.client.MGet(ctx, keys...).Result()
+		if err != nil {
+			return nil, err
+		}
+		refreshed := make(map[string]uuid.UUID)
+		for i, value := range values {
+			if id, ok := value.(string); ok {
+				refreshed[id] = batch[i]
+			}
+		}
+		records, err = r.records(ctx, slices.Collect(maps.Keys(refreshed)))
+		if err != nil {
+			return nil, err
+		}
+		for _, rec := range records {
+			// the lookup may outlive a rotation of the token
+			if rec.RefreshToken == refreshed[rec.ID.String()] {
+				add(rec)
+			}
+		}
+	}
+	return sessions, nil
+}
+
+// DeleteSessions removes the sessions and returns how many it removed.
+func (r *SessionRepo) DeleteSessions(ctx context.Context, sessionIDs []uuid.UUID) (deleted int64, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("redis_delete_sessions", start, err)
+	}(time.Now())
+
+	for batch := range slices.Chunk(sessionIDs, lookupBatchSize) {
+		ids := make([]string, len(batch))
+		for i, id := range batch {
+			ids[i] = id.String()
+		}
+		records, err := r.records(ctx, ids)
+		if err != nil || len(records) == 0 {
+			return deleted, err
+		}
+		_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, rec := range records {
+				r.remove(ctx, pipe, rec)
+			}
+			return nil
+		})
+		if err != nil {
+			return deleted, err
+		}
+		deleted += int64(len(records))
+	}
+	return deleted, nil
+}
+
+// SessionStats counts the unexpired sessions and the sessions started in the last hour and day as of now.
+func (r *SessionRepo) SessionStats(ctx context.Context, now time.Time) (stats entity.SessionStats, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("redis_session_stats", start, err)
+	}(time.Now())
+
+	pipe := r.client.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, expiryIndex, "-inf", unixScore(now))
+	pipe.ZRemRangeByScore(ctx, startIndex, "-inf", unixScore(now.Add(-statsWindow)))
+	active := pipe.ZCard(ctx, expiryIndex)
+	lastHour := pipe.ZCount(ctx, startIndex, "("+unixScore(now.Add(-time.Hour)), "+inf")
+	lastDay := pipe.ZCard(ctx, startIndex)
+	if _, err = pipe.Exec(ctx); err != nil {
+		return entity.SessionStats{}, err
+	}
+	return entity.SessionStats{
+		Active:          active.Val(),
+		StartedLastHour: lastHour.Val(),
+		StartedLastDay:  lastDay.Val(),
+	}, nil
+}
+
+// activeSession returns the stored session as listed to users, with its fields decrypted.
+func (r *SessionRepo) activeSession(rec record) (s entity.ActiveSession, err error) {
+	s = entity.ActiveSession{
+		ID:         rec.ID,
+		ClientType: rec.ClientType,
+		Country:    rec.Country,
+		City:       rec.City,
+		CreatedAt:  rec.StartedAt,
+		LastUsedAt: rec.LastUsedAt,
+		ExpiresAt:  rec.ExpiresAt,
+		Blocked:    rec.IsBlocked,
+	}
+	if s.DeviceName, err = r.crypt.Decrypt(rec.DeviceName); err != nil {
+		return entity.ActiveSession{}, err
+	}
+	if s.UserAgent, err = r.crypt.Decrypt(rec.UserAgent); err != nil {
+		return entity.ActiveSession{}, err
+	}
+	if s.IP, err = r.crypt.DecryptAddr(rec.ClientIP); err != nil {
+		return entity.ActiveSession{}, err
+	}
+	return s, nil
+}
+
+// RenameSession sets the device name of an unexpired session of the user, an empty name removes it.
+// It returns pgx.ErrNoRows if the user has no such session.
+func (r *SessionRepo) RenameSession(ctx context.Context, userID, sessionID uuid.UUID, name string) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("redis_rename_session", start, err)
+	}(time.Now())
+
+	key := sessionPrefix + sessionID.String()
+	return r.client.Watch(ctx, func(tx *redis.Tx) error {
+		rec, err := r.get(ctx, tx, sessionID)
+		if err != nil {
+			return err
+		}
+		if rec.UserID != userID || !rec.ExpiresAt.After(time.Now()) {
+			return pgx.ErrNoRows
+		}
+		rec.DeviceName = r.crypt.Encrypt(name)
+		value, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.SetArgs(ctx, key, value, redis.SetArgs{KeepTTL: true})
+			return nil
+		})
+		return err
+	}, key)
+}
+
+// SetSessionBlocked blocks or unblocks an unexpired session of the user, a blocked session is kept but cannot be
+// refreshed. It returns pgx.ErrNoRows if the user has no such session.
+func (r *SessionRepo) SetSessionBlocked(ctx context.Context, userID, sessionID uuid.UUID, blocked bool) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("redis_set_session_blocked", start, err)
+	}(time.Now())
+
+	key := sessionPrefix + sessionID.String()
+	return r.client.Watch(ctx, func(tx *redis.Tx) error {
+		rec, err := r.get(ctx, tx, sessionID)
+		if err != nil {
+			return err
+		}
+		if rec.UserID != userID || !rec.ExpiresAt.After(time.Now()) {
+			return pgx.ErrNoRows
+		}
+		rec.IsBlocked = blocked
+		value, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.SetArgs(ctx, key, value, redis.SetArgs{KeepTTL: true})
+			return nil
+		})
+		return err
+	}, key)
+}
+
+func (r *SessionRepo) RefreshSession(ctx context.Context, session entity.Session) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("redis_update_session", start, err)
+	}(time.Now())
+
+	return r.updateSession(ctx, session.ID, session)
+}
+
+// RotateSession updates the session like RefreshSession and moves it from previousID to session.ID.
+func (r *SessionRepo) RotateSession(ctx context.Context, previousID uuid.UUID, session entity.Session) (err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("redis_rotate_session", start, err)
+	}(time.Now())
+
+	return r.updateSession(ctx, previousID, session)
+}
+
+// updateSession applies a refresh or rotation to the session stored as previousID, pgx.ErrNoRows if it was
+// deleted since it was read. A concurrent change of the session fails with redis.TxFailedErr.
+func (r *SessionRepo) updateSession(ctx context.Context, previousID uuid.UUID, session entity.Session) error {
+	key := sessionPrefix + previousID.String()
+	return r.client.Watch(ctx, func(tx *redis.Tx) error {
+		old, err := r.get(ctx, tx, previousID)
+		if err != nil {
+			return err
+		}
+		if old.UserID != session.UserID {
+			return pgx.ErrNoRows
+		}
+		rec := old
+		rec.ID = session.ID
+		rec.CreatedAt = session.CreatedAt
+		rec.ExpiresAt = session.ExpiresAt
+		rec.RefreshToken = session.RefreshToken
+		rec.ClientIP = r.crypt.EncryptAddr(session.ClientIP)
+		rec.IPHash = session.IPHash
+		rec.AttestedKey = session.AttestedKey
+		rec.Privileges = session.Privileges
+		rec.ElevatedUntil = session.ElevatedUntil
+		rec.LastUsedAt = time.Now()
+		// the refresh token is kept as the previous one when it is rotated, to tell its reuse from an unknown token
+		if old.RefreshToken != rec.RefreshToken {
+			rec.PreviousRefreshToken = old.RefreshToken
+		}
+		value, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			r.remove(ctx, pipe, old)
+			r.write(ctx, pipe, rec, value)
+			return nil
+		})
+		return err
+	}, key)
+}
+
+// GetSessionByRefreshToken returns the session of the refresh token, pgx.ErrNoRows if there is none.
+func (r *SessionRepo) GetSessionByRefreshToken(ctx context.Context, refreshToken uuid.UUID) (session entity.Session, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("redis_get_session_by_refresh_token", start, err)
+	}(time.Now())
+
+	rec, err := r.lookup(ctx, tokenPrefix, refreshToken)
+	if err == nil && rec.RefreshToken != refreshToken {
+		err = pgx.ErrNoRows
+	}
+	if err != nil {
+		return entity.Session{}, err
+	}
+	return r.session(rec)
+}
+
+// GetSession returns the session of the user, pgx.ErrNoRows if the user has no session with the ID.
+func (r *SessionRepo) GetSession(ctx context.Context, userID, sessionID uuid.UUID) (session entity.Session, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("redis_get_session", start, err)
+	}(time.Now())
+
+	rec, err := r.get(ctx, r.client, sessionID)
+	if err == nil && rec.UserID != userID {
+		err = pgx.ErrNoRows
+	}
+	if err != nil {
+		return entity.Session{}, err
+	}
+	return r.session(rec)
+}
+
+// GetSessionByCanaryToken returns the session the decoy refresh token was issued with, pgx.ErrNoRows if none was.
+func (r *SessionRepo) GetSessionByCanaryToken(ctx context.Context, token uuid.UUID) (session entity.Session, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("redis_get_session_by_canary_token", start, err)
+	}(time.Now())
+
+	rec, err := r.lookup(ctx, canaryPrefix, token)
+	if err == nil && rec.CanaryToken != token {
+		err = pgx.ErrNoRows
+	}
+	if err != nil {
+		return entity.Session{}, err
+	}
+	return r.session(rec)
+}
+
+// GetSessionByPreviousRefreshToken returns the session whose refresh token was rotated away from the token,
+// pgx.ErrNoRows if there is none. Only the last rotated token of a session is kept.
+func (r *SessionRepo) GetSessionByPreviousRefreshToken(ctx context.Context, token uuid.UUID) (session entity.Session, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("redis_get_session_by_previous_refresh_token", start, err)
+	}(time.Now())
+
+	rec, err := r.lookup(ctx, previousTokenPrefix, token)
+	if err == nil && rec.PreviousRefreshToken != token {
+		err = pgx.ErrNoRows
+	}
+	if err != nil {
+		return entity.Session{}, err
+	}
+	return r.session(rec)
+}
+
+// SessionOrigins returns the IP, device hash, country and creation time of the last limit sessions of the user,
+// newest first. Unlike Postgres, sessions are forgotten as soon as they expire.
+func (r *SessionRepo) SessionOrigins(ctx context.Context, userID uuid.UUID, limit int) (origins []entity.SessionOrigin, err error) {
+	defer func(start time.Time) {
+		r.Metrics.ObserveDB("redis_session_origins", start, err)
+	}(time.Now())
+
+	records, err := r.userRecords(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(records, func(a, b record) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+	for _, rec := range records[:min(limit, len(records))] {
+		o := entity.SessionOrigin{DeviceHash: rec.DeviceHash, Country: rec.Country, CreatedAt: rec.CreatedAt}
+		if o.IP, err = r.crypt.DecryptAddr(rec.ClientIP); err != nil {
+			return nil, err
+		}
+		origins = append(origins, o)
+	}
+	return origins, nil
+}
+
+// get reads the session with the ID, pgx.ErrNoRows if it does not exist or expired.
+func (r *SessionRepo) get(ctx context.Context, client redis.Cmdable, sessionID uuid.UUID) (rec record, err error) {
+	value, err := client.Get(ctx, sessionPrefix+sessionID.String()).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return record{}, pgx.ErrNoRows
+	}
+	if err != nil {
+		return record{}, err
+	}
+	err = json.Unmarshal(value, &rec)
+	return rec, err
+}
+
+// lookup reads the session a token lookup key points to, pgx.ErrNoRows if there is none. The caller checks
+// that the session still has the token.
+func (r *SessionRepo) lookup(ctx context.Context, prefix string, token uuid.UUID) (record, error) {
+	id, err := r.client.Get(ctx, prefix+token.String()).Result()
+	if errors.Is(err, redis.Nil) {
+		return record{}, pgx.ErrNoRows
+	}
+	if err != nil {
+		return record{}, err
+	}
+	sessionID, err := uuid.Parse(id)
+	if err != nil {
+		return record{}, err
+	}
+	return r.get(ctx, r.client, sessionID)
+}
+
+// records returns the stored sessions with the IDs, the IDs of sessions that do not exist are skipped.
+func (r *SessionRepo) records(ctx context.Context, ids []string) ([]record, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = sessionPrefix + id
+	}
+	values, err := r.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	records := make([]record, 0, len(values))
+	for _, value := range values {
+		s, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var rec record
+		if err := json.Unmarshal([]byte(s), &rec); err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// userRecords returns the stored sessions of the user and drops the expired ones from its set.
+func (r *SessionRepo) userRecords(ctx context.Context, userID uuid.UUID) ([]record, error) {
+	userKey := userPrefix + userID.String()
+	ids, err := r.client.SMembers(ctx, userKey).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = sessionPrefix + id
+	}
+	values, err := r.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	records := make([]record, 0, len(values))
+	var expired []any
+	for i, value := range values {
+		s, ok := value.(string)
+		if !ok {
+			expired = append(expired, ids[i])
+			continue
+		}
+		var rec record
+		if err := json.Unmarshal([]byte(s), &rec); err != nil {
+			return nil, err
+		}
+		if rec.UserID == userID {
+			records = append(records, rec)
+		}
+	}
+	if len(expired) > 0 {
+		if err := r.client.SRem(ctx, userKey, expired...).Err(); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// session returns the stored session with its fields decrypted.
+func (r *SessionRepo) session(rec record) (session entity.Session, err error) {
+	session = entity.Session{
+		ID:             rec.ID,
+		UserID:         rec.UserID,
+		RefreshToken:   rec.RefreshToken,
+		IsBlocked:      rec.IsBlocked,
+		CreatedAt:      rec.CreatedAt,
+		ExpiresAt:      rec.ExpiresAt,
+		StartedAt:      rec.StartedAt,
+		ClientType:     rec.ClientType,
+		CertThumbprint: rec.CertThumbprint,
+		DPoPThumbprint: rec.DPoPThumbprint,
+		IPHash:         rec.IPHash,
+		DeviceHash:     rec.DeviceHash,
+		CanaryToken:    rec.CanaryToken,
+		Locale:         rec.Locale,
+		Timezone:       rec.Timezone,
+		Country:        rec.Country,
+		City:           rec.City,
+		Risk:           rec.Risk,
+		AttestedKey:    rec.AttestedKey,
+		BoundNetwork:   rec.BoundNetwork,
+		Privileges:     rec.Privileges,
+		ElevatedUntil:  rec.ElevatedUntil,
+		TokenState:     rec.TokenState,
+	}
+	if session.UserAgent, err = r.crypt.Decrypt(rec.UserAgent); err != nil {
+		return entity.Session{}, err
+	}
+	if session.DeviceName, err = r.crypt.Decrypt(rec.DeviceName); err != nil {
+		return entity.Session{}, err
+	}
+	if session.ClientIP, err = r.crypt.DecryptAddr(rec.ClientIP); err != nil {
+		return entity.Session{}, err
+	}
+	return session, nil
+}
@@ -0,0 +1,69 @@
+package tenant
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"main/domain/entity"
+)
+
+// Resolver maps the host of a request to the tenant domain serving it. Requests to hosts without
+// a tenant domain are served as the default domain of the service.
+type Resolver struct {
+	fallback entity.TenantDomain
+	byHost   map[string]entity.TenantDomain
+}
+
+// NewResolver validates the tenant domains. Hosts must be unique and issuers absolute http(s) URLs,
+// the issuer of the default domain is defaultIssuer.
+func NewResolver(defaultIssuer string, domains ...entity.TenantDomain) (*Resolver, error) {
+	r := &Resolver{
+		fallback: entity.TenantDomain{Issuer: strings.TrimSuffix(defaultIssuer, "/")},
+		byHost:   make(map[string]entity.TenantDomain, len(domains)),
+	}
+	for _, d := range domains {
+		d.Host = strings.ToLower(d.Host)
+		d.Issuer = strings.TrimSuffix(d.Issuer, "/")
+		if d.Host == "" {
+			return nil, fmt.Errorf("tenant domain without host")
+		}
+		if _, ok := r.byHost[d.Host]; ok {
+			return nil, fmt.Errorf("tenant domain %s configured twice", d.Host)
+		}
+		u, err := url.Parse(d.Issuer)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, fmt.Errorf("tenant domain %s: issuer must be an absolute http(s) URL", d.Host)
+		}
+		if d.CookieDomain != "" && !strings.HasSuffix(d.Host, strings.TrimPrefix(d.CookieDomain, ".")) {
+			return nil, fmt.Errorf("tenant domain %s: cookie domain %s does not cover the host", d.Host, d.CookieDomain)
+		}
+		r.byHost[d.Host] = d
+	}
+	return r, nil
+}
+
+// Resolve returns the tenant domain of the host, which may carry a port.
+func (r *Resolver) Resolve(host string) entity.TenantDomain {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if d, ok := r.byHost[strings.ToLower(host)]; ok {
+		return d
+	}
+	return r.fallback
+}
+
+// Issuers returns the issuer of the default domain followed by the issuers of the tenant domains.
+func (r *Resolver) Issuers() []string {
+	issuers := []string{r.fallback.Issuer}
+	seen := map[string]bool{r.fallback.Issuer: true}
+	for _, d := range r.byHost {
+		if !seen[d.Issuer] {
+			seen[d.Issuer] = true
+			issuers = append(issuers, d.Issuer)
+		}
+	}
+	return issuers
+}
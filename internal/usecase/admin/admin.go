@@ -0,0 +1,388 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"main/domain/entity"
+	"main/pkg/customerrors"
+	"main/pkg/locale"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// AdminRepo defines the interface for the user operations of the admin surface.
+type AdminRepo interface {
+	// SoftDeleteUser marks the user as deleted and deletes all its sessions.
+	SoftDeleteUser(ctx context.Context, userID uuid.UUID) error
+
+	// RestoreUser clears the soft deletion of the user.
+	RestoreUser(ctx context.Context, userID uuid.UUID) error
+
+	// ListUsers returns a page of live users matching the filter.
+	ListUsers(ctx context.Context, filter entity.UserFilter) (entity.UserPage, error)
+
+	// GetUserDetail returns the user without its sessions, pgx.ErrNoRows if it does not exist.
+	// Its locale and timezone select the language of the notifications.
+	GetUserDetail(ctx context.Context, userID uuid.UUID) (entity.UserDetail, error)
+
+	// SetUserBlocked blocks or unblocks the user, blocking revokes its access tokens.
+	SetUserBlocked(ctx context.Context, userID uuid.UUID, blocked bool) error
+
+	// ForceLogout revokes the access tokens of the target user and records the action. In dry-run mode it only
+	// checks that the user exists.
+	ForceLogout(ctx context.Context, action entity.AdminAction, dryRun bool) error
+}
+
+// SessionRevoker finds and deletes sessions in the session store, Postgres or Redis, which may not be the
+// database of AdminRepo.
+type SessionRevoker interface {
+	// UserSessions returns the unexpired sessions of the user, newest first.
+	UserSessions(ctx context.Context, userID uuid.UUID) ([]entity.Session, error)
+
+	// ListSessionIDs returns the IDs of all sessions of the user.
+	ListSessionIDs(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, error)
+
+	// RemoveUserSessions deletes all sessions of the users, whose token versions are incremented already, and
+	// returns their IDs.
+	RemoveUserSessions(ctx context.Context, userIDs []uuid.UUID) ([]uuid.UUID, error)
+
+	// SessionsByTokens returns the sessions whose ID or refresh token is one of the tokens.
+	SessionsByTokens(ctx context.Context, tokens []uuid.UUID) ([]entity.Session, error)
+
+	// DeleteSessions deletes the sessions and returns how many it deleted.
+	DeleteSessions(ctx context.Context, sessionIDs []uuid.UUID) (int64, error)
+}
+
+// TokenInvalidator publishes the token versions of users whose access tokens were revoked in the database.
+type TokenInvalidator interface {
+	Invalidated(ctx context.Context, userIDs ...uuid.UUID) error
+}
+
+// UserSessions lists and blocks the sessions of users in the session store, implemented by auth.AuthUsecase.
+type UserSessions interface {
+	ListSessionPage(ctx context.Context, filter entity.SessionFilter, cursor string, currentID uuid.UUID) (entity.SessionPage, error)
+	BlockSession(ctx context.Context, userID, sessionID uuid.UUID) error
+	UnblockSession(ctx context.Context, userID, sessionID uuid.UUID) error
+}
+
+// PasswordResetter starts the password reset of a user on behalf of an administrator.
+type PasswordResetter interface {
+	ForceReset(ctx context.Context, userID uuid.UUID) error
+}
+
+// Mailer sends emails to users.
+type Mailer interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+// Auditor records the actions of administrators in the audit log, implemented by audit.AuditLogger.
+type Auditor interface {
+	Record(ctx context.Context, event entity.AuditEvent)
+}
+
+// AdminUsecase implements user management for administrators. Permissions are checked by the
+// delivery layer, destructive actions require a structured reason which is recorded with the actor.
+type AdminUsecase struct {
+	adminRepo AdminRepo
+	passwords PasswordResetter
+	tokens    TokenInvalidator
+	logger    *slog.Logger
+	audit     Auditor
+	sessions  SessionRevoker
+	denylist  SessionDenier
+	// userSessions are the sessions of the session store, which may not be the database of adminRepo
+	userSessions UserSessions
+	// objects opens the lists of compromised tokens kept in an object store, nil when none is configured
+	objects ObjectStore
+	// mailer notifies users of the destructive actions taken on their account
+	mailer Mailer
+}
+
+func NewAdminUsecase(adminRepo AdminRepo, passwords PasswordResetter, tokens TokenInvalidator, logger *slog.Logger, audit Auditor,
+	sessions SessionRevoker, denylist SessionDenier, userSessions UserSessions, objects ObjectStore, mailer Mailer) *AdminUsecase {
+	return &AdminUsecase{
+		adminRepo:    adminRepo,
+		passwords:    passwords,
+		tokens:       tokens,
+		logger:       logger,
+		audit:        audit,
+		sessions:     sessions,
+		denylist:     denylist,
+		userSessions: userSessions,
+		objects:      objects,
+		mailer:       mailer,
+	}
+}
+
+// Page size limits of ListUsers.
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// ListUsers returns a page of users, newest first. The limit defaults to 50 and is capped at 200.
+func (uc *AdminUsecase) ListUsers(ctx context.Context, filter entity.UserFilter) (entity.UserPage, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = defaultPageSize
+	}
+	filter.Limit = min(filter.Limit, maxPageSize)
+	filter.Offset = max(filter.Offset, 0)
+	return uc.adminRepo.ListUsers(ctx, filter)
+}
+
+// GetUser returns the user with its active sessions.
+func (uc *AdminUsecase) GetUser(ctx context.Context, userID uuid.UUID) (entity.UserDetail, error) {
+	detail, err := uc.adminRepo.GetUserDetail(ctx, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return entity.UserDetail{}, customerrors.ErrUserNotFound
+	}
+	if err != nil {
+		return entity.UserDetail{}, err
+	}
+	detail.Sessions, err = uc.sessions.UserSessions(ctx, userID)
+	return detail, err
+}
+
+// BlockUser blocks the user: it is logged out everywhere and can no longer log in until unblocked.
+// The user is emailed the reason.
+func (uc *AdminUsecase) BlockUser(ctx context.Context, adminID, userID uuid.UUID, reason entity.AdminReason) error {
+	if !reason.Valid() {
+		return customerrors.ErrReasonRequired
+	}
+	if err := uc.setBlocked(ctx, userID, true); err != nil {
+		return err
+	}
+	uc.logger.Info("User blocked by admin",
+		"admin_id", adminID, "user_id", userID, "reason_code", reason.Code, "reason", reason.Text)
+	uc.record(ctx, entity.AdminActionBlock, adminID, userID, reason)
+	if detail, err := uc.adminRepo.GetUserDetail(ctx, userID); err == nil {
+		uc.notify(ctx, detail.User, "admin_block", reason)
+	} else {
+		uc.logger.Error("Failed to read blocked user for the notification", "user_id", userID, "error", err)
+	}
+	return nil
+}
+
+// UnblockUser lets a blocked user log in again.
+func (uc *AdminUsecase) UnblockUser(ctx context.Context, adminID, userID uuid.UUID) error {
+	if err := uc.setBlocked(ctx, userID, false); err != nil {
+		return err
+	}
+	uc.logger.Info("User unblocked by admin", "admin_id", adminID, "user_id", userID)
+	uc.record(ctx, entity.AdminActionUnblock, adminID, userID, entity.AdminReason{})
+	return nil
+}
+
+func (uc *AdminUsecase) setBlocked(ctx context.Context, userID uuid.UUID, blocked bool) error {
+	err := uc.adminRepo.SetUserBlocked(ctx, userID, blocked)
+	if errors.Is(err, customerrors.ErrNoTagsAffected) {
+		return customerrors.ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if blocked {
+		uc.invalidateTokens(ctx, userID)
+		uc.endSessions(ctx, userID)
+	}
+	return nil
+}
+
+// invalidateTokens publishes the incremented token version of the user. The version is already incremented
+// in the database, a failure only delays the revocation until the cached version expires.
+func (uc *AdminUsecase) invalidateTokens(ctx context.Context, userID uuid.UUID) {
+	if err := uc.tokens.Invalidated(ctx, userID); err != nil {
+		uc.logger.Error("Failed to publish token version", "user_id", userID, "error", err)
+	}
+}
+
+// endSessions deletes the sessions of the user from the session store and returns their IDs. Its token version
+// is already incremented, a failure leaves sessions that cannot be refreshed.
+func (uc *AdminUsecase) endSessions(ctx context.Context, userID uuid.UUID) []uuid.UUID {
+	ids, err := uc.sessions.RemoveUserSessions(ctx, []uuid.UUID{userID})
+	if err != nil {
+		uc.logger.Error("Failed to delete sessions", "user_id", userID, "error", err)
+	}
+	return ids
+}
+
+// ForceLogout ends every session of the user and revokes its outstanding access tokens, both by the token version and
+// by denying the sessions (see auth.SessionDenylist), over HTTP and gRPC alike. The user has to log in again on every
+// device. The administrator and the reason are recorded with the action, the user is emailed the reason. In dry-run
+// mode nothing changes, the report lists the sessions that would be revoked.
+func (uc *AdminUsecase) ForceLogout(ctx context.Context, adminID, userID uuid.UUID, reason entity.AdminReason, dryRun bool) (entity.AffectedReport, error) {
+	if !reason.Valid() {
+		return entity.AffectedReport{}, customerrors.ErrReasonRequired
+	}
+	err := uc.adminRepo.ForceLogout(ctx, entity.AdminAction{
+		ID:         uuid.New(),
+		ActorID:    adminID,
+		Action:     entity.AdminActionForceLogout,
+		TargetType: entity.AdminTargetUser,
+		TargetID:   userID,
+		Reason:     reason,
+		CreatedAt:  time.Now(),
+	}, dryRun)
+	if errors.Is(err, customerrors.ErrNoTagsAffected) {
+		return entity.AffectedReport{}, customerrors.ErrUserNotFound
+	}
+	if err != nil {
+		return entity.AffectedReport{}, err
+	}
+	if dryRun {
+		ids, err := uc.sessions.ListSessionIDs(ctx, userID)
+		if err != nil {
+			return entity.AffectedReport{}, err
+		}
+		return entity.AffectedReport{DryRun: true, Count: len(ids), IDs: ids}, nil
+	}
+	uc.invalidateTokens(ctx, userID)
+	ids := uc.endSessions(ctx, userID)
+	// the sessions are deleted and the version incremented, a failed denial is covered by the version
+	if err := uc.denylist.Deny(ctx, ids); err != nil {
+		uc.logger.Error("Failed to deny logged out sessions", "user_id", userID, "error", err)
+	}
+	uc.logger.Info("User logged out by admin", "admin_id", adminID, "user_id", userID,
+		"sessions", len(ids), "reason_code", reason.Code, "reason", reason.Text)
+	if detail, err := uc.adminRepo.GetUserDetail(ctx, userID); err == nil {
+		uc.notify(ctx, detail.User, "admin_logout", reason)
+	} else {
+		uc.logger.Error("Failed to read logged out user for the notification", "user_id", userID, "error", err)
+	}
+	return entity.AffectedReport{Count: len(ids), IDs: ids}, nil
+}
+
+// ListUserSessions returns a page of the sessions of the user, see auth.AuthUsecase.ListSessionPage for the
+// filter and the cursor.
+func (uc *AdminUsecase) ListUserSessions(ctx context.Context, filter entity.SessionFilter, cursor string) (entity.SessionPage, error) {
+	return uc.userSessions.ListSessionPage(ctx, filter, cursor, uuid.Nil)
+}
+
+// BlockSession freezes a session of the user, a suspicious device for example, without logging the user out
+// elsewhere. The session is kept: it cannot be refreshed and its access tokens are denied until unblocked.
+func (uc *AdminUsecase) BlockSession(ctx context.Context, adminID, userID, sessionID uuid.UUID, reason entity.AdminReason) error {
+	if !reason.Valid() {
+		return customerrors.ErrReasonRequired
+	}
+	if err := uc.userSessions.BlockSession(ctx, userID, sessionID); err != nil {
+		return err
+	}
+	uc.logger.Info("Session blocked by admin", "admin_id", adminID, "user_id", userID, "session_id", sessionID,
+		"reason_code", reason.Code, "reason", reason.Text)
+	uc.recordSession(ctx, entity.AdminActionSessionBlock, adminID, userID, sessionID, reason)
+	return nil
+}
+
+// UnblockSession lets a blocked session of the user be refreshed again.
+func (uc *AdminUsecase) UnblockSession(ctx context.Context, adminID, userID, sessionID uuid.UUID) error {
+	if err := uc.userSessions.UnblockSession(ctx, userID, sessionID); err != nil {
+		return err
+	}
+	uc.logger.Info("Session unblocked by admin", "admin_id", adminID, "user_id", userID, "session_id", sessionID)
+	uc.recordSession(ctx, entity.AdminActionSessionUnblock, adminID, userID, sessionID, entity.AdminReason{})
+	return nil
+}
+
+// ForcePasswordReset invalidates the password of the user, logs it out everywhere and emails it a reset link.
+func (uc *AdminUsecase) ForcePasswordReset(ctx context.Context, adminID, userID uuid.UUID, reason entity.AdminReason) error {
+	if !reason.Valid() {
+		return customerrors.ErrReasonRequired
+	}
+	if err := uc.passwords.ForceReset(ctx, userID); err != nil {
+		return err
+	}
+	uc.logger.Info("Password reset forced by admin",
+		"admin_id", adminID, "user_id", userID, "reason_code", reason.Code, "reason", reason.Text)
+	uc.record(ctx, entity.AdminActionPasswordReset, adminID, userID, reason)
+	return nil
+}
+
+// DeleteUser soft-deletes the user: it is treated as nonexistent and logged out everywhere,
+// but the record is kept and can be restored. The user is emailed the reason.
+func (uc *AdminUsecase) DeleteUser(ctx context.Context, adminID, userID uuid.UUID, reason entity.AdminReason) error {
+	if !reason.Valid() {
+		return customerrors.ErrReasonRequired
+	}
+	// read before the deletion, deleted users are not found
+	detail, err := uc.adminRepo.GetUserDetail(ctx, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return customerrors.ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	err = uc.adminRepo.SoftDeleteUser(ctx, userID)
+	if errors.Is(err, customerrors.ErrNoTagsAffected) {
+		return customerrors.ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	uc.invalidateTokens(ctx, userID)
+	uc.endSessions(ctx, userID)
+	uc.logger.Info("User soft-deleted by admin",
+		"admin_id", adminID, "user_id", userID, "reason_code", reason.Code, "reason", reason.Text)
+	uc.record(ctx, entity.AdminActionDelete, adminID, userID, reason)
+	uc.notify(ctx, detail.User, "admin_delete", reason)
+	return nil
+}
+
+// RestoreUser restores a soft-deleted user. It fails with customerrors.ErrEmailTaken
+// if the username or email was registered by someone else in the meantime.
+func (uc *AdminUsecase) RestoreUser(ctx context.Context, adminID, userID uuid.UUID) error {
+	err := uc.adminRepo.RestoreUser(ctx, userID)
+	var pgErr *pgconn.PgError
+	switch {
+	case errors.Is(err, customerrors.ErrNoTagsAffected):
+		return customerrors.ErrUserNotFound
+	case errors.As(err, &pgErr) && pgErr.Code == "23505":
+		return customerrors.ErrEmailTaken
+	case err != nil:
+		return err
+	}
+	uc.logger.Info("User restored by admin", "admin_id", adminID, "user_id", userID)
+	uc.record(ctx, entity.AdminActionRestore, adminID, userID, entity.AdminReason{})
+	return nil
+}
+
+// record adds the action of the administrator on the user to the audit log. Force logouts are recorded
+// by the repository together with the logout.
+func (uc *AdminUsecase) record(ctx context.Context, action string, adminID, userID uuid.UUID, reason entity.AdminReason) {
+	uc.audit.Record(ctx, entity.AuditEvent{
+		Action:     action,
+		ActorID:    &adminID,
+		TargetType: entity.AdminTargetUser,
+		TargetID:   &userID,
+		ReasonCode: reason.Code,
+		Reason:     reason.Text,
+	})
+}
+
+// notify emails the user that an administrator took the action on its account, with the reason code and text.
+// The messages of the action are the ones with the key prefix action. The action is already done, a failed
+// email is only logged.
+func (uc *AdminUsecase) notify(ctx context.Context, user entity.User, action string, reason entity.AdminReason) {
+	lang := user.Locale
+	body := locale.T(lang, "admin_action.body", locale.T(lang, action+".intro"),
+		locale.T(lang, "admin_reason."+string(reason.Code)), reason.Text)
+	if err := uc.mailer.Send(ctx, user.Email, locale.T(lang, action+".subject"), body); err != nil {
+		uc.logger.Error("Failed to notify user of admin action", "user_id", user.ID, "action", action, "error", err)
+	}
+}
+
+// recordSession adds the action of the administrator on a session of the user to the audit log.
+func (uc *AdminUsecase) recordSession(ctx context.Context, action string, adminID, userID, sessionID uuid.UUID, reason entity.AdminReason) {
+	uc.audit.Record(ctx, entity.AuditEvent{
+		Action:     action,
+		ActorID:    &adminID,
+		TargetType: entity.AdminTargetSession,
+		TargetID:   &sessionID,
+		ReasonCode: reason.Code,
+		Reason:     reason.Text,
+		Details:    map[string]string{"user_id": userID.String()},
+	})
+}
@@ -0,0 +1,129 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"main/domain/entity"
+	"main/pkg/customerrors"
+	"main/pkg/tokenlist"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// maxCompromisedTokens is the number of entries one list may contain, larger lists must be split.
+const maxCompromisedTokens = 100000
+
+// SessionDenier rejects the access tokens of sessions until they expire, implemented by auth.SessionDenylist.
+type SessionDenier interface {
+	Deny(ctx context.Context, sessionIDs []uuid.UUID) error
+}
+
+// ObjectStore opens the lists kept in an object store, implemented by s3.Client.
+type ObjectStore interface {
+	// Open returns the object at the path, s3://bucket/key.
+	Open(ctx context.Context, path string) (io.ReadCloser, error)
+}
+
+// RevokeCompromisedTokensFrom revokes the tokens of the list at the object store path, see RevokeCompromisedTokens.
+func (uc *AdminUsecase) RevokeCompromisedTokensFrom(ctx context.Context, adminID uuid.UUID, path string,
+	reason entity.AdminReason, dryRun bool) (entity.CompromisedTokenReport, error) {
+	if !reason.Valid() {
+		return entity.CompromisedTokenReport{}, customerrors.ErrReasonRequired
+	}
+	if uc.objects == nil {
+		return entity.CompromisedTokenReport{}, customerrors.ErrObjectStoreDisabled
+	}
+	body, err := uc.objects.Open(ctx, path)
+	if err != nil {
+		return entity.CompromisedTokenReport{}, err
+	}
+	defer body.Close()
+	entries, err := tokenlist.Decode(body)
+	if err != nil {
+		return entity.CompromisedTokenReport{}, fmt.Errorf("invalid token list %s: %w", path, err)
+	}
+	return uc.RevokeCompromisedTokens(ctx, adminID, entries, reason, dryRun)
+}
+
+// RevokeCompromisedTokens ends the sessions of a list of leaked refresh tokens and session IDs, both are accepted
+// in the same list. The sessions are denied before they are deleted, so their outstanding access tokens are
+// rejected at once and a failed revocation can be repeated with the same list. Entries that are no tokens
+// are reported as invalid, the others are revoked. In dry-run mode nothing changes.
+func (uc *AdminUsecase) RevokeCompromisedTokens(ctx context.Context, adminID uuid.UUID, entries []string,
+	reason entity.AdminReason, dryRun bool) (entity.CompromisedTokenReport, error) {
+	if !reason.Valid() {
+		return entity.CompromisedTokenReport{}, customerrors.ErrReasonRequired
+	}
+	if len(entries) > maxCompromisedTokens {
+		return entity.CompromisedTokenReport{}, fmt.Errorf("%w: at most %d", customerrors.ErrTokenListTooLarge, maxCompromisedTokens)
+	}
+
+	report := entity.CompromisedTokenReport{DryRun: dryRun, Sessions: []uuid.UUID{}, Users: []uuid.UUID{}, Invalid: []string{}}
+	seen := make(map[uuid.UUID]bool, len(entries))
+	tokens := make([]uuid.UUID, 0, len(entries))
+	for _, entry := range entries {
+		token, err := uuid.Parse(entry)
+		if err != nil || token == uuid.Nil {
+			report.Invalid = append(report.Invalid, entry)
+			continue
+		}
+		if !seen[token] {
+			seen[token] = true
+			tokens = append(tokens, token)
+		}
+	}
+	report.Tokens = len(tokens)
+	if len(tokens) == 0 {
+		return report, nil
+	}
+
+	sessions, err := uc.sessions.SessionsByTokens(ctx, tokens)
+	if err != nil {
+		return entity.CompromisedTokenReport{}, err
+	}
+	matched, users := 0, make(map[uuid.UUID]bool)
+	for _, session := range sessions {
+		report.Sessions = append(report.Sessions, session.ID)
+		for _, token := range []uuid.UUID{session.ID, session.RefreshToken} {
+			if seen[token] {
+				// a token is counted once, even if the list holds both the ID and the refresh token
+				seen[token] = false
+				matched++
+			}
+		}
+		if !users[session.UserID] {
+			users[session.UserID] = true
+			report.Users = append(report.Users, session.UserID)
+		}
+	}
+	report.Unknown = len(tokens) - matched
+	if dryRun || len(report.Sessions) == 0 {
+		return report, nil
+	}
+
+	if err := uc.denylist.Deny(ctx, report.Sessions); err != nil {
+		return entity.CompromisedTokenReport{}, fmt.Errorf("deny sessions: %w", err)
+	}
+	if _, err := uc.sessions.DeleteSessions(ctx, report.Sessions); err != nil {
+		return entity.CompromisedTokenReport{}, fmt.Errorf("delete sessions: %w", err)
+	}
+
+	uc.logger.Warn("Compromised tokens revoked by admin", "admin_id", adminID, "tokens", report.Tokens,
+		"sessions", len(report.Sessions), "users", len(report.Users), "unknown", report.Unknown,
+		"reason_code", reason.Code, "reason", reason.Text)
+	uc.audit.Record(ctx, entity.AuditEvent{
+		Action:     entity.AdminActionRevokeCompromised,
+		ActorID:    &adminID,
+		ReasonCode: reason.Code,
+		Reason:     reason.Text,
+		Details: map[string]string{
+			"tokens":   strconv.Itoa(report.Tokens),
+			"sessions": strconv.Itoa(len(report.Sessions)),
+			"users":    strconv.Itoa(len(report.Users)),
+			"unknown":  strconv.Itoa(report.Unknown),
+		},
+	})
+	return report, nil
+}
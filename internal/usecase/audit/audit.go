@@ -0,0 +1,105 @@
+package audit
+
+import (
+	"context"
+	"log/slog"
+	"main/domain/entity"
+	ctxUtil "main/pkg/utils/context"
+	"maps"
+	"net/netip"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AuditRepo defines the interface for the storage of the audit log.
+type AuditRepo interface {
+	// StoreAuditEvent appends the event to the log.
+	StoreAuditEvent(ctx context.Context, event entity.AuditEvent) error
+
+	// ListAuditEvents returns a page of the events matching the filter, newest first.
+	ListAuditEvents(ctx context.Context, filter entity.AuditFilter) (entity.AuditPage, error)
+}
+
+// Fingerprinter reduces the client of an event to what the privacy mode allows to store.
+type Fingerprinter interface {
+	Fingerprint(ip netip.Addr, userAgent string) entity.ClientFingerprint
+}
+
+// Page size limits of List.
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// AuditLogger records security relevant actions of users and administrators in the append-only audit log
+// and lets administrators query it.
+type AuditLogger struct {
+	repo          AuditRepo
+	logger        *slog.Logger
+	fingerprinter Fingerprinter
+}
+
+func NewAuditLogger(repo AuditRepo, logger *slog.Logger, fingerprinter Fingerprinter) *AuditLogger {
+	return &AuditLogger{
+		repo:          repo,
+		logger:        logger,
+		fingerprinter: fingerprinter,
+	}
+}
+
+// Record appends the event to the log. The ID and time are set when missing, the client defaults to the one
+// of the request (see ctxUtil.RequestInfo) and the service of a machine token is added to the details.
+// Recording is best effort: a failure is logged and must not change the outcome of the action.
+func (a *AuditLogger) Record(ctx context.Context, event entity.AuditEvent) {
+	if event.ID == uuid.Nil {
+		event.ID = uuid.New()
+	}
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now().UTC()
+	}
+	if client, ok := ctxUtil.RequestInfoFromContext(ctx); ok {
+		if !event.IP.IsValid() {
+			event.IP, _ = netip.ParseAddr(client.IP)
+		}
+		if event.UserAgent == "" {
+			event.UserAgent = client.UserAgent
+		}
+	}
+	// services acting for users with a machine token are named, the actor alone reads like the user acted
+	if client, ok := ctxUtil.ClientFromContext(ctx); ok {
+		event.Details = maps.Clone(event.Details)
+		if event.Details == nil {
+			event.Details = map[string]string{}
+		}
+		event.Details["client_id"] = client.ID
+	}
+	if event.IP.IsValid() {
+		fp := a.fingerprinter.Fingerprint(event.IP, event.UserAgent)
+		event.IP, event.UserAgent = fp.IP, fp.UserAgent
+	} else {
+		// without an address the privacy mode cannot be applied to the user agent
+		event.UserAgent = ""
+	}
+	if err := a.repo.StoreAuditEvent(ctx, event); err != nil {
+		a.logger.Error("Failed to record audit event", "action", event.Action, "actor_id", event.ActorID,
+			"target_id", event.TargetID, "error", err)
+	}
+}
+
+// List returns a page of the events matching the filter, newest first. The limit defaults to 50 and is capped at 200.
+func (a *AuditLogger) List(ctx context.Context, filter entity.AuditFilter) (entity.AuditPage, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = defaultPageSize
+	}
+	filter.Limit = min(filter.Limit, maxPageSize)
+	filter.Offset = max(filter.Offset, 0)
+	page, err := a.repo.ListAuditEvents(ctx, filter)
+	if err != nil {
+		return entity.AuditPage{}, err
+	}
+	if page.Events == nil {
+		page.Events = []entity.AuditEvent{}
+	}
+	return page, nil
+}
@@ -0,0 +1,268 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"main/domain/entity"
+	"main/pkg/customerrors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// AccountRepo defines the interface for account lifecycle storage.
+type AccountRepo interface {
+	// ScheduleDeletion marks the account for deletion and deletes all its sessions.
+	ScheduleDeletion(ctx context.Context, userID uuid.UUID, deleteAt time.Time) error
+
+	// CancelDeletion clears a scheduled deletion.
+	CancelDeletion(ctx context.Context, userID uuid.UUID) error
+
+	// PurgeDeletedUsers permanently deletes the accounts scheduled for deletion before the given time.
+	PurgeDeletedUsers(ctx context.Context, before time.Time) ([]uuid.UUID, error)
+
+	// ListDeletedUsers returns the accounts PurgeDeletedUsers would delete.
+	ListDeletedUsers(ctx context.Context, before time.Time) ([]uuid.UUID, error)
+
+	// GetSecurityFacts returns the properties of the account the security score is computed from, without
+	// the session counts.
+	GetSecurityFacts(ctx context.Context, userID uuid.UUID) (entity.SecurityFacts, error)
+
+	// UpdateUsername changes the username of a live account.
+	UpdateUsername(ctx context.Context, userID uuid.UUID, username string) error
+}
+
+// AccountSessions are the sessions of the session store, Postgres or Redis, which may not be the database
+// of AccountRepo.
+type AccountSessions interface {
+	// ListActiveSessions returns the unexpired sessions of the user, the last used first.
+	ListActiveSessions(ctx context.Context, userID uuid.UUID) ([]entity.ActiveSession, error)
+
+	// DeleteAllSessions removes all sessions of the user and increments its token version.
+	DeleteAllSessions(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, error)
+}
+
+const (
+	// passwordMaxAge is the age after which changing the password is recommended
+	passwordMaxAge = 365 * 24 * time.Hour
+	// staleSessionAge is the time after which an unused session is considered forgotten
+	staleSessionAge = 30 * 24 * time.Hour
+	// maxHealthySessions is the number of active sessions above which reviewing them is recommended
+	maxHealthySessions = 10
+)
+
+// AccountUsecase implements account deletion (right to erasure). Deletion is scheduled after a grace period,
+// during which the user can still log in and cancel it, and performed by a background purge job.
+type AccountUsecase struct {
+	accountRepo AccountRepo
+	userRepo    UserRepo
+	sessions    AccountSessions
+	logger      *slog.Logger
+	gracePeriod time.Duration
+	// passkeysEnabled and mfaEnabled add the passkey and the two-factor checks to the security score
+	passkeysEnabled bool
+	mfaEnabled      bool
+	// handles rejects reserved and banned usernames
+	handles HandlePolicy
+}
+
+func NewAccountUsecase(accountRepo AccountRepo, userRepo UserRepo, sessions AccountSessions, logger *slog.Logger,
+	gracePeriod time.Duration, passkeysEnabled, mfaEnabled bool, handles HandlePolicy) *AccountUsecase {
+	return &AccountUsecase{
+		accountRepo:     accountRepo,
+		userRepo:        userRepo,
+		sessions:        sessions,
+		logger:          logger,
+		gracePeriod:     gracePeriod,
+		passkeysEnabled: passkeysEnabled,
+		mfaEnabled:      mfaEnabled,
+		handles:         handles,
+	}
+}
+
+// DeleteAccount verifies the password, schedules the deletion of the account and revokes all its sessions.
+// Access tokens already issued are revoked by the token version once its cached value expires. Returns the time
+// the account will be purged at.
+func (uc *AccountUsecase) DeleteAccount(ctx context.Context, userID uuid.UUID, password string) (time.Time, error) {
+	user, err := uc.userRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !verifyPassword(password, user.PasswordHash) {
+		return time.Time{}, customerrors.ErrInvalidCredentials
+	}
+
+	deleteAt := time.Now().Add(uc.gracePeriod)
+	err = uc.accountRepo.ScheduleDeletion(ctx, userID, deleteAt)
+	if errors.Is(err, customerrors.ErrNoTagsAffected) {
+		return time.Time{}, customerrors.ErrDeletionScheduled
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	// the repository only ends the sessions kept in Postgres
+	if _, err := uc.sessions.DeleteAllSessions(ctx, userID); err != nil {
+		uc.logger.Error("Failed to end the sessions of the account scheduled for deletion", "user_id", userID, "error", err)
+	}
+	uc.logger.Info("Account deletion scheduled", "user_id", userID, "delete_at", deleteAt)
+	return deleteAt, nil
+}
+
+// CancelDeletion cancels a scheduled deletion of the account.
+func (uc *AccountUsecase) CancelDeletion(ctx context.Context, userID uuid.UUID) error {
+	err := uc.accountRepo.CancelDeletion(ctx, userID)
+	if errors.Is(err, customerrors.ErrNoTagsAffected) {
+		return customerrors.ErrNoDeletionScheduled
+	}
+	if err != nil {
+		return err
+	}
+	uc.logger.Info("Account deletion cancelled", "user_id", userID)
+	return nil
+}
+
+// ChangeUsername verifies the password and changes the username of the account. Reserved and banned usernames
+// are rejected with customerrors.ErrHandleUnavailable, usernames of other accounts with customerrors.ErrUsernameTaken.
+func (uc *AccountUsecase) ChangeUsername(ctx context.Context, userID uuid.UUID, password, username string) error {
+	if !validateUsername(username) {
+		return customerrors.ErrInvalidUsername
+	}
+	user, err := uc.userRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !verifyPassword(password, user.PasswordHash) {
+		return customerrors.ErrInvalidCredentials
+	}
+	if user.Username == username {
+		return nil
+	}
+	if err := uc.handles.Check(ctx, username, ""); err != nil {
+		return err
+	}
+
+	err = uc.accountRepo.UpdateUsername(ctx, userID, username)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return customerrors.ErrUsernameTaken
+	}
+	if err != nil {
+		return err
+	}
+	uc.logger.Info("Username changed", "user_id", userID, "old_username", user.Username, "username", username)
+	return nil
+}
+
+// PurgeDeletedAccounts permanently deletes all accounts whose grace period is over.
+// In dry-run mode it only reports the accounts that would be deleted.
+func (uc *AccountUsecase) PurgeDeletedAccounts(ctx context.Context, dryRun bool) (entity.AffectedReport, error) {
+	purge := uc.accountRepo.PurgeDeletedUsers
+	if dryRun {
+		purge = uc.accountRepo.ListDeletedUsers
+	}
+	ids, err := purge(ctx, time.Now())
+	if err != nil {
+		return entity.AffectedReport{}, err
+	}
+	return entity.AffectedReport{DryRun: dryRun, Count: len(ids), IDs: ids}, nil
+}
+
+// RunPurgeJob purges deleted accounts every interval until the context is cancelled.
+// In dry-run mode the job only logs the accounts it would delete.
+func (uc *AccountUsecase) RunPurgeJob(ctx context.Context, interval time.Duration, dryRun bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := uc.PurgeDeletedAccounts(ctx, dryRun)
+			if err != nil {
+				uc.logger.Error("Failed to purge deleted accounts", "error", err)
+				continue
+			}
+			if report.Count > 0 {
+				uc.logger.Info("Purged deleted accounts", "count", report.Count, "user_ids", report.IDs, "dry_run", dryRun)
+			}
+		}
+	}
+}
+
+// SecurityScore rates the protection of the account and recommends actions for every failed check.
+func (uc *AccountUsecase) SecurityScore(ctx context.Context, userID uuid.UUID) (entity.SecurityScore, error) {
+	facts, err := uc.accountRepo.GetSecurityFacts(ctx, userID)
+	if err != nil {
+		return entity.SecurityScore{}, err
+	}
+	sessions, err := uc.sessions.ListActiveSessions(ctx, userID)
+	if err != nil {
+		return entity.SecurityScore{}, err
+	}
+	staleBefore := time.Now().Add(-staleSessionAge)
+	facts.ActiveSessions = len(sessions)
+	for _, s := range sessions {
+		if s.LastUsedAt.Before(staleBefore) {
+			facts.StaleSessions++
+		}
+	}
+
+	checks := []entity.SecurityCheck{
+		{
+			ID:             "email_verified",
+			Passed:         facts.EmailVerified,
+			Weight:         30,
+			Recommendation: "Verify your email address so you can recover the account.",
+		},
+		{
+			ID:             "password_recent",
+			Passed:         time.Since(facts.PasswordChangedAt) < passwordMaxAge,
+			Weight:         30,
+			Recommendation: "Your password is more than a year old, change it.",
+		},
+		{
+			ID:             "no_stale_sessions",
+			Passed:         facts.StaleSessions == 0,
+			Weight:         20,
+			Recommendation: "Sign out of devices you have not used for a month.",
+		},
+		{
+			ID:             "few_sessions",
+			Passed:         facts.ActiveSessions <= maxHealthySessions,
+			Weight:         20,
+			Recommendation: "You are signed in on many devices, review them and sign out of the ones you do not recognise.",
+		},
+	}
+	if uc.passkeysEnabled {
+		checks = append(checks, entity.SecurityCheck{
+			ID:             "passkey",
+			Passed:         facts.Passkeys > 0,
+			Weight:         20,
+			Recommendation: "Add a passkey to sign in without a password, it cannot be phished.",
+		})
+	}
+	if uc.mfaEnabled {
+		checks = append(checks, entity.SecurityCheck{
+			ID:             "mfa",
+			Passed:         facts.MFAEnabled,
+			Weight:         20,
+			Recommendation: "Turn on two-factor authentication, a stolen password alone is then not enough to sign in.",
+		})
+	}
+
+	var score entity.SecurityScore
+	total, passed := 0, 0
+	for _, check := range checks {
+		total += check.Weight
+		if check.Passed {
+			passed += check.Weight
+			check.Recommendation = ""
+		}
+		score.Checks = append(score.Checks, check)
+	}
+	score.Score = passed * 100 / total
+	return score, nil
+}
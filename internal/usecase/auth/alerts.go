@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"main/domain/entity"
+	"maps"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// AlertPublisher delivers security alerts to the alert sink, implemented by alert.Publisher.
+type AlertPublisher interface {
+	// Publish queues the alert without waiting for the sink.
+	Publish(alert entity.SecurityAlert)
+}
+
+// AlertPolicy configures the detection of anomalous auth events. Detected events are recorded in the audit log
+// and published to the alert sink, a nil Publisher only records them.
+type AlertPolicy struct {
+	Publisher AlertPublisher
+	// ReuseGrace is how long a rotated refresh token may still be presented without an alert, so that a client
+	// retrying a refresh whose answer it lost is not reported
+	ReuseGrace time.Duration
+	// LockoutsAfter raises an alert once a user was locked out of the second factor that many times in the last
+	// LockoutWindow, zero disables it
+	LockoutsAfter int
+	LockoutWindow time.Duration
+	Lockouts      FailureCounter
+	// TravelWindow raises an alert for a login from another country than the last session of the user when the
+	// session was started or rotated less than that ago, zero disables it. It requires a GeoIP database.
+	TravelWindow time.Duration
+}
+
+// raiseAlert records the alert in the audit log and publishes it. The client IP follows the privacy mode.
+func (uc *AuthUsecase) raiseAlert(ctx context.Context, alert entity.SecurityAlert, ip string) {
+	alert.ID = uuid.New()
+	alert.CreatedAt = time.Now().UTC()
+	if addr, err := netip.ParseAddr(ip); err == nil {
+		alert.IP = uc.fingerprinter.Fingerprint(addr, "").IP
+	}
+
+	uc.Metrics.SecurityAlerts.WithLabelValues(string(alert.Type)).Inc()
+	uc.logger.Warn("Security alert", "alert_id", alert.ID, "type", alert.Type, "user_id", alert.UserID,
+		"details", alert.Details)
+	details := map[string]string{"alert_id": alert.ID.String(), "type": string(alert.Type)}
+	maps.Copy(details, alert.Details)
+	uc.audit.Record(ctx, entity.AuditEvent{
+		Action:  entity.AuditSecurityAlert,
+		ActorID: &alert.UserID,
+		Details: details,
+	})
+	if uc.alerts.Publisher != nil {
+		uc.alerts.Publisher.Publish(alert)
+	}
+}
+
+// checkRefreshReuse is called for refresh tokens that match no session. A token a session was rotated away from
+// was either refreshed twice by its client or copied, so once the grace period is over the alert is raised.
+// The caller answers like for any unknown token.
+func (uc *AuthUsecase) checkRefreshReuse(ctx context.Context, token uuid.UUID, in entity.RefreshInput) {
+	session, err := uc.sessions.GetSessionByPreviousRefreshToken(ctx, token)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return
+	}
+	if err != nil {
+		uc.logger.Error("Failed to look up rotated refresh token", "error", err)
+		return
+	}
+	// CreatedAt is the time of the last rotation
+	rotated := time.Since(session.CreatedAt)
+	if rotated < uc.alerts.ReuseGrace {
+		return
+	}
+	uc.raiseAlert(ctx, entity.SecurityAlert{
+		Type:      entity.AlertRefreshTokenReuse,
+		Severity:  entity.AlertSeverityHigh,
+		UserID:    session.UserID,
+		SessionID: &session.ID,
+		Details: map[string]string{
+			"client_type":   string(session.ClientType),
+			"rotated_since": rotated.Round(time.Second).String(),
+		},
+	}, in.IP)
+}
+
+// countLockout counts a second factor challenge of the user that was ended by too many wrong codes and raises
+// the alert when the user reaches LockoutsAfter lockouts. The alert is raised once per window.
+func (uc *AuthUsecase) countLockout(ctx context.Context, userID uuid.UUID, ip string) {
+	if uc.alerts.LockoutsAfter <= 0 || uc.alerts.Lockouts == nil {
+		return
+	}
+	lockouts, err := uc.alerts.Lockouts.Incr(ctx, "alert:lockout:"+userID.String(), uc.alerts.LockoutWindow)
+	if err != nil {
+		uc.logger.Error("Failed to count lockout", "user_id", userID, "error", err)
+		return
+	}
+	if lockouts != int64(uc.alerts.LockoutsAfter) {
+		return
+	}
+	uc.raiseAlert(ctx, entity.SecurityAlert{
+		Type:     entity.AlertRepeatedLockouts,
+		Severity: entity.AlertSeverityMedium,
+		UserID:   userID,
+		Details: map[string]string{
+			"lockouts": strconv.FormatInt(lockouts, 10),
+			"window":   uc.alerts.LockoutWindow.String(),
+		},
+	}, ip)
+}
+
+// checkTravel raises the alert when the login comes from another country than the last session of the user and
+// that session was started or rotated less than TravelWindow ago. Without a coordinate database the distance is unknown,
+// a change of country in a short time is taken as impossible travel.
+func (uc *AuthUsecase) checkTravel(ctx context.Context, userID uuid.UUID, in entity.LoginInput, location entity.GeoLocation) {
+	if uc.alerts.TravelWindow <= 0 || location.Country == "" {
+		return
+	}
+	last, err := uc.sessions.SessionOrigins(ctx, userID, 1)
+	if err != nil {
+		uc.logger.Error("Failed to read session history for travel check", "user_id", userID, "error", err)
+		return
+	}
+	if len(last) == 0 || last[0].Country == "" || last[0].Country == location.Country {
+		return
+	}
+	elapsed := time.Since(last[0].CreatedAt)
+	if elapsed >= uc.alerts.TravelWindow {
+		return
+	}
+	uc.raiseAlert(ctx, entity.SecurityAlert{
+		Type:     entity.AlertImpossibleTravel,
+		Severity: entity.AlertSeverityMedium,
+		UserID:   userID,
+		Details: map[string]string{
+			"previous_country": last[0].Country,
+			"country":          location.Country,
+			"elapsed":          elapsed.Round(time.Second).String(),
+		},
+	}, in.IP)
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"main/domain/entity"
+	"main/pkg/customerrors"
+)
+
+// AttestationLevel is how strictly the app attestation of a client type is checked.
+type AttestationLevel string
+
+const (
+	// AttestationOff ignores attestations
+	AttestationOff AttestationLevel = "off"
+	// AttestationObserve verifies attestations, a missing or invalid one raises the risk of a login and is logged at refresh
+	AttestationObserve AttestationLevel = "observe"
+	// AttestationRequire refuses logins and refreshes without a valid attestation
+	AttestationRequire AttestationLevel = "require"
+)
+
+// Valid reports whether the level is one of the known ones, empty is off.
+func (l AttestationLevel) Valid() bool {
+	switch l {
+	case "", AttestationOff, AttestationObserve, AttestationRequire:
+		return true
+	}
+	return false
+}
+
+// AttestationVerifier verifies app attestations, implemented by attestation.Verifier.
+type AttestationVerifier interface {
+	// Verify checks the attestation, key is the App Attest key bound to the session and nil at login.
+	// It returns the App Attest key to bind to the session, nil for platforms without one.
+	Verify(a entity.Attestation, key *entity.AttestedKey) (*entity.AttestedKey, error)
+	// NewChallenge returns a challenge for the app to request an attestation for.
+	NewChallenge() (entity.AttestationChallenge, error)
+}
+
+// attestationCheck is the result of checking the attestation of a login or refresh.
+type attestationCheck struct {
+	// checked is set when the client type is checked at all, required when it must present a valid attestation
+	checked  bool
+	required bool
+	verified bool
+	// key is the App Attest key of the session after the check
+	key *entity.AttestedKey
+}
+
+// unattested reports whether the client type is checked and did not present a valid attestation.
+func (c attestationCheck) unattested() bool {
+	return c.checked && !c.verified
+}
+
+// AttestationChallenge returns a challenge for a mobile app, customerrors.ErrAttestationDisabled without a verifier.
+func (uc *AuthUsecase) AttestationChallenge(ctx context.Context) (entity.AttestationChallenge, error) {
+	if uc.attestation == nil {
+		return entity.AttestationChallenge{}, customerrors.ErrAttestationDisabled
+	}
+	return uc.attestation.NewChallenge()
+}
+
+// checkAttestation verifies the attestation presented by a client of the client type according to its session
+// policy. key is the App Attest key already bound to the session. With AttestationRequire a missing or invalid
+// attestation fails with customerrors.ErrAttestationFailed, with AttestationObserve it is only reported.
+func (uc *AuthUsecase) checkAttestation(ct entity.ClientType, a entity.Attestation, key *entity.AttestedKey) (attestationCheck, error) {
+	level := uc.sessionPolicies.For(ct).Attestation
+	if uc.attestation == nil || level == "" || level == AttestationOff {
+		return attestationCheck{key: key}, nil
+	}
+	check := attestationCheck{checked: true, required: level == AttestationRequire, key: key}
+
+	var err error
+	outcome := "verified"
+	if a.Token == "" {
+		outcome, err = "missing", fmt.Errorf("%w: no attestation", customerrors.ErrAttestationFailed)
+	} else if verified, verr := uc.attestation.Verify(a, key); verr != nil {
+		outcome, err = "invalid", fmt.Errorf("%w: %v", customerrors.ErrAttestationFailed, verr)
+	} else {
+		check.verified = true
+		if verified != nil {
+			check.key = verified
+		}
+	}
+	uc.Metrics.AppAttestations.WithLabelValues(string(ct), outcome).Inc()
+	if err == nil {
+		return check, nil
+	}
+	if level == AttestationRequire {
+		return check, err
+	}
+	uc.logger.Warn("App attestation failed", "client_type", ct, "platform", a.Platform, "error", err)
+	return check, nil
+}
+
+// provenClientType returns the client type the session is tagged with. The policies of native clients may keep
+// sessions longer than those of browsers, so where the attestation of a native client type is required, the
+// claimed type is only kept when the client proved it: with a verified app attestation or an mTLS client
+// certificate. Other logins of such a type get the web policy. Without required attestation the claimed type
+// is kept, the client cannot prove it.
+func provenClientType(ct entity.ClientType, in entity.LoginInput, attested attestationCheck) entity.ClientType {
+	if !ct.Native() || !attested.required || attested.verified || in.CertThumbprint != "" {
+		return ct
+	}
+	return entity.ClientTypeWeb
+}
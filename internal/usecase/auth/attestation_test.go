@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"testing"
+
+	"main/domain/entity"
+)
+
+func TestProvenClientType(t *testing.T) {
+	tests := []struct {
+		name     string
+		ct       entity.ClientType
+		cert     string
+		attested attestationCheck
+		want     entity.ClientType
+	}{
+		{name: "web is kept", ct: entity.ClientTypeWeb, attested: attestationCheck{checked: true, required: true}, want: entity.ClientTypeWeb},
+		{name: "attestation off", ct: entity.ClientTypeMobile, want: entity.ClientTypeMobile},
+		{name: "attestation logged only", ct: entity.ClientTypeMobile, attested: attestationCheck{checked: true}, want: entity.ClientTypeMobile},
+		{name: "required and verified", ct: entity.ClientTypeMobile, attested: attestationCheck{checked: true, required: true, verified: true}, want: entity.ClientTypeMobile},
+		{name: "required with client certificate", ct: entity.ClientTypeCLI, cert: "thumbprint", attested: attestationCheck{checked: true, required: true}, want: entity.ClientTypeCLI},
+		{name: "required and unproven", ct: entity.ClientTypeCLI, attested: attestationCheck{checked: true, required: true}, want: entity.ClientTypeWeb},
+		{name: "service required and unproven", ct: entity.ClientTypeService, attested: attestationCheck{checked: true, required: true}, want: entity.ClientTypeWeb},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := entity.LoginInput{CertThumbprint: tt.cert}
+			if got := provenClientType(tt.ct, in, tt.attested); got != tt.want {
+				t.Errorf("provenClientType() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
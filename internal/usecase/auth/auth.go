@@ -2,26 +2,64 @@ package auth
 
 import (
 	"context"
+	"crypto/subtle"
 	"errors"
+	"fmt"
+	"log/slog"
 	metrics "main/internal/metrics"
+	"main/pkg/customerrors"
+	"main/pkg/emailnorm"
+	"main/pkg/fingerprint"
+	"main/pkg/idgen"
+	"main/pkg/locale"
+	"main/pkg/passhash"
+	"main/pkg/utils"
+	ctxUtil "main/pkg/utils/context"
 	"net/netip"
+	"strconv"
 	"time"
 	"unicode"
 
 	"main/domain/entity"
 
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
 // AuthRepo defines the interface for authentication-related database operations.
 type AuthRepo interface {
 	// CreateUser creates a new user in the database with the provided details and returns the user ID.
-	CreateUser(ctx context.Context, userID uuid.UUID, email, username, passwordHash string) (uuid.UUID, error)
+	CreateUser(ctx context.Context, user entity.NewUser) (uuid.UUID, error)
 
-	// GetUserByLogin retrieves the user ID and password hash based on the provided login (username or email).
-	GetUserByLogin(ctx context.Context, login string) (userID uuid.UUID, passwordHash string, err error)
+	// CreateInvitedUser consumes one use of the invitation with the given code hash and creates the user atomically.
+	CreateInvitedUser(ctx context.Context, user entity.NewUser, codeHash []byte) (uuid.UUID, error)
 
+	// GetUserByLogin retrieves the user based on the provided login (username or canonical email).
+	GetUserByLogin(ctx context.Context, login, canonicalEmail string) (entity.User, error)
+
+	// LoginTaken reports whether the username or the canonical email is used by an account.
+	LoginTaken(ctx context.Context, username, canonicalEmail string) (bool, error)
+
+	// GetUserByID retrieves the user by ID.
+	GetUserByID(ctx context.Context, userID uuid.UUID) (entity.User, error)
+
+	// StoreReceipt saves the issuance receipt of a login or refresh.
+	StoreReceipt(ctx context.Context, receipt entity.IssuanceReceipt) error
+
+	// StoreLoginEvent adds a login attempt to the login history of the user.
+	StoreLoginEvent(ctx context.Context, event entity.LoginEvent) error
+
+	// LoginHistory returns a page of the login attempts of the user, newest first.
+	LoginHistory(ctx context.Context, userID uuid.UUID, limit, offset int) (entity.LoginEventPage, error)
+
+	// UpdatePasswordHash replaces the password hash if it still equals oldHash, without touching sessions.
+	UpdatePasswordHash(ctx context.Context, userID uuid.UUID, oldHash, newHash string) error
+}
+
+// SessionRepository stores the sessions of users, implemented by the Postgres auth repository and by the
+// Redis session store.
+type SessionRepository interface {
 	// StoreSession saves the session associated with a user in the database, allowing for session management and token revocation.
 	StoreSession(ctx context.Context, userID uuid.UUID, session entity.Session) error
 
@@ -29,162 +67,778 @@ type AuthRepo interface {
 	DeleteSession(ctx context.Context, userID uuid.UUID, sessionID uuid.UUID) error
 
 	// DeleteAllSessions removes all sessions associated with a user, effectively logging them out from !ALL! devices.
-	DeleteAllSessions(ctx context.Context, userID uuid.UUID) error
+	// It increments the token version of the user, which revokes its access tokens.
+	DeleteAllSessions(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, error)
+
+	// ListSessionIDs returns the IDs of all sessions of a user.
+	ListSessionIDs(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, error)
+
+	// ListActiveSessions returns the unexpired sessions of the user, the last used first.
+	ListActiveSessions(ctx context.Context, userID uuid.UUID) ([]entity.ActiveSession, error)
+
+	// ListSessions returns a page of the sessions of the user matching the filter, newest first by its sort.
+	ListSessions(ctx context.Context, filter entity.SessionFilter) (entity.SessionPage, error)
+
+	// RenameSession sets the device name of an unexpired session of the user, pgx.ErrNoRows if there is none.
+	RenameSession(ctx context.Context, userID, sessionID uuid.UUID, name string) error
+
+	// SetSessionBlocked blocks or unblocks an unexpired session of the user, pgx.ErrNoRows if there is none.
+	SetSessionBlocked(ctx context.Context, userID, sessionID uuid.UUID, blocked bool) error
 
-	// UserIsBlocked checks if the user is blocked and returns true if the user is not blocked, false otherwise.
-	UserIsBlocked(userID uuid.UUID) (bool, error)
+	// GetSession returns the session of the user with the ID, pgx.ErrNoRows if there is none.
+	GetSession(ctx context.Context, userID, sessionID uuid.UUID) (entity.Session, error)
 
 	// GetSessionByRefreshToken retrieves the session information based on the provided refresh token.
 	GetSessionByRefreshToken(ctx context.Context, refreshToken uuid.UUID) (entity.Session, error)
 
+	// GetSessionByCanaryToken returns the session of a decoy refresh token, pgx.ErrNoRows if it is none.
+	GetSessionByCanaryToken(ctx context.Context, token uuid.UUID) (entity.Session, error)
+
+	// GetSessionByPreviousRefreshToken returns the session whose refresh token was rotated away from the token,
+	// pgx.ErrNoRows if there is none.
+	GetSessionByPreviousRefreshToken(ctx context.Context, token uuid.UUID) (entity.Session, error)
+
+	// SessionOrigins returns where and when the last limit sessions of the user were started, newest first.
+	SessionOrigins(ctx context.Context, userID uuid.UUID, limit int) ([]entity.SessionOrigin, error)
+
 	// RefreshSession updates the session information in the database, allowing for token renewal and session extension.
 	RefreshSession(ctx context.Context, session entity.Session) error
+
+	// RotateSession updates the session like RefreshSession and moves it from previousID to session.ID.
+	RotateSession(ctx context.Context, previousID uuid.UUID, session entity.Session) error
 }
 
 // JWTManager defines the interface for JWT token management.
 type JWTManager interface {
-	NewAccessToken(userID uuid.UUID) (string, error)
-	VerifyAccessToken(token string) (userID uuid.UUID, err error)
+	NewAccessToken(claims entity.AccessTokenClaims) (string, error)
+	VerifyAccessToken(token string) (entity.AccessTokenClaims, error)
+	ExpiresAt(token string) (time.Time, error)
+	Audience(token string) ([]string, error)
+}
+
+// ProofVerifier verifies DPoP proofs (RFC 9449) and returns the thumbprint of the signing key.
+type ProofVerifier interface {
+	Verify(ctx context.Context, proof, method, uri, accessToken string) (jkt string, err error)
+}
+
+// Fingerprinter derives what is stored about the client of a session, hashing it in privacy mode.
+type Fingerprinter interface {
+	Fingerprint(ip netip.Addr, userAgent string) entity.ClientFingerprint
+}
+
+// PasswordHasher hashes passwords with the current algorithm and parameters.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+
+	// NeedsRehash reports whether the hash was created with another algorithm or other parameters.
+	NeedsRehash(hash string) bool
+}
+
+// EmailVerifier issues email verification links.
+type EmailVerifier interface {
+	SendVerification(ctx context.Context, userID uuid.UUID, email string) error
+
+	// NotifyRegistrationAttempt tells the owner of the email that someone tried to register with it.
+	NotifyRegistrationAttempt(ctx context.Context, email string) error
+}
+
+// HandlePolicy rejects reserved and banned usernames and email addresses, implemented by handles.HandleUsecase.
+type HandlePolicy interface {
+	// Check returns customerrors.ErrHandleUnavailable if the username or the email cannot be used, empty values are skipped.
+	Check(ctx context.Context, username, email string) error
+}
+
+// Auditor records security relevant actions in the audit log, implemented by audit.AuditLogger.
+type Auditor interface {
+	// Record appends the event to the log, failures are logged and not returned.
+	Record(ctx context.Context, event entity.AuditEvent)
 }
 
 type AuthUsecase struct {
-	authRepo   AuthRepo
-	JWTManager JWTManager
-	Metrics    *metrics.Metrics
+	authRepo      AuthRepo
+	sessions      SessionRepository
+	JWTManager    JWTManager
+	Metrics       *metrics.Metrics
+	logger        *slog.Logger
+	emailVerifier EmailVerifier
+	// requireVerifiedEmail blocks login until the user has confirmed their email
+	requireVerifiedEmail bool
+	sessionPolicies      SessionPolicies
+	proofVerifier        ProofVerifier
+	fingerprinter        Fingerprinter
+	passwordHasher       PasswordHasher
+	breachCheck          BreachCheck
+	registrationPolicy   RegistrationPolicy
+	termsPolicy          TermsPolicy
+	emails               emailnorm.Normalizer
+	// receiptSigner signs issuance receipts, nil disables them
+	receiptSigner ReceiptSigner
+	// userIDs generates the IDs of registered users
+	userIDs idgen.Generator
+	// tokenVersions checks and issues the token version claim of access tokens
+	tokenVersions *TokenVersions
+	// mfa starts the second factor of password logins, nil disables two-factor authentication
+	mfa SecondFactor
+	// canary issues decoy refresh tokens and responds to their use
+	canary RefreshCanary
+	// enumeration hides whether accounts exist from registration and login
+	enumeration *EnumerationProtection
+	// risk compares logins with the previous sessions of the user
+	risk RiskPolicy
+	// handles rejects reserved and banned usernames and emails at registration
+	handles HandlePolicy
+	// audit records registrations, logins, logouts and refreshes in the audit log
+	audit Auditor
+	// attestation verifies the app attestation of mobile apps, nil disables it
+	attestation AttestationVerifier
+	// captcha requires CAPTCHAs at registration and after failed logins
+	captcha CaptchaPolicy
+	// geo locates logins and blocks the ones from blocked countries
+	geo GeoPolicy
+	// roles lists the roles of users, sessions are rotated when they change. nil disables the rotation.
+	roles RoleLister
+	// denylist rejects the access tokens of sessions revoked in an incident
+	denylist *SessionDenylist
+	// alerts detects anomalous auth events and publishes them to the alert sink
+	alerts AlertPolicy
+	// elevation shortens the tokens of admins and elevates sessions after a step-up authentication
+	elevation ElevationPolicy
+	// features rolls DPoP-bound sessions and the password hashing algorithm out to a part of the users
+	features FeatureRollout
 }
 
-func NewAuthUsecase(authRepo AuthRepo, JWTManager JWTManager, metrics *metrics.Metrics) *AuthUsecase {
+func NewAuthUsecase(
+	authRepo AuthRepo,
+	sessions SessionRepository,
+	JWTManager JWTManager,
+	metrics *metrics.Metrics,
+	logger *slog.Logger,
+	emailVerifier EmailVerifier,
+	requireVerifiedEmail bool,
+	sessionPolicies SessionPolicies,
+	proofVerifier ProofVerifier,
+	fingerprinter Fingerprinter,
+	passwordHasher PasswordHasher,
+	breachCheck BreachCheck,
+	registrationPolicy RegistrationPolicy,
+	termsPolicy TermsPolicy,
+	emails emailnorm.Normalizer,
+	receiptSigner ReceiptSigner,
+	userIDs idgen.Generator,
+	tokenVersions *TokenVersions,
+	mfa SecondFactor,
+	canary RefreshCanary,
+	enumeration *EnumerationProtection,
+	risk RiskPolicy,
+	handles HandlePolicy,
+	audit Auditor,
+	attestation AttestationVerifier,
+	captcha CaptchaPolicy,
+	geo GeoPolicy,
+	roles RoleLister,
+	denylist *SessionDenylist,
+	alerts AlertPolicy,
+	elevation ElevationPolicy,
+	features FeatureRollout) *AuthUsecase {
 	return &AuthUsecase{
-		authRepo:   authRepo,
-		JWTManager: JWTManager,
-		Metrics:    metrics,
+		authRepo:             authRepo,
+		sessions:             sessions,
+		JWTManager:           JWTManager,
+		Metrics:              metrics,
+		logger:               logger,
+		emailVerifier:        emailVerifier,
+		requireVerifiedEmail: requireVerifiedEmail,
+		sessionPolicies:      sessionPolicies,
+		proofVerifier:        proofVerifier,
+		fingerprinter:        fingerprinter,
+		passwordHasher:       passwordHasher,
+		breachCheck:          breachCheck,
+		registrationPolicy:   registrationPolicy,
+		termsPolicy:          termsPolicy,
+		emails:               emails,
+		receiptSigner:        receiptSigner,
+		userIDs:              userIDs,
+		tokenVersions:        tokenVersions,
+		mfa:                  mfa,
+		canary:               canary,
+		enumeration:          enumeration,
+		risk:                 risk,
+		handles:              handles,
+		audit:                audit,
+		attestation:          attestation,
+		captcha:              captcha,
+		geo:                  geo,
+		roles:                roles,
+		denylist:             denylist,
+		alerts:               alerts,
+		elevation:            elevation,
+		features:             features,
 	}
 }
 
 // RefreshSessionToken validates the provided refresh token and returns the associated user ID if the token is valid.
-func (uc *AuthUsecase) RefreshSessionToken(ctx context.Context, refreshToken string) (string, string, error) {
+// A session bound to a client certificate can only be refreshed by a client presenting the same certificate,
+// a session bound to a DPoP key only with a proof signed by the same key.
+// A refresh from another IP address is handled by the IP change policy of the client type, which can end the session.
+// Refresh tokens sent in the body (in.Native) are only accepted for sessions of native clients.
+// When the roles of the user changed since the login or the last rotation, the session is rotated to a new ID
+// and refresh token, see rotateSession.
+func (uc *AuthUsecase) RefreshSessionToken(ctx context.Context, in entity.RefreshInput) (entity.IssuedTokens, error) {
+	refreshToken, certThumbprint, dpopThumbprint := in.RefreshToken, in.CertThumbprint, in.DPoPThumbprint
 	sid, err := uuid.Parse(refreshToken)
 	if err != nil {
-		return "", "", errors.New("invalid session ID")
+		return entity.IssuedTokens{}, errors.New("invalid session ID")
 	}
 
-	session, err := uc.authRepo.GetSessionByRefreshToken(ctx, sid)
+	session, err := uc.sessions.GetSessionByRefreshToken(ctx, sid)
+	if errors.Is(err, pgx.ErrNoRows) {
+		uc.checkCanary(ctx, sid, in)
+		uc.checkRefreshReuse(ctx, sid, in)
+	}
 	if err != nil {
-		return "", "", err
+		return entity.IssuedTokens{}, err
 	}
 	uid := session.UserID
 
-	if session.ExpiresAt.Before(session.CreatedAt) {
-		uc.authRepo.DeleteSession(ctx, uid, session.ID)
-		return "", "", errors.New("session has expired")
+	// the refresh token of a browser only lives in an HttpOnly cookie, one in a body was taken out of it
+	if in.Native && !session.ClientType.Native() {
+		return entity.IssuedTokens{}, customerrors.ErrRefreshCookieRequired
+	}
+	if session.CertThumbprint != "" && subtle.ConstantTimeCompare([]byte(session.CertThumbprint), []byte(certThumbprint)) != 1 {
+		return entity.IssuedTokens{}, customerrors.ErrCertificateMismatch
 	}
+	if session.DPoPThumbprint != "" && subtle.ConstantTimeCompare([]byte(session.DPoPThumbprint), []byte(dpopThumbprint)) != 1 {
+		return entity.IssuedTokens{}, customerrors.ErrProofKeyMismatch
+	}
+	attested, err := uc.checkAttestation(session.ClientType, in.Attestation, session.AttestedKey)
+	if err != nil {
+		return entity.IssuedTokens{}, err
+	}
+	session.AttestedKey = attested.key
 
-	session.ExpiresAt = time.Now().Add(15 * 24 * time.Hour)
-	session.CreatedAt = time.Now()
-	session.RefreshToken, err = uuid.NewUUID()
+	policy := uc.sessionPolicies.For(session.ClientType)
+	// the lifetime is checked as well, the session may have been started under a longer one
+	if session.ExpiresAt.Before(time.Now()) || policy.outlived(session, time.Now()) {
+		uc.sessions.DeleteSession(ctx, uid, session.ID)
+		return entity.IssuedTokens{}, errors.New("session has expired")
+	}
+	if session.IsBlocked {
+		return entity.IssuedTokens{}, customerrors.ErrSessionBlocked
+	}
+	if err := uc.checkTokenState(ctx, session); err != nil {
+		return entity.IssuedTokens{}, err
+	}
+
+	if err := uc.applySessionBinding(ctx, session, policy.Binding, in); err != nil {
+		return entity.IssuedTokens{}, err
+	}
+	if err := uc.applyIPChangePolicy(ctx, &session, policy.IPChange, in); err != nil {
+		return entity.IssuedTokens{}, err
+	}
+	session.ExpiresAt = policy.expiry(session.StartedAt, time.Now())
+	// long-lived clients may keep their refresh token for a while instead of rotating on every call
+	if time.Since(session.CreatedAt) >= policy.RotationInterval {
+		session.CreatedAt = time.Now()
+		session.RefreshToken, err = uuid.NewUUID()
+		if err != nil {
+			return entity.IssuedTokens{}, err
+		}
+	}
+
+	privileges, roles, err := uc.privileges(ctx, uid)
 	if err != nil {
-		return "", "", err
+		return entity.IssuedTokens{}, err
+	}
+	// sessions started before privileges were stored adopt the current ones
+	if session.Privileges != "" && session.Privileges != privileges {
+		session.Privileges = privileges
+		err = uc.rotateSession(ctx, &session, RotationRolesChanged)
+	} else {
+		session.Privileges = privileges
+		err = uc.sessions.RefreshSession(ctx, session)
+	}
+	if err != nil {
+		return entity.IssuedTokens{}, err
 	}
 
-	err = uc.authRepo.RefreshSession(ctx, session)
+	tokens, err := uc.sessionTokens(ctx, session, roles)
 	if err != nil {
-		return "", "", err
+		return entity.IssuedTokens{}, err
 	}
+	uc.audit.Record(ctx, entity.AuditEvent{
+		Action:  entity.AuditRefresh,
+		ActorID: &uid,
+		Details: map[string]string{"session_id": session.ID.String()},
+	})
+	return tokens, nil
+}
 
-	newAccessToken, err := uc.JWTManager.NewAccessToken(uid)
+// sessionTokens issues a new access token of the session and returns it with the refresh token of the session.
+// roles are the roles of the user, admins get short-lived access tokens.
+func (uc *AuthUsecase) sessionTokens(ctx context.Context, session entity.Session, roles []string) (entity.IssuedTokens, error) {
+	tokenState, err := uc.tokenVersions.Current(ctx, session.UserID)
+	if err != nil {
+		return entity.IssuedTokens{}, err
+	}
+	claims := entity.AccessTokenClaims{
+		UserID:            session.UserID,
+		SessionID:         session.ID,
+		ClientType:        session.ClientType,
+		CertThumbprint:    session.CertThumbprint,
+		DPoPThumbprint:    session.DPoPThumbprint,
+		Issuer:            tenantIssuer(ctx),
+		TokenVersion:      tokenState.Version,
+		PasswordTimestamp: tokenState.PasswordTimestamp,
+		TTL:               uc.elevation.tokenTTL(roles),
+	}
+	if session.ElevatedUntil.After(time.Now()) {
+		claims.ElevatedUntil = session.ElevatedUntil
+	}
+	accessToken, err := uc.JWTManager.NewAccessToken(claims)
 	if err != nil {
-		return "", "", err
+		return entity.IssuedTokens{}, err
 	}
 
-	return newAccessToken, session.RefreshToken.String(), nil
+	tokens := entity.IssuedTokens{
+		UserID:       session.UserID,
+		SessionID:    session.ID,
+		AccessToken:  accessToken,
+		RefreshToken: session.RefreshToken.String(),
+		DPoPBound:    session.DPoPThumbprint != "",
+		ClientType:   session.ClientType,
+	}
+	if err := uc.issueReceipt(ctx, &tokens); err != nil {
+		return entity.IssuedTokens{}, err
+	}
+	return tokens, nil
 }
 
 // RegisterUser validates the input, hashes the password, and creates a new user in the database.
-// It returns the user ID as a string or an error if the registration fails.
-func (uc *AuthUsecase) RegisterUser(ctx context.Context, username, email, password string) (userID uuid.UUID, err error) {
+// The registration policy can close registration, restrict it to some email domains or block throwaway domains, reserved
+// and banned usernames and emails are rejected with customerrors.ErrHandleUnavailable. When registration
+// is invite-only, the invite code must belong to a usable invitation, one use of it is consumed.
+// The accepted terms documents are recorded, the terms policy can require the acceptance of the current ones.
+// It returns the user ID and warnings about the password (see BreachCheck) or an error if the registration fails.
+// With enumeration protection a taken email is not reported, see EnumerationProtection.
+// The CAPTCHA policy can require a solved CAPTCHA, checked last so that invalid input does not use up the token.
+func (uc *AuthUsecase) RegisterUser(ctx context.Context, in entity.RegisterInput) (userID uuid.UUID, warnings []string, err error) {
+	username, email, password := in.Username, uc.emails.Normalize(in.Email), in.Password
 
 	if !validateUsername(username) {
-		return uuid.Nil, errors.New("username must be between 3 and 30 characters")
+		return uuid.Nil, nil, errors.New("username must be between 3 and 30 characters")
 	}
 
 	if !validateEmail(email) {
-		return uuid.Nil, errors.New("invalid email format")
+		return uuid.Nil, nil, errors.New("invalid email format")
+	}
+	var phone string
+	if in.Phone != "" {
+		var ok bool
+		if phone, ok = utils.NormalizePhone(in.Phone); !ok {
+			return uuid.Nil, nil, customerrors.ErrInvalidPhone
+		}
+	}
+	if reason, err := uc.registrationPolicy.check(email, in.InviteCode); err != nil {
+		uc.Metrics.RegistrationRejections.WithLabelValues(reason).Inc()
+		return uuid.Nil, nil, err
+	}
+	if err := uc.handles.Check(ctx, username, email); err != nil {
+		if errors.Is(err, customerrors.ErrHandleUnavailable) {
+			uc.Metrics.RegistrationRejections.WithLabelValues("handle_unavailable").Inc()
+		}
+		return uuid.Nil, nil, err
+	}
+	if err := uc.termsPolicy.checkRegistration(ctx, in.AcceptedTerms); err != nil {
+		if errors.Is(err, customerrors.ErrTermsNotAccepted) {
+			uc.Metrics.RegistrationRejections.WithLabelValues("terms_not_accepted").Inc()
+		}
+		return uuid.Nil, nil, err
 	}
 	if err := validatePassword(password); err != nil {
-		return uuid.Nil, err
+		return uuid.Nil, nil, err
+	}
+	warnings, err = uc.breachCheck.check(ctx, uc.logger, password)
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+	if err := uc.checkRegistrationCaptcha(ctx, in.CaptchaToken, in.IP); err != nil {
+		if errors.Is(err, customerrors.ErrCaptchaRequired) {
+			uc.Metrics.RegistrationRejections.WithLabelValues("captcha_required").Inc()
+		}
+		return uuid.Nil, nil, err
 	}
 
-	passwordHash, err := hashPassword(password)
+	userID, err = uc.userIDs.NewID()
 	if err != nil {
-		return uuid.Nil, err
+		return uuid.Nil, nil, err
 	}
-	userID, err = uuid.NewUUID()
+	passwordHash, err := uc.features.hasher(ctx, userID, uc.passwordHasher).Hash(password)
 	if err != nil {
-		return uuid.Nil, err
+		return uuid.Nil, nil, err
+	}
+	user := entity.NewUser{
+		ID:             userID,
+		Email:          email,
+		CanonicalEmail: uc.emails.Canonical(email),
+		Username:       username,
+		PasswordHash:   passwordHash,
+		Phone:          phone,
 	}
 
-	return uc.authRepo.CreateUser(ctx, userID, email, username, passwordHash)
+	if uc.registrationPolicy.InviteOnly {
+		userID, err = uc.authRepo.CreateInvitedUser(ctx, user, utils.HashToken(in.InviteCode))
+	} else {
+		userID, err = uc.authRepo.CreateUser(ctx, user)
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		switch pgErr.ConstraintName {
+		case "idx_users_canonical_email":
+			if uc.enumeration.Enabled {
+				return uc.registrationAttempt(ctx, email, warnings)
+			}
+			return uuid.Nil, nil, customerrors.ErrEmailTaken
+		case "idx_users_phone":
+			return uuid.Nil, nil, customerrors.ErrPhoneTaken
+		case "users_username_live_key":
+			return uuid.Nil, nil, customerrors.ErrUsernameTaken
+		}
+	}
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
 
+	// the account exists at this point, a failed acceptance is asked for again at login
+	if uc.termsPolicy.Store != nil && len(in.AcceptedTerms) > 0 {
+		if err := uc.termsPolicy.Store.AcceptTerms(ctx, userID, in.AcceptedTerms); err != nil {
+			uc.logger.Error("Failed to record accepted terms", "user_id", userID, "error", err)
+		}
+	}
+	// a failed email must not fail the registration either, the user can request a new link
+	uc.enumeration.run(ctx, func(ctx context.Context) {
+		if err := uc.emailVerifier.SendVerification(ctx, userID, email); err != nil {
+			uc.logger.Error("Failed to send verification email", "user_id", userID, "error", err)
+		}
+	})
+	uc.audit.Record(ctx, entity.AuditEvent{Action: entity.AuditRegister, ActorID: &userID})
+
+	return userID, warnings, nil
+}
+
+// registrationAttempt answers a registration with a taken email like a successful one, with an ID that belongs
+// to no account, and tells the owner of the email about the attempt.
+func (uc *AuthUsecase) registrationAttempt(ctx context.Context, email string, warnings []string) (uuid.UUID, []string, error) {
+	uc.enumeration.run(ctx, func(ctx context.Context) {
+		if err := uc.emailVerifier.NotifyRegistrationAttempt(ctx, email); err != nil {
+			uc.logger.Error("Failed to notify about registration attempt", "error", err)
+		}
+	})
+	id, err := uc.userIDs.NewID()
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+	return id, warnings, nil
 }
 
 // LoginUser authenticates the user by verifying the provided credentials.
 // If successful, it generates an access token and a refresh token, stores the session in the database, and returns the access token.
-// If authentication fails, it returns an error.
-func (uc *AuthUsecase) LoginUser(ctx context.Context,
-	login,
-	password,
-	userAgent,
-	ip string) (uuid.UUID, string, string, error) {
+// The client type tags the session (web when empty or not proved, see provenClientType) and selects its session
+// policy. When the client authenticated with an mTLS certificate, the session and its tokens are bound to that
+// certificate (RFC 8705), when it sent a DPoP proof, they are bound to the proof key (RFC 9449).
+// After repeated failed logins from the IP the CAPTCHA policy requires a solved CAPTCHA before the password is checked.
+// Logins from a country blocked by the GeoIP policy get customerrors.ErrLoginLocationBlocked, also before the password
+// is checked. If authentication fails, it returns an error.
+func (uc *AuthUsecase) LoginUser(ctx context.Context, in entity.LoginInput) (entity.IssuedTokens, error) {
+	login, password := in.Login, in.Password
 
-	userID, passwordHash, err := uc.authRepo.GetUserByLogin(ctx, login)
+	ct, err := clientType(in)
 	if err != nil {
 		uc.Metrics.LoginAttempts.WithLabelValues("failure").Inc()
-		return uuid.Nil, "", "", err
+		return entity.IssuedTokens{}, err
 	}
-	if !verifyPassword(password, passwordHash) {
+	if _, err := deviceName(in.DeviceName); err != nil {
 		uc.Metrics.LoginAttempts.WithLabelValues("failure").Inc()
-		return uuid.Nil, "", "", errors.New("invalid credentials")
+		return entity.IssuedTokens{}, err
+	}
+	// checked before the password, a client without a valid attestation must not learn whether it is right
+	attested, err := uc.checkAttestation(ct, in.Attestation, nil)
+	if err != nil {
+		uc.Metrics.LoginAttempts.WithLabelValues("failure").Inc()
+		uc.audit.Record(ctx, entity.AuditEvent{
+			Action:  entity.AuditLoginFailed,
+			Details: map[string]string{"outcome": string(entity.LoginAttestationFailed), "client_type": string(ct)},
+		})
+		return entity.IssuedTokens{}, err
+	}
+	if err := uc.checkLoginCaptcha(ctx, in.CaptchaToken, in.IP); err != nil {
+		uc.Metrics.LoginAttempts.WithLabelValues("failure").Inc()
+		return entity.IssuedTokens{}, err
+	}
+	location := uc.locate(in.IP)
+	if err := uc.checkLocation(location); err != nil {
+		uc.Metrics.LoginAttempts.WithLabelValues("failure").Inc()
+		uc.audit.Record(ctx, entity.AuditEvent{
+			Action:  entity.AuditLoginFailed,
+			Details: map[string]string{"outcome": string(entity.LoginGeoBlocked), "country": location.Country},
+		})
+		return entity.IssuedTokens{}, err
 	}
 
-	accessToken, err := uc.JWTManager.NewAccessToken(userID)
+	user, err := uc.authRepo.GetUserByLogin(ctx, login, uc.emails.Canonical(login))
+	if errors.Is(err, pgx.ErrNoRows) {
+		uc.Metrics.LoginAttempts.WithLabelValues("failure").Inc()
+		uc.countLoginFailure(ctx, in.IP)
+		// unknown accounts cost a password check too and fail like a wrong password
+		uc.enumeration.burnPasswordCheck(password)
+		uc.audit.Record(ctx, entity.AuditEvent{
+			Action:  entity.AuditLoginFailed,
+			Details: map[string]string{"outcome": "unknown_account"},
+		})
+		if uc.enumeration.Enabled {
+			return entity.IssuedTokens{}, customerrors.ErrInvalidCredentials
+		}
+		return entity.IssuedTokens{}, customerrors.ErrUserNotFound
+	}
 	if err != nil {
 		uc.Metrics.LoginAttempts.WithLabelValues("failure").Inc()
-		return uuid.Nil, "", "", err
+		return entity.IssuedTokens{}, err
+	}
+	if user.PasswordHash == "" {
+		// a password invalidated by an administrator matches nothing, it must not answer faster either
+		uc.enumeration.burnPasswordCheck(password)
+	}
+	if !verifyPassword(password, user.PasswordHash) {
+		uc.Metrics.LoginAttempts.WithLabelValues("failure").Inc()
+		uc.countLoginFailure(ctx, in.IP)
+		uc.recordLogin(ctx, user.ID, in, entity.LoginInvalidPassword)
+		return entity.IssuedTokens{}, customerrors.ErrInvalidCredentials
+	}
+	if uc.requireVerifiedEmail && !user.EmailVerified {
+		uc.Metrics.LoginAttempts.WithLabelValues("failure").Inc()
+		uc.recordLogin(ctx, user.ID, in, entity.LoginEmailNotVerified)
+		return entity.IssuedTokens{}, customerrors.ErrEmailNotVerified
+	}
+	// hashes of other algorithms (imported users) or older parameters are replaced while the plaintext password is at hand
+	if hasher := uc.features.hasher(ctx, user.ID, uc.passwordHasher); hasher.NeedsRehash(user.PasswordHash) {
+		uc.rehashPassword(ctx, user, password, hasher)
+	}
+	risk := uc.assessRisk(ctx, user, in, attested, location)
+	stepUp := uc.risk.stepUp(risk)
+	// trusted devices of the user skip the second factor, unless the login is risky enough to step up
+	if uc.mfa != nil && (stepUp != RiskStepUpNone || !uc.mfa.Trusted(ctx, user.ID, in.TrustedDevice)) {
+		if user.IsBlocked {
+			uc.Metrics.LoginAttempts.WithLabelValues("failure").Inc()
+			uc.recordLogin(ctx, user.ID, in, entity.LoginBlocked)
+			return entity.IssuedTokens{}, customerrors.ErrUserBlocked
+		}
+		// the code is sent in the language of this login, it only becomes the one of the user with the session
+		if lang := locale.Negotiate(in.AcceptLanguage); lang != "" {
+			user.Locale = lang
+		}
+		if tz := locale.Timezone(in.Timezone); tz != "" {
+			user.Timezone = tz
+		}
+		var fallback entity.MFAMethod
+		if stepUp == RiskStepUpEmail {
+			fallback = entity.MFAEmail
+		}
+		challenge, err := uc.mfa.Challenge(ctx, user, fallback)
+		if err != nil {
+			uc.Metrics.LoginAttempts.WithLabelValues("failure").Inc()
+			return entity.IssuedTokens{}, err
+		}
+		if challenge != nil {
+			uc.Metrics.LoginAttempts.WithLabelValues("mfa_required").Inc()
+			uc.recordLogin(ctx, user.ID, in, entity.LoginMFARequired)
+			return entity.IssuedTokens{UserID: user.ID, MFA: challenge}, customerrors.ErrMFARequired
+		}
 	}
 
-	refreshToken, err := uuid.NewUUID()
+	tokens, err := uc.startSession(ctx, user, in, attested, risk, location)
 	if err != nil {
 		uc.Metrics.LoginAttempts.WithLabelValues("failure").Inc()
-		return uuid.Nil, "", "", err
+		return entity.IssuedTokens{}, err
 	}
+	uc.Metrics.LoginAttempts.WithLabelValues("success").Inc()
+	return tokens, nil
+}
 
-	netipAddr, err := netip.ParseAddr(ip)
+// CompleteMFALogin finishes a password login that returned customerrors.ErrMFARequired with the code
+// of its second factor and starts the session.
+func (uc *AuthUsecase) CompleteMFALogin(ctx context.Context, in entity.MFALoginInput) (entity.IssuedTokens, error) {
+	if uc.mfa == nil {
+		return entity.IssuedTokens{}, customerrors.ErrInvalidOTP
+	}
+	userID, err := uc.mfa.Verify(ctx, in.ChallengeID, in.Code)
+	if err != nil {
+		uc.Metrics.LoginAttempts.WithLabelValues("failure").Inc()
+		if errors.Is(err, customerrors.ErrTooManyAttempts) && userID != uuid.Nil {
+			uc.countLockout(ctx, userID, in.Client.IP)
+		}
+		return entity.IssuedTokens{}, err
+	}
+	user, err := uc.authRepo.GetUserByID(ctx, userID)
 	if err != nil {
 		uc.Metrics.LoginAttempts.WithLabelValues("failure").Inc()
-		return uuid.Nil, "", "", errors.New("invalid IP address")
+		return entity.IssuedTokens{}, err
+	}
+	tokens, err := uc.StartSession(ctx, user, in.Client)
+	if err != nil {
+		uc.Metrics.LoginAttempts.WithLabelValues("failure").Inc()
+		return entity.IssuedTokens{}, err
+	}
+	uc.Metrics.LoginAttempts.WithLabelValues("success").Inc()
+
+	if in.TrustDevice {
+		// the login succeeded anyway, without the cookie the next login asks for a code again
+		ip, _ := netip.ParseAddr(in.Client.IP)
+		fp := uc.fingerprinter.Fingerprint(ip, in.Client.UserAgent)
+		tokens.DeviceTrust, err = uc.mfa.TrustDevice(ctx, user.ID, fp.UserAgent, fp.IP)
+		if err != nil {
+			uc.logger.Error("Failed to trust device", "user_id", user.ID, "error", err)
+		}
+	}
+	return tokens, nil
+}
+
+// StartSession creates a session for a user who has already been authenticated and issues its tokens.
+// The client type, certificate and DPoP bindings are taken from the login input, its credentials are ignored.
+// Blocked users get customerrors.ErrUserBlocked. The terms documents accepted with the login are recorded,
+// users who still have to accept current ones get customerrors.ErrTermsNotAccepted when the terms policy requires it.
+// The risk assessment of the login is stored with the session, the attempt is added to the login history.
+// Client types requiring app attestation get customerrors.ErrAttestationFailed without a valid one, logins from
+// a country blocked by the GeoIP policy customerrors.ErrLoginLocationBlocked.
+func (uc *AuthUsecase) StartSession(ctx context.Context, user entity.User, in entity.LoginInput) (entity.IssuedTokens, error) {
+	ct, err := clientType(in)
+	if err != nil {
+		return entity.IssuedTokens{}, err
+	}
+	attested, err := uc.checkAttestation(ct, in.Attestation, nil)
+	if err != nil {
+		uc.recordLogin(ctx, user.ID, in, entity.LoginAttestationFailed)
+		return entity.IssuedTokens{}, err
+	}
+	location := uc.locate(in.IP)
+	if err := uc.checkLocation(location); err != nil {
+		uc.recordLogin(ctx, user.ID, in, entity.LoginGeoBlocked)
+		return entity.IssuedTokens{}, err
+	}
+	risk := uc.assessRisk(ctx, user, in, attested, location)
+	return uc.startSession(ctx, user, in, attested, risk, location)
+}
+
+// startSession is StartSession with the attestation and the risk assessment of the login already done.
+func (uc *AuthUsecase) startSession(ctx context.Context, user entity.User, in entity.LoginInput, attested attestationCheck,
+	risk entity.RiskAssessment, location entity.GeoLocation) (entity.IssuedTokens, error) {
+	if user.IsBlocked {
+		uc.recordLogin(ctx, user.ID, in, entity.LoginBlocked)
+		return entity.IssuedTokens{}, customerrors.ErrUserBlocked
+	}
+	if err := uc.termsPolicy.checkLogin(ctx, user.ID, in.AcceptedTerms); err != nil {
+		if errors.Is(err, customerrors.ErrTermsNotAccepted) {
+			uc.recordLogin(ctx, user.ID, in, entity.LoginTermsNotAccepted)
+		}
+		return entity.IssuedTokens{}, err
+	}
+	ct, err := clientType(in)
+	if err != nil {
+		return entity.IssuedTokens{}, err
+	}
+	ct = provenClientType(ct, in, attested)
+	name, err := deviceName(in.DeviceName)
+	if err != nil {
+		return entity.IssuedTokens{}, err
+	}
+	userID := user.ID
+	// outside of the rollout the proof is ignored and the session gets bearer tokens (RFC 9449 section 7.1)
+	dpopThumbprint := in.DPoPThumbprint
+	if dpopThumbprint != "" && !uc.features.Enabled(ctx, entity.FeatureDPoP, userID) {
+		dpopThumbprint = ""
+	}
+	sessionID := uuid.New()
+
+	refreshToken, err := uuid.NewUUID()
+	if err != nil {
+		return entity.IssuedTokens{}, err
+	}
+
+	netipAddr, err := netip.ParseAddr(in.IP)
+	if err != nil {
+		return entity.IssuedTokens{}, errors.New("invalid IP address")
+	}
+	canaryToken, err := uc.canary.newCanaryToken()
+	if err != nil {
+		return entity.IssuedTokens{}, err
+	}
+
+	privileges, roles, err := uc.privileges(ctx, userID)
+	if err != nil {
+		return entity.IssuedTokens{}, err
+	}
+	tokenState, err := uc.tokenVersions.Current(ctx, userID)
+	if err != nil {
+		return entity.IssuedTokens{}, err
 	}
 
+	fp := uc.fingerprinter.Fingerprint(netipAddr, in.UserAgent)
+	now := time.Now()
 	session := entity.Session{
-		ID:           uuid.New(),
+		ID:           sessionID,
 		UserID:       userID,
 		RefreshToken: refreshToken,
-		CreatedAt:    time.Now(),
-		ExpiresAt:    time.Now().Add(15 * 24 * time.Hour),
-		UserAgent:    userAgent,
-		ClientIP:     netipAddr,
+		CreatedAt:    now,
+		StartedAt:    now,
+		ExpiresAt:    uc.sessionPolicies.For(ct).expiry(now, now),
+		UserAgent:    fp.UserAgent,
+		ClientIP:     fp.IP,
+		ClientType:   ct,
+		DeviceName:   name,
+		IPHash:       fp.IPHash,
+		DeviceHash:   fp.DeviceHash,
+
+		CertThumbprint: in.CertThumbprint,
+		DPoPThumbprint: dpopThumbprint,
+		CanaryToken:    canaryToken,
+		Locale:         locale.Negotiate(in.AcceptLanguage),
+		Timezone:       locale.Timezone(in.Timezone),
+		Country:        location.Country,
+		City:           location.City,
+		Risk:           risk,
+		AttestedKey:    attested.key,
+		BoundNetwork:   fingerprint.Network(fp.IP),
+		Privileges:     privileges,
+		TokenState:     &tokenState,
 	}
 
-	err = uc.authRepo.StoreSession(ctx, userID, session)
+	// compared with the last session before this one becomes it
+	uc.checkTravel(ctx, userID, in, location)
+	err = uc.sessions.StoreSession(ctx, userID, session)
 	if err != nil {
-		uc.Metrics.LoginAttempts.WithLabelValues("failure").Inc()
-		return uuid.Nil, "", "", err
+		return entity.IssuedTokens{}, err
 	}
 
-	uc.Metrics.LoginAttempts.WithLabelValues("success").Inc()
-	return userID, accessToken, refreshToken.String(), nil
+	tokens, err := uc.sessionTokens(ctx, session, roles)
+	if err != nil {
+		return entity.IssuedTokens{}, err
+	}
+	uc.recordLogin(ctx, userID, in, entity.LoginSuccess)
+	return tokens, nil
 }
 
-// LogoutSession logs out the user from a specific session by deleting that session from the database.
+// clientType returns the client type the login claims, web when empty.
+func clientType(in entity.LoginInput) (entity.ClientType, error) {
+	ct := entity.ClientType(in.ClientType)
+	if ct == "" {
+		ct = entity.ClientTypeWeb
+	}
+	if !ct.Valid() {
+		return "", errors.New("invalid client type")
+	}
+	return ct, nil
+}
+
+// LogoutSession logs out the user from a specific session by deleting that session from the database,
+// its access tokens are denied until they expire on all instances, see SessionDenylist.
 func (uc *AuthUsecase) LogoutSession(ctx context.Context, userID string, sessionID string) error {
 	uid, err := uuid.Parse(userID)
 	if err != nil {
@@ -194,53 +848,187 @@ func (uc *AuthUsecase) LogoutSession(ctx context.Context, userID string, session
 	if err != nil {
 		return errors.New("invalid session ID")
 	}
-	err = uc.authRepo.DeleteSession(ctx, uid, sid)
+	err = uc.sessions.DeleteSession(ctx, uid, sid)
 	if err != nil {
 		return err
 	}
+	// the session is deleted, a failed denial only leaves its access tokens valid until they expire
+	if err := uc.denylist.Deny(ctx, []uuid.UUID{sid}); err != nil {
+		uc.logger.Error("Failed to deny logged out session", "user_id", uid, "session_id", sid, "error", err)
+	}
+	uc.audit.Record(ctx, entity.AuditEvent{
+		Action:  entity.AuditLogout,
+		ActorID: &uid,
+		Details: map[string]string{"session_id": sid.String()},
+	})
 	return nil
 }
 
-// LogoutAllSessions logs out the user from all sessions by deleting all sessions associated with the user from the database.
-func (uc *AuthUsecase) LogoutAllSessions(ctx context.Context, userID string) error {
+// LogoutAllSessions logs out the user from all sessions by deleting all sessions associated with the user from the database,
+// its outstanding access tokens are revoked through the token version.
+// In dry-run mode nothing is deleted, the report lists the sessions that would be revoked.
+func (uc *AuthUsecase) LogoutAllSessions(ctx context.Context, userID string, dryRun bool) (entity.AffectedReport, error) {
 	uid, err := uuid.Parse(userID)
 	if err != nil {
-		return errors.New("invalid user ID")
+		return entity.AffectedReport{}, errors.New("invalid user ID")
+	}
+	revoke := uc.sessions.DeleteAllSessions
+	if dryRun {
+		revoke = uc.sessions.ListSessionIDs
 	}
-	err = uc.authRepo.DeleteAllSessions(ctx, uid)
+	ids, err := revoke(ctx, uid)
 	if err != nil {
-		return err
+		return entity.AffectedReport{}, err
 	}
-	return nil
+	if !dryRun {
+		// the version is incremented in the database, a failed cache update only delays the revocation until the cache expires
+		if err := uc.tokenVersions.Invalidated(ctx, uid); err != nil {
+			uc.logger.Error("Failed to publish token version", "user_id", uid, "error", err)
+		}
+		uc.audit.Record(ctx, entity.AuditEvent{
+			Action:  entity.AuditLogoutAll,
+			ActorID: &uid,
+			Details: map[string]string{"sessions": strconv.Itoa(len(ids))},
+		})
+	}
+	return entity.AffectedReport{DryRun: dryRun, Count: len(ids), IDs: ids}, nil
+}
+
+// availabilityMinDuration is the minimum time CheckAvailability takes, hiding database timing differences.
+const availabilityMinDuration = 200 * time.Millisecond
+
+// CheckAvailability reports whether the username and email can be used for a new account, for inline
+// validation of registration forms. When both are given the answer covers both, it does not tell which one
+// is taken. Empty values are skipped, at least one is required. Reserved and banned handles are reported as taken.
+func (uc *AuthUsecase) CheckAvailability(ctx context.Context, username, email string) (bool, error) {
+	if username == "" && email == "" {
+		return false, customerrors.ErrInvalidUsername
+	}
+	if username != "" && !validateUsername(username) {
+		return false, customerrors.ErrInvalidUsername
+	}
+	if email != "" && !validateEmail(email) {
+		return false, customerrors.ErrInvalidEmail
+	}
+	var canonical string
+	if email != "" {
+		canonical = uc.emails.Canonical(email)
+	}
+
+	deadline := time.NewTimer(availabilityMinDuration)
+	defer deadline.Stop()
+
+	var taken bool
+	err := uc.handles.Check(ctx, username, uc.emails.Normalize(email))
+	switch {
+	case errors.Is(err, customerrors.ErrHandleUnavailable):
+		taken = true
+	case err != nil:
+		return false, err
+	default:
+		taken, err = uc.authRepo.LoginTaken(ctx, username, canonical)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	select {
+	case <-deadline.C:
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+	return !taken, nil
+}
+
+// GetProfile returns the record of the user, used for the user's own profile.
+func (uc *AuthUsecase) GetProfile(ctx context.Context, userID uuid.UUID) (entity.User, error) {
+	return uc.authRepo.GetUserByID(ctx, userID)
 }
 
 // VerifyUser checks if the provided access token is valid and returns the associated user ID if the token is valid.
 // It also checks if the user is blocked and returns an error if the user is blocked.
+// Certificate-bound and DPoP-bound tokens are rejected, callers able to check the binding use VerifyAccessClaims.
 func (uc *AuthUsecase) VerifyUser(token string) (userID uuid.UUID, err error) {
-	userID, err = uc.JWTManager.VerifyAccessToken(token)
+	claims, err := uc.VerifyAccessClaims(token)
 	if err != nil {
 		return uuid.Nil, err
 	}
-	isBlocked, err := uc.authRepo.UserIsBlocked(userID)
+	if claims.CertThumbprint != "" {
+		return uuid.Nil, customerrors.ErrCertificateMismatch
+	}
+	if claims.DPoPThumbprint != "" {
+		return uuid.Nil, customerrors.ErrProofKeyMismatch
+	}
+	return claims.UserID, nil
+}
+
+// VerifyAccessClaims is VerifyUser returning all claims of the token, including the session it belongs to.
+// Tokens that fail verification are rejected with customerrors.ErrInvalidToken. Tokens of blocked users get
+// customerrors.ErrUserBlocked; tokens of deleted users, tokens of an older token version, tokens minted before
+// the last password change (see TokenVersions) and tokens of denied sessions (see SessionDenylist) get
+// customerrors.ErrTokenRevoked. Other errors come from the token state lookup, like outages of its stores.
+// The caller must check the certificate binding (CertThumbprint) against the presented client certificate
+// and the DPoP binding (DPoPThumbprint) with VerifyProof.
+func (uc *AuthUsecase) VerifyAccessClaims(token string) (entity.AccessTokenClaims, error) {
+	claims, err := uc.JWTManager.VerifyAccessToken(token)
 	if err != nil {
-		return uuid.Nil, err
+		return entity.AccessTokenClaims{}, fmt.Errorf("%w: %w", customerrors.ErrInvalidToken, err)
 	}
-	if isBlocked {
-		return uuid.Nil, errors.New("user is blocked")
+	if uc.denylist.Denied(claims.SessionID) {
+		return entity.AccessTokenClaims{}, customerrors.ErrTokenRevoked
+	}
+	tokenState, err := uc.tokenVersions.Current(context.Background(), claims.UserID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return entity.AccessTokenClaims{}, customerrors.ErrTokenRevoked
+	}
+	if err != nil {
+		return entity.AccessTokenClaims{}, err
 	}
-	return userID, nil
+	if revoked(claims, tokenState) {
+		return entity.AccessTokenClaims{}, customerrors.ErrTokenRevoked
+	}
+	return claims, nil
+}
+
+// tenantIssuer is the issuer of the tenant domain the request was made to,
+// empty for the default domain and gRPC calls, which get the issuer of the JWT manager.
+func tenantIssuer(ctx context.Context) string {
+	tenant, _ := ctxUtil.TenantFromContext(ctx)
+	return tenant.Issuer
+}
+
+// VerifyProof verifies the DPoP proof sent with a request and returns the thumbprint of its key.
+// accessToken is empty on token requests and the presented token on protected requests.
+func (uc *AuthUsecase) VerifyProof(ctx context.Context, proof, method, uri, accessToken string) (string, error) {
+	return uc.proofVerifier.Verify(ctx, proof, method, uri, accessToken)
+}
+
+// TokenAudience returns the audience of a valid access or service token.
+func (uc *AuthUsecase) TokenAudience(token string) ([]string, error) {
+	return uc.JWTManager.Audience(token)
+}
+
+// TokenExpiry returns the expiration time of a valid access token.
+func (uc *AuthUsecase) TokenExpiry(token string) (time.Time, error) {
+	return uc.JWTManager.ExpiresAt(token)
 }
 
-// hashPassword hashes the given password using bcrypt
-func hashPassword(password string) (string, error) {
-	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	return string(passwordHash), err
+// rehashPassword stores a hash with the algorithm and parameters of the hasher for the verified password.
+// A failure only delays the upgrade to the next login, so it is logged and not returned.
+func (uc *AuthUsecase) rehashPassword(ctx context.Context, user entity.User, password string, hasher PasswordHasher) {
+	passwordHash, err := hasher.Hash(password)
+	if err == nil {
+		err = uc.authRepo.UpdatePasswordHash(ctx, user.ID, user.PasswordHash, passwordHash)
+	}
+	if err != nil {
+		uc.logger.Warn("Failed to upgrade password hash", "user_id", user.ID, "error", err)
+	}
 }
 
 // verifyPassword compares the provided password with the stored password hash and returns true if they match, false otherwise.
+// Besides bcrypt it accepts the hash formats of imported users.
 func verifyPassword(password, passwordHash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password))
-	return err == nil
+	return passhash.Verify(password, passwordHash)
 }
 
 // ValidatePassword checks if the password meets certain criteria
@@ -0,0 +1,330 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+
+	"main/domain/entity"
+	"main/internal/metrics"
+	"main/pkg/customerrors"
+	"main/pkg/fingerprint"
+	"main/pkg/tokenversion"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// fakeSessions holds at most one session and records what was done to it.
+type fakeSessions struct {
+	SessionRepository
+	session   *entity.Session
+	deleted   bool
+	refreshed *entity.Session
+	rotated   *entity.Session
+}
+
+func (f *fakeSessions) GetSessionByRefreshToken(_ context.Context, token uuid.UUID) (entity.Session, error) {
+	if f.session == nil || f.session.RefreshToken != token {
+		return entity.Session{}, pgx.ErrNoRows
+	}
+	return *f.session, nil
+}
+
+func (f *fakeSessions) GetSessionByPreviousRefreshToken(context.Context, uuid.UUID) (entity.Session, error) {
+	return entity.Session{}, pgx.ErrNoRows
+}
+
+func (f *fakeSessions) DeleteSession(context.Context, uuid.UUID, uuid.UUID) error {
+	f.deleted = true
+	return nil
+}
+
+func (f *fakeSessions) RefreshSession(_ context.Context, session entity.Session) error {
+	f.refreshed = &session
+	return nil
+}
+
+func (f *fakeSessions) RotateSession(_ context.Context, _ uuid.UUID, session entity.Session) error {
+	f.rotated = &session
+	return nil
+}
+
+type fakeJWT struct{ JWTManager }
+
+func (fakeJWT) NewAccessToken(entity.AccessTokenClaims) (string, error) { return "access", nil }
+
+type fakeTokenVersionRepo struct{}
+
+func (fakeTokenVersionRepo) UserTokenVersion(context.Context, uuid.UUID) (entity.TokenState, bool, error) {
+	return entity.TokenState{Version: 1}, false, nil
+}
+
+type fakeRoles []string
+
+func (r fakeRoles) UserRoles(context.Context, uuid.UUID) ([]string, error) { return r, nil }
+
+type fakeDenylistRepo struct {
+	DenylistRepo
+	denied []uuid.UUID
+}
+
+func (f *fakeDenylistRepo) DenySessions(_ context.Context, sessionIDs []uuid.UUID, _ time.Time) error {
+	f.denied = append(f.denied, sessionIDs...)
+	return nil
+}
+
+type fakeAuditor struct{ actions []string }
+
+func (f *fakeAuditor) Record(_ context.Context, event entity.AuditEvent) {
+	f.actions = append(f.actions, event.Action)
+}
+
+func TestRefreshSessionToken(t *testing.T) {
+	const (
+		userAgent = "app/1.0"
+		loginIP   = "203.0.113.10"
+	)
+	hasher := fingerprint.NewHasher([]byte("salt"), false)
+	login := hasher.Fingerprint(netip.MustParseAddr(loginIP), userAgent)
+	adminPrivileges := func() string {
+		uc := &AuthUsecase{roles: fakeRoles{"admin"}}
+		fp, _, _ := uc.privileges(context.Background(), uuid.Nil)
+		return fp
+	}()
+
+	tests := []struct {
+		name    string
+		session func(s *entity.Session)
+		policy  SessionPolicy
+		roles   fakeRoles
+		in      func(in *entity.RefreshInput)
+		wantErr error
+		// wantDeleted is set when the refresh ends the session, wantDenied when it also denies its access tokens
+		wantDeleted bool
+		wantDenied  bool
+		// wantRotated moves the session to a new ID, wantNewToken only issues a new refresh token
+		wantRotated  bool
+		wantNewToken bool
+		// wantAudit is an action recorded besides the refresh
+		wantAudit string
+	}{
+		{
+			name:         "refresh rotates the refresh token",
+			wantNewToken: true,
+		},
+		{
+			name:   "refresh within the rotation interval keeps the refresh token",
+			policy: SessionPolicy{TTL: time.Hour, RotationInterval: time.Hour},
+		},
+		{
+			name:    "unknown refresh token",
+			in:      func(in *entity.RefreshInput) { in.RefreshToken = uuid.NewString() },
+			wantErr: pgx.ErrNoRows,
+		},
+		{
+			name:    "web session refreshed from the body",
+			session: func(s *entity.Session) { s.ClientType = entity.ClientTypeWeb },
+			in:      func(in *entity.RefreshInput) { in.Native = true },
+			wantErr: customerrors.ErrRefreshCookieRequired,
+		},
+		{
+			name:         "native session refreshed from the body",
+			session:      func(s *entity.Session) { s.ClientType = entity.ClientTypeMobile },
+			in:           func(in *entity.RefreshInput) { in.Native = true },
+			wantNewToken: true,
+		},
+		{
+			name:    "certificate-bound session without the certificate",
+			session: func(s *entity.Session) { s.CertThumbprint = "x5t" },
+			wantErr: customerrors.ErrCertificateMismatch,
+		},
+		{
+			name:         "certificate-bound session with the certificate",
+			session:      func(s *entity.Session) { s.CertThumbprint = "x5t" },
+			in:           func(in *entity.RefreshInput) { in.CertThumbprint = "x5t" },
+			wantNewToken: true,
+		},
+		{
+			name:    "DPoP-bound session with another key",
+			session: func(s *entity.Session) { s.DPoPThumbprint = "jkt" },
+			in:      func(in *entity.RefreshInput) { in.DPoPThumbprint = "other" },
+			wantErr: customerrors.ErrProofKeyMismatch,
+		},
+		{
+			name:        "expired session",
+			session:     func(s *entity.Session) { s.ExpiresAt = time.Now().Add(-time.Minute) },
+			wantErr:     errors.New("session has expired"),
+			wantDeleted: true,
+		},
+		{
+			name:        "session beyond its lifetime",
+			session:     func(s *entity.Session) { s.StartedAt = time.Now().Add(-48 * time.Hour) },
+			policy:      SessionPolicy{TTL: time.Hour, MaxLifetime: 24 * time.Hour},
+			wantErr:     errors.New("session has expired"),
+			wantDeleted: true,
+		},
+		{
+			name:    "blocked session",
+			session: func(s *entity.Session) { s.IsBlocked = true },
+			wantErr: customerrors.ErrSessionBlocked,
+		},
+		{
+			name:        "session started before the token version was raised",
+			session:     func(s *entity.Session) { s.TokenState = &entity.TokenState{} },
+			wantErr:     pgx.ErrNoRows,
+			wantDeleted: true,
+		},
+		{
+			name:    "enforced binding, another user agent",
+			policy:  SessionPolicy{TTL: time.Hour, Binding: BindingEnforce},
+			in:      func(in *entity.RefreshInput) { in.UserAgent = "curl/8.0" },
+			wantErr: customerrors.ErrSessionBindingMismatch,
+		},
+		{
+			name:    "enforced binding, another network",
+			policy:  SessionPolicy{TTL: time.Hour, Binding: BindingEnforce},
+			in:      func(in *entity.RefreshInput) { in.IP = "198.51.100.7" },
+			wantErr: customerrors.ErrSessionBindingMismatch,
+		},
+		{
+			name:         "enforced binding, same network",
+			policy:       SessionPolicy{TTL: time.Hour, Binding: BindingEnforce},
+			in:           func(in *entity.RefreshInput) { in.IP = "203.0.113.99" },
+			wantNewToken: true,
+		},
+		{
+			name:         "warned binding, another user agent",
+			policy:       SessionPolicy{TTL: time.Hour, Binding: BindingWarn},
+			in:           func(in *entity.RefreshInput) { in.UserAgent = "curl/8.0" },
+			wantNewToken: true,
+			wantAudit:    entity.AuditSessionBindingMismatch,
+		},
+		{
+			name:        "IP change with reauth",
+			policy:      SessionPolicy{TTL: time.Hour, IPChange: IPChangePolicy{Action: IPChangeReauth}},
+			in:          func(in *entity.RefreshInput) { in.IP = "198.51.100.7" },
+			wantErr:     customerrors.ErrReauthenticationRequired,
+			wantDeleted: true,
+			wantDenied:  true,
+		},
+		{
+			name:         "IP change within the network with reauth",
+			policy:       SessionPolicy{TTL: time.Hour, IPChange: IPChangePolicy{Action: IPChangeReauth, TolerateSameNetwork: true}},
+			in:           func(in *entity.RefreshInput) { in.IP = "203.0.113.99" },
+			wantNewToken: true,
+		},
+		{
+			name:         "roles unchanged",
+			session:      func(s *entity.Session) { s.Privileges = adminPrivileges },
+			roles:        fakeRoles{"admin"},
+			wantNewToken: true,
+		},
+		{
+			name:         "roles changed",
+			session:      func(s *entity.Session) { s.Privileges = adminPrivileges },
+			roles:        fakeRoles{"user"},
+			policy:       SessionPolicy{TTL: time.Hour, RotationInterval: time.Hour},
+			wantRotated:  true,
+			wantNewToken: true,
+			wantAudit:    entity.AuditSessionRotated,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := entity.Session{
+				ID:           uuid.New(),
+				UserID:       uuid.New(),
+				RefreshToken: uuid.New(),
+				ClientType:   entity.ClientTypeMobile,
+				ClientIP:     login.IP,
+				IPHash:       login.IPHash,
+				DeviceHash:   login.DeviceHash,
+				BoundNetwork: fingerprint.Network(login.IP),
+				CreatedAt:    time.Now().Add(-time.Minute),
+				StartedAt:    time.Now().Add(-time.Minute),
+				ExpiresAt:    time.Now().Add(time.Hour),
+			}
+			if tt.session != nil {
+				tt.session(&session)
+			}
+			in := entity.RefreshInput{RefreshToken: session.RefreshToken.String(), UserAgent: userAgent, IP: loginIP}
+			if tt.in != nil {
+				tt.in(&in)
+			}
+			policy := tt.policy
+			if policy.TTL == 0 {
+				policy.TTL = time.Hour
+			}
+
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			sessions := &fakeSessions{session: &session}
+			denylist := &fakeDenylistRepo{}
+			audit := &fakeAuditor{}
+			uc := &AuthUsecase{
+				sessions:        sessions,
+				JWTManager:      fakeJWT{},
+				Metrics:         metrics.NewMetrics(prometheus.NewRegistry(), metrics.Options{}),
+				logger:          logger,
+				sessionPolicies: SessionPolicies{Default: policy},
+				fingerprinter:   hasher,
+				tokenVersions:   NewTokenVersions(fakeTokenVersionRepo{}, tokenversion.NewMemoryCache(), time.Minute, nil),
+				audit:           audit,
+				denylist:        NewSessionDenylist(denylist, logger, time.Minute, nil),
+			}
+			if tt.roles != nil {
+				uc.roles = tt.roles
+			}
+
+			tokens, err := uc.RefreshSessionToken(context.Background(), in)
+			if tt.wantErr != nil {
+				if err == nil || (!errors.Is(err, tt.wantErr) && err.Error() != tt.wantErr.Error()) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if sessions.deleted != tt.wantDeleted {
+				t.Errorf("session deleted = %t, want %t", sessions.deleted, tt.wantDeleted)
+			}
+			if denied := len(denylist.denied) > 0; denied != tt.wantDenied {
+				t.Errorf("session denied = %t, want %t", denied, tt.wantDenied)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			stored := sessions.refreshed
+			if tt.wantRotated {
+				stored = sessions.rotated
+			}
+			if stored == nil {
+				t.Fatalf("session was not stored (refreshed %t, rotated %t)", sessions.refreshed != nil, sessions.rotated != nil)
+			}
+			if rotated := stored.ID != session.ID; rotated != tt.wantRotated {
+				t.Errorf("session ID changed = %t, want %t", rotated, tt.wantRotated)
+			}
+			if newToken := tokens.RefreshToken != session.RefreshToken.String(); newToken != tt.wantNewToken {
+				t.Errorf("new refresh token = %t, want %t", newToken, tt.wantNewToken)
+			}
+			if tokens.RefreshToken != stored.RefreshToken.String() || tokens.SessionID != stored.ID {
+				t.Error("issued tokens do not match the stored session")
+			}
+			if tt.wantAudit != "" && !slices.Contains(audit.actions, tt.wantAudit) {
+				t.Errorf("audit actions = %v, want %s", audit.actions, tt.wantAudit)
+			}
+			if !slices.Contains(audit.actions, entity.AuditRefresh) {
+				t.Error("refresh was not audited")
+			}
+			if tokens.ClientType != session.ClientType {
+				t.Errorf("client type = %q, want %q", tokens.ClientType, session.ClientType)
+			}
+		})
+	}
+}
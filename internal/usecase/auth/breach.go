@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"context"
+	"log/slog"
+	"main/domain/entity"
+	"main/pkg/customerrors"
+)
+
+// BreachChecker looks passwords up in known data breaches.
+type BreachChecker interface {
+	// Breached returns how often the password appears in breaches, 0 if never.
+	Breached(ctx context.Context, password string) (int, error)
+}
+
+// BreachCheck decides what happens to new passwords found in data breaches: they are rejected in strict
+// mode, otherwise accepted with a warning. A nil Checker disables the check.
+type BreachCheck struct {
+	Checker BreachChecker
+	Strict  bool
+}
+
+// check returns the warnings for the new password, or customerrors.ErrPasswordBreached in strict mode.
+// An unavailable checker must not block users from setting passwords, so its errors are only logged.
+func (b BreachCheck) check(ctx context.Context, logger *slog.Logger, password string) ([]string, error) {
+	if b.Checker == nil {
+		return nil, nil
+	}
+	count, err := b.Checker.Breached(ctx, password)
+	if err != nil {
+		logger.Warn("Breached password check failed, accepting the password", "error", err)
+		return nil, nil
+	}
+	if count == 0 {
+		return nil, nil
+	}
+	if b.Strict {
+		return nil, customerrors.ErrPasswordBreached
+	}
+	return []string{entity.WarningPasswordBreached}, nil
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"main/domain/entity"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// CanaryAction is the incident response to the use of a decoy refresh token.
+type CanaryAction string
+
+const (
+	// CanaryLog only raises the alert
+	CanaryLog CanaryAction = "log"
+	// CanaryRevokeUser also ends every session of the user the decoy was issued to and revokes its access tokens
+	CanaryRevokeUser CanaryAction = "revoke_user"
+)
+
+// Valid reports whether the action is one of the known actions.
+func (a CanaryAction) Valid() bool {
+	return a == CanaryLog || a == CanaryRevokeUser
+}
+
+// RefreshCanary configures decoy refresh tokens. Every session gets a second refresh token that is stored like
+// the real one but never handed to the client, so only someone who read the sessions table or the traffic to the
+// database can present it.
+type RefreshCanary struct {
+	Enabled bool
+	Action  CanaryAction
+}
+
+// newCanaryToken returns a decoy refresh token, it has the same form as the real ones.
+func (c RefreshCanary) newCanaryToken() (uuid.UUID, error) {
+	if !c.Enabled {
+		return uuid.Nil, nil
+	}
+	return uuid.NewUUID()
+}
+
+// checkCanary is called for refresh tokens that match no session. When the token is a decoy, the alert is raised
+// and the incident response of the canary runs. The caller answers like for any unknown token either way, the
+// client must not learn that it was detected.
+func (uc *AuthUsecase) checkCanary(ctx context.Context, token uuid.UUID, in entity.RefreshInput) {
+	if !uc.canary.Enabled {
+		return
+	}
+	session, err := uc.sessions.GetSessionByCanaryToken(ctx, token)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return
+	}
+	if err != nil {
+		uc.logger.Error("Failed to look up refresh canary", "error", err)
+		return
+	}
+
+	action := uc.canary.Action
+	if !action.Valid() {
+		action = CanaryRevokeUser
+	}
+	uc.Metrics.RefreshCanaryHits.WithLabelValues(string(action)).Inc()
+	uc.logger.Error("Decoy refresh token used, the session store may be compromised",
+		"user_id", session.UserID, "session_id", session.ID, "ip", in.IP, "user_agent", in.UserAgent, "action", action)
+
+	if action != CanaryRevokeUser {
+		return
+	}
+	ids, err := uc.sessions.DeleteAllSessions(ctx, session.UserID)
+	if err != nil {
+		uc.logger.Error("Failed to revoke sessions after refresh canary", "user_id", session.UserID, "error", err)
+		return
+	}
+	// the version is incremented in the database, a failed cache update only delays the revocation until the cache expires
+	if err := uc.tokenVersions.Invalidated(ctx, session.UserID); err != nil {
+		uc.logger.Error("Failed to publish token version", "user_id", session.UserID, "error", err)
+	}
+	uc.logger.Warn("Sessions revoked after refresh canary", "user_id", session.UserID, "sessions", len(ids))
+}
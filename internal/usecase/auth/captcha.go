@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"main/pkg/captcha"
+	"main/pkg/customerrors"
+	"net/netip"
+	"time"
+)
+
+// Actions of the CAPTCHA tokens, reCAPTCHA v3 and Turnstile tokens of another action are rejected.
+const (
+	captchaActionRegister = "register"
+	captchaActionLogin    = "login"
+)
+
+// CaptchaVerifier verifies CAPTCHA response tokens, implemented by captcha.Client.
+type CaptchaVerifier interface {
+	// Verify checks the token of the action, it returns an error wrapping captcha.ErrRejected for bad tokens.
+	Verify(ctx context.Context, token, action, remoteIP string) error
+}
+
+// FailureCounter counts failed logins per IP in fixed windows, implemented by the ratelimit stores.
+type FailureCounter interface {
+	Incr(ctx context.Context, key string, window time.Duration) (int64, error)
+	Count(ctx context.Context, key string) (int64, error)
+}
+
+// CaptchaPolicy configures when a CAPTCHA must be solved. A nil Verifier disables it.
+type CaptchaPolicy struct {
+	Verifier CaptchaVerifier
+	// Register requires a CAPTCHA on every registration
+	Register bool
+	// LoginAfter requires a CAPTCHA on password logins from an IP with that many failed logins in the
+	// last Window, zero never requires one
+	LoginAfter int
+	Window     time.Duration
+	Failures   FailureCounter
+}
+
+// checkRegistrationCaptcha verifies the CAPTCHA of a registration when the policy requires one.
+func (uc *AuthUsecase) checkRegistrationCaptcha(ctx context.Context, token, ip string) error {
+	if uc.captcha.Verifier == nil || !uc.captcha.Register {
+		return nil
+	}
+	return uc.verifyCaptcha(ctx, token, captchaActionRegister, ip)
+}
+
+// checkLoginCaptcha verifies the CAPTCHA of a password login once the IP has failed LoginAfter times.
+// A failure to read the counter is logged and lets the login through, like an unreachable rate limiter.
+func (uc *AuthUsecase) checkLoginCaptcha(ctx context.Context, token, ip string) error {
+	if uc.captcha.Verifier == nil || uc.captcha.LoginAfter <= 0 {
+		return nil
+	}
+	failures, err := uc.captcha.Failures.Count(ctx, uc.loginFailureKey(ip))
+	if err != nil {
+		uc.logger.Error("Failed to read login failures", "error", err)
+		return nil
+	}
+	if failures < int64(uc.captcha.LoginAfter) {
+		return nil
+	}
+	return uc.verifyCaptcha(ctx, token, captchaActionLogin, ip)
+}
+
+// countLoginFailure counts a failed password login of the IP towards LoginAfter.
+func (uc *AuthUsecase) countLoginFailure(ctx context.Context, ip string) {
+	if uc.captcha.Verifier == nil || uc.captcha.LoginAfter <= 0 {
+		return
+	}
+	if _, err := uc.captcha.Failures.Incr(ctx, uc.loginFailureKey(ip), uc.captcha.Window); err != nil {
+		uc.logger.Error("Failed to count login failure", "error", err)
+	}
+}
+
+// loginFailureKey is the counter key of the IP, it holds the hash of the IP rather than the IP itself.
+func (uc *AuthUsecase) loginFailureKey(ip string) string {
+	addr, _ := netip.ParseAddr(ip)
+	return "captcha:login:" + uc.fingerprinter.Fingerprint(addr, "").IPHash
+}
+
+// verifyCaptcha returns customerrors.ErrCaptchaRequired for missing and rejected tokens. Errors of the
+// provider are returned as they are, the client cannot solve them with another token.
+func (uc *AuthUsecase) verifyCaptcha(ctx context.Context, token, action, ip string) error {
+	if token == "" {
+		uc.Metrics.CaptchaVerifications.WithLabelValues(action, "missing").Inc()
+		return customerrors.ErrCaptchaRequired
+	}
+	outcome := "verified"
+	err := uc.captcha.Verifier.Verify(ctx, token, action, ip)
+	switch {
+	case errors.Is(err, captcha.ErrRejected):
+		outcome, err = "rejected", fmt.Errorf("%w: %v", customerrors.ErrCaptchaRequired, err)
+	case err != nil:
+		outcome = "error"
+	}
+	uc.Metrics.CaptchaVerifications.WithLabelValues(action, outcome).Inc()
+	return err
+}
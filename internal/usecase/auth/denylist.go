@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DenylistRepo stores the denied sessions, shared by all instances.
+type DenylistRepo interface {
+	// DenySessions adds the sessions to the denylist until the given time.
+	DenySessions(ctx context.Context, sessionIDs []uuid.UUID, until time.Time) error
+
+	// DeniedSessions returns the sessions denied until a time in the future.
+	DeniedSessions(ctx context.Context) (map[uuid.UUID]time.Time, error)
+
+	// PruneDeniedSessions removes the entries that expired and returns how many it removed.
+	PruneDeniedSessions(ctx context.Context) (int64, error)
+}
+
+// DenylistBroadcaster tells the other instances about denied sessions right away, implemented by
+// revocation.RedisBus. They still pick the sessions up at their next reload when it fails.
+type DenylistBroadcaster interface {
+	Publish(ctx context.Context, sessionIDs []uuid.UUID, until time.Time) error
+}
+
+// SessionDenylist rejects the access tokens of revoked sessions before they expire. Revoking a session only stops
+// its refreshes, its access tokens carry the session ID (sid claim) and stay valid until they expire, so sessions
+// revoked in an incident are denied for the lifetime of an access token. Lookups are served from memory, the list
+// is reloaded from the database every reload interval to pick up the sessions denied by other instances, which
+// also broadcast them when a broadcaster is set.
+type SessionDenylist struct {
+	repo   DenylistRepo
+	logger *slog.Logger
+	// ttl is the lifetime of access tokens, after which the tokens of a denied session have expired anyway
+	ttl time.Duration
+	// broadcaster publishes the denied sessions to the other instances, nil leaves them to the reload
+	broadcaster DenylistBroadcaster
+
+	mu      sync.RWMutex
+	entries map[uuid.UUID]time.Time
+}
+
+func NewSessionDenylist(repo DenylistRepo, logger *slog.Logger, ttl time.Duration, broadcaster DenylistBroadcaster) *SessionDenylist {
+	return &SessionDenylist{
+		repo:        repo,
+		logger:      logger,
+		ttl:         ttl,
+		broadcaster: broadcaster,
+		entries:     make(map[uuid.UUID]time.Time),
+	}
+}
+
+// Deny adds the sessions to the denylist of all instances.
+func (d *SessionDenylist) Deny(ctx context.Context, sessionIDs []uuid.UUID) error {
+	if len(sessionIDs) == 0 {
+		return nil
+	}
+	until := time.Now().Add(d.ttl)
+	if err := d.repo.DenySessions(ctx, sessionIDs, until); err != nil {
+		return err
+	}
+	d.Apply(sessionIDs, until)
+	if d.broadcaster != nil {
+		if err := d.broadcaster.Publish(ctx, sessionIDs, until); err != nil {
+			d.logger.Warn("Failed to broadcast denied sessions, other instances pick them up at their next reload",
+				"sessions", len(sessionIDs), "error", err)
+		}
+	}
+	return nil
+}
+
+// Apply adds sessions denied on this or another instance to the denylist in memory, keeping the later time of
+// sessions already on it.
+func (d *SessionDenylist) Apply(sessionIDs []uuid.UUID, until time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, id := range sessionIDs {
+		if until.After(d.entries[id]) {
+			d.entries[id] = until
+		}
+	}
+}
+
+// Denied reports whether the access tokens of the session are rejected. A nil denylist denies nothing.
+func (d *SessionDenylist) Denied(sessionID uuid.UUID) bool {
+	if d == nil {
+		return false
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	until, ok := d.entries[sessionID]
+	return ok && time.Now().Before(until)
+}
+
+// Size returns the number of unexpired entries held in memory, zero for a nil denylist.
+func (d *SessionDenylist) Size() int {
+	if d == nil {
+		return 0
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	now := time.Now()
+	n := 0
+	for _, until := range d.entries {
+		if now.Before(until) {
+			n++
+		}
+	}
+	return n
+}
+
+// Load replaces the denylist with the entries of the database.
+func (d *SessionDenylist) Load(ctx context.Context) error {
+	entries, err := d.repo.DeniedSessions(ctx)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.entries = entries
+	return nil
+}
+
+// Run reloads the denylist every interval and prunes expired entries until the context is cancelled.
+func (d *SessionDenylist) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := d.repo.PruneDeniedSessions(ctx); err != nil {
+				d.logger.Error("Failed to prune session denylist", "error", err)
+			}
+			if err := d.Load(ctx); err != nil {
+				d.logger.Error("Failed to reload session denylist", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"main/domain/entity"
+	"main/pkg/customerrors"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ElevationPolicy keeps the access tokens of admins short-lived and lets them elevate their session for a while
+// by authenticating again, which the most sensitive admin operations require.
+type ElevationPolicy struct {
+	// Roles are the admin roles, their holders get access tokens of at most TokenTTL
+	Roles    []string
+	TokenTTL time.Duration
+	// Duration is how long an elevation lasts, zero disables elevation
+	Duration time.Duration
+}
+
+// tokenTTL returns the lifetime of the access tokens of a user with the roles, zero for the configured one.
+func (p ElevationPolicy) tokenTTL(roles []string) time.Duration {
+	for _, role := range roles {
+		if slices.Contains(p.Roles, role) {
+			return p.TokenTTL
+		}
+	}
+	return 0
+}
+
+// ElevateSession elevates the session of the user until the elevation duration is over after a step-up
+// authentication. The password is checked first, users with a second factor get customerrors.ErrMFARequired
+// and its challenge, whose code completes the elevation. The session is rotated like at a role change, the
+// returned tokens carry the elevated_until claim and so do the tokens of its refreshes until the end of the
+// elevation.
+func (uc *AuthUsecase) ElevateSession(ctx context.Context, in entity.ElevationInput) (entity.IssuedTokens, error) {
+	if uc.elevation.Duration <= 0 {
+		return entity.IssuedTokens{}, customerrors.ErrElevationDisabled
+	}
+	session, err := uc.sessions.GetSession(ctx, in.UserID, in.SessionID)
+	if err != nil {
+		return entity.IssuedTokens{}, err
+	}
+	if session.IsBlocked {
+		return entity.IssuedTokens{}, customerrors.ErrSessionBlocked
+	}
+	user, err := uc.authRepo.GetUserByID(ctx, in.UserID)
+	if err != nil {
+		return entity.IssuedTokens{}, err
+	}
+	if user.IsBlocked {
+		return entity.IssuedTokens{}, customerrors.ErrUserBlocked
+	}
+
+	if in.ChallengeID == uuid.Nil {
+		if !verifyPassword(in.Password, user.PasswordHash) {
+			uc.countLoginFailure(ctx, in.IP)
+			return entity.IssuedTokens{}, customerrors.ErrInvalidCredentials
+		}
+		if uc.mfa != nil {
+			challenge, err := uc.mfa.Challenge(ctx, user, "")
+			if err != nil {
+				return entity.IssuedTokens{}, err
+			}
+			if challenge != nil {
+				return entity.IssuedTokens{UserID: user.ID, MFA: challenge}, customerrors.ErrMFARequired
+			}
+		}
+	} else {
+		if uc.mfa == nil {
+			return entity.IssuedTokens{}, customerrors.ErrInvalidOTP
+		}
+		userID, err := uc.mfa.Verify(ctx, in.ChallengeID, in.Code)
+		if errors.Is(err, customerrors.ErrTooManyAttempts) && userID != uuid.Nil {
+			uc.countLockout(ctx, userID, in.IP)
+		}
+		if err != nil {
+			return entity.IssuedTokens{}, err
+		}
+		// a challenge is only sent after the password, but it must be one of this user
+		if userID != user.ID {
+			return entity.IssuedTokens{}, customerrors.ErrInvalidOTP
+		}
+	}
+
+	_, roles, err := uc.privileges(ctx, user.ID)
+	if err != nil {
+		return entity.IssuedTokens{}, err
+	}
+	session.ElevatedUntil = time.Now().Add(uc.elevation.Duration)
+	if err := uc.rotateSession(ctx, &session, RotationElevated); err != nil {
+		return entity.IssuedTokens{}, err
+	}
+	tokens, err := uc.sessionTokens(ctx, session, roles)
+	if err != nil {
+		return entity.IssuedTokens{}, err
+	}
+	tokens.ElevatedUntil = session.ElevatedUntil
+
+	uc.logger.Info("Session elevated", "user_id", user.ID, "session_id", session.ID, "until", session.ElevatedUntil)
+	uc.audit.Record(ctx, entity.AuditEvent{
+		Action:  entity.AuditSessionElevated,
+		ActorID: &user.ID,
+		Details: map[string]string{
+			"session_id":     session.ID.String(),
+			"elevated_until": session.ElevatedUntil.Format(time.RFC3339),
+		},
+	})
+	return tokens, nil
+}
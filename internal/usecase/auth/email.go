@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"main/pkg/customerrors"
+	"main/pkg/emailnorm"
+	"main/pkg/locale"
+	"main/pkg/utils"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// EmailChangeRepo defines the interface for pending email change storage.
+type EmailChangeRepo interface {
+	// StoreEmailChange saves a pending email change, replacing any previous one of the user.
+	StoreEmailChange(ctx context.Context, tokenHash []byte, userID uuid.UUID, newEmail, newCanonicalEmail string, expiresAt time.Time) error
+
+	// ConsumeEmailChange applies the pending change, returns pgx.ErrNoRows for unknown or expired tokens.
+	ConsumeEmailChange(ctx context.Context, tokenHash []byte) (userID uuid.UUID, newEmail string, err error)
+}
+
+// EmailUsecase implements the change email flow: the new address has to be confirmed
+// through a link before the change is committed, the old address is notified.
+type EmailUsecase struct {
+	emailChangeRepo EmailChangeRepo
+	userRepo        UserRepo
+	mailer          Mailer
+	logger          *slog.Logger
+	tokenTTL        time.Duration
+	confirmURL      string
+	emails          emailnorm.Normalizer
+	// handles rejects banned email addresses
+	handles HandlePolicy
+}
+
+func NewEmailUsecase(
+	emailChangeRepo EmailChangeRepo,
+	userRepo UserRepo,
+	mailer Mailer,
+	logger *slog.Logger,
+	tokenTTL time.Duration,
+	confirmURL string,
+	emails emailnorm.Normalizer,
+	handles HandlePolicy) *EmailUsecase {
+	return &EmailUsecase{
+		emailChangeRepo: emailChangeRepo,
+		userRepo:        userRepo,
+		mailer:          mailer,
+		logger:          logger,
+		tokenTTL:        tokenTTL,
+		confirmURL:      confirmURL,
+		emails:          emails,
+		handles:         handles,
+	}
+}
+
+// RequestEmailChange verifies the password of the user and sends a confirmation link to the new address.
+// Nothing changes on the account until the link is opened. Banned addresses are rejected with customerrors.ErrHandleUnavailable.
+func (uc *EmailUsecase) RequestEmailChange(ctx context.Context, userID uuid.UUID, password, newEmail string) error {
+	newEmail = uc.emails.Normalize(newEmail)
+	if !validateEmail(newEmail) {
+		return customerrors.ErrInvalidEmail
+	}
+
+	user, err := uc.userRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !verifyPassword(password, user.PasswordHash) {
+		return customerrors.ErrInvalidCredentials
+	}
+	if err := uc.handles.Check(ctx, "", newEmail); err != nil {
+		return err
+	}
+	canonical := uc.emails.Canonical(newEmail)
+	if uc.emails.Canonical(user.Email) == canonical {
+		return customerrors.ErrEmailTaken
+	}
+	if _, err := uc.userRepo.GetUserByEmail(ctx, canonical); err == nil {
+		return customerrors.ErrEmailTaken
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+
+	token, err := utils.GenerateToken(32)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	expiresAt := now.Add(uc.tokenTTL)
+	err = uc.emailChangeRepo.StoreEmailChange(ctx, utils.HashToken(token), userID, newEmail, canonical, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	lang, tz := user.Locale, user.Timezone
+	link := uc.confirmURL + "?token=" + url.QueryEscape(token)
+	body := locale.T(lang, "change_email.body", link, locale.FormatTime(expiresAt, lang, tz))
+	if err := uc.mailer.Send(ctx, newEmail, locale.T(lang, "change_email.subject"), body); err != nil {
+		return err
+	}
+
+	// the old address learns about the attempt right away, in case the account was compromised
+	notice := locale.T(lang, "change_email_notice.body", locale.FormatTime(now, lang, tz), newEmail)
+	if err := uc.mailer.Send(ctx, user.Email, locale.T(lang, "change_email_notice.subject"), notice); err != nil {
+		uc.logger.Error("Failed to notify old email address", "user_id", userID, "error", err)
+	}
+	return nil
+}
+
+// ConfirmEmailChange commits the pending change identified by the token.
+func (uc *EmailUsecase) ConfirmEmailChange(ctx context.Context, token string) (uuid.UUID, error) {
+	if token == "" {
+		return uuid.Nil, customerrors.ErrInvalidToken
+	}
+	userID, _, err := uc.emailChangeRepo.ConsumeEmailChange(ctx, utils.HashToken(token))
+	if err != nil {
+		var pgErr *pgconn.PgError
+		switch {
+		case errors.Is(err, pgx.ErrNoRows), errors.Is(err, customerrors.ErrNoTagsAffected):
+			return uuid.Nil, customerrors.ErrInvalidToken
+		case errors.As(err, &pgErr) && pgErr.Code == "23505":
+			return uuid.Nil, customerrors.ErrEmailTaken
+		}
+		return uuid.Nil, err
+	}
+	return userID, nil
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"context"
+	"sync"
+)
+
+// dummyPassword is hashed once to give logins of unknown accounts the cost of a password check.
+const dummyPassword = "enumeration-protection-dummy-password"
+
+// EnumerationProtection makes registration, login and password recovery answer the same way, in about the same
+// time, whether or not the account exists. Logins of unknown accounts still verify a password hash, registrations
+// with a taken email look successful while the owner of the address is told about the attempt, and the emails of
+// these flows are sent after the response. Disabled, the flows report unknown and taken accounts explicitly.
+type EnumerationProtection struct {
+	Enabled bool
+
+	hasher    PasswordHasher
+	dummyOnce sync.Once
+	dummyHash string
+}
+
+func NewEnumerationProtection(enabled bool, hasher PasswordHasher) *EnumerationProtection {
+	return &EnumerationProtection{
+		Enabled: enabled,
+		hasher:  hasher,
+	}
+}
+
+// burnPasswordCheck verifies the password against a dummy hash of the configured algorithm, for accounts that
+// do not exist or have no password. The result is always a mismatch.
+func (p *EnumerationProtection) burnPasswordCheck(password string) {
+	if !p.Enabled {
+		return
+	}
+	p.dummyOnce.Do(func() {
+		p.dummyHash, _ = p.hasher.Hash(dummyPassword)
+	})
+	verifyPassword(password, p.dummyHash)
+}
+
+// run calls f after the response while the protection is enabled, so the time it takes (usually sending an
+// email) does not tell existing accounts apart. The context keeps the values of the request but not its deadline.
+// Disabled, f runs before the response.
+func (p *EnumerationProtection) run(ctx context.Context, f func(ctx context.Context)) {
+	if !p.Enabled {
+		f(ctx)
+		return
+	}
+	go f(context.WithoutCancel(ctx))
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"context"
+	"main/domain/entity"
+
+	"github.com/google/uuid"
+)
+
+// exportPageSize is the number of users read from the database at once during an export.
+const exportPageSize = 1000
+
+// ExportRepo defines the interface for reading users to export.
+type ExportRepo interface {
+	// ListUsersForExport returns up to limit live users ordered by ID, starting after afterID.
+	ListUsersForExport(ctx context.Context, afterID uuid.UUID, limit int) ([]entity.ExportUser, error)
+}
+
+// ExportUsecase exports users and their credential metadata for migrating to another identity provider.
+type ExportUsecase struct {
+	exportRepo ExportRepo
+}
+
+func NewExportUsecase(exportRepo ExportRepo) *ExportUsecase {
+	return &ExportUsecase{
+		exportRepo: exportRepo,
+	}
+}
+
+// ExportUsers passes all live users to emit page by page, so exports of any size run in constant memory.
+func (uc *ExportUsecase) ExportUsers(ctx context.Context, emit func([]entity.ExportUser) error) error {
+	afterID := uuid.Nil
+	for {
+		users, err := uc.exportRepo.ListUsersForExport(ctx, afterID, exportPageSize)
+		if err != nil {
+			return err
+		}
+		if len(users) == 0 {
+			return nil
+		}
+		if err := emit(users); err != nil {
+			return err
+		}
+		if len(users) < exportPageSize {
+			return nil
+		}
+		afterID = users[len(users)-1].ID
+	}
+}
@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"main/domain/entity"
+	"main/pkg/customerrors"
+	"net/netip"
+	"slices"
+)
+
+// GeoLocator maps IP addresses to countries and cities, implemented by the geoip databases.
+type GeoLocator interface {
+	// Locate returns the country and city of the address, empty fields for unknown ones.
+	Locate(ip netip.Addr) entity.GeoLocation
+}
+
+// GeoPolicy configures the location of logins. A nil Locator leaves sessions and the login history without one.
+type GeoPolicy struct {
+	Locator GeoLocator
+	// BlockedCountries are the ISO 3166-1 alpha-2 codes of the countries logins are refused from. Addresses
+	// of unknown countries are let through.
+	BlockedCountries []string
+}
+
+// locate returns the location of the IP of a login, empty without a database or for an invalid IP.
+func (uc *AuthUsecase) locate(ip string) entity.GeoLocation {
+	if uc.geo.Locator == nil {
+		return entity.GeoLocation{}
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return entity.GeoLocation{}
+	}
+	return uc.geo.Locator.Locate(addr)
+}
+
+// checkLocation returns customerrors.ErrLoginLocationBlocked for logins from a blocked country.
+func (uc *AuthUsecase) checkLocation(location entity.GeoLocation) error {
+	if location.Country == "" || !slices.Contains(uc.geo.BlockedCountries, location.Country) {
+		return nil
+	}
+	uc.logger.Warn("Login from blocked country", "country", location.Country)
+	return customerrors.ErrLoginLocationBlocked
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"main/domain/entity"
+	"main/pkg/customerrors"
+	"main/pkg/emailnorm"
+	"main/pkg/idgen"
+	"main/pkg/passhash"
+
+	"github.com/google/uuid"
+)
+
+// maxImportBatch is the number of users one import may contain, larger exports must be split.
+const maxImportBatch = 10000
+
+// ImportRepo defines the interface for bulk user import storage.
+type ImportRepo interface {
+	// ImportUsers inserts the users in one transaction and returns the indexes of those skipped because
+	// their ID, username or email is already used. In dry-run mode the transaction is rolled back.
+	ImportUsers(ctx context.Context, users []entity.ImportUser, dryRun bool) (conflicts []int, err error)
+}
+
+// ImportUsecase imports users with pre-hashed passwords from other systems. Users are inserted with
+// their hash as is, AuthUsecase replaces it with a hash of the current algorithm on the first login.
+type ImportUsecase struct {
+	importRepo ImportRepo
+	logger     *slog.Logger
+	emails     emailnorm.Normalizer
+	// userIDs generates the IDs of users imported without one, IDs of the source system are kept
+	userIDs idgen.Generator
+}
+
+func NewImportUsecase(importRepo ImportRepo, logger *slog.Logger, emails emailnorm.Normalizer, userIDs idgen.Generator) *ImportUsecase {
+	return &ImportUsecase{
+		importRepo: importRepo,
+		logger:     logger,
+		emails:     emails,
+		userIDs:    userIDs,
+	}
+}
+
+// ImportUsers validates the users and imports the valid ones, the others are reported as rejected.
+// Users whose ID, username or email is taken are rejected as well, the rest of the batch is imported.
+func (uc *ImportUsecase) ImportUsers(ctx context.Context, users []entity.ImportUser, dryRun bool) (entity.ImportReport, error) {
+	if len(users) > maxImportBatch {
+		return entity.ImportReport{}, fmt.Errorf("%w: at most %d", customerrors.ErrImportTooLarge, maxImportBatch)
+	}
+
+	report := entity.ImportReport{DryRun: dryRun, Rejected: []entity.ImportRejection{}}
+	valid := make([]entity.ImportUser, 0, len(users))
+	rows := make([]int, 0, len(users))
+	for i, user := range users {
+		user.Email = uc.emails.Normalize(user.Email)
+		if err := validateImportUser(user); err != nil {
+			report.Rejected = append(report.Rejected, entity.ImportRejection{Row: i + 1, Login: user.Username, Reason: err.Error()})
+			continue
+		}
+		if user.ID == uuid.Nil {
+			id, err := uc.userIDs.NewID()
+			if err != nil {
+				return entity.ImportReport{}, err
+			}
+			user.ID = id
+		}
+		user.CanonicalEmail = uc.emails.Canonical(user.Email)
+		valid = append(valid, user)
+		rows = append(rows, i+1)
+	}
+
+	conflicts, err := uc.importRepo.ImportUsers(ctx, valid, dryRun)
+	if err != nil {
+		return entity.ImportReport{}, err
+	}
+	for _, i := range conflicts {
+		report.Rejected = append(report.Rejected, entity.ImportRejection{
+			Row:    rows[i],
+			Login:  valid[i].Username,
+			Reason: "id, username or email is already used",
+		})
+	}
+	report.Imported = len(valid) - len(conflicts)
+
+	uc.logger.Info("Users imported", "imported", report.Imported, "rejected", len(report.Rejected), "dry_run", dryRun)
+	return report, nil
+}
+
+// validateImportUser applies the registration rules, except the password policy which cannot be checked on a hash.
+func validateImportUser(user entity.ImportUser) error {
+	if !validateUsername(user.Username) {
+		return customerrors.ErrInvalidUsername
+	}
+	if !validateEmail(user.Email) {
+		return customerrors.ErrInvalidEmail
+	}
+	alg, err := passhash.Identify(user.PasswordHash)
+	if err != nil {
+		return err
+	}
+	if user.HashAlgorithm != "" && passhash.Algorithm(user.HashAlgorithm) != alg {
+		return fmt.Errorf("hash_algorithm is %s but the hash is %s", user.HashAlgorithm, alg)
+	}
+	return nil
+}
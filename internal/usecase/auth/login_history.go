@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"context"
+	"main/domain/entity"
+	"net/netip"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// defaultHistoryPageSize and maxHistoryPageSize bound the pages of LoginHistory
+	defaultHistoryPageSize = 20
+	maxHistoryPageSize     = 100
+)
+
+// recordLogin adds the attempt to the login history of the user and to the audit log. Attempts on unknown
+// accounts and codes of unknown MFA challenges cannot be attributed and are not recorded in the history.
+// A failure to record is logged, it must not change the outcome of the login.
+func (uc *AuthUsecase) recordLogin(ctx context.Context, userID uuid.UUID, in entity.LoginInput, outcome entity.LoginOutcome) {
+	event := entity.LoginEvent{
+		ID:         uuid.New(),
+		UserID:     userID,
+		CreatedAt:  time.Now().UTC(),
+		ClientType: entity.ClientType(in.ClientType),
+		Outcome:    outcome,
+	}
+	if ct, err := clientType(in); err == nil {
+		event.ClientType = ct
+	}
+	if ip, err := netip.ParseAddr(in.IP); err == nil {
+		fp := uc.fingerprinter.Fingerprint(ip, in.UserAgent)
+		event.IP, event.UserAgent = fp.IP, fp.UserAgent
+		location := uc.locate(in.IP)
+		event.Country, event.City = location.Country, location.City
+	}
+	if err := uc.authRepo.StoreLoginEvent(ctx, event); err != nil {
+		uc.logger.Error("Failed to record login attempt", "user_id", userID, "outcome", outcome, "error", err)
+	}
+
+	// a login waiting for its second factor is recorded in the audit log once it completes or fails
+	switch outcome {
+	case entity.LoginMFARequired:
+	case entity.LoginSuccess:
+		uc.audit.Record(ctx, entity.AuditEvent{
+			Action:  entity.AuditLogin,
+			ActorID: &userID,
+			Details: map[string]string{"client_type": string(event.ClientType)},
+		})
+	case entity.LoginGeoBlocked:
+		uc.audit.Record(ctx, entity.AuditEvent{
+			Action:  entity.AuditLoginFailed,
+			ActorID: &userID,
+			Details: map[string]string{"outcome": string(outcome), "country": event.Country},
+		})
+	default:
+		uc.audit.Record(ctx, entity.AuditEvent{
+			Action:  entity.AuditLoginFailed,
+			ActorID: &userID,
+			Details: map[string]string{"outcome": string(outcome)},
+		})
+	}
+}
+
+// LoginHistory returns a page of the login attempts on the account of the user, newest first.
+// The limit defaults to 20 and is capped at 100.
+func (uc *AuthUsecase) LoginHistory(ctx context.Context, userID uuid.UUID, limit, offset int) (entity.LoginEventPage, error) {
+	if limit <= 0 {
+		limit = defaultHistoryPageSize
+	}
+	page, err := uc.authRepo.LoginHistory(ctx, userID, min(limit, maxHistoryPageSize), max(offset, 0))
+	if err != nil {
+		return entity.LoginEventPage{}, err
+	}
+	if page.Events == nil {
+		page.Events = []entity.LoginEvent{}
+	}
+	return page, nil
+}
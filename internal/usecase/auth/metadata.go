@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"main/pkg/customerrors"
+	"regexp"
+
+	"github.com/google/uuid"
+)
+
+// MetadataRepo defines the interface for the storage of user metadata.
+type MetadataRepo interface {
+	// UserMetadata returns the metadata object of the user.
+	UserMetadata(ctx context.Context, userID uuid.UUID) ([]byte, error)
+
+	// PatchUserMetadata sets and removes top-level keys of the metadata of the user within the limits.
+	PatchUserMetadata(ctx context.Context, userID uuid.UUID, set []byte, remove []string, maxBytes, maxKeys int) ([]byte, error)
+}
+
+// MetadataLimits bound the metadata stored per user.
+type MetadataLimits struct {
+	// MaxBytes is the maximum size of the metadata object serialized as JSON
+	MaxBytes int
+	// MaxKeys is the maximum number of top-level keys
+	MaxKeys int
+}
+
+// metadataKey allows identifier-like keys, so that consuming applications can namespace them with dots.
+var metadataKey = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.-]{0,63}$`)
+
+// MetadataUsecase stores app-specific attributes of users (display name, locale, preferences) as a JSON object,
+// the service does not interpret them.
+type MetadataUsecase struct {
+	repo   MetadataRepo
+	limits MetadataLimits
+}
+
+func NewMetadataUsecase(repo MetadataRepo, limits MetadataLimits) *MetadataUsecase {
+	return &MetadataUsecase{
+		repo:   repo,
+		limits: limits,
+	}
+}
+
+// Metadata returns the metadata of the user, an empty object if none was stored.
+func (uc *MetadataUsecase) Metadata(ctx context.Context, userID uuid.UUID) (map[string]json.RawMessage, error) {
+	raw, err := uc.repo.UserMetadata(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return decodeMetadata(raw)
+}
+
+// PatchMetadata applies a JSON merge patch (RFC 7396) to the top-level keys of the metadata: a null value
+// removes the key, any other value replaces it as a whole. It returns the metadata after the patch,
+// customerrors.ErrInvalidMetadata for invalid keys and customerrors.ErrMetadataTooLarge above the limits.
+func (uc *MetadataUsecase) PatchMetadata(ctx context.Context, userID uuid.UUID, patch map[string]json.RawMessage) (map[string]json.RawMessage, error) {
+	if len(patch) == 0 {
+		return nil, customerrors.ErrInvalidMetadata
+	}
+	set := make(map[string]json.RawMessage, len(patch))
+	remove := []string{}
+	for key, value := range patch {
+		if !metadataKey.MatchString(key) {
+			return nil, customerrors.ErrInvalidMetadata
+		}
+		if len(value) == 0 || string(value) == "null" {
+			remove = append(remove, key)
+			continue
+		}
+		if !json.Valid(value) {
+			return nil, customerrors.ErrInvalidMetadata
+		}
+		set[key] = value
+	}
+	encoded, err := json.Marshal(set)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := uc.repo.PatchUserMetadata(ctx, userID, encoded, remove, uc.limits.MaxBytes, uc.limits.MaxKeys)
+	if err != nil {
+		return nil, err
+	}
+	return decodeMetadata(raw)
+}
+
+func decodeMetadata(raw []byte) (map[string]json.RawMessage, error) {
+	metadata := map[string]json.RawMessage{}
+	if len(raw) == 0 {
+		return metadata, nil
+	}
+	if err := json.Unmarshal(raw, &metadata); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
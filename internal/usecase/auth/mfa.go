@@ -0,0 +1,276 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"main/domain/entity"
+	"main/pkg/customerrors"
+	"main/pkg/locale"
+	"main/pkg/utils"
+	"net/netip"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// MFARepo defines the interface for second factor settings and pending challenges.
+type MFARepo interface {
+	UserMFAMethod(ctx context.Context, userID uuid.UUID) (entity.MFAMethod, error)
+	SetUserMFAMethod(ctx context.Context, userID uuid.UUID, method entity.MFAMethod) error
+	CreateMFAChallenge(ctx context.Context, c entity.MFAChallenge, codeHash []byte) error
+
+	// ResendMFACode replaces the code of the challenge, returns customerrors.ErrResendThrottled when the last code
+	// was sent less than cooldown ago or maxSends codes were sent.
+	ResendMFACode(ctx context.Context, id uuid.UUID, codeHash []byte, expiresAt time.Time, cooldown time.Duration, maxSends int) (entity.MFAChallenge, error)
+
+	// ConsumeMFAChallenge checks the code and deletes the challenge on a match, returns customerrors.ErrInvalidOTP
+	// for wrong codes and unknown challenges and customerrors.ErrTooManyAttempts once maxAttempts are used up.
+	ConsumeMFAChallenge(ctx context.Context, id uuid.UUID, codeHash []byte, maxAttempts int) (uuid.UUID, error)
+
+	CreateTrustedDevice(ctx context.Context, d entity.TrustedDevice) error
+
+	// UseTrustedDevice returns pgx.ErrNoRows if the user has no such unexpired device.
+	UseTrustedDevice(ctx context.Context, userID, deviceID uuid.UUID) error
+
+	ListTrustedDevices(ctx context.Context, userID uuid.UUID) ([]entity.TrustedDevice, error)
+
+	// DeleteTrustedDevice returns customerrors.ErrNoTagsAffected if the user has no such device.
+	DeleteTrustedDevice(ctx context.Context, userID, deviceID uuid.UUID) error
+	DeleteTrustedDevices(ctx context.Context, userID uuid.UUID) (int64, error)
+}
+
+// SecondFactor starts and checks the second factor of password logins, implemented by MFAUsecase.
+type SecondFactor interface {
+	// Challenge sends a code by the default method of the user, or by the fallback method for users without
+	// a second factor. It returns nil if the user has no second factor and the fallback is empty.
+	Challenge(ctx context.Context, user entity.User, fallback entity.MFAMethod) (*entity.MFAChallenge, error)
+
+	// Verify checks the code of the challenge and returns the user it was sent to. The user is also returned
+	// with customerrors.ErrTooManyAttempts, the lockout is counted towards the repeated lockouts alert.
+	Verify(ctx context.Context, challengeID uuid.UUID, code string) (uuid.UUID, error)
+
+	// Trusted reports whether the cookie is the one of a trusted device of the user.
+	Trusted(ctx context.Context, userID uuid.UUID, cookie string) bool
+
+	// TrustDevice trusts the device of a login completed with the second factor, nil while trusted devices are disabled.
+	TrustDevice(ctx context.Context, userID uuid.UUID, userAgent string, ip netip.Addr) (*entity.DeviceTrust, error)
+}
+
+// DeviceSigner signs and checks the cookies of trusted devices, implemented by devicetrust.Signer.
+type DeviceSigner interface {
+	Sign(deviceID, userID uuid.UUID, expiresAt time.Time) string
+	Verify(cookie string) (deviceID, userID uuid.UUID, err error)
+}
+
+// MFAPolicy configures the one-time codes of the second factor.
+type MFAPolicy struct {
+	TTL         time.Duration
+	CodeLength  int
+	MaxAttempts int
+	// ResendCooldown is the minimum time between two codes of a challenge, MaxSends the number of codes it can send
+	ResendCooldown time.Duration
+	MaxSends       int
+	// TrustedDeviceTTL is how long a trusted device skips the second factor, zero disables trusted devices
+	TrustedDeviceTTL time.Duration
+}
+
+// MFAUsecase implements two-factor authentication of password logins with one-time codes.
+// Users choose their default method, email is the one for users without an authenticator app.
+type MFAUsecase struct {
+	mfaRepo  MFARepo
+	userRepo UserRepo
+	mailer   Mailer
+	logger   *slog.Logger
+	policy   MFAPolicy
+	// devices signs the cookies of trusted devices, nil while they are disabled
+	devices DeviceSigner
+}
+
+func NewMFAUsecase(mfaRepo MFARepo, userRepo UserRepo, mailer Mailer, logger *slog.Logger, policy MFAPolicy, devices DeviceSigner) *MFAUsecase {
+	return &MFAUsecase{
+		mfaRepo:  mfaRepo,
+		userRepo: userRepo,
+		mailer:   mailer,
+		logger:   logger,
+		policy:   policy,
+		devices:  devices,
+	}
+}
+
+// Method returns the default second factor of the user, empty when two-factor authentication is off.
+func (uc *MFAUsecase) Method(ctx context.Context, userID uuid.UUID) (entity.MFAMethod, error) {
+	return uc.mfaRepo.UserMFAMethod(ctx, userID)
+}
+
+// SetMethod changes the default second factor after checking the password, an empty method turns it off.
+// Email codes require a verified email address.
+func (uc *MFAUsecase) SetMethod(ctx context.Context, userID uuid.UUID, method entity.MFAMethod, password string) error {
+	if method != "" && !method.Valid() {
+		return customerrors.ErrInvalidMFAMethod
+	}
+	user, err := uc.userRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !verifyPassword(password, user.PasswordHash) {
+		return customerrors.ErrInvalidCredentials
+	}
+	if method == entity.MFAEmail && !user.EmailVerified {
+		return customerrors.ErrEmailNotVerified
+	}
+	if err := uc.mfaRepo.SetUserMFAMethod(ctx, userID, method); err != nil {
+		return err
+	}
+	uc.logger.Info("Two-factor method changed", "user_id", userID, "method", method)
+	return nil
+}
+
+// Challenge starts the second factor of a login whose password was verified. Users without a second factor
+// get the fallback method, risky logins use it to confirm the login by email.
+func (uc *MFAUsecase) Challenge(ctx context.Context, user entity.User, fallback entity.MFAMethod) (*entity.MFAChallenge, error) {
+	method, err := uc.mfaRepo.UserMFAMethod(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if method == "" {
+		method = fallback
+	}
+	if method == "" {
+		return nil, nil
+	}
+	code, err := utils.GenerateNumericCode(uc.policy.CodeLength)
+	if err != nil {
+		return nil, err
+	}
+	c := entity.MFAChallenge{
+		ID:          uuid.New(),
+		UserID:      user.ID,
+		Method:      method,
+		Destination: maskEmail(user.Email),
+		ExpiresAt:   time.Now().Add(uc.policy.TTL).UTC(),
+	}
+	if err := uc.mfaRepo.CreateMFAChallenge(ctx, c, mfaCodeHash(c.ID, code)); err != nil {
+		return nil, err
+	}
+	if err := uc.send(ctx, user, code, c.ExpiresAt); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Resend sends a new code for the challenge, throttled by the resend cooldown and the number of sends.
+// The new code replaces the previous one and the attempts start over.
+func (uc *MFAUsecase) Resend(ctx context.Context, challengeID uuid.UUID) (entity.MFAChallenge, error) {
+	code, err := utils.GenerateNumericCode(uc.policy.CodeLength)
+	if err != nil {
+		return entity.MFAChallenge{}, err
+	}
+	c, err := uc.mfaRepo.ResendMFACode(ctx, challengeID, mfaCodeHash(challengeID, code),
+		time.Now().Add(uc.policy.TTL).UTC(), uc.policy.ResendCooldown, uc.policy.MaxSends)
+	if err != nil {
+		return entity.MFAChallenge{}, err
+	}
+	user, err := uc.userRepo.GetUserByID(ctx, c.UserID)
+	if err != nil {
+		return entity.MFAChallenge{}, err
+	}
+	c.Destination = maskEmail(user.Email)
+	if err := uc.send(ctx, user, code, c.ExpiresAt); err != nil {
+		return entity.MFAChallenge{}, err
+	}
+	return c, nil
+}
+
+// Verify checks the code of the challenge and returns the user it belongs to, the challenge is used up on success.
+func (uc *MFAUsecase) Verify(ctx context.Context, challengeID uuid.UUID, code string) (uuid.UUID, error) {
+	if code == "" {
+		return uuid.Nil, customerrors.ErrInvalidOTP
+	}
+	return uc.mfaRepo.ConsumeMFAChallenge(ctx, challengeID, mfaCodeHash(challengeID, code), uc.policy.MaxAttempts)
+}
+
+// TrustDevice stores the device of a login completed with the second factor and returns its signed cookie.
+func (uc *MFAUsecase) TrustDevice(ctx context.Context, userID uuid.UUID, userAgent string, ip netip.Addr) (*entity.DeviceTrust, error) {
+	if uc.devices == nil || uc.policy.TrustedDeviceTTL <= 0 {
+		return nil, nil
+	}
+	now := time.Now().UTC()
+	d := entity.TrustedDevice{
+		ID:        uuid.New(),
+		UserID:    userID,
+		UserAgent: userAgent,
+		IP:        ip,
+		CreatedAt: now,
+		ExpiresAt: now.Add(uc.policy.TrustedDeviceTTL),
+	}
+	if err := uc.mfaRepo.CreateTrustedDevice(ctx, d); err != nil {
+		return nil, err
+	}
+	uc.logger.Info("Device trusted", "user_id", userID, "device_id", d.ID)
+	return &entity.DeviceTrust{Cookie: uc.devices.Sign(d.ID, userID, d.ExpiresAt), ExpiresAt: d.ExpiresAt}, nil
+}
+
+// Trusted reports whether the cookie was signed for a device of the user that was not revoked and has not expired.
+func (uc *MFAUsecase) Trusted(ctx context.Context, userID uuid.UUID, cookie string) bool {
+	if uc.devices == nil || cookie == "" {
+		return false
+	}
+	deviceID, cookieUserID, err := uc.devices.Verify(cookie)
+	if err != nil || cookieUserID != userID {
+		return false
+	}
+	err = uc.mfaRepo.UseTrustedDevice(ctx, userID, deviceID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		uc.logger.Error("Failed to check trusted device", "user_id", userID, "error", err)
+	}
+	return err == nil
+}
+
+// TrustedDevices returns the devices on which the user skips the second factor.
+func (uc *MFAUsecase) TrustedDevices(ctx context.Context, userID uuid.UUID) ([]entity.TrustedDevice, error) {
+	return uc.mfaRepo.ListTrustedDevices(ctx, userID)
+}
+
+// RevokeTrustedDevice makes the device ask for the second factor again.
+func (uc *MFAUsecase) RevokeTrustedDevice(ctx context.Context, userID, deviceID uuid.UUID) error {
+	if err := uc.mfaRepo.DeleteTrustedDevice(ctx, userID, deviceID); err != nil {
+		return err
+	}
+	uc.logger.Info("Trusted device revoked", "user_id", userID, "device_id", deviceID)
+	return nil
+}
+
+// RevokeTrustedDevices revokes every trusted device of the user and returns how many there were.
+func (uc *MFAUsecase) RevokeTrustedDevices(ctx context.Context, userID uuid.UUID) (int64, error) {
+	n, err := uc.mfaRepo.DeleteTrustedDevices(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	uc.logger.Info("Trusted devices revoked", "user_id", userID, "count", n)
+	return n, nil
+}
+
+// send delivers the code by email, the only method so far, in the language and time zone of the user.
+func (uc *MFAUsecase) send(ctx context.Context, user entity.User, code string, expiresAt time.Time) error {
+	body := locale.T(user.Locale, "mfa_code.body", code, locale.FormatTime(expiresAt, user.Locale, user.Timezone))
+	return uc.mailer.Send(ctx, user.Email, locale.T(user.Locale, "mfa_code.subject"), body)
+}
+
+// mfaCodeHash binds the code to its challenge.
+func mfaCodeHash(challengeID uuid.UUID, code string) []byte {
+	return utils.HashToken(challengeID.String() + ":" + code)
+}
+
+// maskEmail hides the local part of the address but its first character, j***@example.com.
+func maskEmail(email string) string {
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok || local == "" {
+		return "***"
+	}
+	first, _ := utf8.DecodeRuneInString(local)
+	return string(first) + "***@" + domain
+}
@@ -0,0 +1,245 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"main/domain/entity"
+	"main/pkg/customerrors"
+	"main/pkg/webauthn"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// maxPasskeyName is the length limit of passkey names, in characters.
+const maxPasskeyName = 64
+
+// PasskeyRepo defines the interface for WebAuthn credential and ceremony storage.
+type PasskeyRepo interface {
+	StoreChallenge(ctx context.Context, c entity.PasskeyChallenge) error
+
+	// ConsumeChallenge deletes the pending ceremony and returns it, pgx.ErrNoRows if it is unknown or expired.
+	ConsumeChallenge(ctx context.Context, id uuid.UUID, ceremony string) (entity.PasskeyChallenge, error)
+
+	// CreatePasskey returns customerrors.ErrPasskeyExists when the credential ID is already registered.
+	CreatePasskey(ctx context.Context, p entity.Passkey) error
+
+	GetPasskey(ctx context.Context, credentialID []byte) (entity.Passkey, error)
+	ListPasskeys(ctx context.Context, userID uuid.UUID) ([]entity.Passkey, error)
+	UsePasskey(ctx context.Context, credentialID []byte, signCount uint32, backupState bool) error
+
+	// DeletePasskey returns customerrors.ErrNoTagsAffected if the user has no such credential.
+	DeletePasskey(ctx context.Context, userID uuid.UUID, credentialID []byte) error
+}
+
+// PasskeyUsecase implements passkeys: users register WebAuthn credentials while signed in and can then
+// sign in with one of them instead of a password. Every ceremony has a single-use challenge stored server-side.
+type PasskeyUsecase struct {
+	passkeyRepo PasskeyRepo
+	userRepo    UserRepo
+	sessions    SessionStarter
+	rp          *webauthn.RelyingParty
+	logger      *slog.Logger
+	maxPasskeys int
+	// features rolls the registration of passkeys out to a part of the users
+	features FeatureRollout
+}
+
+func NewPasskeyUsecase(
+	passkeyRepo PasskeyRepo,
+	userRepo UserRepo,
+	sessions SessionStarter,
+	rp *webauthn.RelyingParty,
+	logger *slog.Logger,
+	maxPasskeys int,
+	features FeatureRollout) *PasskeyUsecase {
+	return &PasskeyUsecase{
+		passkeyRepo: passkeyRepo,
+		userRepo:    userRepo,
+		sessions:    sessions,
+		rp:          rp,
+		logger:      logger,
+		maxPasskeys: maxPasskeys,
+		features:    features,
+	}
+}
+
+// BeginRegistration starts adding a passkey to the account and returns the ceremony ID with the options
+// for navigator.credentials.create(). The user handle of the credential is the user ID. It returns
+// customerrors.ErrFeatureNotEnabled while passkeys are not rolled out to the user.
+func (uc *PasskeyUsecase) BeginRegistration(ctx context.Context, userID uuid.UUID) (uuid.UUID, webauthn.CreationOptions, error) {
+	if !uc.features.Enabled(ctx, entity.FeaturePasskeys, userID) {
+		return uuid.Nil, webauthn.CreationOptions{}, customerrors.ErrFeatureNotEnabled
+	}
+	user, err := uc.userRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return uuid.Nil, webauthn.CreationOptions{}, err
+	}
+	passkeys, err := uc.passkeyRepo.ListPasskeys(ctx, userID)
+	if err != nil {
+		return uuid.Nil, webauthn.CreationOptions{}, err
+	}
+	if len(passkeys) >= uc.maxPasskeys {
+		return uuid.Nil, webauthn.CreationOptions{}, customerrors.ErrTooManyPasskeys
+	}
+
+	ceremonyID, challenge, err := uc.startCeremony(ctx, entity.CeremonyRegistration, userID)
+	if err != nil {
+		return uuid.Nil, webauthn.CreationOptions{}, err
+	}
+	// the authenticator refuses to create a second credential for the same account
+	exclude := make([]webauthn.CredentialDescriptor, 0, len(passkeys))
+	for _, p := range passkeys {
+		exclude = append(exclude, descriptor(p))
+	}
+	options := uc.rp.CreationOptions(challenge, webauthn.User{
+		ID:          userID[:],
+		Name:        user.Email,
+		DisplayName: user.Username,
+	}, exclude)
+	return ceremonyID, options, nil
+}
+
+// FinishRegistration verifies the response of the authenticator and stores the new passkey under the given name.
+func (uc *PasskeyUsecase) FinishRegistration(ctx context.Context, userID, ceremonyID uuid.UUID, name string, resp webauthn.AttestationResponse) (entity.Passkey, error) {
+	if utf8.RuneCountInString(name) > maxPasskeyName {
+		return entity.Passkey{}, customerrors.ErrInvalidPasskeyName
+	}
+	c, err := uc.consumeCeremony(ctx, ceremonyID, entity.CeremonyRegistration)
+	if err != nil {
+		return entity.Passkey{}, err
+	}
+	if c.UserID != userID {
+		return entity.Passkey{}, customerrors.ErrInvalidPasskey
+	}
+
+	credential, err := uc.rp.VerifyRegistration(c.Challenge, resp)
+	if err != nil {
+		uc.logger.Info("Passkey registration rejected", "user_id", userID, "error", err)
+		return entity.Passkey{}, customerrors.ErrInvalidPasskey
+	}
+	if name == "" {
+		name = "Passkey"
+	}
+	passkey := entity.Passkey{
+		CredentialID:   credential.ID,
+		UserID:         userID,
+		Name:           name,
+		PublicKey:      credential.PublicKey,
+		SignCount:      credential.SignCount,
+		AAGUID:         credential.AAGUID,
+		Transports:     credential.Transports,
+		BackupEligible: credential.BackupEligible,
+		BackupState:    credential.BackupState,
+		CreatedAt:      time.Now().UTC(),
+	}
+	if passkey.Transports == nil {
+		passkey.Transports = []string{}
+	}
+	if err := uc.passkeyRepo.CreatePasskey(ctx, passkey); err != nil {
+		return entity.Passkey{}, err
+	}
+	uc.logger.Info("Passkey registered", "user_id", userID, "aaguid", passkey.AAGUID, "attestation", credential.AttestationFormat)
+	return passkey, nil
+}
+
+// BeginLogin starts a passkey sign-in and returns the ceremony ID with the options for navigator.credentials.get().
+// No username is needed, the user picks one of their discoverable credentials.
+func (uc *PasskeyUsecase) BeginLogin(ctx context.Context) (uuid.UUID, webauthn.RequestOptions, error) {
+	ceremonyID, challenge, err := uc.startCeremony(ctx, entity.CeremonyAuthentication, uuid.Nil)
+	if err != nil {
+		return uuid.Nil, webauthn.RequestOptions{}, err
+	}
+	return ceremonyID, uc.rp.RequestOptions(challenge, nil), nil
+}
+
+// FinishLogin verifies the assertion of the credential and starts a session like a password login.
+func (uc *PasskeyUsecase) FinishLogin(ctx context.Context, ceremonyID uuid.UUID, credentialID []byte, resp webauthn.AssertionResponse, in entity.LoginInput) (entity.IssuedTokens, error) {
+	c, err := uc.consumeCeremony(ctx, ceremonyID, entity.CeremonyAuthentication)
+	if err != nil {
+		return entity.IssuedTokens{}, err
+	}
+	passkey, err := uc.passkeyRepo.GetPasskey(ctx, credentialID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return entity.IssuedTokens{}, customerrors.ErrInvalidPasskey
+	}
+	if err != nil {
+		return entity.IssuedTokens{}, err
+	}
+	if len(resp.UserHandle) > 0 && !bytes.Equal(resp.UserHandle, passkey.UserID[:]) {
+		return entity.IssuedTokens{}, customerrors.ErrInvalidPasskey
+	}
+
+	assertion, err := uc.rp.VerifyAssertion(c.Challenge, resp, passkey.PublicKey, passkey.SignCount)
+	if errors.Is(err, webauthn.ErrSignCount) {
+		uc.logger.Warn("Passkey signature counter did not increase, the authenticator may be cloned",
+			"user_id", passkey.UserID, "stored_count", passkey.SignCount)
+		return entity.IssuedTokens{}, customerrors.ErrInvalidPasskey
+	}
+	if err != nil {
+		uc.logger.Info("Passkey login rejected", "user_id", passkey.UserID, "error", err)
+		return entity.IssuedTokens{}, customerrors.ErrInvalidPasskey
+	}
+	if err := uc.passkeyRepo.UsePasskey(ctx, credentialID, assertion.SignCount, assertion.BackupState); err != nil {
+		return entity.IssuedTokens{}, err
+	}
+
+	user, err := uc.userRepo.GetUserByID(ctx, passkey.UserID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return entity.IssuedTokens{}, customerrors.ErrInvalidPasskey
+		}
+		return entity.IssuedTokens{}, err
+	}
+	return uc.sessions.StartSession(ctx, user, in)
+}
+
+// Passkeys returns the passkeys of the user.
+func (uc *PasskeyUsecase) Passkeys(ctx context.Context, userID uuid.UUID) ([]entity.Passkey, error) {
+	return uc.passkeyRepo.ListPasskeys(ctx, userID)
+}
+
+// DeletePasskey removes a passkey of the user, it can no longer sign in.
+func (uc *PasskeyUsecase) DeletePasskey(ctx context.Context, userID uuid.UUID, credentialID []byte) error {
+	if err := uc.passkeyRepo.DeletePasskey(ctx, userID, credentialID); err != nil {
+		return err
+	}
+	uc.logger.Info("Passkey deleted", "user_id", userID)
+	return nil
+}
+
+// startCeremony stores a new challenge for the ceremony, it expires with the timeout of the options.
+func (uc *PasskeyUsecase) startCeremony(ctx context.Context, ceremony string, userID uuid.UUID) (uuid.UUID, []byte, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+	c := entity.PasskeyChallenge{
+		ID:        uuid.New(),
+		Ceremony:  ceremony,
+		UserID:    userID,
+		Challenge: challenge,
+		ExpiresAt: time.Now().Add(uc.rp.Timeout),
+	}
+	if err := uc.passkeyRepo.StoreChallenge(ctx, c); err != nil {
+		return uuid.Nil, nil, err
+	}
+	return c.ID, challenge, nil
+}
+
+// consumeCeremony uses up the challenge of the ceremony, unknown and expired ceremonies are invalid.
+func (uc *PasskeyUsecase) consumeCeremony(ctx context.Context, id uuid.UUID, ceremony string) (entity.PasskeyChallenge, error) {
+	c, err := uc.passkeyRepo.ConsumeChallenge(ctx, id, ceremony)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return entity.PasskeyChallenge{}, customerrors.ErrInvalidPasskey
+	}
+	return c, err
+}
+
+func descriptor(p entity.Passkey) webauthn.CredentialDescriptor {
+	return webauthn.CredentialDescriptor{Type: "public-key", ID: p.CredentialID, Transports: p.Transports}
+}
@@ -0,0 +1,265 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"main/domain/entity"
+	"main/pkg/customerrors"
+	"main/pkg/emailnorm"
+	"main/pkg/locale"
+	"main/pkg/utils"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// PasswordRepo defines the interface for password reset storage.
+type PasswordRepo interface {
+	// StorePasswordReset saves the hash of a password reset token issued for the user.
+	StorePasswordReset(ctx context.Context, tokenHash []byte, userID uuid.UUID, expiresAt time.Time) error
+
+	// ResetPassword consumes the token, updates the password hash and revokes all sessions of the user.
+	ResetPassword(ctx context.Context, tokenHash []byte, passwordHash string) (uuid.UUID, error)
+
+	// ChangePassword updates the password hash and revokes all sessions of the user except keepSessionID.
+	ChangePassword(ctx context.Context, userID uuid.UUID, passwordHash string, keepSessionID uuid.UUID) error
+
+	// InvalidatePassword clears the password hash and revokes all sessions of the user.
+	InvalidatePassword(ctx context.Context, userID uuid.UUID) error
+}
+
+// UserRepo defines the user lookups needed by the password flows.
+type UserRepo interface {
+	GetUserByEmail(ctx context.Context, canonicalEmail string) (entity.User, error)
+	GetUserByID(ctx context.Context, userID uuid.UUID) (entity.User, error)
+}
+
+// TokenInvalidator publishes the token states of users whose access tokens were revoked in the database,
+// implemented by TokenVersions.
+type TokenInvalidator interface {
+	Invalidated(ctx context.Context, userIDs ...uuid.UUID) error
+}
+
+// Mailer sends emails to users.
+type Mailer interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+// PasswordUsecase implements the password recovery flows. It lives next to AuthUsecase
+// to share the password policy and hashing.
+type PasswordUsecase struct {
+	passwordRepo PasswordRepo
+	userRepo     UserRepo
+	mailer       Mailer
+	logger       *slog.Logger
+	resetTTL     time.Duration
+	resetURL     string
+	hasher       PasswordHasher
+	breachCheck  BreachCheck
+	emails       emailnorm.Normalizer
+	enumeration  *EnumerationProtection
+	// sessions logs users in when they complete a reset, nil sends them back to the login
+	sessions SessionStarter
+	// audit records password changes and completed resets in the audit log
+	audit Auditor
+	// tokens publishes password changes, access tokens minted before them are revoked
+	tokens TokenInvalidator
+}
+
+func NewPasswordUsecase(
+	passwordRepo PasswordRepo,
+	userRepo UserRepo,
+	mailer Mailer,
+	logger *slog.Logger,
+	resetTTL time.Duration,
+	resetURL string,
+	hasher PasswordHasher,
+	breachCheck BreachCheck,
+	emails emailnorm.Normalizer,
+	enumeration *EnumerationProtection,
+	sessions SessionStarter,
+	audit Auditor,
+	tokens TokenInvalidator) *PasswordUsecase {
+	return &PasswordUsecase{
+		passwordRepo: passwordRepo,
+		userRepo:     userRepo,
+		mailer:       mailer,
+		logger:       logger,
+		resetTTL:     resetTTL,
+		resetURL:     resetURL,
+		hasher:       hasher,
+		breachCheck:  breachCheck,
+		emails:       emails,
+		enumeration:  enumeration,
+		sessions:     sessions,
+		audit:        audit,
+		tokens:       tokens,
+	}
+}
+
+// ForgotPassword emails a single-use reset link if an account with this email exists.
+// It never reports whether the account exists, failures are only logged. With enumeration protection
+// the lookup and the email happen after the response.
+func (uc *PasswordUsecase) ForgotPassword(ctx context.Context, email string) {
+	uc.enumeration.run(ctx, func(ctx context.Context) {
+		uc.forgotPassword(ctx, email)
+	})
+}
+
+func (uc *PasswordUsecase) forgotPassword(ctx context.Context, email string) {
+	user, err := uc.userRepo.GetUserByEmail(ctx, uc.emails.Canonical(email))
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			uc.logger.Error("Failed to lookup user for password reset", "error", err)
+		}
+		return
+	}
+	if err := uc.sendResetLink(ctx, user, "reset_password.requested", "reset_password.ignore"); err != nil {
+		uc.logger.Error("Failed to send password reset link", "error", err)
+	}
+}
+
+// ForceReset is the password reset started by an administrator: the current password stops working,
+// every session is revoked and the user gets a reset link to choose a new password.
+func (uc *PasswordUsecase) ForceReset(ctx context.Context, userID uuid.UUID) error {
+	user, err := uc.userRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return customerrors.ErrUserNotFound
+		}
+		return err
+	}
+	err = uc.passwordRepo.InvalidatePassword(ctx, userID)
+	if errors.Is(err, customerrors.ErrNoTagsAffected) {
+		return customerrors.ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return uc.sendResetLink(ctx, user, "reset_password.forced", "reset_password.logout")
+}
+
+// sendResetLink stores a new reset token of the user and emails the link, the messages with the keys intro and
+// note frame it.
+func (uc *PasswordUsecase) sendResetLink(ctx context.Context, user entity.User, intro, note string) error {
+	token, err := utils.GenerateToken(32)
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(uc.resetTTL)
+	err = uc.passwordRepo.StorePasswordReset(ctx, utils.HashToken(token), user.ID, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	link := uc.resetURL + "?token=" + url.QueryEscape(token)
+	lang := user.Locale
+	body := locale.T(lang, "reset_password.body", locale.T(lang, intro), link,
+		locale.FormatTime(expiresAt, lang, user.Timezone), locale.T(lang, note))
+	return uc.mailer.Send(ctx, user.Email, locale.T(lang, "reset_password.subject"), body)
+}
+
+// ResetPassword validates the reset token, sets the new password and revokes all existing sessions and access tokens
+// of the user.
+// It returns the user ID and warnings about the password (see BreachCheck). When the client asks for a session
+// and sessions after resets are enabled, the user is logged in with client as the login of the new session.
+func (uc *PasswordUsecase) ResetPassword(ctx context.Context, token, newPassword string, client *entity.LoginInput) (entity.PasswordResetResult, error) {
+	if token == "" {
+		return entity.PasswordResetResult{}, customerrors.ErrInvalidToken
+	}
+	if err := validatePassword(newPassword); err != nil {
+		return entity.PasswordResetResult{}, fmt.Errorf("%w: %v", customerrors.ErrPasswordPolicy, err)
+	}
+	warnings, err := uc.breachCheck.check(ctx, uc.logger, newPassword)
+	if err != nil {
+		return entity.PasswordResetResult{}, err
+	}
+	passwordHash, err := uc.hasher.Hash(newPassword)
+	if err != nil {
+		return entity.PasswordResetResult{}, err
+	}
+
+	userID, err := uc.passwordRepo.ResetPassword(ctx, utils.HashToken(token), passwordHash)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) || errors.Is(err, customerrors.ErrNoTagsAffected) {
+			return entity.PasswordResetResult{}, customerrors.ErrInvalidToken
+		}
+		return entity.PasswordResetResult{}, err
+	}
+	uc.passwordChanged(ctx, userID)
+	uc.audit.Record(ctx, entity.AuditEvent{Action: entity.AuditPasswordReset, ActorID: &userID})
+	result := entity.PasswordResetResult{UserID: userID, Warnings: warnings}
+	if client != nil && uc.sessions != nil {
+		result.Tokens = uc.sessionAfterReset(ctx, userID, *client)
+	}
+	return result, nil
+}
+
+// sessionAfterReset logs the user in after a completed reset. The reset link proved control of the email address,
+// which is also where the codes of the second factor go, so no code is asked for. A session that cannot be started
+// (blocked user, terms to accept, app attestation required, blocked country) leaves the reset done and the client logs in as usual.
+func (uc *PasswordUsecase) sessionAfterReset(ctx context.Context, userID uuid.UUID, client entity.LoginInput) *entity.IssuedTokens {
+	user, err := uc.userRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		uc.logger.Error("Failed to load user for session after password reset", "user_id", userID, "error", err)
+		return nil
+	}
+	tokens, err := uc.sessions.StartSession(ctx, user, client)
+	if errors.Is(err, customerrors.ErrUserBlocked) || errors.Is(err, customerrors.ErrTermsNotAccepted) ||
+		errors.Is(err, customerrors.ErrAttestationFailed) || errors.Is(err, customerrors.ErrLoginLocationBlocked) {
+		uc.logger.Info("No session after password reset", "user_id", userID, "reason", err)
+		return nil
+	}
+	if err != nil {
+		uc.logger.Error("Failed to start session after password reset", "user_id", userID, "error", err)
+		return nil
+	}
+	return &tokens
+}
+
+// ChangePassword verifies the current password, enforces the password policy, sets the new password
+// and revokes every other session of the user. The session the request was made from stays valid, but like all
+// access tokens minted before the change its access token is revoked and the client refreshes the session.
+// It returns warnings about the new password (see BreachCheck).
+func (uc *PasswordUsecase) ChangePassword(ctx context.Context, userID, sessionID uuid.UUID, currentPassword, newPassword string) ([]string, error) {
+	user, err := uc.userRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !verifyPassword(currentPassword, user.PasswordHash) {
+		return nil, customerrors.ErrInvalidCredentials
+	}
+	if currentPassword == newPassword {
+		return nil, fmt.Errorf("%w: new password must differ from the current one", customerrors.ErrPasswordPolicy)
+	}
+	if err := validatePassword(newPassword); err != nil {
+		return nil, fmt.Errorf("%w: %v", customerrors.ErrPasswordPolicy, err)
+	}
+	warnings, err := uc.breachCheck.check(ctx, uc.logger, newPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	passwordHash, err := uc.hasher.Hash(newPassword)
+	if err != nil {
+		return nil, err
+	}
+	if err := uc.passwordRepo.ChangePassword(ctx, userID, passwordHash, sessionID); err != nil {
+		return nil, err
+	}
+	uc.passwordChanged(ctx, userID)
+	uc.audit.Record(ctx, entity.AuditEvent{Action: entity.AuditPasswordChange, ActorID: &userID})
+	return warnings, nil
+}
+
+// passwordChanged publishes the new password timestamp of the user to the token state cache. A failed update only
+// delays the revocation of the older access tokens until the cached state expires.
+func (uc *PasswordUsecase) passwordChanged(ctx context.Context, userID uuid.UUID) {
+	if err := uc.tokens.Invalidated(ctx, userID); err != nil {
+		uc.logger.Error("Failed to publish password change", "user_id", userID, "error", err)
+	}
+}
@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"main/domain/entity"
+	"main/pkg/customerrors"
+	"main/pkg/utils"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// PhoneRepo defines the interface for phone login code storage.
+type PhoneRepo interface {
+	// StorePhoneOTP saves the hash of a login code, replacing a previous one of the phone. It returns false
+	// without storing when the previous code was issued less than cooldown ago.
+	StorePhoneOTP(ctx context.Context, phone string, codeHash []byte, expiresAt time.Time, cooldown time.Duration) (bool, error)
+
+	// ConsumePhoneOTP checks the code and deletes it on a match, returns customerrors.ErrInvalidOTP for
+	// wrong or expired codes and customerrors.ErrTooManyAttempts once maxAttempts are used up.
+	ConsumePhoneOTP(ctx context.Context, phone string, codeHash []byte, maxAttempts int) error
+
+	GetUserByPhone(ctx context.Context, phone string) (entity.User, error)
+	MarkPhoneVerified(ctx context.Context, userID uuid.UUID) error
+}
+
+// SMSSender sends text messages to phone numbers in E.164 format.
+type SMSSender interface {
+	Send(ctx context.Context, to, body string) error
+}
+
+// SessionStarter creates the session of an authenticated user, implemented by AuthUsecase.
+type SessionStarter interface {
+	StartSession(ctx context.Context, user entity.User, in entity.LoginInput) (entity.IssuedTokens, error)
+}
+
+// PhoneOTPPolicy configures the one-time codes sent by SMS.
+type PhoneOTPPolicy struct {
+	TTL            time.Duration
+	CodeLength     int
+	MaxAttempts    int
+	ResendCooldown time.Duration
+}
+
+// PhoneUsecase implements login with a one-time code sent by SMS to the phone number of the account,
+// an alternative to the password login.
+type PhoneUsecase struct {
+	phoneRepo PhoneRepo
+	sessions  SessionStarter
+	sms       SMSSender
+	logger    *slog.Logger
+	policy    PhoneOTPPolicy
+}
+
+func NewPhoneUsecase(
+	phoneRepo PhoneRepo,
+	sessions SessionStarter,
+	sms SMSSender,
+	logger *slog.Logger,
+	policy PhoneOTPPolicy) *PhoneUsecase {
+	return &PhoneUsecase{
+		phoneRepo: phoneRepo,
+		sessions:  sessions,
+		sms:       sms,
+		logger:    logger,
+		policy:    policy,
+	}
+}
+
+// RequestLoginCode sends a login code to the phone if an account with this number exists.
+// It never reports whether the account exists, only malformed numbers are rejected.
+func (uc *PhoneUsecase) RequestLoginCode(ctx context.Context, phone string) error {
+	phone, ok := utils.NormalizePhone(phone)
+	if !ok {
+		return customerrors.ErrInvalidPhone
+	}
+
+	if _, err := uc.phoneRepo.GetUserByPhone(ctx, phone); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			uc.logger.Error("Failed to look up user for phone login", "error", err)
+		}
+		return nil
+	}
+
+	code, err := utils.GenerateNumericCode(uc.policy.CodeLength)
+	if err != nil {
+		uc.logger.Error("Failed to generate phone login code", "error", err)
+		return nil
+	}
+	stored, err := uc.phoneRepo.StorePhoneOTP(ctx, phone, phoneCodeHash(phone, code),
+		time.Now().Add(uc.policy.TTL), uc.policy.ResendCooldown)
+	if err != nil {
+		uc.logger.Error("Failed to store phone login code", "error", err)
+		return nil
+	}
+	if !stored {
+		// the previous code is still fresh, resending is throttled
+		return nil
+	}
+
+	body := "Your login code is " + code + ". It expires in " + uc.policy.TTL.String() + "."
+	if err := uc.sms.Send(ctx, phone, body); err != nil {
+		uc.logger.Error("Failed to send phone login code", "error", err)
+	}
+	return nil
+}
+
+// LoginWithCode authenticates the owner of the phone with the code sent by RequestLoginCode and starts a session
+// like a password login. A successful login also marks the phone number as verified.
+func (uc *PhoneUsecase) LoginWithCode(ctx context.Context, in entity.PhoneLoginInput) (entity.IssuedTokens, error) {
+	phone, ok := utils.NormalizePhone(in.Phone)
+	if !ok || in.Code == "" {
+		return entity.IssuedTokens{}, customerrors.ErrInvalidOTP
+	}
+
+	if err := uc.phoneRepo.ConsumePhoneOTP(ctx, phone, phoneCodeHash(phone, in.Code), uc.policy.MaxAttempts); err != nil {
+		return entity.IssuedTokens{}, err
+	}
+
+	user, err := uc.phoneRepo.GetUserByPhone(ctx, phone)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return entity.IssuedTokens{}, customerrors.ErrInvalidOTP
+		}
+		return entity.IssuedTokens{}, err
+	}
+	if err := uc.phoneRepo.MarkPhoneVerified(ctx, user.ID); err != nil {
+		uc.logger.Error("Failed to mark phone as verified", "error", err)
+	}
+
+	return uc.sessions.StartSession(ctx, user, in.Client)
+}
+
+// phoneCodeHash binds the code to the phone it was sent to.
+func phoneCodeHash(phone, code string) []byte {
+	return utils.HashToken(phone + ":" + code)
+}
@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"main/domain/entity"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RoleLister returns the roles granted to a user, implemented by rbac.RBACUsecase.
+type RoleLister interface {
+	UserRoles(ctx context.Context, userID uuid.UUID) ([]string, error)
+}
+
+// Reasons of a session rotation, recorded in the audit log.
+const (
+	// RotationRolesChanged is a refresh after the roles of the user changed
+	RotationRolesChanged = "roles_changed"
+	// RotationElevated is the elevation of the session after a step-up authentication
+	RotationElevated = "elevated"
+)
+
+// privileges returns the roles the user holds and their fingerprint, stored with its sessions to notice role
+// changes. Both are empty without a role lister.
+func (uc *AuthUsecase) privileges(ctx context.Context, userID uuid.UUID) (fingerprint string, roles []string, err error) {
+	if uc.roles == nil {
+		return "", nil, nil
+	}
+	roles, err = uc.roles.UserRoles(ctx, userID)
+	if err != nil {
+		return "", nil, err
+	}
+	sorted := slices.Clone(roles)
+	slices.Sort(sorted)
+	sum := sha256.Sum256([]byte(strings.Join(sorted, "\n")))
+	return hex.EncodeToString(sum[:]), roles, nil
+}
+
+// rotateSession moves the session to a new ID and refresh token, so that neither an ID nor a refresh token
+// known before the user crossed a privilege boundary leads to the session afterwards. The previous refresh token
+// stops working at once, access tokens carrying the previous ID expire as usual.
+func (uc *AuthUsecase) rotateSession(ctx context.Context, session *entity.Session, reason string) error {
+	refreshToken, err := uuid.NewUUID()
+	if err != nil {
+		return err
+	}
+	previousID := session.ID
+	session.ID = uuid.New()
+	session.RefreshToken = refreshToken
+	session.CreatedAt = time.Now()
+	if err := uc.sessions.RotateSession(ctx, previousID, *session); err != nil {
+		return err
+	}
+
+	uc.logger.Info("Session rotated", "user_id", session.UserID, "reason", reason)
+	uc.audit.Record(ctx, entity.AuditEvent{
+		Action:  entity.AuditSessionRotated,
+		ActorID: &session.UserID,
+		Details: map[string]string{
+			"previous_session_id": previousID.String(),
+			"session_id":          session.ID.String(),
+			"reason":              reason,
+		},
+	})
+	return nil
+}
@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"main/domain/entity"
+	"main/pkg/receipt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ReceiptSigner signs issuance receipts, see receipt.Signer.
+type ReceiptSigner interface {
+	Sign(r entity.IssuanceReceipt) (string, error)
+}
+
+// issueReceipt signs and stores the receipt of the issued tokens and sets it on them. Tokens are not handed
+// out without their receipt, a failure fails the login or refresh.
+func (uc *AuthUsecase) issueReceipt(ctx context.Context, tokens *entity.IssuedTokens) error {
+	if uc.receiptSigner == nil {
+		return nil
+	}
+
+	r := entity.IssuanceReceipt{
+		ID:               uuid.New(),
+		UserID:           tokens.UserID,
+		SessionID:        tokens.SessionID,
+		AccessTokenHash:  receipt.HashToken(tokens.AccessToken),
+		RefreshTokenHash: receipt.HashToken(tokens.RefreshToken),
+		IssuedAt:         time.Now().UTC().Truncate(time.Second),
+	}
+	signed, err := uc.receiptSigner.Sign(r)
+	if err != nil {
+		return fmt.Errorf("failed to sign issuance receipt: %w", err)
+	}
+	r.Signature = signed
+	if err := uc.authRepo.StoreReceipt(ctx, r); err != nil {
+		return fmt.Errorf("failed to store issuance receipt: %w", err)
+	}
+	tokens.Receipt = signed
+	return nil
+}
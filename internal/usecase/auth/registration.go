@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"main/pkg/customerrors"
+	"strings"
+)
+
+// DomainBlocklist recognizes email domains that may not register, such as throwaway providers.
+type DomainBlocklist interface {
+	Blocked(domain string) bool
+}
+
+// RegistrationPolicy decides who may create an account through RegisterUser.
+type RegistrationPolicy struct {
+	// Closed rejects every registration, accounts can only be imported or created by administrators
+	Closed bool
+	// InviteOnly requires a valid invite code (see InviteUsecase) for every registration
+	InviteOnly bool
+	// AllowedDomains restricts registration to emails of these domains, any domain when empty
+	AllowedDomains []string
+	// Blocklist rejects emails of the listed domains, nil disables it
+	Blocklist DomainBlocklist
+}
+
+// check rejects the registration of email if it is not allowed by the policy, reason labels the rejection
+// in the metrics. The invite code itself is verified when the user is created.
+func (p RegistrationPolicy) check(email, inviteCode string) (reason string, err error) {
+	domain := emailDomain(email)
+	if p.Closed {
+		return "closed", customerrors.ErrRegistrationClosed
+	}
+	if len(p.AllowedDomains) > 0 && !p.domainAllowed(domain) {
+		return "domain_not_allowed", customerrors.ErrEmailDomainNotAllowed
+	}
+	if p.Blocklist != nil && p.Blocklist.Blocked(domain) {
+		return "disposable_email", customerrors.ErrDisposableEmail
+	}
+	if p.InviteOnly && inviteCode == "" {
+		return "invite_missing", customerrors.ErrInvalidInvite
+	}
+	return "", nil
+}
+
+func (p RegistrationPolicy) domainAllowed(domain string) bool {
+	for _, allowed := range p.AllowedDomains {
+		if strings.EqualFold(domain, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// emailDomain returns the part of the email after the last @, empty if there is none.
+func emailDomain(email string) string {
+	at := strings.LastIndexByte(email, '@')
+	if at < 0 {
+		return ""
+	}
+	return email[at+1:]
+}
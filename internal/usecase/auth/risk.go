@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"context"
+	"main/domain/entity"
+	"main/pkg/fingerprint"
+	"net/netip"
+	"slices"
+)
+
+// RiskStepUp is the extra check of risky password logins.
+type RiskStepUp string
+
+const (
+	// RiskStepUpNone only records the assessment with the session
+	RiskStepUpNone RiskStepUp = "none"
+	// RiskStepUpMFA requires the second factor even on trusted devices, users without one are not challenged
+	RiskStepUpMFA RiskStepUp = "mfa"
+	// RiskStepUpEmail is like RiskStepUpMFA and users without a second factor confirm the login with an email code
+	RiskStepUpEmail RiskStepUp = "email"
+)
+
+// Valid reports whether the step up is one of the known ones.
+func (s RiskStepUp) Valid() bool {
+	return s == RiskStepUpNone || s == RiskStepUpMFA || s == RiskStepUpEmail
+}
+
+// riskWeights are the scores of the factors, a login scoring 1-2 is medium risk and 3 or more high risk.
+var riskWeights = map[string]int{
+	entity.RiskNewDevice:  1,
+	entity.RiskNewNetwork: 1,
+	entity.RiskNewCountry: 2,
+	entity.RiskUnattested: 2,
+}
+
+// RiskPolicy configures the comparison of logins with the previous sessions of the user.
+type RiskPolicy struct {
+	Enabled bool
+	// History is the number of recent sessions a login is compared with
+	History int
+	// StepUp applies to password logins of StepUpLevel or above
+	StepUp      RiskStepUp
+	StepUpLevel entity.RiskLevel
+}
+
+// assessRisk compares the client of a login with the recent sessions of the user. A factor is only raised when
+// the history has something to compare with: the first login of a user, or the first one after the country
+// database was added, is low risk. Failures to read the history are logged and leave the login unassessed.
+// Apps of client types checked for app attestation that presented no valid one are risky regardless of the history.
+// location is where the login IP is, see GeoPolicy.
+func (uc *AuthUsecase) assessRisk(ctx context.Context, user entity.User, in entity.LoginInput, attested attestationCheck,
+	location entity.GeoLocation) entity.RiskAssessment {
+	if !uc.risk.Enabled {
+		return entity.RiskAssessment{}
+	}
+	ip, err := netip.ParseAddr(in.IP)
+	if err != nil {
+		return entity.RiskAssessment{}
+	}
+	country := location.Country
+	history, err := uc.sessions.SessionOrigins(ctx, user.ID, uc.risk.History)
+	if err != nil {
+		uc.logger.Error("Failed to read session history for risk assessment", "user_id", user.ID, "error", err)
+		return entity.RiskAssessment{}
+	}
+
+	fp := uc.fingerprinter.Fingerprint(ip, in.UserAgent)
+	network := fingerprint.Network(ip)
+	var devices, countries []string
+	var networks []netip.Addr
+	for _, h := range history {
+		if h.DeviceHash != "" {
+			devices = append(devices, h.DeviceHash)
+		}
+		if h.IP.IsValid() {
+			networks = append(networks, fingerprint.Network(h.IP))
+		}
+		if h.Country != "" {
+			countries = append(countries, h.Country)
+		}
+	}
+
+	var factors []string
+	if len(devices) > 0 && !slices.Contains(devices, fp.DeviceHash) {
+		factors = append(factors, entity.RiskNewDevice)
+	}
+	if len(networks) > 0 && network.IsValid() && !slices.Contains(networks, network) {
+		factors = append(factors, entity.RiskNewNetwork)
+	}
+	if len(countries) > 0 && country != "" && !slices.Contains(countries, country) {
+		factors = append(factors, entity.RiskNewCountry)
+	}
+	if attested.unattested() {
+		factors = append(factors, entity.RiskUnattested)
+	}
+
+	score := 0
+	for _, f := range factors {
+		score += riskWeights[f]
+	}
+	level := entity.RiskLow
+	switch {
+	case score >= 3:
+		level = entity.RiskHigh
+	case score > 0:
+		level = entity.RiskMedium
+	}
+
+	uc.Metrics.LoginRisk.WithLabelValues(string(level)).Inc()
+	if level != entity.RiskLow {
+		uc.logger.Warn("Risky login", "user_id", user.ID, "level", level, "factors", factors, "country", country)
+	}
+	return entity.RiskAssessment{Level: level, Factors: factors}
+}
+
+// stepUp returns the extra check the assessment requires, RiskStepUpNone below the step up level.
+// Low risk logins never step up, an unknown step up level counts as high.
+func (p RiskPolicy) stepUp(risk entity.RiskAssessment) RiskStepUp {
+	threshold := p.StepUpLevel
+	if threshold.Rank() <= entity.RiskLow.Rank() {
+		threshold = entity.RiskHigh
+	}
+	if !p.Enabled || !p.StepUp.Valid() || risk.Level.Rank() < threshold.Rank() {
+		return RiskStepUpNone
+	}
+	return p.StepUp
+}
@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"context"
+	"main/domain/entity"
+
+	"github.com/google/uuid"
+)
+
+// FeatureGate reports whether a flow that is being rolled out is enabled for the user, implemented by
+// feature.FeatureUsecase.
+type FeatureGate interface {
+	Enabled(ctx context.Context, feature entity.Feature, userID uuid.UUID) bool
+}
+
+// FeatureRollout gates passkey registration, DPoP-bound sessions and the password hashing algorithm by the
+// rollouts of their features.
+type FeatureRollout struct {
+	// Gate decides the rollouts, nil enables every flow for everyone
+	Gate FeatureGate
+	// FallbackHasher hashes the passwords of users outside the rollout of entity.FeaturePasswordHashing, nil
+	// hashes every password with the configured hasher
+	FallbackHasher PasswordHasher
+}
+
+// Enabled reports whether the feature is enabled for the user.
+func (r FeatureRollout) Enabled(ctx context.Context, feature entity.Feature, userID uuid.UUID) bool {
+	return r.Gate == nil || r.Gate.Enabled(ctx, feature, userID)
+}
+
+// hasher returns the password hasher of the user, the configured one or the fallback one outside of the rollout.
+// The hashes of users moving in or out of the rollout are converted at their next login.
+func (r FeatureRollout) hasher(ctx context.Context, userID uuid.UUID, configured PasswordHasher) PasswordHasher {
+	if r.FallbackHasher == nil || r.Enabled(ctx, entity.FeaturePasswordHashing, userID) {
+		return configured
+	}
+	return r.FallbackHasher
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"main/domain/entity"
+	"main/pkg/customerrors"
+	"main/pkg/fingerprint"
+	"net/netip"
+	"strings"
+)
+
+// BindingMode is how a session reacts to a refresh from another user agent or IP network than at login.
+type BindingMode string
+
+const (
+	// BindingOff does not compare refreshes with the client of the login
+	BindingOff BindingMode = "off"
+	// BindingWarn records a mismatch in the audit log and refreshes the session
+	BindingWarn BindingMode = "warn"
+	// BindingEnforce records a mismatch and refuses the refresh, the session stays usable from its own client
+	BindingEnforce BindingMode = "enforce"
+)
+
+// Valid reports whether the mode is one of the known ones, empty is off.
+func (m BindingMode) Valid() bool {
+	switch m {
+	case "", BindingOff, BindingWarn, BindingEnforce:
+		return true
+	}
+	return false
+}
+
+// bindingMismatch lists what differs between the client of a refresh and the one the session was created by:
+// user_agent and network (/24, /48). Sessions created before networks were stored are only compared by user agent.
+func bindingMismatch(session entity.Session, fp entity.ClientFingerprint) []string {
+	var mismatch []string
+	if session.DeviceHash != "" && session.DeviceHash != fp.DeviceHash {
+		mismatch = append(mismatch, "user_agent")
+	}
+	if session.BoundNetwork.IsValid() && fp.IP.IsValid() && fingerprint.Network(fp.IP) != session.BoundNetwork {
+		mismatch = append(mismatch, "network")
+	}
+	return mismatch
+}
+
+// applySessionBinding compares the client of a refresh with the one of the login according to the binding mode of
+// the session. With BindingEnforce a mismatch fails with customerrors.ErrSessionBindingMismatch.
+func (uc *AuthUsecase) applySessionBinding(ctx context.Context, session entity.Session, mode BindingMode, in entity.RefreshInput) error {
+	if mode == "" || mode == BindingOff {
+		return nil
+	}
+	ip, _ := netip.ParseAddr(in.IP)
+	mismatch := bindingMismatch(session, uc.fingerprinter.Fingerprint(ip, in.UserAgent))
+	if len(mismatch) == 0 {
+		return nil
+	}
+
+	uc.Metrics.SessionBindingMismatches.WithLabelValues(string(session.ClientType), string(mode)).Inc()
+	uc.audit.Record(ctx, entity.AuditEvent{
+		Action:  entity.AuditSessionBindingMismatch,
+		ActorID: &session.UserID,
+		Details: map[string]string{
+			"session_id": session.ID.String(),
+			"mismatch":   strings.Join(mismatch, ","),
+			"mode":       string(mode),
+		},
+	})
+	if mode == BindingEnforce {
+		return customerrors.ErrSessionBindingMismatch
+	}
+	return nil
+}
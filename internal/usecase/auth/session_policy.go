@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"context"
+	"main/domain/entity"
+	"main/pkg/customerrors"
+	"main/pkg/fingerprint"
+	"net/netip"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SessionPolicy controls the lifetime and refresh token rotation of sessions.
+type SessionPolicy struct {
+	// TTL is the idle timeout, how long a session lives after login or the last refresh
+	TTL time.Duration
+	// MaxLifetime is how long a session lives after login however often it is refreshed, zero for no limit
+	MaxLifetime time.Duration
+	// RotationInterval is the minimum age of a refresh token before a refresh issues a new one,
+	// zero rotates on every refresh
+	RotationInterval time.Duration
+	// IPChange decides what happens when the session is refreshed from another IP address
+	IPChange IPChangePolicy
+	// Attestation is how strictly the app attestation of logins and refreshes is checked
+	Attestation AttestationLevel
+	// Binding decides what happens when the session is refreshed from another user agent or network than at login
+	Binding BindingMode
+}
+
+// expiry returns when a session started at startedAt and used at now expires: after the idle timeout, but not
+// later than its lifetime allows.
+func (p SessionPolicy) expiry(startedAt, now time.Time) time.Time {
+	expiresAt := now.Add(p.TTL)
+	if p.MaxLifetime > 0 && !startedAt.IsZero() && startedAt.Add(p.MaxLifetime).Before(expiresAt) {
+		return startedAt.Add(p.MaxLifetime)
+	}
+	return expiresAt
+}
+
+// outlived reports whether the session is older than the lifetime of the policy. Sessions without a login time
+// are never considered outlived.
+func (p SessionPolicy) outlived(session entity.Session, now time.Time) bool {
+	return p.MaxLifetime > 0 && !session.StartedAt.IsZero() && now.Sub(session.StartedAt) >= p.MaxLifetime
+}
+
+// IPChangeAction is the reaction to a refresh from another IP address than the previous one.
+type IPChangeAction string
+
+const (
+	// IPChangeIgnore keeps the session and only tracks the new address
+	IPChangeIgnore IPChangeAction = "ignore"
+	// IPChangeLog keeps the session and logs the change
+	IPChangeLog IPChangeAction = "log"
+	// IPChangeReauth ends the session, the user has to log in again
+	IPChangeReauth IPChangeAction = "reauth"
+)
+
+// Valid reports whether the action is one of the known actions.
+func (a IPChangeAction) Valid() bool {
+	switch a {
+	case IPChangeIgnore, IPChangeLog, IPChangeReauth:
+		return true
+	}
+	return false
+}
+
+// IPChangePolicy controls mid-session IP address changes, observed when a session is refreshed.
+type IPChangePolicy struct {
+	// Action is applied to a change, an empty action logs it
+	Action IPChangeAction
+	// TolerateSameNetwork does not count a move within the same network (/24, /48) as a change,
+	// mobile carriers and NAT pools move clients between addresses all the time
+	TolerateSameNetwork bool
+}
+
+// changed reports whether a refresh from the client fingerprint next counts as an IP change of the session.
+// Sessions created before IP hashes were stored are never considered changed.
+func (p IPChangePolicy) changed(session entity.Session, next entity.ClientFingerprint) bool {
+	if session.IPHash == "" || session.IPHash == next.IPHash {
+		return false
+	}
+	if p.TolerateSameNetwork && sameNetwork(session.ClientIP, next.IP) {
+		return false
+	}
+	return true
+}
+
+// sameNetwork compares the networks of the addresses, it also works with addresses already reduced to their network in privacy mode.
+func sameNetwork(a, b netip.Addr) bool {
+	return a.IsValid() && fingerprint.Network(a) == fingerprint.Network(b)
+}
+
+// SessionPolicies resolves the policy for a session based on its client type.
+type SessionPolicies struct {
+	Default      SessionPolicy
+	ByClientType map[entity.ClientType]SessionPolicy
+}
+
+// For returns the policy for the client type, falling back to the default one.
+func (p SessionPolicies) For(clientType entity.ClientType) SessionPolicy {
+	if policy, ok := p.ByClientType[clientType]; ok {
+		return policy
+	}
+	return p.Default
+}
+
+// applyIPChangePolicy compares the client of a refresh with the last one of the session. Unless the policy ends
+// the session, the session takes over the new address, so the next refresh is compared with it.
+func (uc *AuthUsecase) applyIPChangePolicy(ctx context.Context, session *entity.Session, policy IPChangePolicy, in entity.RefreshInput) error {
+	ip, err := netip.ParseAddr(in.IP)
+	if err != nil {
+		return nil
+	}
+	fp := uc.fingerprinter.Fingerprint(ip, in.UserAgent)
+	if !policy.changed(*session, fp) {
+		return nil
+	}
+
+	action := policy.Action
+	if !action.Valid() {
+		action = IPChangeLog
+	}
+	uc.Metrics.SessionIPChanges.WithLabelValues(string(session.ClientType), string(action)).Inc()
+	switch action {
+	case IPChangeReauth:
+		uc.logger.Info("Session ended after an IP change", "user_id", session.UserID, "session_id", session.ID)
+		if err := uc.sessions.DeleteSession(ctx, session.UserID, session.ID); err != nil {
+			return err
+		}
+		if err := uc.denylist.Deny(ctx, []uuid.UUID{session.ID}); err != nil {
+			uc.logger.Error("Failed to deny session ended after an IP change", "user_id", session.UserID,
+				"session_id", session.ID, "error", err)
+		}
+		return customerrors.ErrReauthenticationRequired
+	case IPChangeLog:
+		uc.logger.Warn("Session refreshed from another IP address", "user_id", session.UserID, "session_id", session.ID,
+			"client_type", session.ClientType, "previous_ip", session.ClientIP, "ip", fp.IP)
+	}
+	session.ClientIP = fp.IP
+	session.IPHash = fp.IPHash
+	return nil
+}
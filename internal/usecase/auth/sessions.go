@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"main/domain/entity"
+	"main/pkg/customerrors"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// ListSessions returns the active sessions of the user, the last used first, for a "manage devices" screen.
+// The session of currentID, the one of the access token of the request, is marked as current.
+func (uc *AuthUsecase) ListSessions(ctx context.Context, userID, currentID uuid.UUID) ([]entity.ActiveSession, error) {
+	sessions, err := uc.sessions.ListActiveSessions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if sessions == nil {
+		sessions = []entity.ActiveSession{}
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentID
+	}
+	return sessions, nil
+}
+
+const (
+	// defaultSessionPageSize and maxSessionPageSize bound the pages of ListSessionPage
+	defaultSessionPageSize = 20
+	maxSessionPageSize     = 100
+)
+
+// ListSessionPage returns a page of the sessions of the user matching the status of the filter, newest first by
+// its sort (last use by default), for the sessions list of the user and for administrators. The page continues
+// after cursor, the NextCursor of the previous page, or at the offset without one. The limit defaults to 20 and is
+// capped at 100. currentID marks the session of the request as current, uuid.Nil for administrators.
+// customerrors.ErrInvalidSessionFilter is returned for unknown statuses, sorts and cursors.
+func (uc *AuthUsecase) ListSessionPage(ctx context.Context, filter entity.SessionFilter, cursor string, currentID uuid.UUID) (entity.SessionPage, error) {
+	if filter.Sort == "" {
+		filter.Sort = entity.SessionSortLastUsed
+	}
+	if !filter.Status.Valid() || !filter.Sort.Valid() {
+		return entity.SessionPage{}, customerrors.ErrInvalidSessionFilter
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = defaultSessionPageSize
+	}
+	filter.Limit = min(filter.Limit, maxSessionPageSize)
+	filter.Offset = max(filter.Offset, 0)
+	if cursor != "" {
+		after, err := parseSessionCursor(cursor)
+		if err != nil {
+			return entity.SessionPage{}, customerrors.ErrInvalidSessionFilter
+		}
+		filter.After = &after
+	}
+
+	page, err := uc.sessions.ListSessions(ctx, filter)
+	if err != nil {
+		return entity.SessionPage{}, err
+	}
+	if page.Sessions == nil {
+		page.Sessions = []entity.ActiveSession{}
+	}
+	for i := range page.Sessions {
+		page.Sessions[i].Current = page.Sessions[i].ID == currentID
+	}
+	// a full page may be followed by more sessions, the next page is empty when it was the last one
+	if len(page.Sessions) == filter.Limit {
+		last := page.Sessions[len(page.Sessions)-1]
+		at := last.LastUsedAt
+		if filter.Sort == entity.SessionSortCreated {
+			at = last.CreatedAt
+		}
+		page.NextCursor = sessionCursor(entity.SessionCursor{At: at, ID: last.ID})
+	}
+	return page, nil
+}
+
+// sessionCursor encodes the position of a session in a listing for the client.
+func sessionCursor(c entity.SessionCursor) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.At.Format(time.RFC3339Nano) + "," + c.ID.String()))
+}
+
+// parseSessionCursor decodes a cursor returned by sessionCursor.
+func parseSessionCursor(cursor string) (entity.SessionCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return entity.SessionCursor{}, err
+	}
+	at, id, ok := strings.Cut(string(raw), ",")
+	if !ok {
+		return entity.SessionCursor{}, errors.New("malformed session cursor")
+	}
+	var c entity.SessionCursor
+	if c.At, err = time.Parse(time.RFC3339Nano, at); err != nil {
+		return entity.SessionCursor{}, err
+	}
+	if c.ID, err = uuid.Parse(id); err != nil {
+		return entity.SessionCursor{}, err
+	}
+	return c, nil
+}
+
+// maxDeviceName is the length limit of session device names, in characters.
+const maxDeviceName = 64
+
+// deviceName returns the device name with surrounding spaces removed, customerrors.ErrInvalidDeviceName if it is
+// too long or has control characters.
+func deviceName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if utf8.RuneCountInString(name) > maxDeviceName || strings.ContainsFunc(name, unicode.IsControl) {
+		return "", customerrors.ErrInvalidDeviceName
+	}
+	return name, nil
+}
+
+// RenameSession gives the session of the user a device name ("Dan's iPhone") to tell it apart in the sessions
+// list, an empty name removes it. customerrors.ErrSessionNotFound is returned if the user has no active session
+// with the ID.
+func (uc *AuthUsecase) RenameSession(ctx context.Context, userID, sessionID uuid.UUID, name string) error {
+	name, err := deviceName(name)
+	if err != nil {
+		return err
+	}
+	err = uc.sessions.RenameSession(ctx, userID, sessionID, name)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return customerrors.ErrSessionNotFound
+	}
+	return err
+}
+
+// BlockSession freezes a session of the user without ending it: its refreshes are refused and its access tokens
+// denied until they expire, see SessionDenylist. customerrors.ErrSessionNotFound is returned if the user has no
+// active session with the ID.
+func (uc *AuthUsecase) BlockSession(ctx context.Context, userID, sessionID uuid.UUID) error {
+	err := uc.sessions.SetSessionBlocked(ctx, userID, sessionID, true)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return customerrors.ErrSessionNotFound
+	}
+	if err != nil {
+		return err
+	}
+	// the session is blocked, a failed denial only leaves its access tokens valid until they expire
+	if err := uc.denylist.Deny(ctx, []uuid.UUID{sessionID}); err != nil {
+		uc.logger.Error("Failed to deny blocked session", "user_id", userID, "session_id", sessionID, "error", err)
+	}
+	return nil
+}
+
+// UnblockSession lets a blocked session of the user be refreshed again. The access tokens it is issued are only
+// accepted once the denial of the block is over, at most one access token lifetime after the block.
+func (uc *AuthUsecase) UnblockSession(ctx context.Context, userID, sessionID uuid.UUID) error {
+	err := uc.sessions.SetSessionBlocked(ctx, userID, sessionID, false)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return customerrors.ErrSessionNotFound
+	}
+	return err
+}
+
+// RevokeSession ends one session of the user. Its refresh token stops working right away and its access tokens
+// are denied until they expire, see SessionDenylist. customerrors.ErrSessionNotFound is returned if the user has
+// no session with the ID.
+func (uc *AuthUsecase) RevokeSession(ctx context.Context, userID, sessionID uuid.UUID) error {
+	session, err := uc.sessions.GetSession(ctx, userID, sessionID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return customerrors.ErrSessionNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := uc.sessions.DeleteSession(ctx, userID, sessionID); err != nil {
+		return err
+	}
+	// the session is deleted, a failed denial only leaves its access tokens valid until they expire
+	if err := uc.denylist.Deny(ctx, []uuid.UUID{sessionID}); err != nil {
+		uc.logger.Error("Failed to deny revoked session", "user_id", userID, "session_id", sessionID, "error", err)
+	}
+
+	uc.logger.Info("Session revoked", "user_id", userID, "session_id", sessionID)
+	uc.audit.Record(ctx, entity.AuditEvent{
+		Action:  entity.AuditSessionRevoked,
+		ActorID: &userID,
+		Details: map[string]string{
+			"session_id":  sessionID.String(),
+			"client_type": string(session.ClientType),
+		},
+	})
+	return nil
+}
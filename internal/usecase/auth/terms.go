@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"context"
+	"main/domain/entity"
+	"main/pkg/customerrors"
+	"slices"
+
+	"github.com/google/uuid"
+)
+
+// TermsStore records the acceptance of the terms of service and the privacy policy.
+type TermsStore interface {
+	// PendingTerms returns the current versions the user still has to accept, for uuid.Nil the ones a new user has to accept.
+	PendingTerms(ctx context.Context, userID uuid.UUID) ([]entity.TermsDocument, error)
+
+	// AcceptTerms records that the user accepted the documents.
+	AcceptTerms(ctx context.Context, userID uuid.UUID, documentIDs []uuid.UUID) error
+}
+
+// TermsPolicy records the terms documents accepted at registration and login. With RequireAcceptance
+// registrations and logins are rejected with customerrors.ErrTermsNotAccepted while a current version
+// that requires acceptance is not accepted. A nil Store disables the tracking.
+type TermsPolicy struct {
+	Store             TermsStore
+	RequireAcceptance bool
+}
+
+// checkRegistration rejects a registration that does not accept every pending document.
+func (p TermsPolicy) checkRegistration(ctx context.Context, accepted []uuid.UUID) error {
+	if p.Store == nil || !p.RequireAcceptance {
+		return nil
+	}
+	pending, err := p.Store.PendingTerms(ctx, uuid.Nil)
+	if err != nil {
+		return err
+	}
+	for _, doc := range pending {
+		if !slices.Contains(accepted, doc.ID) {
+			return customerrors.ErrTermsNotAccepted
+		}
+	}
+	return nil
+}
+
+// checkLogin records the documents accepted with the login and rejects it while documents are pending.
+func (p TermsPolicy) checkLogin(ctx context.Context, userID uuid.UUID, accepted []uuid.UUID) error {
+	if p.Store == nil {
+		return nil
+	}
+	if len(accepted) > 0 {
+		if err := p.Store.AcceptTerms(ctx, userID, accepted); err != nil {
+			return err
+		}
+	}
+	if !p.RequireAcceptance {
+		return nil
+	}
+	pending, err := p.Store.PendingTerms(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return customerrors.ErrTermsNotAccepted
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"main/domain/entity"
+	"main/pkg/customerrors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// TokenVersionRepo reads the token state of users: the token version, the counter incremented whenever all their
+// access tokens are revoked, and the time of their last password change.
+type TokenVersionRepo interface {
+	// UserTokenVersion returns the token state of the user and whether it is blocked,
+	// pgx.ErrNoRows if it does not exist or is deleted.
+	UserTokenVersion(ctx context.Context, userID uuid.UUID) (state entity.TokenState, isBlocked bool, err error)
+}
+
+// TokenVersionCache caches token states, it never replaces a cached version or password timestamp by a lower one.
+type TokenVersionCache interface {
+	Get(ctx context.Context, userID uuid.UUID) (state entity.TokenState, ok bool, err error)
+	Raise(ctx context.Context, userID uuid.UUID, state entity.TokenState, ttl time.Duration) error
+}
+
+// TokenStateBroadcaster tells the other instances about raised token states right away, implemented by
+// revocation.RedisBus. They see the states once their cached ones expire when it fails.
+type TokenStateBroadcaster interface {
+	PublishTokenStates(ctx context.Context, userIDs []uuid.UUID) error
+}
+
+// evicter is implemented by caches holding states in the memory of the instance, see tokenversion.TieredCache.
+type evicter interface {
+	Evict(userIDs ...uuid.UUID)
+}
+
+// deletedVersion is cached for deleted users, it is higher than any version and exactly representable
+// in the float64 numbers JWT claims are decoded to.
+const deletedVersion = 1 << 53
+
+// TokenVersions checks access tokens against the token version of their user (tv claim). Logout-all, blocks
+// and forced logouts increment the version in the database, which revokes all outstanding access tokens of
+// the user without a denylist. Password changes and resets likewise revoke the tokens minted before them
+// (pwd_ts claim). States are cached for ttl, Invalidated publishes a new state to the cache right away,
+// and to the other instances when a broadcaster is set, so a revocation does not wait for the cached state to expire.
+type TokenVersions struct {
+	repo  TokenVersionRepo
+	cache TokenVersionCache
+	ttl   time.Duration
+	// broadcaster tells the other instances to evict the states they hold in memory, nil when they hold none
+	broadcaster TokenStateBroadcaster
+}
+
+func NewTokenVersions(repo TokenVersionRepo, cache TokenVersionCache, ttl time.Duration, broadcaster TokenStateBroadcaster) *TokenVersions {
+	return &TokenVersions{
+		repo:        repo,
+		cache:       cache,
+		ttl:         ttl,
+		broadcaster: broadcaster,
+	}
+}
+
+// Current returns the state access tokens of the user must carry. Blocked users get customerrors.ErrUserBlocked,
+// deleted users pgx.ErrNoRows.
+func (t *TokenVersions) Current(ctx context.Context, userID uuid.UUID) (entity.TokenState, error) {
+	// an unavailable cache falls back to the database
+	if state, ok, err := t.cache.Get(ctx, userID); err == nil && ok {
+		return state, nil
+	}
+	state, isBlocked, err := t.repo.UserTokenVersion(ctx, userID)
+	if err != nil {
+		return entity.TokenState{}, err
+	}
+	if isBlocked {
+		return entity.TokenState{}, customerrors.ErrUserBlocked
+	}
+	_ = t.cache.Raise(ctx, userID, state, t.ttl)
+	return state, nil
+}
+
+// revoked reports whether access token claims predate the state: an older token version, or a password timestamp
+// before the last password change. Tokens issued before the pwd_ts claim carry none and are compared by their
+// issuance time.
+func revoked(claims entity.AccessTokenClaims, state entity.TokenState) bool {
+	if claims.TokenVersion < state.Version {
+		return true
+	}
+	passwordTimestamp := claims.PasswordTimestamp
+	if passwordTimestamp == 0 {
+		passwordTimestamp = claims.IssuedAt.Unix()
+	}
+	return passwordTimestamp < state.PasswordTimestamp
+}
+
+// Invalidated publishes the states of users whose tokens were revoked or whose password changed to the cache
+// and to the other instances. It must be called after the transaction that incremented the versions or changed
+// the passwords was committed.
+func (t *TokenVersions) Invalidated(ctx context.Context, userIDs ...uuid.UUID) error {
+	var errs []error
+	for _, userID := range userIDs {
+		state, _, err := t.repo.UserTokenVersion(ctx, userID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			state, err = entity.TokenState{Version: deletedVersion}, nil
+		}
+		if err == nil {
+			err = t.cache.Raise(ctx, userID, state, t.ttl)
+		}
+		errs = append(errs, err)
+	}
+	if t.broadcaster != nil && len(userIDs) > 0 {
+		errs = append(errs, t.broadcaster.PublishTokenStates(ctx, userIDs))
+	}
+	return errors.Join(errs...)
+}
+
+// Evict drops the states of the users from the memory of this instance after another instance raised them.
+func (t *TokenVersions) Evict(userIDs []uuid.UUID) {
+	if cache, ok := t.cache.(evicter); ok {
+		cache.Evict(userIDs...)
+	}
+}
+
+// checkTokenState ends sessions started before the token version of their user was incremented or its password
+// changed. Stores that keep sessions apart from the users (Redis) do not see the sessions deleted with those
+// revocations, their sessions carry the token state they were started with. The refresh is answered like one
+// of an unknown token, pgx.ErrNoRows.
+func (uc *AuthUsecase) checkTokenState(ctx context.Context, session entity.Session) error {
+	if session.TokenState == nil {
+		return nil
+	}
+	state, err := uc.tokenVersions.Current(ctx, session.UserID)
+	if err != nil {
+		return err
+	}
+	if state.Version <= session.TokenState.Version && state.PasswordTimestamp <= session.TokenState.PasswordTimestamp {
+		return nil
+	}
+	if err := uc.sessions.DeleteSession(ctx, session.UserID, session.ID); err != nil {
+		return err
+	}
+	return pgx.ErrNoRows
+}
@@ -0,0 +1,191 @@
+package cleanup
+
+import (
+	"context"
+	"log/slog"
+	"main/domain/entity"
+	metrics "main/internal/metrics"
+	"time"
+)
+
+// CleanupRepo defines the interface for the deletion of expired rows.
+type CleanupRepo interface {
+	// DeleteExpiredSessions deletes up to limit sessions that expired before the given time.
+	DeleteExpiredSessions(ctx context.Context, before time.Time, limit int) (int64, error)
+
+	// CountExpiredSessions counts the sessions that expired before the given time.
+	CountExpiredSessions(ctx context.Context, before time.Time) (int64, error)
+
+	// DeleteLoginEvents deletes up to limit login attempts made before the given time.
+	DeleteLoginEvents(ctx context.Context, before time.Time, limit int) (int64, error)
+
+	// CountLoginEvents counts the login attempts made before the given time.
+	CountLoginEvents(ctx context.Context, before time.Time) (int64, error)
+
+	// EndOverdueSessions expires up to limit live sessions of the client types (all the others with exclude)
+	// last used before idleSince or started before startedBefore, a zero time disables its condition.
+	EndOverdueSessions(ctx context.Context, clientTypes []string, exclude bool, idleSince, startedBefore time.Time, limit int) (int64, error)
+
+	// CountOverdueSessions counts the sessions EndOverdueSessions would expire.
+	CountOverdueSessions(ctx context.Context, clientTypes []string, exclude bool, idleSince, startedBefore time.Time) (int64, error)
+}
+
+// SessionLimit is the idle timeout and lifetime of the sessions of some client types, zero durations are unlimited.
+type SessionLimit struct {
+	ClientTypes []string
+	// Others applies the limit to every client type except ClientTypes
+	Others      bool
+	IdleTimeout time.Duration
+	MaxLifetime time.Duration
+}
+
+// CleanupPolicy configures what the cleanup job deletes.
+type CleanupPolicy struct {
+	// BatchSize is the number of rows deleted per statement, short transactions keep locks and WAL bursts small
+	BatchSize int
+	// SessionRetention keeps expired sessions this long after their expiry, the risk assessment compares new
+	// logins with the devices and countries of previous sessions
+	SessionRetention time.Duration
+	// LoginHistoryRetention is how long login attempts are kept, zero keeps them forever
+	LoginHistoryRetention time.Duration
+	// SessionLimits end the sessions left idle or alive for too long, the refresh only checks a session when it is
+	// used and the limits may have been lowered since the session was started
+	SessionLimits []SessionLimit
+	// DryRun only counts the rows that would be deleted
+	DryRun bool
+}
+
+// CleanupUsecase deletes expired sessions and old login attempts in batches. The audit log is append-only and
+// never cleaned up.
+type CleanupUsecase struct {
+	repo    CleanupRepo
+	Metrics *metrics.Metrics
+	logger  *slog.Logger
+	policy  CleanupPolicy
+}
+
+func NewCleanupUsecase(repo CleanupRepo, metrics *metrics.Metrics, logger *slog.Logger, policy CleanupPolicy) *CleanupUsecase {
+	return &CleanupUsecase{
+		repo:    repo,
+		Metrics: metrics,
+		logger:  logger,
+		policy:  policy,
+	}
+}
+
+// Cleanup ends the sessions over their idle timeout or lifetime, deletes the sessions expired longer than the session
+// retention and the login attempts older than the login history retention. In dry-run mode it only counts them.
+func (uc *CleanupUsecase) Cleanup(ctx context.Context) (report entity.CleanupReport, err error) {
+	defer func(start time.Time) {
+		outcome := "ok"
+		if err != nil {
+			outcome = "error"
+		}
+		uc.Metrics.CleanupDuration.WithLabelValues(outcome).Observe(time.Since(start).Seconds())
+	}(time.Now())
+
+	now := time.Now()
+	report.DryRun = uc.policy.DryRun
+	report.EndedSessions, err = uc.endOverdueSessions(ctx, now)
+	if err != nil {
+		return report, err
+	}
+	report.Sessions, err = uc.purge(ctx, "sessions", now.Add(-uc.policy.SessionRetention),
+		uc.repo.DeleteExpiredSessions, uc.repo.CountExpiredSessions)
+	if err != nil {
+		return report, err
+	}
+	if uc.policy.LoginHistoryRetention > 0 {
+		report.LoginEvents, err = uc.purge(ctx, "login_events", now.Add(-uc.policy.LoginHistoryRetention),
+			uc.repo.DeleteLoginEvents, uc.repo.CountLoginEvents)
+	}
+	return report, err
+}
+
+// endOverdueSessions expires the live sessions over a limit and returns how many, batch by batch like purge.
+func (uc *CleanupUsecase) endOverdueSessions(ctx context.Context, now time.Time) (int64, error) {
+	var total int64
+	for _, limit := range uc.policy.SessionLimits {
+		var idleSince, startedBefore time.Time
+		if limit.IdleTimeout > 0 {
+			idleSince = now.Add(-limit.IdleTimeout)
+		}
+		if limit.MaxLifetime > 0 {
+			startedBefore = now.Add(-limit.MaxLifetime)
+		}
+		if idleSince.IsZero() && startedBefore.IsZero() {
+			continue
+		}
+		if uc.policy.DryRun {
+			count, err := uc.repo.CountOverdueSessions(ctx, limit.ClientTypes, limit.Others, idleSince, startedBefore)
+			total += count
+			if err != nil {
+				return total, err
+			}
+			continue
+		}
+		for {
+			ended, err := uc.repo.EndOverdueSessions(ctx, limit.ClientTypes, limit.Others, idleSince, startedBefore, uc.policy.BatchSize)
+			total += ended
+			if err != nil {
+				return total, err
+			}
+			if ended < int64(uc.policy.BatchSize) {
+				break
+			}
+			if err := ctx.Err(); err != nil {
+				return total, err
+			}
+		}
+	}
+	return total, nil
+}
+
+// purge deletes the rows of the table before the given time batch by batch until a batch comes back short, and
+// returns how many were deleted. The rows of the batches deleted before an error stay deleted and counted.
+func (uc *CleanupUsecase) purge(ctx context.Context, table string, before time.Time,
+	deleteBatch func(ctx context.Context, before time.Time, limit int) (int64, error),
+	count func(ctx context.Context, before time.Time) (int64, error)) (int64, error) {
+	if uc.policy.DryRun {
+		return count(ctx, before)
+	}
+	var total int64
+	for {
+		deleted, err := deleteBatch(ctx, before, uc.policy.BatchSize)
+		total += deleted
+		uc.Metrics.CleanupDeletedRows.WithLabelValues(table).Add(float64(deleted))
+		if err != nil {
+			return total, err
+		}
+		if deleted < int64(uc.policy.BatchSize) {
+			return total, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+	}
+}
+
+// Run cleans up every interval until the context is cancelled.
+func (uc *CleanupUsecase) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := uc.Cleanup(ctx)
+			if err != nil {
+				uc.logger.Error("Failed to clean up expired rows", "ended_sessions", report.EndedSessions,
+					"sessions", report.Sessions, "login_events", report.LoginEvents, "error", err)
+				continue
+			}
+			if report.EndedSessions > 0 || report.Sessions > 0 || report.LoginEvents > 0 {
+				uc.logger.Info("Cleaned up expired rows", "ended_sessions", report.EndedSessions, "sessions", report.Sessions,
+					"login_events", report.LoginEvents, "dry_run", report.DryRun)
+			}
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package feature
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"log/slog"
+	"main/domain/entity"
+	"main/pkg/customerrors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FeatureRepo defines the interface for the storage of the rollouts of features.
+type FeatureRepo interface {
+	// ListFlags returns the rollouts of all features.
+	ListFlags(ctx context.Context) ([]entity.FeatureFlag, error)
+
+	// SetPercentage changes the percentage of the rollout of the feature.
+	SetPercentage(ctx context.Context, feature entity.Feature, percentage int, adminID uuid.UUID) (entity.FeatureFlag, error)
+
+	// AllowUser adds the user to the allowlist of the feature, customerrors.ErrUserNotFound if it does not exist.
+	AllowUser(ctx context.Context, feature entity.Feature, userID, adminID uuid.UUID) error
+
+	// DisallowUser removes the user from the allowlist, customerrors.ErrUserNotAllowlisted if it is not on it.
+	DisallowUser(ctx context.Context, feature entity.Feature, userID uuid.UUID) error
+
+	// ListAllowedUsers returns a page of the allowlist of the feature, the latest addition first.
+	ListAllowedUsers(ctx context.Context, feature entity.Feature, limit, offset int) ([]entity.FeatureUser, error)
+
+	// IsAllowed reports whether the user is on the allowlist of the feature.
+	IsAllowed(ctx context.Context, feature entity.Feature, userID uuid.UUID) (bool, error)
+}
+
+// Auditor records the changes of rollouts in the audit log.
+type Auditor interface {
+	Record(ctx context.Context, event entity.AuditEvent)
+}
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// FeatureUsecase rolls new auth flows out to a part of the users, so that risky changes reach few users first
+// and can be rolled back by lowering the percentage. The flags are cached for the cache TTL, other instances
+// apply changes after at most that time.
+type FeatureUsecase struct {
+	repo     FeatureRepo
+	audit    Auditor
+	logger   *slog.Logger
+	cacheTTL time.Duration
+
+	mu sync.Mutex
+	// flags is nil until they were read once
+	flags    map[entity.Feature]entity.FeatureFlag
+	loadedAt time.Time
+}
+
+func NewFeatureUsecase(repo FeatureRepo, audit Auditor, logger *slog.Logger, cacheTTL time.Duration) *FeatureUsecase {
+	return &FeatureUsecase{
+		repo:     repo,
+		audit:    audit,
+		logger:   logger,
+		cacheTTL: cacheTTL,
+	}
+}
+
+// Enabled reports whether the feature is enabled for the user. Features without a flag are enabled for everyone,
+// while the flags cannot be read at all the gated flows stay off.
+func (uc *FeatureUsecase) Enabled(ctx context.Context, feature entity.Feature, userID uuid.UUID) bool {
+	flags := uc.cachedFlags(ctx)
+	if flags == nil {
+		return false
+	}
+	flag, ok := flags[feature]
+	if !ok || bucket(feature, userID) < flag.Percentage {
+		return true
+	}
+	if flag.Allowlisted == 0 {
+		return false
+	}
+	allowed, err := uc.repo.IsAllowed(ctx, feature, userID)
+	if err != nil {
+		uc.logger.Error("Failed to read the feature allowlist", "feature", feature, "user_id", userID, "error", err)
+		return false
+	}
+	return allowed
+}
+
+// bucket places the user in one of 100 buckets of the feature, the rollout of a percentage covers the buckets
+// below it. The feature is part of the hash so that each feature reaches other users first.
+func bucket(feature entity.Feature, userID uuid.UUID) int {
+	sum := sha256.Sum256(append([]byte(feature+":"), userID[:]...))
+	return int(binary.BigEndian.Uint64(sum[:8]) % 100)
+}
+
+// cachedFlags returns the flags by feature, read again after the cache TTL. When they cannot be read, the ones
+// read last are used until the next attempt a TTL later.
+func (uc *FeatureUsecase) cachedFlags(ctx context.Context) map[entity.Feature]entity.FeatureFlag {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+	if !uc.loadedAt.IsZero() && time.Since(uc.loadedAt) < uc.cacheTTL {
+		return uc.flags
+	}
+	uc.loadedAt = time.Now()
+	flags, err := uc.repo.ListFlags(ctx)
+	if err != nil {
+		uc.logger.Error("Failed to read the feature flags", "error", err)
+		return uc.flags
+	}
+	uc.flags = make(map[entity.Feature]entity.FeatureFlag, len(flags))
+	for _, flag := range flags {
+		uc.flags[flag.Name] = flag
+	}
+	return uc.flags
+}
+
+// ListFlags returns the rollouts of all features.
+func (uc *FeatureUsecase) ListFlags(ctx context.Context) ([]entity.FeatureFlag, error) {
+	flags, err := uc.repo.ListFlags(ctx)
+	if flags == nil && err == nil {
+		flags = []entity.FeatureFlag{}
+	}
+	return flags, err
+}
+
+// SetPercentage rolls the feature out to the percentage of the users, 0 rolls it back to the allowlist.
+func (uc *FeatureUsecase) SetPercentage(ctx context.Context, feature entity.Feature, percentage int, adminID uuid.UUID) (entity.FeatureFlag, error) {
+	if !feature.Valid() {
+		return entity.FeatureFlag{}, customerrors.ErrUnknownFeature
+	}
+	if percentage < 0 || percentage > 100 {
+		return entity.FeatureFlag{}, customerrors.ErrInvalidRolloutPercentage
+	}
+	flag, err := uc.repo.SetPercentage(ctx, feature, percentage, adminID)
+	if err != nil {
+		return entity.FeatureFlag{}, err
+	}
+	uc.logger.Info("Feature rollout changed", "admin_id", adminID, "feature", feature, "percentage", percentage)
+	uc.changed(ctx, entity.AuditEvent{
+		Action:  entity.AdminActionFeatureRollout,
+		ActorID: &adminID,
+		Details: map[string]string{"feature": string(feature), "percentage": strconv.Itoa(percentage)},
+	})
+	return flag, nil
+}
+
+// AllowUser enables the feature for the user whatever the percentage of its rollout, for internal users and
+// testers ahead of a rollout.
+func (uc *FeatureUsecase) AllowUser(ctx context.Context, feature entity.Feature, userID, adminID uuid.UUID) error {
+	if !feature.Valid() {
+		return customerrors.ErrUnknownFeature
+	}
+	if err := uc.repo.AllowUser(ctx, feature, userID, adminID); err != nil {
+		return err
+	}
+	uc.logger.Info("User added to feature allowlist", "admin_id", adminID, "feature", feature, "user_id", userID)
+	uc.changed(ctx, entity.AuditEvent{
+		Action:     entity.AdminActionFeatureAllow,
+		ActorID:    &adminID,
+		TargetType: entity.AdminTargetUser,
+		TargetID:   &userID,
+		Details:    map[string]string{"feature": string(feature)},
+	})
+	return nil
+}
+
+// DisallowUser removes the user from the allowlist of the feature, it stays enabled if the percentage covers the user.
+func (uc *FeatureUsecase) DisallowUser(ctx context.Context, feature entity.Feature, userID, adminID uuid.UUID) error {
+	if !feature.Valid() {
+		return customerrors.ErrUnknownFeature
+	}
+	if err := uc.repo.DisallowUser(ctx, feature, userID); err != nil {
+		return err
+	}
+	uc.logger.Info("User removed from feature allowlist", "admin_id", adminID, "feature", feature, "user_id", userID)
+	uc.changed(ctx, entity.AuditEvent{
+		Action:     entity.AdminActionFeatureDisallow,
+		ActorID:    &adminID,
+		TargetType: entity.AdminTargetUser,
+		TargetID:   &userID,
+		Details:    map[string]string{"feature": string(feature)},
+	})
+	return nil
+}
+
+// ListAllowedUsers returns a page of the allowlist of the feature. The limit defaults to 50 and is capped at 200.
+func (uc *FeatureUsecase) ListAllowedUsers(ctx context.Context, feature entity.Feature, limit, offset int) ([]entity.FeatureUser, error) {
+	if !feature.Valid() {
+		return nil, customerrors.ErrUnknownFeature
+	}
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	users, err := uc.repo.ListAllowedUsers(ctx, feature, min(limit, maxPageSize), max(offset, 0))
+	if users == nil && err == nil {
+		users = []entity.FeatureUser{}
+	}
+	return users, err
+}
+
+// changed records the change in the audit log and drops the cached flags, so this instance applies it right away.
+func (uc *FeatureUsecase) changed(ctx context.Context, event entity.AuditEvent) {
+	uc.audit.Record(ctx, event)
+	uc.mu.Lock()
+	uc.loadedAt = time.Time{}
+	uc.mu.Unlock()
+}
@@ -0,0 +1,174 @@
+package handles
+
+import (
+	"context"
+	"log/slog"
+	"main/domain/entity"
+	"main/pkg/customerrors"
+	ctxUtil "main/pkg/utils/context"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Reserved are the usernames nobody can take on any domain, they could be mistaken for the operators of the service.
+var Reserved = []string{
+	"admin", "administrator", "root", "superuser", "sysadmin", "system", "support", "security", "help",
+	"helpdesk", "info", "contact", "abuse", "postmaster", "hostmaster", "webmaster", "noreply", "no-reply",
+	"mailer-daemon", "billing", "payments", "staff", "team", "moderator", "mod", "official", "owner",
+	"operator", "api", "www", "mail", "auth", "login", "signup", "register", "account", "accounts",
+	"settings", "status", "null", "undefined", "anonymous",
+}
+
+// HandleRepo defines the interface for the storage of banned handles.
+type HandleRepo interface {
+	// BanHandle stores the ban, replacing the reason of an existing one.
+	BanHandle(ctx context.Context, ban entity.BannedHandle) error
+
+	// UnbanHandle removes the ban, returns customerrors.ErrNoTagsAffected if there is none.
+	UnbanHandle(ctx context.Context, tenant, handle string) error
+
+	// ListBannedHandles returns the bans of the tenant.
+	ListBannedHandles(ctx context.Context, tenant string) ([]entity.BannedHandle, error)
+
+	// HandleBanned reports whether one of the handles is banned globally or on the tenant.
+	HandleBanned(ctx context.Context, tenant string, handles []string) (bool, error)
+}
+
+// HandleUsecase keeps users from registering or switching to usernames reserved for the operators of the
+// service, and lets administrators ban further usernames and email addresses. Bans of the empty tenant apply
+// on every domain, the others only on their tenant domain.
+type HandleUsecase struct {
+	repo   HandleRepo
+	logger *slog.Logger
+	// reserved holds the normalized reserved usernames of every domain
+	reserved map[string]struct{}
+	// tenantReserved holds the normalized usernames reserved on a tenant domain only, by host
+	tenantReserved map[string]map[string]struct{}
+}
+
+// NewHandleUsecase reserves the given usernames on every domain and tenantReserved on the tenant domains,
+// keyed by host. Callers usually pass Reserved with the configured additions.
+func NewHandleUsecase(repo HandleRepo, logger *slog.Logger, reserved []string, tenantReserved map[string][]string) *HandleUsecase {
+	uc := &HandleUsecase{
+		repo:           repo,
+		logger:         logger,
+		reserved:       normalizeSet(reserved),
+		tenantReserved: make(map[string]map[string]struct{}, len(tenantReserved)),
+	}
+	for host, names := range tenantReserved {
+		uc.tenantReserved[strings.ToLower(host)] = normalizeSet(names)
+	}
+	return uc
+}
+
+// Normalize folds a username for comparison with reserved and banned ones, so that "Ad.Min" or "ad_min"
+// cannot stand in for "admin".
+func Normalize(username string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '_', '-', ' ':
+			return -1
+		}
+		return r
+	}, strings.ToLower(strings.TrimSpace(username)))
+}
+
+func normalizeSet(names []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		if n := Normalize(name); n != "" {
+			set[n] = struct{}{}
+		}
+	}
+	return set
+}
+
+// Check returns customerrors.ErrHandleUnavailable if the username is reserved or banned, or the email
+// address is banned, on the tenant domain of the request. Empty values are skipped.
+func (uc *HandleUsecase) Check(ctx context.Context, username, email string) error {
+	tenant := tenantOf(ctx)
+	var handles []string
+	if username != "" {
+		name := Normalize(username)
+		_, reserved := uc.reserved[name]
+		if _, ok := uc.tenantReserved[tenant][name]; ok {
+			reserved = true
+		}
+		if reserved {
+			uc.logger.Info("Reserved username rejected", "username", username, "tenant", tenant)
+			return customerrors.ErrHandleUnavailable
+		}
+		handles = append(handles, name)
+	}
+	if email != "" {
+		handles = append(handles, strings.ToLower(strings.TrimSpace(email)))
+	}
+	if len(handles) == 0 {
+		return nil
+	}
+	banned, err := uc.repo.HandleBanned(ctx, tenant, handles)
+	if err != nil {
+		return err
+	}
+	if banned {
+		uc.logger.Info("Banned handle rejected", "username", username, "email", email, "tenant", tenant)
+		return customerrors.ErrHandleUnavailable
+	}
+	return nil
+}
+
+// Ban bans a username or, when it contains an @, an email address on the tenant, every domain for the
+// empty tenant. Accounts already using the handle are not affected.
+func (uc *HandleUsecase) Ban(ctx context.Context, tenant, handle, reason string, adminID uuid.UUID) (entity.BannedHandle, error) {
+	handle = normalizeHandle(handle)
+	if handle == "" || len(handle) > 255 {
+		return entity.BannedHandle{}, customerrors.ErrInvalidHandle
+	}
+	ban := entity.BannedHandle{
+		Handle:    handle,
+		Tenant:    strings.ToLower(strings.TrimSpace(tenant)),
+		Reason:    strings.TrimSpace(reason),
+		CreatedAt: time.Now().UTC(),
+	}
+	if adminID != uuid.Nil {
+		ban.CreatedBy = &adminID
+	}
+	if err := uc.repo.BanHandle(ctx, ban); err != nil {
+		return entity.BannedHandle{}, err
+	}
+	uc.logger.Info("Handle banned", "handle", ban.Handle, "tenant", ban.Tenant, "admin_id", adminID)
+	return ban, nil
+}
+
+// Unban lifts the ban of the handle on the tenant.
+func (uc *HandleUsecase) Unban(ctx context.Context, tenant, handle string) error {
+	err := uc.repo.UnbanHandle(ctx, strings.ToLower(strings.TrimSpace(tenant)), normalizeHandle(handle))
+	if err != nil {
+		return err
+	}
+	uc.logger.Info("Handle unbanned", "handle", handle, "tenant", tenant)
+	return nil
+}
+
+// List returns the bans of the tenant, the bans of every domain for the empty tenant.
+func (uc *HandleUsecase) List(ctx context.Context, tenant string) ([]entity.BannedHandle, error) {
+	return uc.repo.ListBannedHandles(ctx, strings.ToLower(strings.TrimSpace(tenant)))
+}
+
+// normalizeHandle lowercases email addresses and normalizes usernames, see Normalize.
+func normalizeHandle(handle string) string {
+	if strings.Contains(handle, "@") {
+		return strings.ToLower(strings.TrimSpace(handle))
+	}
+	return Normalize(handle)
+}
+
+// tenantOf returns the host of the tenant domain of the request, empty on the default domain.
+func tenantOf(ctx context.Context) string {
+	if tenant, ok := ctxUtil.TenantFromContext(ctx); ok {
+		return strings.ToLower(tenant.Host)
+	}
+	return ""
+}
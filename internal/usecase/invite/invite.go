@@ -0,0 +1,96 @@
+package invite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"main/domain/entity"
+	"main/pkg/customerrors"
+	"main/pkg/utils"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// maxUsesLimit bounds how many registrations a single invitation can admit.
+const maxUsesLimit = 1000
+
+// InviteRepo defines the interface for invitation storage.
+type InviteRepo interface {
+	// StoreInvitation saves the invitation with the hash of its code.
+	StoreInvitation(ctx context.Context, inv entity.Invitation, codeHash []byte) error
+
+	// ListInvitations returns the invitations that can still be used.
+	ListInvitations(ctx context.Context) ([]entity.Invitation, error)
+
+	// RevokeInvitation makes the invitation unusable.
+	RevokeInvitation(ctx context.Context, id uuid.UUID) error
+}
+
+// InviteUsecase manages the invite codes required to register while registration is invite-only.
+// Codes are consumed by AuthUsecase.RegisterUser.
+type InviteUsecase struct {
+	inviteRepo InviteRepo
+	logger     *slog.Logger
+	// defaultTTL applies when no TTL is given, maxTTL caps the lifetime an administrator can give an invitation
+	defaultTTL time.Duration
+	maxTTL     time.Duration
+}
+
+func NewInviteUsecase(inviteRepo InviteRepo, logger *slog.Logger, defaultTTL, maxTTL time.Duration) *InviteUsecase {
+	return &InviteUsecase{
+		inviteRepo: inviteRepo,
+		logger:     logger,
+		defaultTTL: defaultTTL,
+		maxTTL:     maxTTL,
+	}
+}
+
+// CreateInvite creates an invitation for maxUses registrations (1 for single-use) valid for ttl,
+// the default TTL when zero. The returned code is not stored and cannot be shown again.
+func (uc *InviteUsecase) CreateInvite(ctx context.Context, adminID uuid.UUID, maxUses int, ttl time.Duration) (string, entity.Invitation, error) {
+	if ttl == 0 {
+		ttl = uc.defaultTTL
+	}
+	if maxUses < 1 || maxUses > maxUsesLimit || ttl <= 0 || ttl > uc.maxTTL {
+		return "", entity.Invitation{}, customerrors.ErrInvalidInvitation
+	}
+
+	code, err := utils.GenerateToken(16)
+	if err != nil {
+		return "", entity.Invitation{}, err
+	}
+	now := time.Now()
+	inv := entity.Invitation{
+		ID:        uuid.New(),
+		MaxUses:   maxUses,
+		ExpiresAt: now.Add(ttl),
+		CreatedBy: adminID,
+		CreatedAt: now,
+	}
+	if err := uc.inviteRepo.StoreInvitation(ctx, inv, utils.HashToken(code)); err != nil {
+		return "", entity.Invitation{}, fmt.Errorf("failed to store invitation: %w", err)
+	}
+
+	uc.logger.Info("Invitation created", "admin_id", adminID, "invitation_id", inv.ID, "max_uses", maxUses, "expires_at", inv.ExpiresAt)
+	return code, inv, nil
+}
+
+// ListInvites returns the invitations that can still be used.
+func (uc *InviteUsecase) ListInvites(ctx context.Context) ([]entity.Invitation, error) {
+	return uc.inviteRepo.ListInvitations(ctx)
+}
+
+// RevokeInvite makes the invitation unusable, accounts already registered with it are kept.
+func (uc *InviteUsecase) RevokeInvite(ctx context.Context, adminID, id uuid.UUID) error {
+	err := uc.inviteRepo.RevokeInvitation(ctx, id)
+	if errors.Is(err, customerrors.ErrNoTagsAffected) {
+		return customerrors.ErrInvalidInvite
+	}
+	if err != nil {
+		return err
+	}
+	uc.logger.Info("Invitation revoked", "admin_id", adminID, "invitation_id", id)
+	return nil
+}
@@ -0,0 +1,134 @@
+package oauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"main/domain/entity"
+	"main/pkg/customerrors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// GrantTypeClientCredentials is the only grant supported by the token endpoint for now.
+const GrantTypeClientCredentials = "client_credentials"
+
+// ClientRepo defines the interface for service client storage.
+type ClientRepo interface {
+	// GetClientByID retrieves a service client by its client_id.
+	GetClientByID(ctx context.Context, clientID string) (entity.Client, error)
+}
+
+// JWTManager defines the interface for issuing machine tokens.
+type JWTManager interface {
+	NewServiceToken(clientID string, scopes []string, ttl time.Duration) (string, error)
+	VerifyServiceToken(token string) (clientID string, scopes []string, err error)
+
+	// WrappedKeySet returns the token verification keys encrypted to the recipient, to be fetched again after refreshIn.
+	WrappedKeySet(recipient *jose.JSONWebKey, refreshIn time.Duration) (string, error)
+}
+
+// KeyDistribution lets services fetch the token verification keys to verify tokens themselves, see VerificationKeys.
+type KeyDistribution struct {
+	Enabled bool
+	// Scope is required in the service token of the request
+	Scope string
+	// RefreshIn is how long services cache the keys before fetching them again
+	RefreshIn time.Duration
+}
+
+type OAuthUsecase struct {
+	clientRepo ClientRepo
+	JWTManager JWTManager
+	keys       KeyDistribution
+}
+
+func NewOAuthUsecase(clientRepo ClientRepo, JWTManager JWTManager, keys KeyDistribution) *OAuthUsecase {
+	return &OAuthUsecase{
+		clientRepo: clientRepo,
+		JWTManager: JWTManager,
+		keys:       keys,
+	}
+}
+
+// IssueClientToken authenticates a service client and issues a machine access token (client_credentials grant).
+// If scope is empty, all scopes registered for the client are granted, otherwise the requested
+// space-separated scopes must be a subset of them. Returns the token, its lifetime and the granted scopes.
+func (uc *OAuthUsecase) IssueClientToken(ctx context.Context,
+	grantType,
+	clientID,
+	clientSecret,
+	scope string) (string, time.Duration, []string, error) {
+
+	if grantType != GrantTypeClientCredentials {
+		return "", 0, nil, customerrors.ErrUnsupportedGrantType
+	}
+	if clientID == "" || clientSecret == "" {
+		return "", 0, nil, customerrors.ErrInvalidClient
+	}
+
+	client, err := uc.clientRepo.GetClientByID(ctx, clientID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", 0, nil, customerrors.ErrInvalidClient
+		}
+		return "", 0, nil, err
+	}
+	if client.IsDisabled {
+		return "", 0, nil, customerrors.ErrInvalidClient
+	}
+	if bcrypt.CompareHashAndPassword([]byte(client.SecretHash), []byte(clientSecret)) != nil {
+		return "", 0, nil, customerrors.ErrInvalidClient
+	}
+
+	granted := client.Scopes
+	if requested := strings.Fields(scope); len(requested) > 0 {
+		for _, s := range requested {
+			if !slices.Contains(client.Scopes, s) {
+				return "", 0, nil, customerrors.ErrInvalidScope
+			}
+		}
+		granted = requested
+	}
+
+	token, err := uc.JWTManager.NewServiceToken(client.ID, granted, client.TokenTTL)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	return token, client.TokenTTL, granted, nil
+}
+
+// VerifyClient checks the provided machine token and returns the client ID and its granted scopes.
+func (uc *OAuthUsecase) VerifyClient(token string) (clientID string, scopes []string, err error) {
+	return uc.JWTManager.VerifyServiceToken(token)
+}
+
+// VerificationKeys returns the keys verifying access and service tokens to a service client holding the key
+// distribution scope, wrapped for the public key (a P-256 JWK) the client generated for the request. Services
+// verifying tokens with them do not see revocations before the tokens expire.
+func (uc *OAuthUsecase) VerificationKeys(token string, publicKey json.RawMessage) (string, error) {
+	if !uc.keys.Enabled {
+		return "", customerrors.ErrKeyDistributionDisabled
+	}
+	_, scopes, err := uc.JWTManager.VerifyServiceToken(token)
+	if err != nil {
+		return "", customerrors.ErrInvalidClient
+	}
+	if !slices.Contains(scopes, uc.keys.Scope) {
+		return "", customerrors.ErrInsufficientScope
+	}
+	var recipient jose.JSONWebKey
+	if err := json.Unmarshal(publicKey, &recipient); err != nil || !recipient.Valid() || !recipient.IsPublic() {
+		return "", customerrors.ErrInvalidRecipientKey
+	}
+	if _, ok := recipient.Key.(*ecdsa.PublicKey); !ok {
+		return "", customerrors.ErrInvalidRecipientKey
+	}
+	return uc.JWTManager.WrappedKeySet(&recipient, uc.keys.RefreshIn)
+}
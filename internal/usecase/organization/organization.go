@@ -0,0 +1,273 @@
+package organization
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"main/domain/entity"
+	"main/pkg/customerrors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// OrgRepo defines the interface for organization storage. Lifecycle changes record their admin action
+// in the same transaction.
+type OrgRepo interface {
+	// CreateOrganization stores a new organization.
+	CreateOrganization(ctx context.Context, org entity.Organization, action entity.AdminAction) error
+
+	// GetOrganization returns the organization with its members, pgx.ErrNoRows if it does not exist.
+	GetOrganization(ctx context.Context, id uuid.UUID) (entity.OrganizationDetail, error)
+
+	// ListOrganizations returns a page of organizations, newest first.
+	ListOrganizations(ctx context.Context, limit, offset int) ([]entity.Organization, error)
+
+	// SuspendOrganization suspends the organization and revokes the access tokens of its members.
+	SuspendOrganization(ctx context.Context, action entity.AdminAction) error
+
+	// ResumeOrganization reactivates a suspended organization.
+	ResumeOrganization(ctx context.Context, action entity.AdminAction) error
+
+	// DeleteOrganization marks the organization as deleted, schedules its purge and revokes the access tokens of
+	// its members.
+	DeleteOrganization(ctx context.Context, action entity.AdminAction, purgeAt time.Time) error
+
+	// AddMember adds the user to the organization.
+	AddMember(ctx context.Context, orgID, userID uuid.UUID) error
+
+	// RemoveMember removes the user from the organization.
+	RemoveMember(ctx context.Context, orgID, userID uuid.UUID) error
+
+	// PurgeDeletedOrganizations permanently deletes the organizations whose purge time is before the given time.
+	PurgeDeletedOrganizations(ctx context.Context, before time.Time) ([]uuid.UUID, error)
+
+	// ListDeletedOrganizations returns the organizations PurgeDeletedOrganizations would delete.
+	ListDeletedOrganizations(ctx context.Context, before time.Time) ([]uuid.UUID, error)
+}
+
+// TokenInvalidator publishes the token versions of users whose access tokens were revoked in the database.
+type TokenInvalidator interface {
+	Invalidated(ctx context.Context, userIDs ...uuid.UUID) error
+}
+
+// SessionRemover ends the sessions of users in the session store, Postgres or Redis.
+type SessionRemover interface {
+	// RemoveUserSessions deletes all sessions of the users, whose token versions are incremented already, and
+	// returns their IDs.
+	RemoveUserSessions(ctx context.Context, userIDs []uuid.UUID) ([]uuid.UUID, error)
+}
+
+// OrgUsecase manages the lifecycle of organizations (tenants). Suspending or deleting an organization
+// logs out all its members, deleted organizations are purged by a background job after a delay.
+type OrgUsecase struct {
+	orgRepo  OrgRepo
+	tokens   TokenInvalidator
+	sessions SessionRemover
+	logger   *slog.Logger
+	// purgeDelay is how long a deleted organization is kept before its data is purged
+	purgeDelay time.Duration
+}
+
+func NewOrgUsecase(orgRepo OrgRepo, tokens TokenInvalidator, sessions SessionRemover, logger *slog.Logger,
+	purgeDelay time.Duration) *OrgUsecase {
+	return &OrgUsecase{
+		orgRepo:    orgRepo,
+		tokens:     tokens,
+		sessions:   sessions,
+		logger:     logger,
+		purgeDelay: purgeDelay,
+	}
+}
+
+// Page size limits of ListOrganizations.
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+	maxNameLength   = 255
+)
+
+// CreateOrganization creates an active organization without members.
+func (uc *OrgUsecase) CreateOrganization(ctx context.Context, adminID uuid.UUID, name string) (entity.Organization, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > maxNameLength {
+		return entity.Organization{}, customerrors.ErrInvalidOrganizationName
+	}
+	now := time.Now()
+	org := entity.Organization{
+		ID:        uuid.New(),
+		Name:      name,
+		Status:    entity.OrgActive,
+		CreatedAt: now,
+	}
+	err := uc.orgRepo.CreateOrganization(ctx, org, orgAction(adminID, org.ID, entity.AdminActionOrgCreate, entity.AdminReason{}))
+	if err != nil {
+		return entity.Organization{}, err
+	}
+	uc.logger.Info("Organization created", "admin_id", adminID, "organization_id", org.ID)
+	return org, nil
+}
+
+// GetOrganization returns the organization with its members.
+func (uc *OrgUsecase) GetOrganization(ctx context.Context, id uuid.UUID) (entity.OrganizationDetail, error) {
+	detail, err := uc.orgRepo.GetOrganization(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return entity.OrganizationDetail{}, customerrors.ErrOrganizationNotFound
+	}
+	return detail, err
+}
+
+// ListOrganizations returns a page of organizations. The limit defaults to 50 and is capped at 200.
+func (uc *OrgUsecase) ListOrganizations(ctx context.Context, limit, offset int) ([]entity.Organization, error) {
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	return uc.orgRepo.ListOrganizations(ctx, min(limit, maxPageSize), max(offset, 0))
+}
+
+// SuspendOrganization suspends an active organization: the sessions and access tokens of all its members
+// are revoked. A reason is required.
+func (uc *OrgUsecase) SuspendOrganization(ctx context.Context, adminID, id uuid.UUID, reason entity.AdminReason) (entity.AffectedReport, error) {
+	if !reason.Valid() {
+		return entity.AffectedReport{}, customerrors.ErrReasonRequired
+	}
+	if err := uc.orgRepo.SuspendOrganization(ctx, orgAction(adminID, id, entity.AdminActionOrgSuspend, reason)); err != nil {
+		return entity.AffectedReport{}, orgError(err)
+	}
+	ids := uc.endMemberSessions(ctx, id)
+	uc.logger.Info("Organization suspended", "admin_id", adminID, "organization_id", id,
+		"sessions", len(ids), "reason_code", reason.Code, "reason", reason.Text)
+	return entity.AffectedReport{Count: len(ids), IDs: ids}, nil
+}
+
+// ResumeOrganization reactivates a suspended organization, its members can log in again.
+func (uc *OrgUsecase) ResumeOrganization(ctx context.Context, adminID, id uuid.UUID) error {
+	if err := uc.orgRepo.ResumeOrganization(ctx, orgAction(adminID, id, entity.AdminActionOrgResume, entity.AdminReason{})); err != nil {
+		return orgError(err)
+	}
+	uc.logger.Info("Organization resumed", "admin_id", adminID, "organization_id", id)
+	return nil
+}
+
+// DeleteOrganization deletes an active or suspended organization: the sessions and access tokens of all its
+// members are revoked and its data is purged once the purge delay is over. A reason is required.
+func (uc *OrgUsecase) DeleteOrganization(ctx context.Context, adminID, id uuid.UUID, reason entity.AdminReason) (entity.AffectedReport, error) {
+	if !reason.Valid() {
+		return entity.AffectedReport{}, customerrors.ErrReasonRequired
+	}
+	purgeAt := time.Now().Add(uc.purgeDelay)
+	if err := uc.orgRepo.DeleteOrganization(ctx, orgAction(adminID, id, entity.AdminActionOrgDelete, reason), purgeAt); err != nil {
+		return entity.AffectedReport{}, orgError(err)
+	}
+	ids := uc.endMemberSessions(ctx, id)
+	uc.logger.Info("Organization deleted", "admin_id", adminID, "organization_id", id, "purge_at", purgeAt,
+		"sessions", len(ids), "reason_code", reason.Code, "reason", reason.Text)
+	return entity.AffectedReport{Count: len(ids), IDs: ids}, nil
+}
+
+// endMemberSessions publishes the token versions the repository incremented for the members of the organization
+// and deletes their sessions from the session store, it returns the IDs of the deleted sessions. The tokens are
+// revoked already, a failure only delays the revocation until the cached versions expire and leaves sessions that
+// cannot be refreshed.
+func (uc *OrgUsecase) endMemberSessions(ctx context.Context, id uuid.UUID) []uuid.UUID {
+	detail, err := uc.orgRepo.GetOrganization(ctx, id)
+	if err != nil {
+		uc.logger.Error("Failed to read the members of the organization", "organization_id", id, "error", err)
+		return nil
+	}
+	userIDs := make([]uuid.UUID, 0, len(detail.Members))
+	for _, m := range detail.Members {
+		userIDs = append(userIDs, m.UserID)
+	}
+	if err := uc.tokens.Invalidated(ctx, userIDs...); err != nil {
+		uc.logger.Error("Failed to publish token versions of members", "organization_id", id, "error", err)
+	}
+	ids, err := uc.sessions.RemoveUserSessions(ctx, userIDs)
+	if err != nil {
+		uc.logger.Error("Failed to delete the sessions of members", "organization_id", id, "error", err)
+	}
+	return ids
+}
+
+// AddMember adds the user to an active organization.
+func (uc *OrgUsecase) AddMember(ctx context.Context, adminID, id, userID uuid.UUID) error {
+	if err := uc.orgRepo.AddMember(ctx, id, userID); err != nil {
+		return orgError(err)
+	}
+	uc.logger.Info("Organization member added", "admin_id", adminID, "organization_id", id, "user_id", userID)
+	return nil
+}
+
+// RemoveMember removes the user from the organization, its sessions are kept.
+func (uc *OrgUsecase) RemoveMember(ctx context.Context, adminID, id, userID uuid.UUID) error {
+	err := uc.orgRepo.RemoveMember(ctx, id, userID)
+	if errors.Is(err, customerrors.ErrNoTagsAffected) {
+		return customerrors.ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	uc.logger.Info("Organization member removed", "admin_id", adminID, "organization_id", id, "user_id", userID)
+	return nil
+}
+
+// PurgeDeletedOrganizations permanently deletes all organizations whose purge delay is over.
+// In dry-run mode it only reports the organizations that would be deleted.
+func (uc *OrgUsecase) PurgeDeletedOrganizations(ctx context.Context, dryRun bool) (entity.AffectedReport, error) {
+	purge := uc.orgRepo.PurgeDeletedOrganizations
+	if dryRun {
+		purge = uc.orgRepo.ListDeletedOrganizations
+	}
+	ids, err := purge(ctx, time.Now())
+	if err != nil {
+		return entity.AffectedReport{}, err
+	}
+	return entity.AffectedReport{DryRun: dryRun, Count: len(ids), IDs: ids}, nil
+}
+
+// RunPurgeJob purges deleted organizations every interval until the context is cancelled.
+// In dry-run mode the job only logs the organizations it would delete.
+func (uc *OrgUsecase) RunPurgeJob(ctx context.Context, interval time.Duration, dryRun bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := uc.PurgeDeletedOrganizations(ctx, dryRun)
+			if err != nil {
+				uc.logger.Error("Failed to purge deleted organizations", "error", err)
+				continue
+			}
+			if report.Count > 0 {
+				uc.logger.Info("Purged deleted organizations", "count", report.Count, "organization_ids", report.IDs, "dry_run", dryRun)
+			}
+		}
+	}
+}
+
+// orgAction is the audit entry of a lifecycle action on the organization.
+func orgAction(adminID, orgID uuid.UUID, action string, reason entity.AdminReason) entity.AdminAction {
+	return entity.AdminAction{
+		ID:         uuid.New(),
+		ActorID:    adminID,
+		Action:     action,
+		TargetType: entity.AdminTargetOrganization,
+		TargetID:   orgID,
+		Reason:     reason,
+		CreatedAt:  time.Now(),
+	}
+}
+
+// orgError maps a missing organization to customerrors.ErrOrganizationNotFound.
+func orgError(err error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return customerrors.ErrOrganizationNotFound
+	}
+	return err
+}
@@ -0,0 +1,106 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"main/domain/entity"
+	"main/pkg/customerrors"
+	"math/rand/v2"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RBACRepo defines the interface for role and permission storage.
+type RBACRepo interface {
+	// GetUserRoles returns the roles granted to the user with their permissions.
+	GetUserRoles(ctx context.Context, userID uuid.UUID) ([]entity.Role, error)
+}
+
+// DecisionLog logs sampled authorization decisions with their subject, resource, action, the role that granted
+// the permission and the latency of the check. A nil Logger disables it.
+type DecisionLog struct {
+	Logger *slog.Logger
+	// AllowSampleRate and DenySampleRate are the fractions of allowed and denied decisions that are logged,
+	// failed checks are logged like denials
+	AllowSampleRate float64
+	DenySampleRate  float64
+}
+
+type RBACUsecase struct {
+	rbacRepo  RBACRepo
+	decisions DecisionLog
+}
+
+func NewRBACUsecase(rbacRepo RBACRepo, decisions DecisionLog) *RBACUsecase {
+	return &RBACUsecase{
+		rbacRepo:  rbacRepo,
+		decisions: decisions,
+	}
+}
+
+// Authorize returns nil if the user has the permission, customerrors.ErrForbidden if not. resource is the route
+// or method being accessed, it is only logged.
+// Permissions are read on every check, so revoking a role takes effect immediately.
+func (uc *RBACUsecase) Authorize(ctx context.Context, userID uuid.UUID, permission entity.Permission, resource string) error {
+	start := time.Now()
+	roles, err := uc.rbacRepo.GetUserRoles(ctx, userID)
+	if err != nil {
+		uc.logDecision(ctx, userID, permission, resource, "", start, err)
+		return err
+	}
+	i := slices.IndexFunc(roles, func(role entity.Role) bool {
+		return slices.Contains(role.Permissions, string(permission))
+	})
+	if i < 0 {
+		uc.logDecision(ctx, userID, permission, resource, "", start, customerrors.ErrForbidden)
+		return customerrors.ErrForbidden
+	}
+	uc.logDecision(ctx, userID, permission, resource, roles[i].Name, start, nil)
+	return nil
+}
+
+// UserRoles returns the names of the roles granted to the user.
+func (uc *RBACUsecase) UserRoles(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	roles, err := uc.rbacRepo.GetUserRoles(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(roles))
+	for _, role := range roles {
+		names = append(names, role.Name)
+	}
+	return names, nil
+}
+
+// logDecision logs the decision if it is sampled. matchedRole is the first role holding the permission,
+// empty for denials.
+func (uc *RBACUsecase) logDecision(ctx context.Context, userID uuid.UUID, permission entity.Permission, resource,
+	matchedRole string, start time.Time, err error) {
+	if uc.decisions.Logger == nil {
+		return
+	}
+	rate, decision := uc.decisions.AllowSampleRate, "allow"
+	if err != nil {
+		rate, decision = uc.decisions.DenySampleRate, "deny"
+	}
+	if rate <= 0 || (rate < 1 && rand.Float64() >= rate) {
+		return
+	}
+	attrs := []slog.Attr{
+		slog.String("decision", decision),
+		slog.String("subject", userID.String()),
+		slog.String("resource", resource),
+		slog.String("action", string(permission)),
+		slog.Duration("latency", time.Since(start)),
+	}
+	if matchedRole != "" {
+		attrs = append(attrs, slog.String("matched_rule", "role:"+matchedRole))
+	}
+	if err != nil && !errors.Is(err, customerrors.ErrForbidden) {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	uc.decisions.Logger.LogAttrs(ctx, slog.LevelInfo, "Authorization decision", attrs...)
+}
@@ -0,0 +1,60 @@
+package stats
+
+import (
+	"context"
+	"main/domain/entity"
+	"time"
+)
+
+// StatsRepo counts what the statistics report.
+type StatsRepo interface {
+	// Stats counts the users, issued tokens and denylist entries as of the given time.
+	Stats(ctx context.Context, now time.Time) (entity.SystemStats, error)
+}
+
+// SessionCounter counts the sessions of the session store, Postgres or Redis.
+type SessionCounter interface {
+	// SessionStats counts the unexpired sessions and the sessions started in the last hour and day as of now.
+	SessionStats(ctx context.Context, now time.Time) (entity.SessionStats, error)
+}
+
+// Denylist is the session denylist of this instance, implemented by auth.SessionDenylist.
+type Denylist interface {
+	// Size returns the number of unexpired entries held in memory.
+	Size() int
+}
+
+// StatsUsecase reports the counts and growth rates capacity dashboards and autoscalers need, without ad-hoc
+// queries on the database.
+type StatsUsecase struct {
+	repo     StatsRepo
+	sessions SessionCounter
+	denylist Denylist
+}
+
+func NewStatsUsecase(repo StatsRepo, sessions SessionCounter, denylist Denylist) *StatsUsecase {
+	return &StatsUsecase{
+		repo:     repo,
+		sessions: sessions,
+		denylist: denylist,
+	}
+}
+
+// Stats returns the current statistics. The rates are averages: users and sessions per hour over the last day,
+// tokens per minute over the last hour.
+func (uc *StatsUsecase) Stats(ctx context.Context) (entity.SystemStats, error) {
+	now := time.Now().UTC()
+	stats, err := uc.repo.Stats(ctx, now)
+	if err != nil {
+		return entity.SystemStats{}, err
+	}
+	if stats.Sessions, err = uc.sessions.SessionStats(ctx, now); err != nil {
+		return entity.SystemStats{}, err
+	}
+	stats.Users.CreatedPerHour = float64(stats.Users.CreatedLastDay) / 24
+	stats.Sessions.StartedPerHour = float64(stats.Sessions.StartedLastDay) / 24
+	stats.Tokens.IssuedPerMinute = float64(stats.Tokens.IssuedLastHour) / 60
+	stats.Denylist.Cached = int64(uc.denylist.Size())
+	stats.GeneratedAt = now
+	return stats, nil
+}
@@ -0,0 +1,324 @@
+package status
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"main/domain/entity"
+	"main/pkg/customerrors"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// StatusRepo defines the interface for the storage of the incidents of the status page.
+type StatusRepo interface {
+	// CreateIncident stores a new incident.
+	CreateIncident(ctx context.Context, incident entity.Incident) error
+
+	// UpdateIncident changes an unresolved incident and resolves it when resolvedAt is set.
+	UpdateIncident(ctx context.Context, id uuid.UUID, update entity.IncidentUpdate, resolvedAt *time.Time) (entity.Incident, error)
+
+	// GetIncident returns the incident, pgx.ErrNoRows if it does not exist.
+	GetIncident(ctx context.Context, id uuid.UUID) (entity.Incident, error)
+
+	// ListIncidents returns a page of the incidents, the latest start first.
+	ListIncidents(ctx context.Context, limit, offset int) ([]entity.Incident, error)
+
+	// RecentIncidents returns the unresolved incidents and the ones resolved or ended since the given time.
+	RecentIncidents(ctx context.Context, since time.Time, limit int) ([]entity.Incident, error)
+}
+
+// ReadOnlyMode reports whether mutations, logins included, are rejected.
+type ReadOnlyMode interface {
+	Enabled() bool
+}
+
+// Auditor records the changes of the status page in the audit log.
+type Auditor interface {
+	Record(ctx context.Context, event entity.AuditEvent)
+}
+
+// Check probes one dependency, returning nil if it is usable.
+type Check func(ctx context.Context) error
+
+// StatusPolicy configures the status page.
+type StatusPolicy struct {
+	// CacheTTL is how long a computed status is served, the dependencies are probed at most once per TTL
+	// however often the public endpoint is called
+	CacheTTL time.Duration
+	// RecentWindow is how long resolved incidents stay on the page
+	RecentWindow time.Duration
+}
+
+const (
+	// checkTimeout bounds each dependency probe
+	checkTimeout = 2 * time.Second
+	// maxRecentIncidents bounds the incidents on the page
+	maxRecentIncidents = 20
+	maxTitleLength     = 200
+	defaultPageSize    = 50
+	maxPageSize        = 200
+)
+
+// statusRank orders the states of the page, the worst state of its parts is the overall one.
+var statusRank = map[string]int{
+	entity.StatusOperational: 0,
+	entity.StatusDegraded:    1,
+	entity.StatusMaintenance: 2,
+	entity.StatusOutage:      3,
+}
+
+// StatusUsecase summarizes the health of the service for the public status page, from its dependencies, the
+// read-only mode and the incidents administrators annotate it with.
+type StatusUsecase struct {
+	repo     StatusRepo
+	readOnly ReadOnlyMode
+	checks   map[string]Check
+	audit    Auditor
+	logger   *slog.Logger
+	policy   StatusPolicy
+
+	// mu serializes the computation of the status, concurrent requests wait for one probe of the dependencies
+	mu       sync.Mutex
+	cached   entity.SystemStatus
+	cachedAt time.Time
+}
+
+func NewStatusUsecase(repo StatusRepo, readOnly ReadOnlyMode, checks map[string]Check, audit Auditor, logger *slog.Logger,
+	policy StatusPolicy) *StatusUsecase {
+	return &StatusUsecase{
+		repo:     repo,
+		readOnly: readOnly,
+		checks:   checks,
+		audit:    audit,
+		logger:   logger,
+		policy:   policy,
+	}
+}
+
+// Status returns the status of the service, computed at most once per cache TTL. When the incidents cannot be
+// read, the ones read last are shown.
+func (uc *StatusUsecase) Status(ctx context.Context) entity.SystemStatus {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+	now := time.Now().UTC()
+	if !uc.cachedAt.IsZero() && now.Sub(uc.cachedAt) < uc.policy.CacheTTL {
+		return uc.cached
+	}
+
+	status := entity.SystemStatus{
+		Components: uc.probe(ctx),
+		Incidents:  uc.cached.Incidents,
+		UpdatedAt:  now,
+	}
+	incidents, err := uc.repo.RecentIncidents(ctx, now.Add(-uc.policy.RecentWindow), maxRecentIncidents)
+	if err != nil {
+		uc.logger.Error("Failed to read the status incidents", "error", err)
+	} else {
+		status.Incidents = incidents
+	}
+	if status.Incidents == nil {
+		status.Incidents = []entity.Incident{}
+	}
+
+	status.Maintenance.ReadOnly = uc.readOnly.Enabled()
+	status.Maintenance.Active = status.Maintenance.ReadOnly
+	status.Status = entity.StatusOperational
+	worsen := func(state string) {
+		if statusRank[state] > statusRank[status.Status] {
+			status.Status = state
+		}
+	}
+	for _, state := range status.Components {
+		worsen(state)
+	}
+	for _, incident := range status.Incidents {
+		if !incident.Active(now) {
+			continue
+		}
+		switch {
+		case incident.Kind == entity.IncidentKindMaintenance:
+			status.Maintenance.Active = true
+			if incident.EndsAt != nil && (status.Maintenance.Until == nil || incident.EndsAt.After(*status.Maintenance.Until)) {
+				status.Maintenance.Until = incident.EndsAt
+			}
+		case incident.Severity == entity.IncidentSeverityMajor:
+			worsen(entity.StatusOutage)
+		default:
+			worsen(entity.StatusDegraded)
+		}
+	}
+	if status.Maintenance.Active {
+		worsen(entity.StatusMaintenance)
+	}
+
+	uc.cached, uc.cachedAt = status, now
+	return status
+}
+
+// probe runs all checks concurrently and returns the state of each dependency. Errors are logged, the page
+// only tells which dependency is out.
+func (uc *StatusUsecase) probe(ctx context.Context) map[string]string {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	states := make(map[string]string, len(uc.checks))
+	for name, check := range uc.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			probeCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+			state := entity.StatusOperational
+			if err := check(probeCtx); err != nil {
+				uc.logger.Warn("Status check failed", "component", name, "error", err)
+				state = entity.StatusOutage
+			}
+			mu.Lock()
+			states[name] = state
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return states
+}
+
+// CreateIncident publishes an incident or an announced maintenance on the status page. A zero start is now,
+// a start in the future schedules it. The severity of a maintenance defaults to minor.
+func (uc *StatusUsecase) CreateIncident(ctx context.Context, incident entity.Incident, adminID uuid.UUID) (entity.Incident, error) {
+	now := time.Now().UTC()
+	incident.ID = uuid.New()
+	incident.Title = strings.TrimSpace(incident.Title)
+	incident.Message = strings.TrimSpace(incident.Message)
+	if incident.Severity == "" && incident.Kind == entity.IncidentKindMaintenance {
+		incident.Severity = entity.IncidentSeverityMinor
+	}
+	if incident.StartsAt.IsZero() {
+		incident.StartsAt = now
+	}
+	incident.ResolvedAt = nil
+	incident.CreatedBy = &adminID
+	incident.CreatedAt, incident.UpdatedAt = now, now
+	if err := validIncident(incident); err != nil {
+		return entity.Incident{}, err
+	}
+
+	if err := uc.repo.CreateIncident(ctx, incident); err != nil {
+		return entity.Incident{}, err
+	}
+	uc.logger.Info("Status incident created", "admin_id", adminID, "incident_id", incident.ID, "kind", incident.Kind,
+		"severity", incident.Severity)
+	uc.changed(ctx, entity.AdminActionIncidentCreate, adminID, incident)
+	return incident, nil
+}
+
+// UpdateIncident changes the severity, title, message or expected end of an unresolved incident.
+func (uc *StatusUsecase) UpdateIncident(ctx context.Context, id uuid.UUID, update entity.IncidentUpdate, adminID uuid.UUID) (entity.Incident, error) {
+	current, err := uc.unresolved(ctx, id)
+	if err != nil {
+		return entity.Incident{}, err
+	}
+	if update.Title != nil {
+		title := strings.TrimSpace(*update.Title)
+		update.Title, current.Title = &title, title
+	}
+	if update.Message != nil {
+		message := strings.TrimSpace(*update.Message)
+		update.Message = &message
+	}
+	if update.Severity != nil {
+		current.Severity = *update.Severity
+	}
+	if update.EndsAt != nil {
+		current.EndsAt = update.EndsAt
+	}
+	if err := validIncident(current); err != nil {
+		return entity.Incident{}, err
+	}
+
+	incident, err := uc.repo.UpdateIncident(ctx, id, update, nil)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// resolved meanwhile
+		return entity.Incident{}, customerrors.ErrIncidentResolved
+	}
+	if err != nil {
+		return entity.Incident{}, err
+	}
+	uc.logger.Info("Status incident updated", "admin_id", adminID, "incident_id", id)
+	uc.changed(ctx, entity.AdminActionIncidentUpdate, adminID, incident)
+	return incident, nil
+}
+
+// ResolveIncident ends an incident, it stays on the status page for the recent window.
+func (uc *StatusUsecase) ResolveIncident(ctx context.Context, id, adminID uuid.UUID) (entity.Incident, error) {
+	if _, err := uc.unresolved(ctx, id); err != nil {
+		return entity.Incident{}, err
+	}
+	now := time.Now().UTC()
+	incident, err := uc.repo.UpdateIncident(ctx, id, entity.IncidentUpdate{}, &now)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return entity.Incident{}, customerrors.ErrIncidentResolved
+	}
+	if err != nil {
+		return entity.Incident{}, err
+	}
+	uc.logger.Info("Status incident resolved", "admin_id", adminID, "incident_id", id)
+	uc.changed(ctx, entity.AdminActionIncidentResolve, adminID, incident)
+	return incident, nil
+}
+
+// ListIncidents returns a page of all incidents, for administrators. The limit defaults to 50 and is capped at 200.
+func (uc *StatusUsecase) ListIncidents(ctx context.Context, limit, offset int) ([]entity.Incident, error) {
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	return uc.repo.ListIncidents(ctx, min(limit, maxPageSize), max(offset, 0))
+}
+
+// unresolved returns the incident, customerrors.ErrIncidentNotFound or ErrIncidentResolved when it cannot be changed.
+func (uc *StatusUsecase) unresolved(ctx context.Context, id uuid.UUID) (entity.Incident, error) {
+	incident, err := uc.repo.GetIncident(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return entity.Incident{}, customerrors.ErrIncidentNotFound
+	}
+	if err != nil {
+		return entity.Incident{}, err
+	}
+	if incident.ResolvedAt != nil {
+		return entity.Incident{}, customerrors.ErrIncidentResolved
+	}
+	return incident, nil
+}
+
+// changed records the change in the audit log and drops the cached status, so this instance shows it right away.
+func (uc *StatusUsecase) changed(ctx context.Context, action string, adminID uuid.UUID, incident entity.Incident) {
+	uc.audit.Record(ctx, entity.AuditEvent{
+		Action:     action,
+		ActorID:    &adminID,
+		TargetType: entity.AdminTargetIncident,
+		TargetID:   &incident.ID,
+		Details: map[string]string{
+			"kind":     string(incident.Kind),
+			"severity": string(incident.Severity),
+			"title":    incident.Title,
+		},
+	})
+	uc.mu.Lock()
+	uc.cachedAt = time.Time{}
+	uc.mu.Unlock()
+}
+
+func validIncident(incident entity.Incident) error {
+	switch {
+	case incident.Kind != entity.IncidentKindIncident && incident.Kind != entity.IncidentKindMaintenance,
+		incident.Severity != entity.IncidentSeverityMinor && incident.Severity != entity.IncidentSeverityMajor,
+		incident.Title == "" || utf8.RuneCountInString(incident.Title) > maxTitleLength,
+		incident.EndsAt != nil && !incident.EndsAt.After(incident.StartsAt):
+		return customerrors.ErrInvalidIncident
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package terms
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"main/domain/entity"
+	"main/pkg/customerrors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// TermsRepo defines the interface for the storage of terms documents and their acceptance.
+type TermsRepo interface {
+	// PublishTerms stores a new version of a document.
+	PublishTerms(ctx context.Context, doc entity.TermsDocument) error
+
+	// ListTermsDocuments returns every published version.
+	ListTermsDocuments(ctx context.Context) ([]entity.TermsDocument, error)
+
+	// CurrentTermsDocuments returns the current version of every kind.
+	CurrentTermsDocuments(ctx context.Context) ([]entity.TermsDocument, error)
+
+	// PendingTerms returns the current versions the user still has to accept.
+	PendingTerms(ctx context.Context, userID uuid.UUID) ([]entity.TermsDocument, error)
+
+	// AcceptTerms records that the user accepted the documents.
+	AcceptTerms(ctx context.Context, userID uuid.UUID, documentIDs []uuid.UUID) error
+
+	// TermsAcceptances returns the documents the user accepted.
+	TermsAcceptances(ctx context.Context, userID uuid.UUID) ([]entity.TermsAcceptance, error)
+}
+
+// TermsUsecase publishes versions of the terms of service and the privacy policy and records which
+// version every user accepted and when. Whether logins wait for the acceptance is decided by auth.TermsPolicy.
+type TermsUsecase struct {
+	repo   TermsRepo
+	logger *slog.Logger
+}
+
+func NewTermsUsecase(repo TermsRepo, logger *slog.Logger) *TermsUsecase {
+	return &TermsUsecase{
+		repo:   repo,
+		logger: logger,
+	}
+}
+
+// Publish publishes a new version of a document, it replaces the current version of its kind right away.
+// With requiresAcceptance users must accept it again, otherwise earlier acceptances stay valid.
+func (uc *TermsUsecase) Publish(ctx context.Context, kind entity.TermsKind, version, docURL string, requiresAcceptance bool) (entity.TermsDocument, error) {
+	version = strings.TrimSpace(version)
+	if !kind.Valid() || version == "" || len(version) > 64 {
+		return entity.TermsDocument{}, customerrors.ErrInvalidTermsDocument
+	}
+	if u, err := url.Parse(docURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return entity.TermsDocument{}, customerrors.ErrInvalidTermsDocument
+	}
+	doc := entity.TermsDocument{
+		ID:                 uuid.New(),
+		Kind:               kind,
+		Version:            version,
+		URL:                docURL,
+		PublishedAt:        time.Now().UTC(),
+		RequiresAcceptance: requiresAcceptance,
+	}
+	err := uc.repo.PublishTerms(ctx, doc)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return entity.TermsDocument{}, customerrors.ErrTermsVersionExists
+	}
+	if err != nil {
+		return entity.TermsDocument{}, err
+	}
+	uc.logger.Info("Terms document published", "kind", kind, "version", version, "requires_acceptance", requiresAcceptance)
+	return doc, nil
+}
+
+// ListDocuments returns every published version, for administrators.
+func (uc *TermsUsecase) ListDocuments(ctx context.Context) ([]entity.TermsDocument, error) {
+	return uc.repo.ListTermsDocuments(ctx)
+}
+
+// CurrentDocuments returns the versions in force, the ones to show at registration.
+func (uc *TermsUsecase) CurrentDocuments(ctx context.Context) ([]entity.TermsDocument, error) {
+	return uc.repo.CurrentTermsDocuments(ctx)
+}
+
+// Pending returns the current versions the user has not accepted yet.
+func (uc *TermsUsecase) Pending(ctx context.Context, userID uuid.UUID) ([]entity.TermsDocument, error) {
+	return uc.repo.PendingTerms(ctx, userID)
+}
+
+// Accept records that the user accepted the documents.
+func (uc *TermsUsecase) Accept(ctx context.Context, userID uuid.UUID, documentIDs []uuid.UUID) error {
+	if len(documentIDs) == 0 {
+		return customerrors.ErrInvalidTermsDocument
+	}
+	return uc.repo.AcceptTerms(ctx, userID, documentIDs)
+}
+
+// Acceptances returns the documents the user accepted and when.
+func (uc *TermsUsecase) Acceptances(ctx context.Context, userID uuid.UUID) ([]entity.TermsAcceptance, error) {
+	return uc.repo.TermsAcceptances(ctx, userID)
+}
@@ -0,0 +1,136 @@
+package verification
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"main/domain/entity"
+	"main/pkg/customerrors"
+	"main/pkg/emailnorm"
+	"main/pkg/locale"
+	"main/pkg/utils"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// VerificationRepo defines the interface for email verification token storage.
+type VerificationRepo interface {
+	// StoreEmailVerification saves the hash of a verification token issued for the user's email.
+	StoreEmailVerification(ctx context.Context, tokenHash []byte, userID uuid.UUID, email string, expiresAt time.Time) error
+
+	// ConsumeEmailVerification deletes the token and marks the email verified, returns pgx.ErrNoRows for unknown or expired tokens.
+	ConsumeEmailVerification(ctx context.Context, tokenHash []byte) (uuid.UUID, error)
+}
+
+// UserRepo defines the user lookups needed to resend verification emails.
+type UserRepo interface {
+	GetUserByEmail(ctx context.Context, canonicalEmail string) (entity.User, error)
+}
+
+// Mailer sends emails to users.
+type Mailer interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+type VerificationUsecase struct {
+	verificationRepo VerificationRepo
+	userRepo         UserRepo
+	mailer           Mailer
+	logger           *slog.Logger
+	tokenTTL         time.Duration
+	verifyURL        string
+	emails           emailnorm.Normalizer
+}
+
+func NewVerificationUsecase(
+	verificationRepo VerificationRepo,
+	userRepo UserRepo,
+	mailer Mailer,
+	logger *slog.Logger,
+	tokenTTL time.Duration,
+	verifyURL string,
+	emails emailnorm.Normalizer) *VerificationUsecase {
+	return &VerificationUsecase{
+		verificationRepo: verificationRepo,
+		userRepo:         userRepo,
+		mailer:           mailer,
+		logger:           logger,
+		tokenTTL:         tokenTTL,
+		verifyURL:        verifyURL,
+		emails:           emails,
+	}
+}
+
+// SendVerification issues a single-use verification token for the email and mails the link to it.
+// New accounts have no locale yet, the email is in English.
+func (uc *VerificationUsecase) SendVerification(ctx context.Context, userID uuid.UUID, email string) error {
+	return uc.sendVerification(ctx, entity.User{ID: userID, Email: email})
+}
+
+// sendVerification mails the verification link in the language and time zone of the user.
+func (uc *VerificationUsecase) sendVerification(ctx context.Context, user entity.User) error {
+	token, err := utils.GenerateToken(32)
+	if err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().Add(uc.tokenTTL)
+	err = uc.verificationRepo.StoreEmailVerification(ctx, utils.HashToken(token), user.ID, user.Email, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	link := uc.verifyURL + "?token=" + url.QueryEscape(token)
+	body := locale.T(user.Locale, "verify_email.body", link, locale.FormatTime(expiresAt, user.Locale, user.Timezone))
+	return uc.mailer.Send(ctx, user.Email, locale.T(user.Locale, "verify_email.subject"), body)
+}
+
+// NotifyRegistrationAttempt tells the owner of the email that someone tried to register with it, in place of
+// an error that would reveal the account. Unknown emails are ignored.
+func (uc *VerificationUsecase) NotifyRegistrationAttempt(ctx context.Context, email string) error {
+	user, err := uc.userRepo.GetUserByEmail(ctx, uc.emails.Canonical(email))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	body := locale.T(user.Locale, "registration_attempt.body", locale.FormatTime(time.Now(), user.Locale, user.Timezone))
+	return uc.mailer.Send(ctx, user.Email, locale.T(user.Locale, "registration_attempt.subject"), body)
+}
+
+// ResendVerification sends a new verification link if the account exists and is not verified yet.
+// It never reports whether the account exists, failures are only logged.
+func (uc *VerificationUsecase) ResendVerification(ctx context.Context, email string) {
+	user, err := uc.userRepo.GetUserByEmail(ctx, uc.emails.Canonical(email))
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			uc.logger.Error("Failed to lookup user for verification resend", "error", err)
+		}
+		return
+	}
+	if user.EmailVerified {
+		return
+	}
+	if err := uc.sendVerification(ctx, user); err != nil {
+		uc.logger.Error("Failed to resend verification email", "error", err)
+	}
+}
+
+// VerifyEmail consumes the verification token and marks the user's email as verified.
+func (uc *VerificationUsecase) VerifyEmail(ctx context.Context, token string) (uuid.UUID, error) {
+	if token == "" {
+		return uuid.Nil, customerrors.ErrInvalidToken
+	}
+	userID, err := uc.verificationRepo.ConsumeEmailVerification(ctx, utils.HashToken(token))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) || errors.Is(err, customerrors.ErrNoTagsAffected) {
+			return uuid.Nil, customerrors.ErrInvalidToken
+		}
+		return uuid.Nil, err
+	}
+	return userID, nil
+}
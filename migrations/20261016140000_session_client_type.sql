@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS client_type VARCHAR(16) NOT NULL DEFAULT 'web';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+ALTER TABLE sessions DROP COLUMN IF EXISTS client_type;
+-- +goose StatementEnd
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- base64url SHA-256 of the client certificate the session is bound to (RFC 8705), NULL for unbound sessions
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS cert_thumbprint VARCHAR(64);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+ALTER TABLE sessions DROP COLUMN IF EXISTS cert_thumbprint;
+-- +goose StatementEnd
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- JWK SHA-256 thumbprint of the DPoP key the session is bound to (RFC 9449), NULL for bearer sessions
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS dpop_jkt VARCHAR(64);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+ALTER TABLE sessions DROP COLUMN IF EXISTS dpop_jkt;
+-- +goose StatementEnd
@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+
+-- soft-deleted users must not hold on to their username and email, uniqueness only covers live users
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_username_key;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_live_key ON users(username) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_live_key ON users(email) WHERE deleted_at IS NULL;
+
+-- restoring soft-deleted users is an admin operation
+UPDATE roles SET permissions = array_append(permissions, 'user.delete')
+WHERE name = 'admin' AND NOT ('user.delete' = ANY(permissions));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+UPDATE roles SET permissions = array_remove(permissions, 'user.delete') WHERE name = 'admin';
+DROP INDEX IF EXISTS users_email_live_key;
+DROP INDEX IF EXISTS users_username_live_key;
+ALTER TABLE users ADD CONSTRAINT users_username_key UNIQUE (username);
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
+ALTER TABLE users DROP COLUMN IF EXISTS deleted_at;
+-- +goose StatementEnd
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- salted HMACs of the client IP and user agent, kept in privacy mode where the raw values are not stored
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS ip_hash VARCHAR(64);
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS device_hash VARCHAR(64);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_ip_hash ON sessions(ip_hash);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP INDEX IF EXISTS idx_sessions_ip_hash;
+ALTER TABLE sessions DROP COLUMN IF EXISTS device_hash;
+ALTER TABLE sessions DROP COLUMN IF EXISTS ip_hash;
+-- +goose StatementEnd
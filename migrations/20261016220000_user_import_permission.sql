@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- bulk import of users from other systems is an admin operation
+UPDATE roles SET permissions = array_append(permissions, 'user.import')
+WHERE name = 'admin' AND NOT ('user.import' = ANY(permissions));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+UPDATE roles SET permissions = array_remove(permissions, 'user.import') WHERE name = 'admin';
+-- +goose StatementEnd
@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+CREATE TABLE IF NOT EXISTS invitations (
+    id UUID PRIMARY KEY,
+    -- only the SHA-256 of the code is stored
+    code_hash BYTEA NOT NULL UNIQUE,
+    max_uses INT NOT NULL CHECK (max_uses > 0),
+    uses INT NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    created_by UUID,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL
+);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS invitation_id UUID REFERENCES invitations(id) ON DELETE SET NULL;
+
+UPDATE roles SET permissions = array_append(permissions, 'invite.manage')
+WHERE name = 'admin' AND NOT ('invite.manage' = ANY(permissions));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+UPDATE roles SET permissions = array_remove(permissions, 'invite.manage') WHERE name = 'admin';
+ALTER TABLE users DROP COLUMN IF EXISTS invitation_id;
+DROP TABLE IF EXISTS invitations;
+-- +goose StatementEnd
@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS canonical_email VARCHAR(255);
+
+-- existing addresses are normalized the same way new ones are (trimmed, lowercased). When two accounts
+-- only differ in case, the oldest keeps the canonical email, the others stay NULL and have to be merged by hand.
+UPDATE users u SET canonical_email = c.canonical
+FROM (
+    SELECT id, lower(btrim(email)) AS canonical,
+           row_number() OVER (PARTITION BY lower(btrim(email)) ORDER BY created_at, id) AS n
+    FROM users
+) c
+WHERE u.id = c.id AND c.n = 1;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_canonical_email ON users(canonical_email);
+
+ALTER TABLE email_changes ADD COLUMN IF NOT EXISTS new_canonical_email VARCHAR(255);
+UPDATE email_changes SET new_canonical_email = lower(btrim(new_email));
+ALTER TABLE email_changes ALTER COLUMN new_canonical_email SET NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+ALTER TABLE email_changes DROP COLUMN IF EXISTS new_canonical_email;
+DROP INDEX IF EXISTS idx_users_canonical_email;
+ALTER TABLE users DROP COLUMN IF EXISTS canonical_email;
+-- +goose StatementEnd
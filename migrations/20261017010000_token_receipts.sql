@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- receipts outlive sessions and accounts on purpose, they are the record of what was issued
+CREATE TABLE IF NOT EXISTS token_receipts (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL,
+    session_id UUID NOT NULL,
+    access_token_hash TEXT NOT NULL,
+    refresh_token_hash TEXT NOT NULL,
+    issued_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    signature TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_token_receipts_user_id ON token_receipts(user_id, issued_at);
+CREATE INDEX IF NOT EXISTS idx_token_receipts_session_id ON token_receipts(session_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP TABLE IF EXISTS token_receipts;
+-- +goose StatementEnd
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- admins can force a password reset, the current password stops working until the user sets a new one
+UPDATE roles SET permissions = array_append(permissions, 'user.password_reset')
+WHERE name = 'admin' AND NOT ('user.password_reset' = ANY(permissions));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+UPDATE roles SET permissions = array_remove(permissions, 'user.password_reset') WHERE name = 'admin';
+-- +goose StatementEnd
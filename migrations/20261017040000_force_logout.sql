@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- access tokens of the user issued up to this time are rejected, set when an admin forces a logout
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tokens_revoked_at TIMESTAMP WITH TIME ZONE;
+
+-- who performed an admin action on which user and why
+CREATE TABLE IF NOT EXISTS admin_actions (
+    id UUID PRIMARY KEY,
+    actor_id UUID NOT NULL,
+    action VARCHAR(64) NOT NULL,
+    target_id UUID NOT NULL,
+    reason_code VARCHAR(32) NOT NULL,
+    reason TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_admin_actions_target_id ON admin_actions(target_id, created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP TABLE IF EXISTS admin_actions;
+ALTER TABLE users DROP COLUMN IF EXISTS tokens_revoked_at;
+-- +goose StatementEnd
@@ -0,0 +1,39 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+CREATE TABLE IF NOT EXISTS organizations (
+    id UUID PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    -- active, suspended or deleted
+    status VARCHAR(16) NOT NULL DEFAULT 'active',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    suspended_at TIMESTAMP WITH TIME ZONE,
+    deleted_at TIMESTAMP WITH TIME ZONE,
+    -- deleted organizations are purged by a background job once this time has passed
+    purge_at TIMESTAMP WITH TIME ZONE
+);
+CREATE INDEX IF NOT EXISTS idx_organizations_purge_at ON organizations(purge_at) WHERE purge_at IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS organization_members (
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (organization_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS idx_organization_members_user_id ON organization_members(user_id);
+
+-- admin actions are about a user or, for tenant lifecycle actions, an organization
+ALTER TABLE admin_actions ADD COLUMN IF NOT EXISTS target_type VARCHAR(32) NOT NULL DEFAULT 'user';
+
+UPDATE roles SET permissions = array_append(permissions, 'org.manage')
+WHERE name = 'admin' AND NOT ('org.manage' = ANY(permissions));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+UPDATE roles SET permissions = array_remove(permissions, 'org.manage') WHERE name = 'admin';
+ALTER TABLE admin_actions DROP COLUMN IF EXISTS target_type;
+DROP TABLE IF EXISTS organization_members;
+DROP TABLE IF EXISTS organizations;
+-- +goose StatementEnd
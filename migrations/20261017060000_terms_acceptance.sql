@@ -0,0 +1,35 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- versions of the terms of service and the privacy policy, the latest published version of a kind is current
+CREATE TABLE IF NOT EXISTS terms_documents (
+    id UUID PRIMARY KEY,
+    -- terms or privacy
+    kind VARCHAR(32) NOT NULL,
+    version VARCHAR(64) NOT NULL,
+    url TEXT NOT NULL,
+    published_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    -- users who accepted an earlier version must accept this one again
+    requires_acceptance BOOLEAN NOT NULL DEFAULT TRUE,
+    UNIQUE (kind, version)
+);
+CREATE INDEX IF NOT EXISTS idx_terms_documents_kind_published_at ON terms_documents(kind, published_at DESC);
+
+CREATE TABLE IF NOT EXISTS terms_acceptances (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    document_id UUID NOT NULL REFERENCES terms_documents(id) ON DELETE CASCADE,
+    accepted_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, document_id)
+);
+
+UPDATE roles SET permissions = array_append(permissions, 'terms.manage')
+WHERE name = 'admin' AND NOT ('terms.manage' = ANY(permissions));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+UPDATE roles SET permissions = array_remove(permissions, 'terms.manage') WHERE name = 'admin';
+DROP TABLE IF EXISTS terms_acceptances;
+DROP TABLE IF EXISTS terms_documents;
+-- +goose StatementEnd
@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- incremented whenever all access tokens of the user are revoked (logout-all, block, forced logout),
+-- access tokens carry the version they were issued with
+ALTER TABLE users ADD COLUMN IF NOT EXISTS token_version BIGINT NOT NULL DEFAULT 0;
+-- tokens issued before carry no version (0), they stay revoked for users whose tokens were revoked
+UPDATE users SET token_version = 1 WHERE tokens_revoked_at IS NOT NULL;
+ALTER TABLE users DROP COLUMN IF EXISTS tokens_revoked_at;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tokens_revoked_at TIMESTAMP WITH TIME ZONE;
+UPDATE users SET tokens_revoked_at = NOW() WHERE token_version > 0;
+ALTER TABLE users DROP COLUMN IF EXISTS token_version;
+-- +goose StatementEnd
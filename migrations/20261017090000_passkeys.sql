@@ -0,0 +1,40 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- WebAuthn credentials, the credential ID is chosen by the authenticator
+CREATE TABLE IF NOT EXISTS passkeys (
+    credential_id BYTEA PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(64) NOT NULL,
+    -- COSE encoded public key
+    public_key BYTEA NOT NULL,
+    sign_count BIGINT NOT NULL DEFAULT 0,
+    aaguid UUID NOT NULL,
+    transports TEXT[] NOT NULL DEFAULT '{}',
+    -- synced passkeys are backup eligible
+    backup_eligible BOOLEAN NOT NULL DEFAULT FALSE,
+    backup_state BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP WITH TIME ZONE
+);
+CREATE INDEX IF NOT EXISTS idx_passkeys_user_id ON passkeys(user_id);
+
+-- pending registration and authentication ceremonies, each challenge is used once
+CREATE TABLE IF NOT EXISTS passkey_challenges (
+    id UUID PRIMARY KEY,
+    -- registration or authentication
+    ceremony VARCHAR(16) NOT NULL,
+    -- the registering user, NULL for authentication with a discoverable credential
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    challenge BYTEA NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_passkey_challenges_expires_at ON passkey_challenges(expires_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP TABLE IF EXISTS passkey_challenges;
+DROP TABLE IF EXISTS passkeys;
+-- +goose StatementEnd
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- language and IANA time zone of the last login, used for the emails sent to the user
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locale VARCHAR(8);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS timezone VARCHAR(64);
+
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS locale VARCHAR(8);
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS timezone VARCHAR(64);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+ALTER TABLE sessions DROP COLUMN IF EXISTS timezone;
+ALTER TABLE sessions DROP COLUMN IF EXISTS locale;
+ALTER TABLE users DROP COLUMN IF EXISTS timezone;
+ALTER TABLE users DROP COLUMN IF EXISTS locale;
+-- +goose StatementEnd
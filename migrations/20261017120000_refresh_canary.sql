@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- decoy refresh token of the session, it is never given to the client and any use of it means the table leaked
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS backup_refresh_token UUID;
+CREATE UNIQUE INDEX IF NOT EXISTS sessions_backup_refresh_token_idx ON sessions (backup_refresh_token) WHERE backup_refresh_token IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP INDEX IF EXISTS sessions_backup_refresh_token_idx;
+ALTER TABLE sessions DROP COLUMN IF EXISTS backup_refresh_token;
+-- +goose StatementEnd
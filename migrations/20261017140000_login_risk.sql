@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- country of the login IP and the risk assessment of the login that started the session
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS country VARCHAR(2);
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS risk_level VARCHAR(8);
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS risk_factors TEXT[] NOT NULL DEFAULT '{}';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+ALTER TABLE sessions DROP COLUMN IF EXISTS risk_factors;
+ALTER TABLE sessions DROP COLUMN IF EXISTS risk_level;
+ALTER TABLE sessions DROP COLUMN IF EXISTS country;
+-- +goose StatementEnd
@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- usernames (normalized, see handles.Normalize) and email addresses nobody can register or change to,
+-- the empty tenant bans them on every tenant domain
+CREATE TABLE IF NOT EXISTS banned_handles (
+    tenant VARCHAR(255) NOT NULL DEFAULT '',
+    handle VARCHAR(255) NOT NULL,
+    reason TEXT,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (tenant, handle)
+);
+
+UPDATE roles SET permissions = array_append(permissions, 'handle.manage')
+WHERE name = 'admin' AND NOT ('handle.manage' = ANY(permissions));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+UPDATE roles SET permissions = array_remove(permissions, 'handle.manage') WHERE name = 'admin';
+DROP TABLE IF EXISTS banned_handles;
+-- +goose StatementEnd
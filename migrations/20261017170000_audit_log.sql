@@ -0,0 +1,57 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- the admin actions become part of the security audit log, which also records the actions of users themselves:
+-- those have no actor when they fail before the user is known and no reason
+ALTER TABLE admin_actions RENAME TO audit_events;
+ALTER INDEX IF EXISTS idx_admin_actions_target_id RENAME TO idx_audit_events_target_id;
+ALTER TABLE audit_events ALTER COLUMN actor_id DROP NOT NULL;
+ALTER TABLE audit_events ALTER COLUMN target_id DROP NOT NULL;
+ALTER TABLE audit_events ALTER COLUMN target_type DROP NOT NULL;
+ALTER TABLE audit_events ALTER COLUMN target_type DROP DEFAULT;
+ALTER TABLE audit_events ALTER COLUMN reason_code DROP NOT NULL;
+ALTER TABLE audit_events ALTER COLUMN reason DROP NOT NULL;
+-- ip_address and user_agent follow the privacy mode like the ones of sessions
+ALTER TABLE audit_events ADD COLUMN IF NOT EXISTS ip_address INET;
+ALTER TABLE audit_events ADD COLUMN IF NOT EXISTS user_agent TEXT;
+ALTER TABLE audit_events ADD COLUMN IF NOT EXISTS details JSONB NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_created ON audit_events(created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor_created ON audit_events(actor_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_events_action_created ON audit_events(action, created_at DESC);
+
+-- the log is append-only, rows are never changed or removed by the application
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events;
+CREATE TRIGGER audit_events_append_only BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events;
+DROP FUNCTION IF EXISTS audit_events_append_only();
+DROP INDEX IF EXISTS idx_audit_events_action_created;
+DROP INDEX IF EXISTS idx_audit_events_actor_created;
+DROP INDEX IF EXISTS idx_audit_events_created;
+-- only the admin actions fit the old table
+DELETE FROM audit_events WHERE actor_id IS NULL OR target_id IS NULL OR reason_code IS NULL OR reason IS NULL;
+ALTER TABLE audit_events DROP COLUMN IF EXISTS details;
+ALTER TABLE audit_events DROP COLUMN IF EXISTS user_agent;
+ALTER TABLE audit_events DROP COLUMN IF EXISTS ip_address;
+UPDATE audit_events SET target_type = 'user' WHERE target_type IS NULL;
+ALTER TABLE audit_events ALTER COLUMN target_type SET DEFAULT 'user';
+ALTER TABLE audit_events ALTER COLUMN target_type SET NOT NULL;
+ALTER TABLE audit_events ALTER COLUMN actor_id SET NOT NULL;
+ALTER TABLE audit_events ALTER COLUMN target_id SET NOT NULL;
+ALTER TABLE audit_events ALTER COLUMN reason_code SET NOT NULL;
+ALTER TABLE audit_events ALTER COLUMN reason SET NOT NULL;
+ALTER INDEX IF EXISTS idx_audit_events_target_id RENAME TO idx_admin_actions_target_id;
+ALTER TABLE audit_events RENAME TO admin_actions;
+-- +goose StatementEnd
@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- App Attest key of sessions started by an attested ios app, the counter is the sign count of its last assertion
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS attest_key_id BYTEA;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS attest_public_key BYTEA;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS attest_counter BIGINT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+ALTER TABLE sessions DROP COLUMN IF EXISTS attest_counter;
+ALTER TABLE sessions DROP COLUMN IF EXISTS attest_public_key;
+ALTER TABLE sessions DROP COLUMN IF EXISTS attest_key_id;
+-- +goose StatementEnd
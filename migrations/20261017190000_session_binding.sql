@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- network (/24, /48) of the login IP, compared with refreshes by the session binding policy
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS bound_network INET;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+ALTER TABLE sessions DROP COLUMN IF EXISTS bound_network;
+-- +goose StatementEnd
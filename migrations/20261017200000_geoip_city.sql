@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- city of the login IP, empty without a GeoIP city database
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS city TEXT;
+ALTER TABLE login_events ADD COLUMN IF NOT EXISTS city TEXT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+ALTER TABLE login_events DROP COLUMN IF EXISTS city;
+ALTER TABLE sessions DROP COLUMN IF EXISTS city;
+-- +goose StatementEnd
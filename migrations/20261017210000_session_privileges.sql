@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- fingerprint of the roles of the user when the session was started or last rotated, empty for older sessions
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS privileges TEXT NOT NULL DEFAULT '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+ALTER TABLE sessions DROP COLUMN IF EXISTS privileges;
+-- +goose StatementEnd
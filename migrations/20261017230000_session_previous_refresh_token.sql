@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- the refresh token a session was last rotated away from, presenting it again raises a security alert
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS previous_refresh_token UUID;
+CREATE INDEX IF NOT EXISTS idx_sessions_previous_refresh_token ON sessions (previous_refresh_token);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP INDEX IF EXISTS idx_sessions_previous_refresh_token;
+ALTER TABLE sessions DROP COLUMN IF EXISTS previous_refresh_token;
+-- +goose StatementEnd
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- end of the step-up elevation of the session, admin operations requiring elevation are refused after it
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS elevated_until TIMESTAMPTZ;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+ALTER TABLE sessions DROP COLUMN IF EXISTS elevated_until;
+-- +goose StatementEnd
@@ -0,0 +1,45 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- data keys of the column encryption, wrapped with the master key of the config. Only one index key is
+-- ever created, the data keys are rotated
+CREATE TABLE IF NOT EXISTS data_keys (
+    id SERIAL PRIMARY KEY,
+    purpose VARCHAR(16) NOT NULL,
+    wrapped_key BYTEA NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_data_keys_index ON data_keys(purpose) WHERE purpose = 'index';
+
+-- encrypted emails do not fit VARCHAR(255), canonical emails hold a blind index
+ALTER TABLE users ALTER COLUMN email TYPE TEXT;
+ALTER TABLE users ALTER COLUMN canonical_email TYPE TEXT;
+ALTER TABLE email_verifications ALTER COLUMN email TYPE TEXT;
+ALTER TABLE email_changes ALTER COLUMN new_email TYPE TEXT;
+ALTER TABLE email_changes ALTER COLUMN new_canonical_email TYPE TEXT;
+
+-- encrypted addresses are text, plaintext ones keep their address without the prefix length
+ALTER TABLE sessions ALTER COLUMN ip_address TYPE TEXT USING host(ip_address);
+ALTER TABLE login_events ALTER COLUMN ip_address TYPE TEXT USING host(ip_address);
+ALTER TABLE trusted_devices ALTER COLUMN ip_address TYPE TEXT USING host(ip_address);
+ALTER TABLE audit_events ALTER COLUMN ip_address TYPE TEXT USING host(ip_address);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM data_keys) THEN
+        RAISE EXCEPTION 'encrypted values would become unreadable, run authctl decrypt-pii and delete the data keys first';
+    END IF;
+END $$;
+-- emails stay TEXT, shortening them could fail. Addresses of the append-only audit log that could not be
+-- decrypted are dropped
+ALTER TABLE sessions ALTER COLUMN ip_address TYPE INET USING ip_address::inet;
+ALTER TABLE login_events ALTER COLUMN ip_address TYPE INET USING ip_address::inet;
+ALTER TABLE trusted_devices ALTER COLUMN ip_address TYPE INET USING ip_address::inet;
+ALTER TABLE audit_events ALTER COLUMN ip_address TYPE INET
+    USING CASE WHEN ip_address LIKE 'enc:%' THEN NULL ELSE ip_address::inet END;
+DROP TABLE IF EXISTS data_keys;
+-- +goose StatementEnd
@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- annotations of the public status page, GET /status
+CREATE TABLE IF NOT EXISTS status_incidents (
+    id UUID PRIMARY KEY,
+    kind VARCHAR(16) NOT NULL,
+    severity VARCHAR(16) NOT NULL,
+    title VARCHAR(200) NOT NULL,
+    message TEXT,
+    starts_at TIMESTAMPTZ NOT NULL,
+    ends_at TIMESTAMPTZ,
+    resolved_at TIMESTAMPTZ,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_status_incidents_starts_at ON status_incidents(starts_at DESC);
+
+UPDATE roles SET permissions = array_append(permissions, 'status.manage')
+WHERE name = 'admin' AND NOT ('status.manage' = ANY(permissions));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+UPDATE roles SET permissions = array_remove(permissions, 'status.manage') WHERE name = 'admin';
+DROP TABLE IF EXISTS status_incidents;
+-- +goose StatementEnd
@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- created_at moves on with each rotation of the refresh token, started_at keeps the login time for the session
+-- list of the user, last_used_at is the last refresh
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS started_at TIMESTAMPTZ;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMPTZ;
+UPDATE sessions SET started_at = created_at, last_used_at = created_at WHERE started_at IS NULL;
+ALTER TABLE sessions ALTER COLUMN started_at SET DEFAULT NOW(), ALTER COLUMN started_at SET NOT NULL;
+ALTER TABLE sessions ALTER COLUMN last_used_at SET DEFAULT NOW(), ALTER COLUMN last_used_at SET NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_sessions_user_last_used ON sessions (user_id, last_used_at DESC);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP INDEX IF EXISTS idx_sessions_user_last_used;
+ALTER TABLE sessions DROP COLUMN IF EXISTS last_used_at;
+ALTER TABLE sessions DROP COLUMN IF EXISTS started_at;
+-- +goose StatementEnd
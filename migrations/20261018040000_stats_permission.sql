@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- GET /admin/stats and the GetStats RPC, capacity counts of users, sessions, tokens and the denylist
+UPDATE roles SET permissions = array_append(permissions, 'stats.read')
+WHERE name = 'admin' AND NOT ('stats.read' = ANY(permissions));
+CREATE INDEX IF NOT EXISTS idx_users_created_at ON users(created_at);
+CREATE INDEX IF NOT EXISTS idx_sessions_started_at ON sessions(started_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP INDEX IF EXISTS idx_sessions_started_at;
+DROP INDEX IF EXISTS idx_users_created_at;
+UPDATE roles SET permissions = array_remove(permissions, 'stats.read') WHERE name = 'admin';
+-- +goose StatementEnd
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- name the user gave the device of the session, encrypted like the user agent
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS device_name TEXT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+ALTER TABLE sessions DROP COLUMN IF EXISTS device_name;
+-- +goose StatementEnd
@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- gradual rollouts of new auth flows, the existing flows stay enabled for everyone
+CREATE TABLE IF NOT EXISTS feature_flags (
+    name VARCHAR(64) PRIMARY KEY,
+    percentage SMALLINT NOT NULL DEFAULT 0 CHECK (percentage BETWEEN 0 AND 100),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_by UUID REFERENCES users(id) ON DELETE SET NULL
+);
+INSERT INTO feature_flags (name, percentage) VALUES ('passkeys', 100), ('dpop', 100), ('password_hashing', 100)
+ON CONFLICT (name) DO NOTHING;
+
+-- users a flow is enabled for regardless of the percentage of its rollout
+CREATE TABLE IF NOT EXISTS feature_flag_users (
+    feature VARCHAR(64) NOT NULL REFERENCES feature_flags(name) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    added_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    added_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    PRIMARY KEY (feature, user_id)
+);
+
+UPDATE roles SET permissions = array_append(permissions, 'feature.manage')
+WHERE name = 'admin' AND NOT ('feature.manage' = ANY(permissions));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+UPDATE roles SET permissions = array_remove(permissions, 'feature.manage') WHERE name = 'admin';
+DROP TABLE IF EXISTS feature_flag_users;
+DROP TABLE IF EXISTS feature_flags;
+-- +goose StatementEnd
@@ -0,0 +1,205 @@
+// Package alert delivers security alerts to the sink of the security team: a generic JSON webhook, a Slack
+// incoming webhook or a Kafka topic behind a Kafka REST proxy (Confluent REST Proxy API v2).
+//
+// Alerts are queued by Publish and sent in the background by Run, raising one never waits for the sink.
+package alert
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"main/domain/entity"
+	"main/pkg/retry"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Kind is the type of a sink.
+type Kind string
+
+const (
+	Webhook Kind = "webhook"
+	Slack   Kind = "slack"
+	Kafka   Kind = "kafka"
+)
+
+// SignatureHeader carries the HMAC-SHA256 of the body of webhook requests, keyed with the webhook secret.
+const SignatureHeader = "X-Signature-256"
+
+// Sink sends alerts to one destination.
+type Sink interface {
+	Send(ctx context.Context, alert entity.SecurityAlert) error
+}
+
+// NewSink returns the sink of the kind. secret signs webhook requests and is optional, topic is required for Kafka.
+func NewSink(kind Kind, endpoint, topic, secret string, timeout time.Duration) (Sink, error) {
+	if _, err := url.ParseRequestURI(endpoint); err != nil {
+		return nil, fmt.Errorf("alert: invalid URL: %w", err)
+	}
+	client := &http.Client{Timeout: timeout}
+	switch kind {
+	case Webhook:
+		return &webhookSink{client: client, url: endpoint, secret: []byte(secret)}, nil
+	case Slack:
+		return &slackSink{client: client, url: endpoint}, nil
+	case Kafka:
+		if topic == "" {
+			return nil, errors.New("alert: the kafka sink requires a topic")
+		}
+		return &kafkaSink{client: client, url: strings.TrimSuffix(endpoint, "/") + "/topics/" + url.PathEscape(topic)}, nil
+	default:
+		return nil, fmt.Errorf("alert: unknown sink %q", kind)
+	}
+}
+
+// webhookSink posts the alert as JSON.
+type webhookSink struct {
+	client *http.Client
+	url    string
+	secret []byte
+}
+
+func (s *webhookSink) Send(ctx context.Context, alert entity.SecurityAlert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	header := http.Header{"Content-Type": {"application/json"}}
+	if len(s.secret) > 0 {
+		mac := hmac.New(sha256.New, s.secret)
+		mac.Write(body)
+		header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	return post(ctx, s.client, s.url, header, body)
+}
+
+// slackSink posts the alert as a message to a Slack incoming webhook.
+type slackSink struct {
+	client *http.Client
+	url    string
+}
+
+func (s *slackSink) Send(ctx context.Context, alert entity.SecurityAlert) error {
+	body, err := json.Marshal(map[string]string{"text": slackText(alert)})
+	if err != nil {
+		return err
+	}
+	return post(ctx, s.client, s.url, http.Header{"Content-Type": {"application/json"}}, body)
+}
+
+// slackText formats the alert as a message, the details sorted by key.
+func slackText(alert entity.SecurityAlert) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, ":rotating_light: *Security alert: %s* (%s)\nuser: `%s`", alert.Type, alert.Severity, alert.UserID)
+	if alert.SessionID != nil {
+		fmt.Fprintf(&b, "\nsession: `%s`", alert.SessionID)
+	}
+	if alert.IP.IsValid() {
+		fmt.Fprintf(&b, "\nip: `%s`", alert.IP)
+	}
+	keys := make([]string, 0, len(alert.Details))
+	for k := range alert.Details {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "\n%s: `%s`", k, alert.Details[k])
+	}
+	return b.String()
+}
+
+// kafkaSink produces the alert to a topic through a Kafka REST proxy, keyed by user so that the alerts
+// of a user stay in order.
+type kafkaSink struct {
+	client *http.Client
+	url    string
+}
+
+func (s *kafkaSink) Send(ctx context.Context, alert entity.SecurityAlert) error {
+	body, err := json.Marshal(map[string]any{
+		"records": []map[string]any{{"key": alert.UserID.String(), "value": alert}},
+	})
+	if err != nil {
+		return err
+	}
+	header := http.Header{
+		"Content-Type": {"application/vnd.kafka.json.v2+json"},
+		"Accept":       {"application/vnd.kafka.v2+json"},
+	}
+	return post(ctx, s.client, s.url, header, body)
+}
+
+func post(ctx context.Context, client *http.Client, endpoint string, header http.Header, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header = header
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("alert: sink returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// sendPolicy retries alerts the sink failed to accept, a sink that stays down for longer loses them.
+var sendPolicy = retry.Policy{Attempts: 4, Backoff: time.Second}
+
+// Publisher queues alerts and sends them to the sink.
+type Publisher struct {
+	sink   Sink
+	logger *slog.Logger
+	queue  chan entity.SecurityAlert
+}
+
+// NewPublisher returns a publisher queueing up to size alerts.
+func NewPublisher(sink Sink, logger *slog.Logger, size int) *Publisher {
+	return &Publisher{
+		sink:   sink,
+		logger: logger,
+		queue:  make(chan entity.SecurityAlert, max(size, 1)),
+	}
+}
+
+// Publish queues the alert, it is dropped and logged when the queue is full.
+func (p *Publisher) Publish(alert entity.SecurityAlert) {
+	select {
+	case p.queue <- alert:
+	default:
+		p.logger.Error("Security alert queue full, alert dropped", "alert_id", alert.ID, "type", alert.Type,
+			"user_id", alert.UserID)
+	}
+}
+
+// Run sends the queued alerts until the context is cancelled, alerts still queued then are not sent.
+func (p *Publisher) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case alert := <-p.queue:
+			err := retry.Do(ctx, p.logger, "send_security_alert", sendPolicy, func(ctx context.Context) error {
+				return p.sink.Send(ctx, alert)
+			})
+			if err != nil {
+				p.logger.Error("Failed to send security alert", "alert_id", alert.ID, "type", alert.Type,
+					"user_id", alert.UserID, "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package attestation
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"main/domain/entity"
+	"main/pkg/cbor"
+)
+
+// appAttestNonceOID is the extension of the credential certificate holding the nonce of the attestation.
+var appAttestNonceOID = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 8, 2}
+
+// AAGUIDs of App Attest keys in production and in the development environment.
+var (
+	aaguidProduction  = []byte("appattest\x00\x00\x00\x00\x00\x00\x00")
+	aaguidDevelopment = []byte("appattestdevelop")
+)
+
+// authDataMinLength is the RP ID hash, the flags and the sign count of authenticator data.
+const authDataMinLength = 37
+
+// AppAttest verifies Apple App Attest attestation objects and assertions. The client data hash of both is the
+// SHA-256 of the challenge.
+type AppAttest struct {
+	// AppID is the team ID and the bundle ID of the ios app, like "ABCDE12345.com.example.app"
+	AppID string
+	// Roots holds the Apple App Attestation Root CA
+	Roots *x509.CertPool
+	// Development accepts keys of the development environment instead of production ones
+	Development bool
+}
+
+// VerifyAttestation verifies an attestation object following Apple's "Validating apps that connect to your
+// server" and returns the attested key with a sign count of zero.
+func (a *AppAttest) VerifyAttestation(object []byte, challenge string) (entity.AttestedKey, error) {
+	item, _, err := cbor.Decode(object)
+	if err != nil {
+		return entity.AttestedKey{}, fmt.Errorf("%w: malformed attestation object", ErrInvalid)
+	}
+	m, _ := item.(map[any]any)
+	format, _ := m["fmt"].(string)
+	statement, _ := m["attStmt"].(map[any]any)
+	authData, _ := m["authData"].([]byte)
+	x5c, _ := statement["x5c"].([]any)
+	if format != "apple-appattest" || len(x5c) == 0 || len(authData) < authDataMinLength {
+		return entity.AttestedKey{}, fmt.Errorf("%w: not an App Attest attestation", ErrInvalid)
+	}
+
+	certs := make([]*x509.Certificate, 0, len(x5c))
+	for _, der := range x5c {
+		b, _ := der.([]byte)
+		cert, err := x509.ParseCertificate(b)
+		if err != nil {
+			return entity.AttestedKey{}, fmt.Errorf("%w: bad certificate", ErrInvalid)
+		}
+		certs = append(certs, cert)
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	leaf := certs[0]
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         a.Roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return entity.AttestedKey{}, fmt.Errorf("%w: untrusted certificate: %v", ErrInvalid, err)
+	}
+
+	clientDataHash := sha256.Sum256([]byte(challenge))
+	nonce := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+	if !bytes.Equal(certificateNonce(leaf), nonce[:]) {
+		return entity.AttestedKey{}, fmt.Errorf("%w: nonce mismatch", ErrInvalid)
+	}
+
+	pub, ok := leaf.PublicKey.(*ecdsa.PublicKey)
+	if !ok || pub.Curve != elliptic.P256() {
+		return entity.AttestedKey{}, fmt.Errorf("%w: unexpected key type", ErrInvalid)
+	}
+	point, err := pub.ECDH()
+	if err != nil {
+		return entity.AttestedKey{}, fmt.Errorf("%w: bad key", ErrInvalid)
+	}
+	keyID := sha256.Sum256(point.Bytes())
+
+	if err := a.checkAuthData(authData); err != nil {
+		return entity.AttestedKey{}, err
+	}
+	if binary.BigEndian.Uint32(authData[33:37]) != 0 {
+		return entity.AttestedKey{}, fmt.Errorf("%w: key already used", ErrInvalid)
+	}
+	aaguid := aaguidProduction
+	if a.Development {
+		aaguid = aaguidDevelopment
+	}
+	rest := authData[authDataMinLength:]
+	if len(rest) < 18 || !bytes.Equal(rest[:16], aaguid) {
+		return entity.AttestedKey{}, fmt.Errorf("%w: wrong environment", ErrInvalid)
+	}
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	if idLength > len(rest)-18 || !bytes.Equal(rest[18:18+idLength], keyID[:]) {
+		return entity.AttestedKey{}, fmt.Errorf("%w: key identifier mismatch", ErrInvalid)
+	}
+
+	publicKey, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return entity.AttestedKey{}, err
+	}
+	return entity.AttestedKey{ID: keyID[:], PublicKey: publicKey}, nil
+}
+
+// VerifyAssertion verifies an assertion of the attested key and returns the key with the sign count of
+// the assertion, which must be above the one of the previous assertion.
+func (a *AppAttest) VerifyAssertion(assertion []byte, challenge string, key entity.AttestedKey) (entity.AttestedKey, error) {
+	item, _, err := cbor.Decode(assertion)
+	if err != nil {
+		return entity.AttestedKey{}, fmt.Errorf("%w: malformed assertion", ErrInvalid)
+	}
+	m, _ := item.(map[any]any)
+	signature, _ := m["signature"].([]byte)
+	authData, _ := m["authenticatorData"].([]byte)
+	if len(signature) == 0 || len(authData) < authDataMinLength {
+		return entity.AttestedKey{}, fmt.Errorf("%w: not an App Attest assertion", ErrInvalid)
+	}
+
+	parsed, err := x509.ParsePKIXPublicKey(key.PublicKey)
+	if err != nil {
+		return entity.AttestedKey{}, fmt.Errorf("%w: bad stored key", ErrInvalid)
+	}
+	pub, ok := parsed.(*ecdsa.PublicKey)
+	if !ok {
+		return entity.AttestedKey{}, fmt.Errorf("%w: bad stored key", ErrInvalid)
+	}
+	clientDataHash := sha256.Sum256([]byte(challenge))
+	nonce := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+	// the key signs the nonce with ES256, which hashes it once more
+	digest := sha256.Sum256(nonce[:])
+	if !ecdsa.VerifyASN1(pub, digest[:], signature) {
+		return entity.AttestedKey{}, fmt.Errorf("%w: bad signature", ErrInvalid)
+	}
+
+	if err := a.checkAuthData(authData); err != nil {
+		return entity.AttestedKey{}, err
+	}
+	counter := binary.BigEndian.Uint32(authData[33:37])
+	if counter <= key.Counter {
+		return entity.AttestedKey{}, fmt.Errorf("%w: replayed assertion", ErrInvalid)
+	}
+	key.Counter = counter
+	return key, nil
+}
+
+// checkAuthData checks that the authenticator data belongs to the app.
+func (a *AppAttest) checkAuthData(authData []byte) error {
+	rpIDHash := sha256.Sum256([]byte(a.AppID))
+	if !bytes.Equal(authData[:32], rpIDHash[:]) {
+		return fmt.Errorf("%w: app ID mismatch", ErrInvalid)
+	}
+	return nil
+}
+
+// certificateNonce returns the nonce of the credential certificate, a SEQUENCE holding the OCTET STRING in an
+// explicit [1] tag. It is nil without the extension.
+func certificateNonce(cert *x509.Certificate) []byte {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(appAttestNonceOID) {
+			continue
+		}
+		var value struct {
+			Nonce []byte `asn1:"tag:1,explicit"`
+		}
+		if _, err := asn1.Unmarshal(ext.Value, &value); err != nil {
+			return nil
+		}
+		return value.Nonce
+	}
+	return nil
+}
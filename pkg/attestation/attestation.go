@@ -0,0 +1,117 @@
+// Package attestation verifies that requests come from genuine installations of the mobile apps: Google Play
+// Integrity tokens on android and Apple App Attest attestations and assertions on ios. Tokens are requested by
+// the app for a challenge issued by Challenges, which keeps them from being replayed after the challenge expires.
+package attestation
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"main/domain/entity"
+	"time"
+)
+
+var (
+	// ErrInvalid is returned for malformed attestations and attestations that fail verification.
+	ErrInvalid = errors.New("attestation: invalid attestation")
+	// ErrUnsupportedPlatform is returned for platforms without a configured verifier.
+	ErrUnsupportedPlatform = errors.New("attestation: unsupported platform")
+)
+
+// challengeRandomSize is the number of random bytes of a challenge.
+const challengeRandomSize = 16
+
+// Challenges issues stateless challenges: an expiry time and random bytes authenticated with HMAC-SHA256.
+type Challenges struct {
+	key []byte
+	ttl time.Duration
+}
+
+func NewChallenges(key []byte, ttl time.Duration) *Challenges {
+	return &Challenges{key: key, ttl: ttl}
+}
+
+// New returns a challenge valid for the TTL. It is URL-safe base64 and fits the nonce of Play Integrity requests.
+func (c *Challenges) New() (entity.AttestationChallenge, error) {
+	expiresAt := time.Now().Add(c.ttl).Truncate(time.Second)
+	raw := make([]byte, 8+challengeRandomSize, 8+challengeRandomSize+sha256.Size)
+	binary.BigEndian.PutUint64(raw, uint64(expiresAt.Unix()))
+	if _, err := rand.Read(raw[8:]); err != nil {
+		return entity.AttestationChallenge{}, err
+	}
+	raw = append(raw, c.mac(raw)...)
+	return entity.AttestationChallenge{Challenge: base64.RawURLEncoding.EncodeToString(raw), ExpiresAt: expiresAt}, nil
+}
+
+// check verifies the authenticity and expiry of a challenge.
+func (c *Challenges) check(challenge string) error {
+	raw, err := base64.RawURLEncoding.DecodeString(challenge)
+	if err != nil || len(raw) != 8+challengeRandomSize+sha256.Size {
+		return fmt.Errorf("%w: malformed challenge", ErrInvalid)
+	}
+	body := raw[:8+challengeRandomSize]
+	if !hmac.Equal(raw[len(body):], c.mac(body)) {
+		return fmt.Errorf("%w: unknown challenge", ErrInvalid)
+	}
+	if time.Now().Unix() > int64(binary.BigEndian.Uint64(body)) {
+		return fmt.Errorf("%w: expired challenge", ErrInvalid)
+	}
+	return nil
+}
+
+func (c *Challenges) mac(body []byte) []byte {
+	m := hmac.New(sha256.New, c.key)
+	m.Write(body)
+	return m.Sum(nil)
+}
+
+// Verifier checks the attestations of both platforms, a nil verifier of a platform rejects its attestations.
+type Verifier struct {
+	Challenges *Challenges
+	Android    *PlayIntegrity
+	IOS        *AppAttest
+}
+
+// Verify checks the attestation and its challenge. key is the App Attest key bound to the session, nil at login:
+// without one an ios token is an attestation object, with one an assertion of the key. It returns the key to bind
+// to the session from now on, nil on android.
+func (v *Verifier) Verify(a entity.Attestation, key *entity.AttestedKey) (*entity.AttestedKey, error) {
+	if err := v.Challenges.check(a.Challenge); err != nil {
+		return nil, err
+	}
+	switch a.Platform {
+	case entity.AttestationAndroid:
+		if v.Android == nil {
+			return nil, ErrUnsupportedPlatform
+		}
+		return nil, v.Android.Verify(a.Token, a.Challenge)
+	case entity.AttestationIOS:
+		if v.IOS == nil {
+			return nil, ErrUnsupportedPlatform
+		}
+		token, err := base64.RawURLEncoding.DecodeString(a.Token)
+		if err != nil {
+			return nil, fmt.Errorf("%w: token is not base64url", ErrInvalid)
+		}
+		var verified entity.AttestedKey
+		if key == nil {
+			verified, err = v.IOS.VerifyAttestation(token, a.Challenge)
+		} else {
+			verified, err = v.IOS.VerifyAssertion(token, a.Challenge, *key)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &verified, nil
+	}
+	return nil, ErrUnsupportedPlatform
+}
+
+// NewChallenge issues a challenge for the app to request its token for.
+func (v *Verifier) NewChallenge() (entity.AttestationChallenge, error) {
+	return v.Challenges.New()
+}
@@ -0,0 +1,199 @@
+package attestation
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Play Integrity verdicts a genuine app on a genuine device gets.
+const (
+	playRecognized       = "PLAY_RECOGNIZED"
+	meetsDeviceIntegrity = "MEETS_DEVICE_INTEGRITY"
+)
+
+// PlayIntegrity verifies Google Play Integrity tokens locally with the response encryption keys of the app
+// ("Manage response encryption yourself" in the Play Console): the token is a JWE (A256KW, A256GCM) wrapping
+// a JWS (ES256) of the integrity verdict.
+type PlayIntegrity struct {
+	// PackageName is the package of the android app
+	PackageName string
+	// DecryptionKey is the 32-byte AES key and VerificationKey the P-256 key of the app
+	DecryptionKey   []byte
+	VerificationKey *ecdsa.PublicKey
+	// MaxAge is how old the verdict may be
+	MaxAge time.Duration
+}
+
+// integrityVerdict is the part of the token payload that is checked.
+type integrityVerdict struct {
+	RequestDetails struct {
+		RequestPackageName string `json:"requestPackageName"`
+		Nonce              string `json:"nonce"`
+		TimestampMillis    string `json:"timestampMillis"`
+	} `json:"requestDetails"`
+	AppIntegrity struct {
+		AppRecognitionVerdict string `json:"appRecognitionVerdict"`
+		PackageName           string `json:"packageName"`
+	} `json:"appIntegrity"`
+	DeviceIntegrity struct {
+		DeviceRecognitionVerdict []string `json:"deviceRecognitionVerdict"`
+	} `json:"deviceIntegrity"`
+}
+
+// Verify decrypts the token, checks its signature and that it was requested for the challenge by the
+// Play-recognized app on a device meeting device integrity within MaxAge.
+func (p *PlayIntegrity) Verify(token, challenge string) error {
+	jws, err := p.decrypt(token)
+	if err != nil {
+		return err
+	}
+	payload, err := p.verifySignature(jws)
+	if err != nil {
+		return err
+	}
+	var verdict integrityVerdict
+	if err := json.Unmarshal(payload, &verdict); err != nil {
+		return fmt.Errorf("%w: malformed verdict", ErrInvalid)
+	}
+
+	req := verdict.RequestDetails
+	if req.RequestPackageName != p.PackageName || verdict.AppIntegrity.PackageName != p.PackageName {
+		return fmt.Errorf("%w: package mismatch", ErrInvalid)
+	}
+	if subtle.ConstantTimeCompare([]byte(req.Nonce), []byte(challenge)) != 1 {
+		return fmt.Errorf("%w: challenge mismatch", ErrInvalid)
+	}
+	millis, err := strconv.ParseInt(req.TimestampMillis, 10, 64)
+	if err != nil || time.Since(time.UnixMilli(millis)) > p.MaxAge {
+		return fmt.Errorf("%w: stale verdict", ErrInvalid)
+	}
+	if verdict.AppIntegrity.AppRecognitionVerdict != playRecognized {
+		return fmt.Errorf("%w: app not recognized (%s)", ErrInvalid, verdict.AppIntegrity.AppRecognitionVerdict)
+	}
+	if !slices.Contains(verdict.DeviceIntegrity.DeviceRecognitionVerdict, meetsDeviceIntegrity) {
+		return fmt.Errorf("%w: device integrity not met", ErrInvalid)
+	}
+	return nil
+}
+
+// decrypt opens the compact JWE and returns the JWS inside.
+func (p *PlayIntegrity) decrypt(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 5 {
+		return "", fmt.Errorf("%w: token is not a compact JWE", ErrInvalid)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Enc string `json:"enc"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil || header.Alg != "A256KW" || header.Enc != "A256GCM" {
+		return "", fmt.Errorf("%w: unsupported JWE header", ErrInvalid)
+	}
+	var segments [4][]byte
+	for i, part := range parts[1:] {
+		b, err := base64.RawURLEncoding.DecodeString(part)
+		if err != nil {
+			return "", fmt.Errorf("%w: malformed JWE", ErrInvalid)
+		}
+		segments[i] = b
+	}
+	wrappedKey, iv, ciphertext, tag := segments[0], segments[1], segments[2], segments[3]
+
+	cek, err := unwrapKey(p.DecryptionKey, wrappedKey)
+	if err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return "", fmt.Errorf("%w: bad content key", ErrInvalid)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil || len(iv) != gcm.NonceSize() || len(tag) != gcm.Overhead() {
+		return "", fmt.Errorf("%w: malformed JWE", ErrInvalid)
+	}
+	// the additional data is the encoded protected header
+	plaintext, err := gcm.Open(nil, iv, append(ciphertext, tag...), []byte(parts[0]))
+	if err != nil {
+		return "", fmt.Errorf("%w: decryption failed", ErrInvalid)
+	}
+	return string(plaintext), nil
+}
+
+// verifySignature checks the ES256 signature of the compact JWS and returns its payload.
+func (p *PlayIntegrity) verifySignature(jws string) ([]byte, error) {
+	parts := strings.Split(jws, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: payload is not a compact JWS", ErrInvalid)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil || header.Alg != "ES256" {
+		return nil, fmt.Errorf("%w: unsupported JWS header", ErrInvalid)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(sig) != 64 {
+		return nil, fmt.Errorf("%w: malformed signature", ErrInvalid)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+	if !ecdsa.Verify(p.VerificationKey, digest[:], r, s) {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalid)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed payload", ErrInvalid)
+	}
+	return payload, nil
+}
+
+func decodeSegment(segment string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// keyWrapIV is the initial value of AES key wrap (RFC 3394).
+var keyWrapIV = []byte{0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6}
+
+// unwrapKey unwraps a key wrapped with AES key wrap (RFC 3394).
+func unwrapKey(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 24 || len(wrapped)%8 != 0 {
+		return nil, fmt.Errorf("%w: malformed wrapped key", ErrInvalid)
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad decryption key", ErrInvalid)
+	}
+	n := len(wrapped)/8 - 1
+	a := binary.BigEndian.Uint64(wrapped)
+	r := append([]byte(nil), wrapped[8:]...)
+	buf := make([]byte, 16)
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			binary.BigEndian.PutUint64(buf, a^uint64(n*j+i))
+			copy(buf[8:], r[(i-1)*8:i*8])
+			block.Decrypt(buf, buf)
+			a = binary.BigEndian.Uint64(buf)
+			copy(r[(i-1)*8:i*8], buf[8:])
+		}
+	}
+	if a != binary.BigEndian.Uint64(keyWrapIV) {
+		return nil, fmt.Errorf("%w: key unwrap failed", ErrInvalid)
+	}
+	return r, nil
+}
@@ -0,0 +1,200 @@
+// Package authsdk lets downstream services authenticate the requests they receive with tokens of this service
+// and authorize them declaratively. The client verifies tokens with the forward-auth endpoint (GET /authz) of
+// the auth service, the middleware applies the policy of a route group to the identity it returns:
+//
+//	client := authsdk.NewClient("https://auth.example.com/authz", authsdk.WithLogger(logger))
+//	mux.Handle("/billing/", client.Middleware(authsdk.Policy{
+//		Audiences: []string{"billing"},
+//		Scopes:    []string{"billing:read"},
+//	})(billing))
+//	mux.Handle("/admin/", client.Middleware(authsdk.Policy{Roles: []string{"admin"}})(admin))
+//
+// With WithLocalVerification the client fetches the verification keys and verifies most tokens itself, without
+// a secret in the configuration of the service:
+//
+//	client := authsdk.NewClient("https://auth.example.com/authz", authsdk.WithLocalVerification(authsdk.LocalVerification{
+//		KeysURL:      "https://auth.example.com/oauth/verification-keys",
+//		TokenURL:     "https://auth.example.com/oauth/token",
+//		ClientID:     "billing",
+//		ClientSecret: os.Getenv("AUTH_CLIENT_SECRET"),
+//	}))
+package authsdk
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"main/pkg/retry"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Identity headers of the forward-auth endpoint, scopes, roles and audiences are space-separated.
+const (
+	headerUserID   = "X-User-ID"
+	headerClientID = "X-Client-ID"
+	headerScopes   = "X-Scopes"
+	headerRoles    = "X-Roles"
+	headerAudience = "X-Audience"
+)
+
+var (
+	// ErrUnauthorized is returned for requests without a valid token
+	ErrUnauthorized = errors.New("authsdk: invalid or missing token")
+	// ErrForbidden is returned for identities not satisfying the policy of the route
+	ErrForbidden = errors.New("authsdk: insufficient permissions")
+)
+
+// Identity is the authenticated caller of a request, a user (UserID) or a service client (ClientID).
+type Identity struct {
+	UserID   string
+	ClientID string
+	// Scopes are the scopes granted to a service client, users have none
+	Scopes []string
+	// Roles are the roles of a user, service clients have none
+	Roles []string
+	// Audiences is the aud claim of the token
+	Audiences []string
+}
+
+// Client verifies tokens with the forward-auth endpoint of the auth service, or itself with fetched keys.
+type Client struct {
+	authzURL   string
+	httpClient *http.Client
+	logger     *slog.Logger
+	// retries are the attempts of verifications and key fetches failing with network errors or 5xx answers
+	retries retry.Policy
+	// keys verifies tokens locally, nil without WithLocalVerification
+	keys *keySource
+}
+
+// Option configures optional Client features.
+type Option func(*Client)
+
+// WithHTTPClient sets the client of the requests to the auth service, a client with a 5s timeout by default.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithLogger sets the logger of retries and failed verifications, nothing is logged by default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *Client) {
+		c.logger = logger
+	}
+}
+
+// WithRetries sets the number of attempts of a verification including the first one and the backoff before
+// the first retry, 3 attempts after 50ms by default.
+func WithRetries(attempts int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries.Attempts, c.retries.Backoff = attempts, backoff
+	}
+}
+
+// NewClient returns a client of the forward-auth endpoint at authzURL, e.g. https://auth.example.com/authz.
+func NewClient(authzURL string, opts ...Option) *Client {
+	c := &Client{
+		authzURL:   authzURL,
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+		retries:    retry.Policy{Attempts: 3, Backoff: 50 * time.Millisecond, Retryable: retryable},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Verify authenticates the request with its Authorization header (and DPoP proof for DPoP-bound tokens).
+// It returns ErrUnauthorized for requests without a valid token and other errors when the auth service
+// cannot be reached. Identities verified locally (WithLocalVerification) carry no roles.
+func (c *Client) Verify(ctx context.Context, r *http.Request) (Identity, error) {
+	if r.Header.Get("Authorization") == "" {
+		return Identity{}, ErrUnauthorized
+	}
+	if c.keys != nil {
+		if identity, err, ok := c.verifyLocally(r); ok {
+			return identity, err
+		}
+	}
+	return c.verifyRemotely(ctx, r)
+}
+
+// verifyRemotely authenticates the request with the forward-auth endpoint, retrying failed calls.
+func (c *Client) verifyRemotely(ctx context.Context, r *http.Request) (Identity, error) {
+	if r.Header.Get("Authorization") == "" {
+		return Identity{}, ErrUnauthorized
+	}
+	var identity Identity
+	err := retry.Do(ctx, c.logger, "authsdk_verify", c.retries, func(ctx context.Context) (err error) {
+		identity, err = c.verify(ctx, r)
+		return err
+	})
+	return identity, err
+}
+
+func (c *Client) verify(ctx context.Context, r *http.Request) (Identity, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.authzURL, nil)
+	if err != nil {
+		return Identity{}, err
+	}
+	req.Header.Set("Authorization", r.Header.Get("Authorization"))
+	if proof := r.Header.Get("DPoP"); proof != "" {
+		req.Header.Set("DPoP", proof)
+	}
+	// the original request, DPoP proofs are bound to its method and URL
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	req.Header.Set("X-Forwarded-Method", r.Method)
+	req.Header.Set("X-Forwarded-Proto", proto)
+	req.Header.Set("X-Forwarded-Host", r.Host)
+	req.Header.Set("X-Forwarded-Uri", r.URL.RequestURI())
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return Identity{}, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return Identity{}, ErrUnauthorized
+	case resp.StatusCode != http.StatusOK:
+		return Identity{}, &statusError{code: resp.StatusCode, status: resp.Status}
+	}
+	return Identity{
+		UserID:    resp.Header.Get(headerUserID),
+		ClientID:  resp.Header.Get(headerClientID),
+		Scopes:    strings.Fields(resp.Header.Get(headerScopes)),
+		Roles:     strings.Fields(resp.Header.Get(headerRoles)),
+		Audiences: strings.Fields(resp.Header.Get(headerAudience)),
+	}, nil
+}
+
+// statusError is an answer of the forward-auth endpoint other than 200 and 401.
+type statusError struct {
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string {
+	return "authsdk: auth service answered " + e.status
+}
+
+// retryable reports whether a failed verification may succeed when repeated: network errors other than
+// the deadline of the caller and server errors.
+func retryable(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.code >= 500
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,290 @@
+package authsdk
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	authjwt "main/pkg/jwt"
+	"main/pkg/retry"
+	mathrand "math/rand/v2"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// defaultKeyScope is the scope the auth service requires for its verification keys by default
+	defaultKeyScope = "tokens.verify"
+	// defaultKeyRefresh is how long keys are cached when the auth service does not say
+	defaultKeyRefresh = 5 * time.Minute
+	// keyRetryInterval is how long after a failed fetch of the keys the next one starts
+	keyRetryInterval = 30 * time.Second
+	// keyFetchTimeout bounds a fetch of the keys including its retries
+	keyFetchTimeout = 30 * time.Second
+)
+
+// LocalVerification makes the client verify tokens itself with the keys of the auth service, fetched at runtime
+// instead of configured, see WithLocalVerification.
+type LocalVerification struct {
+	// KeysURL is the verification key endpoint, e.g. https://auth.example.com/oauth/verification-keys
+	KeysURL string
+	// TokenURL is the token endpoint, e.g. https://auth.example.com/oauth/token. The keys are fetched with a
+	// service token of the client, which must be granted Scope.
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	// Scope is the key distribution scope of the auth service, tokens.verify by default
+	Scope string
+}
+
+// WithLocalVerification verifies tokens inside the service with the verification keys of the auth service instead
+// of asking its forward-auth endpoint for every request. The keys are fetched in the background, encrypted to a
+// key pair generated for each fetch, and refreshed with jitter before the auth service expects them to change.
+// Until they are available, and for tokens they cannot verify (a rotated key, certificate- or DPoP-bound tokens),
+// the forward-auth endpoint is asked as before. Routes whose policy requires roles are always verified by the
+// forward-auth endpoint, roles are not part of tokens.
+//
+// Tokens verified locally are valid until they expire: logouts, blocked users and revoked sessions are only
+// observed by the forward-auth endpoint.
+func WithLocalVerification(cfg LocalVerification) Option {
+	return func(c *Client) {
+		if cfg.Scope == "" {
+			cfg.Scope = defaultKeyScope
+		}
+		c.keys = &keySource{cfg: cfg}
+	}
+}
+
+// errUnknownKey is a token signed with a key the cached key set does not hold.
+var errUnknownKey = errors.New("authsdk: unknown verification key")
+
+// keySource caches the verification keys of the auth service and refreshes them in the background.
+type keySource struct {
+	cfg LocalVerification
+
+	mu  sync.Mutex
+	set *authjwt.KeySet
+	// refreshAt is when the set is fetched again, the set stays in use until a fetch succeeds
+	refreshAt time.Time
+	fetching  bool
+	// token is the cached service token of the fetches
+	token       string
+	tokenExpiry time.Time
+}
+
+// keySet returns the cached key set, nil before the first fetch succeeded. A fetch is started in the background
+// when the set is due for a refresh.
+func (c *Client) keySet() *authjwt.KeySet {
+	k := c.keys
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if !k.fetching && !time.Now().Before(k.refreshAt) {
+		k.fetching = true
+		go c.refreshKeys()
+	}
+	return k.set
+}
+
+// refreshKeys fetches the key set and schedules the next refresh: a jittered fraction of the refresh interval of the
+// set after a success, so that the instances of a service do not fetch at once, the retry interval after a failure.
+func (c *Client) refreshKeys() {
+	ctx, cancel := context.WithTimeout(context.Background(), keyFetchTimeout)
+	defer cancel()
+
+	var set authjwt.KeySet
+	err := retry.Do(ctx, c.logger, "authsdk_fetch_keys", c.retries, func(ctx context.Context) (err error) {
+		set, err = c.fetchKeys(ctx)
+		return err
+	})
+
+	k := c.keys
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.fetching = false
+	if err != nil {
+		if c.logger != nil {
+			c.logger.Error("Failed to fetch verification keys", "error", err)
+		}
+		k.refreshAt = time.Now().Add(jitter(keyRetryInterval))
+		return
+	}
+	refreshIn := set.RefreshIn
+	if refreshIn <= 0 {
+		refreshIn = defaultKeyRefresh
+	}
+	k.set = &set
+	k.refreshAt = time.Now().Add(jitter(refreshIn))
+}
+
+// jitter returns a random duration between 80% and 100% of d.
+func jitter(d time.Duration) time.Duration {
+	return d - time.Duration(mathrand.Int64N(int64(d)/5+1))
+}
+
+// fetchKeys fetches the key set, encrypted to a key pair generated for the request.
+func (c *Client) fetchKeys(ctx context.Context) (authjwt.KeySet, error) {
+	token, err := c.serviceToken(ctx)
+	if err != nil {
+		return authjwt.KeySet{}, err
+	}
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return authjwt.KeySet{}, err
+	}
+	body, err := json.Marshal(map[string]any{
+		"public_key": jose.JSONWebKey{Key: &private.PublicKey, Algorithm: string(jose.ECDH_ES_A256KW), Use: "enc"},
+	})
+	if err != nil {
+		return authjwt.KeySet{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.keys.cfg.KeysURL, bytes.NewReader(body))
+	if err != nil {
+		return authjwt.KeySet{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return authjwt.KeySet{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		// the service token expired early or its key was rotated, the next attempt gets a new one
+		c.keys.mu.Lock()
+		c.keys.token = ""
+		c.keys.mu.Unlock()
+	}
+	if resp.StatusCode != http.StatusOK {
+		return authjwt.KeySet{}, &statusError{code: resp.StatusCode, status: resp.Status}
+	}
+	var out struct {
+		Keys string `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return authjwt.KeySet{}, err
+	}
+	return authjwt.UnwrapKeySet(out.Keys, private)
+}
+
+// serviceToken returns the cached service token of the client or requests a new one with its credentials.
+func (c *Client) serviceToken(ctx context.Context) (string, error) {
+	k := c.keys
+	k.mu.Lock()
+	token, expiry := k.token, k.tokenExpiry
+	k.mu.Unlock()
+	if token != "" && time.Now().Before(expiry) {
+		return token, nil
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}, "scope": {k.cfg.Scope}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(k.cfg.ClientID, k.cfg.ClientSecret)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", &statusError{code: resp.StatusCode, status: resp.Status}
+	}
+	var out struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", err
+	}
+	ttl := time.Duration(out.ExpiresIn) * time.Second
+	k.mu.Lock()
+	// renewed halfway, a fetch never starts with a token about to expire
+	k.token, k.tokenExpiry = out.AccessToken, time.Now().Add(ttl/2)
+	k.mu.Unlock()
+	return out.AccessToken, nil
+}
+
+// verifyLocally verifies the bearer token of the request with the cached key set. It reports false when the
+// token has to be verified by the forward-auth endpoint instead: no key set yet, a key the set does not hold or
+// a token bound to a certificate or DPoP key.
+func (c *Client) verifyLocally(r *http.Request) (Identity, error, bool) {
+	set := c.keySet()
+	if set == nil {
+		return Identity{}, nil, false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return Identity{}, nil, false
+	}
+
+	// compact JWE has five segments, JWS has three
+	if strings.Count(token, ".") == 4 {
+		if len(set.EncryptionKey) == 0 {
+			return Identity{}, nil, false
+		}
+		jwe, err := jose.ParseEncrypted(token, []jose.KeyAlgorithm{jose.DIRECT}, []jose.ContentEncryption{jose.A256GCM})
+		if err != nil {
+			return Identity{}, ErrUnauthorized, true
+		}
+		plaintext, err := jwe.Decrypt(set.EncryptionKey)
+		if err != nil {
+			return Identity{}, ErrUnauthorized, true
+		}
+		token = string(plaintext)
+	}
+
+	now := time.Now()
+	parsed, err := jwt.Parse(token, func(token *jwt.Token) (any, error) {
+		var keys []jwt.VerificationKey
+		kid, _ := token.Header["kid"].(string)
+		for _, key := range set.Keys {
+			if (kid == "" || key.ID == kid) && (key.NotAfter.IsZero() || now.Before(key.NotAfter)) {
+				keys = append(keys, key.Secret)
+			}
+		}
+		if len(keys) == 0 {
+			return nil, errUnknownKey
+		}
+		return jwt.VerificationKeySet{Keys: keys}, nil
+	}, jwt.WithValidMethods([]string{"HS256"}), jwt.WithExpirationRequired())
+	if errors.Is(err, errUnknownKey) {
+		return Identity{}, nil, false
+	}
+	if err != nil {
+		return Identity{}, ErrUnauthorized, true
+	}
+	claims, ok := parsed.Claims.(jwt.MapClaims)
+	if !ok {
+		return Identity{}, ErrUnauthorized, true
+	}
+	if _, bound := claims["cnf"]; bound {
+		return Identity{}, nil, false
+	}
+
+	sub, err := claims.GetSubject()
+	if err != nil || sub == "" {
+		return Identity{}, ErrUnauthorized, true
+	}
+	audiences, _ := claims.GetAudience()
+	identity := Identity{Audiences: audiences}
+	if claims["token_type"] == "service" {
+		scope, _ := claims["scope"].(string)
+		identity.ClientID, identity.Scopes = sub, strings.Fields(scope)
+	} else {
+		identity.UserID = sub
+	}
+	return identity, nil, true
+}
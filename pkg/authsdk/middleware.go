@@ -0,0 +1,80 @@
+package authsdk
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+)
+
+// Policy is the authorization policy of a route group. Empty fields are not checked, the zero policy
+// admits every authenticated caller.
+type Policy struct {
+	// Audiences admits tokens meant for at least one of them
+	Audiences []string
+	// Scopes are all required, they are only granted to service clients
+	Scopes []string
+	// Roles admits users holding at least one of them, service clients hold none
+	Roles []string
+}
+
+// Check returns ErrForbidden if the identity does not satisfy the policy.
+func (p Policy) Check(identity Identity) error {
+	if len(p.Audiences) > 0 && !slices.ContainsFunc(p.Audiences, func(aud string) bool {
+		return slices.Contains(identity.Audiences, aud)
+	}) {
+		return ErrForbidden
+	}
+	for _, scope := range p.Scopes {
+		if !slices.Contains(identity.Scopes, scope) {
+			return ErrForbidden
+		}
+	}
+	if len(p.Roles) > 0 && !slices.ContainsFunc(p.Roles, func(role string) bool {
+		return slices.Contains(identity.Roles, role)
+	}) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+type identityKey struct{}
+
+// FromContext returns the identity the middleware authenticated the request with.
+func FromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(Identity)
+	return identity, ok
+}
+
+// Middleware authenticates requests and admits the ones satisfying the policy, the handlers find the identity
+// with FromContext. It answers 401 to requests without a valid token, 403 to identities the policy does not admit
+// and 503 while the auth service cannot be reached. Policies requiring roles are checked with the forward-auth
+// endpoint, which knows the roles of users.
+func (c *Client) Middleware(policy Policy) func(http.Handler) http.Handler {
+	verify := c.Verify
+	if len(policy.Roles) > 0 {
+		verify = c.verifyRemotely
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			identity, err := verify(r.Context(), r)
+			if errors.Is(err, ErrUnauthorized) {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				if c.logger != nil {
+					c.logger.Error("Failed to verify token", "error", err)
+				}
+				http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			if err := policy.Check(identity); err != nil {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, identity)))
+		})
+	}
+}
@@ -0,0 +1,114 @@
+// Package captcha verifies CAPTCHA response tokens with the siteverify API of the provider. reCAPTCHA v3,
+// hCaptcha and Cloudflare Turnstile share the request (secret, response, remoteip) and the response shape,
+// reCAPTCHA v3 additionally grades the client with a score.
+package captcha
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Provider is a CAPTCHA service.
+type Provider string
+
+const (
+	Recaptcha Provider = "recaptcha"
+	HCaptcha  Provider = "hcaptcha"
+	Turnstile Provider = "turnstile"
+)
+
+// verifyURLs are the siteverify endpoints of the providers.
+var verifyURLs = map[Provider]string{
+	Recaptcha: "https://www.google.com/recaptcha/api/siteverify",
+	HCaptcha:  "https://api.hcaptcha.com/siteverify",
+	Turnstile: "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+}
+
+// ErrRejected is returned for tokens the provider did not accept, a score below the minimum or another action.
+var ErrRejected = errors.New("captcha: token rejected")
+
+// Client verifies tokens with one provider.
+type Client struct {
+	client   *http.Client
+	url      string
+	secret   string
+	minScore float64
+}
+
+// NewClient returns a client of the provider. minScore is the lowest accepted reCAPTCHA v3 score (0.0 to 1.0),
+// the other providers have no score.
+func NewClient(provider Provider, secret string, minScore float64, timeout time.Duration) (*Client, error) {
+	endpoint, ok := verifyURLs[provider]
+	if !ok {
+		return nil, fmt.Errorf("captcha: unknown provider %q", provider)
+	}
+	if secret == "" {
+		return nil, errors.New("captcha: secret is required")
+	}
+	return &Client{
+		client:   &http.Client{Timeout: timeout},
+		url:      endpoint,
+		secret:   secret,
+		minScore: minScore,
+	}, nil
+}
+
+// siteverifyResponse is the answer of the siteverify endpoints, Score is only sent by reCAPTCHA v3 and
+// Action by reCAPTCHA v3 and Turnstile.
+type siteverifyResponse struct {
+	Success    bool     `json:"success"`
+	Score      *float64 `json:"score"`
+	Action     string   `json:"action"`
+	ErrorCodes []string `json:"error-codes"`
+}
+
+// Verify checks the token the widget returned for the action (login, register). remoteIP is optional.
+// Tokens are single use, a token is never accepted twice by the provider.
+func (c *Client) Verify(ctx context.Context, token, action, remoteIP string) error {
+	if token == "" {
+		return fmt.Errorf("%w: no token", ErrRejected)
+	}
+	form := url.Values{}
+	form.Set("secret", c.secret)
+	form.Set("response", token)
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("captcha: siteverify returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var result siteverifyResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&result); err != nil {
+		return fmt.Errorf("captcha: malformed siteverify response: %w", err)
+	}
+
+	if !result.Success {
+		return fmt.Errorf("%w: %s", ErrRejected, strings.Join(result.ErrorCodes, ","))
+	}
+	if result.Score != nil && *result.Score < c.minScore {
+		return fmt.Errorf("%w: score %.1f", ErrRejected, *result.Score)
+	}
+	if result.Action != "" && action != "" && result.Action != action {
+		return fmt.Errorf("%w: token of action %q", ErrRejected, result.Action)
+	}
+	return nil
+}
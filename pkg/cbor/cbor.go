@@ -0,0 +1,130 @@
+// Package cbor decodes the subset of CBOR (RFC 8949) produced by WebAuthn authenticators and the App Attest service.
+package cbor
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// maxNesting bounds the depth of decoded items, authenticator data never nests deeply.
+const maxNesting = 8
+
+// ErrMalformed is returned for invalid or unsupported input.
+var ErrMalformed = errors.New("cbor: malformed CBOR")
+
+// Decode decodes the first CBOR item of data and returns it with the number of bytes it used.
+// Only the subset authenticators produce is supported: integers, byte and text strings, arrays, maps,
+// booleans and null. Integers decode to int64, maps to map[any]any keyed by int64 or string.
+func Decode(data []byte) (any, int, error) {
+	return decodeItem(data, 0)
+}
+
+func decodeItem(data []byte, depth int) (any, int, error) {
+	if depth > maxNesting {
+		return nil, 0, ErrMalformed
+	}
+	if len(data) == 0 {
+		return nil, 0, ErrMalformed
+	}
+	major, info := data[0]>>5, data[0]&0x1f
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, 1, nil
+		case 21:
+			return true, 1, nil
+		case 22:
+			return nil, 1, nil
+		}
+		return nil, 0, fmt.Errorf("%w: unsupported simple value %d", ErrMalformed, info)
+	}
+
+	arg, n, err := decodeArgument(data)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch major {
+	case 0:
+		if arg > 1<<63-1 {
+			return nil, 0, ErrMalformed
+		}
+		return int64(arg), n, nil
+	case 1:
+		if arg > 1<<63-1 {
+			return nil, 0, ErrMalformed
+		}
+		return -1 - int64(arg), n, nil
+	case 2, 3:
+		if arg > uint64(len(data)-n) {
+			return nil, 0, ErrMalformed
+		}
+		end := n + int(arg)
+		if major == 3 {
+			return string(data[n:end]), end, nil
+		}
+		return append([]byte(nil), data[n:end]...), end, nil
+	case 4:
+		// every item takes at least one byte, which bounds the allocation by the input
+		if arg > uint64(len(data)-n) {
+			return nil, 0, ErrMalformed
+		}
+		items := make([]any, 0, arg)
+		for range arg {
+			item, used, err := decodeItem(data[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			items = append(items, item)
+			n += used
+		}
+		return items, n, nil
+	case 5:
+		if arg > uint64(len(data)-n)/2 {
+			return nil, 0, ErrMalformed
+		}
+		m := make(map[any]any, arg)
+		for range arg {
+			key, used, err := decodeItem(data[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			n += used
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, 0, fmt.Errorf("%w: unsupported map key", ErrMalformed)
+			}
+			value, used, err := decodeItem(data[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			n += used
+			if _, dup := m[key]; dup {
+				return nil, 0, fmt.Errorf("%w: duplicate map key", ErrMalformed)
+			}
+			m[key] = value
+		}
+		return m, n, nil
+	}
+	return nil, 0, fmt.Errorf("%w: unsupported major type %d", ErrMalformed, major)
+}
+
+// decodeArgument returns the argument of the item head and the length of the head, indefinite lengths are rejected.
+func decodeArgument(data []byte) (uint64, int, error) {
+	info := data[0] & 0x1f
+	switch {
+	case info < 24:
+		return uint64(info), 1, nil
+	case info == 24 && len(data) >= 2:
+		return uint64(data[1]), 2, nil
+	case info == 25 && len(data) >= 3:
+		return uint64(binary.BigEndian.Uint16(data[1:])), 3, nil
+	case info == 26 && len(data) >= 5:
+		return uint64(binary.BigEndian.Uint32(data[1:])), 5, nil
+	case info == 27 && len(data) >= 9:
+		return binary.BigEndian.Uint64(data[1:]), 9, nil
+	}
+	return 0, 0, ErrMalformed
+}
@@ -4,4 +4,223 @@ import "errors"
 
 var (
 	ErrNoTagsAffected = errors.New("no rows were affected by the operation")
+
+	// OAuth token endpoint errors, messages match the RFC 6749 error codes
+	ErrInvalidClient        = errors.New("invalid_client")
+	ErrInvalidScope         = errors.New("invalid_scope")
+	ErrUnsupportedGrantType = errors.New("unsupported_grant_type")
+	// ErrInsufficientScope is returned for service tokens without the scope an endpoint requires
+	ErrInsufficientScope = errors.New("insufficient_scope")
+	// ErrInvalidRecipientKey is returned when the public key verification keys are wrapped for is not a P-256 JWK
+	ErrInvalidRecipientKey = errors.New("public_key must be a public P-256 JWK")
+	// ErrKeyDistributionDisabled is returned for verification key requests while key distribution is disabled
+	ErrKeyDistributionDisabled = errors.New("verification key distribution is disabled")
+)
+
+var (
+	// ErrReasonRequired is returned when a destructive admin action lacks a valid reason code and text
+	ErrReasonRequired = errors.New("a valid reason code and a reason text are required")
+
+	// ErrForbidden is returned when the user lacks the permission required for an action
+	ErrForbidden = errors.New("permission denied")
+)
+
+var (
+	// ErrInvalidToken is returned for unknown, expired or already used single-use tokens
+	ErrInvalidToken = errors.New("invalid or expired token")
+
+	// ErrEmailNotVerified is returned on login when verification is required and the email is not verified yet
+	ErrEmailNotVerified = errors.New("email is not verified")
+
+	// ErrPasswordPolicy wraps the reason a new password was rejected by the password policy
+	ErrPasswordPolicy = errors.New("password does not meet the policy")
+
+	// ErrInvalidCredentials is returned when a password check fails
+	ErrInvalidCredentials = errors.New("invalid credentials")
+
+	// ErrCertificateMismatch is returned when a certificate-bound token is used without the certificate it is bound to
+	ErrCertificateMismatch = errors.New("token is bound to a different client certificate")
+
+	// ErrProofKeyMismatch is returned when a DPoP-bound token or session is used without a proof of its key
+	ErrProofKeyMismatch = errors.New("token is bound to a different DPoP key")
+
+	// ErrDeletionScheduled is returned when the account deletion was already requested
+	ErrDeletionScheduled = errors.New("account deletion is already scheduled")
+
+	// ErrNoDeletionScheduled is returned when cancelling a deletion that was never requested
+	ErrNoDeletionScheduled = errors.New("no account deletion is scheduled")
+
+	// ErrUserNotFound is returned when the user does not exist (or is not in the expected state)
+	ErrUserNotFound = errors.New("user not found")
+
+	// ErrEmailTaken is returned when the email already belongs to another account
+	ErrEmailTaken = errors.New("email is already in use")
+
+	// ErrInvalidUsername is returned when a username has an invalid length
+	ErrInvalidUsername = errors.New("username must be between 3 and 30 characters")
+
+	// ErrInvalidEmail is returned when an email address has an invalid format
+	ErrInvalidEmail = errors.New("invalid email format")
+
+	// ErrInvalidPhone is returned when a phone number is not in international format
+	ErrInvalidPhone = errors.New("phone number must be in international format, e.g. +14155550123")
+
+	// ErrPhoneTaken is returned when the phone number already belongs to another account
+	ErrPhoneTaken = errors.New("phone number is already in use")
+
+	// ErrInvalidOTP is returned when a one-time code is wrong, expired or was never requested
+	ErrInvalidOTP = errors.New("invalid or expired code")
+
+	// ErrTooManyAttempts is returned when a one-time code was guessed too often, a new one has to be requested
+	ErrTooManyAttempts = errors.New("too many attempts, request a new code")
+
+	// ErrPasswordBreached is returned when a new password appears in known data breaches
+	ErrPasswordBreached = errors.New("password appears in a known data breach, choose another one")
+
+	// ErrInvalidInvite is returned when registration requires an invite code and it is missing, unknown,
+	// expired, revoked or used up
+	ErrInvalidInvite = errors.New("a valid invite code is required")
+
+	// ErrRegistrationClosed is returned when public registration is disabled
+	ErrRegistrationClosed = errors.New("registration is closed")
+
+	// ErrEmailDomainNotAllowed is returned when registration is restricted to other email domains
+	ErrEmailDomainNotAllowed = errors.New("registration is not open to this email domain")
+
+	// ErrDisposableEmail is returned when an email belongs to a throwaway email provider
+	ErrDisposableEmail = errors.New("disposable email addresses are not allowed")
+
+	// ErrUserBlocked is returned when a blocked user tries to log in
+	ErrUserBlocked = errors.New("account is blocked")
+
+	// ErrTokenRevoked is returned for access tokens revoked before their expiry
+	ErrTokenRevoked = errors.New("token has been revoked")
+
+	// ErrReauthenticationRequired is returned when a session can no longer be refreshed and the user has to log in again
+	ErrReauthenticationRequired = errors.New("session ended, log in again")
+
+	// ErrInvalidInvitation is returned when an invitation is created with invalid limits
+	ErrInvalidInvitation = errors.New("max_uses must be between 1 and 1000 and the TTL positive and within the limit")
+
+	// ErrOrganizationNotFound is returned when the organization does not exist or is already purged
+	ErrOrganizationNotFound = errors.New("organization not found")
+
+	// ErrOrganizationState is returned when a lifecycle action does not apply to the current state of the organization
+	ErrOrganizationState = errors.New("action not allowed in the current state of the organization")
+
+	// ErrInvalidOrganizationName is returned when an organization name is empty or too long
+	ErrInvalidOrganizationName = errors.New("organization name must be 1 to 255 characters")
+
+	// ErrInvalidMetadata is returned for user metadata patches with invalid keys or values
+	ErrInvalidMetadata = errors.New("invalid metadata")
+
+	// ErrMetadataTooLarge is returned when a patch would make the user metadata exceed its size or key limit
+	ErrMetadataTooLarge = errors.New("metadata exceeds the size limit")
+
+	// ErrTermsNotAccepted is returned at login and registration while current terms documents are not accepted
+	ErrTermsNotAccepted = errors.New("the current terms of service and privacy policy must be accepted")
+
+	// ErrInvalidTermsDocument is returned for unknown terms documents and invalid new versions
+	ErrInvalidTermsDocument = errors.New("invalid terms document")
+
+	// ErrTermsVersionExists is returned when a version of a terms document is published twice
+	ErrTermsVersionExists = errors.New("this version is already published")
+
+	// ErrImportTooLarge is returned when a user import has more rows than allowed in one batch
+	ErrImportTooLarge = errors.New("too many users in one import")
+
+	// ErrTokenListTooLarge is returned when a list of compromised tokens has more entries than allowed in one batch
+	ErrTokenListTooLarge = errors.New("too many tokens in one list")
+
+	// ErrObjectStoreDisabled is returned for lists read from an object store when none is configured
+	ErrObjectStoreDisabled = errors.New("no object store is configured")
+
+	// ErrInvalidPasskey is returned when a passkey ceremony fails: unknown or expired ceremony,
+	// unknown credential or a response that does not verify
+	ErrInvalidPasskey = errors.New("passkey verification failed")
+
+	// ErrPasskeyExists is returned when a credential is registered twice
+	ErrPasskeyExists = errors.New("passkey is already registered")
+
+	// ErrTooManyPasskeys is returned when the user has registered the maximum number of passkeys
+	ErrTooManyPasskeys = errors.New("too many passkeys, delete one first")
+
+	// ErrMFARequired is returned by password logins of users with two-factor authentication, the second factor
+	// was started and the login is completed with its code
+	ErrMFARequired = errors.New("two-factor authentication required")
+
+	// ErrInvalidMFAMethod is returned for unknown second factors
+	ErrInvalidMFAMethod = errors.New("unknown two-factor method")
+
+	// ErrResendThrottled is returned when a code is resent too early or too often
+	ErrResendThrottled = errors.New("code was sent recently, try again later")
+
+	// ErrInvalidPasskeyName is returned for passkey names longer than 64 characters
+	ErrInvalidPasskeyName = errors.New("passkey name must be at most 64 characters")
+
+	// ErrHandleUnavailable is returned for usernames and emails that are reserved or banned
+	ErrHandleUnavailable = errors.New("this username or email cannot be used")
+
+	// ErrUsernameTaken is returned when the username already belongs to another account
+	ErrUsernameTaken = errors.New("username is already in use")
+
+	// ErrInvalidHandle is returned when a banned handle is neither a username nor an email address
+	ErrInvalidHandle = errors.New("handle must be a username or an email address of at most 255 characters")
+
+	// ErrRefreshCookieRequired is returned when a web session is refreshed without its refresh_token cookie
+	ErrRefreshCookieRequired = errors.New("web sessions are refreshed with the refresh_token cookie")
+
+	// ErrAttestationFailed is returned when a client type requiring app attestation presents none or an invalid one
+	ErrAttestationFailed = errors.New("app attestation failed")
+
+	// ErrAttestationDisabled is returned for attestation challenges while app attestation is not configured
+	ErrAttestationDisabled = errors.New("app attestation is disabled")
+
+	// ErrCaptchaRequired is returned for registrations and logins that require a CAPTCHA and sent none or a rejected one
+	ErrCaptchaRequired = errors.New("a valid CAPTCHA token is required")
+
+	// ErrLoginLocationBlocked is returned for logins from a country logins are blocked from
+	ErrLoginLocationBlocked = errors.New("logins from this location are not allowed")
+
+	// ErrSessionBindingMismatch is returned when an enforced session is refreshed from another user agent or network than its login
+	ErrSessionBindingMismatch = errors.New("session is bound to another client, log in again")
+
+	// ErrSessionNotFound is returned when the user has no active session with the given ID
+	ErrSessionNotFound = errors.New("session not found")
+
+	// ErrSessionBlocked is returned when a session blocked by an administrator is refreshed
+	ErrSessionBlocked = errors.New("session is blocked")
+
+	// ErrInvalidSessionFilter is returned for session listings with an unknown status, sort or cursor
+	ErrInvalidSessionFilter = errors.New("status must be active, expired or blocked, sort created_at or last_used_at, and the cursor one of a previous page")
+
+	// ErrInvalidDeviceName is returned for session device names longer than 64 characters or with control characters
+	ErrInvalidDeviceName = errors.New("device name must be at most 64 characters without control characters")
+
+	// ErrElevationDisabled is returned for elevations of sessions while elevation is not configured
+	ErrElevationDisabled = errors.New("session elevation is disabled")
+
+	// ErrElevationRequired is returned for sensitive admin operations outside of the elevation of the session
+	ErrElevationRequired = errors.New("this operation requires an elevated session, authenticate again at /me/elevate")
+
+	// ErrInvalidIncident is returned for status incidents with an unknown kind or severity, no title or an end before their start
+	ErrInvalidIncident = errors.New("incident needs a kind (incident, maintenance), a severity (minor, major), a title of up to 200 characters and an end after its start")
+
+	// ErrIncidentNotFound is returned when the status incident does not exist
+	ErrIncidentNotFound = errors.New("incident not found")
+
+	// ErrIncidentResolved is returned for changes of a status incident that is already resolved
+	ErrIncidentResolved = errors.New("incident is already resolved")
+
+	// ErrUnknownFeature is returned for feature flags other than passkeys, dpop and password_hashing
+	ErrUnknownFeature = errors.New("unknown feature")
+
+	// ErrInvalidRolloutPercentage is returned for rollout percentages outside of 0 to 100
+	ErrInvalidRolloutPercentage = errors.New("rollout percentage must be between 0 and 100")
+
+	// ErrFeatureNotEnabled is returned when a flow that is being rolled out is not enabled for the user yet
+	ErrFeatureNotEnabled = errors.New("this feature is not available for the account yet")
+
+	// ErrUserNotAllowlisted is returned when removing a user who is not on the allowlist of a feature
+	ErrUserNotAllowlisted = errors.New("user is not on the allowlist of the feature")
 )
@@ -0,0 +1,80 @@
+// Package devicetrust signs the cookies of trusted devices, on which users skip the second factor of logins.
+// A cookie is base64url(device ID | user ID | expiry) "." base64url(HMAC-SHA256): it cannot be forged without
+// the key and names the stored device, so deleting the device revokes the cookie before it expires.
+package devicetrust
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrInvalidCookie = errors.New("invalid trusted device cookie")
+
+// minKeySize is the minimum length of the HMAC key in bytes.
+const minKeySize = 32
+
+// payloadSize is the length of device ID, user ID and expiry.
+const payloadSize = 16 + 16 + 8
+
+// Signer issues and checks trusted device cookies.
+type Signer struct {
+	key []byte
+}
+
+// NewSigner returns a signer of the base64 (standard encoding) key, at least 32 bytes long.
+func NewSigner(key string) (*Signer, error) {
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("trusted device key is not base64: %w", err)
+	}
+	if len(raw) < minKeySize {
+		return nil, fmt.Errorf("trusted device key must be at least %d bytes, got %d", minKeySize, len(raw))
+	}
+	return &Signer{key: raw}, nil
+}
+
+// Sign returns the cookie of the device of the user, valid until expiresAt.
+func (s *Signer) Sign(deviceID, userID uuid.UUID, expiresAt time.Time) string {
+	payload := make([]byte, 0, payloadSize)
+	payload = append(payload, deviceID[:]...)
+	payload = append(payload, userID[:]...)
+	payload = binary.BigEndian.AppendUint64(payload, uint64(expiresAt.Unix()))
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(s.mac(payload))
+}
+
+// Verify checks the signature and the expiry of the cookie and returns the device and user it was issued for.
+func (s *Signer) Verify(cookie string) (deviceID, userID uuid.UUID, err error) {
+	encPayload, encMAC, ok := strings.Cut(cookie, ".")
+	if !ok {
+		return uuid.Nil, uuid.Nil, ErrInvalidCookie
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encPayload)
+	if err != nil || len(payload) != payloadSize {
+		return uuid.Nil, uuid.Nil, ErrInvalidCookie
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(encMAC)
+	if err != nil || !hmac.Equal(mac, s.mac(payload)) {
+		return uuid.Nil, uuid.Nil, ErrInvalidCookie
+	}
+	expiresAt := time.Unix(int64(binary.BigEndian.Uint64(payload[32:])), 0)
+	if !time.Now().Before(expiresAt) {
+		return uuid.Nil, uuid.Nil, ErrInvalidCookie
+	}
+	copy(deviceID[:], payload[:16])
+	copy(userID[:], payload[16:32])
+	return deviceID, userID, nil
+}
+
+func (s *Signer) mac(payload []byte) []byte {
+	h := hmac.New(sha256.New, s.key)
+	h.Write(payload)
+	return h.Sum(nil)
+}
@@ -0,0 +1,139 @@
+// Package disposable recognizes email domains of throwaway providers. A bundled list is loaded on creation
+// and can be replaced from a file or URL in the same format: one domain per line, # starts a comment.
+package disposable
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//go:embed domains.txt
+var bundled string
+
+// Blocklist is a set of blocked domains, safe for concurrent use while being refreshed.
+type Blocklist struct {
+	domains    atomic.Pointer[map[string]struct{}]
+	httpClient *http.Client
+}
+
+// NewBlocklist returns a blocklist with the bundled domains.
+func NewBlocklist() *Blocklist {
+	b := &Blocklist{httpClient: &http.Client{Timeout: 30 * time.Second}}
+	domains, _ := parse(strings.NewReader(bundled))
+	b.domains.Store(&domains)
+	return b
+}
+
+// Blocked reports whether the domain or one of its parent domains is on the list.
+func (b *Blocklist) Blocked(domain string) bool {
+	domains := *b.domains.Load()
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	for domain != "" {
+		if _, ok := domains[domain]; ok {
+			return true
+		}
+		dot := strings.IndexByte(domain, '.')
+		if dot < 0 {
+			break
+		}
+		domain = domain[dot+1:]
+	}
+	return false
+}
+
+// Len returns the number of listed domains.
+func (b *Blocklist) Len() int {
+	return len(*b.domains.Load())
+}
+
+// Refresh replaces the list with the domains read from source, an http(s) URL or a file path.
+// The bundled domains stay blocked, an empty or unreadable source keeps the current list.
+func (b *Blocklist) Refresh(ctx context.Context, source string) error {
+	r, err := b.open(ctx, source)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	domains, err := parse(r)
+	if err != nil {
+		return fmt.Errorf("failed to read blocklist %s: %w", source, err)
+	}
+	if len(domains) == 0 {
+		return fmt.Errorf("blocklist %s is empty", source)
+	}
+	base, _ := parse(strings.NewReader(bundled))
+	for domain := range base {
+		domains[domain] = struct{}{}
+	}
+	b.domains.Store(&domains)
+	return nil
+}
+
+// Run refreshes the list from source every interval until the context is cancelled, failures are logged
+// and the previous list is kept.
+func (b *Blocklist) Run(ctx context.Context, source string, interval time.Duration, logger *slog.Logger) {
+	refresh := func() {
+		if err := b.Refresh(ctx, source); err != nil {
+			logger.Error("Failed to refresh disposable email blocklist", "source", source, "error", err)
+			return
+		}
+		logger.Info("Disposable email blocklist refreshed", "source", source, "domains", b.Len())
+	}
+
+	refresh()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			refresh()
+		}
+	}
+}
+
+func (b *Blocklist) open(ctx context.Context, source string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return os.Open(source)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("blocklist %s returned status %d", source, resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+func parse(r io.Reader) (map[string]struct{}, error) {
+	domains := make(map[string]struct{})
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.ToLower(strings.TrimSpace(line))
+		if line != "" {
+			domains[line] = struct{}{}
+		}
+	}
+	return domains, scanner.Err()
+}
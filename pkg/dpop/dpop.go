@@ -0,0 +1,190 @@
+// Package dpop verifies DPoP proofs (RFC 9449), which bind access and refresh tokens
+// to a key pair held by the client instead of to a bearer secret.
+package dpop
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+// HeaderName is the request header carrying the proof.
+const HeaderName = "DPoP"
+
+// proofType is the mandatory typ header of a proof JWT.
+const proofType = "dpop+jwt"
+
+var (
+	// ErrNoProof is returned when the request carries no DPoP header.
+	ErrNoProof = errors.New("dpop: missing proof")
+
+	// ErrInvalidProof is returned for malformed, mis-signed or mismatching proofs.
+	ErrInvalidProof = errors.New("dpop: invalid proof")
+
+	// ErrReplayedProof is returned when the jti of the proof was already used.
+	ErrReplayedProof = errors.New("dpop: proof replayed")
+)
+
+// signatureAlgorithms are the asymmetric algorithms accepted for proofs, symmetric ones are forbidden by the RFC.
+var signatureAlgorithms = []jose.SignatureAlgorithm{
+	jose.ES256, jose.ES384, jose.ES512,
+	jose.RS256, jose.RS384, jose.RS512,
+	jose.PS256, jose.PS384, jose.PS512,
+	jose.EdDSA,
+}
+
+// SigningAlgorithms returns the names of the algorithms accepted for proofs, as advertised in server metadata.
+func SigningAlgorithms() []string {
+	names := make([]string, len(signatureAlgorithms))
+	for i, alg := range signatureAlgorithms {
+		names[i] = string(alg)
+	}
+	return names
+}
+
+// ReplayCache remembers the jti of accepted proofs.
+type ReplayCache interface {
+	// Remember stores the key for ttl and reports false if it was already present.
+	Remember(ctx context.Context, key string, ttl time.Duration) (bool, error)
+}
+
+type claims struct {
+	JTI string `json:"jti"`
+	HTM string `json:"htm"`
+	HTU string `json:"htu"`
+	IAT int64  `json:"iat"`
+	ATH string `json:"ath"`
+}
+
+// Verifier checks proofs against the request they were sent with.
+type Verifier struct {
+	cache ReplayCache
+	// maxAge is how far the iat of a proof may be from the current time, in both directions
+	maxAge time.Duration
+}
+
+func NewVerifier(cache ReplayCache, maxAge time.Duration) *Verifier {
+	return &Verifier{
+		cache:  cache,
+		maxAge: maxAge,
+	}
+}
+
+// Verify validates the proof for a request with the given method and URL and returns the JWK SHA-256
+// thumbprint (jkt) of the key that signed it. When accessToken is not empty the proof must carry its hash (ath),
+// as required when presenting a DPoP-bound token to a resource.
+func (v *Verifier) Verify(ctx context.Context, proof, method, uri, accessToken string) (string, error) {
+	if proof == "" {
+		return "", ErrNoProof
+	}
+	jws, err := jose.ParseSigned(proof, signatureAlgorithms)
+	if err != nil || len(jws.Signatures) != 1 {
+		return "", ErrInvalidProof
+	}
+	header := jws.Signatures[0].Protected
+	if typ, _ := header.ExtraHeaders[jose.HeaderType].(string); typ != proofType {
+		return "", ErrInvalidProof
+	}
+	jwk := header.JSONWebKey
+	if jwk == nil || !jwk.IsPublic() || !jwk.Valid() {
+		return "", ErrInvalidProof
+	}
+
+	payload, err := jws.Verify(jwk.Key)
+	if err != nil {
+		return "", ErrInvalidProof
+	}
+	var c claims
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return "", ErrInvalidProof
+	}
+
+	if c.JTI == "" || c.HTM != method || !sameURI(c.HTU, uri) {
+		return "", ErrInvalidProof
+	}
+	issuedAt := time.Unix(c.IAT, 0)
+	if time.Since(issuedAt) > v.maxAge || time.Until(issuedAt) > v.maxAge {
+		return "", ErrInvalidProof
+	}
+	if accessToken != "" && c.ATH != TokenHash(accessToken) {
+		return "", ErrInvalidProof
+	}
+
+	thumbprint, err := jwk.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", ErrInvalidProof
+	}
+	jkt := base64.RawURLEncoding.EncodeToString(thumbprint)
+
+	// a proof is accepted for at most 2*maxAge, the jti only has to be remembered that long
+	fresh, err := v.cache.Remember(ctx, "dpop:jti:"+jkt+":"+c.JTI, 2*v.maxAge)
+	if err != nil {
+		return "", err
+	}
+	if !fresh {
+		return "", ErrReplayedProof
+	}
+	return jkt, nil
+}
+
+// TokenHash returns the ath value of an access token: base64url of its SHA-256.
+func TokenHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// RequestURL reconstructs the htu of a request, honouring the forwarded scheme and host of a reverse proxy.
+func RequestURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	host := r.Host
+	if forwarded := r.Header.Get("X-Forwarded-Host"); forwarded != "" {
+		host = forwarded
+	}
+	return scheme + "://" + host + r.URL.Path
+}
+
+// sameURI compares two URIs ignoring query, fragment and the case of scheme and host (RFC 9449 section 4.3).
+func sameURI(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(ua.Scheme, ub.Scheme) &&
+		strings.EqualFold(ua.Host, ub.Host) &&
+		ua.Path == ub.Path
+}
+
+// ParseAuthorization splits an Authorization header of the Bearer or DPoP scheme (RFC 9449 section 7.1)
+// and reports whether the DPoP scheme was used.
+func ParseAuthorization(header string) (token string, isDPoP bool, ok bool) {
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || token == "" {
+		return "", false, false
+	}
+	switch {
+	case strings.EqualFold(scheme, "Bearer"):
+		return token, false, true
+	case strings.EqualFold(scheme, "DPoP"):
+		return token, true, true
+	}
+	return "", false, false
+}
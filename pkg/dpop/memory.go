@@ -0,0 +1,40 @@
+package dpop
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryReplayCache is a ReplayCache of a single instance, for deployments without Redis.
+type MemoryReplayCache struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	lastSweep time.Time
+}
+
+func NewMemoryReplayCache() *MemoryReplayCache {
+	return &MemoryReplayCache{seen: make(map[string]time.Time)}
+}
+
+// Remember stores the key until ttl passes.
+func (c *MemoryReplayCache) Remember(_ context.Context, key string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if expiresAt, ok := c.seen[key]; ok && now.Before(expiresAt) {
+		return false, nil
+	}
+	// proofs expire quickly, so an occasional sweep keeps the map at the size of one proof lifetime
+	if now.Sub(c.lastSweep) > time.Second {
+		for k, expiresAt := range c.seen {
+			if now.After(expiresAt) {
+				delete(c.seen, k)
+			}
+		}
+		c.lastSweep = now
+	}
+	c.seen[key] = now.Add(ttl)
+	return true, nil
+}
@@ -0,0 +1,22 @@
+package dpop
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisReplayCache is a ReplayCache shared by all instances of the service.
+type RedisReplayCache struct {
+	client *redis.Client
+}
+
+func NewRedisReplayCache(client *redis.Client) *RedisReplayCache {
+	return &RedisReplayCache{client: client}
+}
+
+// Remember stores the key with SETNX, so concurrent requests with the same proof are detected too.
+func (c *RedisReplayCache) Remember(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return c.client.SetNX(ctx, key, 1, ttl).Result()
+}
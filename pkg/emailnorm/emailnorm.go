@@ -0,0 +1,42 @@
+// Package emailnorm normalizes email addresses before they are stored or looked up. The normalized form is
+// what the account keeps as its email, the canonical form decides whether two addresses belong to the same
+// mailbox and is what uniqueness is enforced on.
+package emailnorm
+
+import "strings"
+
+// gmailDomains deliver to the same mailboxes, dots and +tags in the local part are ignored by Gmail.
+var gmailDomains = map[string]bool{
+	"gmail.com":      true,
+	"googlemail.com": true,
+}
+
+// Normalizer derives the normalized and canonical forms of email addresses.
+type Normalizer struct {
+	// CollapseGmail removes dots and +tags from Gmail addresses in the canonical form,
+	// so f.o.o+news@gmail.com and foo@googlemail.com are the same account
+	CollapseGmail bool
+}
+
+// Normalize trims surrounding whitespace and lowercases the address.
+func (n Normalizer) Normalize(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Canonical returns the form used for uniqueness and lookups, the normalized address unless Gmail
+// collapsing applies.
+func (n Normalizer) Canonical(email string) string {
+	email = n.Normalize(email)
+	if !n.CollapseGmail {
+		return email
+	}
+	at := strings.LastIndexByte(email, '@')
+	if at < 0 || !gmailDomains[email[at+1:]] {
+		return email
+	}
+	local := email[:at]
+	if plus := strings.IndexByte(local, '+'); plus >= 0 {
+		local = local[:plus]
+	}
+	return strings.ReplaceAll(local, ".", "") + "@gmail.com"
+}
@@ -0,0 +1,245 @@
+// Package fieldcrypt encrypts personal data in database columns with envelope encryption. Values are encrypted
+// with AES-256-GCM data keys, which are stored in the database wrapped (encrypted) with a master key kept out of
+// it, so that a dump of the database alone reveals neither the keys nor the data.
+//
+// Encrypted values are text: enc:v1:<key id>:<base64 nonce and ciphertext>. Values without the prefix are
+// plaintext written before encryption was enabled and are returned as they are, so rows are encrypted as they
+// are written or by a backfill. Encryption is randomized, columns that are looked up store a blind index instead,
+// idx:<hex HMAC-SHA256 of the value>.
+package fieldcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Purposes of data keys.
+const (
+	// PurposeData keys encrypt values, the newest usable one encrypts and all decrypt
+	PurposeData = "data"
+	// PurposeIndex is the single key of the blind indexes, never rotated since indexes could not be looked up
+	PurposeIndex = "index"
+)
+
+const (
+	valuePrefix = "enc:v1:"
+	indexPrefix = "idx:"
+	// keyBytes is the size of master and data keys, AES-256
+	keyBytes = 32
+)
+
+var (
+	// ErrUnknownKey is returned for values encrypted with a data key the cipher does not have
+	ErrUnknownKey = errors.New("fieldcrypt: value encrypted with an unknown data key")
+	// ErrMalformed is returned for values with the prefix of encrypted values that cannot be decrypted
+	ErrMalformed = errors.New("fieldcrypt: malformed encrypted value")
+)
+
+// DataKey is a data key as stored, wrapped with the master key.
+type DataKey struct {
+	ID        int32
+	Purpose   string
+	Wrapped   []byte
+	CreatedAt time.Time
+}
+
+// NewKey returns a random key.
+func NewKey() []byte {
+	key := make([]byte, keyBytes)
+	rand.Read(key)
+	return key
+}
+
+// WrapKey encrypts a data key with the master key.
+func WrapKey(master, key []byte) ([]byte, error) {
+	aead, err := newAEAD(master)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	rand.Read(nonce)
+	return aead.Seal(nonce, nonce, key, []byte("fieldcrypt data key")), nil
+}
+
+// UnwrapKey decrypts a data key wrapped with the master key.
+func UnwrapKey(master, wrapped []byte) ([]byte, error) {
+	aead, err := newAEAD(master)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("fieldcrypt: wrapped key too short")
+	}
+	key, err := aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], []byte("fieldcrypt data key"))
+	if err != nil {
+		return nil, errors.New("fieldcrypt: data key not wrapped with this master key")
+	}
+	return key, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != keyBytes {
+		return nil, fmt.Errorf("fieldcrypt: keys must be %d bytes", keyBytes)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// keyring is the unwrapped data keys of a Cipher, replaced as a whole by Load.
+type keyring struct {
+	data   map[int32]cipher.AEAD
+	active int32
+	index  []byte
+}
+
+// Cipher encrypts and decrypts column values. A nil Cipher leaves values in plaintext, it still reads the
+// plaintext ones.
+type Cipher struct {
+	master []byte
+	// activation is how long a new data key is only used to decrypt, so that every instance knows it before
+	// any instance encrypts with it
+	activation time.Duration
+	keys       atomic.Pointer[keyring]
+}
+
+// NewCipher returns a cipher of the data keys wrapped with the master key, see Load.
+func NewCipher(master []byte, keys []DataKey, activation time.Duration) (*Cipher, error) {
+	c := &Cipher{master: master, activation: activation}
+	if err := c.Load(keys); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Load replaces the data keys of the cipher. The keys need an index key and a data key, values are encrypted
+// with the newest data key older than the activation delay, or the oldest one when none is.
+func (c *Cipher) Load(keys []DataKey) error {
+	ring := &keyring{data: make(map[int32]cipher.AEAD)}
+	var active, oldest *DataKey
+	for _, k := range keys {
+		key, err := UnwrapKey(c.master, k.Wrapped)
+		if err != nil {
+			return fmt.Errorf("data key %d: %w", k.ID, err)
+		}
+		switch k.Purpose {
+		case PurposeIndex:
+			ring.index = key
+		case PurposeData:
+			aead, err := newAEAD(key)
+			if err != nil {
+				return err
+			}
+			ring.data[k.ID] = aead
+			if oldest == nil || k.CreatedAt.Before(oldest.CreatedAt) {
+				oldest = &k
+			}
+			if time.Since(k.CreatedAt) >= c.activation && (active == nil || k.CreatedAt.After(active.CreatedAt)) {
+				active = &k
+			}
+		}
+	}
+	if ring.index == nil || len(ring.data) == 0 {
+		return errors.New("fieldcrypt: an index key and a data key are required")
+	}
+	if active == nil {
+		active = oldest
+	}
+	ring.active = active.ID
+	c.keys.Store(ring)
+	return nil
+}
+
+// Encrypt returns the encrypted value, empty values stay empty.
+func (c *Cipher) Encrypt(value string) string {
+	if c == nil || value == "" {
+		return value
+	}
+	ring := c.keys.Load()
+	aead := ring.data[ring.active]
+	nonce := make([]byte, aead.NonceSize())
+	rand.Read(nonce)
+	id := strconv.FormatInt(int64(ring.active), 10)
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(id))
+	return valuePrefix + id + ":" + base64.RawStdEncoding.EncodeToString(sealed)
+}
+
+// Decrypt returns the plaintext of an encrypted value, other values are returned as they are.
+func (c *Cipher) Decrypt(value string) (string, error) {
+	rest, ok := strings.CutPrefix(value, valuePrefix)
+	if !ok {
+		return value, nil
+	}
+	if c == nil {
+		return "", ErrUnknownKey
+	}
+	id, payload, ok := strings.Cut(rest, ":")
+	keyID, err := strconv.ParseInt(id, 10, 32)
+	if !ok || err != nil {
+		return "", ErrMalformed
+	}
+	aead, ok := c.keys.Load().data[int32(keyID)]
+	if !ok {
+		return "", ErrUnknownKey
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(payload)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", ErrMalformed
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(id))
+	if err != nil {
+		return "", ErrMalformed
+	}
+	return string(plaintext), nil
+}
+
+// Index returns the blind index of a value looked up by equality, the value itself without a cipher.
+// Empty values stay empty.
+func (c *Cipher) Index(value string) string {
+	if c == nil || value == "" {
+		return value
+	}
+	mac := hmac.New(sha256.New, c.keys.Load().index)
+	mac.Write([]byte(value))
+	return indexPrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Encrypted reports whether the value is encrypted or a blind index.
+func Encrypted(value string) bool {
+	return strings.HasPrefix(value, valuePrefix) || strings.HasPrefix(value, indexPrefix)
+}
+
+// EncryptAddr returns the encrypted address, nil for the zero address to store NULL.
+func (c *Cipher) EncryptAddr(ip netip.Addr) *string {
+	if !ip.IsValid() {
+		return nil
+	}
+	value := c.Encrypt(ip.String())
+	return &value
+}
+
+// DecryptAddr returns the address of an encrypted or plain address, the zero address for NULL.
+func (c *Cipher) DecryptAddr(value *string) (netip.Addr, error) {
+	if value == nil || *value == "" {
+		return netip.Addr{}, nil
+	}
+	plaintext, err := c.Decrypt(*value)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return netip.ParseAddr(plaintext)
+}
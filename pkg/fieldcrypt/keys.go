@@ -0,0 +1,97 @@
+package fieldcrypt
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// KeyStore stores the wrapped data keys.
+type KeyStore interface {
+	DataKeys(ctx context.Context) ([]DataKey, error)
+	// StoreDataKey adds a key, an index key is only added when there is none yet
+	StoreDataKey(ctx context.Context, purpose string, wrapped []byte) error
+}
+
+// ParseMasterKey decodes a base64 master key.
+func ParseMasterKey(encoded string) ([]byte, error) {
+	master, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("fieldcrypt: master key must be base64: %w", err)
+	}
+	if len(master) != keyBytes {
+		return nil, fmt.Errorf("fieldcrypt: master key must be %d bytes", keyBytes)
+	}
+	return master, nil
+}
+
+// Open returns a cipher of the keys of the store, see NewCipher. The index key and the first data key are
+// created when the store has none, by whichever instance starts first.
+func Open(ctx context.Context, store KeyStore, master []byte, activation time.Duration) (*Cipher, error) {
+	keys, err := store.DataKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var hasIndex, hasData bool
+	for _, k := range keys {
+		hasIndex = hasIndex || k.Purpose == PurposeIndex
+		hasData = hasData || k.Purpose == PurposeData
+	}
+	if !hasIndex || !hasData {
+		if !hasIndex {
+			if err := storeNewKey(ctx, store, master, PurposeIndex); err != nil {
+				return nil, err
+			}
+		}
+		if !hasData {
+			if err := storeNewKey(ctx, store, master, PurposeData); err != nil {
+				return nil, err
+			}
+		}
+		if keys, err = store.DataKeys(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return NewCipher(master, keys, activation)
+}
+
+// Rotate adds a new data key to the store. Instances encrypt with it once it is older than their activation
+// delay, values encrypted with the older keys are still decrypted.
+func Rotate(ctx context.Context, store KeyStore, master []byte) error {
+	return storeNewKey(ctx, store, master, PurposeData)
+}
+
+func storeNewKey(ctx context.Context, store KeyStore, master []byte, purpose string) error {
+	wrapped, err := WrapKey(master, NewKey())
+	if err != nil {
+		return err
+	}
+	return store.StoreDataKey(ctx, purpose, wrapped)
+}
+
+// Watch reloads the keys of the store every interval until the context is cancelled, failed reloads are
+// logged and the keys in use kept.
+func (c *Cipher) Watch(ctx context.Context, logger *slog.Logger, store KeyStore, interval time.Duration) {
+	if c == nil || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			keys, err := store.DataKeys(ctx)
+			if err == nil {
+				err = c.Load(keys)
+			}
+			if err != nil {
+				logger.Error("Failed to reload data keys", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+// Package fingerprint derives the client fingerprint stored with sessions. In privacy mode raw IP
+// addresses and user agents are replaced by keyed hashes, which still allow matching the same client
+// (rate limiting, device recognition, abuse detection) but cannot be reversed without the salt.
+package fingerprint
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"main/domain/entity"
+	"net/netip"
+)
+
+// Hasher computes salted fingerprints with HMAC-SHA256.
+type Hasher struct {
+	salt []byte
+	// privacyMode drops the raw user agent and the host part of the IP address
+	privacyMode bool
+}
+
+func NewHasher(salt []byte, privacyMode bool) *Hasher {
+	return &Hasher{
+		salt:        salt,
+		privacyMode: privacyMode,
+	}
+}
+
+// Fingerprint returns what is stored about the client of a session. The hashes are always set,
+// the raw values only outside privacy mode, in privacy mode the IP is reduced to its network (/24, /48).
+func (h *Hasher) Fingerprint(ip netip.Addr, userAgent string) entity.ClientFingerprint {
+	fp := entity.ClientFingerprint{
+		IP:         ip,
+		UserAgent:  userAgent,
+		IPHash:     h.hash("ip", ip.String()),
+		DeviceHash: h.hash("ua", userAgent),
+	}
+	if h.privacyMode {
+		fp.IP = Network(ip)
+		fp.UserAgent = ""
+	}
+	return fp
+}
+
+// RateLimitKey returns the key client requests are counted under, the hashed IP in privacy mode.
+func (h *Hasher) RateLimitKey(ip string) string {
+	if h.privacyMode {
+		return h.hash("ip", ip)
+	}
+	return ip
+}
+
+// hash returns the hex HMAC of the value, the kind separates the hash spaces of different inputs.
+func (h *Hasher) hash(kind, value string) string {
+	mac := hmac.New(sha256.New, h.salt)
+	mac.Write([]byte(kind + ":" + value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Network returns the address with the host bits cleared: /24 for IPv4, /48 for IPv6.
+func Network(ip netip.Addr) netip.Addr {
+	bits := 48
+	if ip.Unmap().Is4() {
+		ip = ip.Unmap()
+		bits = 24
+	}
+	prefix, err := ip.Prefix(bits)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return prefix.Addr()
+}
@@ -0,0 +1,127 @@
+// Package geoip maps IP addresses to countries and cities. It reads MaxMind DB files (GeoLite2, DB-IP Lite mmdb)
+// and databases of address ranges in CSV form, either one start_ip,end_ip,country_code line per range like the
+// free DB-IP Lite country database or the start_ip,end_ip,continent,country,region,city,... lines of the DB-IP
+// Lite city database.
+package geoip
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"main/domain/entity"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Database locates addresses.
+type Database interface {
+	// Locate returns the country and city of the address, empty fields for unknown ones.
+	Locate(ip netip.Addr) entity.GeoLocation
+}
+
+// Load reads a database, files ending in .mmdb as MaxMind DB and all others as CSV.
+func Load(path string) (Database, error) {
+	if strings.HasSuffix(strings.ToLower(path), ".mmdb") {
+		return LoadMMDB(path)
+	}
+	return LoadCountries(path)
+}
+
+// cityColumns is the number of columns of the DB-IP Lite city database, which has the country in
+// its fourth and the city in its sixth column.
+const cityColumns = 8
+
+// Countries is an in-memory range database, safe for concurrent lookups.
+type Countries struct {
+	// ranges are sorted by their start address and do not overlap
+	ranges []countryRange
+}
+
+type countryRange struct {
+	start, end netip.Addr
+	country    string
+	city       string
+}
+
+// LoadCountries reads the database from a CSV file.
+func LoadCountries(path string) (*Countries, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseCountries(f)
+}
+
+// ParseCountries reads the database from CSV. A header line is skipped, ranges with an unknown country
+// (empty, "-" or "ZZ") are left out.
+func ParseCountries(r io.Reader) (*Countries, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	var ranges []countryRange
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < 3 {
+			return nil, fmt.Errorf("line %d: expected start_ip,end_ip,country_code", line)
+		}
+		start, err := netip.ParseAddr(strings.TrimSpace(record[0]))
+		if err != nil {
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		end, err := netip.ParseAddr(strings.TrimSpace(record[1]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		start, end = start.Unmap(), end.Unmap()
+		if start.Is4() != end.Is4() || end.Less(start) {
+			return nil, fmt.Errorf("line %d: invalid range %s-%s", line, start, end)
+		}
+		country, city := record[2], ""
+		if len(record) >= cityColumns {
+			country, city = record[3], strings.TrimSpace(record[5])
+		}
+		country = strings.ToUpper(strings.TrimSpace(country))
+		if len(country) != 2 || country == "ZZ" {
+			continue
+		}
+		ranges = append(ranges, countryRange{start: start, end: end, country: country, city: city})
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].start.Less(ranges[j].start) })
+	return &Countries{ranges: ranges}, nil
+}
+
+// Country returns the ISO 3166-1 alpha-2 code of the country of the address, empty if it is unknown.
+func (c *Countries) Country(ip netip.Addr) string {
+	return c.Locate(ip).Country
+}
+
+// Locate returns the country and city of the address, empty fields for unknown ones. Country databases
+// have no cities.
+func (c *Countries) Locate(ip netip.Addr) entity.GeoLocation {
+	ip = ip.Unmap()
+	// the last range starting at or before the address is the only one that can contain it
+	i := sort.Search(len(c.ranges), func(i int) bool { return ip.Less(c.ranges[i].start) }) - 1
+	if i < 0 || c.ranges[i].end.Less(ip) {
+		return entity.GeoLocation{}
+	}
+	return entity.GeoLocation{Country: c.ranges[i].country, City: c.ranges[i].city}
+}
+
+// Len returns the number of ranges in the database.
+func (c *Countries) Len() int {
+	return len(c.ranges)
+}
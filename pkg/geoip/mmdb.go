@@ -0,0 +1,339 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"main/domain/entity"
+	"math"
+	"net/netip"
+	"os"
+)
+
+// metadataMarker starts the metadata section at the end of a MaxMind DB file.
+var metadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// dataSectionSeparator is the number of zero bytes between the search tree and the data section.
+const dataSectionSeparator = 16
+
+// maxPointerDepth bounds pointers to pointers, a malformed file must not recurse without end.
+const maxPointerDepth = 32
+
+// MMDB is a database in the MaxMind DB format, like GeoLite2 City and Country or the DB-IP Lite mmdb files.
+// The whole file is kept in memory, lookups are safe for concurrent use.
+type MMDB struct {
+	buf        []byte
+	nodeCount  uint32
+	recordSize int
+	// treeSize is the length of the search tree, the data section starts dataSectionSeparator bytes later
+	treeSize int
+	// ipv4Start is the node of ::/96 in an IPv6 tree, where the IPv4 addresses are
+	ipv4Start uint32
+	ipVersion int
+}
+
+// LoadMMDB reads a MaxMind DB file.
+func LoadMMDB(path string) (*MMDB, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseMMDB(buf)
+}
+
+// ParseMMDB reads a MaxMind DB from its bytes, which must not be modified afterwards.
+func ParseMMDB(buf []byte) (*MMDB, error) {
+	i := bytes.LastIndex(buf, metadataMarker)
+	if i < 0 {
+		return nil, errors.New("geoip: not a MaxMind DB, metadata not found")
+	}
+	metaStart := i + len(metadataMarker)
+	meta, _, err := (&decoder{buf: buf[metaStart:]}).decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("geoip: metadata: %w", err)
+	}
+	m, _ := meta.(map[string]any)
+	nodeCount, _ := m["node_count"].(uint64)
+	recordSize, _ := m["record_size"].(uint64)
+	ipVersion, _ := m["ip_version"].(uint64)
+	if recordSize != 24 && recordSize != 28 && recordSize != 32 {
+		return nil, fmt.Errorf("geoip: unsupported record size %d", recordSize)
+	}
+	if ipVersion != 4 && ipVersion != 6 {
+		return nil, fmt.Errorf("geoip: unsupported IP version %d", ipVersion)
+	}
+	db := &MMDB{
+		buf:        buf[:i],
+		nodeCount:  uint32(nodeCount),
+		recordSize: int(recordSize),
+		treeSize:   int(nodeCount) * int(recordSize) / 4,
+		ipVersion:  int(ipVersion),
+	}
+	if db.treeSize+dataSectionSeparator > len(db.buf) {
+		return nil, errors.New("geoip: search tree exceeds the file")
+	}
+	if db.ipVersion == 6 {
+		node := uint32(0)
+		for range 96 {
+			if node >= db.nodeCount {
+				break
+			}
+			if node, err = db.record(node, 0); err != nil {
+				return nil, err
+			}
+		}
+		db.ipv4Start = node
+	}
+	return db, nil
+}
+
+// Locate returns the country and city of the address, empty fields for unknown ones.
+func (db *MMDB) Locate(ip netip.Addr) entity.GeoLocation {
+	record, err := db.lookup(ip)
+	if err != nil || record == nil {
+		return entity.GeoLocation{}
+	}
+	var loc entity.GeoLocation
+	if country, ok := record["country"].(map[string]any); ok {
+		loc.Country, _ = country["iso_code"].(string)
+	}
+	if loc.Country == "" {
+		// anycast and satellite ranges only have the country of the registration
+		if country, ok := record["registered_country"].(map[string]any); ok {
+			loc.Country, _ = country["iso_code"].(string)
+		}
+	}
+	if city, ok := record["city"].(map[string]any); ok {
+		if names, ok := city["names"].(map[string]any); ok {
+			loc.City, _ = names["en"].(string)
+		}
+	}
+	return loc
+}
+
+// Country returns the ISO 3166-1 alpha-2 code of the country of the address, empty if it is unknown.
+func (db *MMDB) Country(ip netip.Addr) string {
+	return db.Locate(ip).Country
+}
+
+// lookup walks the search tree along the bits of the address and decodes the record it ends at,
+// nil for addresses without one.
+func (db *MMDB) lookup(ip netip.Addr) (map[string]any, error) {
+	ip = ip.Unmap()
+	if !ip.IsValid() || (ip.Is6() && db.ipVersion == 4) {
+		return nil, nil
+	}
+	raw := ip.AsSlice()
+	node := uint32(0)
+	if ip.Is4() && db.ipVersion == 6 {
+		node = db.ipv4Start
+	}
+	for i := 0; i < len(raw)*8 && node < db.nodeCount; i++ {
+		bit := (raw[i/8] >> (7 - i%8)) & 1
+		next, err := db.record(node, int(bit))
+		if err != nil {
+			return nil, err
+		}
+		node = next
+	}
+	if node <= db.nodeCount {
+		// nodeCount itself marks addresses without data
+		return nil, nil
+	}
+	offset := int(node-db.nodeCount) - dataSectionSeparator
+	d := &decoder{buf: db.buf[db.treeSize+dataSectionSeparator:]}
+	value, _, err := d.decode(offset, 0)
+	if err != nil {
+		return nil, err
+	}
+	record, _ := value.(map[string]any)
+	return record, nil
+}
+
+// record returns the left (0) or right (1) record of the node.
+func (db *MMDB) record(node uint32, side int) (uint32, error) {
+	size := db.recordSize / 4
+	start := int(node) * size
+	if start+size > db.treeSize {
+		return 0, errors.New("geoip: node outside of the search tree")
+	}
+	b := db.buf[start : start+size]
+	switch db.recordSize {
+	case 24:
+		b = b[side*3:]
+		return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2]), nil
+	case 28:
+		// the middle byte holds the high nibbles of both records
+		if side == 0 {
+			return uint32(b[3]&0xF0)<<20 | uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2]), nil
+		}
+		return uint32(b[3]&0x0F)<<24 | uint32(b[4])<<16 | uint32(b[5])<<8 | uint32(b[6]), nil
+	default:
+		return binary.BigEndian.Uint32(b[side*4:]), nil
+	}
+}
+
+// Types of the MaxMind DB data section.
+const (
+	typeExtended = 0
+	typePointer  = 1
+	typeString   = 2
+	typeDouble   = 3
+	typeBytes    = 4
+	typeUint16   = 5
+	typeUint32   = 6
+	typeMap      = 7
+	typeInt32    = 8
+	typeUint64   = 9
+	typeUint128  = 10
+	typeArray    = 11
+	typeBool     = 14
+	typeFloat    = 15
+)
+
+var errTruncated = errors.New("truncated data")
+
+// decoder decodes values of a data section. Maps become map[string]any, arrays []any, all unsigned integers
+// uint64 (uint128 only keeps its low 64 bits), floats float64 and int32 int64.
+type decoder struct {
+	buf []byte
+}
+
+// decode decodes the value at offset and returns the offset following it.
+func (d *decoder) decode(offset, depth int) (any, int, error) {
+	typ, size, offset, err := d.control(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	if typ == typePointer {
+		if depth >= maxPointerDepth {
+			return nil, 0, errors.New("pointers nested too deeply")
+		}
+		value, _, err := d.decode(size, depth+1)
+		return value, offset, err
+	}
+	switch typ {
+	case typeMap:
+		m := make(map[string]any, size)
+		for range size {
+			var key, value any
+			if key, offset, err = d.decode(offset, depth); err != nil {
+				return nil, 0, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, 0, errors.New("map key is not a string")
+			}
+			if value, offset, err = d.decode(offset, depth); err != nil {
+				return nil, 0, err
+			}
+			m[name] = value
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]any, 0, min(size, 1024))
+		for range size {
+			var value any
+			if value, offset, err = d.decode(offset, depth); err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+		}
+		return a, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	}
+
+	if offset+size > len(d.buf) {
+		return nil, 0, errTruncated
+	}
+	b := d.buf[offset : offset+size]
+	next := offset + size
+	switch typ {
+	case typeString:
+		return string(b), next, nil
+	case typeBytes:
+		return append([]byte(nil), b...), next, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, errors.New("double is not 8 bytes")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), next, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, errors.New("float is not 4 bytes")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), next, nil
+	case typeUint16, typeUint32, typeUint64, typeUint128:
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		return n, next, nil
+	case typeInt32:
+		var n uint32
+		for _, c := range b {
+			n = n<<8 | uint32(c)
+		}
+		return int64(int32(n)), next, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported data type %d", typ)
+}
+
+// control reads the control byte at offset and returns the type and size of the value and the offset of its
+// payload. The size of a pointer is the offset it points to.
+func (d *decoder) control(offset int) (typ, size, next int, err error) {
+	if offset < 0 || offset >= len(d.buf) {
+		return 0, 0, 0, errTruncated
+	}
+	ctrl := d.buf[offset]
+	offset++
+	typ = int(ctrl >> 5)
+
+	if typ == typePointer {
+		n := int(ctrl>>3) & 0x3
+		if offset+n+1 > len(d.buf) {
+			return 0, 0, 0, errTruncated
+		}
+		b := d.buf[offset : offset+n+1]
+		v := int(ctrl & 0x7)
+		switch n {
+		case 0:
+			size = v<<8 | int(b[0])
+		case 1:
+			size = (v<<16 | int(b[0])<<8 | int(b[1])) + 2048
+		case 2:
+			size = (v<<24 | int(b[0])<<16 | int(b[1])<<8 | int(b[2])) + 526336
+		default:
+			size = int(binary.BigEndian.Uint32(b))
+		}
+		return typ, size, offset + n + 1, nil
+	}
+
+	if typ == typeExtended {
+		if offset >= len(d.buf) {
+			return 0, 0, 0, errTruncated
+		}
+		typ = 7 + int(d.buf[offset])
+		offset++
+	}
+	size = int(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > len(d.buf) {
+			return 0, 0, 0, errTruncated
+		}
+		b := d.buf[offset : offset+n]
+		switch n {
+		case 1:
+			size = 29 + int(b[0])
+		case 2:
+			size = 285 + (int(b[0])<<8 | int(b[1]))
+		default:
+			size = 65821 + (int(b[0])<<16 | int(b[1])<<8 | int(b[2]))
+		}
+		offset += n
+	}
+	return typ, size, offset, nil
+}
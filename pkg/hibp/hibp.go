@@ -0,0 +1,179 @@
+// Package hibp checks passwords against Have I Been Pwned with the k-anonymity range API: only the first
+// five hex characters of the SHA-1 of a password leave the service, the match is done locally.
+package hibp
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"main/pkg/retry"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client looks password hashes up online or, with a range directory, offline.
+type Client struct {
+	httpClient *http.Client
+	baseURL    string
+	// retries are the attempts of range requests failing with network errors or 5xx and 429 answers
+	retries retry.Policy
+	logger  *slog.Logger
+	// rangeDir holds one <PREFIX>.txt file per range as produced by the official downloader
+	rangeDir string
+
+	cacheTTL  time.Duration
+	cacheSize int
+	mu        sync.Mutex
+	cache     map[string]cachedRange
+}
+
+type cachedRange struct {
+	counts    map[string]int
+	expiresAt time.Time
+}
+
+// NewClient returns a client of the range API at baseURL. Ranges are cached for cacheTTL, at most cacheSize of them.
+// Failed requests are retried up to attempts times in total, timeout applies to each attempt.
+func NewClient(baseURL string, timeout, cacheTTL time.Duration, cacheSize, attempts int, logger *slog.Logger) *Client {
+	return &Client{
+		httpClient: &http.Client{Timeout: timeout},
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		retries:    retry.Policy{Attempts: attempts, Backoff: 100 * time.Millisecond, Retryable: retryable},
+		logger:     logger,
+		cacheTTL:   cacheTTL,
+		cacheSize:  cacheSize,
+		cache:      make(map[string]cachedRange),
+	}
+}
+
+// NewOfflineClient returns a client reading the ranges from files, for deployments without internet access.
+func NewOfflineClient(rangeDir string) *Client {
+	return &Client{rangeDir: rangeDir}
+}
+
+// Breached returns how often the password appears in known breaches, 0 if never.
+func (c *Client) Breached(ctx context.Context, password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	counts, err := c.lookupRange(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+	return counts[suffix], nil
+}
+
+func (c *Client) lookupRange(ctx context.Context, prefix string) (map[string]int, error) {
+	if c.rangeDir != "" {
+		f, err := os.Open(filepath.Join(c.rangeDir, prefix+".txt"))
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return parseRange(f)
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	cached, ok := c.cache[prefix]
+	c.mu.Unlock()
+	if ok && now.Before(cached.expiresAt) {
+		return cached.counts, nil
+	}
+
+	var counts map[string]int
+	err := retry.Do(ctx, c.logger, "hibp_range", c.retries, func(ctx context.Context) (err error) {
+		counts, err = c.fetchRange(ctx, prefix)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.cache) >= c.cacheSize {
+		// evict expired ranges first, any range if none has expired
+		for p, r := range c.cache {
+			if now.After(r.expiresAt) || len(c.cache) >= c.cacheSize {
+				delete(c.cache, p)
+			}
+		}
+	}
+	if c.cacheSize > 0 {
+		c.cache[prefix] = cachedRange{counts: counts, expiresAt: now.Add(c.cacheTTL)}
+	}
+	return counts, nil
+}
+
+func (c *Client) fetchRange(ctx context.Context, prefix string) (map[string]int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/range/"+prefix, nil)
+	if err != nil {
+		return nil, err
+	}
+	// padding hides the real number of matches of the range from observers of the response size
+	req.Header.Set("Add-Padding", "true")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &statusError{code: resp.StatusCode, status: resp.Status}
+	}
+	return parseRange(resp.Body)
+}
+
+// statusError is an answer of the range API other than 200.
+type statusError struct {
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string {
+	return "hibp range API answered " + e.status
+}
+
+// retryable reports whether a failed range request may succeed when repeated: network errors other than
+// the deadline of the caller, server errors and rate limiting.
+func retryable(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.code >= 500 || se.code == http.StatusTooManyRequests
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// parseRange reads SUFFIX:COUNT lines. Padding entries have a count of 0 and are skipped.
+func parseRange(r io.Reader) (map[string]int, error) {
+	counts := make(map[string]int)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		suffix, count, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(count)
+		if err != nil || n == 0 {
+			continue
+		}
+		counts[strings.ToUpper(suffix)] = n
+	}
+	return counts, scanner.Err()
+}
@@ -0,0 +1,60 @@
+// Package idgen generates the primary keys of new users. Random UUIDv4 keys are spread over the whole
+// key space, so every insert touches a random page of the primary key index. Time-ordered keys
+// (UUIDv7, ULID) are appended to the right edge of the index instead, which keeps it compact.
+// All strategies produce 128 bit values stored in the existing uuid columns.
+package idgen
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Strategies.
+const (
+	UUIDv4 = "uuidv4"
+	UUIDv7 = "uuidv7"
+	ULID   = "ulid"
+)
+
+// Generator creates identifiers.
+type Generator interface {
+	NewID() (uuid.UUID, error)
+}
+
+// GeneratorFunc adapts a function to Generator.
+type GeneratorFunc func() (uuid.UUID, error)
+
+func (f GeneratorFunc) NewID() (uuid.UUID, error) {
+	return f()
+}
+
+// New returns the generator of the strategy.
+func New(strategy string) (Generator, error) {
+	switch strategy {
+	case UUIDv4:
+		return GeneratorFunc(uuid.NewRandom), nil
+	case UUIDv7:
+		return GeneratorFunc(uuid.NewV7), nil
+	case ULID:
+		return GeneratorFunc(newULID), nil
+	}
+	return nil, fmt.Errorf("unknown ID strategy %q, want %s, %s or %s", strategy, UUIDv4, UUIDv7, ULID)
+}
+
+// newULID returns a ULID (48 bit millisecond timestamp followed by 80 random bits) in UUID form.
+// Unlike UUIDv7 it spends no bits on version and variant, the canonical ULID text is the
+// Crockford base32 encoding of the same 16 bytes.
+func newULID() (uuid.UUID, error) {
+	var id uuid.UUID
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(time.Now().UnixMilli()))
+	copy(id[:6], ts[2:])
+	if _, err := rand.Read(id[6:]); err != nil {
+		return uuid.Nil, err
+	}
+	return id, nil
+}
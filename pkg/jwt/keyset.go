@@ -0,0 +1,106 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+// KeySet is the verification material services need to verify tokens themselves instead of asking the
+// forward-auth endpoint. It only travels wrapped for one recipient, see WrapKeySet.
+type KeySet struct {
+	Keys []VerificationKey `json:"keys"`
+	// EncryptionKey decrypts JWE tokens, empty when tokens are not encrypted
+	EncryptionKey []byte `json:"encryption_key,omitempty"`
+	// Audience is the aud claim of the tokens, empty when they carry none
+	Audience []string `json:"audience,omitempty"`
+	// RefreshIn is how long the set may be cached before it is fetched again
+	RefreshIn time.Duration `json:"refresh_in"`
+}
+
+// VerificationKey is an HMAC key tokens are signed with, ID is the kid header of its tokens.
+type VerificationKey struct {
+	ID     string `json:"kid"`
+	Secret []byte `json:"k"`
+	// NotAfter is when a replaced key stops verifying, zero for the current key
+	NotAfter time.Time `json:"not_after,omitzero"`
+}
+
+// ErrInvalidRecipient is returned for recipient keys that are not public EC keys.
+var ErrInvalidRecipient = errors.New("recipient must be a public P-256 key")
+
+// keyID returns the kid of the key, a digest that does not reveal it.
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return base64.RawURLEncoding.EncodeToString(sum[:8])
+}
+
+// KeySet returns the keys verifying the tokens of the manager: the current one and, during the grace period of
+// the last rotation, the replaced one.
+func (manager *JWTManager) KeySet() KeySet {
+	keys := manager.keys.Load()
+	set := KeySet{
+		Keys:          []VerificationKey{{ID: keyID(keys.current), Secret: keys.current}},
+		EncryptionKey: manager.encryptionKey,
+		Audience:      manager.audience,
+	}
+	if keys.previous != nil && time.Now().Before(keys.previousUntil) {
+		set.Keys = append(set.Keys, VerificationKey{ID: keyID(keys.previous), Secret: keys.previous, NotAfter: keys.previousUntil})
+	}
+	return set
+}
+
+// WrappedKeySet returns the KeySet of the manager wrapped for the recipient, to be fetched again after refreshIn.
+func (manager *JWTManager) WrappedKeySet(recipient *jose.JSONWebKey, refreshIn time.Duration) (string, error) {
+	set := manager.KeySet()
+	set.RefreshIn = refreshIn
+	return WrapKeySet(set, recipient)
+}
+
+// WrapKeySet encrypts the set to the public key of the recipient as a compact JWE (ECDH-ES+A256KW, A256GCM),
+// only the holder of the private key can read it.
+func WrapKeySet(set KeySet, recipient *jose.JSONWebKey) (string, error) {
+	if recipient == nil || !recipient.Valid() || !recipient.IsPublic() {
+		return "", ErrInvalidRecipient
+	}
+	if _, ok := recipient.Key.(*ecdsa.PublicKey); !ok {
+		return "", ErrInvalidRecipient
+	}
+	plaintext, err := json.Marshal(set)
+	if err != nil {
+		return "", err
+	}
+	encrypter, err := jose.NewEncrypter(jose.A256GCM,
+		jose.Recipient{Algorithm: jose.ECDH_ES_A256KW, Key: recipient.Key},
+		(&jose.EncrypterOptions{}).WithContentType("JSON"))
+	if err != nil {
+		return "", err
+	}
+	jwe, err := encrypter.Encrypt(plaintext)
+	if err != nil {
+		return "", err
+	}
+	return jwe.CompactSerialize()
+}
+
+// UnwrapKeySet decrypts a set wrapped by WrapKeySet with the private key of the recipient.
+func UnwrapKeySet(wrapped string, key *ecdsa.PrivateKey) (KeySet, error) {
+	jwe, err := jose.ParseEncrypted(wrapped, []jose.KeyAlgorithm{jose.ECDH_ES_A256KW}, []jose.ContentEncryption{jose.A256GCM})
+	if err != nil {
+		return KeySet{}, err
+	}
+	plaintext, err := jwe.Decrypt(key)
+	if err != nil {
+		return KeySet{}, err
+	}
+	var set KeySet
+	if err := json.Unmarshal(plaintext, &set); err != nil {
+		return KeySet{}, err
+	}
+	return set, nil
+}
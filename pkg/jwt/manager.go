@@ -1,55 +1,322 @@
 package jwt
 
 import (
+	"cmp"
+	"errors"
+	"main/domain/entity"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/go-jose/go-jose/v4"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
+// tokenTypeService marks machine tokens issued via the client_credentials grant.
+const tokenTypeService = "service"
+
 type JWTManager struct {
-	secretKey      string
+	// keys are swapped as a whole by RotateSecret while tokens are issued and verified
+	keys           atomic.Pointer[signingKeys]
 	accessTokenTTL int
+	// encryptionKey enables JWE (dir + A256GCM) wrapping of signed tokens when set
+	encryptionKey []byte
+	// issuer is the iss claim of access tokens issued without a tenant issuer
+	issuer string
+	// audience is the aud claim of all access and service tokens, none when empty
+	audience []string
+	// parserOptions and requiredClaims are additional checks of verification
+	parserOptions  []jwt.ParserOption
+	requiredClaims []string
+}
+
+// signingKeys are the HMAC keys of the manager.
+type signingKeys struct {
+	current []byte
+	// previous still verifies the tokens signed before the last rotation until previousUntil
+	previous      []byte
+	previousUntil time.Time
+}
+
+// Option configures optional JWTManager features.
+type Option func(*JWTManager)
+
+// WithEncryption makes the manager encrypt every signed token into a JWE (encrypt-after-sign),
+// so claims are not readable by the bearer. The key must be 32 bytes (A256GCM).
+// Plain signed tokens are still accepted on verification to allow a rolling switch.
+func WithEncryption(key []byte) Option {
+	return func(m *JWTManager) {
+		m.encryptionKey = key
+	}
+}
+
+// WithIssuer sets the iss claim of access tokens whose claims do not name an issuer.
+func WithIssuer(issuer string) Option {
+	return func(m *JWTManager) {
+		m.issuer = issuer
+	}
+}
+
+// WithAudience sets the aud claim of all access and service tokens, the services they are meant for.
+func WithAudience(audience ...string) Option {
+	return func(m *JWTManager) {
+		m.audience = audience
+	}
+}
+
+// WithRequiredAudience makes verification reject tokens whose aud claim names none of the audiences.
+func WithRequiredAudience(audience ...string) Option {
+	return func(m *JWTManager) {
+		m.parserOptions = append(m.parserOptions, jwt.WithAudience(audience...))
+	}
+}
+
+// WithRequiredClaims makes verification reject tokens without any of the claims.
+func WithRequiredClaims(claims ...string) Option {
+	return func(m *JWTManager) {
+		m.requiredClaims = append(m.requiredClaims, claims...)
+	}
 }
 
-func NewJWTManager(secretKey string, tokenTTL int) *JWTManager {
-	return &JWTManager{
-		secretKey:      secretKey,
+func NewJWTManager(secretKey string, tokenTTL int, opts ...Option) *JWTManager {
+	m := &JWTManager{
 		accessTokenTTL: tokenTTL,
 	}
+	m.keys.Store(&signingKeys{current: []byte(secretKey)})
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
 }
 
-// NewAccessToken generates a new JWT access token for the given user ID.
-func (manager *JWTManager) NewAccessToken(userID uuid.UUID) (string, error) {
-	jwtClaims := jwt.NewWithClaims(jwt.SigningMethodHS256, &jwt.MapClaims{
-		"user_id": userID,
-		"exp":     time.Now().Add(time.Duration(manager.accessTokenTTL) * time.Minute).Unix(),
-		"iat":     time.Now().Unix(),
+// RotateSecret signs new tokens with the secret. The tokens signed with the replaced secret are still
+// verified for grace, which should be at least the lifetime of access and service tokens.
+func (manager *JWTManager) RotateSecret(secretKey string, grace time.Duration) {
+	old := manager.keys.Load()
+	manager.keys.Store(&signingKeys{
+		current:       []byte(secretKey),
+		previous:      old.current,
+		previousUntil: time.Now().Add(grace),
 	})
-	tokenString, err := jwtClaims.SignedString([]byte(manager.secretKey))
+}
+
+// NewAccessToken generates a new JWT access token for the user session described by the claims.
+func (manager *JWTManager) NewAccessToken(claims entity.AccessTokenClaims) (string, error) {
+	ttl := time.Duration(manager.accessTokenTTL) * time.Minute
+	if claims.TTL > 0 {
+		ttl = min(ttl, claims.TTL)
+	}
+	mapClaims := jwt.MapClaims{
+		"sub":         claims.UserID.String(),
+		"sid":         claims.SessionID.String(),
+		"client_type": string(claims.ClientType),
+		"exp":         time.Now().Add(ttl).Unix(),
+		"iat":         time.Now().Unix(),
+		"tv":          claims.TokenVersion,
+		"pwd_ts":      claims.PasswordTimestamp,
+	}
+	if issuer := cmp.Or(claims.Issuer, manager.issuer); issuer != "" {
+		mapClaims["iss"] = issuer
+	}
+	if len(manager.audience) > 0 {
+		mapClaims["aud"] = manager.audience
+	}
+	if !claims.ElevatedUntil.IsZero() {
+		mapClaims["elevated_until"] = claims.ElevatedUntil.Unix()
+	}
+	// confirmation claim of certificate-bound (RFC 8705 section 3.1) and DPoP-bound (RFC 9449 section 6.1) tokens
+	cnf := map[string]string{}
+	if claims.CertThumbprint != "" {
+		cnf["x5t#S256"] = claims.CertThumbprint
+	}
+	if claims.DPoPThumbprint != "" {
+		cnf["jkt"] = claims.DPoPThumbprint
+	}
+	if len(cnf) > 0 {
+		mapClaims["cnf"] = cnf
+	}
+	return manager.sign(jwt.NewWithClaims(jwt.SigningMethodHS256, mapClaims))
+}
+
+// VerifyAccessToken verifies the access token and returns its user claims if the token is valid.
+func (manager *JWTManager) VerifyAccessToken(tokenString string) (entity.AccessTokenClaims, error) {
+	token, err := manager.parse(tokenString)
+	if err != nil {
+		return entity.AccessTokenClaims{}, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return entity.AccessTokenClaims{}, jwt.ErrTokenMalformed
+	}
+	// service tokens must never be accepted as user tokens
+	if claims["token_type"] == tokenTypeService {
+		return entity.AccessTokenClaims{}, jwt.ErrTokenInvalidClaims
+	}
+	sub, err := claims.GetSubject()
+	if err != nil || sub == "" {
+		return entity.AccessTokenClaims{}, jwt.ErrTokenMalformed
+	}
+
+	var result entity.AccessTokenClaims
+	result.UserID, err = uuid.Parse(sub)
+	if err != nil {
+		return entity.AccessTokenClaims{}, jwt.ErrTokenMalformed
+	}
+	// tokens issued before sessions were embedded carry no sid, they keep working until expiry
+	if sid, ok := claims["sid"].(string); ok {
+		result.SessionID, _ = uuid.Parse(sid)
+	}
+	if clientType, ok := claims["client_type"].(string); ok {
+		result.ClientType = entity.ClientType(clientType)
+	}
+	if iat, err := claims.GetIssuedAt(); err == nil && iat != nil {
+		result.IssuedAt = iat.Time
+	}
+	result.Issuer, _ = claims.GetIssuer()
+	// tokens issued before token versions carry no tv, they have version 0
+	if tv, ok := claims["tv"].(float64); ok {
+		result.TokenVersion = int64(tv)
+	}
+	// tokens issued before pwd_ts carry none, they have timestamp 0
+	if pwdTS, ok := claims["pwd_ts"].(float64); ok {
+		result.PasswordTimestamp = int64(pwdTS)
+	}
+	if cnf, ok := claims["cnf"].(map[string]any); ok {
+		result.CertThumbprint, _ = cnf["x5t#S256"].(string)
+		result.DPoPThumbprint, _ = cnf["jkt"].(string)
+	}
+	if elevatedUntil, ok := claims["elevated_until"].(float64); ok {
+		result.ElevatedUntil = time.Unix(int64(elevatedUntil), 0)
+	}
+
+	return result, nil
+}
+
+// NewServiceToken generates a machine access token for a service client with the granted scopes and TTL.
+func (manager *JWTManager) NewServiceToken(clientID string, scopes []string, ttl time.Duration) (string, error) {
+	mapClaims := jwt.MapClaims{
+		"sub":        clientID,
+		"scope":      strings.Join(scopes, " "),
+		"token_type": tokenTypeService,
+		"exp":        time.Now().Add(ttl).Unix(),
+		"iat":        time.Now().Unix(),
+	}
+	if len(manager.audience) > 0 {
+		mapClaims["aud"] = manager.audience
+	}
+	return manager.sign(jwt.NewWithClaims(jwt.SigningMethodHS256, &mapClaims))
+}
+
+// VerifyServiceToken verifies a machine access token and returns the client ID and its granted scopes.
+func (manager *JWTManager) VerifyServiceToken(tokenString string) (clientID string, scopes []string, err error) {
+	token, err := manager.parse(tokenString)
+	if err != nil {
+		return "", nil, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["token_type"] != tokenTypeService {
+		return "", nil, jwt.ErrTokenInvalidClaims
+	}
+	clientID, err = claims.GetSubject()
+	if err != nil || clientID == "" {
+		return "", nil, jwt.ErrTokenMalformed
+	}
+	scope, _ := claims["scope"].(string)
+	return clientID, strings.Fields(scope), nil
+}
+
+// ExpiresAt verifies the token signature and returns its expiration time.
+func (manager *JWTManager) ExpiresAt(tokenString string) (time.Time, error) {
+	token, err := manager.parse(tokenString)
+	if err != nil {
+		return time.Time{}, err
+	}
+	exp, err := token.Claims.GetExpirationTime()
+	if err != nil || exp == nil {
+		return time.Time{}, jwt.ErrTokenInvalidClaims
+	}
+	return exp.Time, nil
+}
+
+// Audience verifies the token signature and returns its aud claim, empty for tokens without one.
+func (manager *JWTManager) Audience(tokenString string) ([]string, error) {
+	token, err := manager.parse(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	aud, err := token.Claims.GetAudience()
+	if err != nil {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	return aud, nil
+}
+
+// sign signs the token and, when encryption is enabled, wraps the JWS into a compact JWE. The kid header
+// lets services verifying tokens with a KeySet pick the key.
+func (manager *JWTManager) sign(token *jwt.Token) (string, error) {
+	key := manager.keys.Load().current
+	token.Header["kid"] = keyID(key)
+	signed, err := token.SignedString(key)
+	if err != nil {
+		return "", err
+	}
+	if manager.encryptionKey == nil {
+		return signed, nil
+	}
+
+	encrypter, err := jose.NewEncrypter(jose.A256GCM,
+		jose.Recipient{Algorithm: jose.DIRECT, Key: manager.encryptionKey},
+		(&jose.EncrypterOptions{}).WithContentType("JWT"))
+	if err != nil {
+		return "", err
+	}
+	jwe, err := encrypter.Encrypt([]byte(signed))
 	if err != nil {
 		return "", err
 	}
-	return tokenString, nil
+	return jwe.CompactSerialize()
 }
 
-// VerifyAccessToken verifies the access token and returns the user ID if the token is valid.
-func (manager *JWTManager) VerifyAccessToken(tokenString string) (userID uuid.UUID, err error) {
+// parse decrypts the token if it is a JWE and verifies the signature of the inner JWS.
+func (manager *JWTManager) parse(tokenString string) (*jwt.Token, error) {
+	// compact JWE has five segments, JWS has three
+	if strings.Count(tokenString, ".") == 4 {
+		if manager.encryptionKey == nil {
+			return nil, errors.New("encrypted tokens are not enabled")
+		}
+		jwe, err := jose.ParseEncrypted(tokenString,
+			[]jose.KeyAlgorithm{jose.DIRECT},
+			[]jose.ContentEncryption{jose.A256GCM})
+		if err != nil {
+			return nil, jwt.ErrTokenMalformed
+		}
+		plaintext, err := jwe.Decrypt(manager.encryptionKey)
+		if err != nil {
+			return nil, jwt.ErrTokenMalformed
+		}
+		tokenString = string(plaintext)
+	}
+
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (any, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, jwt.ErrTokenMalformed
 		}
-		return []byte(manager.secretKey), nil
-	})
+		keys := manager.keys.Load()
+		if keys.previous == nil || time.Now().After(keys.previousUntil) {
+			return keys.current, nil
+		}
+		return jwt.VerificationKeySet{Keys: []jwt.VerificationKey{keys.current, keys.previous}}, nil
+	}, manager.parserOptions...)
 	if err != nil {
-		return uuid.Nil, err
+		return nil, err
 	}
-	sub, err := token.Claims.GetSubject()
-	if err != nil || sub == "" {
-		return uuid.Nil, jwt.ErrTokenMalformed
+	if claims, ok := token.Claims.(jwt.MapClaims); ok {
+		for _, name := range manager.requiredClaims {
+			if _, ok := claims[name]; !ok {
+				return nil, jwt.ErrTokenRequiredClaimMissing
+			}
+		}
 	}
-
-	uuid := uuid.MustParse(sub)
-
-	return uuid, nil
+	return token, nil
 }
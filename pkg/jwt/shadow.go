@@ -0,0 +1,89 @@
+package jwt
+
+import (
+	"log/slog"
+	"main/domain/entity"
+	"math/rand/v2"
+)
+
+// Outcomes of a shadow verification.
+const (
+	// ShadowMatch is a token both verifiers accepted with the same claims or both rejected
+	ShadowMatch = "match"
+	// ShadowClaimsMismatch is a token both verifiers accepted with different claims
+	ShadowClaimsMismatch = "claims_mismatch"
+	// ShadowCandidateRejected is a token only the current verifier accepted
+	ShadowCandidateRejected = "candidate_rejected"
+	// ShadowCandidateAccepted is a token only the candidate verifier accepted
+	ShadowCandidateAccepted = "candidate_accepted"
+)
+
+// AccessTokenVerifier verifies user access tokens, implemented by JWTManager.
+type AccessTokenVerifier interface {
+	VerifyAccessToken(token string) (entity.AccessTokenClaims, error)
+}
+
+// ShadowManager is a JWTManager whose access token verifications are repeated by a candidate verifier,
+// the new signing keys or claim rules a migration moves to. The result of the current manager is always the
+// one returned, the candidate runs in the background and only its divergences are logged, so the migration
+// can be cut over once the candidate has been seen to agree with live traffic.
+type ShadowManager struct {
+	*JWTManager
+	candidate  AccessTokenVerifier
+	sampleRate float64
+	logger     *slog.Logger
+	// observe counts the outcome of every shadow verification
+	observe func(outcome string)
+}
+
+// NewShadowManager returns a manager verifying a sampleRate fraction of the tokens with the candidate too.
+func NewShadowManager(current *JWTManager, candidate AccessTokenVerifier, sampleRate float64, logger *slog.Logger,
+	observe func(outcome string)) *ShadowManager {
+	return &ShadowManager{
+		JWTManager: current,
+		candidate:  candidate,
+		sampleRate: sampleRate,
+		logger:     logger,
+		observe:    observe,
+	}
+}
+
+// VerifyAccessToken verifies the token with the current manager and, for sampled tokens, compares the result
+// with the candidate in the background.
+func (m *ShadowManager) VerifyAccessToken(token string) (entity.AccessTokenClaims, error) {
+	claims, err := m.JWTManager.VerifyAccessToken(token)
+	if m.sampleRate >= 1 || (m.sampleRate > 0 && rand.Float64() < m.sampleRate) {
+		go m.compare(token, claims, err)
+	}
+	return claims, err
+}
+
+// compare verifies the token with the candidate and reports how its result differs from the current one.
+func (m *ShadowManager) compare(token string, claims entity.AccessTokenClaims, err error) {
+	candidateClaims, candidateErr := m.candidate.VerifyAccessToken(token)
+	outcome := ShadowMatch
+	switch {
+	case err == nil && candidateErr != nil:
+		outcome = ShadowCandidateRejected
+	case err != nil && candidateErr == nil:
+		outcome = ShadowCandidateAccepted
+	case err == nil && !sameClaims(claims, candidateClaims):
+		outcome = ShadowClaimsMismatch
+	}
+	m.observe(outcome)
+	if outcome == ShadowMatch {
+		return
+	}
+	// the token itself is a credential and never logged
+	m.logger.Warn("Shadow token verification diverged", "outcome", outcome, "user_id", claims.UserID,
+		"candidate_user_id", candidateClaims.UserID, "error", err, "candidate_error", candidateErr)
+}
+
+// sameClaims reports whether two verifications returned the same claims.
+func sameClaims(a, b entity.AccessTokenClaims) bool {
+	if !a.IssuedAt.Equal(b.IssuedAt) {
+		return false
+	}
+	a.IssuedAt = b.IssuedAt
+	return a == b
+}
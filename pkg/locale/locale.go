@@ -0,0 +1,109 @@
+// Package locale picks the language and time zone of the emails sent to a user. Both are captured from
+// the Accept-Language and X-Timezone headers of logins, the language is reduced to one of the languages
+// the messages are translated to and the time zone must be an IANA name.
+package locale
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // the runtime image has no zoneinfo
+)
+
+const (
+	// Default is the language of users without a supported Accept-Language
+	Default = "en"
+
+	// TimezoneHeader carries the IANA time zone of the client, e.g. Europe/Berlin
+	TimezoneHeader = "X-Timezone"
+
+	// maxTimezone bounds the header before it is looked up, the longest IANA names have about 30 characters
+	maxTimezone = 64
+)
+
+// Languages are the languages the messages are translated to.
+var Languages = []string{"en", "ru", "de"}
+
+// Negotiate returns the supported language the client prefers most according to its Accept-Language header,
+// empty if it prefers none of them. Region subtags are ignored, de-AT selects de.
+func Negotiate(acceptLanguage string) string {
+	type weighted struct {
+		lang string
+		q    float64
+	}
+	var prefs []weighted
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		base, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if q <= 0 || !supported(base) {
+			continue
+		}
+		prefs = append(prefs, weighted{base, q})
+	}
+	if len(prefs) == 0 {
+		return ""
+	}
+	// the order of the header breaks ties
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].q > prefs[j].q })
+	return prefs[0].lang
+}
+
+// Timezone returns the name if it is a known IANA time zone, empty otherwise.
+func Timezone(name string) string {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxTimezone || name == "Local" {
+		return ""
+	}
+	if _, err := time.LoadLocation(name); err != nil {
+		return ""
+	}
+	return name
+}
+
+// FormatTime renders t in the time zone and the date format of the language, UTC without a valid zone.
+func FormatTime(t time.Time, lang, tz string) string {
+	loc, err := time.LoadLocation(Timezone(tz))
+	if err != nil {
+		loc = time.UTC
+	}
+	t = t.In(loc)
+	switch lang {
+	case "ru":
+		return t.Format("02.01.2006 15:04 MST")
+	case "de":
+		return t.Format("02.01.2006, 15:04 MST")
+	}
+	return t.Format("Jan 2, 2006 at 15:04 MST")
+}
+
+// T returns the message with the key in the language, formatted with args. Unknown languages fall back
+// to English.
+func T(lang, key string, args ...any) string {
+	msg, ok := messages[lang][key]
+	if !ok {
+		msg = messages[Default][key]
+	}
+	if len(args) == 0 {
+		return msg
+	}
+	return fmt.Sprintf(msg, args...)
+}
+
+func supported(lang string) bool {
+	for _, l := range Languages {
+		if l == lang {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package locale
+
+// messages holds the translations of the emails by language and key. Verbs are filled by T, every
+// translation takes the same arguments in the same order as the English one.
+var messages = map[string]map[string]string{
+	"en": {
+		"verify_email.subject": "Confirm your email address",
+		"verify_email.body": "Please confirm your email address by opening the link below:\n\n%s\n\n" +
+			"The link expires on %s. If you did not create an account, ignore this email.",
+
+		"mfa_code.subject": "Your login code",
+		"mfa_code.body": "Your login code is %s. It expires on %s.\n\n" +
+			"If you did not try to sign in, change your password: someone knows it.",
+
+		"reset_password.subject":   "Reset your password",
+		"reset_password.body":      "%s Open the link below to choose a new password:\n\n%s\n\nThe link expires on %s. %s",
+		"reset_password.requested": "A password reset was requested for your account.",
+		"reset_password.forced":    "An administrator reset the password of your account, it no longer works.",
+		"reset_password.ignore":    "If you did not request it, ignore this email.",
+		"reset_password.logout":    "You were logged out on all devices.",
+
+		"change_email.subject": "Confirm your new email address",
+		"change_email.body": "Confirm that this address should become the email of your account by opening the link below:\n\n%s\n\n" +
+			"The link expires on %s. If you did not request it, ignore this email.",
+		"change_email_notice.subject": "Your email address is being changed",
+		"change_email_notice.body": "On %s a change of your account email to %s was requested. " +
+			"If this was not you, change your password immediately.",
+
+		"registration_attempt.subject": "Someone tried to sign up with your email",
+		"registration_attempt.body": "On %s someone tried to create an account with this email address, which already has one. " +
+			"If it was you, log in or reset your password. Otherwise you can ignore this email.",
+
+		"admin_action.body":    "%s\n\nReason: %s\n%s\n\nIf you think this is a mistake, contact support.",
+		"admin_block.subject":  "Your account has been blocked",
+		"admin_block.intro":    "An administrator blocked your account, you were logged out and can no longer log in.",
+		"admin_logout.subject": "You have been logged out",
+		"admin_logout.intro":   "An administrator logged you out on all devices, log in again to continue.",
+		"admin_delete.subject": "Your account has been deleted",
+		"admin_delete.intro":   "An administrator deleted your account, you were logged out and can no longer log in.",
+
+		"admin_reason.security_incident": "Security incident",
+		"admin_reason.abuse":             "Abuse",
+		"admin_reason.fraud":             "Fraud",
+		"admin_reason.user_request":      "At your request",
+		"admin_reason.legal":             "Legal requirement",
+		"admin_reason.other":             "Other",
+	},
+	"ru": {
+		"verify_email.subject": "Подтвердите адрес электронной почты",
+		"verify_email.body": "Подтвердите адрес электронной почты, открыв ссылку ниже:\n\n%s\n\n" +
+			"Ссылка действительна до %s. Если вы не создавали аккаунт, просто проигнорируйте это письмо.",
+
+		"mfa_code.subject": "Ваш код для входа",
+		"mfa_code.body": "Ваш код для входа: %s. Он действителен до %s.\n\n" +
+			"Если вы не пытались войти, смените пароль: он известен кому-то ещё.",
+
+		"reset_password.subject":   "Сброс пароля",
+		"reset_password.body":      "%s Откройте ссылку ниже, чтобы задать новый пароль:\n\n%s\n\nСсылка действительна до %s. %s",
+		"reset_password.requested": "Для вашего аккаунта запрошен сброс пароля.",
+		"reset_password.forced":    "Администратор сбросил пароль вашего аккаунта, он больше не действует.",
+		"reset_password.ignore":    "Если вы этого не запрашивали, проигнорируйте это письмо.",
+		"reset_password.logout":    "Вы вышли из аккаунта на всех устройствах.",
+
+		"change_email.subject": "Подтвердите новый адрес электронной почты",
+		"change_email.body": "Подтвердите, что этот адрес должен стать почтой вашего аккаунта, открыв ссылку ниже:\n\n%s\n\n" +
+			"Ссылка действительна до %s. Если вы этого не запрашивали, проигнорируйте это письмо.",
+		"change_email_notice.subject": "Адрес электронной почты меняется",
+		"change_email_notice.body": "%[1]s запрошена смена почты вашего аккаунта на %[2]s. " +
+			"Если это были не вы, немедленно смените пароль.",
+
+		"registration_attempt.subject": "Попытка регистрации с вашей почтой",
+		"registration_attempt.body": "%s кто-то пытался создать аккаунт с этим адресом, хотя аккаунт с ним уже есть. " +
+			"Если это были вы, войдите или сбросьте пароль. Иначе просто проигнорируйте это письмо.",
+
+		"admin_action.body":    "%s\n\nПричина: %s\n%s\n\nЕсли вы считаете, что это ошибка, обратитесь в поддержку.",
+		"admin_block.subject":  "Ваш аккаунт заблокирован",
+		"admin_block.intro":    "Администратор заблокировал ваш аккаунт, вы вышли из него и больше не можете войти.",
+		"admin_logout.subject": "Вы вышли из аккаунта",
+		"admin_logout.intro":   "Администратор завершил ваши сеансы на всех устройствах, войдите снова, чтобы продолжить.",
+		"admin_delete.subject": "Ваш аккаунт удалён",
+		"admin_delete.intro":   "Администратор удалил ваш аккаунт, вы вышли из него и больше не можете войти.",
+
+		"admin_reason.security_incident": "Инцидент безопасности",
+		"admin_reason.abuse":             "Злоупотребление",
+		"admin_reason.fraud":             "Мошенничество",
+		"admin_reason.user_request":      "По вашему запросу",
+		"admin_reason.legal":             "Требование закона",
+		"admin_reason.other":             "Другое",
+	},
+	"de": {
+		"verify_email.subject": "Bestätigen Sie Ihre E-Mail-Adresse",
+		"verify_email.body": "Bitte bestätigen Sie Ihre E-Mail-Adresse über den folgenden Link:\n\n%s\n\n" +
+			"Der Link ist gültig bis %s. Wenn Sie kein Konto erstellt haben, ignorieren Sie diese E-Mail.",
+
+		"mfa_code.subject": "Ihr Anmeldecode",
+		"mfa_code.body": "Ihr Anmeldecode lautet %s. Er ist gültig bis %s.\n\n" +
+			"Wenn Sie sich nicht anmelden wollten, ändern Sie Ihr Passwort: jemand anderes kennt es.",
+
+		"reset_password.subject":   "Passwort zurücksetzen",
+		"reset_password.body":      "%s Über den folgenden Link können Sie ein neues Passwort wählen:\n\n%s\n\nDer Link ist gültig bis %s. %s",
+		"reset_password.requested": "Für Ihr Konto wurde das Zurücksetzen des Passworts angefordert.",
+		"reset_password.forced":    "Ein Administrator hat das Passwort Ihres Kontos zurückgesetzt, es funktioniert nicht mehr.",
+		"reset_password.ignore":    "Wenn Sie das nicht angefordert haben, ignorieren Sie diese E-Mail.",
+		"reset_password.logout":    "Sie wurden auf allen Geräten abgemeldet.",
+
+		"change_email.subject": "Bestätigen Sie Ihre neue E-Mail-Adresse",
+		"change_email.body": "Bestätigen Sie über den folgenden Link, dass diese Adresse die E-Mail-Adresse Ihres Kontos werden soll:\n\n%s\n\n" +
+			"Der Link ist gültig bis %s. Wenn Sie das nicht angefordert haben, ignorieren Sie diese E-Mail.",
+		"change_email_notice.subject": "Ihre E-Mail-Adresse wird geändert",
+		"change_email_notice.body": "Am %s wurde die Änderung der E-Mail-Adresse Ihres Kontos zu %s angefordert. " +
+			"Wenn Sie das nicht waren, ändern Sie sofort Ihr Passwort.",
+
+		"registration_attempt.subject": "Registrierungsversuch mit Ihrer E-Mail-Adresse",
+		"registration_attempt.body": "Am %s hat jemand versucht, mit dieser E-Mail-Adresse ein Konto zu erstellen, obwohl es bereits eines gibt. " +
+			"Wenn Sie das waren, melden Sie sich an oder setzen Sie Ihr Passwort zurück. Andernfalls ignorieren Sie diese E-Mail.",
+
+		"admin_action.body":    "%s\n\nGrund: %s\n%s\n\nWenn Sie das für einen Fehler halten, wenden Sie sich an den Support.",
+		"admin_block.subject":  "Ihr Konto wurde gesperrt",
+		"admin_block.intro":    "Ein Administrator hat Ihr Konto gesperrt, Sie wurden abgemeldet und können sich nicht mehr anmelden.",
+		"admin_logout.subject": "Sie wurden abgemeldet",
+		"admin_logout.intro":   "Ein Administrator hat Sie auf allen Geräten abgemeldet, melden Sie sich erneut an, um fortzufahren.",
+		"admin_delete.subject": "Ihr Konto wurde gelöscht",
+		"admin_delete.intro":   "Ein Administrator hat Ihr Konto gelöscht, Sie wurden abgemeldet und können sich nicht mehr anmelden.",
+
+		"admin_reason.security_incident": "Sicherheitsvorfall",
+		"admin_reason.abuse":             "Missbrauch",
+		"admin_reason.fraud":             "Betrug",
+		"admin_reason.user_request":      "Auf Ihren Wunsch",
+		"admin_reason.legal":             "Gesetzliche Vorgabe",
+		"admin_reason.other":             "Sonstiges",
+	},
+}
@@ -0,0 +1,160 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"main/pkg/retry"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// ErrQueueFull is returned by Queue.Send when the queue holds its maximum number of emails.
+var ErrQueueFull = errors.New("mailer: email queue is full")
+
+// drainTimeout bounds the delivery of the emails still queued when the queue is stopped.
+const drainTimeout = 10 * time.Second
+
+// Sender delivers an email right away, implemented by SMTPMailer and LogMailer.
+type Sender interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+// Provider is a named sender of a Queue, the name is used in logs and metrics.
+type Provider struct {
+	Name   string
+	Sender Sender
+}
+
+// QueuePolicy configures how a Queue sends emails.
+type QueuePolicy struct {
+	// Size is the number of emails waiting to be sent, further ones are rejected with ErrQueueFull
+	Size int
+	// Rate is the number of emails sent per second over all providers, zero is unlimited. Burst is how many
+	// are sent at once after a pause
+	Rate  float64
+	Burst int
+	// Retry is how often an email is retried with a provider before the next provider is tried
+	Retry retry.Policy
+	// FailoverCooldown is how long a failed provider is skipped, the last provider is never skipped
+	FailoverCooldown time.Duration
+}
+
+type message struct {
+	to, subject, body string
+}
+
+// Queue sends emails in the background at a limited rate and fails over to the next provider when one
+// fails. Send only queues the email, Run delivers them.
+type Queue struct {
+	providers []Provider
+	policy    QueuePolicy
+	limiter   *rate.Limiter
+	queue     chan message
+	logger    *slog.Logger
+	// observe is called with the provider and the outcome (sent, failed) of each provider tried and with an
+	// empty provider and dropped for the emails rejected by Send or delivered by no provider
+	observe func(provider, outcome string)
+	// downUntil is when the failed providers are tried again, only used by the goroutine of Run
+	downUntil map[string]time.Time
+}
+
+// NewQueue returns a queue delivering through the providers, in order of preference.
+func NewQueue(providers []Provider, policy QueuePolicy, logger *slog.Logger, observe func(provider, outcome string)) *Queue {
+	if observe == nil {
+		observe = func(string, string) {}
+	}
+	limit := rate.Limit(policy.Rate)
+	if policy.Rate <= 0 {
+		limit = rate.Inf
+	}
+	return &Queue{
+		providers: providers,
+		policy:    policy,
+		limiter:   rate.NewLimiter(limit, max(policy.Burst, 1)),
+		queue:     make(chan message, max(policy.Size, 1)),
+		logger:    logger,
+		observe:   observe,
+		downUntil: make(map[string]time.Time),
+	}
+}
+
+// Send queues the email. It does not wait for the delivery, failures to deliver are logged.
+func (q *Queue) Send(ctx context.Context, to, subject, body string) error {
+	select {
+	case q.queue <- message{to: to, subject: subject, body: body}:
+		return nil
+	default:
+		q.observe("", "dropped")
+		return ErrQueueFull
+	}
+}
+
+// Run delivers the queued emails until the context is cancelled, then tries to deliver the emails still
+// queued for a few seconds.
+func (q *Queue) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			q.drain()
+			return
+		case m := <-q.queue:
+			q.deliver(ctx, m)
+		}
+	}
+}
+
+func (q *Queue) drain() {
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	// bounded by the emails queued now, deliver puts back the ones it ran out of time for
+	for n := len(q.queue); n > 0 && ctx.Err() == nil; n-- {
+		q.deliver(ctx, <-q.queue)
+	}
+	if left := len(q.queue); left > 0 {
+		q.logger.Error("Emails left unsent at shutdown", "emails", left)
+	}
+}
+
+// deliver sends the email through the first provider that accepts it.
+func (q *Queue) deliver(ctx context.Context, m message) {
+	if err := q.limiter.Wait(ctx); err != nil {
+		// stopped while waiting for the rate limit, the email is left to the drain
+		select {
+		case q.queue <- m:
+		default:
+			q.observe("", "dropped")
+			q.logger.Error("Email not sent", "subject", m.subject, "error", err)
+		}
+		return
+	}
+	var err error
+	for i, p := range q.providers {
+		if i < len(q.providers)-1 && q.down(p.Name) {
+			continue
+		}
+		err = retry.Do(ctx, q.logger, "send_email_"+p.Name, q.policy.Retry, func(ctx context.Context) error {
+			return p.Sender.Send(ctx, m.to, m.subject, m.body)
+		})
+		if err == nil {
+			q.observe(p.Name, "sent")
+			return
+		}
+		q.observe(p.Name, "failed")
+		q.markDown(p.Name)
+		if i < len(q.providers)-1 {
+			q.logger.Warn("Email provider failed, failing over", "provider", p.Name, "cooldown", q.policy.FailoverCooldown, "error", err)
+		}
+	}
+	q.observe("", "dropped")
+	q.logger.Error("Email not sent by any provider", "subject", m.subject, "error", err)
+}
+
+func (q *Queue) down(provider string) bool {
+	return time.Now().Before(q.downUntil[provider])
+}
+
+func (q *Queue) markDown(provider string) {
+	q.downUntil[provider] = time.Now().Add(q.policy.FailoverCooldown)
+}
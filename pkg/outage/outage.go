@@ -0,0 +1,82 @@
+// Package outage recognizes errors caused by Postgres or Redis being unreachable or overloaded. Such errors
+// say nothing about the request, so they must reach clients as retryable errors instead of being reported
+// as invalid credentials or internal failures.
+package outage
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/redis/go-redis/v9"
+)
+
+// Kind is the kind of outage behind an error.
+type Kind int
+
+const (
+	// None is an ordinary error, the dependency answered
+	None Kind = iota
+	// Unavailable means the dependency cannot be reached or refuses work right now
+	Unavailable
+	// Exhausted means the dependency is up but has no connection or memory left
+	Exhausted
+)
+
+// redisUnavailable are the prefixes of the errors Redis answers while it cannot serve commands.
+var redisUnavailable = []string{"LOADING", "MASTERDOWN", "CLUSTERDOWN", "TRYAGAIN", "BUSY"}
+
+// Classify returns the kind of outage behind err, None for errors of the request itself.
+func Classify(err error) Kind {
+	if err == nil {
+		return None
+	}
+	// checked before the context errors, a connect timeout wraps context.DeadlineExceeded
+	var connectErr *pgconn.ConnectError
+	if errors.As(err, &connectErr) {
+		return Unavailable
+	}
+	// the deadline or cancellation of the caller, which satisfies net.Error as well
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return None
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		// too_many_connections, out_of_memory, insufficient_resources
+		case pgErr.Code == "53300" || pgErr.Code == "53200" || pgErr.Code == "53000":
+			return Exhausted
+		// connection exceptions, server shutting down or starting up
+		case strings.HasPrefix(pgErr.Code, "08") || pgErr.Code == "57P01" || pgErr.Code == "57P02" || pgErr.Code == "57P03":
+			return Unavailable
+		}
+		return None
+	}
+	var redisErr redis.Error
+	if errors.As(err, &redisErr) {
+		msg := redisErr.Error()
+		if strings.HasPrefix(msg, "OOM") {
+			return Exhausted
+		}
+		for _, prefix := range redisUnavailable {
+			if strings.HasPrefix(msg, prefix) {
+				return Unavailable
+			}
+		}
+		return None
+	}
+
+	if errors.Is(err, redis.ErrPoolTimeout) || errors.Is(err, redis.ErrPoolExhausted) {
+		return Exhausted
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, redis.ErrClosed) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return Unavailable
+	}
+	return None
+}
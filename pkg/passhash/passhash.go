@@ -0,0 +1,232 @@
+// Package passhash creates password hashes and identifies and verifies the formats accepted on import:
+// bcrypt ($2a$, $2b$, $2y$) and the PHC strings of argon2id and scrypt.
+//
+// Every format carries its algorithm and cost parameters, which is what versions a hash: a hash whose
+// algorithm or parameters differ from the current ones is recognized and can be upgraded on the next login.
+package passhash
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Algorithm names the algorithm a hash was produced with.
+type Algorithm string
+
+const (
+	Bcrypt   Algorithm = "bcrypt"
+	Argon2id Algorithm = "argon2id"
+	Scrypt   Algorithm = "scrypt"
+)
+
+var ErrUnknownFormat = errors.New("unknown password hash format")
+
+const (
+	// argon2SaltLength and argon2KeyLength follow the recommendation of RFC 9106
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+// The upper bounds of the parameters accepted from an imported hash, verifying a hash costs what its
+// parameters say, so a hash above them would let a single login exhaust the memory or CPU of the server.
+const (
+	maxArgon2Memory     = 1 << 20 // KiB, 1 GiB
+	maxArgon2Iterations = 10
+	maxScryptLogN       = 20
+	maxScryptRP         = 1 << 10
+	maxKeyLength        = 128
+)
+
+// BcryptHasher creates bcrypt hashes with the configured cost.
+type BcryptHasher struct {
+	cost int
+}
+
+func NewBcryptHasher(cost int) (*BcryptHasher, error) {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	return &BcryptHasher{cost: cost}, nil
+}
+
+// Hash hashes the password with the configured cost.
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	return string(hash), err
+}
+
+// NeedsRehash reports whether the hash is not a bcrypt hash of the configured cost. Parameters are
+// compared for equality, so lowering them is migrated as well.
+func (h *BcryptHasher) NeedsRehash(hash string) bool {
+	alg, err := Identify(hash)
+	if err != nil || alg != Bcrypt {
+		return true
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != h.cost
+}
+
+// Argon2idHasher creates argon2id hashes in the PHC string format, so the parameters are stored with every hash.
+type Argon2idHasher struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+}
+
+// NewArgon2idHasher returns a hasher using memory KiB, the given number of passes and of lanes.
+func NewArgon2idHasher(memory, iterations uint32, parallelism uint8) (*Argon2idHasher, error) {
+	if iterations == 0 || parallelism == 0 {
+		return nil, errors.New("argon2id iterations and parallelism must be positive")
+	}
+	if memory < 8*uint32(parallelism) {
+		return nil, errors.New("argon2id memory must be at least 8 KiB per lane")
+	}
+	return &Argon2idHasher{memory: memory, iterations: iterations, parallelism: parallelism}, nil
+}
+
+// Hash hashes the password with a random salt and the configured parameters.
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.iterations, h.memory, h.parallelism, argon2KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.memory, h.iterations, h.parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// NeedsRehash reports whether the hash is not an argon2id hash of the configured parameters.
+func (h *Argon2idHasher) NeedsRehash(hash string) bool {
+	if alg, err := Identify(hash); err != nil || alg != Argon2id {
+		return true
+	}
+	p, err := parseArgon2id(hash)
+	return err != nil || p.memory != h.memory || p.iterations != h.iterations || p.parallelism != h.parallelism ||
+		len(p.key) != argon2KeyLength
+}
+
+// Identify returns the algorithm of the hash, it fails if the hash is malformed.
+func Identify(hash string) (Algorithm, error) {
+	switch {
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return "", fmt.Errorf("%w: %v", ErrUnknownFormat, err)
+		}
+		return Bcrypt, nil
+	case strings.HasPrefix(hash, "$argon2id$"):
+		if _, err := parseArgon2id(hash); err != nil {
+			return "", err
+		}
+		return Argon2id, nil
+	case strings.HasPrefix(hash, "$scrypt$"):
+		if _, err := parseScrypt(hash); err != nil {
+			return "", err
+		}
+		return Scrypt, nil
+	}
+	return "", ErrUnknownFormat
+}
+
+// Verify reports whether the password matches the hash. Malformed hashes never match.
+func Verify(password, hash string) bool {
+	alg, err := Identify(hash)
+	if err != nil {
+		return false
+	}
+	switch alg {
+	case Bcrypt:
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case Argon2id:
+		p, _ := parseArgon2id(hash)
+		key := argon2.IDKey([]byte(password), p.salt, p.iterations, p.memory, p.parallelism, uint32(len(p.key)))
+		return subtle.ConstantTimeCompare(key, p.key) == 1
+	case Scrypt:
+		p, _ := parseScrypt(hash)
+		key, err := scrypt.Key([]byte(password), p.salt, 1<<p.logN, p.r, p.p, len(p.key))
+		return err == nil && subtle.ConstantTimeCompare(key, p.key) == 1
+	}
+	return false
+}
+
+type argon2Params struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt, key   []byte
+}
+
+// parseArgon2id parses $argon2id$v=19$m=<KiB>,t=<iterations>,p=<parallelism>$<salt>$<key>.
+func parseArgon2id(hash string) (argon2Params, error) {
+	var p argon2Params
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return p, ErrUnknownFormat
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, fmt.Errorf("%w: unsupported argon2 version", ErrUnknownFormat)
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.iterations, &p.parallelism); err != nil {
+		return p, fmt.Errorf("%w: %v", ErrUnknownFormat, err)
+	}
+	var err error
+	if p.salt, p.key, err = decodeSaltKey(parts[4], parts[5]); err != nil {
+		return p, err
+	}
+	if p.iterations == 0 || p.parallelism == 0 {
+		return p, fmt.Errorf("%w: invalid argon2 parameters", ErrUnknownFormat)
+	}
+	if p.memory > maxArgon2Memory || p.iterations > maxArgon2Iterations {
+		return p, fmt.Errorf("%w: argon2 parameters above m=%d,t=%d", ErrUnknownFormat, maxArgon2Memory,
+			maxArgon2Iterations)
+	}
+	return p, nil
+}
+
+type scryptParams struct {
+	logN, r, p int
+	salt, key  []byte
+}
+
+// parseScrypt parses $scrypt$ln=<log2 N>,r=<block size>,p=<parallelism>$<salt>$<key>.
+func parseScrypt(hash string) (scryptParams, error) {
+	var p scryptParams
+	parts := strings.Split(hash, "$")
+	if len(parts) != 5 {
+		return p, ErrUnknownFormat
+	}
+	if _, err := fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &p.logN, &p.r, &p.p); err != nil {
+		return p, fmt.Errorf("%w: %v", ErrUnknownFormat, err)
+	}
+	if p.logN <= 0 || p.r <= 0 || p.p <= 0 {
+		return p, fmt.Errorf("%w: invalid scrypt parameters", ErrUnknownFormat)
+	}
+	if p.logN > maxScryptLogN || p.r > maxScryptRP || p.p > maxScryptRP || p.r*p.p > maxScryptRP {
+		return p, fmt.Errorf("%w: scrypt parameters above ln=%d, r*p=%d", ErrUnknownFormat, maxScryptLogN,
+			maxScryptRP)
+	}
+	var err error
+	p.salt, p.key, err = decodeSaltKey(parts[3], parts[4])
+	return p, err
+}
+
+// decodeSaltKey decodes the unpadded base64 salt and key of a PHC string.
+func decodeSaltKey(salt, key string) ([]byte, []byte, error) {
+	s, err := base64.RawStdEncoding.DecodeString(salt)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: invalid salt", ErrUnknownFormat)
+	}
+	k, err := base64.RawStdEncoding.DecodeString(key)
+	if err != nil || len(k) == 0 || len(k) > maxKeyLength {
+		return nil, nil, fmt.Errorf("%w: invalid key", ErrUnknownFormat)
+	}
+	return s, k, nil
+}
@@ -0,0 +1,59 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// MemcachedStore keeps the counters in memcached, keys are spread over the servers of the client.
+type MemcachedStore struct {
+	client *memcache.Client
+}
+
+func NewMemcachedStore(client *memcache.Client) *MemcachedStore {
+	return &MemcachedStore{client: client}
+}
+
+// Incr increments the counter, creating it with ADD on the first request of a window. ADD fails if a
+// concurrent request created the counter first, which is then incremented instead.
+func (s *MemcachedStore) Incr(_ context.Context, key string, window time.Duration) (int64, error) {
+	count, err := s.client.Increment(key, 1)
+	if err == nil {
+		return int64(count), nil
+	}
+	if !errors.Is(err, memcache.ErrCacheMiss) {
+		return 0, err
+	}
+
+	// memcached expirations are whole seconds
+	expiration := int32(window / time.Second)
+	if expiration < 1 {
+		expiration = 1
+	}
+	err = s.client.Add(&memcache.Item{Key: key, Value: []byte(strconv.Itoa(1)), Expiration: expiration})
+	if err == nil {
+		return 1, nil
+	}
+	if !errors.Is(err, memcache.ErrNotStored) {
+		return 0, err
+	}
+	count, err = s.client.Increment(key, 1)
+	return int64(count), err
+}
+
+func (s *MemcachedStore) Count(_ context.Context, key string) (int64, error) {
+	item, err := s.client.Get(key)
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	// counters written by Increment may be padded with spaces
+	return strconv.ParseInt(strings.TrimSpace(string(item.Value)), 10, 64)
+}
@@ -0,0 +1,93 @@
+package ratelimit
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps the counters in process. Keys are spread over shards with their own lock, so
+// concurrent requests for different clients rarely contend. Counters are not shared between instances.
+type MemoryStore struct {
+	shards []*memoryShard
+}
+
+type memoryShard struct {
+	mu       sync.Mutex
+	counters map[string]*memoryCounter
+}
+
+type memoryCounter struct {
+	count     int64
+	expiresAt time.Time
+}
+
+// NewMemoryStore returns a store with the given number of shards.
+func NewMemoryStore(shards int) *MemoryStore {
+	if shards < 1 {
+		shards = 1
+	}
+	s := &MemoryStore{shards: make([]*memoryShard, shards)}
+	for i := range s.shards {
+		s.shards[i] = &memoryShard{counters: make(map[string]*memoryCounter)}
+	}
+	return s
+}
+
+func (s *MemoryStore) Incr(_ context.Context, key string, window time.Duration) (int64, error) {
+	shard := s.shard(key)
+	now := time.Now()
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	c, ok := shard.counters[key]
+	if !ok || now.After(c.expiresAt) {
+		c = &memoryCounter{expiresAt: now.Add(window)}
+		shard.counters[key] = c
+	}
+	c.count++
+	return c.count, nil
+}
+
+func (s *MemoryStore) Count(_ context.Context, key string) (int64, error) {
+	shard := s.shard(key)
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	c, ok := shard.counters[key]
+	if !ok || time.Now().After(c.expiresAt) {
+		return 0, nil
+	}
+	return c.count, nil
+}
+
+// Run removes expired counters every interval until the context is cancelled, so memory does not
+// grow with every client ever seen.
+func (s *MemoryStore) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, shard := range s.shards {
+				shard.mu.Lock()
+				for key, c := range shard.counters {
+					if now.After(c.expiresAt) {
+						delete(shard.counters, key)
+					}
+				}
+				shard.mu.Unlock()
+			}
+		}
+	}
+}
+
+func (s *MemoryStore) shard(key string) *memoryShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return s.shards[h.Sum32()%uint32(len(s.shards))]
+}
@@ -0,0 +1,18 @@
+// Package ratelimit provides the stores the rate limiter counts requests in. Redis and memcached
+// share the counters between instances, the in-process store lets small single-instance deployments
+// run without either.
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Store counts requests per key in fixed windows.
+type Store interface {
+	// Incr increments the counter of the key and returns the new count. A counter expires one window
+	// after its first increment.
+	Incr(ctx context.Context, key string, window time.Duration) (int64, error)
+	// Count returns the counter of the key without incrementing it, zero for unknown and expired keys.
+	Count(ctx context.Context, key string) (int64, error)
+}
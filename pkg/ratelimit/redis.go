@@ -0,0 +1,42 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// incr increments KEYS[1] and sets its TTL to ARGV[1] milliseconds on the first increment, in one round trip.
+// A counter left without a TTL, by a client that failed between INCR and EXPIRE, gets one too instead of
+// limiting its key forever.
+var incr = redis.NewScript(`
+local count = redis.call('INCR', KEYS[1])
+if count == 1 or redis.call('PTTL', KEYS[1]) < 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return count
+`)
+
+// RedisStore keeps the counters in Redis.
+type RedisStore struct {
+	client *redis.Client
+}
+
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+// Incr increments the counter and starts its window atomically.
+func (s *RedisStore) Incr(ctx context.Context, key string, window time.Duration) (int64, error) {
+	return incr.Run(ctx, s.client, []string{key}, window.Milliseconds()).Int64()
+}
+
+func (s *RedisStore) Count(ctx context.Context, key string) (int64, error) {
+	count, err := s.client.Get(ctx, key).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return count, err
+}
@@ -0,0 +1,103 @@
+// Package retry retries failed operations with backoff and keeps track of the retries of a request, so that
+// request logs can tell a slow dependency apart from a request that was slow because it was retried.
+package retry
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Policy is how an operation is retried.
+type Policy struct {
+	// Attempts is the number of attempts including the first one, values below 2 do not retry
+	Attempts int
+	// Backoff is the delay before the first retry, doubled before each further one
+	Backoff time.Duration
+	// Retryable reports whether an error is worth another attempt, nil retries every error
+	Retryable func(error) bool
+}
+
+// Trace counts the retries made for one request.
+type Trace struct {
+	mu      sync.Mutex
+	retries int
+	latency time.Duration
+}
+
+// Stats returns the number of retries and the time spent on failed attempts and backoff.
+func (t *Trace) Stats() (retries int, latency time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.retries, t.latency
+}
+
+func (t *Trace) add(latency time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.retries++
+	t.latency += latency
+}
+
+type traceKey struct{}
+
+// NewContext starts the trace of a request.
+func NewContext(ctx context.Context) (context.Context, *Trace) {
+	t := &Trace{}
+	return context.WithValue(ctx, traceKey{}, t), t
+}
+
+// FromContext returns the trace of the request, nil outside of one.
+func FromContext(ctx context.Context) *Trace {
+	t, _ := ctx.Value(traceKey{}).(*Trace)
+	return t
+}
+
+// Do runs fn until it succeeds, returns an error that is not retryable or the attempts are used up, and returns
+// the error of the last attempt. Every retry is logged with the attempt number and the latency accumulated
+// since the first attempt, and added to the trace of the request.
+func Do(ctx context.Context, logger *slog.Logger, operation string, policy Policy, fn func(ctx context.Context) error) error {
+	start := time.Now()
+	backoff := policy.Backoff
+	for attempt := 1; ; attempt++ {
+		attemptStart := time.Now()
+		err := fn(ctx)
+		if err == nil || attempt >= policy.Attempts || (policy.Retryable != nil && !policy.Retryable(err)) {
+			if attempt > 1 && logger != nil {
+				logger.Info("Retried operation finished", "operation", operation, "attempts", attempt,
+					"cumulative_latency", time.Since(start), "error", err)
+			}
+			return err
+		}
+
+		if logger != nil {
+			logger.Warn("Retrying operation", "operation", operation, "attempt", attempt+1,
+				"cumulative_latency", time.Since(start), "error", err)
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		if t := FromContext(ctx); t != nil {
+			t.add(time.Since(attemptStart))
+		}
+		backoff *= 2
+	}
+}
+
+// LogAttrs returns the retry attributes of the request for its log line, none without retries.
+func LogAttrs(ctx context.Context) []any {
+	t := FromContext(ctx)
+	if t == nil {
+		return nil
+	}
+	retries, latency := t.Stats()
+	if retries == 0 {
+		return nil
+	}
+	return []any{"retries", retries, "retry_latency", latency}
+}
@@ -0,0 +1,81 @@
+// Package revocation tells the instances of the service about revoked sessions and raised token states through
+// a Redis pub/sub channel, so they reject the access tokens right away instead of at the next reload of the
+// denylist or when their cached token states expire. Pub/sub does not keep messages: an instance disconnected
+// from Redis misses them and relies on the reload and the expiry.
+package revocation
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Event is a message of the channel: sessions denied until a time, or users whose token state was raised.
+type Event struct {
+	SessionIDs []uuid.UUID `json:"session_ids,omitempty"`
+	Until      time.Time   `json:"until,omitzero"`
+	UserIDs    []uuid.UUID `json:"user_ids,omitempty"`
+}
+
+// RedisBus publishes and receives the events of a channel shared by all instances.
+type RedisBus struct {
+	client  *redis.Client
+	channel string
+}
+
+func NewRedisBus(client *redis.Client, channel string) *RedisBus {
+	return &RedisBus{client: client, channel: channel}
+}
+
+// Publish sends the denied sessions to all instances, including this one.
+func (b *RedisBus) Publish(ctx context.Context, sessionIDs []uuid.UUID, until time.Time) error {
+	return b.publish(ctx, Event{SessionIDs: sessionIDs, Until: until})
+}
+
+// PublishTokenStates tells all instances, including this one, that the token states of the users were raised.
+func (b *RedisBus) PublishTokenStates(ctx context.Context, userIDs []uuid.UUID) error {
+	return b.publish(ctx, Event{UserIDs: userIDs})
+}
+
+func (b *RedisBus) publish(ctx context.Context, event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return b.client.Publish(ctx, b.channel, payload).Err()
+}
+
+// Run passes the denied sessions of the channel to apply and the users with raised token states to evict until
+// the context is cancelled. The client resubscribes by itself after losing the connection.
+func (b *RedisBus) Run(ctx context.Context, logger *slog.Logger, apply func(sessionIDs []uuid.UUID, until time.Time),
+	evict func(userIDs []uuid.UUID)) {
+	sub := b.client.Subscribe(ctx, b.channel)
+	defer sub.Close()
+
+	messages := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			var event Event
+			if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+				logger.Warn("Ignoring malformed revocation event", "channel", b.channel, "error", err)
+				continue
+			}
+			if len(event.SessionIDs) > 0 {
+				apply(event.SessionIDs, event.Until)
+			}
+			if len(event.UserIDs) > 0 {
+				evict(event.UserIDs)
+			}
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Package s3 downloads objects from Amazon S3 and S3-compatible stores (MinIO, R2, GCS interoperability).
+// It only implements GetObject, signed with AWS Signature Version 4 and addressed path-style.
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"main/pkg/sigv4"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrInvalidPath is returned for paths that are not of the form s3://bucket/key.
+var ErrInvalidPath = errors.New("s3: path must be s3://bucket/key")
+
+// Credentials sign the requests, SessionToken is only set for temporary credentials.
+type Credentials = sigv4.Credentials
+
+// Client downloads objects of one region.
+type Client struct {
+	// endpoint is the base URL of the store, https://s3.<region>.amazonaws.com for AWS
+	endpoint    string
+	region      string
+	credentials Credentials
+	httpClient  *http.Client
+}
+
+// NewClient returns a client of the endpoint, the AWS endpoint of the region when empty.
+func NewClient(endpoint, region string, credentials Credentials) *Client {
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	return &Client{
+		endpoint:    strings.TrimSuffix(endpoint, "/"),
+		region:      region,
+		credentials: credentials,
+		httpClient:  &http.Client{Timeout: time.Minute},
+	}
+}
+
+// ParsePath splits an s3://bucket/key path.
+func ParsePath(path string) (bucket, key string, err error) {
+	rest, ok := strings.CutPrefix(path, "s3://")
+	if !ok {
+		return "", "", ErrInvalidPath
+	}
+	bucket, key, ok = strings.Cut(rest, "/")
+	if !ok || bucket == "" || key == "" {
+		return "", "", ErrInvalidPath
+	}
+	return bucket, key, nil
+}
+
+// Open returns the body of the object at an s3://bucket/key path, the caller closes it.
+func (c *Client) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	bucket, key, err := ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	return c.GetObject(ctx, bucket, key)
+}
+
+// GetObject returns the body of the object, the caller closes it.
+func (c *Client) GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	u, err := url.Parse(c.endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("s3: invalid endpoint: %w", err)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + bucket + "/" + key
+	u.RawPath = escapePath(u.Path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	sigv4.Sign(req, nil, "s3", c.region, c.credentials, time.Now())
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		// the body is an XML error document, its code is enough to tell a missing object from a denied one
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3: GET %s/%s returned status %d: %s", bucket, key, resp.StatusCode, errorCode(body))
+	}
+	return resp.Body, nil
+}
+
+// escapePath percent-encodes every byte of the path except the unreserved characters and slashes,
+// the encoding Signature Version 4 expects in the canonical request.
+func escapePath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-._~/", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// errorCode returns the Code element of an S3 error document, the whole body if it has none.
+func errorCode(body []byte) string {
+	s := string(body)
+	start := strings.Index(s, "<Code>")
+	end := strings.Index(s, "</Code>")
+	if start < 0 || end < start {
+		return strings.TrimSpace(s)
+	}
+	return s[start+len("<Code>") : end]
+}
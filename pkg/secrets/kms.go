@@ -0,0 +1,185 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"main/pkg/sigv4"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AWSKMS decrypts values encrypted with a key of AWS KMS. The plaintext of a value is the secret, so a base64
+// setting like the JWT encryption key is encrypted in its base64 form.
+type AWSKMS struct {
+	endpoint    string
+	region      string
+	credentials sigv4.Credentials
+	client      *http.Client
+}
+
+// NewAWSKMS returns a client of the region, endpoint is the AWS endpoint of the region when empty.
+func NewAWSKMS(endpoint, region string, credentials sigv4.Credentials, timeout time.Duration) (*AWSKMS, error) {
+	if region == "" {
+		return nil, errors.New("secrets: aws kms region is required")
+	}
+	if endpoint == "" {
+		endpoint = "https://kms." + region + ".amazonaws.com"
+	}
+	return &AWSKMS{
+		endpoint:    strings.TrimSuffix(endpoint, "/") + "/",
+		region:      region,
+		credentials: credentials,
+		client:      &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// Decrypt returns the plaintext of the base64 ciphertext blob, the key is named by the blob.
+func (k *AWSKMS) Decrypt(ctx context.Context, ciphertext string) (string, error) {
+	body, err := json.Marshal(map[string]string{"CiphertextBlob": ciphertext})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService.Decrypt")
+	sigv4.Sign(req, body, "kms", k.region, k.credentials, time.Now())
+
+	var result struct {
+		Plaintext string `json:"Plaintext"`
+		Type      string `json:"__type"`
+		Message   string `json:"message"`
+	}
+	if err := doJSON(k.client, req, &result); err != nil {
+		return "", apiError("aws kms decrypt", err, strings.TrimSpace(result.Type+" "+result.Message))
+	}
+	plaintext, err := base64.StdEncoding.DecodeString(result.Plaintext)
+	if err != nil {
+		return "", fmt.Errorf("secrets: malformed aws kms plaintext: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// metadataTokenURL returns the access token of the service account of a GCE, GKE or Cloud Run instance.
+const metadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// GCPKMS decrypts values encrypted with a key of Google Cloud KMS. The plaintext of a value is the secret,
+// like with AWSKMS.
+type GCPKMS struct {
+	endpoint string
+	// accessToken is a fixed OAuth access token, the token of the instance service account when empty
+	accessToken string
+	client      *http.Client
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// NewGCPKMS returns a client of the Cloud KMS API. Without an access token the requests are authenticated as
+// the service account of the instance, fetched from the metadata server.
+func NewGCPKMS(accessToken string, timeout time.Duration) *GCPKMS {
+	return &GCPKMS{
+		endpoint:    "https://cloudkms.googleapis.com/v1/",
+		accessToken: accessToken,
+		client:      &http.Client{Timeout: timeout},
+	}
+}
+
+// Decrypt returns the plaintext of the base64 ciphertext encrypted with the key, named
+// projects/<project>/locations/<location>/keyRings/<ring>/cryptoKeys/<key>.
+func (k *GCPKMS) Decrypt(ctx context.Context, key, ciphertext string) (string, error) {
+	token, err := k.bearerToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	body, err := json.Marshal(map[string]string{"ciphertext": ciphertext})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.endpoint+key+":decrypt", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	var result struct {
+		Plaintext string `json:"plaintext"`
+		Error     struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := doJSON(k.client, req, &result); err != nil {
+		return "", apiError("gcp kms decrypt", err, result.Error.Message)
+	}
+	plaintext, err := base64.StdEncoding.DecodeString(result.Plaintext)
+	if err != nil {
+		return "", fmt.Errorf("secrets: malformed gcp kms plaintext: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// bearerToken returns the fixed access token or the cached token of the instance, fetched again a minute
+// before it expires.
+func (k *GCPKMS) bearerToken(ctx context.Context) (string, error) {
+	if k.accessToken != "" {
+		return k.accessToken, nil
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.token != "" && time.Now().Before(k.expiresAt) {
+		return k.token, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataTokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := doJSON(k.client, req, &result); err != nil {
+		return "", apiError("gcp metadata token", err, "")
+	}
+	k.token = result.AccessToken
+	k.expiresAt = time.Now().Add(time.Duration(result.ExpiresIn)*time.Second - time.Minute)
+	return k.token, nil
+}
+
+// apiError wraps the error of an API call with the error message of the API, if it sent one.
+func apiError(op string, err error, message string) error {
+	if message == "" {
+		return fmt.Errorf("secrets: %s: %w", op, err)
+	}
+	return fmt.Errorf("secrets: %s: %w: %s", op, err, message)
+}
+
+// doJSON sends the request and decodes the JSON answer into result, also for error statuses so that the caller
+// can report the error message of the API.
+func doJSON(client *http.Client, req *http.Request, result any) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	decodeErr := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(result)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	if decodeErr != nil {
+		return fmt.Errorf("malformed response: %w", decodeErr)
+	}
+	return nil
+}
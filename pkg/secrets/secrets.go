@@ -0,0 +1,103 @@
+// Package secrets resolves the secrets of the configuration from HashiCorp Vault, AWS KMS or Google Cloud KMS,
+// so that they are not kept in plaintext YAML. A configured value is a reference when it starts with a scheme:
+//
+//	vault:<mount>/<path>#<field>     a field of a KV version 2 secret, e.g. vault:secret/auth#jwt_secret
+//	awskms:<ciphertext>              a value encrypted with AWS KMS, base64 as returned by aws kms encrypt
+//	gcpkms:<key name>#<ciphertext>   a value encrypted with the Cloud KMS key projects/../cryptoKeys/<key>, base64
+//
+// Other values are plain values and used as they are.
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Reference schemes.
+const (
+	schemeVault  = "vault:"
+	schemeAWSKMS = "awskms:"
+	schemeGCPKMS = "gcpkms:"
+)
+
+// ErrNotConfigured is returned for references to a store the resolver has no client of.
+var ErrNotConfigured = errors.New("secrets: store not configured")
+
+// IsReference reports whether the value refers to a secret store instead of being the secret.
+func IsReference(value string) bool {
+	return strings.HasPrefix(value, schemeVault) || strings.HasPrefix(value, schemeAWSKMS) ||
+		strings.HasPrefix(value, schemeGCPKMS)
+}
+
+// Resolver fetches the secrets of references, a nil client leaves its references unresolvable.
+type Resolver struct {
+	Vault  *Vault
+	AWSKMS *AWSKMS
+	GCPKMS *GCPKMS
+}
+
+// Resolve returns the secret of a reference, plain values are returned as they are.
+func (r *Resolver) Resolve(ctx context.Context, value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, schemeVault):
+		if r.Vault == nil {
+			return "", fmt.Errorf("%w: vault", ErrNotConfigured)
+		}
+		path, field, ok := strings.Cut(strings.TrimPrefix(value, schemeVault), "#")
+		mount, path, ok2 := strings.Cut(path, "/")
+		if !ok || !ok2 || field == "" || mount == "" || path == "" {
+			return "", errors.New("secrets: vault reference must be vault:<mount>/<path>#<field>")
+		}
+		return r.Vault.Read(ctx, mount, path, field)
+	case strings.HasPrefix(value, schemeAWSKMS):
+		if r.AWSKMS == nil {
+			return "", fmt.Errorf("%w: aws kms", ErrNotConfigured)
+		}
+		return r.AWSKMS.Decrypt(ctx, strings.TrimPrefix(value, schemeAWSKMS))
+	case strings.HasPrefix(value, schemeGCPKMS):
+		if r.GCPKMS == nil {
+			return "", fmt.Errorf("%w: gcp kms", ErrNotConfigured)
+		}
+		key, ciphertext, ok := strings.Cut(strings.TrimPrefix(value, schemeGCPKMS), "#")
+		if !ok || key == "" || ciphertext == "" {
+			return "", errors.New("secrets: gcp kms reference must be gcpkms:<key name>#<ciphertext>")
+		}
+		return r.GCPKMS.Decrypt(ctx, key, ciphertext)
+	}
+	return value, nil
+}
+
+// Watch fetches the secret of the reference every interval until the context is cancelled and calls apply
+// with it when it changed since the last fetch. current is the secret fetched at startup. Plain values never
+// change and are not watched. Failed fetches are logged, the secret in use is kept until a fetch succeeds.
+func (r *Resolver) Watch(ctx context.Context, logger *slog.Logger, name, reference, current string,
+	interval time.Duration, apply func(secret string)) {
+	if !IsReference(reference) || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			secret, err := r.Resolve(ctx, reference)
+			if err != nil {
+				logger.Error("Failed to fetch secret", "secret", name, "error", err)
+				continue
+			}
+			if secret == current {
+				continue
+			}
+			current = secret
+			apply(secret)
+			logger.Info("Secret rotated", "secret", name)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Vault reads secrets of the KV version 2 secrets engine of a HashiCorp Vault server.
+type Vault struct {
+	addr      string
+	token     string
+	tokenFile string
+	namespace string
+	client    *http.Client
+}
+
+// NewVault returns a client of the server at addr. The token authenticates the requests, or when tokenFile is
+// set the token in that file, read again for every request so that a token renewed by a Vault Agent is picked
+// up. namespace is the Vault Enterprise namespace, empty for none.
+func NewVault(addr, token, tokenFile, namespace string, timeout time.Duration) (*Vault, error) {
+	if addr == "" {
+		return nil, errors.New("secrets: vault address is required")
+	}
+	if token == "" && tokenFile == "" {
+		return nil, errors.New("secrets: vault token or token file is required")
+	}
+	return &Vault{
+		addr:      strings.TrimSuffix(addr, "/"),
+		token:     token,
+		tokenFile: tokenFile,
+		namespace: namespace,
+		client:    &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// kvResponse is the answer of a KV version 2 read, the fields of the secret are in data.data.
+type kvResponse struct {
+	Data struct {
+		Data map[string]any `json:"data"`
+	} `json:"data"`
+	Errors []string `json:"errors"`
+}
+
+// Read returns a field of the latest version of the secret at path in the engine mounted at mount.
+func (v *Vault) Read(ctx context.Context, mount, path, field string) (string, error) {
+	token := v.token
+	if v.tokenFile != "" {
+		data, err := os.ReadFile(v.tokenFile)
+		if err != nil {
+			return "", fmt.Errorf("secrets: vault token: %w", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.addr+"/v1/"+mount+"/data/"+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+
+	var result kvResponse
+	if err := doJSON(v.client, req, &result); err != nil {
+		return "", apiError("vault read "+mount+"/"+path, err, strings.Join(result.Errors, ", "))
+	}
+	value, ok := result.Data.Data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("secrets: vault secret %s/%s has no string field %q", mount, path, field)
+	}
+	return value, nil
+}
@@ -0,0 +1,174 @@
+// Package seed generates realistic users, sessions and login history for load tests. Emails use the reserved
+// example domains, so nothing is ever delivered to a real mailbox.
+package seed
+
+import (
+	"fmt"
+	"main/domain/entity"
+	"math/rand/v2"
+	"net/netip"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	firstNames = []string{"james", "mary", "john", "patricia", "robert", "jennifer", "michael", "linda", "david", "elizabeth",
+		"william", "barbara", "richard", "susan", "joseph", "jessica", "thomas", "sarah", "carlos", "maria", "wei", "yuki",
+		"olga", "ivan", "fatima", "ahmed", "priya", "arjun", "chloe", "lucas"}
+	lastNames = []string{"smith", "johnson", "williams", "brown", "jones", "garcia", "miller", "davis", "rodriguez", "martinez",
+		"hernandez", "lopez", "wilson", "anderson", "taylor", "thomas", "moore", "martin", "lee", "walker", "wang", "tanaka",
+		"ivanova", "petrov", "khan", "ali", "sharma", "patel", "dubois", "silva"}
+	domains    = []string{"example.com", "example.org", "example.net"}
+	userAgents = map[entity.ClientType][]string{
+		entity.ClientTypeWeb: {
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36",
+			"Mozilla/5.0 (Macintosh; Intel Mac OS X 14_6) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.6 Safari/605.1.15",
+			"Mozilla/5.0 (X11; Linux x86_64; rv:131.0) Gecko/20100101 Firefox/131.0",
+		},
+		entity.ClientTypeMobile: {
+			"MyApp/4.2.1 (iPhone; iOS 18.0; Scale/3.00)",
+			"MyApp/4.2.0 (Linux; Android 14; Pixel 8)",
+		},
+		entity.ClientTypeCLI: {"myapp-cli/1.8.0 (linux/amd64)"},
+	}
+)
+
+// Options shape the generated data.
+type Options struct {
+	// PasswordHash is the hash stored for every user, one known password keeps logins testable
+	PasswordHash string
+	// SessionTTL is the lifetime of generated sessions, sessions created earlier than that are already expired
+	SessionTTL time.Duration
+	// History is how far back users and sessions are created
+	History time.Duration
+}
+
+// Generator produces users and sessions. Usernames and emails carry a tag of the run, so repeated
+// runs against the same database do not collide.
+type Generator struct {
+	rng  *rand.Rand
+	opts Options
+	now  time.Time
+	tag  string
+	next int
+}
+
+func NewGenerator(opts Options, seed uint64) *Generator {
+	rng := rand.New(rand.NewPCG(seed, seed^0x9e3779b97f4a7c15))
+	return &Generator{
+		rng:  rng,
+		opts: opts,
+		now:  time.Now().UTC(),
+		tag:  fmt.Sprintf("%04x", rng.IntN(1<<16)),
+	}
+}
+
+// User returns the next user: about 80% have a verified email and 1% are blocked. The canonical email is left to the caller.
+func (g *Generator) User() entity.SeedUser {
+	g.next++
+	first, last := pick(g.rng, firstNames), pick(g.rng, lastNames)
+	email := fmt.Sprintf("%s.%s.%s%d@%s", first, last, g.tag, g.next, pick(g.rng, domains))
+	return entity.SeedUser{
+		ImportUser: entity.ImportUser{
+			ID:            uuid.New(),
+			Email:         email,
+			Username:      fmt.Sprintf("%s%d_%s", first, g.next, g.tag),
+			PasswordHash:  g.opts.PasswordHash,
+			EmailVerified: g.rng.Float64() < 0.8,
+		},
+		IsBlocked: g.rng.Float64() < 0.01,
+		CreatedAt: g.before(g.now, g.opts.History),
+	}
+}
+
+// Sessions returns n sessions of the user created since its registration, mostly web and mobile clients.
+// Sessions older than the session TTL are expired, which gives the cleanup job work.
+func (g *Generator) Sessions(user entity.SeedUser, n int) []entity.Session {
+	sessions := make([]entity.Session, 0, n)
+	for range n {
+		ct := entity.ClientTypeWeb
+		switch r := g.rng.Float64(); {
+		case r < 0.3:
+			ct = entity.ClientTypeMobile
+		case r < 0.35:
+			ct = entity.ClientTypeCLI
+		}
+		createdAt := g.before(g.now, g.now.Sub(user.CreatedAt))
+		sessions = append(sessions, entity.Session{
+			ID:           uuid.New(),
+			UserID:       user.ID,
+			RefreshToken: uuid.New(),
+			ClientIP:     g.ip(),
+			CreatedAt:    createdAt,
+			ExpiresAt:    createdAt.Add(g.opts.SessionTTL),
+			UserAgent:    pick(g.rng, userAgents[ct]),
+			ClientType:   ct,
+		})
+	}
+	return sessions
+}
+
+// LoginEvents returns the login history behind the sessions: every session has its successful login, about 15%
+// are preceded by a mistyped password and 5% by a second factor prompt, all from the client of the session.
+// A few attempts with a wrong password come from unknown clients.
+func (g *Generator) LoginEvents(user entity.SeedUser, sessions []entity.Session) []entity.LoginEvent {
+	events := make([]entity.LoginEvent, 0, len(sessions)+len(sessions)/4)
+	attempt := func(s entity.Session, at time.Time, outcome entity.LoginOutcome) {
+		events = append(events, entity.LoginEvent{
+			ID:         uuid.New(),
+			UserID:     user.ID,
+			CreatedAt:  at,
+			IP:         s.ClientIP,
+			UserAgent:  s.UserAgent,
+			ClientType: s.ClientType,
+			Outcome:    outcome,
+		})
+	}
+	for _, s := range sessions {
+		switch r := g.rng.Float64(); {
+		case r < 0.15:
+			attempt(s, s.CreatedAt.Add(-time.Duration(5+g.rng.IntN(55))*time.Second), entity.LoginInvalidPassword)
+		case r < 0.2:
+			attempt(s, s.CreatedAt.Add(-time.Duration(10+g.rng.IntN(110))*time.Second), entity.LoginMFARequired)
+		}
+		attempt(s, s.CreatedAt, entity.LoginSuccess)
+	}
+	if g.rng.Float64() < 0.05 {
+		ct := entity.ClientTypeWeb
+		stranger := entity.Session{ClientIP: g.ip(), UserAgent: pick(g.rng, userAgents[ct]), ClientType: ct}
+		at := g.before(g.now, g.now.Sub(user.CreatedAt))
+		for i := range 1 + g.rng.IntN(5) {
+			attempt(stranger, at.Add(time.Duration(i)*time.Second), entity.LoginInvalidPassword)
+		}
+	}
+	return events
+}
+
+// before returns a random time within window before t, recent times are more likely.
+func (g *Generator) before(t time.Time, window time.Duration) time.Time {
+	if window <= 0 {
+		return t
+	}
+	f := g.rng.Float64()
+	return t.Add(-time.Duration(f * f * float64(window)))
+}
+
+// ip returns a random address of the documentation ranges (RFC 5737, RFC 3849), mostly IPv4.
+func (g *Generator) ip() netip.Addr {
+	if g.rng.Float64() < 0.2 {
+		var b [16]byte
+		copy(b[:], []byte{0x20, 0x01, 0x0d, 0xb8})
+		for i := 8; i < 16; i++ {
+			b[i] = byte(g.rng.IntN(256))
+		}
+		return netip.AddrFrom16(b)
+	}
+	prefixes := [][3]byte{{192, 0, 2}, {198, 51, 100}, {203, 0, 113}}
+	p := prefixes[g.rng.IntN(len(prefixes))]
+	return netip.AddrFrom4([4]byte{p[0], p[1], p[2], byte(1 + g.rng.IntN(254))})
+}
+
+func pick[T any](rng *rand.Rand, values []T) T {
+	return values[rng.IntN(len(values))]
+}
@@ -0,0 +1,77 @@
+package sms
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TwilioSender sends text messages through the Twilio Messages API.
+type TwilioSender struct {
+	client     *http.Client
+	accountSID string
+	authToken  string
+	from       string
+	baseURL    string
+}
+
+// NewTwilioSender creates a sender for the given account, from is the sending number or messaging service SID.
+func NewTwilioSender(accountSID, authToken, from string, timeout time.Duration) *TwilioSender {
+	return &TwilioSender{
+		client:     &http.Client{Timeout: timeout},
+		accountSID: accountSID,
+		authToken:  authToken,
+		from:       from,
+		baseURL:    "https://api.twilio.com/2010-04-01",
+	}
+}
+
+// Send delivers a text message to a phone number in E.164 format.
+func (s *TwilioSender) Send(ctx context.Context, to, body string) error {
+	form := url.Values{}
+	form.Set("To", to)
+	form.Set("Body", body)
+	if strings.HasPrefix(s.from, "MG") {
+		form.Set("MessagingServiceSid", s.from)
+	} else {
+		form.Set("From", s.from)
+	}
+
+	endpoint := s.baseURL + "/Accounts/" + url.PathEscape(s.accountSID) + "/Messages.json"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(s.accountSID, s.authToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("sms: twilio returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// LogSender writes text messages to the log instead of sending them, used when no SMS provider is configured.
+type LogSender struct {
+	logger *slog.Logger
+}
+
+func NewLogSender(logger *slog.Logger) *LogSender {
+	return &LogSender{logger: logger}
+}
+
+func (s *LogSender) Send(ctx context.Context, to, body string) error {
+	s.logger.Info("SMS (not sent, no provider configured)", "to", to, "body", body)
+	return nil
+}
@@ -0,0 +1,57 @@
+// Package tokenlist decodes the lists of compromised refresh tokens and session IDs handed over in an incident.
+//
+// A list is either a JSON array of strings or text with one token per line, where blank lines and
+// everything after a # are ignored.
+package tokenlist
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Decode reads all entries of the list, the entries are not validated.
+func Decode(r io.Reader) ([]string, error) {
+	br := bufio.NewReader(r)
+	if isJSON(br) {
+		var entries []string
+		if err := json.NewDecoder(br).Decode(&entries); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		for i, entry := range entries {
+			entries[i] = strings.TrimSpace(entry)
+		}
+		return entries, nil
+	}
+
+	var entries []string
+	scanner := bufio.NewScanner(br)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			entries = append(entries, line)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// isJSON reports whether the first non-space byte of the input opens an array.
+func isJSON(br *bufio.Reader) bool {
+	for n := 1; n <= br.Size(); n++ {
+		head, _ := br.Peek(n)
+		if len(head) < n {
+			return false
+		}
+		switch head[n-1] {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return head[n-1] == '['
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+// Package tokenversion caches the token states (token version and password timestamp) of users. Both only grow,
+// so the caches keep the highest values they were given: a stale state read from the database cannot undo
+// a revocation.
+package tokenversion
+
+import (
+	"context"
+	"main/domain/entity"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type entry struct {
+	state     entity.TokenState
+	expiresAt time.Time
+}
+
+// MemoryCache is a cache of a single instance, for deployments without Redis. Revocations made on
+// another instance are only seen here once the cached state expires.
+type MemoryCache struct {
+	mu        sync.Mutex
+	entries   map[uuid.UUID]entry
+	lastSweep time.Time
+}
+
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{entries: make(map[uuid.UUID]entry)}
+}
+
+// Get returns the cached state of the user, ok is false when none is cached.
+func (c *MemoryCache) Get(_ context.Context, userID uuid.UUID) (state entity.TokenState, ok bool, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[userID]
+	if !ok || time.Now().After(e.expiresAt) {
+		return entity.TokenState{}, false, nil
+	}
+	return e.state, true, nil
+}
+
+// Evict drops the cached states of the users, the next Get misses.
+func (c *MemoryCache) Evict(userIDs ...uuid.UUID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, id := range userIDs {
+		delete(c.entries, id)
+	}
+}
+
+// Raise caches the state for ttl, keeping the higher of the cached and the given version and password timestamp.
+func (c *MemoryCache) Raise(_ context.Context, userID uuid.UUID, state entity.TokenState, ttl time.Duration) error {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[userID]; ok && now.Before(e.expiresAt) {
+		if e.state.Version >= state.Version && e.state.PasswordTimestamp >= state.PasswordTimestamp {
+			return nil
+		}
+		state.Version = max(state.Version, e.state.Version)
+		state.PasswordTimestamp = max(state.PasswordTimestamp, e.state.PasswordTimestamp)
+	}
+	// the map holds the users active within one TTL, an occasional sweep drops the others
+	if now.Sub(c.lastSweep) > ttl {
+		for id, e := range c.entries {
+			if now.After(e.expiresAt) {
+				delete(c.entries, id)
+			}
+		}
+		c.lastSweep = now
+	}
+	c.entries[userID] = entry{state: state, expiresAt: now.Add(ttl)}
+	return nil
+}
@@ -0,0 +1,83 @@
+package tokenversion
+
+import (
+	"context"
+	"fmt"
+	"main/domain/entity"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// keyPrefix names hashes with the fields v (token version) and p (password timestamp).
+const keyPrefix = "token_state:"
+
+// raise raises the fields v and p of the hash to ARGV[1] and ARGV[2] and sets its TTL to ARGV[3] milliseconds,
+// unless it holds higher values for both. The values are kept as the strings they were sent as, Lua numbers
+// would turn large versions into floats.
+var raise = redis.NewScript(`
+local v = redis.call('HGET', KEYS[1], 'v')
+local p = redis.call('HGET', KEYS[1], 'p')
+local raised = false
+if not v or tonumber(v) < tonumber(ARGV[1]) then
+	v = ARGV[1]
+	raised = true
+end
+if not p or tonumber(p) < tonumber(ARGV[2]) then
+	p = ARGV[2]
+	raised = true
+end
+if raised then
+	redis.call('HSET', KEYS[1], 'v', v, 'p', p)
+	redis.call('PEXPIRE', KEYS[1], ARGV[3])
+end
+return 0
+`)
+
+// RedisCache is a cache shared by all instances of the service, a revocation is seen by all of them at once.
+type RedisCache struct {
+	client *redis.Client
+}
+
+func NewRedisCache(client *redis.Client) *RedisCache {
+	return &RedisCache{client: client}
+}
+
+// Get returns the cached state of the user, ok is false when none is cached.
+func (c *RedisCache) Get(ctx context.Context, userID uuid.UUID) (state entity.TokenState, ok bool, err error) {
+	fields, err := c.client.HMGet(ctx, keyPrefix+userID.String(), "v", "p").Result()
+	if err != nil {
+		return entity.TokenState{}, false, err
+	}
+	// missing keys and fields are nil
+	if len(fields) != 2 || fields[0] == nil || fields[1] == nil {
+		return entity.TokenState{}, false, nil
+	}
+	version, err := parseField(fields[0])
+	if err != nil {
+		return entity.TokenState{}, false, err
+	}
+	passwordTimestamp, err := parseField(fields[1])
+	if err != nil {
+		return entity.TokenState{}, false, err
+	}
+	return entity.TokenState{Version: version, PasswordTimestamp: passwordTimestamp}, true, nil
+}
+
+// Raise caches the state for ttl, keeping the higher of the cached and the given version and password timestamp,
+// atomically across instances.
+func (c *RedisCache) Raise(ctx context.Context, userID uuid.UUID, state entity.TokenState, ttl time.Duration) error {
+	return raise.Run(ctx, c.client, []string{keyPrefix + userID.String()},
+		state.Version, state.PasswordTimestamp, ttl.Milliseconds()).Err()
+}
+
+// parseField parses a hash field returned by HMGET.
+func parseField(field any) (int64, error) {
+	s, ok := field.(string)
+	if !ok {
+		return 0, fmt.Errorf("unexpected token state field %T", field)
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
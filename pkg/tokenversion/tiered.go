@@ -0,0 +1,47 @@
+package tokenversion
+
+import (
+	"context"
+	"main/domain/entity"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TieredCache keeps the states of the shared Redis cache in memory for a short time, which spares most token
+// verifications the round trip to Redis. The instance raising a state publishes it on the revocation channel
+// and every instance evicts it from memory (see revocation.RedisBus), an instance missing the event sees the
+// raised state once its entry expires.
+type TieredCache struct {
+	local    *MemoryCache
+	shared   *RedisCache
+	localTTL time.Duration
+}
+
+func NewTieredCache(shared *RedisCache, localTTL time.Duration) *TieredCache {
+	return &TieredCache{local: NewMemoryCache(), shared: shared, localTTL: localTTL}
+}
+
+// Get returns the state of the user from memory, or from Redis when it is not held in memory.
+func (c *TieredCache) Get(ctx context.Context, userID uuid.UUID) (state entity.TokenState, ok bool, err error) {
+	if state, ok, _ := c.local.Get(ctx, userID); ok {
+		return state, true, nil
+	}
+	state, ok, err = c.shared.Get(ctx, userID)
+	if err == nil && ok {
+		_ = c.local.Raise(ctx, userID, state, c.localTTL)
+	}
+	return state, ok, err
+}
+
+// Raise raises the state in Redis and drops the copy in memory, the next Get reads the raised state.
+func (c *TieredCache) Raise(ctx context.Context, userID uuid.UUID, state entity.TokenState, ttl time.Duration) error {
+	err := c.shared.Raise(ctx, userID, state, ttl)
+	c.local.Evict(userID)
+	return err
+}
+
+// Evict drops the states of the users from memory, called for the states raised on other instances.
+func (c *TieredCache) Evict(userIDs ...uuid.UUID) {
+	c.local.Evict(userIDs...)
+}
@@ -0,0 +1,152 @@
+// Package userexport writes users as a SCIM 2.0 ListResponse (RFC 7644), with the credential metadata
+// in an extension schema, for migrating to or syncing with another identity provider.
+package userexport
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"main/domain/entity"
+	"main/pkg/passhash"
+	"strings"
+	"time"
+)
+
+const (
+	schemaListResponse = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	schemaUser         = "urn:ietf:params:scim:schemas:core:2.0:User"
+	// SchemaCredentials is the extension schema holding the credential metadata of a user
+	SchemaCredentials = "urn:k3rlll:auth:scim:schemas:extension:credentials:1.0:User"
+)
+
+// Field is a PII field that can be redacted.
+type Field string
+
+const (
+	FieldEmail    Field = "email"
+	FieldUsername Field = "username"
+)
+
+// Options controls what the export reveals.
+type Options struct {
+	// Redact masks the listed fields, keeping only their first character (and the domain of emails)
+	Redact []Field
+	// IncludePasswordHashes exports the password hashes, needed when the target can verify them
+	IncludePasswordHashes bool
+}
+
+type resource struct {
+	Schemas     []string     `json:"schemas"`
+	ID          string       `json:"id"`
+	UserName    string       `json:"userName"`
+	Emails      []email      `json:"emails"`
+	Active      bool         `json:"active"`
+	Meta        meta         `json:"meta"`
+	Credentials *credentials `json:"urn:k3rlll:auth:scim:schemas:extension:credentials:1.0:User"`
+}
+
+type email struct {
+	Value    string `json:"value"`
+	Primary  bool   `json:"primary"`
+	Verified bool   `json:"verified"`
+}
+
+type meta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+}
+
+type credentials struct {
+	PasswordAlgorithm string    `json:"passwordAlgorithm,omitempty"`
+	PasswordHash      string    `json:"passwordHash,omitempty"`
+	PasswordChangedAt time.Time `json:"passwordChangedAt"`
+}
+
+// Writer streams users into a ListResponse, Close writes the total and ends the document.
+type Writer struct {
+	w     io.Writer
+	opts  Options
+	total int
+	err   error
+}
+
+func NewWriter(w io.Writer, opts Options) *Writer {
+	ew := &Writer{w: w, opts: opts}
+	ew.printf(`{"schemas":["%s"],"Resources":[`, schemaListResponse)
+	return ew
+}
+
+// Write appends the users to the document.
+func (ew *Writer) Write(users []entity.ExportUser) error {
+	for _, u := range users {
+		data, err := json.Marshal(ew.resource(u))
+		if err != nil {
+			return err
+		}
+		if ew.total > 0 {
+			ew.printf(",")
+		}
+		ew.printf("\n%s", data)
+		ew.total++
+	}
+	return ew.err
+}
+
+// Close ends the document, it does not close the underlying writer.
+func (ew *Writer) Close() error {
+	ew.printf("\n],\"totalResults\":%d,\"itemsPerPage\":%d,\"startIndex\":1}\n", ew.total, ew.total)
+	return ew.err
+}
+
+func (ew *Writer) printf(format string, args ...any) {
+	if ew.err == nil {
+		_, ew.err = fmt.Fprintf(ew.w, format, args...)
+	}
+}
+
+func (ew *Writer) resource(u entity.ExportUser) resource {
+	r := resource{
+		Schemas:  []string{schemaUser, SchemaCredentials},
+		ID:       u.ID.String(),
+		UserName: u.Username,
+		Emails:   []email{{Value: u.Email, Primary: true, Verified: u.EmailVerified}},
+		Active:   !u.IsBlocked,
+		Meta:     meta{ResourceType: "User", Created: u.CreatedAt.UTC()},
+		Credentials: &credentials{
+			PasswordChangedAt: u.PasswordChangedAt.UTC(),
+		},
+	}
+	if alg, err := passhash.Identify(u.PasswordHash); err == nil {
+		r.Credentials.PasswordAlgorithm = string(alg)
+	}
+	if ew.opts.IncludePasswordHashes {
+		r.Credentials.PasswordHash = u.PasswordHash
+	}
+	for _, field := range ew.opts.Redact {
+		switch field {
+		case FieldEmail:
+			r.Emails[0].Value = maskEmail(u.Email)
+		case FieldUsername:
+			r.UserName = mask(u.Username)
+		}
+	}
+	return r
+}
+
+// mask keeps the first character of the value.
+func mask(value string) string {
+	if value == "" {
+		return ""
+	}
+	first := []rune(value)[0]
+	return string(first) + "***"
+}
+
+// maskEmail masks the local part and keeps the domain, which is rarely personal and useful for statistics.
+func maskEmail(value string) string {
+	local, domain, ok := strings.Cut(value, "@")
+	if !ok {
+		return mask(value)
+	}
+	return mask(local) + "@" + domain
+}
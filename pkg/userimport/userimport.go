@@ -0,0 +1,98 @@
+// Package userimport decodes user exports of other systems into accounts to import.
+//
+// JSON input is an array of objects, CSV input has a header row, both use the field names
+// email, username, password_hash and optionally id, email_verified and hash_algorithm.
+// The parameters of argon2id and scrypt hashes contain commas, so CSV hash fields must be quoted.
+package userimport
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"main/domain/entity"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Format is the encoding of an import file.
+type Format string
+
+const (
+	FormatCSV  Format = "csv"
+	FormatJSON Format = "json"
+)
+
+var ErrUnknownFormat = errors.New("unknown import format")
+
+// Decode reads all users from r.
+func Decode(r io.Reader, format Format) ([]entity.ImportUser, error) {
+	switch format {
+	case FormatJSON:
+		var users []entity.ImportUser
+		if err := json.NewDecoder(r).Decode(&users); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		return users, nil
+	case FormatCSV:
+		return decodeCSV(r)
+	}
+	return nil, ErrUnknownFormat
+}
+
+func decodeCSV(r io.Reader) ([]entity.ImportUser, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"email", "username", "password_hash"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("CSV header is missing the %q column", required)
+		}
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var users []entity.ImportUser
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return users, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+
+		user := entity.ImportUser{
+			Email:         field(record, "email"),
+			Username:      field(record, "username"),
+			PasswordHash:  field(record, "password_hash"),
+			HashAlgorithm: field(record, "hash_algorithm"),
+		}
+		if id := field(record, "id"); id != "" {
+			if user.ID, err = uuid.Parse(id); err != nil {
+				return nil, fmt.Errorf("line %d: invalid id: %w", line, err)
+			}
+		}
+		if verified := field(record, "email_verified"); verified != "" {
+			if user.EmailVerified, err = strconv.ParseBool(verified); err != nil {
+				return nil, fmt.Errorf("line %d: invalid email_verified: %w", line, err)
+			}
+		}
+		users = append(users, user)
+	}
+}
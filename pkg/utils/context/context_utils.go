@@ -2,14 +2,26 @@ package context
 
 import (
 	"context"
+	"main/domain/entity"
 )
 
 type key int
 
 const (
 	userIDKey key = iota
+	clientKey
+	peerIdentityKey
+	tenantKey
+	requestInfoKey
+	insecureCookiesKey
 )
 
+// Client is the identity of a service authenticated with a machine token.
+type Client struct {
+	ID     string
+	Scopes []string
+}
+
 func NewContext(ctx context.Context, userID string) context.Context {
 	return context.WithValue(ctx, userIDKey, userID)
 }
@@ -18,3 +30,83 @@ func FromContext(ctx context.Context) (string, bool) {
 	id, ok := ctx.Value(userIDKey).(string)
 	return id, ok
 }
+
+func NewClientContext(ctx context.Context, client Client) context.Context {
+	return context.WithValue(ctx, clientKey, client)
+}
+
+func ClientFromContext(ctx context.Context) (Client, bool) {
+	client, ok := ctx.Value(clientKey).(Client)
+	return client, ok
+}
+
+// PeerIdentity is the identity taken from a verified mTLS client certificate.
+type PeerIdentity struct {
+	CommonName string
+	DNSNames   []string
+	URIs       []string
+	// Thumbprint is the x5t#S256 of the certificate, used for certificate-bound tokens
+	Thumbprint string
+}
+
+// Names returns the CN followed by all SAN values of the certificate.
+func (p PeerIdentity) Names() []string {
+	names := make([]string, 0, 1+len(p.DNSNames)+len(p.URIs))
+	if p.CommonName != "" {
+		names = append(names, p.CommonName)
+	}
+	names = append(names, p.DNSNames...)
+	return append(names, p.URIs...)
+}
+
+func NewPeerIdentityContext(ctx context.Context, identity PeerIdentity) context.Context {
+	return context.WithValue(ctx, peerIdentityKey, identity)
+}
+
+func PeerIdentityFromContext(ctx context.Context) (PeerIdentity, bool) {
+	identity, ok := ctx.Value(peerIdentityKey).(PeerIdentity)
+	return identity, ok
+}
+
+// NewTenantContext stores the tenant domain the request was made to.
+func NewTenantContext(ctx context.Context, tenant entity.TenantDomain) context.Context {
+	return context.WithValue(ctx, tenantKey, tenant)
+}
+
+func TenantFromContext(ctx context.Context) (entity.TenantDomain, bool) {
+	tenant, ok := ctx.Value(tenantKey).(entity.TenantDomain)
+	return tenant, ok
+}
+
+// CookieDomain returns the cookie domain of the tenant domain of the request, empty for host-only cookies.
+func CookieDomain(ctx context.Context) string {
+	tenant, _ := TenantFromContext(ctx)
+	return tenant.CookieDomain
+}
+
+// RequestInfo is the client of the request as seen by the delivery layer, recorded with audit events.
+type RequestInfo struct {
+	IP        string
+	UserAgent string
+}
+
+func NewRequestInfoContext(ctx context.Context, info RequestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoKey, info)
+}
+
+func RequestInfoFromContext(ctx context.Context) (RequestInfo, bool) {
+	info, ok := ctx.Value(requestInfoKey).(RequestInfo)
+	return info, ok
+}
+
+// NewInsecureCookiesContext marks a request of local development, its cookies are set without the Secure flag
+// so that they also travel over plain HTTP.
+func NewInsecureCookiesContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, insecureCookiesKey, true)
+}
+
+// CookieSecure reports whether the cookies of the request get the Secure flag, always outside local development.
+func CookieSecure(ctx context.Context) bool {
+	insecure, _ := ctx.Value(insecureCookiesKey).(bool)
+	return !insecure
+}
@@ -1 +1,82 @@
 package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// GenerateToken returns a random URL-safe token built from n random bytes.
+func GenerateToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the SHA-256 of a token. Single-use tokens are stored hashed,
+// a database leak must not allow using them.
+func HashToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+// CertThumbprint returns the x5t#S256 value of a certificate (RFC 8705): base64url SHA-256 of its DER encoding.
+func CertThumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// RequestCertThumbprint returns the thumbprint of the verified client certificate of an HTTP request,
+// or an empty string when the request did not come over mTLS.
+func RequestCertThumbprint(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return CertThumbprint(r.TLS.VerifiedChains[0][0])
+}
+
+// NormalizePhone returns the E.164 form (+ followed by 8 to 15 digits) of a phone number, removing the spaces,
+// dashes, dots and parentheses it is commonly written with. ok is false if it is not an international number.
+func NormalizePhone(phone string) (normalized string, ok bool) {
+	var b strings.Builder
+	for i, r := range strings.TrimSpace(phone) {
+		switch {
+		case r == '+' && i == 0:
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+		default:
+			return "", false
+		}
+	}
+	normalized = b.String()
+	digits := len(normalized) - 1
+	if !strings.HasPrefix(normalized, "+") || digits < 8 || digits > 15 || normalized[1] == '0' {
+		return "", false
+	}
+	return normalized, true
+}
+
+// GenerateNumericCode returns a random code of n decimal digits, for codes that are typed in by hand.
+func GenerateNumericCode(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		// 250 is the largest multiple of 10 below 256, larger bytes would skew the distribution
+		for b[i] >= 250 {
+			if _, err := rand.Read(b[i : i+1]); err != nil {
+				return "", err
+			}
+		}
+		b[i] = '0' + b[i]%10
+	}
+	return string(b), nil
+}
@@ -0,0 +1,115 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"main/pkg/cbor"
+	"math/big"
+)
+
+// COSE algorithm identifiers of the supported credential keys.
+const (
+	AlgES256 int64 = -7
+	AlgEdDSA int64 = -8
+	AlgRS256 int64 = -257
+)
+
+// COSE key parameters (RFC 9052, RFC 9053).
+const (
+	coseKty = 1
+	coseAlg = 3
+	coseCrv = -1 // EC2 and OKP curve, RSA modulus
+	coseX   = -2 // EC2 and OKP x, RSA exponent
+	coseY   = -3
+
+	ktyOKP = 1
+	ktyEC2 = 2
+	ktyRSA = 3
+
+	crvP256    = 1
+	crvEd25519 = 6
+)
+
+// supportedAlgorithms are offered in creation options, in order of preference.
+var supportedAlgorithms = []int64{AlgES256, AlgEdDSA, AlgRS256}
+
+var errUnsupportedKey = errors.New("webauthn: unsupported credential key")
+
+// publicKey is a credential public key decoded from its COSE encoding.
+type publicKey struct {
+	alg int64
+	key crypto.PublicKey
+}
+
+// parsePublicKey decodes a COSE_Key as stored with the credential.
+func parsePublicKey(cose []byte) (publicKey, error) {
+	item, n, err := cbor.Decode(cose)
+	if err != nil {
+		return publicKey{}, err
+	}
+	m, ok := item.(map[any]any)
+	if !ok || n != len(cose) {
+		return publicKey{}, errUnsupportedKey
+	}
+	kty, _ := m[int64(coseKty)].(int64)
+	alg, _ := m[int64(coseAlg)].(int64)
+
+	switch {
+	case kty == ktyEC2 && alg == AlgES256:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		y, _ := m[int64(coseY)].([]byte)
+		if crv != crvP256 || len(x) != 32 || len(y) != 32 {
+			return publicKey{}, errUnsupportedKey
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return publicKey{}, errUnsupportedKey
+		}
+		return publicKey{alg: alg, key: key}, nil
+	case kty == ktyOKP && alg == AlgEdDSA:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		if crv != crvEd25519 || len(x) != ed25519.PublicKeySize {
+			return publicKey{}, errUnsupportedKey
+		}
+		return publicKey{alg: alg, key: ed25519.PublicKey(x)}, nil
+	case kty == ktyRSA && alg == AlgRS256:
+		modulus, _ := m[int64(coseCrv)].([]byte)
+		exponent, _ := m[int64(coseX)].([]byte)
+		e := new(big.Int).SetBytes(exponent)
+		if len(modulus) < 256 || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return publicKey{}, errUnsupportedKey
+		}
+		return publicKey{alg: alg, key: &rsa.PublicKey{N: new(big.Int).SetBytes(modulus), E: int(e.Int64())}}, nil
+	}
+	return publicKey{}, errUnsupportedKey
+}
+
+// verify checks the signature of data made with the key.
+func (k publicKey) verify(data, sig []byte) bool {
+	return verifySignature(k.alg, k.key, data, sig)
+}
+
+// verifySignature checks a signature of the COSE algorithm alg, used for credential and attestation certificate keys.
+func verifySignature(alg int64, key crypto.PublicKey, data, sig []byte) bool {
+	switch alg {
+	case AlgES256:
+		k, ok := key.(*ecdsa.PublicKey)
+		digest := sha256.Sum256(data)
+		return ok && ecdsa.VerifyASN1(k, digest[:], sig)
+	case AlgEdDSA:
+		k, ok := key.(ed25519.PublicKey)
+		return ok && ed25519.Verify(k, data, sig)
+	case AlgRS256:
+		k, ok := key.(*rsa.PublicKey)
+		digest := sha256.Sum256(data)
+		return ok && rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil
+	}
+	return false
+}
@@ -0,0 +1,403 @@
+// Package webauthn implements the relying party side of the WebAuthn registration and authentication
+// ceremonies (W3C Web Authentication Level 2) for passkeys. Attestation is not used to decide which
+// authenticators are trusted, "none" is requested and packed statements are only checked for consistency.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"main/pkg/cbor"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrInvalidResponse is returned for malformed responses and responses that fail verification.
+	ErrInvalidResponse = errors.New("webauthn: invalid response")
+
+	// ErrSignCount is returned when the signature counter did not increase, a sign of a cloned authenticator.
+	ErrSignCount = errors.New("webauthn: signature counter did not increase")
+)
+
+// Authenticator data flags and layout.
+const (
+	flagUserPresent     = 0x01
+	flagUserVerified    = 0x04
+	flagBackupEligible  = 0x08
+	flagBackupState     = 0x10
+	flagAttestedData    = 0x40
+	flagExtensionData   = 0x80
+	authDataMinLength   = 37
+	challengeLength     = 32
+	credentialIDMaxSize = 1023
+)
+
+// User verification requirements.
+const (
+	VerificationRequired  = "required"
+	VerificationPreferred = "preferred"
+)
+
+// Base64URL is binary data encoded as unpadded base64url in JSON, the encoding of WebAuthn JSON.
+type Base64URL []byte
+
+func (b Base64URL) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *Base64URL) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+	*b = decoded
+	return nil
+}
+
+// RelyingParty verifies ceremonies for one RP ID, the domain credentials are scoped to.
+type RelyingParty struct {
+	ID   string
+	Name string
+	// Origins are the allowed origins of client data, e.g. https://app.example.com
+	Origins []string
+	// UserVerification is required or preferred, required rejects authenticators that did not verify the user
+	UserVerification string
+	Timeout          time.Duration
+}
+
+// CredentialDescriptor identifies a credential in options.
+type CredentialDescriptor struct {
+	Type       string    `json:"type"`
+	ID         Base64URL `json:"id"`
+	Transports []string  `json:"transports,omitempty"`
+}
+
+// User is the account a credential is created for. ID is the user handle returned with assertions.
+type User struct {
+	ID          Base64URL `json:"id"`
+	Name        string    `json:"name"`
+	DisplayName string    `json:"displayName"`
+}
+
+// CreationOptions are passed as publicKey to navigator.credentials.create().
+type CreationOptions struct {
+	Challenge Base64URL `json:"challenge"`
+	RP        struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"rp"`
+	User             User `json:"user"`
+	PubKeyCredParams []struct {
+		Type string `json:"type"`
+		Alg  int64  `json:"alg"`
+	} `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection struct {
+		ResidentKey        string `json:"residentKey"`
+		RequireResidentKey bool   `json:"requireResidentKey"`
+		UserVerification   string `json:"userVerification"`
+	} `json:"authenticatorSelection"`
+	Attestation string `json:"attestation"`
+}
+
+// RequestOptions are passed as publicKey to navigator.credentials.get().
+type RequestOptions struct {
+	Challenge        Base64URL              `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// AttestationResponse is the response of navigator.credentials.create().
+type AttestationResponse struct {
+	ClientDataJSON    Base64URL `json:"clientDataJSON"`
+	AttestationObject Base64URL `json:"attestationObject"`
+	Transports        []string  `json:"transports"`
+}
+
+// AssertionResponse is the response of navigator.credentials.get().
+type AssertionResponse struct {
+	ClientDataJSON    Base64URL `json:"clientDataJSON"`
+	AuthenticatorData Base64URL `json:"authenticatorData"`
+	Signature         Base64URL `json:"signature"`
+	UserHandle        Base64URL `json:"userHandle"`
+}
+
+// Credential is a verified new credential, stored to verify later assertions.
+type Credential struct {
+	ID []byte
+	// PublicKey is the COSE encoded key
+	PublicKey         []byte
+	SignCount         uint32
+	AAGUID            uuid.UUID
+	AttestationFormat string
+	Transports        []string
+	// BackupEligible credentials are synced passkeys, BackupState tells whether they are currently backed up
+	BackupEligible bool
+	BackupState    bool
+}
+
+// Assertion is the result of a verified authentication.
+type Assertion struct {
+	SignCount    uint32
+	UserVerified bool
+	BackupState  bool
+}
+
+// NewChallenge returns a random challenge for one ceremony.
+func NewChallenge() ([]byte, error) {
+	challenge := make([]byte, challengeLength)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// CreationOptions returns the options of a registration ceremony. The credential is created as a discoverable
+// passkey, so it can be used without entering a username. Existing credentials of the user are excluded.
+func (rp *RelyingParty) CreationOptions(challenge []byte, user User, exclude []CredentialDescriptor) CreationOptions {
+	opts := CreationOptions{
+		Challenge:          challenge,
+		User:               user,
+		Timeout:            rp.Timeout.Milliseconds(),
+		ExcludeCredentials: exclude,
+		Attestation:        "none",
+	}
+	opts.RP.ID, opts.RP.Name = rp.ID, rp.Name
+	for _, alg := range supportedAlgorithms {
+		opts.PubKeyCredParams = append(opts.PubKeyCredParams, struct {
+			Type string `json:"type"`
+			Alg  int64  `json:"alg"`
+		}{Type: "public-key", Alg: alg})
+	}
+	opts.AuthenticatorSelection.ResidentKey = "required"
+	opts.AuthenticatorSelection.RequireResidentKey = true
+	opts.AuthenticatorSelection.UserVerification = rp.UserVerification
+	if opts.ExcludeCredentials == nil {
+		opts.ExcludeCredentials = []CredentialDescriptor{}
+	}
+	return opts
+}
+
+// RequestOptions returns the options of an authentication ceremony, an empty allow list lets the user pick any
+// discoverable credential of the RP.
+func (rp *RelyingParty) RequestOptions(challenge []byte, allow []CredentialDescriptor) RequestOptions {
+	if allow == nil {
+		allow = []CredentialDescriptor{}
+	}
+	return RequestOptions{
+		Challenge:        challenge,
+		Timeout:          rp.Timeout.Milliseconds(),
+		RPID:             rp.ID,
+		AllowCredentials: allow,
+		UserVerification: rp.UserVerification,
+	}
+}
+
+// VerifyRegistration verifies the response to CreationOptions with the given challenge and returns the new credential.
+func (rp *RelyingParty) VerifyRegistration(challenge []byte, resp AttestationResponse) (Credential, error) {
+	if err := rp.verifyClientData(resp.ClientDataJSON, "webauthn.create", challenge); err != nil {
+		return Credential{}, err
+	}
+
+	item, n, err := cbor.Decode(resp.AttestationObject)
+	if err != nil || n != len(resp.AttestationObject) {
+		return Credential{}, fmt.Errorf("%w: malformed attestation object", ErrInvalidResponse)
+	}
+	object, _ := item.(map[any]any)
+	format, _ := object["fmt"].(string)
+	statement, _ := object["attStmt"].(map[any]any)
+	rawAuthData, _ := object["authData"].([]byte)
+	if format == "" || statement == nil {
+		return Credential{}, fmt.Errorf("%w: malformed attestation object", ErrInvalidResponse)
+	}
+
+	data, err := rp.parseAuthData(rawAuthData)
+	if err != nil {
+		return Credential{}, err
+	}
+	if data.flags&flagAttestedData == 0 {
+		return Credential{}, fmt.Errorf("%w: no attested credential data", ErrInvalidResponse)
+	}
+	key, err := parsePublicKey(data.publicKey)
+	if err != nil {
+		return Credential{}, fmt.Errorf("%w: %v", ErrInvalidResponse, err)
+	}
+
+	clientDataHash := sha256.Sum256(resp.ClientDataJSON)
+	if err := verifyAttestation(format, statement, key, append(append([]byte(nil), rawAuthData...), clientDataHash[:]...)); err != nil {
+		return Credential{}, err
+	}
+
+	return Credential{
+		ID:                data.credentialID,
+		PublicKey:         data.publicKey,
+		SignCount:         data.signCount,
+		AAGUID:            data.aaguid,
+		AttestationFormat: format,
+		Transports:        resp.Transports,
+		BackupEligible:    data.flags&flagBackupEligible != 0,
+		BackupState:       data.flags&flagBackupState != 0,
+	}, nil
+}
+
+// VerifyAssertion verifies the response to RequestOptions with the given challenge against the stored credential.
+// The user handle of discoverable credentials is checked by the caller.
+func (rp *RelyingParty) VerifyAssertion(challenge []byte, resp AssertionResponse, storedKey []byte, storedCount uint32) (Assertion, error) {
+	if err := rp.verifyClientData(resp.ClientDataJSON, "webauthn.get", challenge); err != nil {
+		return Assertion{}, err
+	}
+	data, err := rp.parseAuthData(resp.AuthenticatorData)
+	if err != nil {
+		return Assertion{}, err
+	}
+	key, err := parsePublicKey(storedKey)
+	if err != nil {
+		return Assertion{}, err
+	}
+	clientDataHash := sha256.Sum256(resp.ClientDataJSON)
+	signed := append(append([]byte(nil), resp.AuthenticatorData...), clientDataHash[:]...)
+	if !key.verify(signed, resp.Signature) {
+		return Assertion{}, fmt.Errorf("%w: bad signature", ErrInvalidResponse)
+	}
+	// authenticators without a counter always report zero, synced passkeys mostly do
+	if (data.signCount != 0 || storedCount != 0) && data.signCount <= storedCount {
+		return Assertion{}, ErrSignCount
+	}
+	return Assertion{
+		SignCount:    data.signCount,
+		UserVerified: data.flags&flagUserVerified != 0,
+		BackupState:  data.flags&flagBackupState != 0,
+	}, nil
+}
+
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+func (rp *RelyingParty) verifyClientData(raw []byte, ceremony string, challenge []byte) error {
+	var cd clientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return fmt.Errorf("%w: malformed client data", ErrInvalidResponse)
+	}
+	if cd.Type != ceremony {
+		return fmt.Errorf("%w: unexpected ceremony %q", ErrInvalidResponse, cd.Type)
+	}
+	got, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(cd.Challenge, "="))
+	if err != nil || subtle.ConstantTimeCompare(got, challenge) != 1 {
+		return fmt.Errorf("%w: challenge mismatch", ErrInvalidResponse)
+	}
+	if !slices.Contains(rp.Origins, cd.Origin) || cd.CrossOrigin {
+		return fmt.Errorf("%w: origin %q not allowed", ErrInvalidResponse, cd.Origin)
+	}
+	return nil
+}
+
+type authData struct {
+	flags        byte
+	signCount    uint32
+	aaguid       uuid.UUID
+	credentialID []byte
+	publicKey    []byte
+}
+
+// parseAuthData decodes authenticator data and checks the RP ID hash and the user presence and verification flags.
+func (rp *RelyingParty) parseAuthData(raw []byte) (authData, error) {
+	if len(raw) < authDataMinLength {
+		return authData{}, fmt.Errorf("%w: short authenticator data", ErrInvalidResponse)
+	}
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(raw[:32], rpIDHash[:]) {
+		return authData{}, fmt.Errorf("%w: RP ID mismatch", ErrInvalidResponse)
+	}
+	data := authData{flags: raw[32], signCount: binary.BigEndian.Uint32(raw[33:37])}
+	if data.flags&flagUserPresent == 0 {
+		return authData{}, fmt.Errorf("%w: user not present", ErrInvalidResponse)
+	}
+	if rp.UserVerification == VerificationRequired && data.flags&flagUserVerified == 0 {
+		return authData{}, fmt.Errorf("%w: user not verified", ErrInvalidResponse)
+	}
+	// a backed up credential has to be eligible for backup
+	if data.flags&flagBackupState != 0 && data.flags&flagBackupEligible == 0 {
+		return authData{}, fmt.Errorf("%w: inconsistent backup flags", ErrInvalidResponse)
+	}
+
+	rest := raw[authDataMinLength:]
+	if data.flags&flagAttestedData != 0 {
+		if len(rest) < 18 {
+			return authData{}, fmt.Errorf("%w: short attested credential data", ErrInvalidResponse)
+		}
+		copy(data.aaguid[:], rest[:16])
+		idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if idLength > credentialIDMaxSize || idLength > len(rest) {
+			return authData{}, fmt.Errorf("%w: bad credential ID length", ErrInvalidResponse)
+		}
+		data.credentialID = append([]byte(nil), rest[:idLength]...)
+		rest = rest[idLength:]
+		_, n, err := cbor.Decode(rest)
+		if err != nil {
+			return authData{}, fmt.Errorf("%w: malformed credential public key", ErrInvalidResponse)
+		}
+		data.publicKey = append([]byte(nil), rest[:n]...)
+		rest = rest[n:]
+	}
+	if data.flags&flagExtensionData != 0 {
+		_, n, err := cbor.Decode(rest)
+		if err != nil {
+			return authData{}, fmt.Errorf("%w: malformed extensions", ErrInvalidResponse)
+		}
+		rest = rest[n:]
+	}
+	if len(rest) != 0 {
+		return authData{}, fmt.Errorf("%w: trailing authenticator data", ErrInvalidResponse)
+	}
+	return data, nil
+}
+
+// verifyAttestation checks the attestation statement. Packed statements must be signed by the credential key
+// (self attestation) or the leaf certificate, the certificate chain is not evaluated. Other formats are accepted
+// unverified, like "none", because attestation does not decide whether a passkey is accepted.
+func verifyAttestation(format string, statement map[any]any, key publicKey, signed []byte) error {
+	if format != "packed" {
+		return nil
+	}
+	alg, _ := statement["alg"].(int64)
+	sig, _ := statement["sig"].([]byte)
+	x5c, _ := statement["x5c"].([]any)
+	if len(x5c) == 0 {
+		if alg != key.alg || !key.verify(signed, sig) {
+			return fmt.Errorf("%w: bad self attestation", ErrInvalidResponse)
+		}
+		return nil
+	}
+	der, _ := x5c[0].([]byte)
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return fmt.Errorf("%w: bad attestation certificate", ErrInvalidResponse)
+	}
+	if !verifySignature(alg, cert.PublicKey, signed, sig) {
+		return fmt.Errorf("%w: bad attestation signature", ErrInvalidResponse)
+	}
+	return nil
+}